@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/lattice"
+	"hexagon_clink/pkg/lowerbound"
+)
+
+func runBound(args []string) error {
+	fs := flag.NewFlagSet("bound", flag.ExitOnError)
+	spiral := fs.Int("spiral", 0, "bound the spiral's contact graph on this many coins instead of reading files")
+	nFlag := fs.Int("n", 0, "number of vertices (required for .bin files without a header, checked otherwise)")
+	verbose := fs.Bool("v", false, "also print the configuration LP's verdict for each k tried")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink bound [-v] -spiral N")
+		fmt.Println("       hexclink bound [-v] [-n N] <graphs.g6|graphs.bin>...")
+		fmt.Println("\nPrints lower bounds on the number of arrangements needed to cover all pairs of n")
+		fmt.Println("items when every arrangement uses the same host graph on n vertices:")
+		fmt.Println("  trivial  ceil(pairs/edges)")
+		fmt.Println("  degree   ceil((n-1)/max degree): an item meets at most that many partners per arrangement")
+		fmt.Println("  lp       the configuration LP: the slot degrees an item gets over the k arrangements")
+		fmt.Println("           must add up to n-1, and each arrangement hands out the host's degrees once")
+		fmt.Printf("The lp bound implies the other two; it is tried up to k=%d (\"-\" above that).\n", lowerbound.MaxK)
+		fmt.Println("For a file, the smallest bound over its graphs bounds a solver that may pick any of them.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if (*spiral > 0) == (fs.NArg() > 0) {
+		fs.Usage()
+		return errors.New("need either -spiral N or input files")
+	}
+
+	fmt.Printf("%-24s %-12s %3s %5s  %-24s %7s %6s %3s\n", "file", "graph", "n", "edges", "degrees", "trivial", "degree", "lp")
+	show := func(file, name string, g invariants.Graph) lowerbound.Bounds {
+		b := lowerbound.Compute(g)
+		fmt.Printf("%-24s %-12s %3d %5d  %-24s %7d %6d %3s\n", file, name, b.N, b.Edges, degreeClasses(g), b.Trivial, b.MaxDegree, lpBound(b))
+		if *verbose && b.Edges > 0 {
+			degrees := make([]int, g.N)
+			for v := range degrees {
+				degrees[v] = bits.OnesCount64(g.Adj[v])
+			}
+			last := b.Config
+			if last == 0 {
+				last = lowerbound.MaxK
+			}
+			for k := max(b.Trivial, b.MaxDegree); k <= last; k++ {
+				verdict := "infeasible"
+				if lowerbound.ConfigFeasible(g.N, k, degrees) {
+					verdict = "feasible"
+				}
+				fmt.Printf("    k=%d: LP %s\n", k, verdict)
+			}
+		}
+		return b
+	}
+
+	if *spiral > 0 {
+		show("-", fmt.Sprintf("spiral(%d)", *spiral), lattice.Contact(lattice.Spiral(*spiral)))
+		return nil
+	}
+
+	// The least bounds over the graphs; an lp bound of 0 (none up to MaxK)
+	// counts as above every other.
+	leastTrivial, leastLP := 0, 0
+	graphs := 0
+	err := eachGraph(fs.Args(), *nFlag, func(path string, index int, g invariants.Graph, g6 string) error {
+		b := show(filepath.Base(path), fmt.Sprint(index), g)
+		if graphs == 0 || b.Trivial < leastTrivial {
+			leastTrivial = b.Trivial
+		}
+		if graphs == 0 || b.Config > 0 && (leastLP == 0 || b.Config < leastLP) {
+			leastLP = b.Config
+		}
+		graphs++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if graphs > 1 {
+		lp := "-"
+		if leastLP > 0 {
+			lp = fmt.Sprint(leastLP)
+		}
+		fmt.Printf("\nOver all %d graphs: trivial >= %d, lp >= %s\n", graphs, leastTrivial, lp)
+	}
+	return nil
+}
+
+// degreeClasses summarizes g's degree sequence as degree^count, largest
+// degree first, e.g. "6^1 4^2 3^4".
+func degreeClasses(g invariants.Graph) string {
+	count := make(map[int]int)
+	for v := 0; v < g.N; v++ {
+		count[bits.OnesCount64(g.Adj[v])]++
+	}
+	degrees := make([]int, 0, len(count))
+	for d := range count {
+		degrees = append(degrees, d)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(degrees)))
+	parts := make([]string, len(degrees))
+	for i, d := range degrees {
+		parts[i] = fmt.Sprintf("%d^%d", d, count[d])
+	}
+	return strings.Join(parts, " ")
+}
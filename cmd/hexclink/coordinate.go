@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"hexagon_clink/pkg/workq"
+)
+
+func runCoordinate(args []string) error {
+	fs := flag.NewFlagSet("coordinate", flag.ExitOnError)
+	listen := fs.String("listen", ":8700", "address to serve workers on")
+	units := fs.Int("units", 0, "number of work units: unit i is -shard i/<units> for the workers' command")
+	dir := fs.String("dir", "coord_results", "directory for the units' result files (units already there count as done)")
+	ext := fs.String("ext", ".txt", "extension of the result files, e.g. .g6, .bin, .csv, .g6.gz")
+	lease := fs.Duration("lease", 5*time.Minute, "a unit goes back in the queue if its worker doesn't renew within this time")
+	mergeOut := fs.String("merge", "", "once all units are done, merge their results into this file")
+	dedup := fs.Bool("dedup", false, "with -merge: drop isomorphic graphs (pipeline_nauty)")
+	linger := fs.Duration("linger", 15*time.Second, "keep serving this long after the last unit so waiting workers learn they are done")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink coordinate -units m [-ext .g6] [-merge out] [-listen :8700]")
+		fmt.Println("\nHands the shards i/m of a run to 'hexclink work' processes, requeues the units of")
+		fmt.Println("workers that fail or vanish, and collects the result files.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *units < 1 {
+		fs.Usage()
+		return errors.New("need -units")
+	}
+
+	logf := func(format string, args ...any) {
+		fmt.Printf("%s  %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+	}
+	c, err := workq.NewCoordinator(*units, *dir, *ext, *lease, logf)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	go http.Serve(ln, c.Handler())
+	s := c.Status()
+	logf("serving %d units on %s (%d already done), results in %s", s.Units, ln.Addr(), s.Done, *dir)
+
+	<-c.Done()
+	s = c.Status()
+	logf("finished: %d done, %d failed", s.Done, s.Failed)
+	time.Sleep(*linger)
+	ln.Close()
+
+	if failed := c.Failed(); len(failed) > 0 {
+		ids := make([]int, 0, len(failed))
+		for id := range failed {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			fmt.Printf("  unit %d: %s\n", id, failed[id])
+		}
+		return fmt.Errorf("%d units failed; rerun coordinate with the same -dir to retry just those", len(failed))
+	}
+	if *mergeOut == "" {
+		return nil
+	}
+	mergeArgs := []string{"-out", *mergeOut}
+	if *dedup {
+		mergeArgs = append(mergeArgs, "-dedup")
+	}
+	for i := 0; i < *units; i++ {
+		mergeArgs = append(mergeArgs, c.ResultPath(i))
+	}
+	return runMerge(mergeArgs)
+}
+
+func runWork(args []string) error {
+	fs := flag.NewFlagSet("work", flag.ExitOnError)
+	coordinator := fs.String("coordinator", "", "coordinator URL, e.g. http://host:8700")
+	host, _ := os.Hostname()
+	name := fs.String("name", fmt.Sprintf("%s:%d", host, os.Getpid()), "worker name in the coordinator's log")
+	tmp := fs.String("tmp", os.TempDir(), "directory for result files before upload")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink work -coordinator http://host:8700 -- <command> [args]")
+		fmt.Println("\nLeases units from 'hexclink coordinate' and runs the command for each, with {shard}")
+		fmt.Println("replaced by the unit's i/m and {out} by the result file to upload, until all units are done.")
+		fmt.Println("Example: hexclink work -coordinator http://h:8700 -- ./verify_penny.out -in n9.g6 -shard {shard} -out {out}")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *coordinator == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need -coordinator and a command")
+	}
+	cl := &workq.Client{URL: strings.TrimSuffix(*coordinator, "/"), Worker: *name}
+
+	units := 0
+	for {
+		l, err := cl.Lease()
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			fmt.Printf("All units done; this worker ran %d\n", units)
+			return nil
+		}
+		units++
+		out := filepath.Join(*tmp, fmt.Sprintf("workq_%d_%d%s", os.Getpid(), l.ID, l.Ext))
+		fmt.Printf("Unit %d (shard %s, attempt %d)\n", l.ID, l.Shard, l.Attempt)
+		if err := runUnit(cl, l, out, fs.Args()); err != nil {
+			fmt.Printf("Unit %d failed: %v\n", l.ID, err)
+			cl.Fail(l.ID, err.Error())
+		}
+		os.Remove(out)
+	}
+}
+
+// runUnit runs the command for lease l, renewing the lease meanwhile, and
+// uploads the result. If the lease is lost the command is killed: the unit
+// has gone to another worker.
+func runUnit(cl *workq.Client, l *workq.Lease, out string, command []string) error {
+	argv := make([]string, len(command))
+	for i, a := range command {
+		argv[i] = strings.NewReplacer("{shard}", l.Shard, "{out}", out).Replace(a)
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	lost := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(time.Duration(l.LeaseSeconds)*time.Second/3, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-finished:
+				return
+			case <-ticker.C:
+				if ok, _ := cl.Renew(l.ID); !ok {
+					close(lost)
+					cmd.Process.Kill()
+					return
+				}
+			}
+		}
+	}()
+	err := cmd.Wait()
+	close(finished)
+	select {
+	case <-lost:
+		return errors.New("lease lost")
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", argv[0], err)
+	}
+	if _, err := os.Stat(out); err != nil {
+		return fmt.Errorf("command wrote no result file (does it use {out}?): %v", err)
+	}
+	return cl.Upload(l.ID, out)
+}
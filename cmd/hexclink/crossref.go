@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/manifest"
+)
+
+func runCrossref(args []string) error {
+	fs := flag.NewFlagSet("crossref", flag.ExitOnError)
+	polyFiles := fs.String("polyiamonds", "", "comma-separated graph files from polyiamond_enum -g6 (required)")
+	yesFile := fs.String("realizable", "", "write the catalog graphs that are polyiamond edge graphs here, .g6 or .bin")
+	noFile := fs.String("not-realizable", "", "write the catalog graphs that are not polyiamond edge graphs here, .g6 or .bin")
+	nFlag := fs.Int("n", 0, "number of vertices (required for .bin files without a header)")
+	verbose := fs.Bool("v", false, "print the verdict for every catalog graph")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink crossref -polyiamonds poly.g6[,...] [-realizable file] [-not-realizable file] <penny catalog>...")
+		fmt.Println("\nSplits a penny graph catalog (.g6/.bin) into the graphs that are isomorphic to the edge graph")
+		fmt.Println("of a polyiamond, i.e. triangular lattice contact graphs, and the ones that are not. Polyiamond")
+		fmt.Println("graphs that match no catalog graph are listed: the catalog is incomplete or was built for")
+		fmt.Println("different parameters.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *polyFiles == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need -polyiamonds and at least one catalog file")
+	}
+
+	run := manifest.Start("hexclink crossref", fs)
+	if err := run.Input(strings.Split(*polyFiles, ",")...); err != nil {
+		return err
+	}
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	poly := newIsoClasses()
+	var polyG6 []string
+	polyRead := 0
+	err := eachGraph(strings.Split(*polyFiles, ","), *nFlag, func(_ string, _ int, g invariants.Graph, g6 string) error {
+		polyRead++
+		if _, added := poly.Add(g); added {
+			polyG6 = append(polyG6, g6)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{"polyiamonds": *polyFiles}
+	var yes, no *graphSink
+	if *yesFile != "" {
+		if yes, err = newGraphSink(*yesFile, "hexclink crossref -realizable", params, *nFlag); err != nil {
+			return err
+		}
+	}
+	if *noFile != "" {
+		if no, err = newGraphSink(*noFile, "hexclink crossref -not-realizable", params, *nFlag); err != nil {
+			if yes != nil {
+				yes.Close()
+			}
+			return err
+		}
+	}
+	matched := make([]bool, len(polyG6))
+	read, realizable := 0, 0
+	err = eachGraph(fs.Args(), *nFlag, func(path string, index int, g invariants.Graph, g6 string) error {
+		read++
+		sink := no
+		verdict := "not realizable"
+		if id := poly.Find(g); id >= 0 {
+			matched[id] = true
+			realizable++
+			sink = yes
+			verdict = "realizable"
+		}
+		if *verbose {
+			fmt.Printf("%s:%d %s %s\n", path, index, g6, verdict)
+		}
+		if sink == nil {
+			return nil
+		}
+		if sink.n == 0 {
+			sink.n = g.N
+		}
+		return sink.Write(g, g6)
+	})
+	for _, sink := range []*graphSink{yes, no} {
+		if sink == nil {
+			continue
+		}
+		if cerr := sink.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Catalog: %d graphs\n", read)
+	fmt.Printf("Polyiamonds: %d graphs, %d up to isomorphism\n", polyRead, len(polyG6))
+	fmt.Printf("Realizable as polyiamond edge graphs: %d\n", realizable)
+	fmt.Printf("Not realizable: %d\n", read-realizable)
+	var missing []string
+	for id, ok := range matched {
+		if !ok {
+			missing = append(missing, polyG6[id])
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Polyiamond graphs not in the catalog: %d\n", len(missing))
+		for _, g6 := range missing {
+			fmt.Printf("  %s\n", g6)
+		}
+	}
+	if *yesFile != "" {
+		fmt.Printf("Wrote %d realizable graphs -> %s\n", realizable, *yesFile)
+	}
+	if *noFile != "" {
+		fmt.Printf("Wrote %d not realizable graphs -> %s\n", read-realizable, *noFile)
+	}
+	return run.Wrote(*yesFile, *noFile)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/resultdb"
+)
+
+func runDB(args []string) error {
+	fs := flag.NewFlagSet("db", flag.ExitOnError)
+	dbPath := fs.String("db", "results.db", "SQLite result database (created if missing)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink db [-db results.db] <subcommand> [flags] [args]")
+		fmt.Println("\nSubcommands:")
+		fmt.Println("  import [-stage s] [-n N] <graphs.g6|graphs.bin>...   add graphs not yet there up to isomorphism")
+		fmt.Println("  query '<SQL>'                                        print the result as tab-separated text")
+		fmt.Println("  export -where '<cond>' [-out file]                   write matching graphs as graph6 or .bin")
+		fmt.Println("\nTables: graphs (graph6 as first added, canon its canonical form), invariants, verdicts,")
+		fmt.Println("solutions; the view graph_view joins a graph")
+		fmt.Println("with its invariants and latest penny verdict (yes; no, a filter's proof; not_found, the search")
+		fmt.Println("gave up; or NULL if never checked):")
+		fmt.Println("  hexclink db export -where 'n=12 AND edges>=24 AND penny IS NULL' -out todo.g6")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need a subcommand")
+	}
+	db, err := resultdb.Open(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sub, rest := fs.Arg(0), fs.Args()[1:]
+	switch sub {
+	case "import":
+		return dbImport(db, rest)
+	case "query":
+		if len(rest) != 1 {
+			return errors.New("query takes one SQL statement")
+		}
+		return dbQuery(db, rest[0])
+	case "export":
+		return dbExport(db, rest)
+	}
+	return fmt.Errorf("unknown db subcommand %q", sub)
+}
+
+func dbImport(db *resultdb.DB, args []string) error {
+	fs := flag.NewFlagSet("db import", flag.ExitOnError)
+	stage := fs.String("stage", "", "pipeline stage to record (default: the .bin header's, or the file name)")
+	nFlag := fs.Int("n", 0, "number of vertices (required for .bin files without a header, checked otherwise)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("import: no input files")
+	}
+	for _, path := range fs.Args() {
+		st := *stage
+		if st == "" {
+			st = path
+			if r, err := openGraphs(path, *nFlag); err == nil {
+				if r.bin != nil && r.bin.Header().Stage != "" {
+					st = r.bin.Header().Stage
+				}
+				r.Close()
+			}
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		read, added := 0, 0
+		err = eachGraph([]string{path}, *nFlag, func(_ string, index int, g invariants.Graph, g6 string) error {
+			read++
+			_, isNew, err := tx.AddGraph(g, g6, st, fmt.Sprintf("%s:%d", path, index))
+			if isNew {
+				added++
+			}
+			return err
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d graphs, %d new\n", path, read, added)
+	}
+	return nil
+}
+
+func dbQuery(db *resultdb.DB, query string) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintln(w, strings.Join(cols, "\t"))
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	fields := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			fields[i] = v.String
+			if !v.Valid {
+				fields[i] = "NULL"
+			}
+		}
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+	return rows.Err()
+}
+
+func dbExport(db *resultdb.DB, args []string) error {
+	fs := flag.NewFlagSet("db export", flag.ExitOnError)
+	where := fs.String("where", "1", "SQL condition on graph_view, e.g. 'n=12 AND edges>=24 AND penny IS NULL'")
+	outFile := fs.String("out", "", "output file, .g6 or .bin, optionally .gz/.zst (default: graph6 on stdout)")
+	fs.Parse(args)
+	run := manifest.Start("hexclink db export", fs)
+
+	rows, err := db.Query("SELECT graph6 FROM graph_view WHERE " + *where + " ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sink, err := newGraphSink(*outFile, "hexclink db export", map[string]string{"where": *where}, 0)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for rows.Next() {
+		var g6 string
+		if err = rows.Scan(&g6); err != nil {
+			break
+		}
+		var g invariants.Graph
+		if g, err = invariants.ParseGraph6(g6); err != nil {
+			break
+		}
+		if sink.n == 0 {
+			sink.n = g.N
+		}
+		if err = sink.Write(g, g6); err != nil {
+			break
+		}
+		count++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if *outFile != "" {
+		fmt.Printf("Exported %d graphs -> %s\n", count, *outFile)
+	}
+	return run.Wrote(*outFile)
+}
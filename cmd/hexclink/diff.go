@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+)
+
+// collection is one side of a diff: its graphs up to isomorphism, with one
+// graph6 string and edge count per class.
+type collection struct {
+	classes *isoClasses
+	g6      []string
+	edges   []int
+	read    int
+}
+
+func readCollection(paths []string, n int) (*collection, error) {
+	c := &collection{classes: newIsoClasses()}
+	err := eachGraph(paths, n, func(_ string, _ int, g invariants.Graph, g6 string) error {
+		c.read++
+		if _, added := c.classes.Add(g); added {
+			c.g6 = append(c.g6, g6)
+			c.edges = append(c.edges, bits.OnesCount64(g.Mask()))
+		}
+		return nil
+	})
+	return c, err
+}
+
+// missing returns the classes of c that other has no graph of.
+func (c *collection) missing(other *collection) []int {
+	var ids []int
+	for id, rep := range c.classes.reps {
+		if other.classes.Find(rep) < 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// writeClasses writes the representatives of ids to path (nothing for "").
+func (c *collection) writeClasses(path, stage string, ids []int, n int) error {
+	if path == "" {
+		return nil
+	}
+	if n == 0 && len(c.classes.reps) > 0 {
+		// An empty .bin still needs n for its header
+		n = c.classes.reps[0].N
+	}
+	sink, err := newGraphSink(path, stage, nil, n)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = sink.Write(c.classes.reps[id], c.g6[id]); err != nil {
+			break
+		}
+	}
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	nFlag := fs.Int("n", 0, "number of vertices (required for .bin files without a header)")
+	list := fs.Bool("v", false, "list the graph6 of every class found on one side only")
+	onlyA := fs.String("only-a", "", "write one graph of each class only in A here, .g6 or .bin")
+	onlyB := fs.String("only-b", "", "write one graph of each class only in B here, .g6 or .bin")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink diff [-v] [-n N] [-only-a file] [-only-b file] <A files> <B files>")
+		fmt.Println("\nCompares two graph collections (.g6/.bin, several files of one side joined with commas) up to")
+		fmt.Println("isomorphism and reports, per edge count, the classes on each side and those on only one. Fails")
+		fmt.Println("if the collections differ, so a pipeline change can be checked against an older catalog:")
+		fmt.Println("  hexclink diff n9_unique.g6 n9_canon.bin")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("need two collections")
+	}
+	pathsA, pathsB := strings.Split(fs.Arg(0), ","), strings.Split(fs.Arg(1), ",")
+	run := manifest.Start("hexclink diff", fs)
+	if err := run.Input(append(pathsA, pathsB...)...); err != nil {
+		return err
+	}
+
+	a, err := readCollection(pathsA, *nFlag)
+	if err != nil {
+		return err
+	}
+	b, err := readCollection(pathsB, *nFlag)
+	if err != nil {
+		return err
+	}
+	missA, missB := a.missing(b), b.missing(a)
+
+	// Counts per edge count: classes in A, in B, only in A, only in B
+	counts := map[int]*[4]int{}
+	count := func(edges, col int) {
+		if counts[edges] == nil {
+			counts[edges] = &[4]int{}
+		}
+		counts[edges][col]++
+	}
+	for _, e := range a.edges {
+		count(e, 0)
+	}
+	for _, e := range b.edges {
+		count(e, 1)
+	}
+	for _, id := range missA {
+		count(a.edges[id], 2)
+	}
+	for _, id := range missB {
+		count(b.edges[id], 3)
+	}
+	edges := make([]int, 0, len(counts))
+	for e := range counts {
+		edges = append(edges, e)
+	}
+	sort.Ints(edges)
+
+	fmt.Printf("A: %d graphs, %d up to isomorphism (%s)\n", a.read, len(a.g6), fs.Arg(0))
+	fmt.Printf("B: %d graphs, %d up to isomorphism (%s)\n", b.read, len(b.g6), fs.Arg(1))
+	fmt.Printf("\n%6s %10s %10s %10s %10s\n", "edges", "A", "B", "only A", "only B")
+	for _, e := range edges {
+		c := counts[e]
+		fmt.Printf("%6d %10d %10d %10d %10d\n", e, c[0], c[1], c[2], c[3])
+		events.Emit("edges", jsonl.Fields{"edges": e, "a": c[0], "b": c[1], "only_a": c[2], "only_b": c[3]})
+	}
+	fmt.Printf("%6s %10d %10d %10d %10d\n", "total", len(a.g6), len(b.g6), len(missA), len(missB))
+	events.Emit("diff", jsonl.Fields{"a": len(a.g6), "b": len(b.g6), "only_a": len(missA), "only_b": len(missB)})
+	if *list {
+		for _, side := range []struct {
+			name string
+			c    *collection
+			ids  []int
+		}{{"A", a, missA}, {"B", b, missB}} {
+			for _, id := range side.ids {
+				fmt.Printf("only %s: %s (%d edges)\n", side.name, side.c.g6[id], side.c.edges[id])
+			}
+		}
+	}
+
+	if err := a.writeClasses(*onlyA, "hexclink diff -only-a", missA, *nFlag); err != nil {
+		return err
+	}
+	if err := b.writeClasses(*onlyB, "hexclink diff -only-b", missB, *nFlag); err != nil {
+		return err
+	}
+	if err := run.Wrote(*onlyA, *onlyB); err != nil {
+		return err
+	}
+	if len(missA) > 0 || len(missB) > 0 {
+		return fmt.Errorf("the collections differ: %d classes only in A, %d only in B", len(missA), len(missB))
+	}
+	fmt.Println("\nSame graphs up to isomorphism")
+	return nil
+}
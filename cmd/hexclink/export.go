@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/fabexport"
+	"hexagon_clink/pkg/manifest"
+)
+
+func runExportLayout(args []string) error {
+	fs := flag.NewFlagSet("export-layout", flag.ExitOnError)
+	outFile := fs.String("out", "arrangement.dxf", "output file, .dxf (laser cutting) or .stl (3D printing)")
+	setFlag := fs.Int("set", 1, "export the N-th set (counting from 1) of the files")
+	arrFlag := fs.Int("arr", -1, "export only this arrangement of the set (-1: all, side by side)")
+	shape := fs.String("shape", fabexport.Disc, "piece shape: disc (coins) or hex (cells of the packing)")
+	size := fs.Float64("size", 20, "distance between neighboring centers in mm (coin diameter, hexagon width across flats)")
+	clearance := fs.Float64("clearance", 0.2, "shrink every piece by this many mm, so neighbors don't share a cut")
+	height := fs.Float64("height", 3, "STL: piece thickness in mm")
+	relief := fs.Float64("relief", 0.6, "STL: height of the raised numbers in mm (0: none)")
+	segments := fs.Int("segments", 48, "STL: sides of the polygon approximating a disc")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink export-layout [-out arr.dxf|arr.stl] [-set N] [-arr I] [-shape disc|hex] [-size mm] <arrangement file>...")
+		fmt.Println("\nWrites the arrangements of one set of arrangement files (solver -out, see pkg/arrangement) as")
+		fmt.Println("numbered pieces at the coin positions, side by side: DXF outlines with the item numbers as text,")
+		fmt.Println("or an STL solid with the numbers raised on each piece (pkg/fabexport). Hosts of layout \"graphs\"")
+		fmt.Println("sets must embed in the triangular lattice.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need at least one arrangement file")
+	}
+	ext := strings.ToLower(filepath.Ext(*outFile))
+	if ext != ".dxf" && ext != ".stl" {
+		return fmt.Errorf("-out %s: want a .dxf or .stl file", *outFile)
+	}
+	opts := fabexport.Options{Shape: *shape, Size: *size, Clearance: *clearance, Height: *height, Relief: *relief, Segments: *segments}
+	if err := opts.Check(); err != nil {
+		return err
+	}
+
+	run := manifest.Start("hexclink export-layout", fs)
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	var sets []arrangement.Set
+	for _, path := range fs.Args() {
+		s, err := arrangement.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, s...)
+	}
+	if *setFlag < 1 || *setFlag > len(sets) {
+		return fmt.Errorf("-set %d: the files hold %d sets", *setFlag, len(sets))
+	}
+	s := sets[*setFlag-1]
+	arrs := make([]int, len(s.Arrangements))
+	for i := range arrs {
+		arrs[i] = i
+	}
+	if *arrFlag >= 0 {
+		if *arrFlag >= len(s.Arrangements) {
+			return fmt.Errorf("-arr %d: the set has %d arrangements", *arrFlag, len(s.Arrangements))
+		}
+		arrs = []int{*arrFlag}
+	}
+
+	// Arrangements go left to right, two pieces apart, with their names
+	// under them
+	var pieces []fabexport.Piece
+	var notes []fabexport.Note
+	left := 0.0
+	for _, i := range arrs {
+		pos, ok := s.Positions(i)
+		if !ok {
+			return fmt.Errorf("%s: host doesn't embed in the triangular lattice", s.Name(i))
+		}
+		minX, maxX, minY := math.Inf(1), math.Inf(-1), math.Inf(1)
+		for _, p := range pos {
+			x, y := p.XY()
+			minX, maxX, minY = min(minX, x), max(maxX, x), min(minY, y)
+		}
+		for v, p := range pos {
+			x, y := p.XY()
+			pieces = append(pieces, fabexport.Piece{
+				X:     left + (x-minX)*opts.Size,
+				Y:     (y - minY) * opts.Size,
+				Label: strconv.Itoa(s.Arrangements[i][v]),
+			})
+		}
+		notes = append(notes, fabexport.Note{X: left + (maxX-minX)*opts.Size/2, Y: -opts.Size, Text: s.Name(i)})
+		left += (maxX - minX + 3) * opts.Size
+	}
+
+	f, err := os.Create(*outFile)
+	if err != nil {
+		return err
+	}
+	if ext == ".dxf" {
+		err = fabexport.DXF(f, pieces, notes, opts)
+	} else {
+		err = fabexport.STL(f, pieces, opts)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*outFile)
+		return err
+	}
+	fmt.Printf("Wrote %d pieces in %d arrangements -> %s\n", len(pieces), len(arrs), *outFile)
+	return run.Wrote(*outFile)
+}
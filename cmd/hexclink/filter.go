@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/zfile"
+)
+
+// graphSink writes the graphs filter keeps: graph6 lines, or a raw .bin file
+// when the output name says so, with stage and params in its header.
+type graphSink struct {
+	path   string
+	stage  string
+	params map[string]string
+	n      int
+
+	g6  *bufio.Writer
+	f   io.WriteCloser
+	bin *graphio.Writer
+}
+
+func newGraphSink(path, stage string, params map[string]string, n int) (*graphSink, error) {
+	s := &graphSink{path: path, stage: stage, params: params, n: n}
+	if path != "" && zfile.HasExt(path, ".bin") {
+		// Created on the first graph, once n is known
+		return s, nil
+	}
+	s.f = os.Stdout
+	if path != "" {
+		f, err := zfile.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		s.f = f
+	}
+	s.g6 = bufio.NewWriter(s.f)
+	return s, nil
+}
+
+func (s *graphSink) openBin(n int) error {
+	var err error
+	s.n = n
+	s.bin, err = graphio.Create(s.path, graphio.Header{
+		Kind:   graphio.Raw,
+		N:      n,
+		Stage:  s.stage,
+		Params: s.params,
+	})
+	return err
+}
+
+func (s *graphSink) Write(g invariants.Graph, g6 string) error {
+	if s.g6 != nil {
+		_, err := fmt.Fprintln(s.g6, g6)
+		return err
+	}
+	if s.bin == nil {
+		if err := s.openBin(g.N); err != nil {
+			return err
+		}
+	}
+	if g.N != s.n {
+		return fmt.Errorf("%s: can't mix n=%d and n=%d in one .bin file", s.path, s.n, g.N)
+	}
+	return s.bin.Write(g.Mask())
+}
+
+func (s *graphSink) Close() error {
+	if s.g6 != nil {
+		err := s.g6.Flush()
+		if s.f != os.Stdout {
+			if cerr := s.f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	if s.bin == nil {
+		// Nothing kept; an empty file still needs n for its header
+		if s.n == 0 {
+			return fmt.Errorf("%s: no graphs kept and n unknown; pass -n", s.path)
+		}
+		if err := s.openBin(s.n); err != nil {
+			return err
+		}
+	}
+	return s.bin.Close()
+}
+
+func runFilter(args []string) error {
+	fs := flag.NewFlagSet("filter", flag.ExitOnError)
+	exprFlag := fs.String("e", "", "predicate, e.g. 'edges==26 && maxdeg<=6 && girth>=3 && connected'")
+	nFlag := fs.Int("n", 0, "number of vertices (required for .bin files without a header, checked otherwise)")
+	invert := fs.Bool("v", false, "keep the graphs that do not match")
+	outFile := fs.String("out", "", "output file, .g6 or .bin, optionally .gz/.zst (default: graph6 on stdout)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink filter -e <expr> [-v] [-n N] [-out file] <graphs.g6|graphs.bin>...")
+		fmt.Println("\nKeeps the graphs for which the expression is true. Operators: || && ! == != < <= > >= + - * / %,")
+		fmt.Println("parentheses, integers, true and false. Variables:")
+		for _, v := range invariants.Variables() {
+			fmt.Printf("  %s\n", v)
+		}
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *exprFlag == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need -e and at least one input file")
+	}
+	expr, err := invariants.Compile(*exprFlag)
+	if err != nil {
+		return err
+	}
+
+	run := manifest.Start("hexclink filter", fs)
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	sink, err := newGraphSink(*outFile, "hexclink filter", map[string]string{"expr": expr.String()}, *nFlag)
+	if err != nil {
+		return err
+	}
+	read, kept := 0, 0
+	err = eachGraph(fs.Args(), *nFlag, func(_ string, _ int, g invariants.Graph, g6 string) error {
+		if sink.n == 0 {
+			sink.n = g.N
+		}
+		read++
+		inv := invariants.Compute(g)
+		match, err := expr.Match(&inv)
+		if err != nil {
+			return fmt.Errorf("%s: %v", g6, err)
+		}
+		if match == *invert {
+			return nil
+		}
+		kept++
+		return sink.Write(g, g6)
+	})
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if *outFile != "" {
+		fmt.Printf("Kept %d of %d graphs -> %s\n", kept, read, *outFile)
+	}
+	return run.Wrote(*outFile)
+}
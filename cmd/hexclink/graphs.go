@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/subiso"
+	"hexagon_clink/pkg/zfile"
+)
+
+// graphReader reads the graphs of a .g6 or .bin file, either possibly
+// compressed. Binary files without a header are read as raw files on n
+// vertices.
+type graphReader struct {
+	path string
+	n    int
+
+	// Exactly one of these is set
+	g6  *bufio.Scanner
+	bin *graphio.Reader
+
+	f    io.Closer
+	line int
+}
+
+func openGraphs(path string, n int) (*graphReader, error) {
+	r := &graphReader{path: path, n: n}
+	if zfile.HasExt(path, ".g6") {
+		f, err := zfile.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r.f = f
+		r.g6 = bufio.NewScanner(f)
+		return r, nil
+	}
+	h, err := graphio.ReadHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	var kind graphio.Kind
+	if h.Legacy {
+		if n == 0 {
+			return nil, fmt.Errorf("%s: no header; pass -n", path)
+		}
+		kind = graphio.Raw
+	}
+	bin, err := graphio.Open(path, kind, n)
+	if err != nil {
+		return nil, err
+	}
+	r.bin = bin
+	r.f = bin
+	r.n = bin.Header().N
+	return r, nil
+}
+
+// Next returns the next graph with its graph6 encoding, or io.EOF.
+func (r *graphReader) Next() (invariants.Graph, string, error) {
+	if r.bin != nil {
+		mask, err := r.bin.Next()
+		if err != nil {
+			return invariants.Graph{}, "", err
+		}
+		g := invariants.FromMask(r.n, mask)
+		return g, g.Graph6(), nil
+	}
+	for r.g6.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.g6.Text())
+		if line == "" {
+			continue
+		}
+		g, err := invariants.ParseGraph6(line)
+		if err != nil {
+			return invariants.Graph{}, "", fmt.Errorf("%s:%d: %v", r.path, r.line, err)
+		}
+		if r.n != 0 && g.N != r.n {
+			return invariants.Graph{}, "", fmt.Errorf("%s:%d: graph on %d vertices, expected %d", r.path, r.line, g.N, r.n)
+		}
+		return g, strings.TrimPrefix(line, ">>graph6<<"), nil
+	}
+	if err := r.g6.Err(); err != nil {
+		return invariants.Graph{}, "", fmt.Errorf("%s: %v", r.path, err)
+	}
+	return invariants.Graph{}, "", io.EOF
+}
+
+func (r *graphReader) Close() error {
+	return r.f.Close()
+}
+
+// eachGraph calls f for every graph in paths, with its file and position in
+// that file, stopping at the first error.
+func eachGraph(paths []string, n int, f func(path string, index int, g invariants.Graph, g6 string) error) error {
+	for _, path := range paths {
+		r, err := openGraphs(path, n)
+		if err != nil {
+			return err
+		}
+		for index := 0; ; index++ {
+			g, g6, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				err = f(path, index, g, g6)
+			}
+			if err != nil {
+				r.Close()
+				return err
+			}
+		}
+		r.Close()
+	}
+	return nil
+}
+
+// isoClasses collects graphs up to isomorphism: graphs are bucketed by
+// their signature and compared within a bucket by induced subgraph
+// matching, which for graphs of the same size is an isomorphism test.
+type isoClasses struct {
+	buckets map[string][]int
+	reps    []invariants.Graph
+}
+
+func newIsoClasses() *isoClasses {
+	return &isoClasses{buckets: make(map[string][]int)}
+}
+
+// Find returns the class of g, or -1 if no collected graph is isomorphic
+// to it.
+func (c *isoClasses) Find(g invariants.Graph) int {
+	for _, id := range c.buckets[signKey(g)] {
+		if subiso.ContainsInduced(c.reps[id].Adj, g.Adj) {
+			return id
+		}
+	}
+	return -1
+}
+
+// Add returns the class of g, starting a new one with g as its
+// representative if there is none; added says which happened.
+func (c *isoClasses) Add(g invariants.Graph) (id int, added bool) {
+	if id := c.Find(g); id >= 0 {
+		return id, false
+	}
+	id = len(c.reps)
+	c.reps = append(c.reps, g)
+	key := signKey(g)
+	c.buckets[key] = append(c.buckets[key], id)
+	return id, true
+}
+
+func signKey(g invariants.Graph) string {
+	return fmt.Sprint(subiso.Sign(g.Adj))
+}
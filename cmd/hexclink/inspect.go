@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/manifest"
+)
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	nFlag := fs.Int("n", 0, "number of vertices (required for files without a header, checked otherwise)")
+	kindFlag := fs.String("kind", "", "raw or grouped (required for files without a header, checked otherwise)")
+	scan := fs.Bool("scan", true, "read the whole file and check it against the header")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink inspect [-n N -kind raw|grouped] [-scan=false] <file.bin>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no input files")
+	}
+
+	failed := 0
+	for i, path := range fs.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := inspectFile(path, *nFlag, *kindFlag, *scan); err != nil {
+			fmt.Printf("  error:           %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed validation", failed, fs.NArg())
+	}
+	return nil
+}
+
+func inspectFile(path string, n int, kindName string, scan bool) error {
+	fmt.Printf("%s\n", path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  size:            %d bytes\n", info.Size())
+
+	h, err := graphio.ReadHeader(path)
+	if err != nil {
+		return err
+	}
+
+	var kind graphio.Kind
+	if kindName != "" {
+		if kind, err = graphio.ParseKind(kindName); err != nil {
+			return err
+		}
+	}
+	if h.Legacy {
+		fmt.Printf("  format:          legacy (no %q header)\n", graphio.Magic)
+		if n == 0 || kind == 0 {
+			return errors.New("pass -n and -kind to read a file without a header")
+		}
+	} else {
+		fmt.Printf("  format:          %s version %d\n", graphio.Magic, h.Version)
+	}
+	r, err := graphio.Open(path, kind, n)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h = r.Header()
+	fmt.Printf("  kind:            %s\n", h.Kind)
+	fmt.Printf("  n:               %d\n", h.N)
+	fmt.Printf("  bytes per graph: %d\n", h.Width)
+	// Compressed files and legacy grouped files don't record every count up
+	// front; those are printed after the scan
+	groupsKnown := h.Groups != graphio.Streamed
+	graphsKnown := h.Count != graphio.Streamed && !(h.Legacy && h.Kind == graphio.Grouped)
+	if h.Kind == graphio.Grouped {
+		if groupsKnown {
+			fmt.Printf("  groups:          %d\n", h.Groups)
+		} else {
+			fmt.Println("  groups:          streamed (counted on scan)")
+		}
+	}
+	if graphsKnown {
+		fmt.Printf("  graphs:          %d\n", h.Count)
+	} else if !h.Legacy {
+		fmt.Println("  graphs:          streamed (counted on scan)")
+	}
+	if h.Stage != "" {
+		fmt.Printf("  stage:           %s\n", h.Stage)
+	}
+	for _, k := range h.ParamKeys() {
+		fmt.Printf("  param:           %s=%s\n", k, h.Params[k])
+	}
+	if m, err := manifest.Read(path); err == nil {
+		modified := ""
+		if m.Build.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("  written by:      %s at %s, commit %.12s%s\n", m.Tool, m.Written.Format(time.RFC3339), m.Build.Commit, modified)
+		fmt.Printf("  command:         %s\n", strings.Join(m.Args, " "))
+	}
+	if !scan {
+		return nil
+	}
+
+	if h.Kind == graphio.Raw {
+		for {
+			if _, err := r.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		if !graphsKnown {
+			fmt.Printf("  graphs counted:  %d\n", r.Header().Count)
+		}
+		fmt.Println("  scan:            ok")
+		return nil
+	}
+
+	minSize, maxSize := -1, 0
+	singletons := 0
+	for {
+		group, err := r.NextGroup()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if minSize < 0 || len(group) < minSize {
+			minSize = len(group)
+		}
+		if len(group) > maxSize {
+			maxSize = len(group)
+		}
+		if len(group) == 1 {
+			singletons++
+		}
+	}
+	if !groupsKnown {
+		fmt.Printf("  groups counted:  %d\n", r.Header().Groups)
+	}
+	if !graphsKnown {
+		fmt.Printf("  graphs counted:  %d\n", r.Header().Count)
+	}
+	if minSize >= 0 {
+		fmt.Printf("  group sizes:     %d..%d (%d singletons)\n", minSize, maxSize, singletons)
+	}
+	fmt.Println("  scan:            ok")
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"hexagon_clink/pkg/coordfile"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/lattice"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/zfile"
+)
+
+func runLattice(args []string) error {
+	fs := flag.NewFlagSet("lattice", flag.ExitOnError)
+	induced := fs.Bool("induced", false, "require an induced subgraph: non-adjacent vertices may not be lattice neighbors either")
+	nFlag := fs.Int("n", 0, "number of vertices (required for .bin files without a header, checked otherwise)")
+	invert := fs.Bool("v", false, "keep the graphs that do not embed")
+	outFile := fs.String("out", "", "output file, .g6 or .bin, optionally .gz/.zst (default: graph6 on stdout)")
+	coordsFile := fs.String("coords", "", "also write the lattice positions of the embedded graphs to this file")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink lattice [-induced] [-v] [-n N] [-out file] [-coords file] <graphs.g6|graphs.bin>...")
+		fmt.Println("\nKeeps the graphs that embed in the triangular lattice with unit edges, i.e. can be laid")
+		fmt.Println("out on the hexagonal packing the solvers' spiral is cut from. With -induced, the graph")
+		fmt.Println("must be the full contact graph of its coins, as the spiral is.")
+		fmt.Println("\n-coords writes, per embedded graph, the axial coordinates (q r) of each vertex and its edges:")
+		fmt.Println("  GRAPH <index>\n  VERTICES <n>\n  <q> <r>   (one line per vertex)\n  EDGES <m>\n  <i> <j>   (one line per edge)")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need at least one input file")
+	}
+	if *invert && *coordsFile != "" {
+		return errors.New("-coords needs the embedded graphs; it can't be combined with -v")
+	}
+
+	run := manifest.Start("hexclink lattice", fs)
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	sink, err := newGraphSink(*outFile, "hexclink lattice", map[string]string{"induced": strconv.FormatBool(*induced)}, *nFlag)
+	if err != nil {
+		return err
+	}
+	var coords *bufio.Writer
+	var coordsF io.WriteCloser
+	if *coordsFile != "" {
+		if coordsF, err = zfile.Create(*coordsFile); err != nil {
+			sink.Close()
+			return err
+		}
+		coords = bufio.NewWriter(coordsF)
+	}
+	read, kept := 0, 0
+	err = eachGraph(fs.Args(), *nFlag, func(_ string, _ int, g invariants.Graph, g6 string) error {
+		if sink.n == 0 {
+			sink.n = g.N
+		}
+		read++
+		pos, ok := lattice.Embed(g, *induced)
+		if ok == *invert {
+			return nil
+		}
+		kept++
+		if coords != nil {
+			if err := writeCoords(coords, kept, g, pos); err != nil {
+				return err
+			}
+		}
+		return sink.Write(g, g6)
+	})
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if coords != nil {
+		if ferr := coords.Flush(); err == nil {
+			err = ferr
+		}
+		if cerr := coordsF.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *outFile != "" {
+		fmt.Printf("Kept %d of %d graphs -> %s\n", kept, read, *outFile)
+	}
+	return run.Wrote(*outFile, *coordsFile)
+}
+
+// writeCoords writes one embedded graph in the layout of polyiamond_enum's
+// -coords output, so the same plotting tools read both.
+func writeCoords(w io.Writer, index int, g invariants.Graph, pos []lattice.Point) error {
+	c := coordfile.Graph{Index: index, Lattice: true, Pos: make([][2]float64, g.N)}
+	for v, p := range pos {
+		c.Pos[v] = [2]float64{float64(p.Q), float64(p.R)}
+	}
+	for i := 0; i < g.N; i++ {
+		for j := i + 1; j < g.N; j++ {
+			if g.HasEdge(i, j) {
+				c.Edges = append(c.Edges, [2]int{i, j})
+			}
+		}
+	}
+	return coordfile.Write(w, c)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/lattice"
+	"hexagon_clink/pkg/lowerbound"
+	"hexagon_clink/pkg/pairs"
+	"hexagon_clink/pkg/subiso"
+)
+
+// maxAutomorphisms caps the automorphisms layout-info lists; lattice hosts
+// have at most 12.
+const maxAutomorphisms = 1 << 12
+
+// lpBound formats the configuration LP bound, "-" if there is none up to
+// lowerbound.MaxK.
+func lpBound(b lowerbound.Bounds) string {
+	if b.Config == 0 {
+		return "-"
+	}
+	return fmt.Sprint(b.Config)
+}
+
+func runLayoutInfo(args []string) error {
+	fs := flag.NewFlagSet("layout-info", flag.ExitOnError)
+	nFlag := fs.Int("n", 0, "number of items, i.e. host vertices (required for the spiral, checked for files)")
+	layout := fs.String("layout", "spiral", "spiral, or a .g6/.bin file of host graphs as given to solver_general -graphs")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink layout-info -n N [-layout spiral|hosts.g6]")
+		fmt.Println("\nPrints what the solvers need to know about a host graph before a search: its edges, degree")
+		fmt.Println("sequence (degree^count), automorphism group order, diameter, and the lower bounds on the")
+		fmt.Println("number of arrangements covering all pairs of n items (as hexclink bound: trivial is")
+		fmt.Println("ceil(pairs/edges), degree ceil((n-1)/max degree), lp the configuration LP). The spiral is")
+		fmt.Println("the contact graph of the first n coins in the solvers' slot order. A file gets one line per")
+		fmt.Println("graph. Automorphisms are counted up to 4096 and the diameter of a disconnected host is \"-\".")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("layout-info takes no arguments; name a file with -layout")
+	}
+	n := *nFlag
+	if *layout == "spiral" {
+		if n < 1 || n > invariants.MaxN {
+			return fmt.Errorf("-n: the spiral needs 1 to %d coins", invariants.MaxN)
+		}
+	} else if n < 0 || n > invariants.MaxN {
+		return fmt.Errorf("-n: at most %d", invariants.MaxN)
+	}
+
+	fmt.Printf("%-24s %3s %5s %5s  %-24s %5s %4s %7s %6s %3s\n", "host", "n", "edges", "pairs", "degrees", "auts", "diam", "trivial", "degree", "lp")
+	show := func(name string, g invariants.Graph) {
+		inv := invariants.Compute(g)
+		auts := fmt.Sprintf(">%d", maxAutomorphisms)
+		if perms, ok := subiso.Automorphisms(g.Adj, maxAutomorphisms); ok {
+			auts = fmt.Sprint(len(perms))
+		}
+		diam := "-"
+		if inv.Connected {
+			diam = fmt.Sprint(inv.Diameter)
+		}
+		b := lowerbound.Compute(g)
+		fmt.Printf("%-24s %3d %5d %5d  %-24s %5s %4s %7d %6d %3s\n", name, g.N, inv.Edges, pairs.Count(g.N),
+			degreeClasses(g), auts, diam, b.Trivial, b.MaxDegree, lpBound(b))
+	}
+
+	if *layout == "spiral" {
+		show(fmt.Sprintf("spiral(%d)", n), lattice.Contact(lattice.Spiral(n)))
+		return nil
+	}
+	hosts := 0
+	err := eachGraph([]string{*layout}, n, func(path string, index int, g invariants.Graph, g6 string) error {
+		show(fmt.Sprintf("%s:%d", filepath.Base(path), index), g)
+		hosts++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if hosts == 0 {
+		return fmt.Errorf("%s: no graphs", *layout)
+	}
+	return nil
+}
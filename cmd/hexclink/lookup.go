@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/nauty"
+	"hexagon_clink/pkg/wlcanon"
+)
+
+// catalog answers which position of a graph file holds a graph isomorphic
+// to a query.
+type catalog struct {
+	find func(g invariants.Graph) int
+	size int
+}
+
+// openCatalog prepares path for lookups. A sorted canonicalize output is
+// searched in place: the query is brought into the same canonical form and
+// found by binary search. Any other file is read into isomorphism classes
+// first.
+func openCatalog(path string, n int) (*catalog, func() error, error) {
+	c := &catalog{}
+	if h, err := graphio.ReadHeader(path); err == nil && !h.Legacy && h.Kind == graphio.Raw && h.Params["order"] == "sorted" {
+		canon, err := canonForm(h.Params["canon"])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		r, err := graphio.Open(path, graphio.Raw, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		// A mapped file comes as one view of the mapping; a compressed one
+		// is read into memory
+		var chunks []graphio.Graphs
+		for {
+			chunk, err := r.NextChunk(math.MaxInt)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return nil, nil, err
+			}
+			chunks = append(chunks, chunk)
+		}
+		var at func(i int) uint64
+		if len(chunks) == 1 {
+			at, c.size = chunks[0].At, chunks[0].Len()
+		} else {
+			var all []uint64
+			for _, chunk := range chunks {
+				all = chunk.AppendTo(all)
+			}
+			at, c.size = func(i int) uint64 { return all[i] }, len(all)
+		}
+		catalogN := r.Header().N
+		c.find = func(g invariants.Graph) int {
+			if g.N != catalogN {
+				return -1
+			}
+			form := canon(g.N, g.Mask())
+			i := sort.Search(c.size, func(i int) bool { return at(i) >= form })
+			if i < c.size && at(i) == form {
+				return i
+			}
+			return -1
+		}
+		return c, r.Close, nil
+	}
+
+	fmt.Printf("%s is not a sorted canonicalize output; reading it into isomorphism classes\n", path)
+	classes := newIsoClasses()
+	var first []int
+	err := eachGraph([]string{path}, n, func(_ string, index int, g invariants.Graph, _ string) error {
+		if _, added := classes.Add(g); added {
+			first = append(first, index)
+		}
+		c.size++
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	c.find = func(g invariants.Graph) int {
+		if id := classes.Find(g); id >= 0 {
+			return first[id]
+		}
+		return -1
+	}
+	return c, func() error { return nil }, nil
+}
+
+// canonForm returns the canonical form function of a canonicalize backend.
+func canonForm(backend string) (func(n int, mask uint64) uint64, error) {
+	if backend == "" {
+		backend = "brute"
+	}
+	if err := nauty.CheckBackend(backend); err != nil {
+		return nil, err
+	}
+	switch backend {
+	case "wl":
+		return wlcanon.Canonical, nil
+	case "nauty":
+		return nauty.Canonical, nil
+	}
+	return wlcanon.Minimum, nil
+}
+
+// parseQuery reads a graph given as graph6 or as an edge list such as
+// "0-1,1-2,2-0", on n vertices (0: one more than the largest named).
+func parseQuery(s string, n int) (invariants.Graph, error) {
+	if !strings.Contains(s, "-") {
+		return invariants.ParseGraph6(s)
+	}
+	var edges [][2]int
+	for _, e := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		a, b, ok := strings.Cut(e, "-")
+		i, err1 := strconv.Atoi(a)
+		j, err2 := strconv.Atoi(b)
+		if !ok || err1 != nil || err2 != nil || i < 0 || j < 0 || i == j {
+			return invariants.Graph{}, fmt.Errorf("edge %q: want i-j with distinct vertices", e)
+		}
+		edges = append(edges, [2]int{i, j})
+		n = max(n, i+1, j+1)
+	}
+	if n > invariants.MaxN {
+		return invariants.Graph{}, fmt.Errorf("%d vertices, at most %d supported", n, invariants.MaxN)
+	}
+	g := invariants.New(n)
+	for _, e := range edges {
+		g.AddEdge(e[0], e[1])
+	}
+	return g, nil
+}
+
+func runLookup(args []string) error {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	catalogFile := fs.String("catalog", "", "the enumerated set: canonicalize's .bin output, or any .g6/.bin file (required)")
+	queryFile := fs.String("queries", "", "also look up every graph of this .g6/.bin file, e.g. solver host graphs")
+	nFlag := fs.Int("n", 0, "number of vertices (for .bin files without a header, and edge lists with isolated top vertices)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink lookup -catalog file [-queries file] [-n N] [graph6 | edge list]...")
+		fmt.Println("\nReports for each query graph whether a graph isomorphic to it is in the catalog, and at which")
+		fmt.Println("index (counting from 0). Queries are graph6 strings or edge lists such as 0-1,1-2,2-0. The")
+		fmt.Println("sorted .bin that canonicalize writes is searched in place: the query is brought into the")
+		fmt.Println("catalog's canonical form (brute, wl or nauty, as its header says) and found by binary search.")
+		fmt.Println("Other files are read into isomorphism classes first, which takes a pass over the catalog.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *catalogFile == "" || fs.NArg() == 0 && *queryFile == "" {
+		fs.Usage()
+		return errors.New("need -catalog and at least one query")
+	}
+
+	c, closeCatalog, err := openCatalog(*catalogFile, *nFlag)
+	if err != nil {
+		return err
+	}
+	defer closeCatalog()
+
+	queries, found := 0, 0
+	report := func(name string, g invariants.Graph) {
+		queries++
+		if i := c.find(g); i >= 0 {
+			found++
+			fmt.Printf("%s: index %d\n", name, i)
+			events.Emit("match", jsonl.Fields{"query": name, "found": true, "index": i})
+		} else {
+			fmt.Printf("%s: not found\n", name)
+			events.Emit("match", jsonl.Fields{"query": name, "found": false})
+		}
+	}
+	for _, arg := range fs.Args() {
+		g, err := parseQuery(arg, *nFlag)
+		if err != nil {
+			return fmt.Errorf("query %q: %v", arg, err)
+		}
+		report(arg, g)
+	}
+	if *queryFile != "" {
+		err := eachGraph([]string{*queryFile}, *nFlag, func(path string, index int, g invariants.Graph, g6 string) error {
+			report(fmt.Sprintf("%s:%d %s", path, index, g6), g)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Found %d of %d queries among the %d graphs of %s\n", found, queries, c.size, *catalogFile)
+	return nil
+}
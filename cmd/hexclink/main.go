@@ -0,0 +1,92 @@
+// hexclink collects the small utilities that work on the project's data
+// files.
+//
+// Usage:
+//
+//	hexclink [-json] <command> [flags] [args]
+//
+// Run "hexclink help" for the list of commands. With -json the command's
+// events go to stdout as JSON lines (see pkg/jsonl) and its text to stderr.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"hexagon_clink/pkg/jsonl"
+)
+
+// events carries the running command's -json events; nil (discarding them)
+// without -json
+var events *jsonl.Emitter
+
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"annotate":           {"write a CSV/JSON table of invariants for every graph", runAnnotate},
+	"bound":              {"prove lower bounds on the number of arrangements for host graphs", runBound},
+	"db":                 {"store graphs, invariants, verdicts and solutions in SQLite and query them", runDB},
+	"coordinate":         {"hand the shards of a run to workers over HTTP and collect the results", runCoordinate},
+	"crossref":           {"split a penny graph catalog by whether each graph is a polyiamond edge graph", runCrossref},
+	"export-layout":      {"write an arrangement set as DXF/STL pieces for laser cutting or 3D printing", runExportLayout},
+	"filter":             {"keep the graphs matching an invariant expression", runFilter},
+	"lattice":            {"keep the graphs that embed in the triangular lattice", runLattice},
+	"layout-info":        {"print a host graph's edges, degrees, automorphisms, diameter and covering bounds", runLayoutInfo},
+	"diff":               {"compare two graph collections up to isomorphism, by edge count", runDiff},
+	"lookup":             {"find graphs in an enumerated catalog up to isomorphism, with their index", runLookup},
+	"inspect":            {"print the header and layout of binary graph files", runInspect},
+	"merge":              {"combine the outputs of runs split with -shard i/m", runMerge},
+	"selftest":           {"run the enumeration pipeline for small n in memory and check its counts", runSelftest},
+	"plot":               {"draw the graphs of coordinate files in a PNG/SVG grid", runPlot},
+	"render-arrangement": {"draw the arrangements of solver -out files on their coins (SVG)", runRenderArrangement},
+	"verify":             {"check that the arrangement sets of solver -out files cover all pairs", runVerify},
+	"work":               {"run the units a coordinator hands out", runWork},
+}
+
+func usage() {
+	fmt.Println("Usage: hexclink [-json] <command> [flags] [args]")
+	fmt.Println("\nCommands:")
+	names := make([]string, 0, len(commands))
+	width := 0
+	for name := range commands {
+		names = append(names, name)
+		width = max(width, len(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-*s %s\n", width, name, commands[name].summary)
+	}
+	fmt.Println("\nRun 'hexclink <command> -h' for the flags of a command. With -json the command writes")
+	fmt.Println("events (start, the command's own, result) as JSON lines on stdout; text goes to stderr.")
+}
+
+func main() {
+	args := os.Args[1:]
+	jsonOut := len(args) > 0 && (args[0] == "-json" || args[0] == "--json")
+	if jsonOut {
+		args = args[1:]
+	}
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		return
+	}
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Printf("Error: unknown command %q\n\n", name)
+		usage()
+		os.Exit(1)
+	}
+	events = jsonl.Start("hexclink", jsonOut)
+	events.Emit("start", jsonl.Fields{"command": name, "args": args[1:]})
+	if err := cmd.run(args[1:]); err != nil {
+		events.Emit("result", jsonl.Fields{"command": name, "ok": false, "error": err.Error()})
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	events.Emit("result", jsonl.Fields{"command": name, "ok": true})
+}
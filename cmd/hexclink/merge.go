@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/zfile"
+)
+
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	outFile := fs.String("out", "", "output file, optionally .gz/.zst (default: stdout; required for .bin)")
+	dedup := fs.Bool("dedup", false, "graphs: keep one graph per isomorphism class (for pipeline_nauty shards)")
+	nFlag := fs.Int("n", 0, "graphs: number of vertices (required for .bin files without a header)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink merge [-dedup] [-out file] <shard outputs>...")
+		fmt.Println("\nCombines the outputs of a run split with -shard i/m:")
+		fmt.Println("  .g6/.bin   graphs, concatenated (-dedup drops isomorphic copies, needed for pipeline_nauty)")
+		fmt.Println("  .csv       tables such as find_fourth -stats-out, with the header once")
+		fmt.Println("  other      text such as find_fourth -unsat-log, concatenated")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need at least one input file")
+	}
+
+	run := manifest.Start("hexclink merge", fs)
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	kind := mergeKind(fs.Arg(0))
+	for _, path := range fs.Args()[1:] {
+		if mergeKind(path) != kind {
+			return fmt.Errorf("%s and %s are different kinds of file", fs.Arg(0), path)
+		}
+	}
+	if kind == "graphs" {
+		if err := mergeGraphs(fs.Args(), *outFile, *nFlag, *dedup); err != nil {
+			return err
+		}
+		return run.Wrote(*outFile)
+	}
+	if *dedup {
+		return errors.New("-dedup only applies to graph files")
+	}
+
+	var w io.WriteCloser = os.Stdout
+	if *outFile != "" {
+		f, err := zfile.Create(*outFile)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	var err error
+	if kind == "csv" {
+		err = mergeCSV(fs.Args(), bw)
+	} else {
+		err = mergeText(fs.Args(), bw)
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if w != os.Stdout {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return run.Wrote(*outFile)
+}
+
+func mergeKind(path string) string {
+	switch {
+	case zfile.HasExt(path, ".g6"), zfile.HasExt(path, ".bin"):
+		return "graphs"
+	case zfile.HasExt(path, ".csv"):
+		return "csv"
+	}
+	return "text"
+}
+
+// mergeGraphs concatenates graph files. With dedup a graph is dropped if
+// an earlier one is isomorphic to it.
+func mergeGraphs(paths []string, out string, n int, dedup bool) error {
+	params := map[string]string{"inputs": strconv.Itoa(len(paths))}
+	if dedup {
+		params["dedup"] = "iso"
+	}
+	sink, err := newGraphSink(out, "hexclink merge", params, n)
+	if err != nil {
+		return err
+	}
+	classes := newIsoClasses()
+	read, kept := 0, 0
+	err = eachGraph(paths, n, func(_ string, _ int, g invariants.Graph, g6 string) error {
+		if sink.n == 0 {
+			sink.n = g.N
+		}
+		read++
+		if dedup {
+			if _, added := classes.Add(g); !added {
+				return nil
+			}
+		}
+		kept++
+		return sink.Write(g, g6)
+	})
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		fmt.Printf("Merged %d files: %d graphs, %d written -> %s\n", len(paths), read, kept, out)
+	}
+	return nil
+}
+
+// mergeCSV writes the rows of every file under the first file's header;
+// the other files must have the same header.
+func mergeCSV(paths []string, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	var header []string
+	for _, path := range paths {
+		f, err := zfile.Open(path)
+		if err != nil {
+			return err
+		}
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			if line == 1 {
+				if header == nil {
+					header = rec
+				} else if !slices.Equal(rec, header) {
+					f.Close()
+					return fmt.Errorf("%s: header differs from %s", path, paths[0])
+				} else {
+					continue
+				}
+			}
+			cw.Write(rec)
+		}
+		f.Close()
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func mergeText(paths []string, w io.Writer) error {
+	for _, path := range paths {
+		f, err := zfile.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"hexagon_clink/pkg/coordfile"
+	"hexagon_clink/pkg/gridplot"
+	"hexagon_clink/pkg/manifest"
+)
+
+func runPlot(args []string) error {
+	fs := flag.NewFlagSet("plot", flag.ExitOnError)
+	outFile := fs.String("out", "graphs.png", "output image, .png or .svg")
+	cols := fs.Int("cols", 6, "graphs per row")
+	cell := fs.Int("cell", 200, "cell size in pixels")
+	coins := fs.Bool("coins", false, "draw each vertex as a coin of diameter 1 (the penny packing)")
+	labels := fs.Bool("labels", true, "write each graph's index in its cell")
+	limit := fs.Int("limit", 0, "plot only the first N graphs (0: all)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink plot [-out grid.png|grid.svg] [-cols N] [-cell px] [-coins] <coords.txt>...")
+		fmt.Println("\nDraws the graphs of coordinate files (polyiamond_enum -coords, hexclink lattice -coords,")
+		fmt.Println("verify_penny -coords) in a grid, all at the same scale, so unit edges have the same")
+		fmt.Println("length in every cell.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need at least one coordinate file")
+	}
+	ext := strings.ToLower(filepath.Ext(*outFile))
+	if ext != ".png" && ext != ".svg" {
+		return fmt.Errorf("-out %s: want a .png or .svg file", *outFile)
+	}
+
+	run := manifest.Start("hexclink plot", fs)
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	var graphs []coordfile.Graph
+	for _, path := range fs.Args() {
+		gs, err := coordfile.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		graphs = append(graphs, gs...)
+	}
+	total := len(graphs)
+	if *limit > 0 && total > *limit {
+		graphs = graphs[:*limit]
+	}
+
+	f, err := os.Create(*outFile)
+	if err != nil {
+		return err
+	}
+	opts := gridplot.Options{Cols: *cols, Cell: *cell, Coins: *coins, Labels: *labels}
+	if ext == ".svg" {
+		err = gridplot.SVG(f, graphs, opts)
+	} else {
+		err = gridplot.PNG(f, graphs, opts)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*outFile)
+		return err
+	}
+	if len(graphs) < total {
+		fmt.Printf("Plotted %d of %d graphs -> %s\n", len(graphs), total, *outFile)
+	} else {
+		fmt.Printf("Plotted %d graphs -> %s\n", len(graphs), *outFile)
+	}
+	return run.Wrote(*outFile)
+}
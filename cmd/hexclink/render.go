@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/manifest"
+)
+
+func runRenderArrangement(args []string) error {
+	fs := flag.NewFlagSet("render-arrangement", flag.ExitOnError)
+	outFile := fs.String("out", "arrangement.svg", "output SVG file")
+	coin := fs.Int("coin", 40, "coin diameter in pixels")
+	setFlag := fs.Int("set", 0, "draw only the N-th set (counting from 1) of the files (0: all, one row each)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink render-arrangement [-out arr.svg] [-coin px] [-set N] <arrangement file>...")
+		fmt.Println("\nDraws the sets of arrangement files (solver -out, see pkg/arrangement) as SVG: the arrangements")
+		fmt.Println("of a set side by side on their coins, labeled with the items, with the contacts that cover a")
+		fmt.Println("new pair thick in the arrangement's color and repeated ones thin and gray. Hosts of layout")
+		fmt.Println("\"graphs\" sets must embed in the triangular lattice.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("need at least one arrangement file")
+	}
+	if *coin < 8 {
+		return fmt.Errorf("-coin %d: need at least 8 pixels", *coin)
+	}
+
+	run := manifest.Start("hexclink render-arrangement", fs)
+	if err := run.Input(fs.Args()...); err != nil {
+		return err
+	}
+
+	var sets []arrangement.Set
+	for _, path := range fs.Args() {
+		s, err := arrangement.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, s...)
+	}
+	if *setFlag < 0 || *setFlag > len(sets) {
+		return fmt.Errorf("-set %d: the files hold %d sets", *setFlag, len(sets))
+	}
+	if *setFlag > 0 {
+		sets = sets[*setFlag-1 : *setFlag]
+	}
+
+	f, err := os.Create(*outFile)
+	if err != nil {
+		return err
+	}
+	err = arrangement.WriteSVG(f, sets, *coin)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*outFile)
+		return err
+	}
+	fmt.Printf("Drew %d sets -> %s\n", len(sets), *outFile)
+	return run.Wrote(*outFile)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/bits"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/lattice"
+	"hexagon_clink/pkg/oeis"
+	"hexagon_clink/pkg/pennyembed"
+	"hexagon_clink/pkg/pennyfilter"
+	"hexagon_clink/pkg/polyiamond"
+	"hexagon_clink/pkg/subiso"
+	"hexagon_clink/pkg/wlcanon"
+)
+
+// goldenCounts are the counts of one n, by edge count from n-1.
+type goldenCounts struct {
+	candidates []int64  // connected graphs passing the default filters
+	penny      []int64  // of those, the penny graphs
+	maximal    []int64  // penny graphs in no penny graph with more edges
+	misses     []string // penny graphs the search rejects, graph6
+	wrong      []string // candidates the search embeds that aren't penny graphs, graph6
+}
+
+// golden holds the true counts, as far as they were verified, and the
+// known errors of the embedding search on the labeling canonicalize writes.
+// The candidates are what all_in_one -orderly reports. Every candidate was
+// put to a separate, stronger search (exact unit edges, every non-edge
+// pushed to 1.02 apart, 300 starts): a drawing with every non-edge more
+// than 1.0001 apart counts it as a penny graph, which proves it; failing
+// counts it as none. For n=7 that is proved by hand for the two the search
+// accepts under some labeling, FF]e? and FP]u? on their minimum-bitmask
+// labels: their drawings force two non-adjacent vertices to distance 1.
+// For n=8 the best drawings found of the four wrong ones bring two
+// non-adjacent coins within 0.0014 of touching, which isn't a proof.
+//
+// The search only asks for edges within 0.001 of 1, so it accepts such
+// forced contacts (wrong), and on this labeling it misses penny graphs that
+// the stronger search draws (misses). The penny and maximal counts are
+// checked with the search's result corrected by the two lists, and how far
+// the search itself is off is reported as an expected failure; a candidate
+// that changes sides fails the check until the lists are updated.
+var golden = map[int]goldenCounts{
+	6: {
+		candidates: []int64{6, 13, 17, 11, 5},
+		penny:      []int64{6, 13, 16, 8, 3},
+		maximal:    []int64{0, 0, 0, 0, 3},
+	},
+	7: {
+		candidates: []int64{10, 32, 58, 65, 43, 15, 3},
+		penny:      []int64{10, 32, 53, 44, 19, 3, 1},
+		maximal:    []int64{0, 0, 0, 0, 0, 3, 1},
+		misses:     []string{"F_mKO"},
+	},
+	8: {
+		candidates: []int64{21, 85, 207, 331, 365, 263, 111, 27, 2, 1},
+		penny:      []int64{21, 85, 186, 216, 148, 49, 9, 1},
+		maximal:    []int64{0, 0, 0, 0, 0, 0, 8, 1},
+		misses: []string{
+			"G?F?VC",                               // 8 edges
+			"GBcXQ?", "GLCPHO", "GKNGGG", "GBJOAS", // 9 edges
+			"GdCYcO", "GBoGTo", "G`KISW", "GC?zLG", "GWCyKG", "G??~eC", "GAbwOc", "GKBUAS", "GOU?Ls", "GRI?FK", // 10 edges
+			"GzLPA?", "GX?Zd_", "GS?A~c", // 11 edges
+		},
+		wrong: []string{
+			"GgMRg_", "GTEbGS", // 11 edges
+			"Gb]CaW", "GwHKak", // 12 edges
+		},
+	},
+}
+
+// selftestReport prints one line per check and counts the failures and
+// the expected failures.
+type selftestReport struct {
+	checks, failed, xfailed int
+}
+
+// compare checks got against want, both from edge count (or size) start.
+func (r *selftestReport) compare(name string, start int, got, want []int64) {
+	r.checks++
+	got = trimZeros(got)
+	if slices.Equal(got, want) {
+		fmt.Printf("  ok    %s (from %d): %s\n", name, start, oeis.Format(got))
+		events.Emit("check", jsonl.Fields{"name": name, "status": "ok", "from": start, "got": got})
+		return
+	}
+	r.failed++
+	fmt.Printf("  FAIL  %s (from %d)\n", name, start)
+	events.Emit("check", jsonl.Fields{"name": name, "status": "fail", "from": start, "got": got, "want": want})
+	printDiff(start, got, want)
+}
+
+// expectDiff is a check known to fail: got should differ from want, and
+// the difference is printed. It passes if they are equal.
+func (r *selftestReport) expectDiff(name string, start int, got, want []int64) {
+	r.checks++
+	got = trimZeros(got)
+	if slices.Equal(got, want) {
+		fmt.Printf("  ok    %s (from %d): %s\n", name, start, oeis.Format(got))
+		events.Emit("check", jsonl.Fields{"name": name, "status": "ok", "from": start, "got": got})
+		return
+	}
+	r.xfailed++
+	fmt.Printf("  xfail %s (from %d)\n", name, start)
+	events.Emit("check", jsonl.Fields{"name": name, "status": "xfail", "from": start, "got": got, "want": want})
+	printDiff(start, got, want)
+}
+
+// trimZeros drops trailing zeros, edge counts past the largest graph.
+func trimZeros(counts []int64) []int64 {
+	for len(counts) > 0 && counts[len(counts)-1] == 0 {
+		counts = counts[:len(counts)-1]
+	}
+	return counts
+}
+
+// printDiff prints the entries of got and want, from start, that differ.
+func printDiff(start int, got, want []int64) {
+	for i := 0; i < max(len(got), len(want)); i++ {
+		var g, w int64
+		if i < len(got) {
+			g = got[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if g != w {
+			fmt.Printf("          %d: got %d, want %d\n", start+i, g, w)
+		}
+	}
+}
+
+// check records a yes/no check.
+func (r *selftestReport) check(name string, ok bool, detail string) {
+	r.checks++
+	status := "ok  "
+	if !ok {
+		r.failed++
+		status = "FAIL"
+	}
+	fmt.Printf("  %s  %s: %s\n", status, name, detail)
+	events.Emit("check", jsonl.Fields{"name": name, "status": strings.ToLower(strings.TrimSpace(status)), "detail": detail})
+}
+
+// pennyCensus is the pipeline's result for one n.
+type pennyCensus struct {
+	n          int
+	candidates []uint64 // wlcanon.Canonical forms, as canonicalize writes them
+	embedded   []uint64 // candidates the embedding search draws
+	penny      []uint64 // embedded corrected by the search's known errors (correct)
+	maximal    []uint64 // penny graphs in no penny graph with more edges
+}
+
+// byEdges counts graphs by edge count from n-1.
+func (c *pennyCensus) byEdges(graphs []uint64) []int64 {
+	var counts []int64
+	for _, g := range graphs {
+		i := bits.OnesCount64(g) - (c.n - 1)
+		for len(counts) <= i {
+			counts = append(counts, 0)
+		}
+		counts[i]++
+	}
+	return counts
+}
+
+// enumeratePenny runs the enumeration pipeline for n in memory: every
+// graph passing the hereditary filters is grown one edge at a time from the
+// empty graph, deduplicated by canonical form (wlcanon), and the connected
+// ones passing the whole default chain are the candidates; they are
+// relabeled to canonicalize's form and embedded (pennyembed).
+func enumeratePenny(n, workers int) (*pennyCensus, error) {
+	filters, err := pennyfilter.Parse(pennyfilter.Default)
+	if err != nil {
+		return nil, err
+	}
+	hereditary := filters.Hereditary()
+	pairs := n * (n - 1) / 2
+
+	c := &pennyCensus{n: n}
+	level := []uint64{0}
+	for len(level) > 0 {
+		for _, g := range level {
+			if filters.Accept(n, g) && invariants.FromMask(n, g).Connected() {
+				c.candidates = append(c.candidates, wlcanon.Canonical(n, g))
+			}
+		}
+		seen := make(map[uint64]bool)
+		var next []uint64
+		for _, g := range level {
+			for k := 0; k < pairs; k++ {
+				h := g | 1<<k
+				if h == g || !hereditary.Accept(n, h) {
+					continue
+				}
+				if canon := wlcanon.Canonical(n, h); !seen[canon] {
+					seen[canon] = true
+					next = append(next, canon)
+				}
+			}
+		}
+		slices.Sort(next)
+		level = next
+	}
+	slices.Sort(c.candidates)
+
+	embeds := make([]bool, len(c.candidates))
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				edges := edgeList(invariants.FromMask(n, c.candidates[i]))
+				_, embeds[i] = pennyembed.Embed(n, edges, pennyembed.Models["penny"])
+			}
+		}()
+	}
+	for i := range c.candidates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for i, g := range c.candidates {
+		if embeds[i] {
+			c.embedded = append(c.embedded, g)
+		}
+	}
+	return c, nil
+}
+
+// maximalGraphs returns the graphs on n vertices that are in no other of
+// them with more edges (subiso).
+func maximalGraphs(n int, graphs []uint64) []uint64 {
+	// As filter_maximal: larger graphs first, each compared with the
+	// maximal graphs found so far
+	type graph struct {
+		mask uint64
+		adj  []uint64
+		sig  subiso.Signature
+	}
+	var sorted []graph
+	for _, g := range graphs {
+		adj := invariants.FromMask(n, g).Adj
+		sorted = append(sorted, graph{g, adj, subiso.Sign(adj)})
+	}
+	slices.SortStableFunc(sorted, func(a, b graph) int {
+		return bits.OnesCount64(b.mask) - bits.OnesCount64(a.mask)
+	})
+	var maximal []graph
+	var masks []uint64
+	for _, g := range sorted {
+		contained := false
+		for _, m := range maximal {
+			if g.sig.FitsIn(m.sig) && subiso.Contains(m.adj, g.adj) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			maximal = append(maximal, g)
+			masks = append(masks, g.mask)
+		}
+	}
+	return masks
+}
+
+// edgeList returns the edges (i < j) of g.
+func edgeList(g invariants.Graph) [][2]int {
+	var edges [][2]int
+	for u := 0; u < g.N; u++ {
+		for v := u + 1; v < g.N; v++ {
+			if g.HasEdge(u, v) {
+				edges = append(edges, [2]int{u, v})
+			}
+		}
+	}
+	return edges
+}
+
+// correct sets c.penny to the graphs the search embedded, with the known
+// misses added and the known wrong ones removed, and c.maximal to the
+// maximal ones among them. Each miss must be a candidate the search
+// rejects, and each wrong one a candidate it embeds; one that changed sides
+// fails the check.
+func (r *selftestReport) correct(c *pennyCensus, want goldenCounts) {
+	var problems []string
+	lookup := func(g6 string) (uint64, bool) {
+		g, err := invariants.ParseGraph6(g6)
+		if err != nil || g.N != c.n {
+			problems = append(problems, fmt.Sprintf("%s: not a graph on %d vertices", g6, c.n))
+			return 0, false
+		}
+		m := wlcanon.Canonical(c.n, g.Mask())
+		if _, ok := slices.BinarySearch(c.candidates, m); !ok {
+			problems = append(problems, g6+": not a candidate")
+			return 0, false
+		}
+		return m, true
+	}
+	penny := slices.Clone(c.embedded)
+	for _, g6 := range want.misses {
+		if m, ok := lookup(g6); !ok {
+			continue
+		} else if _, found := slices.BinarySearch(c.embedded, m); found {
+			problems = append(problems, g6+": a miss the search embeds now, drop it from misses")
+		} else {
+			penny = append(penny, m)
+		}
+	}
+	wrong := make(map[uint64]bool)
+	for _, g6 := range want.wrong {
+		if m, ok := lookup(g6); !ok {
+			continue
+		} else if _, found := slices.BinarySearch(c.embedded, m); !found {
+			problems = append(problems, g6+": wrong, but the search rejects it now, drop it from wrong")
+		} else {
+			wrong[m] = true
+		}
+	}
+	c.penny = slices.DeleteFunc(penny, func(m uint64) bool { return wrong[m] })
+	slices.Sort(c.penny)
+	c.maximal = maximalGraphs(c.n, c.penny)
+
+	r.check(fmt.Sprintf("n=%d known errors of the search", c.n), len(problems) == 0,
+		fmt.Sprintf("%d misses, %d wrongly embedded", len(want.misses), len(want.wrong)))
+	for _, p := range problems {
+		fmt.Println("          " + p)
+	}
+}
+
+func runSelftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	nList := fs.String("n", "6,7,8", "vertex counts to enumerate penny graphs for (those with bundled counts: 6, 7, 8)")
+	cells := fs.Int("cells", 12, "enumerate polyiamonds up to this many triangles")
+	workers := fs.Int("workers", 0, "workers for the embedding search (default: NumCPU)")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink selftest [-n 6,7,8] [-cells N] [-workers N]")
+		fmt.Println("\nRuns the enumeration pipeline in memory for small n and compares its counts with the bundled")
+		fmt.Println("true counts, so a regression in any shared primitive shows up as a changed count:")
+		fmt.Println("  candidates  connected graphs passing the default filters (pkg/pennyfilter), grown edge by")
+		fmt.Println("              edge and deduplicated by canonical form (pkg/wlcanon), by edge count")
+		fmt.Println("  errors      the known errors of the embedding search (pkg/pennyembed) on canonicalize's")
+		fmt.Println("              labeling: penny graphs it misses and forced contacts it accepts")
+		fmt.Println("  penny       candidates the search finds a drawing for, corrected by the known errors")
+		fmt.Println("  maximal     penny graphs in no penny graph with more edges (pkg/subiso)")
+		fmt.Println("  embedded    the search's own counts against the penny counts, an expected failure (xfail)")
+		fmt.Println("              while it has known errors")
+		fmt.Println("  harborth    the most edges of a penny graph, against OEIS A047932")
+		fmt.Println("  polyiamonds shapes by triangles (pkg/polyiamond) against OEIS A000577, and the contact graph")
+		fmt.Println("              of coins on the vertices of each (pkg/lattice) found among the penny graphs")
+		fmt.Println("Each mismatch is printed with the counts that differ; the command fails if any check but")
+		fmt.Println("an expected failure does.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("selftest takes no arguments")
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+	var ns []int
+	for _, s := range strings.Split(*nList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("-n: %q is not a number", s)
+		}
+		if _, ok := golden[n]; !ok {
+			return fmt.Errorf("-n: no bundled counts for n=%d (have 6, 7, 8)", n)
+		}
+		ns = append(ns, n)
+	}
+
+	start := time.Now()
+	r := &selftestReport{}
+	censuses := make(map[int]*pennyCensus)
+	for _, n := range ns {
+		t := time.Now()
+		c, err := enumeratePenny(n, *workers)
+		if err != nil {
+			return err
+		}
+		censuses[n] = c
+		want := golden[n]
+		r.correct(c, want)
+		fmt.Printf("n=%d: %d candidates, %d embedded by the search, %d penny graphs, %d maximal (%.1fs)\n",
+			n, len(c.candidates), len(c.embedded), len(c.penny), len(c.maximal), time.Since(t).Seconds())
+		r.compare(fmt.Sprintf("n=%d candidates by edges", n), n-1, c.byEdges(c.candidates), want.candidates)
+		r.compare(fmt.Sprintf("n=%d penny graphs by edges", n), n-1, c.byEdges(c.penny), want.penny)
+		r.compare(fmt.Sprintf("n=%d maximal penny graphs by edges", n), n-1, c.byEdges(c.maximal), want.maximal)
+		r.expectDiff(fmt.Sprintf("n=%d embedded by the search, against the penny graphs", n), n-1, c.byEdges(c.embedded), want.penny)
+		most := 0
+		for _, g := range c.penny {
+			most = max(most, bits.OnesCount64(g))
+		}
+		harborth, _ := oeis.Lookup("A047932")
+		limit, _ := harborth.Term(n)
+		r.check(fmt.Sprintf("n=%d most penny edges", n), int64(most) == limit,
+			fmt.Sprintf("%d, A047932(%d) = %d", most, n, limit))
+	}
+
+	t := time.Now()
+	var shapes []int64
+	realized, total := 0, 0
+	polyiamond.Enumerate(*cells, *workers, nil, polyiamond.NewCache(1<<16), func(size int, level []polyiamond.Polyiamond) {
+		shapes = append(shapes, int64(len(level)))
+		for _, p := range level {
+			// Coins on the shape's vertices: neighboring points may touch
+			// without a triangle edge between them
+			vertices, _ := polyiamond.Graph(p)
+			c := censuses[len(vertices)]
+			if c == nil {
+				continue
+			}
+			pts := make([]lattice.Point, len(vertices))
+			for i, v := range vertices {
+				pts[i] = lattice.Point{Q: v.A, R: v.B}
+			}
+			g := lattice.Contact(pts)
+			total++
+			if _, found := slices.BinarySearch(c.penny, wlcanon.Canonical(g.N, g.Mask())); found {
+				realized++
+			}
+		}
+	})
+	fmt.Printf("Polyiamonds with 1..%d triangles (%.1fs)\n", *cells, time.Since(t).Seconds())
+	lines, ok := oeis.Report("A000577", 1, shapes)
+	r.check("polyiamonds by triangles", ok, oeis.Format(shapes))
+	if !ok {
+		for _, l := range lines {
+			fmt.Println("          " + l)
+		}
+	}
+	r.check("polyiamond coin graphs among the penny graphs", realized == total,
+		fmt.Sprintf("%d of %d", realized, total))
+
+	fmt.Printf("\n%d of %d checks passed, %d expected failures, in %.1fs\n", r.checks-r.failed-r.xfailed, r.checks, r.xfailed, time.Since(start).Seconds())
+	events.Emit("checks", jsonl.Fields{"checks": r.checks, "passed": r.checks - r.failed - r.xfailed, "failed": r.failed, "xfailed": r.xfailed})
+	if r.failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", r.failed, r.checks)
+	}
+	return nil
+}
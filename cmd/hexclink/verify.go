@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/coverage"
+	"hexagon_clink/pkg/jsonl"
+)
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "print each arrangement's edges, new pairs and overlap")
+	fs.Usage = func() {
+		fmt.Println("Usage: hexclink verify [-v] <arrangement file>...")
+		fmt.Println("\nChecks that every set of arrangements in the files (written by the solvers with -out, see")
+		fmt.Println("pkg/arrangement) covers all pairs of its items, and lists the pairs a set leaves uncovered.")
+		fmt.Println("Fails if any set does not cover all pairs.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("need at least one arrangement file")
+	}
+
+	total, failed := 0, 0
+	for _, path := range fs.Args() {
+		sets, err := arrangement.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for i, s := range sets {
+			total++
+			uncovered := s.Uncovered()
+			verdict := "covers all pairs"
+			if len(uncovered) > 0 {
+				failed++
+				verdict = fmt.Sprintf("leaves %d of %d pairs uncovered", len(uncovered), s.N*(s.N-1)/2)
+			}
+			fmt.Printf("%s:%d n=%d k=%d %s", path, i+1, s.N, len(s.Arrangements), s.Layout)
+			if s.Tool != "" {
+				fmt.Printf(" (%s)", s.Tool)
+			}
+			fmt.Printf(": %s\n", verdict)
+			events.Emit("set", jsonl.Fields{"file": path, "set": i + 1, "n": s.N, "k": len(s.Arrangements), "layout": s.Layout,
+				"covers": len(uncovered) == 0, "uncovered": len(uncovered)})
+			if len(uncovered) > 0 {
+				fmt.Printf("  uncovered: %v\n", uncovered)
+			}
+			if *verbose {
+				arrs := make([]coverage.Arrangement, len(s.Arrangements))
+				for j, items := range s.Arrangements {
+					arrs[j] = coverage.Arrangement{Name: s.Name(j), Items: items, Edges: s.Edges(j)}
+				}
+				for _, st := range coverage.New(s.N, arrs).Stats() {
+					fmt.Printf("  %s: %d edges, %d new pairs, %d overlap, %d only here\n", st.Name, st.Edges, st.New, st.Overlap, st.Only)
+				}
+			}
+		}
+	}
+	fmt.Printf("Verified %d sets: %d cover all pairs, %d do not\n", total, total-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sets do not cover all pairs", failed, total)
+	}
+	return nil
+}
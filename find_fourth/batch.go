@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/bits"
+	"sort"
+	"strings"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/pairs"
+)
+
+// batchEvaluator computes the uncovered pairs of many candidates at once
+// and refutes those failing the necessary conditions of estimateDifficulty
+// (more uncovered pairs than arr3 has edges, or item demands no matching
+// to slots can meet), so only the rest go to the SAT solver.
+//
+// The layout is meant for wide, branch-free loops: items are stored by
+// column (for each slot of arr1 and arr2, the items of all candidates of
+// the batch side by side), so each host edge is applied to the whole batch
+// in one pass over two columns, and the covered pairs are bitmaps, one row
+// of 64-bit words per candidate, whose uncovered pairs are the zero bits.
+type batchEvaluator struct {
+	n, numEdges, words int
+	edges              []Edge
+	hostDeg            []int        // host degrees, sorted descending
+	pairs              *cover.Table // pair indices of the items, and the items of each index
+	pairIdx            []int32      // pair index of items a, b at a*n+b
+	base               pairs.Set    // arr0's coverage; pad bits past the last pair are set
+
+	cols    [][]uint16 // cols[arr*n+slot][c]: item at slot of arr1 (arr 0) or arr2 (arr 1) in candidate c
+	covered []uint64   // candidate c's bitmap at c*words
+}
+
+// evaluated is a candidate after batch evaluation.
+type evaluated struct {
+	cand       candidate
+	ok         bool // false for a malformed line
+	arr1, arr2 []int
+	uncovered  [][2]int
+	refuted    bool // a necessary condition fails: UNSAT without SAT
+}
+
+func newBatchEvaluator(n, numEdges int, edges []Edge, fullAdj [][]int, pairTable *cover.Table) *batchEvaluator {
+	e := &batchEvaluator{
+		n:        n,
+		numEdges: numEdges,
+		edges:    edges,
+		hostDeg:  make([]int, n),
+		pairs:    pairTable,
+		pairIdx:  make([]int32, n*n),
+		base:     pairs.NewSet(pairTable.Len()),
+		cols:     make([][]uint16, 2*n),
+	}
+	e.words = len(e.base)
+	for s := range fullAdj {
+		e.hostDeg[s] = len(fullAdj[s])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(e.hostDeg)))
+	for a := 0; a < n; a++ {
+		for b := 0; b < n; b++ {
+			if a != b {
+				e.pairIdx[a*n+b] = int32(pairTable.Index(a, b))
+			}
+		}
+	}
+	for _, ed := range edges {
+		e.base.Add(pairTable.Index(ed.a, ed.b))
+	}
+	for pi := pairTable.Len(); pi < e.words*64; pi++ {
+		e.base.Add(pi)
+	}
+	return e
+}
+
+// evaluate parses and evaluates a batch of candidates. The evaluator's
+// buffers are reused from batch to batch, so each worker needs its own.
+func (e *batchEvaluator) evaluate(cands []candidate) []evaluated {
+	n, size := e.n, len(cands)
+	for i := range e.cols {
+		if cap(e.cols[i]) < size {
+			e.cols[i] = make([]uint16, size)
+		}
+		e.cols[i] = e.cols[i][:size]
+	}
+	if cap(e.covered) < size*e.words {
+		e.covered = make([]uint64, size*e.words)
+	}
+	e.covered = e.covered[:size*e.words]
+
+	out := make([]evaluated, size)
+	for c, cand := range cands {
+		out[c].cand = cand
+		arr1, arr2, ok := parseLine(cand.line, n)
+		out[c].ok, out[c].arr1, out[c].arr2 = ok, arr1, arr2
+		for slot := 0; slot < n; slot++ {
+			// a malformed candidate gets item 0 everywhere and is ignored below
+			var i1, i2 int
+			if ok {
+				i1, i2 = arr1[slot], arr2[slot]
+			}
+			e.cols[slot][c], e.cols[n+slot][c] = uint16(i1), uint16(i2)
+		}
+		copy(e.covered[c*e.words:], e.base)
+	}
+
+	for arr := 0; arr < 2; arr++ {
+		for _, ed := range e.edges {
+			ca, cb := e.cols[arr*n+ed.a], e.cols[arr*n+ed.b]
+			for c := range ca {
+				pi := e.pairIdx[int(ca[c])*n+int(cb[c])]
+				e.covered[c*e.words+int(pi>>6)] |= 1 << (pi & 63)
+			}
+		}
+	}
+
+	for c := range out {
+		if !out[c].ok {
+			continue
+		}
+		row := e.covered[c*e.words : (c+1)*e.words]
+		count := 0
+		for _, w := range row {
+			count += bits.OnesCount64(^w)
+		}
+		uncovered := make([][2]int, 0, count)
+		for wi, w := range row {
+			for free := ^w; free != 0; free &= free - 1 {
+				uncovered = append(uncovered, e.pairs.Pair(wi*64+bits.TrailingZeros64(free)))
+			}
+		}
+		out[c].uncovered = uncovered
+		out[c].refuted = estimateDifficulty(n, e.numEdges, e.hostDeg, uncovered).infeasible
+	}
+	return out
+}
+
+// parseLine splits an "arr1;arr2" line into two arrangements of n items,
+// each a permutation of 0..n-1.
+func parseLine(line string, n int) (arr1, arr2 []int, ok bool) {
+	parts := strings.Split(line, ";")
+	if len(parts) != 2 {
+		return nil, nil, false
+	}
+	arr1 = parseArray(parts[0])
+	arr2 = parseArray(parts[1])
+	if cover.CheckPermutation(arr1, n) != nil || cover.CheckPermutation(arr2, n) != nil {
+		return nil, nil, false
+	}
+	return arr1, arr2, true
+}
@@ -2,4 +2,9 @@ module find_fourth
 
 go 1.21
 
-require github.com/crillab/gophersat v1.4.0
+require (
+	github.com/crillab/gophersat v1.4.0
+	hexagon_clink v0.0.0
+)
+
+replace hexagon_clink => ../
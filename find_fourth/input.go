@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/shard"
+	"hexagon_clink/pkg/zfile"
+)
+
+// readCandidates streams the candidate lines of in, which is a directory
+// of item_*.txt files (read in name order), a single file (.gz/.zst are
+// decompressed; a .json/.jsonl arrangement file gives a line per set, see
+// candidateLine), or "-" for stdin. emit gets each line of shard sh in turn
+// (line k of the whole input, counting from 0, belongs to shard k mod m,
+// and keeps index k) and returns false to stop early; at most limit lines
+// are emitted if limit > 0. It returns how many lines were emitted and
+// whether the whole input was read.
+func readCandidates(in string, limit int, sh shard.Shard, emit func(candidate) bool) (int, bool, error) {
+	var files []string
+	if in == "-" {
+		files = []string{"-"}
+	} else if st, err := os.Stat(in); err != nil {
+		return 0, false, err
+	} else if st.IsDir() {
+		files, _ = filepath.Glob(filepath.Join(in, "item_*.txt"))
+		if len(files) == 0 {
+			return 0, false, fmt.Errorf("%s: no item_*.txt files", in)
+		}
+	} else {
+		files = []string{in}
+	}
+
+	read, index := 0, 0
+	// add passes line k of the input on if shard sh owns it; it returns
+	// false to stop
+	add := func(source, line string) bool {
+		if limit > 0 && read == limit {
+			return false
+		}
+		index++
+		if !sh.Owns(uint64(index - 1)) {
+			return true
+		}
+		read++
+		return emit(candidate{index: index - 1, source: source, line: line})
+	}
+	for _, file := range files {
+		if file != "-" && isArrangementFile(file) {
+			sets, err := arrangement.ReadFile(file)
+			if err != nil {
+				return read, false, err
+			}
+			for i, s := range sets {
+				line, err := candidateLine(s)
+				if err != nil {
+					return read, false, fmt.Errorf("%s: set %d: %v", file, i+1, err)
+				}
+				if !add(fmt.Sprintf("%s:%d", file, i+1), line) {
+					return read, false, nil
+				}
+			}
+			continue
+		}
+		var r io.ReadCloser = os.Stdin
+		name := "stdin"
+		if file != "-" {
+			f, err := zfile.Open(file)
+			if err != nil {
+				return read, false, err
+			}
+			r, name = f, file
+		}
+		scanner := bufio.NewScanner(r)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			if !add(fmt.Sprintf("%s:%d", name, lineNo), scanner.Text()) {
+				r.Close()
+				return read, false, nil
+			}
+		}
+		err := scanner.Err()
+		if cerr := r.Close(); err == nil && file != "-" {
+			err = cerr
+		}
+		if err != nil {
+			return read, false, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return read, true, nil
+}
+
+// isArrangementFile reports whether path is an arrangement file
+// (pkg/arrangement) rather than candidate lines.
+func isArrangementFile(path string) bool {
+	return zfile.HasExt(path, ".json") || zfile.HasExt(path, ".jsonl")
+}
+
+// candidateLine turns the first three arrangements of a spiral set into an
+// "arr1;arr2" candidate line, relabeling the items so that arr0 becomes
+// the identity (which changes no pair's coverage).
+func candidateLine(s arrangement.Set) (string, error) {
+	if s.Layout != arrangement.Spiral {
+		return "", fmt.Errorf("layout %s, need %s", s.Layout, arrangement.Spiral)
+	}
+	if len(s.Arrangements) < 3 {
+		return "", fmt.Errorf("%d arrangements, need at least 3", len(s.Arrangements))
+	}
+	label := make([]int, s.N)
+	for slot, item := range s.Arrangements[0] {
+		label[item] = slot
+	}
+	var parts [2]string
+	for i, arr := range s.Arrangements[1:3] {
+		items := make([]string, len(arr))
+		for slot, item := range arr {
+			items[slot] = strconv.Itoa(label[item])
+		}
+		parts[i] = strings.Join(items, ",")
+	}
+	return parts[0] + ";" + parts[1], nil
+}
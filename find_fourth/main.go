@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/csv"
 	"flag"
 	"fmt"
 	"os"
@@ -14,31 +16,134 @@ import (
 	"time"
 
 	"github.com/crillab/gophersat/solver"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/budget"
+	"hexagon_clink/pkg/config"
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/encoding"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/pairs"
+	"hexagon_clink/pkg/shard"
 )
 
 type candidate struct {
-	index int
-	line  string
+	index  int
+	source string // file:line it was read from
+	line   string
 }
 
 type result struct {
 	index          int
+	source         string
 	found          bool
+	invalid        bool // malformed line, not checked
+	refuted        bool // UNSAT by the -batch necessary conditions, without SAT
 	uncoveredCount int
+	uncovered      [][2]int
 	elapsed        time.Duration
+	stats          solver.Stats
 	arr1, arr2     []int
-	arr3           []int
+	added          [][]int  // arr3 (and arr4 with -find 2); with -maxsat also for UNSAT: the best ones
+	missed         [][2]int // -maxsat: the uncovered pairs the best ones leave
+	size           formulaSize
+}
+
+// arrangements returns arr0 (the identity), arr1, arr2 and the ones found.
+func (r result) arrangements(n int) [][]int {
+	return append([][]int{identityArr(n), r.arr1, r.arr2}, r.added...)
+}
+
+// formulaSize is the number of variables and clauses of a SAT formula.
+type formulaSize struct {
+	vars, clauses int
 }
 
 func main() {
 	nFlag := flag.Int("n", 17, "Number of items")
-	inDir := flag.String("in", "output_17", "Input directory")
+	inDir := flag.String("in", "output_17", "Input: directory of item_*.txt files, one candidate file (.gz/.zst ok), an arrangement file (.json/.jsonl: arrangements 0-2 of each set), or - for stdin")
+	outPath := flag.String("out", "", "Write the solutions (arr0-arr3, or arr0-arr4 with -find 2) to this arrangement file (pkg/arrangement)")
 	samples := flag.Int("samples", 0, "Number of samples to check (0 = all)")
 	workers := flag.Int("workers", 0, "Number of workers (0 = NumCPU)")
+	unsatLog := flag.String("unsat-log", "", "Append every UNSAT candidate (source, arrangements, uncovered pairs) to this file")
+	proofDir := flag.String("proof-dir", "", "Write cand_<index>.cnf and a DRAT proof cand_<index>.drat for every UNSAT candidate")
+	statsOut := flag.String("stats-out", "", "Write per-candidate SAT statistics to this CSV file")
+	rank := flag.Bool("rank", false, "Check candidates in order of estimated difficulty (cheap feasibility tests first)")
+	keepGoing := flag.Bool("keep-going", false, "Check all candidates instead of stopping at the first solution")
+	jsonOut := flag.Bool("json", false, "Write events (start, progress, solutions, result) as JSON lines on stdout; text goes to stderr")
+	shardSpec := flag.String("shard", "", "Only check shard i/m of the candidates (every m-th input line from the i-th); combine the logs with hexclink merge")
+	timeout := flag.Duration("timeout", 0, "Stop after this long, finish the candidates being solved and print the summary so far (0 = no limit)")
+	find := flag.Int("find", 1, "Number of arrangements to find after arr1 and arr2, jointly in one formula: 1 (arr3, k=4) or 2 (arr3 and arr4, k=5)")
+	maxSAT := flag.Bool("maxsat", false, "For candidates no arr3 completes, find the arr3 covering the most of their uncovered pairs (MaxSAT) and report the best one")
+	symmetry := flag.Bool("symmetry", false, "Add clauses that break the symmetries of arr3 (twin items, host automorphisms), for faster UNSAT answers")
+	amo := flag.String("amo", encoding.Pairwise, "At-most-one encoding of the permutation constraints: pairwise, sequential, commander or product")
+	multiplicityPath := flag.String("multiplicity", "", "Cover each pair as often as this file asks (solver_general -multiplicity: \"a b t\" lines and \"default t\"), for candidates dumped with it; not with -batch or -maxsat")
+	batchSize := flag.Int("batch", 0, "Evaluate coverage for this many candidates at once and send only those passing the edge-count and degree-matching tests to SAT (0 = off)")
+	maxNodes := flag.Int64("max-nodes", 0, "Stop after this many candidates have been checked (0 = no limit)")
+	configPath := flag.String("config", "", "take flags from this TOML file (flags on the command line win); see pkg/config")
+	profile := flag.String("profile", "", "with -config: also apply this [profile] section of the file")
+	logLevel := flag.String("log-level", "normal", "progress lines to print: quiet, normal, verbose or debug (see pkg/logging)")
+	logFile := flag.String("log-file", "", "also append the progress lines to this file")
 	flag.Parse()
+	settings, err := config.Load(*configPath, *profile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := encoding.CheckAMO(*amo); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *find < 1 || *find > 2 {
+		fmt.Printf("Error: -find %d: want 1 or 2\n", *find)
+		os.Exit(1)
+	}
+	if *find > 1 && (*batchSize > 0 || *rank) {
+		fmt.Printf("Error: the -batch and -rank tests assume one arrangement is left; they can't be combined with -find 2\n")
+		os.Exit(1)
+	}
+	if *maxSAT && (*proofDir != "" || *batchSize > 0) {
+		fmt.Printf("Error: -maxsat optimizes every candidate; it can't be combined with -proof-dir or -batch\n")
+		os.Exit(1)
+	}
+	if *multiplicityPath != "" && (*batchSize > 0 || *maxSAT) {
+		fmt.Printf("Error: the -batch evaluator and -maxsat count uncovered pairs, not coverings; they can't be combined with -multiplicity\n")
+		os.Exit(1)
+	}
+	if *batchSize > 0 && *proofDir != "" {
+		fmt.Printf("Error: -batch refutes candidates without the SAT solver, so they would have no DRAT proof; drop -batch or -proof-dir\n")
+		os.Exit(1)
+	}
+	events := jsonl.Start("find_fourth", *jsonOut)
+	logger, err := logging.Start(*logLevel, *logFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+	if *configPath != "" {
+		fmt.Println(config.Describe(*configPath, *profile, settings))
+	}
+	sh, err := shard.Parse(*shardSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	run := manifest.Start("find_fourth", nil)
+	if err := run.Input(*inDir, *configPath, *multiplicityPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// what a complete run rules out, for the summary
+	scope := *inDir
+	if !sh.IsAll() {
+		scope = fmt.Sprintf("shard %s of %s", sh, *inDir)
+	}
 
 	n := *nFlag
-	numPairs := n * (n - 1) / 2
+	numPairs := pairs.Count(n)
 	numWorkers := *workers
 	if numWorkers == 0 {
 		numWorkers = runtime.NumCPU()
@@ -47,18 +152,20 @@ func main() {
 	edges, numEdges := buildSpiral(n)
 	fmt.Printf("n=%d, edges=%d, pairs=%d\n", n, numEdges, numPairs)
 	fmt.Printf("Using %d workers\n", numWorkers)
+	if !sh.IsAll() {
+		fmt.Printf("Shard: %s\n", sh)
+	}
+	events.Emit("start", jsonl.Fields{"n": n, "edges": numEdges, "pairs": numPairs, "workers": numWorkers, "in": *inDir, "shard": sh.String()})
 
-	// Build pair index lookup
-	pairTable := make([][]int, n)
-	for a := 0; a < n; a++ {
-		pairTable[a] = make([]int, n)
-		for b := 0; b < n; b++ {
-			if a < b {
-				pairTable[a][b] = a*n - a*(a+1)/2 + (b - a - 1)
-			} else if b < a {
-				pairTable[a][b] = b*n - b*(b+1)/2 + (a - b - 1)
-			}
+	pairTable := cover.NewTable(n)
+	var need cover.Multiplicity
+	if *multiplicityPath != "" {
+		need, err = cover.ReadMultiplicity(*multiplicityPath, n)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Printf("Multiplicity from %s: %d coverings, each pair at most %d times\n", *multiplicityPath, need.Total(), need.Max())
 	}
 
 	// Full adjacency
@@ -71,125 +178,249 @@ func main() {
 		fullAdj[e.b] = append(fullAdj[e.b], e.a)
 	}
 
-	// Adjacency matrix
-	adjMatrix := make([][]bool, n)
-	for s := 0; s < n; s++ {
-		adjMatrix[s] = make([]bool, n)
-	}
-	for _, e := range edges {
-		adjMatrix[e.a][e.b] = true
-		adjMatrix[e.b][e.a] = true
+	// Both directions of each edge, for the coverage clauses
+	hostEdges := make([][2]int, len(edges))
+	for i, e := range edges {
+		hostEdges[i] = [2]int{e.a, e.b}
 	}
+	arcs := encoding.Arcs(hostEdges)
 
-	// arr0 = identity coverage
-	covered0 := make([]bool, numPairs)
-	for _, e := range edges {
-		covered0[pairTable[e.a][e.b]] = true
+	var sym *hostSymmetry
+	if *symmetry {
+		if n > 64 {
+			fmt.Printf("Error: -symmetry needs n <= 64\n")
+			os.Exit(1)
+		}
+		sym = newHostSymmetry(n, arcs)
+		fmt.Printf("Symmetry breaking: twin items, %d host automorphisms\n", len(sym.autos))
 	}
 
-	// Load lines from input files
-	var allLines []string
-	files, _ := filepath.Glob(filepath.Join(*inDir, "item_*.txt"))
-	for _, file := range files {
-		f, err := os.Open(file)
+	var unsatOut *bufio.Writer
+	if *unsatLog != "" {
+		f, err := os.OpenFile(*unsatLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
-			continue
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			allLines = append(allLines, scanner.Text())
+		defer f.Close()
+		unsatOut = bufio.NewWriter(f)
+		defer unsatOut.Flush()
+		fmt.Fprintf(unsatOut, "# n=%d in=%s shard=%s started %s\n", n, *inDir, sh, time.Now().Format(time.RFC3339))
+	}
+	var statsCSV *csv.Writer
+	if *statsOut != "" {
+		f, err := os.Create(*statsOut)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		statsCSV = csv.NewWriter(f)
+		defer statsCSV.Flush()
+		statsCSV.Write([]string{"index", "source", "uncovered", "result", "conflicts", "decisions", "restarts", "learned", "solve_ms", "vars", "clauses"})
+	}
+	if *proofDir != "" {
+		if err := os.MkdirAll(*proofDir, 0755); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		f.Close()
 	}
 
-	fmt.Printf("Loaded %d candidates\n", len(allLines))
+	// parseCandidate reads an "arr1;arr2" line and finds the pairs that
+	// arr0, arr1 and arr2 leave uncovered. With -multiplicity those are the
+	// pairs short of coverings, and short[i] is how many uncoveredPairs[i]
+	// lacks; otherwise short is nil.
+	parseCandidate := func(line string) (arr1, arr2 []int, uncoveredPairs [][2]int, short []int, ok bool) {
+		arr1, arr2, ok = parseLine(line, n)
+		if !ok {
+			return nil, nil, nil, nil, false
+		}
 
-	checkCount := *samples
-	if checkCount == 0 || checkCount > len(allLines) {
-		checkCount = len(allLines)
+		arrs := [][]int{identityArr(n), arr1, arr2}
+		hosts := [][][2]int{hostEdges, hostEdges, hostEdges}
+		if need == nil {
+			return arr1, arr2, pairTable.Uncovered(pairTable.Coverage(arrs, hosts)), nil, true
+		}
+		counts := pairTable.Counts(arrs, hosts)
+		for pi, t := range need {
+			if t > counts[pi] {
+				uncoveredPairs = append(uncoveredPairs, pairTable.Pair(pi))
+				short = append(short, t-counts[pi])
+			}
+		}
+		return arr1, arr2, uncoveredPairs, short, true
 	}
 
-	fmt.Printf("Checking %d candidates with SAT solver...\n\n", checkCount)
+	// -rank needs every candidate up front; otherwise they are streamed to
+	// the workers through the bounded work channel
+	var ranked []candidate
+	readAll := false
+	if *rank {
+		var err error
+		_, readAll, err = readCandidates(*inDir, *samples, sh, func(c candidate) bool {
+			ranked = append(ranked, c)
+			return true
+		})
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded %d candidates\n", len(ranked))
+		rankCandidates(ranked, n, numEdges, fullAdj, func(line string) ([][2]int, bool) {
+			_, _, uncovered, _, ok := parseCandidate(line)
+			return uncovered, ok
+		})
+		fmt.Printf("Checking %d candidates with SAT solver...\n\n", len(ranked))
+	} else {
+		fmt.Printf("Streaming candidates from %s to the SAT solver...\n\n", *inDir)
+	}
 
 	work := make(chan candidate, 1000)
 	results := make(chan result, 100)
 
+	// Workers and readers stop at the first solution (unless -keep-going)
+	// or when the budget runs out; a candidate being solved is finished
 	var stopFlag int32
+	b := budget.New(*timeout, *maxNodes)
+	stopping := func() bool {
+		return atomic.LoadInt32(&stopFlag) != 0 || b.Stopped()
+	}
 
-	var wg sync.WaitGroup
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for cand := range work {
-				if atomic.LoadInt32(&stopFlag) != 0 {
-					continue
-				}
-
-				parts := strings.Split(cand.line, ";")
-				if len(parts) != 2 {
-					continue
+	// check solves one parsed candidate and reports the result
+	check := func(cand candidate, arr1, arr2 []int, uncoveredPairs [][2]int, short []int, log *logging.Logger) {
+		var cnf, proof *bytes.Buffer
+		if *proofDir != "" {
+			cnf, proof = new(bytes.Buffer), new(bytes.Buffer)
+		}
+		start := time.Now()
+		var found bool
+		var added [][]int
+		var missed [][2]int
+		var stats solver.Stats
+		var size formulaSize
+		if *maxSAT {
+			added, missed, stats, size = solveMaxSAT(n, uncoveredPairs, arcs, *find, *amo, sym)
+			found = len(missed) == 0
+		} else {
+			found, added, stats, size = solveSAT(n, uncoveredPairs, short, arcs, *find, *amo, sym, cnf, proof)
+		}
+		elapsed := time.Since(start)
+		log.Debugf("candidate %d (%s): %d uncovered pairs, found=%v in %v (%d variables, %d clauses, %d conflicts)",
+			cand.index, cand.source, len(uncoveredPairs), found, elapsed.Round(time.Microsecond), size.vars, size.clauses, stats.NbConflicts)
+
+		if !found && *proofDir != "" {
+			base := filepath.Join(*proofDir, fmt.Sprintf("cand_%d", cand.index))
+			for ext, buf := range map[string]*bytes.Buffer{".cnf": cnf, ".drat": proof} {
+				if err := os.WriteFile(base+ext, buf.Bytes(), 0644); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
 				}
+			}
+		}
 
-				arr1 := parseArray(parts[0])
-				arr2 := parseArray(parts[1])
-				if len(arr1) != n || len(arr2) != n {
-					continue
-				}
+		results <- result{
+			index:          cand.index,
+			source:         cand.source,
+			found:          found,
+			uncoveredCount: len(uncoveredPairs),
+			uncovered:      uncoveredPairs,
+			elapsed:        elapsed,
+			stats:          stats,
+			arr1:           arr1,
+			arr2:           arr2,
+			added:          added,
+			missed:         missed,
+			size:           size,
+		}
 
-				// Compute covered pairs after arr0, arr1, arr2
-				covered := make([]bool, numPairs)
-				copy(covered, covered0)
+		b.Add(1)
+		if found && !*keepGoing {
+			atomic.StoreInt32(&stopFlag, 1)
+		}
+	}
 
-				for slot := 0; slot < n; slot++ {
-					item := arr1[slot]
-					for _, adjSlot := range fullAdj[slot] {
-						adjItem := arr1[adjSlot]
-						covered[pairTable[item][adjItem]] = true
-					}
+	// checkBatches takes the candidates -batch at a time, evaluates their
+	// coverage together and solves only those the batch evaluator can't
+	// refute
+	var refutedCount int64
+	checkBatches := func(eval *batchEvaluator, log *logging.Logger) {
+		batch := make([]candidate, 0, *batchSize)
+		for {
+			batch = batch[:0]
+			for cand := range work {
+				batch = append(batch, cand)
+				if len(batch) == *batchSize {
+					break
 				}
-
-				for slot := 0; slot < n; slot++ {
-					item := arr2[slot]
-					for _, adjSlot := range fullAdj[slot] {
-						adjItem := arr2[adjSlot]
-						covered[pairTable[item][adjItem]] = true
+			}
+			if len(batch) == 0 {
+				return
+			}
+			evs := eval.evaluate(batch)
+			if log.Enabled(logging.Verbose) {
+				refuted := 0
+				for _, ev := range evs {
+					if ev.refuted {
+						refuted++
 					}
 				}
-
-				// Find uncovered pairs
-				var uncoveredPairs [][2]int
-				for a := 0; a < n; a++ {
-					for b := a + 1; b < n; b++ {
-						if !covered[pairTable[a][b]] {
-							uncoveredPairs = append(uncoveredPairs, [2]int{a, b})
-						}
+				log.Verbosef("batch of %d candidates from %d: %d refuted", len(batch), batch[0].index, refuted)
+			}
+			for _, ev := range evs {
+				switch {
+				case stopping():
+				case !ev.ok:
+					results <- result{index: ev.cand.index, source: ev.cand.source, invalid: true}
+				case ev.refuted:
+					atomic.AddInt64(&refutedCount, 1)
+					results <- result{
+						index:          ev.cand.index,
+						source:         ev.cand.source,
+						refuted:        true,
+						uncoveredCount: len(ev.uncovered),
+						uncovered:      ev.uncovered,
+						arr1:           ev.arr1,
+						arr2:           ev.arr2,
 					}
+					b.Add(1)
+				default:
+					check(ev.cand, ev.arr1, ev.arr2, ev.uncovered, nil, log)
 				}
+			}
+		}
+	}
 
-				start := time.Now()
-				found, arr3 := solveSAT(n, uncoveredPairs, adjMatrix)
-				elapsed := time.Since(start)
-
-				results <- result{
-					index:          cand.index,
-					found:          found,
-					uncoveredCount: len(uncoveredPairs),
-					elapsed:        elapsed,
-					arr1:           arr1,
-					arr2:           arr2,
-					arr3:           arr3,
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(log *logging.Logger) {
+			defer wg.Done()
+			if *batchSize > 0 {
+				checkBatches(newBatchEvaluator(n, numEdges, edges, fullAdj, pairTable), log)
+				return
+			}
+			for cand := range work {
+				if stopping() {
+					continue
 				}
 
-				if found {
-					atomic.StoreInt32(&stopFlag, 1)
+				arr1, arr2, uncoveredPairs, short, ok := parseCandidate(cand.line)
+				if !ok {
+					results <- result{index: cand.index, source: cand.source, invalid: true}
+					continue
 				}
+				check(cand, arr1, arr2, uncoveredPairs, short, log)
 			}
-		}()
+		}(logger.Worker(w))
 	}
 
-	var checkedCount int64
+	var checkedCount, readCount int64
 	var foundResult *result
+	var bestResult *result          // -maxsat: the UNSAT candidate whose best completion leaves the fewest pairs
+	var solutions []arrangement.Set // for -out, rewritten at each one
+	var unsatCount, invalidCount, foundCount int
+	var solvedCount int                // candidates given to the SAT solver
+	var totalSize, maxSize formulaSize // over their formulas
 	start := time.Now()
 
 	// Progress ticker - update every second
@@ -206,38 +437,114 @@ func main() {
 					return
 				}
 				atomic.AddInt64(&checkedCount, 1)
+				if res.size.vars > 0 {
+					solvedCount++
+					totalSize.vars += res.size.vars
+					totalSize.clauses += res.size.clauses
+					maxSize.vars = max(maxSize.vars, res.size.vars)
+					maxSize.clauses = max(maxSize.clauses, res.size.clauses)
+				}
 
+				if statsCSV != nil {
+					writeStats(statsCSV, res)
+				}
+				if res.invalid {
+					invalidCount++
+					logger.Printf("Skipped malformed candidate %d (%s)", res.index, res.source)
+					continue
+				}
+				if !res.found {
+					unsatCount++
+					if unsatOut != nil {
+						logUnsat(unsatOut, res)
+					}
+				}
+				if *maxSAT && !res.found && (bestResult == nil || len(res.missed) < len(bestResult.missed)) {
+					bestResult = &res
+					logger.Printf("Best so far: candidate %d (%s), the best completion leaves %d of its %d uncovered pairs",
+						res.index, res.source, len(res.missed), res.uncoveredCount)
+					events.Emit("best", jsonl.Fields{
+						"index": res.index, "source": res.source, "uncovered": res.uncoveredCount, "missed": len(res.missed),
+						"arrangements": res.arrangements(n),
+					})
+				}
 				if res.found {
+					foundCount++
+					if foundResult != nil {
+						logger.Printf("Also SAT: candidate %d (%s)", res.index, res.source)
+						continue
+					}
 					foundResult = &res
 					fmt.Printf("\n*** SOLUTION FOUND at candidate %d! ***\n", res.index)
 					fmt.Printf("arr0: identity [0,1,2,...,%d]\n", n-1)
-					fmt.Printf("arr1: %v\n", res.arr1)
-					fmt.Printf("arr2: %v\n", res.arr2)
-					fmt.Printf("arr3: %v\n", res.arr3)
+					for i, arr := range res.arrangements(n)[1:] {
+						fmt.Printf("arr%d: %v\n", i+1, arr)
+					}
 					fmt.Printf("Uncovered pairs before arr3: %d\n", res.uncoveredCount)
 					fmt.Printf("SAT solve time: %v\n", res.elapsed)
 					fmt.Printf("Total time to find: %v\n", time.Since(start).Round(time.Millisecond))
 				}
+				if res.found && *outPath != "" {
+					solutions = append(solutions, arrangement.NewSpiral(n, res.arrangements(n), "find_fourth"))
+					if err := arrangement.WriteFile(*outPath, solutions...); err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+					if err := run.Wrote(*outPath); err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+				}
+				if res.found {
+					events.Emit("solution", jsonl.Fields{
+						"index": res.index, "source": res.source, "uncovered": res.uncoveredCount,
+						"arrangements":  res.arrangements(n),
+						"solve_seconds": res.elapsed.Seconds(),
+					})
+				}
 
 			case <-ticker.C:
 				count := atomic.LoadInt64(&checkedCount)
 				if count > 0 {
 					elapsed := time.Since(start)
 					rate := float64(count) / elapsed.Seconds()
-					remaining := float64(checkCount) - float64(count)
-					eta := time.Duration(remaining/rate) * time.Second
-					fmt.Printf("  Progress: %d/%d (%.2f%%), rate=%.1f/s, ETA=%v\n",
-						count, checkCount, float64(count)/float64(checkCount)*100, rate, eta.Round(time.Second))
+					events.Emit("progress", jsonl.Fields{
+						"checked": count, "read": atomic.LoadInt64(&readCount), "rate": rate,
+						"sat": foundCount, "unsat": unsatCount,
+					})
+					if ranked != nil {
+						remaining := float64(len(ranked)) - float64(count)
+						eta := time.Duration(remaining/rate) * time.Second
+						logger.Printf("Progress: %d/%d (%.2f%%), rate=%.1f/s, ETA=%v",
+							count, len(ranked), float64(count)/float64(len(ranked))*100, rate, eta.Round(time.Second))
+					} else {
+						logger.Printf("Progress: %d checked, %d read, rate=%.1f/s",
+							count, atomic.LoadInt64(&readCount), rate)
+					}
 				}
 			}
 		}
 	}()
 
-	for i := 0; i < checkCount; i++ {
-		if atomic.LoadInt32(&stopFlag) != 0 {
-			break
+	var readErr error
+	if ranked != nil {
+		for _, c := range ranked {
+			if stopping() {
+				readAll = false
+				break
+			}
+			work <- c
+			atomic.AddInt64(&readCount, 1)
 		}
-		work <- candidate{index: i, line: allLines[i]}
+	} else {
+		_, readAll, readErr = readCandidates(*inDir, *samples, sh, func(c candidate) bool {
+			if stopping() {
+				return false
+			}
+			work <- c
+			atomic.AddInt64(&readCount, 1)
+			return true
+		})
 	}
 	close(work)
 
@@ -248,7 +555,20 @@ func main() {
 	elapsed := time.Since(start)
 	checked := atomic.LoadInt64(&checkedCount)
 
+	total := atomic.LoadInt64(&readCount)
+	if ranked != nil {
+		total = int64(len(ranked))
+	}
+
 	fmt.Printf("\nResults:\n")
+	if readErr != nil {
+		fmt.Printf("  Input error after %d candidates: %v\n", total, readErr)
+	}
+	var stopped any
+	if err := b.Err(); err != nil {
+		stopped = err.Error()
+		fmt.Printf("  Stopped: %v\n", err)
+	}
 	fmt.Printf("  Checked: %d\n", checked)
 	fmt.Printf("  Total time: %v\n", elapsed.Round(time.Millisecond))
 	if checked > 0 {
@@ -256,109 +576,279 @@ func main() {
 		fmt.Printf("  Rate: %.0f candidates/sec\n", float64(checked)/elapsed.Seconds())
 	}
 
+	fmt.Printf("  SAT: %d, UNSAT: %d\n", foundCount, unsatCount)
+	if solvedCount > 0 {
+		fmt.Printf("  Formulas (-amo %s): %d variables, %d clauses on average; largest %d variables, %d clauses\n",
+			*amo, totalSize.vars/solvedCount, totalSize.clauses/solvedCount, maxSize.vars, maxSize.clauses)
+	}
+	refuted := atomic.LoadInt64(&refutedCount)
+	if *batchSize > 0 {
+		fmt.Printf("  Refuted by the batch tests without SAT: %d of the UNSAT\n", refuted)
+	}
+	if invalidCount > 0 {
+		fmt.Printf("  Malformed (not checked): %d\n", invalidCount)
+	}
+
 	if foundResult != nil {
-		fmt.Printf("\n*** Solution exists! 4 arrangements cover all %d pairs ***\n", numPairs)
+		if need != nil {
+			fmt.Printf("\n*** Solution exists! %d arrangements make all %d coverings -multiplicity asks for ***\n", 3+*find, need.Total())
+		} else {
+			fmt.Printf("\n*** Solution exists! %d arrangements cover all %d pairs ***\n", 3+*find, numPairs)
+		}
+		if *outPath != "" {
+			fmt.Printf("%d solution(s) written to %s\n", len(solutions), *outPath)
+		}
 	} else {
 		fmt.Printf("\n*** No solution found in %d candidates ***\n", checked)
+		if bestResult != nil {
+			r := bestResult
+			fmt.Printf("Closest (-maxsat): candidate %d (%s) leaves %d of the %d pairs uncovered with the best arrangements\n",
+				r.index, r.source, len(r.missed), numPairs)
+			for i, arr := range r.arrangements(n)[1:] {
+				fmt.Printf("arr%d: %v\n", i+1, arr)
+			}
+			fmt.Printf("Still uncovered: %s\n", joinPairs(r.missed))
+		}
+		// Only a complete, clean run rules out the whole candidate set
+		if readAll && readErr == nil && int64(unsatCount) == total {
+			fmt.Printf("All %d candidates in %s are UNSAT: none of them extends to %d arrangements\n", unsatCount, scope, 3+*find)
+		} else if readAll && readErr == nil {
+			fmt.Printf("Not a proof for %s: %d of %d candidates are UNSAT\n", scope, unsatCount, total)
+		} else {
+			fmt.Printf("Not a proof for %s: input not read to the end (%d candidates read, %d UNSAT)\n", scope, total, unsatCount)
+		}
+	}
+	bestMissed := -1 // no -maxsat result
+	if bestResult != nil {
+		bestMissed = len(bestResult.missed)
+	}
+	inputErr := ""
+	if readErr != nil {
+		inputErr = readErr.Error()
+	}
+	events.Emit("result", jsonl.Fields{
+		"checked": checked, "read": total, "sat": foundCount, "unsat": unsatCount, "malformed": invalidCount,
+		"refuted": refuted, "best_missed": bestMissed, "amo": *amo, "max_vars": maxSize.vars, "max_clauses": maxSize.clauses, "input_complete": readAll && readErr == nil, "input_error": inputErr,
+		"all_unsat": foundResult == nil && readAll && readErr == nil && int64(unsatCount) == total,
+		"stopped":   stopped, "seconds": elapsed.Seconds(),
+	})
+	if unsatOut != nil {
+		complete := "complete"
+		if stopped != nil {
+			complete = fmt.Sprintf("stopped: %v", stopped)
+		} else if !readAll || readErr != nil {
+			complete = "input not read to the end"
+		}
+		fmt.Fprintf(unsatOut, "# checked %d, unsat %d, malformed %d, of %d candidates read (%s)\n",
+			checked, unsatCount, invalidCount, total, complete)
+		fmt.Printf("UNSAT candidates logged to %s\n", *unsatLog)
+	}
+	if statsCSV != nil {
+		fmt.Printf("Per-candidate statistics written to %s\n", *statsOut)
+	}
+	if *proofDir != "" {
+		fmt.Printf("CNFs and DRAT proofs in %s (check with drat-trim cand_i.cnf cand_i.drat)\n", *proofDir)
+	}
+	if err := run.Wrote(*unsatLog, *statsOut, *proofDir); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
+	code := 0
+	if readErr != nil {
+		code = 1
+	} else if stopped != nil && foundResult == nil {
+		code = budget.ExitStopped
+	}
+	if code != 0 {
+		// os.Exit skips the deferred flushes
+		if unsatOut != nil {
+			unsatOut.Flush()
+		}
+		if statsCSV != nil {
+			statsCSV.Flush()
+		}
+		os.Exit(code)
+	}
+}
+
+// logUnsat records one UNSAT candidate: index, source, the arrangements
+// and the pairs the fourth arrangement would have had to cover
+func logUnsat(w *bufio.Writer, res result) {
+	fmt.Fprintf(w, "%d\t%s\t%s;%s\t%s\n", res.index, res.source,
+		joinInts(res.arr1), joinInts(res.arr2), joinPairs(res.uncovered))
 }
 
-func solveSAT(n int, uncoveredPairs [][2]int, adjMatrix [][]bool) (bool, []int) {
-	// Variables: x[item][slot] means item is placed in slot
-	// Variable numbering: item*n + slot + 1 (SAT vars are 1-indexed)
-	varIdx := func(item, slot int) int {
-		return item*n + slot + 1
+// joinPairs writes pairs as a-b,c-d,...
+func joinPairs(pairs [][2]int) string {
+	s := make([]string, len(pairs))
+	for i, p := range pairs {
+		s[i] = fmt.Sprintf("%d-%d", p[0], p[1])
 	}
+	return strings.Join(s, ",")
+}
 
-	var clauses [][]int
+// writeStats records one candidate's row of the -stats-out CSV
+func writeStats(w *csv.Writer, res result) {
+	status := "UNSAT"
+	switch {
+	case res.invalid:
+		w.Write([]string{strconv.Itoa(res.index), res.source, "", "malformed", "", "", "", "", "", "", ""})
+		return
+	case res.refuted:
+		w.Write([]string{strconv.Itoa(res.index), res.source, strconv.Itoa(res.uncoveredCount), "UNSAT", "", "", "", "", "", "", ""})
+		return
+	case res.found:
+		status = "SAT"
+	}
+	w.Write([]string{
+		strconv.Itoa(res.index),
+		res.source,
+		strconv.Itoa(res.uncoveredCount),
+		status,
+		strconv.Itoa(res.stats.NbConflicts),
+		strconv.Itoa(res.stats.NbDecisions),
+		strconv.Itoa(res.stats.NbRestarts),
+		strconv.Itoa(res.stats.NbLearned),
+		strconv.FormatFloat(res.elapsed.Seconds()*1000, 'f', 3, 64),
+		strconv.Itoa(res.size.vars),
+		strconv.Itoa(res.size.clauses),
+	})
+}
 
-	// Constraint 1: Each item in at least one slot
-	for item := 0; item < n; item++ {
-		clause := make([]int, n)
-		for slot := 0; slot < n; slot++ {
-			clause[slot] = varIdx(item, slot)
-		}
-		clauses = append(clauses, clause)
+func identityArr(n int) []int {
+	a := make([]int, n)
+	for i := range a {
+		a[i] = i
 	}
+	return a
+}
 
-	// Constraint 2: Each item in at most one slot
-	for item := 0; item < n; item++ {
-		for s1 := 0; s1 < n; s1++ {
-			for s2 := s1 + 1; s2 < n; s2++ {
-				clauses = append(clauses, []int{-varIdx(item, s1), -varIdx(item, s2)})
-			}
-		}
+func joinInts(a []int) string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = strconv.Itoa(v)
 	}
+	return strings.Join(s, ",")
+}
 
-	// Constraint 3: Each slot has at least one item
-	for slot := 0; slot < n; slot++ {
-		clause := make([]int, n)
-		for item := 0; item < n; item++ {
-			clause[item] = varIdx(item, slot)
-		}
-		clauses = append(clauses, clause)
+// buildFormula encodes the last count arrangements (arr3, arr4, ...) as
+// permutations that together cover uncoveredPairs, with the at-most-one
+// constraints in encoding amo and, if sym is non-nil, symmetry-breaking
+// clauses on arr3 (addSymmetryBreaking). If short is non-nil, pair i must
+// be covered by short[i] of them (-multiplicity). With relax, each pair's
+// clause gets a variable of its own that satisfies it instead, returned in
+// the order of uncoveredPairs, so a model may leave pairs uncovered; that
+// needs short nil.
+func buildFormula(n int, uncoveredPairs [][2]int, short []int, arcs [][2]int, count int, amo string, sym *hostSymmetry, relax bool) (*encoding.CNF, []encoding.Permutation, []int) {
+	f := &encoding.CNF{}
+	perms := make([]encoding.Permutation, count)
+	for i := range perms {
+		perms[i] = f.NewPermutation(n)
+	}
+	for _, perm := range perms {
+		f.PermutationConstraints(perm, amo)
 	}
 
-	// Constraint 4: Each slot has at most one item
-	for slot := 0; slot < n; slot++ {
-		for i1 := 0; i1 < n; i1++ {
-			for i2 := i1 + 1; i2 < n; i2++ {
-				clauses = append(clauses, []int{-varIdx(i1, slot), -varIdx(i2, slot)})
+	// Each uncovered pair must be covered by one of them: a and b at the
+	// ends of one of the host's arcs. A pair short of r > 1 coverings gets
+	// one variable per arrangement, implying one of its ways there, and at
+	// least r of those (none can be true if r > count).
+	var relaxVars []int
+	for i, pair := range uncoveredPairs {
+		var ways, by []int
+		for _, perm := range perms {
+			at := f.Adjacent(perm, pair[0], pair[1], arcs)
+			ways = append(ways, at...)
+			if short != nil && short[i] > 1 {
+				v := f.NewVar()
+				f.Add(append([]int{-v}, at...)...)
+				by = append(by, v)
 			}
 		}
+		if short != nil && short[i] > 1 {
+			f.AtLeast(short[i], by)
+			continue
+		}
+		if relax {
+			r := f.NewVar()
+			relaxVars = append(relaxVars, r)
+			ways = append(ways, r)
+		}
+		f.Add(ways...)
 	}
 
-	// Next available variable for auxiliaries
-	nextVar := n*n + 1
-
-	// Constraint 5: Each uncovered pair must be covered by arr3
-	for _, pair := range uncoveredPairs {
-		a, b := pair[0], pair[1]
-
-		// Collect all ways this pair can be covered
-		var auxVars []int
-		for s1 := 0; s1 < n; s1++ {
-			for s2 := 0; s2 < n; s2++ {
-				if adjMatrix[s1][s2] {
-					// aux <=> (a@s1 AND b@s2)
-					aux := nextVar
-					nextVar++
-					auxVars = append(auxVars, aux)
+	// Swapping twins in every arrangement at once, and moving arr3 alone
+	// by a host automorphism, keep the coverage, so the clauses stay valid
+	// for more than one arrangement
+	if sym != nil {
+		addSymmetryBreaking(f, n, uncoveredPairs, short, sym, perms[0].Var)
+	}
+	return f, perms, relaxVars
+}
 
-					clauses = append(clauses, []int{-aux, varIdx(a, s1)})
-					clauses = append(clauses, []int{-aux, varIdx(b, s2)})
-					clauses = append(clauses, []int{-varIdx(a, s1), -varIdx(b, s2), aux})
-				}
-			}
-		}
+// decodeAll returns the arrangements of perms in model.
+func decodeAll(perms []encoding.Permutation, model []bool) [][]int {
+	arrs := make([][]int, len(perms))
+	for i, p := range perms {
+		arrs[i] = p.Decode(model)
+	}
+	return arrs
+}
 
-		// At least one aux must be true
-		clauses = append(clauses, auxVars)
+// satMu serializes the solvers: gophersat builds every learned clause in
+// one package-level buffer, so two solvers searching at once corrupt each
+// other's clauses (a crash at best, a wrong verdict at worst). Workers
+// still parse candidates and build formulas in parallel.
+var satMu sync.Mutex
+
+// solveSAT looks for the last count arrangements covering uncoveredPairs,
+// short[i] times each if short is non-nil (buildFormula), and
+// returns the formula's size along with the answer. If cnfOut and proof
+// are non-nil, the formula is written to cnfOut in DIMACS form and the
+// clauses gophersat learns to proof, which for an UNSAT answer ends in the
+// empty clause and forms a DRAT proof (without deletions).
+func solveSAT(n int, uncoveredPairs [][2]int, short []int, arcs [][2]int, count int, amo string, sym *hostSymmetry, cnfOut, proof *bytes.Buffer) (bool, [][]int, solver.Stats, formulaSize) {
+	f, perms, _ := buildFormula(n, uncoveredPairs, short, arcs, count, amo, sym, false)
+	clauses := f.Clauses
+	size := formulaSize{vars: f.NumVars, clauses: len(clauses)}
+
+	if cnfOut != nil {
+		f.WriteDIMACS(cnfOut)
 	}
 
 	// Solve
 	problem := solver.ParseSlice(clauses)
 	s := solver.New(problem)
+	var certDone chan bool
+	if proof != nil {
+		s.Certified = true
+		s.CertChan = make(chan string)
+		certDone = make(chan bool)
+		go func() {
+			last := ""
+			for line := range s.CertChan {
+				proof.WriteString(line + "\n")
+				last = line
+			}
+			certDone <- last == "0"
+		}()
+	}
+	satMu.Lock()
 	status := s.Solve()
-
-	if status != solver.Sat {
-		return false, nil
+	satMu.Unlock()
+	if proof != nil {
+		close(s.CertChan)
+		// A formula refuted while parsing yields no certificate lines
+		if hasEmpty := <-certDone; !hasEmpty && status != solver.Sat {
+			proof.WriteString("0\n")
+		}
 	}
 
-	// Extract solution
-	model := s.Model()
-	arr3 := make([]int, n)
-	for item := 0; item < n; item++ {
-		for slot := 0; slot < n; slot++ {
-			v := varIdx(item, slot)
-			if v <= len(model) && model[v-1] {
-				arr3[slot] = item
-				break
-			}
-		}
+	if status != solver.Sat {
+		return false, nil, s.Stats, size
 	}
 
-	return true, arr3
+	return true, decodeAll(perms, s.Model()), s.Stats, size
 }
 
 func parseArray(s string) []int {
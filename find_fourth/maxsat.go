@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/crillab/gophersat/solver"
+)
+
+// solveMaxSAT finds the last count arrangements covering as many of
+// uncoveredPairs as possible: the formula of solveSAT with a relaxation
+// variable per pair, whose sum gophersat minimizes (each better model
+// tightens a pseudo-Boolean bound on it until none is left, so the last
+// one is optimal). It returns the witness and the pairs it leaves
+// uncovered, none if it completes the candidate.
+func solveMaxSAT(n int, uncoveredPairs [][2]int, arcs [][2]int, count int, amo string, sym *hostSymmetry) ([][]int, [][2]int, solver.Stats, formulaSize) {
+	f, perms, relaxVars := buildFormula(n, uncoveredPairs, nil, arcs, count, amo, sym, true)
+	size := formulaSize{vars: f.NumVars, clauses: len(f.Clauses)}
+
+	problem := solver.ParseSliceNb(f.Clauses, f.NumVars)
+	cost := make([]solver.Lit, len(relaxVars))
+	weights := make([]int, len(relaxVars))
+	for i, r := range relaxVars {
+		cost[i], weights[i] = solver.IntToLit(int32(r)), 1
+	}
+	// Minimize indexes the weights even when SetCostFunc allows nil for 1s
+	problem.SetCostFunc(cost, weights)
+	s := solver.New(problem)
+	// Any permutation satisfies the relaxed formula, so there is a model
+	satMu.Lock()
+	s.Minimize()
+	satMu.Unlock()
+	arrs := decodeAll(perms, s.Model())
+	return arrs, missedPairs(arrs, uncoveredPairs, arcs), s.Stats, size
+}
+
+// missedPairs returns the pairs none of arrs places on an arc of the host.
+func missedPairs(arrs [][]int, pairs [][2]int, arcs [][2]int) [][2]int {
+	n := len(arrs[0])
+	adjacent := make([]bool, n*n)
+	for _, arr := range arrs {
+		for _, arc := range arcs {
+			adjacent[arr[arc[0]]*n+arr[arc[1]]] = true
+		}
+	}
+	var missed [][2]int
+	for _, p := range pairs {
+		if !adjacent[p[0]*n+p[1]] {
+			missed = append(missed, p)
+		}
+	}
+	return missed
+}
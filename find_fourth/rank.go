@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// difficulty is a cheap estimate of how hard a candidate is for the SAT
+// solver. A candidate failing a necessary condition can't be completed and
+// goes last; among the rest, fewer uncovered pairs and more spare degree
+// come first.
+type difficulty struct {
+	infeasible bool
+	uncovered  int
+	minSlack   int // smallest host degree minus item demand after matching
+}
+
+func (d difficulty) less(o difficulty) bool {
+	if d.infeasible != o.infeasible {
+		return o.infeasible
+	}
+	if d.uncovered != o.uncovered {
+		return d.uncovered < o.uncovered
+	}
+	return d.minSlack > o.minSlack
+}
+
+// estimateDifficulty runs the necessary conditions for arr3 to cover the
+// uncovered pairs: there must be at most as many as arr3 has edges, and
+// every item must sit at a slot with at least as many neighbors as it has
+// uncovered partners, all items at distinct slots. That is a bipartite
+// matching between items and slots where item i fits slots of degree >=
+// demand(i); since these neighborhoods are nested, it exists iff the
+// demands sorted in descending order are dominated pointwise by the sorted
+// host degrees (Hall's condition), so the greedy pairing below decides it.
+func estimateDifficulty(n, numEdges int, hostDeg []int, uncovered [][2]int) difficulty {
+	d := difficulty{uncovered: len(uncovered), minSlack: n}
+	if len(uncovered) > numEdges {
+		d.infeasible = true
+	}
+	demand := make([]int, n)
+	for _, p := range uncovered {
+		demand[p[0]]++
+		demand[p[1]]++
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(demand)))
+	for i, need := range demand {
+		slack := hostDeg[i] - need
+		if slack < 0 {
+			d.infeasible = true
+		}
+		if slack < d.minSlack {
+			d.minSlack = slack
+		}
+	}
+	return d
+}
+
+// rankCandidates reorders cands in place by estimated difficulty, easiest
+// first. Malformed lines go last; indices are kept so logs still refer to
+// input order.
+func rankCandidates(cands []candidate, n, numEdges int, fullAdj [][]int, uncoveredOf func(line string) ([][2]int, bool)) {
+	hostDeg := make([]int, n)
+	for s := range fullAdj {
+		hostDeg[s] = len(fullAdj[s])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(hostDeg)))
+
+	diff := make(map[int]difficulty, len(cands))
+	infeasible, malformed := 0, 0
+	for _, c := range cands {
+		uncovered, ok := uncoveredOf(c.line)
+		d := difficulty{infeasible: true, uncovered: n * n}
+		if ok {
+			d = estimateDifficulty(n, numEdges, hostDeg, uncovered)
+			if d.infeasible {
+				infeasible++
+			}
+		} else {
+			malformed++
+		}
+		diff[c.index] = d
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return diff[cands[i].index].less(diff[cands[j].index])
+	})
+
+	fmt.Printf("Ranked %d candidates: %d pass the edge-count and degree-matching tests, %d can't be completed, %d malformed (both checked last)\n",
+		len(cands), len(cands)-infeasible-malformed, infeasible, malformed)
+	if len(cands) > 0 && !diff[cands[0].index].infeasible {
+		d := diff[cands[0].index]
+		fmt.Printf("Easiest: candidate %d (%d uncovered pairs, min degree slack %d)\n", cands[0].index, d.uncovered, d.minSlack)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math/bits"
+
+	"hexagon_clink/pkg/encoding"
+	"hexagon_clink/pkg/subiso"
+)
+
+// hostSymmetry holds what -symmetry needs of the host's automorphisms:
+// the slots that represent their orbits, and the same under the
+// stabilizer of each representative.
+type hostSymmetry struct {
+	autos    [][]int
+	reps     []bool   // reps[s]: s is the smallest slot of its orbit
+	stabReps [][]bool // stabReps[r][s]: the same under the automorphisms fixing r
+}
+
+// newHostSymmetry finds the spiral's automorphisms (there are few: 1 for
+// most n, at most 12 up to n=30).
+func newHostSymmetry(n int, arcs [][2]int) *hostSymmetry {
+	adj := make([]uint64, n)
+	for _, arc := range arcs {
+		adj[arc[0]] |= 1 << arc[1]
+	}
+	autos, _ := subiso.Automorphisms(adj, 1<<16)
+	h := &hostSymmetry{autos: autos, stabReps: make([][]bool, n)}
+	h.reps = orbitReps(n, autos)
+	for r := 0; r < n; r++ {
+		if !h.reps[r] {
+			continue
+		}
+		var stab [][]int
+		for _, g := range autos {
+			if g[r] == r {
+				stab = append(stab, g)
+			}
+		}
+		h.stabReps[r] = orbitReps(n, stab)
+	}
+	return h
+}
+
+// orbitReps marks the smallest slot of each orbit of the group.
+func orbitReps(n int, group [][]int) []bool {
+	reps := make([]bool, n)
+	for s := 0; s < n; s++ {
+		reps[s] = true
+		for _, g := range group {
+			if g[s] < s {
+				reps[s] = false
+				break
+			}
+		}
+	}
+	return reps
+}
+
+// addSymmetryBreaking adds clauses that keep one arr3 of each class of
+// equivalent ones, so an UNSAT formula is refuted with less search; the
+// formula stays satisfiable iff it was. Two symmetries map arr3 to another
+// arr3 covering the same pairs:
+//
+//   - Items that are twins in the graph of uncovered pairs (the same
+//     uncovered partners, apart from each other) can trade slots, e.g. all
+//     items without uncovered pairs. Twins must take slots in increasing
+//     order.
+//   - An automorphism of the host moves every item along. Of the items
+//     without a twin, the one with the most uncovered partners must sit at
+//     the smallest slot of its orbit, and the next one at the smallest slot
+//     of its orbit under the automorphisms fixing the first one's slot.
+//
+// Any arr3 can be brought into this form by first moving it with an
+// automorphism and then sorting the twins, which doesn't move the two
+// items without a twin. With short (-multiplicity), an item in a pair that
+// lacks more than one covering has no twin: trading it would move that
+// requirement to another pair.
+func addSymmetryBreaking(f *encoding.CNF, n int, uncovered [][2]int, short []int, host *hostSymmetry, varIdx func(item, slot int) int) {
+	nbrs := make([]uint64, n)
+	canTwin := make([]bool, n)
+	for item := range canTwin {
+		canTwin[item] = true
+	}
+	for i, p := range uncovered {
+		nbrs[p[0]] |= 1 << p[1]
+		nbrs[p[1]] |= 1 << p[0]
+		if short != nil && short[i] > 1 {
+			canTwin[p[0]], canTwin[p[1]] = false, false
+		}
+	}
+
+	// Open twins (non-adjacent, same neighbors) and closed twins (adjacent,
+	// same neighbors counting themselves) form disjoint classes
+	twin := make([]bool, n)
+	for _, closed := range []bool{false, true} {
+		classes := map[uint64][]int{}
+		var keys []uint64
+		for item := 0; item < n; item++ {
+			if !canTwin[item] {
+				continue
+			}
+			key := nbrs[item]
+			if closed {
+				key |= 1 << item
+			}
+			if classes[key] == nil {
+				keys = append(keys, key)
+			}
+			classes[key] = append(classes[key], item)
+		}
+		for _, key := range keys {
+			class := classes[key]
+			for i := 1; i < len(class); i++ {
+				twin[class[i-1]], twin[class[i]] = true, true
+				// class[i] at slot s needs class[i-1] at a smaller slot
+				for s := 0; s < n; s++ {
+					clause := []int{-varIdx(class[i], s)}
+					for t := 0; t < s; t++ {
+						clause = append(clause, varIdx(class[i-1], t))
+					}
+					f.Add(clause...)
+				}
+			}
+		}
+	}
+
+	if len(host.autos) <= 1 {
+		return
+	}
+	first, second := -1, -1
+	for item := 0; item < n; item++ {
+		if twin[item] {
+			continue
+		}
+		switch {
+		case first < 0 || bits.OnesCount64(nbrs[item]) > bits.OnesCount64(nbrs[first]):
+			first, second = item, first
+		case second < 0 || bits.OnesCount64(nbrs[item]) > bits.OnesCount64(nbrs[second]):
+			second = item
+		}
+	}
+	if first < 0 {
+		return
+	}
+	for s := 0; s < n; s++ {
+		if !host.reps[s] {
+			f.Add(-varIdx(first, s))
+			continue
+		}
+		if second < 0 {
+			continue
+		}
+		for t := 0; t < n; t++ {
+			if t != s && !host.stabReps[s][t] {
+				f.Add(-varIdx(first, s), -varIdx(second, t))
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+module hexagon_clink
+
+go 1.21
+
+require (
+	github.com/crillab/gophersat v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.22
+)
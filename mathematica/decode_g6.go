@@ -1,60 +1,273 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/coordfile"
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/jsonl"
 )
 
-func decodeGraph6(s string) (int, [][2]int) {
-	n := int(s[0]) - 63
+// Graph is one decoded input line
+type Graph struct {
+	n      int
+	edges  [][2]int
+	coords *coordfile.Graph // positions, or nil
+}
+
+// readCoords reads a coordinate file, keyed by the graph6 form of each
+// graph so the positions can be matched to the input lines.
+func readCoords(path string) (map[string]*coordfile.Graph, error) {
+	graphs, err := coordfile.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	coords := make(map[string]*coordfile.Graph, len(graphs))
+	for i := range graphs {
+		coords[graph6.EncodeEdges(len(graphs[i].Pos), graphs[i].Edges)] = &graphs[i]
+	}
+	return coords, nil
+}
+
+// graphExpr is the Graph[] expression, with vertices 0..n-1 as in the
+// edge lists.
+func (g Graph) graphExpr() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Graph[Range[0, %d], {", g.n-1)
+	for i, e := range g.edges {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d <-> %d", e[0], e[1])
+	}
+	b.WriteString("}]")
+	return b.String()
+}
 
-	// Decode bits from remaining characters
-	var bits []int
-	for i := 1; i < len(s); i++ {
-		val := int(s[i]) - 63
-		for b := 5; b >= 0; b-- {
-			bits = append(bits, (val>>b)&1)
+// coordsExpr is the vertex positions in the plane: lattice coordinates
+// {q, r} go through toXY.
+func (g Graph) coordsExpr() string {
+	parts := make([]string, len(g.coords.Pos))
+	for i, p := range g.coords.Pos {
+		if g.coords.Lattice {
+			parts[i] = fmt.Sprintf("{%d, %d}", int(p[0]), int(p[1]))
+		} else {
+			parts[i] = fmt.Sprintf("{%s, %s}", number(p[0]), number(p[1]))
 		}
 	}
+	list := "{" + strings.Join(parts, ", ") + "}"
+	if g.coords.Lattice {
+		return "(" + toXY + list + ")"
+	}
+	return list
+}
 
-	// Read upper triangle
-	var edges [][2]int
-	idx := 0
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			if idx < len(bits) && bits[idx] == 1 {
-				edges = append(edges, [2]int{i, j})
+// number formats x as a Mathematica real (no e notation).
+func number(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+// toXY turns axial lattice coordinates into the plane with unit edges
+const toXY = "{#1 + #2/2, #2 Sqrt[3]/2} & @@@ "
+
+func (g Graph) title(num int) string {
+	s := fmt.Sprintf("Graph %d: %d vertices, %d edges", num, g.n, len(g.edges))
+	if g.coords != nil && g.coords.Lattice {
+		s += ", lattice embedding"
+	} else if g.coords != nil {
+		s += ", embedding"
+	}
+	return s
+}
+
+// writeSnippets writes the graphNEdges = {...} assignments.
+func writeSnippets(w io.Writer, graphs []Graph) {
+	for i, g := range graphs {
+		num := i + 1
+		fmt.Fprintf(w, "(* Graph %d: %d vertices, %d edges *)\n", num, g.n, len(g.edges))
+		fmt.Fprintf(w, "graph%dEdges = {\n", num)
+		for k, e := range g.edges {
+			if k < len(g.edges)-1 {
+				fmt.Fprintf(w, "  {%d, %d},\n", e[0], e[1])
+			} else {
+				fmt.Fprintf(w, "  {%d, %d}\n", e[0], e[1])
 			}
-			idx++
 		}
+		fmt.Fprintf(w, "};\n\n")
 	}
+}
 
-	return n, edges
+// writePackage writes a package defining $Graphs and $Coordinates (graph
+// number -> Graph[] and -> positions) and PlotGraph/PlotAll to draw them.
+func writePackage(w io.Writer, graphs []Graph, context string) {
+	fmt.Fprintf(w, "(* ::Package:: *)\n\n")
+	fmt.Fprintf(w, "(* %d graphs, generated by mathematica/decode_g6.go. Load with Get[\"file.wl\"]. *)\n\n", len(graphs))
+	fmt.Fprintf(w, "BeginPackage[\"%s`\"];\n\n", context)
+	fmt.Fprintln(w, `$Graphs::usage = "$Graphs is an association from graph number to Graph[], vertices numbered from 0.";`)
+	fmt.Fprintln(w, `$Coordinates::usage = "$Coordinates is an association from graph number to vertex positions, for the graphs with a known embedding.";`)
+	fmt.Fprintln(w, `PlotGraph::usage = "PlotGraph[k] draws graph k with GraphPlot, at its embedding when known.";`)
+	fmt.Fprintln(w, `PlotAll::usage = "PlotAll[] draws all graphs in a grid.";`)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "$Graphs = <|")
+	for i, g := range graphs {
+		sep := ","
+		if i == len(graphs)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "  (* %s *)\n", g.title(i+1))
+		fmt.Fprintf(w, "  %d -> %s%s\n", i+1, g.graphExpr(), sep)
+	}
+	fmt.Fprintf(w, "|>;\n\n")
+
+	var withCoords []int
+	for i, g := range graphs {
+		if g.coords != nil {
+			withCoords = append(withCoords, i)
+		}
+	}
+	fmt.Fprintln(w, "$Coordinates = <|")
+	for k, i := range withCoords {
+		sep := ","
+		if k == len(withCoords)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "  %d -> %s%s\n", i+1, graphs[i].coordsExpr(), sep)
+	}
+	fmt.Fprintf(w, "|>;\n\n")
+
+	fmt.Fprintln(w, "Begin[\"`Private`\"];")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "PlotGraph[k_Integer] := If[KeyExistsQ[$Coordinates, k],")
+	fmt.Fprintln(w, "  GraphPlot[$Graphs[k], VertexCoordinates -> $Coordinates[k], PlotLabel -> k],")
+	fmt.Fprintln(w, "  GraphPlot[$Graphs[k], PlotLabel -> k]]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "PlotAll[] := GraphicsGrid[Partition[PlotGraph /@ Keys[$Graphs], UpTo[4]]]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "End[];")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "EndPackage[];")
+}
+
+// writeNotebook writes a Notebook[] expression with a heading and an input
+// cell per graph, each cell a self-contained GraphPlot call.
+func writeNotebook(w io.Writer, graphs []Graph) {
+	fmt.Fprintln(w, "Notebook[{")
+	fmt.Fprintf(w, "Cell[\"%d graphs\", \"Title\"]", len(graphs))
+	for i, g := range graphs {
+		call := "GraphPlot[" + g.graphExpr()
+		if g.coords != nil {
+			call += ", VertexCoordinates -> " + g.coordsExpr()
+		}
+		call += "]"
+		fmt.Fprintf(w, ",\nCell[\"%s\", \"Subsection\"],\n", g.title(i+1))
+		fmt.Fprintf(w, "Cell[\"%s\", \"Input\"]", call)
+	}
+	fmt.Fprintln(w, "\n}]")
 }
 
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
-	graphNum := 1
+	format := flag.String("format", "snippets", "output: snippets (graphNEdges = {...} assignments), package (a .wl package) or notebook (a .nb, one cell per graph)")
+	coordsFile := flag.String("coords", "", "vertex positions from polyiamond_enum, hexclink lattice or verify_penny -coords, used for the graphs they match")
+	context := flag.String("context", "PennyGraphs", "package context for -format package")
+	outFile := flag.String("out", "", "write the output to this file instead of stdout")
+	jsonOut := flag.Bool("json", false, "write events (start, result) as JSON lines on stdout, so the output needs -out; text goes to stderr")
+	flag.Parse()
+	if *jsonOut && *outFile == "" {
+		fmt.Println("Error: -json writes events to stdout; give the output a file with -out")
+		os.Exit(1)
+	}
+	events := jsonl.Start("decode_g6", *jsonOut)
+	if *format != "snippets" && *format != "package" && *format != "notebook" {
+		fmt.Printf("Error: unknown -format %q (want snippets, package or notebook)\n", *format)
+		os.Exit(1)
+	}
+	if *format == "snippets" && *coordsFile != "" {
+		fmt.Println("Error: -coords needs -format package or notebook")
+		os.Exit(1)
+	}
+
+	var coords map[string]*coordfile.Graph
+	if *coordsFile != "" {
+		var err error
+		if coords, err = readCoords(*coordsFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
+	events.Emit("start", jsonl.Fields{"format": *format, "coords": *coordsFile, "out": *outFile})
+	var graphs []Graph
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30) // a graph6 line grows as n^2/12
 	for scanner.Scan() {
 		line := scanner.Text()
-		if line == "" {
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		n, edges := decodeGraph6(line)
+		n, edges, err := graph6.Decode(line)
+		if err != nil {
+			fmt.Printf("Error: graph %d: %v\n", len(graphs)+1, err)
+			os.Exit(1)
+		}
+		g := Graph{n: n, edges: edges}
+		if coords != nil {
+			g.coords = coords[graph6.EncodeEdges(n, edges)]
+		}
+		graphs = append(graphs, g)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-		fmt.Printf("(* Graph %d: %d vertices, %d edges *)\n", graphNum, n, len(edges))
-		fmt.Printf("graph%dEdges = {\n", graphNum)
-		for i, e := range edges {
-			if i < len(edges)-1 {
-				fmt.Printf("  {%d, %d},\n", e[0], e[1])
-			} else {
-				fmt.Printf("  {%d, %d}\n", e[0], e[1])
+	out := os.Stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	switch *format {
+	case "snippets":
+		writeSnippets(w, graphs)
+	case "package":
+		writePackage(w, graphs, *context)
+	case "notebook":
+		writeNotebook(w, graphs)
+	}
+	err := w.Flush()
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	result := jsonl.Fields{"graphs": len(graphs), "format": *format, "out": *outFile}
+	if coords != nil {
+		matched := 0
+		for _, g := range graphs {
+			if g.coords != nil {
+				matched++
 			}
 		}
-		fmt.Printf("};\n\n")
-		graphNum++
+		fmt.Fprintf(os.Stderr, "%d of %d graphs have coordinates\n", matched, len(graphs))
+		result["with_coords"] = matched
 	}
+	events.Emit("result", result)
 }
@@ -0,0 +1,494 @@
+//go:build ignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/oeis"
+	"hexagon_clink/pkg/zfile"
+)
+
+// Runs the full brute-force pipeline for one n: generate_edges writes the
+// candidates for the whole edge range in one pass, then
+//   refine_hash -> wl_refine -> canonicalize -> verify_penny
+// runs once per edge count, then filter_maximal over all penny graphs found.
+// With -orderly, generate_edges emits one graph per isomorphism class and
+// only verify_penny is left per edge count.
+
+var tools = []string{
+	"generate_edges",
+	"refine_hash",
+	"wl_refine",
+	"canonicalize",
+	"verify_penny",
+	"filter_maximal",
+}
+
+type stageResult struct {
+	edges     int
+	pennyFile string
+	unique    int
+	penny     int
+	err       error
+	elapsed   time.Duration
+}
+
+// ensureTools (re)builds every <tool>.out binary in binDir. go build is
+// cached, so this costs next to nothing when nothing changed, and a binary
+// is never older than its source or the packages it uses.
+func ensureTools(binDir, tags string) error {
+	for _, tool := range tools {
+		bin, err := filepath.Abs(filepath.Join(binDir, tool+".out"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Building %s...\n", bin)
+		// Built from binDir, so the module is found wherever the run is
+		cmd := exec.Command("go", "build", "-tags", tags, "-o", bin, tool+".go")
+		cmd.Dir = binDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("building %s: %v\n%s", tool, err, out)
+		}
+	}
+	return nil
+}
+
+// runStage runs one tool binary, appending its output to the log file
+func runStage(binDir string, log *os.File, tool string, args ...string) error {
+	bin, err := filepath.Abs(filepath.Join(binDir, tool+".out"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "\n$ %s %s\n", tool, strings.Join(args, " "))
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v (see %s)", tool, err, log.Name())
+	}
+	return nil
+}
+
+func countLines(path string) int {
+	count, err := zfile.CountLines(path)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+func graphCount(path string) uint64 {
+	r, err := graphio.OpenAny(path)
+	if err != nil {
+		return 0
+	}
+	defer r.Close()
+	if r.Header().Count != graphio.Streamed {
+		return r.Header().Count
+	}
+	var count uint64
+	for {
+		if _, err := r.Next(); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+// writeEmpty creates path as an empty g6 file (a valid empty stream if it
+// is compressed).
+func writeEmpty(path string) error {
+	f, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// removeFiles deletes intermediate files and their manifests.
+func removeFiles(paths ...string) {
+	for _, path := range paths {
+		os.Remove(path)
+		os.Remove(path + manifest.Suffix)
+	}
+}
+
+// concat writes the contents of inputs to path, decompressing and
+// recompressing as the extensions say.
+func concat(path string, inputs []string) error {
+	out, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	for _, in := range inputs {
+		f, err := zfile.Open(in)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(out, f)
+		f.Close()
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("%s: %v", in, err)
+		}
+	}
+	return out.Close()
+}
+
+func main() {
+	nFlag := flag.Int("n", 8, "number of vertices")
+	minEdges := flag.Int("min", 0, "minimum edges (default: n-1)")
+	maxEdgesFlag := flag.Int("max", 0, "maximum edges (default: 3n-6 for planar)")
+	outputFile := flag.String("out", "", "output file for maximal penny graphs (default: n<N>_maximal.g6)")
+	pennyFile := flag.String("penny-out", "", "also write all penny graphs to this .g6 file")
+	tmpDir := flag.String("tmp", "", "directory for intermediate files (default: tmp_pipeline_n<N>)")
+	binDir := flag.String("bin", ".", "directory containing the pipeline tools (.go sources and .out binaries)")
+	buildTags := flag.String("tags", "", "build tags for the stage binaries, e.g. nauty (they are rebuilt on every run)")
+	jobs := flag.Int("jobs", 1, "edge counts processed concurrently")
+	workers := flag.Int("workers", 0, "workers for verify_penny (default: NumCPU/jobs)")
+	keep := flag.Bool("keep", false, "keep intermediate files")
+	compress := flag.String("compress", "", "compress intermediate files: gz or zst")
+	orderly := flag.Bool("orderly", false, "generate one graph per isomorphism class and skip refine_hash, wl_refine and canonicalize")
+	filters := flag.String("filters", "", "penny graph filter chain for generate_edges and verify_penny (default: theirs)")
+	sequence := flag.Bool("sequence", false, "print the counts per edge count as comma-separated sequences")
+	checkOEIS := flag.Bool("oeis", false, "with -sequence: compare the largest penny edge count with OEIS A047932 and exit 1 on a mismatch")
+	jsonOut := flag.Bool("json", false, "write events (start, stage, edges, result) as JSON lines on stdout; text goes to stderr")
+	flag.Parse()
+	events := jsonl.Start("all_in_one", *jsonOut)
+
+	n := *nFlag
+	if n < 2 {
+		fmt.Println("Error: n must be an integer >= 2")
+		os.Exit(1)
+	}
+	numEdges := n * (n - 1) / 2
+
+	minE := *minEdges
+	if minE == 0 {
+		minE = n - 1
+	}
+	maxE := *maxEdgesFlag
+	if maxE == 0 {
+		maxE = 3*n - 6
+	}
+	if maxE > numEdges {
+		maxE = numEdges
+	}
+	if minE > maxE {
+		fmt.Printf("Error: empty edge range %d..%d\n", minE, maxE)
+		os.Exit(1)
+	}
+	if *jobs < 1 {
+		*jobs = 1
+	}
+	if *workers == 0 {
+		*workers = runtime.NumCPU() / *jobs
+		if *workers < 1 {
+			*workers = 1
+		}
+	}
+	if *outputFile == "" {
+		*outputFile = fmt.Sprintf("n%d_maximal.g6", n)
+	}
+	// The stages write manifests of their own; the final outputs get this
+	// run's, with the flags it passed down
+	run := manifest.Start("all_in_one", nil)
+	ext := ""
+	switch *compress {
+	case "":
+	case "gz", "zst":
+		ext = "." + *compress
+	default:
+		fmt.Printf("Error: unknown -compress %q (use gz or zst)\n", *compress)
+		os.Exit(1)
+	}
+	if *tmpDir == "" {
+		*tmpDir = fmt.Sprintf("tmp_pipeline_n%d", n)
+	}
+
+	fmt.Printf("=== All-in-one pipeline for n=%d ===\n", n)
+	fmt.Printf("Edge range: %d to %d\n", minE, maxE)
+	fmt.Printf("Concurrent edge counts: %d, verify workers: %d\n", *jobs, *workers)
+	fmt.Printf("Intermediate files: %s\n\n", *tmpDir)
+	events.Emit("start", jsonl.Fields{"n": n, "min_edges": minE, "max_edges": maxE, "orderly": *orderly,
+		"jobs": *jobs, "workers": *workers, "tmp": *tmpDir, "out": *outputFile})
+
+	if err := ensureTools(*binDir, *buildTags); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// A -tmp that already exists may hold other files, the outputs among
+	// them, so only a directory this run creates is removed at the end
+	_, statErr := os.Stat(*tmpDir)
+	createdTmp := os.IsNotExist(statErr)
+	if err := os.MkdirAll(*tmpDir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %v\n", *tmpDir, err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	ns := strconv.Itoa(n)
+
+	// All candidate files come out of a single generate_edges traversal
+	fmt.Println("Generating candidates...")
+	genArgs := []string{"-min", strconv.Itoa(minE), "-max", strconv.Itoa(maxE), ns,
+		filepath.Join(*tmpDir, fmt.Sprintf("n%d_%%d_edges.bin%s", n, ext))}
+	if *orderly {
+		genArgs = append([]string{"-orderly"}, genArgs...)
+	}
+	var filterArgs []string
+	if *filters != "" {
+		filterArgs = []string{"-filters", *filters}
+		genArgs = append(filterArgs, genArgs...)
+	}
+	genLog, err := os.Create(filepath.Join(*tmpDir, fmt.Sprintf("n%d_generate_log.txt", n)))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	err = runStage(*binDir, genLog, "generate_edges", genArgs...)
+	genLog.Close()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("  done (%v)\n\n", time.Since(start).Round(time.Millisecond))
+	events.Emit("stage", jsonl.Fields{"stage": "generate_edges", "seconds": time.Since(start).Seconds()})
+
+	process := func(e int) stageResult {
+		res := stageResult{edges: e}
+		stageStart := time.Now()
+		prefix := filepath.Join(*tmpDir, fmt.Sprintf("n%d_%d", n, e))
+		candidates := prefix + "_edges.bin" + ext
+		grouped := prefix + "_grouped.bin" + ext
+		groupedWL := prefix + "_grouped_wl.bin" + ext
+		unique := prefix + "_unique"
+		res.pennyFile = prefix + "_penny.g6" + ext
+
+		log, err := os.Create(prefix + "_log.txt")
+		if err != nil {
+			res.err = err
+			return res
+		}
+		defer log.Close()
+
+		steps := [][]string{
+			{"refine_hash", ns, candidates, grouped},
+			{"wl_refine", ns, grouped, groupedWL},
+			{"canonicalize", ns, groupedWL, unique},
+			append([]string{"verify_penny", "-n", ns, "-in", unique + ".bin", "-out", res.pennyFile,
+				"-workers", strconv.Itoa(*workers)}, filterArgs...),
+		}
+		if *orderly {
+			// The candidates are already one per isomorphism class
+			steps = [][]string{
+				append([]string{"verify_penny", "-n", ns, "-in", candidates, "-out", res.pennyFile,
+					"-workers", strconv.Itoa(*workers)}, filterArgs...),
+			}
+		}
+		// Nothing to refine for an edge count without candidates
+		if graphCount(candidates) == 0 {
+			if res.err = writeEmpty(res.pennyFile); res.err != nil {
+				return res
+			}
+			steps = nil
+		}
+		for _, step := range steps {
+			if res.err = runStage(*binDir, log, step[0], step[1:]...); res.err != nil {
+				return res
+			}
+		}
+
+		if *orderly {
+			res.unique = int(graphCount(candidates))
+		} else {
+			res.unique = countLines(unique + ".txt")
+		}
+		res.penny = countLines(res.pennyFile)
+		res.elapsed = time.Since(stageStart)
+
+		if !*keep {
+			removeFiles(candidates, grouped, groupedWL, unique+".bin", unique+".txt")
+		}
+		return res
+	}
+
+	var edgeCounts []int
+	for e := maxE; e >= minE; e-- {
+		edgeCounts = append(edgeCounts, e)
+	}
+
+	results := make([]stageResult, len(edgeCounts))
+	work := make(chan int, len(edgeCounts))
+	for i := range edgeCounts {
+		work <- i
+	}
+	close(work)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for j := 0; j < *jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				res := process(edgeCounts[i])
+				results[i] = res
+				mu.Lock()
+				if res.err != nil {
+					fmt.Printf("  [edges=%d] FAILED: %v\n", res.edges, res.err)
+					events.Emit("edges", jsonl.Fields{"edges": res.edges, "error": res.err.Error()})
+				} else {
+					fmt.Printf("  [edges=%d] %d unique, %d penny (%v)\n",
+						res.edges, res.unique, res.penny, res.elapsed.Round(time.Millisecond))
+					events.Emit("edges", jsonl.Fields{"edges": res.edges, "unique": res.unique, "penny": res.penny,
+						"seconds": res.elapsed.Seconds()})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var pennyFiles []string
+	totalUnique, totalPenny := 0, 0
+	failed := false
+	for _, res := range results {
+		if res.err != nil {
+			failed = true
+			continue
+		}
+		totalUnique += res.unique
+		totalPenny += res.penny
+		if res.penny > 0 {
+			pennyFiles = append(pennyFiles, res.pennyFile)
+		}
+	}
+	if failed {
+		fmt.Printf("\nError: some edge counts failed, intermediate files kept in %s\n", *tmpDir)
+		os.Exit(1)
+	}
+
+	if *pennyFile != "" {
+		if err := concat(*pennyFile, pennyFiles); err != nil {
+			fmt.Printf("Error writing %s: %v\n", *pennyFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("\nWrote %d penny graphs to %s\n", totalPenny, *pennyFile)
+	}
+
+	fmt.Println("\nFiltering maximal graphs...")
+	if len(pennyFiles) == 0 {
+		if err := writeEmpty(*outputFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		log, err := os.Create(filepath.Join(*tmpDir, fmt.Sprintf("n%d_maximal_log.txt", n)))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		args := append([]string{"-n", ns, "-out", *outputFile}, pennyFiles...)
+		err = runStage(*binDir, log, "filter_maximal", args...)
+		log.Close()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	maximal := countLines(*outputFile)
+	if err := run.Wrote(*outputFile, *pennyFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n=== Result ===\n")
+	fmt.Printf("%8s %8s %8s\n", "edges", "unique", "penny")
+	sort.Slice(results, func(i, j int) bool { return results[i].edges < results[j].edges })
+	for _, res := range results {
+		fmt.Printf("%8d %8d %8d\n", res.edges, res.unique, res.penny)
+	}
+	fmt.Printf("Total unique graphs: %d\n", totalUnique)
+	fmt.Printf("Penny graphs: %d\n", totalPenny)
+	fmt.Printf("Maximal penny graphs: %d\n", maximal)
+	fmt.Printf("Output: %s\n", *outputFile)
+	fmt.Printf("Time: %v\n", time.Since(start))
+
+	summary := jsonl.Fields{"unique": totalUnique, "penny": totalPenny, "maximal": maximal,
+		"out": *outputFile, "seconds": time.Since(start).Seconds()}
+	uniqueByEdges, pennyByEdges := map[int]int{}, map[int]int{}
+	for _, res := range results {
+		uniqueByEdges[res.edges] = res.unique
+		pennyByEdges[res.edges] = res.penny
+	}
+	summary["unique_by_edges"], summary["penny_by_edges"] = uniqueByEdges, pennyByEdges
+	if *pennyFile != "" {
+		summary["penny_out"] = *pennyFile
+	}
+
+	mismatch := false
+	if *sequence {
+		var unique, penny []int64
+		maxPenny := -1
+		for _, res := range results {
+			unique = append(unique, int64(res.unique))
+			penny = append(penny, int64(res.penny))
+			if res.penny > 0 {
+				maxPenny = res.edges
+			}
+		}
+		fmt.Printf("\nUnique graphs by edges (%d..%d): %s\n", minE, maxE, oeis.Format(unique))
+		fmt.Printf("Penny graphs by edges (%d..%d): %s\n", minE, maxE, oeis.Format(penny))
+		fmt.Printf("Max penny edges for n=%d: %d\n", n, maxPenny)
+		summary["max_penny_edges"] = maxPenny
+		if *checkOEIS {
+			// The maximum is only established if the range reaches past it
+			if seq, _ := oeis.Lookup("A047932"); maxE <= maxPenny || minE > maxPenny {
+				want, _ := seq.Term(n)
+				fmt.Printf("  A047932: edge range %d..%d doesn't bracket the maximum (expected %d), not checked\n", minE, maxE, want)
+			} else {
+				lines, ok := oeis.Report("A047932", n, []int64{int64(maxPenny)})
+				for _, l := range lines {
+					fmt.Println("  " + l)
+				}
+				mismatch = !ok
+				summary["oeis_ok"] = ok
+			}
+		}
+	}
+	events.Emit("result", summary)
+
+	if !*keep {
+		removeFiles(filepath.Join(*tmpDir, fmt.Sprintf("n%d_generate_log.txt", n)),
+			filepath.Join(*tmpDir, fmt.Sprintf("n%d_maximal_log.txt", n)))
+		for _, res := range results {
+			prefix := filepath.Join(*tmpDir, fmt.Sprintf("n%d_%d", n, res.edges))
+			removeFiles(prefix+"_log.txt", res.pennyFile)
+		}
+		if createdTmp {
+			os.Remove(*tmpDir) // fails, keeping it, if anything else ended up there
+		}
+	}
+	if mismatch {
+		os.Exit(1)
+	}
+}
@@ -1,16 +1,26 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
-	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/extsort"
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/nauty"
+	"hexagon_clink/pkg/prof"
+	"hexagon_clink/pkg/wlcanon"
 )
 
 var n int
@@ -79,101 +89,155 @@ func (g Graph) canonical() Graph {
 }
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: canonicalize <n> <input_grouped_wl.bin> <output_prefix>")
-		fmt.Println("  n: number of vertices")
+	memMB := flag.Int("mem-mb", 0, "keep at most this many MB of canonical forms in memory, spilling sorted runs to disk beyond it (0: no limit)")
+	tmpDir := flag.String("tmp", "", "directory for the spilled runs (default: the system temp directory)")
+	backend := flag.String("canon-backend", "wl", "canonical forms from wl (minimum over the relabelings that keep WL color classes together), brute (minimum bitmask over all n! relabelings, to cross-check wl) or nauty (needs go build -tags nauty)")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address (e.g. localhost:6060) at /debug/pprof/")
+	tracePath := flag.String("trace", "", "write a runtime/trace of the run to this file, for go tool trace")
+	traceFor := flag.Duration("trace-for", time.Minute, "with -trace: stop tracing after this long (0: trace the whole run)")
+	jsonOut := flag.Bool("json", false, "write events (start, progress, result) as JSON lines on stdout; text goes to stderr")
+	flag.Usage = func() {
+		fmt.Println("Usage: canonicalize [-mem-mb MB] [-tmp dir] [-canon-backend wl|brute|nauty] [n] <input_grouped_wl.bin> <output_prefix>")
+		fmt.Println("  n: number of vertices (optional, read from the input header)")
 		fmt.Println("  input_grouped_wl.bin: WL-refined grouped file")
 		fmt.Println("  output_prefix: prefix for output files (creates <prefix>.bin and <prefix>.txt)")
-		os.Exit(1)
+		flag.PrintDefaults()
 	}
-
-	vertices, err := strconv.Atoi(os.Args[1])
-	if err != nil || vertices < 2 {
-		fmt.Println("Error: n must be an integer >= 2")
+	flag.Parse()
+	events := jsonl.Start("canonicalize", *jsonOut)
+	args := flag.Args()
+	if len(args) < 2 || len(args) > 3 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	initEdges(vertices)
-
-	inputFile := os.Args[2]
-	outputPrefix := os.Args[3]
 
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	vertices := 0
+	if len(args) == 3 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 2 {
+			fmt.Println("Error: n must be an integer >= 2")
+			os.Exit(1)
+		}
+		vertices = v
+		args = args[1:]
 	}
 
-	numWorkers := runtime.NumCPU()
-	fmt.Printf("Using %d workers (n=%d, %d bytes/graph)\n", numWorkers, n, bytesPerGraph)
+	inputFile := args[0]
+	outputPrefix := args[1]
+	if err := nauty.CheckBackend(*backend); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	run := manifest.Start("canonicalize", nil)
+	if err := run.Input(inputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *pprofAddr != "" {
+		if err := prof.Serve(*pprofAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("pprof on http://%s/debug/pprof/\n", *pprofAddr)
+	}
+	if *tracePath != "" {
+		if err := prof.StartTrace(*tracePath, *traceFor); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer prof.StopTrace()
+	}
 
-	f, err := os.Open(inputFile)
+	// Groups are streamed from the input and the canonical forms collected
+	// in an external sorter, so neither the input nor the unique set has to
+	// fit in memory when -mem-mb is set.
+	reader, err := graphio.Open(inputFile, graphio.Grouped, vertices)
 	if err != nil {
-		fmt.Printf("Error opening input file: %v\n", err)
+		fmt.Printf("Error reading input file: %v\n", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	reader := bufio.NewReader(f)
-
-	var numGroups uint32
-	binary.Read(reader, binary.LittleEndian, &numGroups)
-	fmt.Printf("Canonicalizing %d groups...\n", numGroups)
-
-	type group struct {
-		graphs []Graph
-	}
-	groups := make([]group, numGroups)
-	totalGraphs := 0
-	for g := uint32(0); g < numGroups; g++ {
-		var size uint32
-		binary.Read(reader, binary.LittleEndian, &size)
-		groups[g].graphs = make([]Graph, size)
-		for i := uint32(0); i < size; i++ {
-			if bytesPerGraph == 4 {
-				var graph uint32
-				binary.Read(reader, binary.LittleEndian, &graph)
-				groups[g].graphs[i] = Graph(graph)
-			} else {
-				var graph uint64
-				binary.Read(reader, binary.LittleEndian, &graph)
-				groups[g].graphs[i] = Graph(graph)
-			}
+	defer reader.Close()
+	header := reader.Header()
+	initEdges(header.N)
+	bytesPerGraph := graphio.Width(n)
+
+	// nauty's and wl's forms label the graph differently than the
+	// brute-force minimum, but equal forms still mean isomorphic graphs
+	canonical := Graph.canonical
+	switch *backend {
+	case "nauty":
+		if n > nauty.MaxN {
+			fmt.Printf("Error: n=%d is too large for the nauty backend (max %d)\n", n, nauty.MaxN)
+			os.Exit(1)
+		}
+		canonical = func(g Graph) Graph { return Graph(nauty.Canonical(n, uint64(g))) }
+	case "wl":
+		if n > wlcanon.MaxN {
+			fmt.Printf("Error: n=%d is too large for the wl backend (max %d)\n", n, wlcanon.MaxN)
+			os.Exit(1)
 		}
-		totalGraphs += int(size)
+		canonical = func(g Graph) Graph { return Graph(wlcanon.Canonical(n, uint64(g))) }
 	}
-	fmt.Printf("Loaded %d graphs in %d groups\n", totalGraphs, numGroups)
 
+	numWorkers := runtime.NumCPU()
+	fmt.Printf("Using %d workers (n=%d, %d bytes/graph, %s canonical forms)\n", numWorkers, n, bytesPerGraph, *backend)
+
+	numGroups := "?"
+	if header.Groups != graphio.Streamed {
+		numGroups = strconv.FormatUint(header.Groups, 10)
+	}
+	fmt.Printf("Canonicalizing %s groups...\n", numGroups)
+
+	startFields := jsonl.Fields{"n": n, "input": inputFile, "output": outputPrefix + ".bin", "backend": *backend, "workers": numWorkers}
+	if header.Groups != graphio.Streamed {
+		startFields["groups"] = header.Groups
+	}
+	events.Emit("start", startFields)
 	start := time.Now()
 	var canonCalls atomic.Int64
 	var groupsDone atomic.Int64
+	var totalGraphs int64
 
-	results := make(chan map[Graph]bool, numGroups)
-	groupChan := make(chan int, numGroups)
+	results := make(chan map[Graph]bool, numWorkers*2)
+	groupChan := make(chan graphio.Graphs, numWorkers*2)
 
 	var wg sync.WaitGroup
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for gIdx := range groupChan {
+			for group := range groupChan {
 				seen := make(map[Graph]bool)
-				for _, gr := range groups[gIdx].graphs {
+				for i := 0; i < group.Len(); i++ {
 					canonCalls.Add(1)
-					canon := gr.canonical()
+					canon := canonical(Graph(group.At(i)))
 					seen[canon] = true
 				}
 				results <- seen
 				done := groupsDone.Add(1)
 				if done%50 == 0 {
-					fmt.Printf("  %d/%d groups done (%.1fs)\n", done, numGroups, time.Since(start).Seconds())
+					fmt.Printf("  %d/%s groups done (%.1fs)\n", done, numGroups, time.Since(start).Seconds())
+					events.Emit("progress", jsonl.Fields{"groups_done": done, "canonical_calls": canonCalls.Load()})
 				}
 			}
 		}()
 	}
 
+	var readErr error
 	go func() {
-		for i := 0; i < int(numGroups); i++ {
-			groupChan <- i
+		defer close(groupChan)
+		for {
+			group, err := reader.NextGroupView()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			totalGraphs += int64(group.Len())
+			groupChan <- group
 		}
-		close(groupChan)
 	}()
 
 	go func() {
@@ -181,44 +245,68 @@ func main() {
 		close(results)
 	}()
 
-	allUnique := make(map[Graph]bool)
+	sorter := extsort.New(*tmpDir, extsort.LimitForMB(*memMB))
+	defer sorter.Close()
+	var addErr error
 	for seen := range results {
 		for g := range seen {
-			allUnique[g] = true
+			if err := sorter.Add(uint64(g)); err != nil && addErr == nil {
+				addErr = err
+			}
 		}
 	}
+	if readErr != nil {
+		fmt.Printf("Error reading input file: %v\n", readErr)
+		os.Exit(1)
+	}
+	if addErr != nil {
+		fmt.Printf("Error spilling canonical forms: %v\n", addErr)
+		os.Exit(1)
+	}
 
 	fmt.Printf("\nDone in %v\n", time.Since(start))
 	fmt.Printf("Total graphs: %d\n", totalGraphs)
 	fmt.Printf("Canonical calls: %d\n", canonCalls.Load())
-	fmt.Printf("Unique graphs: %d\n", len(allUnique))
+	if sorter.Runs() > 0 {
+		fmt.Printf("Spilled %d canonical forms to %d sorted runs\n", sorter.Spilled, sorter.Runs())
+	}
 
-	outFile, err := os.Create(outputPrefix + ".bin")
+	writer, err := graphio.Create(outputPrefix+".bin", graphio.Derive(header, graphio.Raw, "canonicalize", map[string]string{"canon": *backend, "order": "sorted"}))
 	if err != nil {
 		fmt.Printf("Error creating output file: %v\n", err)
 		os.Exit(1)
 	}
-	writer := bufio.NewWriter(outFile)
-	for g := range allUnique {
-		if bytesPerGraph == 4 {
-			binary.Write(writer, binary.LittleEndian, uint32(g))
-		} else {
-			binary.Write(writer, binary.LittleEndian, uint64(g))
-		}
+	txtFile, err := os.Create(outputPrefix + ".txt")
+	if err != nil {
+		fmt.Printf("Error creating output file: %v\n", err)
+		os.Exit(1)
 	}
-	writer.Flush()
-	outFile.Close()
-	fmt.Printf("Wrote %d unique graphs to %s.bin\n", len(allUnique), outputPrefix)
-
-	txtFile, _ := os.Create(outputPrefix + ".txt")
-	var sorted []Graph
-	for g := range allUnique {
-		sorted = append(sorted, g)
+	txt := bufio.NewWriter(txtFile)
+	unique := 0
+	err = sorter.Each(func(g uint64) error {
+		unique++
+		fmt.Fprintf(txt, "%d\n", g)
+		return writer.Write(g)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = txt.Flush()
 	}
-	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
-	for _, g := range sorted {
-		fmt.Fprintf(txtFile, "%d\n", g)
+	if cerr := txtFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Printf("Error writing output files: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Wrote(outputPrefix+".bin", outputPrefix+".txt"); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
-	txtFile.Close()
-	fmt.Printf("Wrote %d unique graphs to %s.txt\n", len(allUnique), outputPrefix)
+	fmt.Printf("Unique graphs: %d\n", unique)
+	fmt.Printf("Wrote %d unique graphs to %s.bin and %s.txt\n", unique, outputPrefix, outputPrefix)
+	events.Emit("result", jsonl.Fields{"graphs": totalGraphs, "unique": unique, "canonical_calls": canonCalls.Load(),
+		"spilled_runs": sorter.Runs(), "outputs": []string{outputPrefix + ".bin", outputPrefix + ".txt"}, "seconds": time.Since(start).Seconds()})
 }
@@ -1,13 +1,23 @@
+//go:build ignore
+
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/bliss"
+	"hexagon_clink/pkg/externaltools"
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
 )
 
 var n int
@@ -54,129 +64,207 @@ func (g Graph) toDIMACS() string {
 	return result
 }
 
+// benchBinding canonicalizes every graph in-process through pkg/bliss on
+// all CPUs
+func benchBinding(graphs []Graph) (int, time.Duration) {
+	start := time.Now()
+	forms := make([]uint64, len(graphs))
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	numWorkers := runtime.NumCPU()
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(graphs); i += numWorkers {
+				cg, err := bliss.CanonicalGraph(invariants.FromMask(n, uint64(graphs[i])))
+				if err != nil {
+					fmt.Printf("Error on graph %d: %v\n", i, err)
+					os.Exit(1)
+				}
+				forms[i] = cg.Mask()
+				if d := done.Add(1); d%500000 == 0 {
+					fmt.Printf("  %d/%d graphs (%.0f/sec)\n", d, len(graphs), float64(d)/time.Since(start).Seconds())
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	unique := make(map[uint64]bool)
+	for _, f := range forms {
+		unique[f] = true
+	}
+	return len(unique), elapsed
+}
+
+// benchCLI writes the DIMACS files for all graphs in one batch, then runs
+// the bliss command on them from all CPUs. The command still takes one
+// graph per process, so this mostly measures process startup
+func benchCLI(graphs []Graph) (int, time.Duration) {
+	dir, err := os.MkdirTemp("", "bench_bliss")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	genStart := time.Now()
+	paths := make([]string, len(graphs))
+	for i, g := range graphs {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("g%d.dimacs", i))
+		if err := os.WriteFile(paths[i], []byte(g.toDIMACS()), 0o644); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("  Wrote %d DIMACS files in %v\n", len(graphs), time.Since(genStart))
+
+	start := time.Now()
+	outputs := make([]string, len(graphs))
+	var done, failed atomic.Int64
+	var wg sync.WaitGroup
+	numWorkers := runtime.NumCPU()
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(graphs); i += numWorkers {
+				// Run bliss with canonical hash output
+				output, err := externaltools.Output("bliss", "-canonical", paths[i])
+				if err != nil {
+					fmt.Printf("Error on graph %d: %v\n", i, err)
+					failed.Add(1)
+					continue
+				}
+				outputs[i] = string(output)
+				if d := done.Add(1); d%1000 == 0 {
+					fmt.Printf("  %d/%d graphs (%.0f/sec)\n", d, len(graphs), float64(d)/time.Since(start).Seconds())
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	// A graph without an output would drop out of the unique count
+	if f := failed.Load(); f > 0 {
+		fmt.Printf("Error: bliss failed on %d of %d graphs\n", f, len(graphs))
+		os.Exit(1)
+	}
+
+	unique := make(map[string]bool)
+	for _, out := range outputs {
+		unique[out] = true
+	}
+	return len(unique), elapsed
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: bench_bliss <input.bin> <n>")
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: bench_bliss <input.bin> [n] [raw|grouped] [--cli] [--limit=N] [--json]")
 		fmt.Println("  Benchmarks bliss on binary graph file")
+		fmt.Println("  n and the format are read from the file header; files without a header need both")
+		fmt.Println("  Built with -tags bliss, graphs go through the bliss C library in-process;")
+		fmt.Println("  otherwise (or with --cli) the bliss command runs once per graph")
+		fmt.Println("  --limit=N caps the number of graphs (default: all in-process, 10000 with the command)")
+		fmt.Println("  --json writes events (start, result) as JSON lines on stdout; text goes to stderr")
 		fmt.Println("")
 		fmt.Println("Install bliss: brew install bliss")
 		os.Exit(1)
 	}
 
 	inputFile := os.Args[1]
-	vertices, _ := strconv.Atoi(os.Args[2])
-	initEdges(vertices)
-
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	vertices := 0
+	var kind graphio.Kind
+	useCLI := !bliss.Available
+	limit := -1
+	jsonOut := false
+	for _, arg := range os.Args[2:] {
+		if arg == "--cli" {
+			useCLI = true
+			continue
+		}
+		if arg == "--json" {
+			jsonOut = true
+			continue
+		}
+		if l, ok := strings.CutPrefix(arg, "--limit="); ok {
+			v, err := strconv.Atoi(l)
+			if err != nil || v < 1 {
+				fmt.Printf("Error: bad --limit %q\n", l)
+				os.Exit(1)
+			}
+			limit = v
+			continue
+		}
+		if k, err := graphio.ParseKind(arg); err == nil {
+			kind = k
+			continue
+		}
+		v, err := strconv.Atoi(arg)
+		if err != nil || v < 2 {
+			fmt.Printf("Error: unexpected argument %q (n must be an integer >= 2)\n", arg)
+			os.Exit(1)
+		}
+		vertices = v
 	}
 
-	// Check if bliss exists
-	blissPath, err := exec.LookPath("bliss")
-	if err != nil {
-		fmt.Println("Error: bliss not found. Install with: brew install bliss")
-		os.Exit(1)
+	events := jsonl.Start("bench_bliss", jsonOut)
+	if useCLI {
+		// Check if bliss exists
+		blissPath, err := externaltools.Require("bliss")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Using bliss: %s\n", blissPath)
+	} else {
+		fmt.Println("Using the bliss C library (pkg/bliss)")
 	}
-	fmt.Printf("Using bliss: %s\n", blissPath)
 
 	// Read graphs
-	f, err := os.Open(inputFile)
+	vs, header, err := graphio.ReadAll(inputFile, kind, vertices)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-
-	var graphs []Graph
-	reader := bufio.NewReader(f)
-
-	// Detect format (grouped vs raw)
-	info, _ := f.Stat()
-	fileSize := info.Size()
-	var numGroups uint32
-	binary.Read(reader, binary.LittleEndian, &numGroups)
-
-	if int64(numGroups)*4 > fileSize || numGroups > 10000000 {
-		f.Seek(0, 0)
-		reader = bufio.NewReader(f)
-		buf := make([]byte, bytesPerGraph)
-		for {
-			_, err := reader.Read(buf)
-			if err != nil {
-				break
-			}
-			var g Graph
-			if bytesPerGraph == 4 {
-				g = Graph(binary.LittleEndian.Uint32(buf))
-			} else {
-				g = Graph(binary.LittleEndian.Uint64(buf))
-			}
-			graphs = append(graphs, g)
-		}
-	} else {
-		for i := uint32(0); i < numGroups; i++ {
-			var size uint32
-			binary.Read(reader, binary.LittleEndian, &size)
-			for j := uint32(0); j < size; j++ {
-				var g Graph
-				if bytesPerGraph == 4 {
-					var v uint32
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				} else {
-					var v uint64
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				}
-				graphs = append(graphs, g)
-			}
-		}
+	initEdges(header.N)
+	graphs := make([]Graph, len(vs))
+	for i, v := range vs {
+		graphs[i] = Graph(v)
 	}
 
 	fmt.Printf("Read %d graphs (n=%d)\n", len(graphs), n)
 
 	// Limit for benchmark
-	limit := len(graphs)
-	if limit > 10000 {
+	if limit < 0 && useCLI {
 		limit = 10000
+	}
+	if limit > 0 && len(graphs) > limit {
+		graphs = graphs[:limit]
 		fmt.Printf("Limiting to %d graphs for benchmark\n", limit)
 	}
 
-	// Create temp file for each graph and run bliss
-	fmt.Println("\n=== bliss canonical hash ===")
-	tmpFile := "/tmp/bench_graph.dimacs"
-
-	unique := make(map[string]bool)
-	start := time.Now()
-
-	for i := 0; i < limit; i++ {
-		// Write graph to temp file
-		out, _ := os.Create(tmpFile)
-		fmt.Fprint(out, graphs[i].toDIMACS())
-		out.Close()
-
-		// Run bliss with canonical hash output
-		cmd := exec.Command("bliss", "-canonical", tmpFile)
-		output, err := cmd.Output()
-		if err != nil {
-			fmt.Printf("Error on graph %d: %v\n", i, err)
-			continue
-		}
-
-		// Extract canonical hash from output
-		unique[string(output)] = true
+	method := "library"
+	if useCLI {
+		method = "command"
+	}
+	events.Emit("start", jsonl.Fields{"input": inputFile, "n": n, "graphs": len(graphs), "method": method})
 
-		if (i+1)%1000 == 0 {
-			elapsed := time.Since(start)
-			fmt.Printf("  %d/%d graphs (%.0f/sec)\n", i+1, limit, float64(i+1)/elapsed.Seconds())
-		}
+	var unique int
+	var elapsed time.Duration
+	if useCLI {
+		fmt.Println("\n=== bliss command, one process per graph ===")
+		unique, elapsed = benchCLI(graphs)
+	} else {
+		fmt.Println("\n=== bliss C library ===")
+		unique, elapsed = benchBinding(graphs)
 	}
 
-	elapsed := time.Since(start)
 	fmt.Printf("\nTime: %v\n", elapsed)
-	fmt.Printf("Graphs/sec: %.0f\n", float64(limit)/elapsed.Seconds())
-	fmt.Printf("Unique canonical forms: %d\n", len(unique))
-
-	os.Remove(tmpFile)
+	fmt.Printf("Graphs/sec: %.0f\n", float64(len(graphs))/elapsed.Seconds())
+	fmt.Printf("Unique canonical forms: %d\n", unique)
+	events.Emit("result", jsonl.Fields{"graphs": len(graphs), "unique": unique, "seconds": elapsed.Seconds(),
+		"rate": float64(len(graphs)) / elapsed.Seconds()})
 }
@@ -1,206 +1,126 @@
-package main
-
-/*
-#cgo CFLAGS: -I/opt/homebrew/include
-#cgo LDFLAGS: -L/opt/homebrew/lib -lnauty
-
-#include <nauty.h>
-#include <naututil.h>
-
-// Wrapper to canonicalize a graph
-// adj is the adjacency matrix as a flat array (row-major)
-// n is the number of vertices
-// Returns the canonical labeling hash
-unsigned long canonical_hash(int *adj, int n) {
-    DYNALLSTAT(int, lab, lab_sz);
-    DYNALLSTAT(int, ptn, ptn_sz);
-    DYNALLSTAT(int, orbits, orbits_sz);
-    DYNALLSTAT(graph, g, g_sz);
-    DYNALLSTAT(graph, cg, cg_sz);
-
-    static DEFAULTOPTIONS_GRAPH(options);
-    statsblk stats;
-
-    int m = SETWORDSNEEDED(n);
-    nauty_check(WORDSIZE, m, n, NAUTYVERSIONID);
-
-    DYNALLOC1(int, lab, lab_sz, n, "malloc");
-    DYNALLOC1(int, ptn, ptn_sz, n, "malloc");
-    DYNALLOC1(int, orbits, orbits_sz, n, "malloc");
-    DYNALLOC2(graph, g, g_sz, n, m, "malloc");
-    DYNALLOC2(graph, cg, cg_sz, n, m, "malloc");
-
-    EMPTYGRAPH(g, m, n);
-
-    // Build graph from adjacency matrix
-    for (int i = 0; i < n; i++) {
-        for (int j = i + 1; j < n; j++) {
-            if (adj[i * n + j]) {
-                ADDONEEDGE(g, i, j, m);
-            }
-        }
-    }
-
-    options.getcanon = TRUE;
-    options.defaultptn = TRUE;
-
-    densenauty(g, lab, ptn, orbits, &options, &stats, m, n, cg);
+//go:build ignore
 
-    // Hash the canonical graph
-    unsigned long hash = 0;
-    for (int i = 0; i < n * m; i++) {
-        hash = hash * 31 + cg[i];
-    }
-
-    DYNFREE(lab, lab_sz);
-    DYNFREE(ptn, ptn_sz);
-    DYNFREE(orbits, orbits_sz);
-    DYNFREE(g, g_sz);
-    DYNFREE(cg, cg_sz);
-
-    return hash;
-}
-*/
-import "C"
+package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
-	"unsafe"
-)
 
-var n int
-var numEdges int
-var edgeIndex [][]int
-var edgePairs [][2]int
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/nauty"
+	"hexagon_clink/pkg/zfile"
+)
 
-func initEdges(vertices int) {
-	n = vertices
-	numEdges = n * (n - 1) / 2
-	edgeIndex = make([][]int, n)
-	for i := 0; i < n; i++ {
-		edgeIndex[i] = make([]int, n)
-	}
-	edgePairs = make([][2]int, numEdges)
-	idx := 0
-	for i := 0; i < n; i++ {
-		for j := i + 1; j < n; j++ {
-			edgeIndex[i][j] = idx
-			edgeIndex[j][i] = idx
-			edgePairs[idx] = [2]int{i, j}
-			idx++
+// readGraphs reads a .bin file, or a graph6 file (any n, optionally .gz/.zst)
+func readGraphs(path string, kind graphio.Kind, vertices int) ([]invariants.Graph, error) {
+	if !zfile.HasExt(path, ".g6") {
+		vs, header, err := graphio.ReadAll(path, kind, vertices)
+		if err != nil {
+			return nil, err
+		}
+		graphs := make([]invariants.Graph, len(vs))
+		for i, v := range vs {
+			graphs[i] = invariants.FromMask(header.N, v)
 		}
+		return graphs, nil
 	}
-}
-
-type Graph uint64
-
-func (g Graph) toAdjMatrix() []C.int {
-	adj := make([]C.int, n*n)
-	for idx := 0; idx < numEdges; idx++ {
-		if g&(1<<idx) != 0 {
-			i, j := edgePairs[idx][0], edgePairs[idx][1]
-			adj[i*n+j] = 1
-			adj[j*n+i] = 1
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var graphs []invariants.Graph
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
 		}
+		g, err := invariants.ParseGraph6(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, len(graphs)+1, err)
+		}
+		graphs = append(graphs, g)
 	}
-	return adj
-}
-
-func (g Graph) canonicalHash() uint64 {
-	adj := g.toAdjMatrix()
-	hash := C.canonical_hash((*C.int)(unsafe.Pointer(&adj[0])), C.int(n))
-	return uint64(hash)
+	return graphs, scanner.Err()
 }
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: bench_cgo_nauty <input.bin> <n>")
-		fmt.Println("  Benchmarks nauty via CGO on binary graph file")
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: bench_cgo_nauty <input.bin|input.g6> [n] [raw|grouped] [--json]")
+		fmt.Println("  Benchmarks nauty via CGO on a binary or graph6 graph file")
+		fmt.Println("  n and the format are read from the .bin header; files without a header need both")
+		fmt.Println("  graph6 input may have any n up to nauty's word size")
+		fmt.Println("  --json: write events (start, progress, result) as JSON lines on stdout; text goes to stderr")
 		fmt.Println("")
-		fmt.Println("Requires nauty library: brew install nauty")
+		fmt.Println("Requires the nauty library (brew install nauty) and go build -tags nauty")
 		os.Exit(1)
 	}
 
 	inputFile := os.Args[1]
-	vertices, _ := strconv.Atoi(os.Args[2])
-	initEdges(vertices)
-
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	vertices := 0
+	var kind graphio.Kind
+	jsonOut := false
+	for _, arg := range os.Args[2:] {
+		if arg == "--json" {
+			jsonOut = true
+			continue
+		}
+		if k, err := graphio.ParseKind(arg); err == nil {
+			kind = k
+			continue
+		}
+		v, err := strconv.Atoi(arg)
+		if err != nil || v < 2 {
+			fmt.Printf("Error: unexpected argument %q (n must be an integer >= 2)\n", arg)
+			os.Exit(1)
+		}
+		vertices = v
+	}
+	events := jsonl.Start("bench_cgo_nauty", jsonOut)
+	if err := nauty.CheckBackend("nauty"); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
 
 	// Read graphs
-	f, err := os.Open(inputFile)
+	graphs, err := readGraphs(inputFile, kind, vertices)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-
-	var graphs []Graph
-	reader := bufio.NewReader(f)
-
-	info, _ := f.Stat()
-	fileSize := info.Size()
-	var numGroups uint32
-	binary.Read(reader, binary.LittleEndian, &numGroups)
-
-	if int64(numGroups)*4 > fileSize || numGroups > 10000000 {
-		f.Seek(0, 0)
-		reader = bufio.NewReader(f)
-		buf := make([]byte, bytesPerGraph)
-		for {
-			_, err := reader.Read(buf)
-			if err != nil {
-				break
-			}
-			var g Graph
-			if bytesPerGraph == 4 {
-				g = Graph(binary.LittleEndian.Uint32(buf))
-			} else {
-				g = Graph(binary.LittleEndian.Uint64(buf))
-			}
-			graphs = append(graphs, g)
-		}
-	} else {
-		for i := uint32(0); i < numGroups; i++ {
-			var size uint32
-			binary.Read(reader, binary.LittleEndian, &size)
-			for j := uint32(0); j < size; j++ {
-				var g Graph
-				if bytesPerGraph == 4 {
-					var v uint32
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				} else {
-					var v uint64
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				}
-				graphs = append(graphs, g)
-			}
-		}
+	if len(graphs) == 0 {
+		fmt.Println("Error: no graphs in input")
+		os.Exit(1)
 	}
 
-	fmt.Printf("Read %d graphs (n=%d)\n", len(graphs), n)
+	fmt.Printf("Read %d graphs (n=%d)\n", len(graphs), graphs[0].N)
+	events.Emit("start", jsonl.Fields{"input": inputFile, "graphs": len(graphs), "n": graphs[0].N})
 
+	// Dedup on the whole canonical graph (as graph6), so distinct classes
+	// can't collide the way a hash of it could
 	fmt.Println("\n=== nauty via CGO ===")
-	unique := make(map[uint64]bool)
+	unique := make(map[string]bool)
 	start := time.Now()
 
 	for i, g := range graphs {
-		hash := g.canonicalHash()
-		unique[hash] = true
+		cg, err := nauty.CanonicalGraph(g)
+		if err != nil {
+			fmt.Printf("Error: graph %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		unique[cg.Graph6()] = true
 
 		if (i+1)%50000 == 0 {
 			elapsed := time.Since(start)
 			fmt.Printf("  %d/%d graphs (%.0f/sec)\n", i+1, len(graphs), float64(i+1)/elapsed.Seconds())
+			events.Emit("progress", jsonl.Fields{"done": i + 1, "graphs": len(graphs), "rate": float64(i+1) / elapsed.Seconds()})
 		}
 	}
 
@@ -208,4 +128,6 @@ func main() {
 	fmt.Printf("\nTime: %v\n", elapsed)
 	fmt.Printf("Graphs/sec: %.0f\n", float64(len(graphs))/elapsed.Seconds())
 	fmt.Printf("Unique canonical forms: %d\n", len(unique))
+	events.Emit("result", jsonl.Fields{"graphs": len(graphs), "unique": len(unique), "seconds": elapsed.Seconds(),
+		"rate": float64(len(graphs)) / elapsed.Seconds()})
 }
@@ -1,26 +1,33 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 	"time"
+
+	"hexagon_clink/pkg/externaltools"
+	"hexagon_clink/pkg/jsonl"
 )
 
 // Benchmark nauty's labelg tool for canonical labeling
 // labelg reads graph6 format and outputs canonical graph6
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: bench_nauty <input.g6>")
+	if len(os.Args) < 2 || len(os.Args) > 2 && os.Args[2] != "--json" {
+		fmt.Println("Usage: bench_nauty <input.g6> [--json]")
 		fmt.Println("  Benchmarks nauty's labelg on graph6 file")
+		fmt.Println("  --json: write events (start, bench, result) as JSON lines on stdout; text goes to stderr")
 		fmt.Println("")
 		fmt.Println("Install nauty: brew install nauty")
 		os.Exit(1)
 	}
 
 	inputFile := os.Args[1]
+	events := jsonl.Start("bench_nauty", len(os.Args) > 2)
 
 	// Count graphs
 	f, err := os.Open(inputFile)
@@ -35,87 +42,61 @@ func main() {
 	}
 	f.Close()
 	fmt.Printf("Input: %d graphs\n", count)
+	events.Emit("start", jsonl.Fields{"input": inputFile, "graphs": count})
 
 	// Check if labelg exists
-	_, err = exec.LookPath("labelg")
-	if err != nil {
-		fmt.Println("Error: labelg not found. Install with: brew install nauty")
+	if _, err := externaltools.Require("labelg"); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Run labelg (canonical labeling)
 	fmt.Println("\n=== nauty labelg (canonical labeling) ===")
 	start := time.Now()
-	cmd := exec.Command("labelg", "-q", inputFile)
-	output, err := cmd.Output()
+	output, err := externaltools.Output("labelg", "-q", inputFile)
 	elapsed := time.Since(start)
-
 	if err != nil {
-		fmt.Printf("Error running labelg: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Count unique canonical forms
+	// Count unique canonical forms; labelg writes one per input graph
 	unique := make(map[string]bool)
-	scanner = bufio.NewScanner(bufio.NewReader(
-		&struct{ b []byte }{output},
-	))
-	// Actually parse the output properly
-	lines := 0
-	for i := 0; i < len(output); i++ {
-		if output[i] == '\n' {
-			lines++
-		}
+	lines := strings.Fields(string(output))
+	for _, line := range lines {
+		unique[line] = true
 	}
-
-	// Re-run to get unique count
-	cmd = exec.Command("labelg", "-q", inputFile)
-	cmd.Stdout = nil
-	outPipe, _ := cmd.StdoutPipe()
-	cmd.Start()
-	scanner = bufio.NewScanner(outPipe)
-	for scanner.Scan() {
-		unique[scanner.Text()] = true
+	if len(lines) != count {
+		fmt.Printf("Error: labelg returned %d graphs for %d inputs\n", len(lines), count)
+		os.Exit(1)
 	}
-	cmd.Wait()
 
 	fmt.Printf("Time: %v\n", elapsed)
 	fmt.Printf("Graphs/sec: %.0f\n", float64(count)/elapsed.Seconds())
 	fmt.Printf("Unique canonical forms: %d\n", len(unique))
+	events.Emit("bench", jsonl.Fields{"method": "labelg", "seconds": elapsed.Seconds(), "unique": len(unique)})
+	labelgSeconds := elapsed.Seconds()
 
 	// Also try shortg (removes isomorphic duplicates)
 	fmt.Println("\n=== nauty shortg (deduplicate) ===")
 	start = time.Now()
-	cmd = exec.Command("shortg", "-q", inputFile)
-	output, err = cmd.Output()
+	output, err = externaltools.Output("shortg", "-q", inputFile)
 	elapsed = time.Since(start)
 
 	if err != nil {
-		fmt.Printf("Error running shortg: %v\n", err)
-	} else {
-		// Count output lines
-		outCount := 0
-		for _, b := range output {
-			if b == '\n' {
-				outCount++
-			}
-		}
-		fmt.Printf("Time: %v\n", elapsed)
-		fmt.Printf("Graphs/sec: %.0f\n", float64(count)/elapsed.Seconds())
-		fmt.Printf("Unique graphs: %d\n", outCount)
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
-}
-
-type byteReader struct {
-	b []byte
-	i int
-}
-
-func (r *byteReader) Read(p []byte) (n int, err error) {
-	if r.i >= len(r.b) {
-		return 0, os.ErrClosed
+	// Count output lines
+	outCount := 0
+	for _, b := range output {
+		if b == '\n' {
+			outCount++
+		}
 	}
-	n = copy(p, r.b[r.i:])
-	r.i += n
-	return n, nil
+	fmt.Printf("Time: %v\n", elapsed)
+	fmt.Printf("Graphs/sec: %.0f\n", float64(count)/elapsed.Seconds())
+	fmt.Printf("Unique graphs: %d\n", outCount)
+	events.Emit("bench", jsonl.Fields{"method": "shortg", "seconds": elapsed.Seconds(), "unique": outCount})
+	events.Emit("result", jsonl.Fields{"graphs": count, "unique": outCount, "labelg_seconds": labelgSeconds, "shortg_seconds": elapsed.Seconds()})
 }
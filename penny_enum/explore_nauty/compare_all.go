@@ -1,16 +1,24 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"hexagon_clink/pkg/externaltools"
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/nauty"
+	"hexagon_clink/pkg/wlcanon"
 )
 
 var n int
@@ -39,6 +47,10 @@ func initEdges(vertices int) {
 
 type Graph uint64
 
+// canonFunc is the canonicalization step of our pipeline, Graph.canonical
+// unless --canon-backend=nauty or wl is given
+var canonFunc = Graph.canonical
+
 func (g Graph) canonical() Graph {
 	best := g
 	perm := make([]int, n)
@@ -164,10 +176,7 @@ func (g Graph) wlFingerprint(iterations int) string {
 	}
 
 	for iter := 0; iter < iterations; iter++ {
-		newColors := make([]int, n)
-		colorMap := make(map[string]int)
-		nextColor := 0
-
+		sigs := make([]string, n)
 		for v := 0; v < n; v++ {
 			var neighColors []int
 			for u := 0; u < n; u++ {
@@ -176,17 +185,22 @@ func (g Graph) wlFingerprint(iterations int) string {
 				}
 			}
 			sort.Ints(neighColors)
-			sig := fmt.Sprintf("%d:%v", colors[v], neighColors)
+			sigs[v] = fmt.Sprintf("%d:%v", colors[v], neighColors)
+		}
 
-			if c, ok := colorMap[sig]; ok {
-				newColors[v] = c
-			} else {
-				colorMap[sig] = nextColor
-				newColors[v] = nextColor
-				nextColor++
+		// Number the new colors by sorted signature, not by first vertex
+		// seen, so they don't depend on the vertex order
+		distinct := append([]string(nil), sigs...)
+		sort.Strings(distinct)
+		colorMap := make(map[string]int)
+		for _, sig := range distinct {
+			if _, ok := colorMap[sig]; !ok {
+				colorMap[sig] = len(colorMap)
 			}
 		}
-		colors = newColors
+		for v := 0; v < n; v++ {
+			colors[v] = colorMap[sigs[v]]
+		}
 	}
 
 	sorted := make([]int, n)
@@ -217,73 +231,83 @@ func (g Graph) toGraph6() string {
 	return string(result)
 }
 
-func readGraphs(inputFile string) []Graph {
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
-	}
+// graphSet is the input as views of the file, read in place from the
+// mapping rather than copied: one view per group of a grouped file, or the
+// chunks of a raw one. Graph i is numbered across the views.
+type graphSet struct {
+	views  []graphio.Graphs
+	starts []int // index of each view's first graph
+	total  int
+}
 
-	f, _ := os.Open(inputFile)
-	defer f.Close()
+func (s *graphSet) add(v graphio.Graphs) {
+	s.views = append(s.views, v)
+	s.starts = append(s.starts, s.total)
+	s.total += v.Len()
+}
 
-	var graphs []Graph
-	reader := bufio.NewReader(f)
-
-	info, _ := f.Stat()
-	fileSize := info.Size()
-	var numGroups uint32
-	binary.Read(reader, binary.LittleEndian, &numGroups)
-
-	if int64(numGroups)*4 > fileSize || numGroups > 10000000 {
-		f.Seek(0, 0)
-		reader = bufio.NewReader(f)
-		buf := make([]byte, bytesPerGraph)
-		for {
-			_, err := reader.Read(buf)
-			if err != nil {
-				break
-			}
-			var g Graph
-			if bytesPerGraph == 4 {
-				g = Graph(binary.LittleEndian.Uint32(buf))
-			} else {
-				g = Graph(binary.LittleEndian.Uint64(buf))
-			}
-			graphs = append(graphs, g)
+// At returns graph i.
+func (s *graphSet) At(i int) Graph {
+	k := sort.Search(len(s.starts), func(k int) bool { return s.starts[k] > i }) - 1
+	return Graph(s.views[k].At(i - s.starts[k]))
+}
+
+// each calls f on every graph in order.
+func (s *graphSet) each(f func(g Graph)) {
+	for _, v := range s.views {
+		for i := 0; i < v.Len(); i++ {
+			f(Graph(v.At(i)))
 		}
-	} else {
-		for i := uint32(0); i < numGroups; i++ {
-			var size uint32
-			binary.Read(reader, binary.LittleEndian, &size)
-			for j := uint32(0); j < size; j++ {
-				var g Graph
-				if bytesPerGraph == 4 {
-					var v uint32
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				} else {
-					var v uint64
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				}
-				graphs = append(graphs, g)
-			}
+	}
+}
+
+// truncate keeps the first graphs, at most limit of them: whole views if
+// whole is set (the groups of a grouped file), else cutting the last one.
+func (s *graphSet) truncate(limit int, whole bool) {
+	for k, v := range s.views {
+		if s.starts[k]+v.Len() <= limit {
+			continue
+		}
+		if whole || s.starts[k] == limit {
+			s.views, s.starts, s.total = s.views[:k], s.starts[:k], s.starts[k]
+		} else {
+			s.views[k] = v.Slice(0, limit-s.starts[k])
+			s.views, s.starts, s.total = s.views[:k+1], s.starts[:k+1], limit
 		}
+		return
+	}
+}
+
+// readGraphs maps a raw file; the views stay valid until the reader is
+// closed.
+func readGraphs(inputFile string) (*graphio.Reader, *graphSet, error) {
+	r, err := graphio.Open(inputFile, graphio.Raw, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	chunks, err := r.Chunks()
+	if err != nil {
+		r.Close()
+		return nil, nil, err
 	}
-	return graphs
+	graphs := &graphSet{}
+	for _, c := range chunks {
+		graphs.add(c)
+	}
+	return r, graphs, nil
 }
 
 // Our optimized pipeline: fingerprint -> WL -> canonical on groups
-func benchOurPipeline(graphs []Graph) (int, time.Duration) {
+func benchOurPipeline(graphs *graphSet) (int, time.Duration) {
 	numWorkers := runtime.NumCPU()
 	start := time.Now()
 
 	// Step 1: Fingerprint grouping
 	fpGroups := make(map[string][]Graph)
-	for _, g := range graphs {
+	graphs.each(func(g Graph) {
 		fp := g.fingerprint()
 		fpGroups[fp] = append(fpGroups[fp], g)
-	}
+	})
 
 	// Step 2: WL refinement
 	type group struct {
@@ -313,7 +337,7 @@ func benchOurPipeline(graphs []Graph) (int, time.Duration) {
 			for gIdx := range groupChan {
 				seen := make(map[Graph]bool)
 				for _, gr := range wlGroups[gIdx].graphs {
-					canon := gr.canonical()
+					canon := canonFunc(gr)
 					seen[canon] = true
 				}
 				results <- seen
@@ -343,100 +367,125 @@ func benchOurPipeline(graphs []Graph) (int, time.Duration) {
 	return len(allUnique), time.Since(start)
 }
 
-func benchNautyLabelg(graphs []Graph) (int, time.Duration) {
+// writeBenchInput writes graphs to path in graph6, as input for the nauty
+// tools.
+func writeBenchInput(path string, graphs *graphSet) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	graphs.each(func(g Graph) {
+		fmt.Fprintln(w, g.toGraph6())
+	})
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func benchNautyLabelg(graphs *graphSet) (int, time.Duration, error) {
 	tmpFile := "/tmp/bench_compare.g6"
-	out, _ := os.Create(tmpFile)
-	for _, g := range graphs {
-		fmt.Fprintln(out, g.toGraph6())
+	if err := writeBenchInput(tmpFile, graphs); err != nil {
+		return 0, 0, err
 	}
-	out.Close()
+	defer os.Remove(tmpFile)
 
 	start := time.Now()
-	cmd := exec.Command("labelg", "-q", tmpFile)
-	outPipe, _ := cmd.StdoutPipe()
-	cmd.Start()
+	cmd, err := externaltools.Command("labelg", "-q", tmpFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	outPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, 0, err
+	}
 
 	unique := make(map[string]bool)
+	lines := 0
 	scanner := bufio.NewScanner(outPipe)
 	for scanner.Scan() {
 		unique[scanner.Text()] = true
+		lines++
+	}
+	if err := cmd.Wait(); err != nil {
+		return 0, 0, err
 	}
-	cmd.Wait()
 	elapsed := time.Since(start)
-
-	os.Remove(tmpFile)
-	return len(unique), elapsed
+	if lines != graphs.total {
+		return 0, 0, fmt.Errorf("labelg returned %d graphs for %d inputs", lines, graphs.total)
+	}
+	return len(unique), elapsed, nil
 }
 
-func benchNautyShortg(graphs []Graph) (int, time.Duration) {
+func benchNautyShortg(graphs *graphSet) (int, time.Duration, error) {
 	tmpFile := "/tmp/bench_compare.g6"
 	outFile := "/tmp/bench_compare_out.g6"
-	out, _ := os.Create(tmpFile)
-	for _, g := range graphs {
-		fmt.Fprintln(out, g.toGraph6())
+	if err := writeBenchInput(tmpFile, graphs); err != nil {
+		return 0, 0, err
 	}
-	out.Close()
+	defer os.Remove(tmpFile)
+	defer os.Remove(outFile)
 
 	start := time.Now()
-	cmd := exec.Command("shortg", "-q", tmpFile, outFile)
-	cmd.Run()
+	err := externaltools.Retry(func() error {
+		cmd, err := externaltools.Command("shortg", "-q", tmpFile, outFile)
+		if err != nil {
+			return err
+		}
+		return cmd.Run()
+	})
+	if err != nil {
+		return 0, 0, err
+	}
 	elapsed := time.Since(start)
 
 	// Count lines in output file
-	f, _ := os.Open(outFile)
+	f, err := os.Open(outFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
 	count := 0
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		count++
 	}
-	f.Close()
-
-	os.Remove(tmpFile)
-	os.Remove(outFile)
-	return count, elapsed
+	return count, elapsed, scanner.Err()
 }
 
-// Read pre-grouped WL file and only benchmark the canonicalization step
-func readGroupedWL(inputFile string) [][]Graph {
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
-	}
-
-	f, _ := os.Open(inputFile)
-	defer f.Close()
-	reader := bufio.NewReader(f)
-
-	var numGroups uint32
-	binary.Read(reader, binary.LittleEndian, &numGroups)
-
-	groups := make([][]Graph, numGroups)
-	for i := uint32(0); i < numGroups; i++ {
-		var size uint32
-		binary.Read(reader, binary.LittleEndian, &size)
-		groups[i] = make([]Graph, size)
-		for j := uint32(0); j < size; j++ {
-			if bytesPerGraph == 4 {
-				var v uint32
-				binary.Read(reader, binary.LittleEndian, &v)
-				groups[i][j] = Graph(v)
-			} else {
-				var v uint64
-				binary.Read(reader, binary.LittleEndian, &v)
-				groups[i][j] = Graph(v)
-			}
+// Read pre-grouped WL file and only benchmark the canonicalization step;
+// the groups are views of the mapped file, valid until the reader is closed
+func readGroupedWL(inputFile string) (*graphio.Reader, *graphSet, error) {
+	r, err := graphio.Open(inputFile, graphio.Grouped, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups := &graphSet{}
+	for {
+		group, err := r.NextGroupView()
+		if err == io.EOF {
+			return r, groups, nil
+		}
+		if err != nil {
+			r.Close()
+			return nil, nil, err
 		}
+		groups.add(group)
 	}
-	return groups
 }
 
 // Benchmark just the canonicalization step on pre-grouped data
-func benchCanonicalOnly(groups [][]Graph) (int, time.Duration) {
+func benchCanonicalOnly(groups *graphSet) (int, time.Duration) {
 	numWorkers := runtime.NumCPU()
 	start := time.Now()
 
-	results := make(chan map[Graph]bool, len(groups))
-	groupChan := make(chan int, len(groups))
+	results := make(chan map[Graph]bool, len(groups.views))
+	groupChan := make(chan int, len(groups.views))
 
 	var wg sync.WaitGroup
 	for w := 0; w < numWorkers; w++ {
@@ -445,8 +494,9 @@ func benchCanonicalOnly(groups [][]Graph) (int, time.Duration) {
 			defer wg.Done()
 			for gIdx := range groupChan {
 				seen := make(map[Graph]bool)
-				for _, gr := range groups[gIdx] {
-					canon := gr.canonical()
+				group := groups.views[gIdx]
+				for i := 0; i < group.Len(); i++ {
+					canon := canonFunc(Graph(group.At(i)))
 					seen[canon] = true
 				}
 				results <- seen
@@ -455,7 +505,7 @@ func benchCanonicalOnly(groups [][]Graph) (int, time.Duration) {
 	}
 
 	go func() {
-		for i := range groups {
+		for i := range groups.views {
 			groupChan <- i
 		}
 		close(groupChan)
@@ -476,96 +526,307 @@ func benchCanonicalOnly(groups [][]Graph) (int, time.Duration) {
 	return len(allUnique), time.Since(start)
 }
 
+// nautyLabels returns nauty's canonical form of every graph, through
+// pkg/nauty when built with -tags nauty and through labelg otherwise
+func nautyLabels(graphs *graphSet) ([]string, error) {
+	if nauty.Available {
+		labels := make([]string, 0, graphs.total)
+		graphs.each(func(g Graph) {
+			labels = append(labels, strconv.FormatUint(nauty.Canonical(n, uint64(g)), 10))
+		})
+		return labels, nil
+	}
+	if _, err := externaltools.Require("labelg"); err != nil {
+		return nil, fmt.Errorf("crosscheck needs nauty: build with -tags nauty, or %v", err)
+	}
+	tmp, err := os.CreateTemp("", "crosscheck_*.g6")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	graphs.each(func(g Graph) {
+		fmt.Fprintln(w, g.toGraph6())
+	})
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	tmp.Close()
+
+	// labelg writes one canonical graph per input line, in order
+	out, err := externaltools.Output("labelg", "-q", tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	labels := strings.Fields(string(out))
+	if len(labels) != graphs.total {
+		return nil, fmt.Errorf("labelg returned %d graphs for %d inputs", len(labels), graphs.total)
+	}
+	return labels, nil
+}
+
+// crosscheck classifies the graphs by our canonical form (brute force, or
+// canon for --canon-backend=wl) and by nauty's, and reports every class on which the two disagree, plus every
+// nauty class whose graphs get different fingerprints or WL colorings (our
+// pipeline relies on both being isomorphism invariants). Returns whether
+// everything agreed.
+// events carries the --json events; nil (discarding them) without --json
+var events *jsonl.Emitter
+
+func crosscheck(graphs *graphSet, canon func(Graph) Graph) (bool, error) {
+	start := time.Now()
+	ours := make([]Graph, graphs.total)
+	fps := make([]string, graphs.total)
+	numWorkers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < graphs.total; i += numWorkers {
+				g := graphs.At(i)
+				ours[i] = canon(g)
+				fps[i] = g.fingerprint() + " " + g.wlFingerprint(3)
+			}
+		}(w)
+	}
+	wg.Wait()
+	fmt.Printf("  Our canonical forms: %v\n", time.Since(start))
+
+	start = time.Now()
+	theirs, err := nautyLabels(graphs)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("  nauty canonical forms: %v\n", time.Since(start))
+
+	// Map each class of one side to the classes of the other it meets;
+	// each entry holds the first graph seen with that pair, as an example
+	oursToNauty := make(map[Graph]map[string]int)
+	nautyToOurs := make(map[string]map[Graph]int)
+	nautyFP := make(map[string]map[string]int)
+	for i := range ours {
+		if oursToNauty[ours[i]] == nil {
+			oursToNauty[ours[i]] = make(map[string]int)
+		}
+		if _, ok := oursToNauty[ours[i]][theirs[i]]; !ok {
+			oursToNauty[ours[i]][theirs[i]] = i
+		}
+		if nautyToOurs[theirs[i]] == nil {
+			nautyToOurs[theirs[i]] = make(map[Graph]int)
+			nautyFP[theirs[i]] = make(map[string]int)
+		}
+		if _, ok := nautyToOurs[theirs[i]][ours[i]]; !ok {
+			nautyToOurs[theirs[i]][ours[i]] = i
+		}
+		if _, ok := nautyFP[theirs[i]][fps[i]]; !ok {
+			nautyFP[theirs[i]][fps[i]] = i
+		}
+	}
+	fmt.Printf("  Classes: %d ours, %d nauty\n", len(oursToNauty), len(nautyToOurs))
+
+	examples := func(idx map[string]int) string {
+		var parts []string
+		for _, i := range idx {
+			parts = append(parts, fmt.Sprintf("#%d %s", i, graphs.At(i).toGraph6()))
+		}
+		sort.Strings(parts)
+		if len(parts) > 4 {
+			parts = append(parts[:4], "...")
+		}
+		return strings.Join(parts, ", ")
+	}
+	merged, split, variant := 0, 0, 0
+	for form, classes := range oursToNauty {
+		if len(classes) > 1 {
+			merged++
+			fmt.Printf("  MERGED: our form %d covers %d nauty classes: %s\n", form, len(classes), examples(classes))
+			events.Emit("disagreement", jsonl.Fields{"kind": "merged", "form": form, "classes": len(classes), "examples": examples(classes)})
+		}
+	}
+	for label, classes := range nautyToOurs {
+		if len(classes) > 1 {
+			split++
+			idx := make(map[string]int, len(classes))
+			for form, i := range classes {
+				idx[strconv.FormatUint(uint64(form), 10)] = i
+			}
+			fmt.Printf("  SPLIT: nauty class %s has %d of our forms: %s\n", label, len(classes), examples(idx))
+			events.Emit("disagreement", jsonl.Fields{"kind": "split", "nauty": label, "classes": len(classes), "examples": examples(idx)})
+		}
+		if len(nautyFP[label]) > 1 {
+			variant++
+			fmt.Printf("  NOT INVARIANT: nauty class %s gets %d fingerprint/WL values: %s\n", label, len(nautyFP[label]), examples(nautyFP[label]))
+			events.Emit("disagreement", jsonl.Fields{"kind": "not_invariant", "nauty": label, "classes": len(nautyFP[label]), "examples": examples(nautyFP[label])})
+		}
+	}
+	fmt.Printf("  Disagreements: %d merged, %d split, %d fingerprint/WL\n", merged, split, variant)
+	events.Emit("crosscheck", jsonl.Fields{"ours": len(oursToNauty), "nauty": len(nautyToOurs),
+		"merged": merged, "split": split, "not_invariant": variant})
+	return merged == 0 && split == 0 && variant == 0, nil
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: compare_all <input.bin> <n> [--raw]")
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: compare_all <input.bin> [n] [--raw] [--canon-backend=brute|wl|nauty] [--crosscheck] [--json]")
 		fmt.Println("  Compares our pipeline vs nauty performance")
 		fmt.Println("")
-		fmt.Println("  If input is *_grouped_wl.bin, compares just canonicalization step")
-		fmt.Println("  Use --raw to force full pipeline comparison on raw graphs")
+		fmt.Println("  If input is a grouped file (*_grouped_wl.bin), compares just canonicalization step")
+		fmt.Println("  n is read from the file header; files without a header need n and")
+		fmt.Println("  are read as grouped unless --raw is given")
+		fmt.Println("  --canon-backend=nauty canonicalizes through libnauty via cgo (build with -tags nauty)")
+		fmt.Println("  --canon-backend=wl only tries the relabelings that keep WL color classes together")
+		fmt.Println("  --crosscheck classifies every graph with our canonical form and with nauty (cgo or")
+		fmt.Println("  labelg) instead of benchmarking, lists the graphs where they disagree and exits 1 if any")
+		fmt.Println("  --json writes events (start, bench, disagreement, crosscheck, result) as JSON lines on")
+		fmt.Println("  stdout; text goes to stderr")
 		os.Exit(1)
 	}
 
 	inputFile := os.Args[1]
-	vertices, _ := strconv.Atoi(os.Args[2])
-	initEdges(vertices)
+	vertices := 0
+	forceRaw := false
+	backend := "brute"
+	crossCheck := false
+	jsonOut := false
+	for _, arg := range os.Args[2:] {
+		if arg == "--raw" {
+			forceRaw = true
+			continue
+		}
+		if arg == "--crosscheck" {
+			crossCheck = true
+			continue
+		}
+		if arg == "--json" {
+			jsonOut = true
+			continue
+		}
+		if b, ok := strings.CutPrefix(arg, "--canon-backend="); ok {
+			backend = b
+			continue
+		}
+		v, err := strconv.Atoi(arg)
+		if err != nil || v < 2 {
+			fmt.Printf("Error: unexpected argument %q (n must be an integer >= 2)\n", arg)
+			os.Exit(1)
+		}
+		vertices = v
+	}
+	events = jsonl.Start("compare_all", jsonOut)
 
-	// Detect if this is a grouped file or raw file
-	isGrouped := len(os.Args) <= 3 // no --raw flag
+	// The header says whether this is a grouped or raw file and gives n;
+	// legacy files are grouped unless --raw is given
+	header, err := graphio.ReadHeader(inputFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := header.Check(0, vertices); !header.Legacy && err != nil {
+		fmt.Printf("Error: %s: %v\n", inputFile, err)
+		os.Exit(1)
+	}
+	if vertices == 0 {
+		vertices = header.N
+	}
+	if vertices == 0 {
+		fmt.Printf("Error: %s does not record n; pass it explicitly\n", inputFile)
+		os.Exit(1)
+	}
+	initEdges(vertices)
+	if err := nauty.CheckBackend(backend); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch backend {
+	case "nauty":
+		canonFunc = func(g Graph) Graph { return Graph(nauty.Canonical(n, uint64(g))) }
+	case "wl":
+		canonFunc = func(g Graph) Graph { return Graph(wlcanon.Canonical(n, uint64(g))) }
+	}
 
-	var graphs []Graph
-	var groups [][]Graph
-	var totalGraphs int
+	isGrouped := header.Kind == graphio.Grouped
+	if header.Legacy {
+		isGrouped = !forceRaw
+	}
 
+	// Either way the graphs are views of the mapped input, not copies
+	var reader *graphio.Reader
+	var graphs *graphSet
 	if isGrouped {
-		// Try reading as grouped format
-		groups = readGroupedWL(inputFile)
-		if len(groups) > 0 && len(groups) < 10000000 {
-			for _, g := range groups {
-				totalGraphs += len(g)
-			}
-			fmt.Printf("Loaded %d graphs in %d pre-grouped WL groups (n=%d)\n\n", totalGraphs, len(groups), n)
-		} else {
-			isGrouped = false
+		reader, graphs, err = readGroupedWL(inputFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded %d graphs in %d pre-grouped WL groups (n=%d)\n\n", graphs.total, len(graphs.views), n)
+	} else {
+		reader, graphs, err = readGraphs(inputFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Printf("Loaded %d raw graphs (n=%d)\n\n", graphs.total, n)
 	}
+	defer reader.Close()
+	events.Emit("start", jsonl.Fields{"input": inputFile, "n": n, "grouped": isGrouped, "graphs": graphs.total,
+		"backend": backend, "crosscheck": crossCheck})
 
-	if !isGrouped {
-		graphs = readGraphs(inputFile)
-		totalGraphs = len(graphs)
-		fmt.Printf("Loaded %d raw graphs (n=%d)\n\n", totalGraphs, n)
+	if crossCheck {
+		fmt.Println("=== Cross-check: our canonical forms vs nauty ===")
+		canon := Graph.canonical
+		if backend == "wl" {
+			canon = canonFunc
+		}
+		ok, err := crosscheck(graphs, canon)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		events.Emit("result", jsonl.Fields{"graphs": graphs.total, "agree": ok})
+		if !ok {
+			os.Exit(1)
+		}
+		fmt.Println("  All graphs agree")
+		return
 	}
 
-	// Limit for benchmark
-	limit := totalGraphs
-	if limit > 300000 {
-		limit = 300000
-		fmt.Printf("Limiting to %d graphs for benchmark\n\n", limit)
-		if isGrouped {
-			// Truncate groups
-			count := 0
-			for i, g := range groups {
-				if count+len(g) > limit {
-					groups = groups[:i]
-					break
-				}
-				count += len(g)
-			}
-			totalGraphs = count
-		} else {
-			graphs = graphs[:limit]
-			totalGraphs = limit
-		}
+	// Limit for benchmark; groups are kept whole
+	if graphs.total > 300000 {
+		fmt.Printf("Limiting to %d graphs for benchmark\n\n", 300000)
+		graphs.truncate(300000, isGrouped)
 	}
+	totalGraphs := graphs.total
 
 	var ourUnique int
 	var ourTime time.Duration
 
 	if isGrouped {
-		fmt.Println("=== Our canonicalization (on pre-grouped data) ===")
-		ourUnique, ourTime = benchCanonicalOnly(groups)
+		fmt.Printf("=== Our canonicalization (on pre-grouped data, %s) ===\n", backend)
+		ourUnique, ourTime = benchCanonicalOnly(graphs)
 	} else {
-		fmt.Println("=== Our full pipeline (fingerprint + WL + canonical) ===")
+		fmt.Printf("=== Our full pipeline (fingerprint + WL + canonical, %s) ===\n", backend)
 		ourUnique, ourTime = benchOurPipeline(graphs)
 	}
 	fmt.Printf("  Time: %v\n", ourTime)
 	fmt.Printf("  Rate: %.0f graphs/sec\n", float64(totalGraphs)/ourTime.Seconds())
 	fmt.Printf("  Unique: %d\n\n", ourUnique)
-
-	// Flatten groups for nauty comparison
-	if isGrouped && graphs == nil {
-		for _, g := range groups {
-			graphs = append(graphs, g...)
-		}
-	}
+	events.Emit("bench", jsonl.Fields{"method": "ours", "seconds": ourTime.Seconds(), "unique": ourUnique})
+	result := jsonl.Fields{"graphs": totalGraphs, "unique": ourUnique, "seconds": ourTime.Seconds()}
 
 	// Check if nauty is available
-	if _, err := exec.LookPath("labelg"); err == nil {
+	if externaltools.Available("labelg") {
 		fmt.Println("=== nauty labelg ===")
-		nautyUnique, nautyTime := benchNautyLabelg(graphs)
+		nautyUnique, nautyTime, err := benchNautyLabelg(graphs)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Printf("  Time: %v\n", nautyTime)
-		fmt.Printf("  Rate: %.0f graphs/sec\n", float64(len(graphs))/nautyTime.Seconds())
+		fmt.Printf("  Rate: %.0f graphs/sec\n", float64(graphs.total)/nautyTime.Seconds())
 		fmt.Printf("  Unique: %d\n", nautyUnique)
+		events.Emit("bench", jsonl.Fields{"method": "labelg", "seconds": nautyTime.Seconds(), "unique": nautyUnique})
+		result["labelg_seconds"] = nautyTime.Seconds()
 		if nautyTime < ourTime {
 			fmt.Printf("  nauty is %.1fx faster\n\n", ourTime.Seconds()/nautyTime.Seconds())
 		} else {
@@ -573,16 +834,24 @@ func main() {
 		}
 
 		fmt.Println("=== nauty shortg (deduplicate) ===")
-		shortgUnique, shortgTime := benchNautyShortg(graphs)
+		shortgUnique, shortgTime, err := benchNautyShortg(graphs)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Printf("  Time: %v\n", shortgTime)
-		fmt.Printf("  Rate: %.0f graphs/sec\n", float64(len(graphs))/shortgTime.Seconds())
+		fmt.Printf("  Rate: %.0f graphs/sec\n", float64(graphs.total)/shortgTime.Seconds())
 		fmt.Printf("  Unique: %d\n", shortgUnique)
+		events.Emit("bench", jsonl.Fields{"method": "shortg", "seconds": shortgTime.Seconds(), "unique": shortgUnique})
+		result["shortg_seconds"] = shortgTime.Seconds()
 		if shortgTime < ourTime {
 			fmt.Printf("  nauty is %.1fx faster\n", ourTime.Seconds()/shortgTime.Seconds())
 		} else {
 			fmt.Printf("  Our method is %.1fx faster\n", shortgTime.Seconds()/ourTime.Seconds())
 		}
 	} else {
-		fmt.Println("nauty not found. Install with: brew install nauty")
+		_, err := externaltools.Require("labelg")
+		fmt.Printf("Skipping the nauty comparison: %v\n", err)
 	}
+	events.Emit("result", result)
 }
@@ -1,197 +1,317 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/zfile"
 )
 
-var n int
-var numEdges int
-var edgeIndex [][]int
-var edgePairs [][2]int
+// Graph is one graph in a form every format can be written from: graph6
+// input may mix vertex counts and go past the 64-bit edge masks of .bin
+// files.
+type Graph struct {
+	n     int
+	edges [][2]int // (i, j) with i < j
+}
 
-func initEdges(vertices int) {
-	n = vertices
-	numEdges = n * (n - 1) / 2
-	edgeIndex = make([][]int, n)
-	for i := 0; i < n; i++ {
-		edgeIndex[i] = make([]int, n)
-	}
-	edgePairs = make([][2]int, numEdges)
+// fromMask converts an edge bitmask on n vertices, in the pipeline's pair
+// order (0,1), (0,2), ..., (1,2), ...
+func fromMask(n int, mask uint64) Graph {
+	g := Graph{n: n}
 	idx := 0
 	for i := 0; i < n; i++ {
 		for j := i + 1; j < n; j++ {
-			edgeIndex[i][j] = idx
-			edgeIndex[j][i] = idx
-			edgePairs[idx] = [2]int{i, j}
+			if mask&(1<<idx) != 0 {
+				g.edges = append(g.edges, [2]int{i, j})
+			}
 			idx++
 		}
 	}
+	return g
 }
 
-type Graph uint64
+// readGraph6 reads every graph of a .g6 file.
+func readGraph6(path string) ([]Graph, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var graphs []Graph
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30) // a graph6 line grows as n^2/12
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		n, edges, err := graph6.Decode(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		graphs = append(graphs, Graph{n, edges})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return graphs, nil
+}
 
 // toGraph6 converts a graph to graph6 format (used by nauty)
 func (g Graph) toGraph6() string {
-	// Graph6 format:
-	// 1. n encoded as single char (for n < 63: char = n + 63)
-	// 2. Upper triangle of adjacency matrix, 6 bits per char
-
-	result := []byte{byte(n + 63)}
-
-	// Build upper triangle bits
-	var bits []byte
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			if g&(1<<edgeIndex[i][j]) != 0 {
-				bits = append(bits, 1)
-			} else {
-				bits = append(bits, 0)
-			}
-		}
+	return graph6.EncodeEdges(g.n, g.edges)
+}
+
+// toDIMACS converts a graph to DIMACS format (used by bliss)
+func (g Graph) toDIMACS() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "p edge %d %d\n", g.n, len(g.edges))
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "e %d %d\n", e[0]+1, e[1]+1) // DIMACS is 1-indexed
 	}
+	return b.String()
+}
 
-	// Pad to multiple of 6
-	for len(bits)%6 != 0 {
-		bits = append(bits, 0)
+// toEdgeList writes one "i j" line per edge, 0-indexed, after a comment
+// line with the graph's size.
+func (g Graph) toEdgeList(i int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# graph %d: %d vertices, %d edges\n", i, g.n, len(g.edges))
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "%d %d\n", e[0], e[1])
 	}
+	return b.String()
+}
 
-	// Convert to characters
-	for i := 0; i < len(bits); i += 6 {
-		val := bits[i]<<5 | bits[i+1]<<4 | bits[i+2]<<3 | bits[i+3]<<2 | bits[i+4]<<1 | bits[i+5]
-		result = append(result, byte(val+63))
+// toAdjacencyList writes one "v: neighbors" line per vertex, 0-indexed.
+func (g Graph) toAdjacencyList(i int) string {
+	neighbors := make([][]int, g.n)
+	for _, e := range g.edges {
+		neighbors[e[0]] = append(neighbors[e[0]], e[1])
+		neighbors[e[1]] = append(neighbors[e[1]], e[0])
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# graph %d: %d vertices, %d edges\n", i, g.n, len(g.edges))
+	for v, ns := range neighbors {
+		sort.Ints(ns)
+		fmt.Fprintf(&b, "%d:", v)
+		for _, u := range ns {
+			fmt.Fprintf(&b, " %d", u)
+		}
+		b.WriteByte('\n')
 	}
+	return b.String()
+}
 
-	return string(result)
+// toGraphML writes the graph as a <graph> element; the file holds them all
+// in one <graphml> document.
+func (g Graph) toGraphML(i int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  <graph id=\"g%d\" edgedefault=\"undirected\">\n", i)
+	for v := 0; v < g.n; v++ {
+		fmt.Fprintf(&b, "    <node id=\"n%d\"/>\n", v)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(&b, "    <edge source=\"n%d\" target=\"n%d\"/>\n", e[0], e[1])
+	}
+	b.WriteString("  </graph>\n")
+	return b.String()
 }
 
-// toDIMACS converts a graph to DIMACS format (used by bliss)
-func (g Graph) toDIMACS() string {
-	edgeCount := 0
-	for idx := 0; idx < numEdges; idx++ {
-		if g&(1<<idx) != 0 {
-			edgeCount++
+const (
+	graphMLHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns"
+    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+    xsi:schemaLocation="http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd">
+`
+	graphMLFooter = "</graphml>\n"
+)
+
+// toMathematica writes the graph as a Graph[] expression with vertices
+// 0..n-1, the numbering of the other formats and of decode_g6.go.
+func (g Graph) toMathematica() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Graph[Range[0, %d], {", g.n-1)
+	for k, e := range g.edges {
+		if k > 0 {
+			b.WriteString(", ")
 		}
+		fmt.Fprintf(&b, "%d <-> %d", e[0], e[1])
 	}
+	b.WriteString("}]")
+	return b.String()
+}
 
-	result := fmt.Sprintf("p edge %d %d\n", n, edgeCount)
-	for idx := 0; idx < numEdges; idx++ {
-		if g&(1<<idx) != 0 {
-			i, j := edgePairs[idx][0], edgePairs[idx][1]
-			result += fmt.Sprintf("e %d %d\n", i+1, j+1) // DIMACS is 1-indexed
-		}
+// create opens outputFile for writing, compressed if it ends in .gz or .zst.
+func create(outputFile string) (io.WriteCloser, *bufio.Writer) {
+	out, err := zfile.Create(outputFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	return out, bufio.NewWriter(out)
+}
+
+func finish(outputFile string, out io.WriteCloser, w *bufio.Writer) {
+	err := w.Flush()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Printf("Error writing %s: %v\n", outputFile, err)
+		os.Exit(1)
 	}
-	return result
+}
+
+// formatNames says what each output format is called in the messages.
+var formatNames = map[string]string{
+	"g6":          "graph6",
+	"dimacs":      "DIMACS",
+	"dimacs-dir":  "DIMACS",
+	"edges":       "edge list",
+	"adj":         "adjacency list",
+	"graphml":     "GraphML",
+	"mathematica": "Mathematica Graph[]",
 }
 
 func main() {
-	if len(os.Args) < 5 {
-		fmt.Println("Usage: convert <input.bin> <output> <n> <input-format> [output-format]")
-		fmt.Println("  input.bin: binary file with graphs")
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: convert <input.bin|input.g6> <output> [n] [input-format] [output-format] [--json]")
+		fmt.Println("  input: binary file with graphs, or graph6 (any n, mixed n allowed)")
 		fmt.Println("  output: output file")
-		fmt.Println("  n: number of vertices")
-		fmt.Println("  input-format: 'raw' or 'grouped'")
-		fmt.Println("  output-format: 'g6' (default), 'dimacs', or 'dimacs-dir'")
+		fmt.Println("  n: number of vertices (.bin only; read from the header if omitted)")
+		fmt.Println("  input-format: 'raw' or 'grouped' (.bin only; read from the header if omitted)")
+		fmt.Println("  output-format: 'g6' (default), 'dimacs', 'dimacs-dir', 'edges' (\"i j\" per line),")
+		fmt.Println("    'adj' (\"v: neighbors\" per line), 'graphml' or 'mathematica' (a list of Graph[])")
+		fmt.Println("  Files without a header need n and input-format.")
+		fmt.Println("  Paths ending in .gz or .zst are read and written compressed.")
+		fmt.Println("  --json: write events (start, result) as JSON lines on stdout; text goes to stderr")
 		os.Exit(1)
 	}
 
 	inputFile := os.Args[1]
 	outputFile := os.Args[2]
-	vertices, _ := strconv.Atoi(os.Args[3])
-	inputFormat := os.Args[4]
+	vertices := 0
+	var kind graphio.Kind
 	format := "g6"
-	if len(os.Args) > 5 {
-		format = os.Args[5]
-	}
-
-	initEdges(vertices)
-
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	jsonOut := false
+	for _, arg := range os.Args[3:] {
+		if _, ok := formatNames[arg]; ok {
+			format = arg
+			continue
+		}
+		switch arg {
+		case "--json":
+			jsonOut = true
+		case "raw", "grouped":
+			kind, _ = graphio.ParseKind(arg)
+		default:
+			v, err := strconv.Atoi(arg)
+			if err != nil || v < 2 {
+				fmt.Printf("Error: unexpected argument %q (n must be an integer >= 2)\n", arg)
+				os.Exit(1)
+			}
+			vertices = v
+		}
 	}
 
-	f, err := os.Open(inputFile)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
-	}
-	defer f.Close()
-	reader := bufio.NewReader(f)
+	events := jsonl.Start("convert", jsonOut)
+	events.Emit("start", jsonl.Fields{"input": inputFile, "output": outputFile, "format": format})
 
 	var graphs []Graph
-
-	if inputFormat == "raw" {
-		buf := make([]byte, bytesPerGraph)
-		for {
-			_, err := reader.Read(buf)
-			if err != nil {
-				break
-			}
-			var g Graph
-			if bytesPerGraph == 4 {
-				g = Graph(binary.LittleEndian.Uint32(buf))
-			} else {
-				g = Graph(binary.LittleEndian.Uint64(buf))
-			}
-			graphs = append(graphs, g)
-		}
-	} else if inputFormat == "grouped" {
-		var numGroups uint32
-		binary.Read(reader, binary.LittleEndian, &numGroups)
-		for i := uint32(0); i < numGroups; i++ {
-			var size uint32
-			binary.Read(reader, binary.LittleEndian, &size)
-			for j := uint32(0); j < size; j++ {
-				var g Graph
-				if bytesPerGraph == 4 {
-					var v uint32
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				} else {
-					var v uint64
-					binary.Read(reader, binary.LittleEndian, &v)
-					g = Graph(v)
-				}
-				graphs = append(graphs, g)
-			}
+	if zfile.HasExt(inputFile, ".g6") {
+		if vertices != 0 || kind != 0 {
+			fmt.Println("Error: n and input-format only apply to .bin input")
+			os.Exit(1)
+		}
+		var err error
+		graphs, err = readGraph6(inputFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
 	} else {
-		fmt.Printf("Unknown input format: %s (use 'raw' or 'grouped')\n", inputFormat)
-		os.Exit(1)
+		vs, header, err := graphio.ReadAll(inputFile, kind, vertices)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		graphs = make([]Graph, len(vs))
+		for i, v := range vs {
+			graphs[i] = fromMask(header.N, v)
+		}
 	}
 
 	fmt.Printf("Read %d graphs\n", len(graphs))
 
+	if format == "dimacs-dir" {
+		if err := os.MkdirAll(outputFile, 0755); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		for i, g := range graphs {
+			fname := fmt.Sprintf("%s/graph_%06d.dimacs", outputFile, i)
+			if err := os.WriteFile(fname, []byte(g.toDIMACS()), 0644); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("Wrote %d graphs to %s/ in DIMACS format\n", len(graphs), outputFile)
+		events.Emit("result", jsonl.Fields{"graphs": len(graphs), "output": outputFile, "format": format})
+		return
+	}
+
+	out, w := create(outputFile)
 	switch format {
 	case "g6":
-		out, _ := os.Create(outputFile)
-		defer out.Close()
 		for _, g := range graphs {
-			fmt.Fprintln(out, g.toGraph6())
+			fmt.Fprintln(w, g.toGraph6())
 		}
-		fmt.Printf("Wrote %d graphs to %s in graph6 format\n", len(graphs), outputFile)
-
 	case "dimacs":
-		out, _ := os.Create(outputFile)
-		defer out.Close()
 		for i, g := range graphs {
-			fmt.Fprintf(out, "c graph %d\n", i)
-			fmt.Fprint(out, g.toDIMACS())
+			fmt.Fprintf(w, "c graph %d\n", i)
+			fmt.Fprint(w, g.toDIMACS())
 		}
-		fmt.Printf("Wrote %d graphs to %s in DIMACS format\n", len(graphs), outputFile)
-
-	case "dimacs-dir":
-		os.MkdirAll(outputFile, 0755)
+	case "edges", "adj":
 		for i, g := range graphs {
-			fname := fmt.Sprintf("%s/graph_%06d.dimacs", outputFile, i)
-			out, _ := os.Create(fname)
-			fmt.Fprint(out, g.toDIMACS())
-			out.Close()
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			if format == "edges" {
+				fmt.Fprint(w, g.toEdgeList(i))
+			} else {
+				fmt.Fprint(w, g.toAdjacencyList(i))
+			}
 		}
-		fmt.Printf("Wrote %d graphs to %s/ in DIMACS format\n", len(graphs), outputFile)
+	case "graphml":
+		fmt.Fprint(w, graphMLHeader)
+		for i, g := range graphs {
+			fmt.Fprint(w, g.toGraphML(i))
+		}
+		fmt.Fprint(w, graphMLFooter)
+	case "mathematica":
+		// One list expression, so Get[file] returns all graphs
+		fmt.Fprintln(w, "{")
+		for i, g := range graphs {
+			sep := ","
+			if i == len(graphs)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(w, "  %s%s\n", g.toMathematica(), sep)
+		}
+		fmt.Fprintln(w, "}")
 	}
+	finish(outputFile, out, w)
+	fmt.Printf("Wrote %d graphs to %s in %s format\n", len(graphs), outputFile, formatNames[format])
+	events.Emit("result", jsonl.Fields{"graphs": len(graphs), "output": outputFile, "format": format})
 }
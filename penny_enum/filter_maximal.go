@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -7,168 +9,79 @@ import (
 	"os"
 	"sort"
 	"strings"
-)
-
-type Graph uint64
-
-var n int
-var numEdges int
-var edgeIndex [][]int
-var edgePairs [][2]int
-var allPerms [][]int
-
-func initEdges(vertices int) {
-	n = vertices
-	numEdges = n * (n - 1) / 2
-	edgeIndex = make([][]int, n)
-	for i := 0; i < n; i++ {
-		edgeIndex[i] = make([]int, n)
-	}
-	edgePairs = make([][2]int, numEdges)
-	idx := 0
-	for i := 0; i < n; i++ {
-		for j := i + 1; j < n; j++ {
-			edgeIndex[i][j] = idx
-			edgeIndex[j][i] = idx
-			edgePairs[idx] = [2]int{i, j}
-			idx++
-		}
-	}
-	allPerms = permutations(n)
-}
 
-func permutations(n int) [][]int {
-	if n == 0 {
-		return [][]int{{}}
-	}
-	var result [][]int
-	arr := make([]int, n)
-	for i := range arr {
-		arr[i] = i
-	}
-	var generate func(k int)
-	generate = func(k int) {
-		if k == 1 {
-			perm := make([]int, n)
-			copy(perm, arr)
-			result = append(result, perm)
-			return
-		}
-		for i := 0; i < k; i++ {
-			generate(k - 1)
-			if k%2 == 0 {
-				arr[i], arr[k-1] = arr[k-1], arr[i]
-			} else {
-				arr[0], arr[k-1] = arr[k-1], arr[0]
-			}
-		}
-	}
-	generate(n)
-	return result
-}
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/subiso"
+	"hexagon_clink/pkg/zfile"
+)
 
-func (g Graph) edgeCount() int {
-	count := 0
-	tmp := g
-	for tmp != 0 {
-		count += int(tmp & 1)
-		tmp >>= 1
-	}
-	return count
+// Graph is one input graph: graphs of different n can be mixed, so each
+// carries its own vertex count and neighbor bitmasks
+type Graph struct {
+	n   int
+	adj []uint64
+	sig subiso.Signature
 }
 
-// Check if g is isomorphic to a subgraph of other
-func (g Graph) isIsomorphicSubgraphOf(other Graph) bool {
-	for _, perm := range allPerms {
-		var relabeled Graph
-		for idx := 0; idx < numEdges; idx++ {
-			if g&(1<<idx) != 0 {
-				i, j := edgePairs[idx][0], edgePairs[idx][1]
-				ni, nj := perm[i], perm[j]
-				if ni > nj {
-					ni, nj = nj, ni
-				}
-				relabeled |= 1 << edgeIndex[ni][nj]
-			}
-		}
-		// Check if relabeled is a subset of other
-		if relabeled&other == relabeled {
-			return true
-		}
-	}
-	return false
+func (g *Graph) edgeCount() int {
+	return g.sig.Edges
 }
 
-func parseGraph6(line string) Graph {
-	line = strings.TrimSpace(line)
-	if len(line) == 0 {
-		return 0
+// parseGraph6 decodes one graph6 line (n <= 64)
+func parseGraph6(line string) (*Graph, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
 	}
-	nFromLine := int(line[0]) - 63
-	if nFromLine != n {
-		return 0
+	n, err := graph6.Size(line)
+	if err != nil {
+		return nil, err
 	}
-
-	var bits []byte
-	for i := 1; i < len(line); i++ {
-		val := int(line[i]) - 63
-		for b := 5; b >= 0; b-- {
-			bits = append(bits, byte((val>>b)&1))
-		}
+	if n < 1 || n > 64 {
+		return nil, fmt.Errorf("graph6: unsupported vertex count %d", n)
 	}
 
-	var g Graph
-	bitIdx := 0
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			if bitIdx < len(bits) && bits[bitIdx] == 1 {
-				g |= 1 << edgeIndex[i][j]
-			}
-			bitIdx++
-		}
+	g := &Graph{n: n, adj: make([]uint64, n)}
+	if _, err := graph6.Each(line, func(i, j int) {
+		g.adj[i] |= 1 << j
+		g.adj[j] |= 1 << i
+	}); err != nil {
+		return nil, err
 	}
-	return g
+	g.sig = subiso.Sign(g.adj)
+	return g, nil
 }
 
-func (g Graph) toGraph6() string {
-	result := []byte{byte(n + 63)}
-	var bits []byte
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			if g&(1<<edgeIndex[i][j]) != 0 {
-				bits = append(bits, 1)
-			} else {
-				bits = append(bits, 0)
-			}
-		}
-	}
-	for len(bits)%6 != 0 {
-		bits = append(bits, 0)
-	}
-	for i := 0; i < len(bits); i += 6 {
-		val := bits[i]<<5 | bits[i+1]<<4 | bits[i+2]<<3 | bits[i+3]<<2 | bits[i+4]<<1 | bits[i+5]
-		result = append(result, byte(val+63))
-	}
-	return string(result)
+func (g *Graph) toGraph6() string {
+	return graph6.Encode(g.n, func(i, j int) bool { return g.adj[i]&(1<<j) != 0 })
 }
 
 func main() {
-	nFlag := flag.Int("n", 8, "number of vertices")
+	nFlag := flag.Int("n", 0, "only read graphs with this many vertices (default: all, mixed n allowed)")
 	outputFile := flag.String("out", "", "output file for maximal graphs")
+	induced := flag.Bool("induced", false, "drop a graph only if it is an induced subgraph of a larger one")
+	jsonOut := flag.Bool("json", false, "write results as JSON lines on stdout (text goes to stderr)")
 	flag.Parse()
+	events := jsonl.Start("filter_maximal", *jsonOut)
+	run := manifest.Start("filter_maximal", nil)
 
 	if flag.NArg() == 0 {
-		fmt.Println("Usage: filter_maximal -n <vertices> [-out output.g6] <input1.g6> [input2.g6] ...")
-		fmt.Println("  Reads multiple g6 files and outputs only maximal graphs (not subgraph of any other)")
+		fmt.Println("Usage: filter_maximal [-n <vertices>] [-induced] [-out output.g6] <input1.g6> [input2.g6] ...")
+		fmt.Println("  .g6.gz and .g6.zst files are read and written compressed")
+		fmt.Println("  Reads multiple g6 files and outputs only maximal graphs (not subgraph of any other).")
+		fmt.Println("  Inputs may mix vertex counts: a graph is also dropped if it is a subgraph of a")
+		fmt.Println("  maximal graph on more vertices.")
 		os.Exit(1)
 	}
 
-	initEdges(*nFlag)
+	events.Emit("start", jsonl.Fields{"n": *nFlag, "inputs": flag.Args(), "out": *outputFile, "induced": *induced})
 
 	// Read all graphs from all input files
-	var allGraphs []Graph
+	var allGraphs []*Graph
+	sizes := make(map[int]int)
 	for _, inputFile := range flag.Args() {
-		f, err := os.Open(inputFile)
+		f, err := zfile.Open(inputFile)
 		if err != nil {
 			fmt.Printf("Error opening %s: %v\n", inputFile, err)
 			continue
@@ -176,25 +89,63 @@ func main() {
 		scanner := bufio.NewScanner(f)
 		count := 0
 		for scanner.Scan() {
-			g := parseGraph6(scanner.Text())
-			if g != 0 {
-				allGraphs = append(allGraphs, g)
-				count++
+			g, err := parseGraph6(scanner.Text())
+			if err != nil {
+				fmt.Printf("Error reading %s: %v\n", inputFile, err)
+				os.Exit(1)
+			}
+			if g == nil || *nFlag != 0 && g.n != *nFlag {
+				continue
 			}
+			allGraphs = append(allGraphs, g)
+			sizes[g.n]++
+			count++
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Printf("Error reading %s: %v\n", inputFile, err)
+			os.Exit(1)
 		}
 		f.Close()
+		if err := run.Input(inputFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Printf("Read %d graphs from %s\n", count, inputFile)
+		events.Emit("input", jsonl.Fields{"file": inputFile, "graphs": count})
 	}
 
 	fmt.Printf("Total: %d graphs\n", len(allGraphs))
+	mixed := len(sizes) > 1
+	if mixed {
+		var ns []int
+		for v := range sizes {
+			ns = append(ns, v)
+		}
+		sort.Ints(ns)
+		for _, v := range ns {
+			fmt.Printf("  n=%d: %d graphs\n", v, sizes[v])
+		}
+	}
 
-	// Sort by edge count descending (larger graphs first)
+	// Sort by edge count descending (larger graphs first), then by vertex
+	// count, so any graph that could contain another comes before it
 	sort.Slice(allGraphs, func(i, j int) bool {
-		return allGraphs[i].edgeCount() > allGraphs[j].edgeCount()
+		if allGraphs[i].edgeCount() != allGraphs[j].edgeCount() {
+			return allGraphs[i].edgeCount() > allGraphs[j].edgeCount()
+		}
+		return allGraphs[i].n > allGraphs[j].n
 	})
 
-	// Filter: keep only maximal graphs
-	var maximal []Graph
+	contains := subiso.Contains
+	if *induced {
+		contains = subiso.ContainsInduced
+	}
+
+	// Filter: keep only maximal graphs. A graph is compared only with the
+	// maximal graphs whose invariant signature (n, edges, triangles, sorted
+	// degrees) can contain it; the rest go to the subgraph matcher.
+	var maximal []*Graph
+	var compared, matched int
 	for i, g := range allGraphs {
 		if i%100 == 0 {
 			fmt.Printf("\rProcessing %d/%d, maximal so far: %d   ", i, len(allGraphs), len(maximal))
@@ -202,8 +153,13 @@ func main() {
 
 		isSubgraph := false
 		for _, m := range maximal {
-			if g.isIsomorphicSubgraphOf(m) {
+			if !g.sig.FitsIn(m.sig) {
+				continue
+			}
+			compared++
+			if contains(m.adj, g.adj) {
 				isSubgraph = true
+				matched++
 				break
 			}
 		}
@@ -212,34 +168,68 @@ func main() {
 		}
 	}
 	fmt.Printf("\rProcessing %d/%d, maximal: %d           \n", len(allGraphs), len(allGraphs), len(maximal))
+	fmt.Printf("Subgraph tests: %d after the invariant prefilter, %d found\n", compared, matched)
 
-	// Group by edge count for summary
-	byEdges := make(map[int]int)
-	for _, g := range maximal {
-		byEdges[g.edgeCount()]++
+	// Group by size for summary
+	type size struct{ n, edges int }
+	bySize := make(map[size]int)
+	for _, m := range maximal {
+		bySize[size{m.n, m.edgeCount()}]++
 	}
 
-	fmt.Printf("\nMaximal graphs by edge count:\n")
-	var edgeCounts []int
-	for e := range byEdges {
-		edgeCounts = append(edgeCounts, e)
+	var keys []size
+	for k := range bySize {
+		keys = append(keys, k)
 	}
-	sort.Sort(sort.Reverse(sort.IntSlice(edgeCounts)))
-	for _, e := range edgeCounts {
-		fmt.Printf("  %d edges: %d graphs\n", e, byEdges[e])
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].n != keys[j].n {
+			return keys[i].n > keys[j].n
+		}
+		return keys[i].edges > keys[j].edges
+	})
+	if mixed {
+		fmt.Printf("\nMaximal graphs by vertex and edge count:\n")
+		for _, k := range keys {
+			fmt.Printf("  n=%d, %d edges: %d graphs\n", k.n, k.edges, bySize[k])
+		}
+	} else {
+		fmt.Printf("\nMaximal graphs by edge count:\n")
+		for _, k := range keys {
+			fmt.Printf("  %d edges: %d graphs\n", k.edges, bySize[k])
+		}
 	}
 
 	// Write output
 	if *outputFile != "" {
-		out, err := os.Create(*outputFile)
+		out, err := zfile.Create(*outputFile)
 		if err != nil {
 			fmt.Printf("Error creating %s: %v\n", *outputFile, err)
 			os.Exit(1)
 		}
-		for _, g := range maximal {
-			fmt.Fprintln(out, g.toGraph6())
+		w := bufio.NewWriter(out)
+		for _, m := range maximal {
+			fmt.Fprintln(w, m.toGraph6())
+		}
+		w.Flush()
+		if err := out.Close(); err != nil {
+			fmt.Printf("Error writing %s: %v\n", *outputFile, err)
+			os.Exit(1)
+		}
+		if err := run.Wrote(*outputFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		out.Close()
 		fmt.Printf("\nWrote %d maximal graphs to %s\n", len(maximal), *outputFile)
 	}
+
+	if events.Enabled() {
+		var groups []jsonl.Fields
+		for _, k := range keys {
+			groups = append(groups, jsonl.Fields{"n": k.n, "edges": k.edges, "graphs": bySize[k]})
+		}
+		events.Emit("result", jsonl.Fields{
+			"graphs": len(allGraphs), "maximal": len(maximal), "by_size": groups,
+			"compared": compared, "matched": matched, "output": *outputFile,
+		})
+	}
 }
@@ -1,11 +1,23 @@
+//go:build ignore
+
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
+	"math/bits"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"hexagon_clink/pkg/estimate"
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/pennyfilter"
+	"hexagon_clink/pkg/wlcanon"
 )
 
 var n int
@@ -34,170 +46,589 @@ func initEdges(vertices int) {
 
 type Graph uint64
 
-func (g Graph) degrees() []int {
-	deg := make([]int, n)
-	for idx := 0; idx < numEdges; idx++ {
-		if g&(1<<idx) != 0 {
-			deg[edgePairs[idx][0]]++
-			deg[edgePairs[idx][1]]++
-		}
+// isConnected reports whether g is connected, which for a graph with edges
+// also means it has no isolated vertex.
+func (g Graph) isConnected() bool {
+	return g != 0 && invariants.FromMask(n, uint64(g)).Connected()
+}
+
+// lastEdge[v] is the largest edge index touching v. Edges are added in
+// increasing index order, so once that index is passed a vertex's degree is
+// final.
+var lastEdge []int
+
+func initLastEdge() {
+	lastEdge = make([]int, n)
+	for v := 0; v < n-1; v++ {
+		lastEdge[v] = edgeIndex[v][n-1]
 	}
-	return deg
+	lastEdge[n-1] = numEdges - 1
 }
 
-func (g Graph) hasIsolated() bool {
-	deg := g.degrees()
-	for i := 0; i < n; i++ {
-		if deg[i] == 0 {
-			return true
+// canCoverIsolated reports whether the isolated vertices (deg 0) can still
+// all get an edge when only edges from index next on are left and at most
+// budget more edges may be added.
+func canCoverIsolated(deg []int, next, isolated, budget int) bool {
+	if isolated == 0 {
+		return true
+	}
+	if (isolated+1)/2 > budget {
+		return false
+	}
+	for v := 0; v < n; v++ {
+		if deg[v] == 0 && lastEdge[v] < next {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-func (g Graph) isConnected() bool {
-	if g == 0 {
-		return false
+// ---- Orderly generation (Read/Faradzev canonical augmentation) ----
+//
+// A graph is canonical if its bitmask is the largest over all relabelings.
+// Removing the lowest edge bit of a canonical graph leaves a canonical graph,
+// so every isomorphism class is reached exactly once by starting from the
+// empty graph and only adding edges below the current lowest bit, keeping the
+// children that are canonical. Max degree <= 6 and no K4 survive removing an
+// edge, so they prune the tree; connectivity is only checked at the end.
+
+// pairStart[i] is the bit index of edge (i, i+1): edges (a, b) with a < i
+// are exactly the bits below it. pairStart[n-1] = pairStart[n] = numEdges.
+var pairStart []int
+
+func initOrderly() {
+	pairStart = make([]int, n+1)
+	for i := 0; i < n-1; i++ {
+		pairStart[i] = edgeIndex[i][i+1]
+	}
+	pairStart[n-1] = numEdges
+	pairStart[n] = numEdges
+}
+
+type canonChecker struct {
+	g   Graph
+	adj []uint64
+	lab []int // lab[L] = vertex of g given label L
+}
+
+func newCanonChecker() *canonChecker {
+	return &canonChecker{adj: make([]uint64, n), lab: make([]int, n)}
+}
+
+// isCanonical reports whether no relabeling of g has a larger bitmask.
+func (c *canonChecker) isCanonical(g Graph) bool {
+	c.load(g)
+	return c.search(n-1, 0)
+}
+
+func (c *canonChecker) load(g Graph) {
+	c.g = g
+	for v := range c.adj {
+		c.adj[v] = 0
 	}
-	adj := make([]uint64, n)
 	for idx := 0; idx < numEdges; idx++ {
 		if g&(1<<idx) != 0 {
 			i, j := edgePairs[idx][0], edgePairs[idx][1]
-			adj[i] |= 1 << j
-			adj[j] |= 1 << i
+			c.adj[i] |= 1 << j
+			c.adj[j] |= 1 << i
 		}
 	}
-	visited := uint64(1)
-	queue := []int{0}
-	for len(queue) > 0 {
-		node := queue[0]
-		queue = queue[1:]
-		neighbors := adj[node]
-		for neighbor := 0; neighbor < n; neighbor++ {
-			if neighbors&(1<<neighbor) != 0 && visited&(1<<neighbor) == 0 {
-				visited |= 1 << neighbor
-				queue = append(queue, neighbor)
+}
+
+// search assigns label L, with labels above L already placed in c.lab, and
+// compares the relabeled graph with g bit by bit from the top. It returns
+// false as soon as some relabeling is larger than g.
+func (c *canonChecker) search(L int, used uint64) bool {
+	// If g has no edges left below the labels placed so far, every
+	// completion that ties up to here ties all the way down
+	if c.g&(1<<pairStart[L+1]-1) == 0 {
+		return true
+	}
+	for v := 0; v < n; v++ {
+		if used&(1<<v) != 0 {
+			continue
+		}
+		c.lab[L] = v
+		cmp := 0
+		for j := n - 1; j > L; j-- {
+			a := c.adj[v] >> c.lab[j] & 1
+			b := uint64(c.g>>edgeIndex[L][j]) & 1
+			if a != b {
+				cmp = int(a) - int(b)
+				break
 			}
 		}
+		if cmp > 0 {
+			return false
+		}
+		if cmp == 0 && !c.search(L-1, used|1<<v) {
+			return false
+		}
 	}
-	return visited == (1<<n)-1
+	return true
 }
 
-func (g Graph) maxDegree() int {
-	deg := g.degrees()
-	max := 0
-	for i := 0; i < n; i++ {
-		if deg[i] > max {
-			max = deg[i]
+// addKeepsK4Free reports whether adding edge (i, j) keeps the graph with
+// neighbor masks adj K4-free: it must not close a K4 with a triangle of
+// common neighbors, i.e. no common neighbor may be adjacent to another.
+func addKeepsK4Free(adj []uint64, i, j int) bool {
+	common := adj[i] & adj[j]
+	for c := common; c != 0; c &= c - 1 {
+		if adj[bits.TrailingZeros64(c)]&common != 0 {
+			return false
 		}
 	}
-	return max
+	return true
 }
 
-func (g Graph) hasK4() bool {
-	for a := 0; a < n; a++ {
-		for b := a + 1; b < n; b++ {
-			if g&(1<<edgeIndex[a][b]) == 0 {
+// generateOrderly calls emit once for every isomorphism class of graphs with
+// minE..maxE edges that passes the filter chain. It returns the number of
+// canonical graphs visited. Hereditary filters (K4, planarity, ...) also hold
+// for every graph on the way to one that passes, so they cut the tree; the
+// rest are only checked on emitted graphs. Emitted graphs are relabeled to
+// the form canonicalize writes (wlcanon.Canonical, its default backend):
+// the search needs the largest bitmask, but verify_penny's numeric check
+// depends on the labeling, so the output matches the brute-force pipeline
+// exactly.
+func generateOrderly(minE, maxE int, filters pennyfilter.Chain, emit func(edges int, g Graph)) int64 {
+	checker := newCanonChecker()
+	prune := filters.Hereditary()
+	k4 := prune.Has("k4")
+	deg := make([]int, n)
+	adj := make([]uint64, n) // neighbor masks, kept in step with deg
+	var visited int64
+
+	var extend func(g Graph, low, edges int)
+	extend = func(g Graph, low, edges int) {
+		visited++
+		if edges >= minE && g.isConnected() && filters.Accept(n, uint64(g)) {
+			emit(edges, Graph(wlcanon.Canonical(n, uint64(g))))
+		}
+		if edges == maxE {
+			return
+		}
+		// Edges still needed to reach minE; only bits below low are free
+		need := minE - edges
+		if low < need {
+			return
+		}
+		// Every isolated vertex still needs an edge
+		isolated := 0
+		for v := 0; v < n; v++ {
+			if deg[v] == 0 {
+				if v > 0 && v-1 >= low || v == 0 && low == 0 {
+					return
+				}
+				isolated++
+			}
+		}
+		if (isolated+1)/2 > maxE-edges {
+			return
+		}
+		for idx := low - 1; idx >= 0 && idx >= need-1; idx-- {
+			i, j := edgePairs[idx][0], edgePairs[idx][1]
+			if deg[i] == 6 || deg[j] == 6 || k4 && !addKeepsK4Free(adj, i, j) {
 				continue
 			}
-			for c := b + 1; c < n; c++ {
-				if g&(1<<edgeIndex[a][c]) == 0 || g&(1<<edgeIndex[b][c]) == 0 {
-					continue
-				}
-				for d := c + 1; d < n; d++ {
-					if g&(1<<edgeIndex[a][d]) != 0 && g&(1<<edgeIndex[b][d]) != 0 && g&(1<<edgeIndex[c][d]) != 0 {
-						return true
-					}
+			child := g | 1<<idx
+			if !checker.isCanonical(child) {
+				continue
+			}
+			if !prune.Accept(n, uint64(child)) {
+				continue
+			}
+			deg[i]++
+			deg[j]++
+			adj[i] |= 1 << j
+			adj[j] |= 1 << i
+			extend(child, idx, edges+1)
+			deg[i]--
+			deg[j]--
+			adj[i] &^= 1 << j
+			adj[j] &^= 1 << i
+		}
+	}
+	extend(0, numEdges, 0)
+	return visited
+}
+
+// Counters of the estimate: the graphs written with e edges are counted
+// under e (at least 1), all of them under estWritten and the graphs checked
+// by the subset recursion under estChecked.
+const estWritten = 0
+
+func estChecked() int { return numEdges + 1 }
+
+// probeOrderly walks one random path of generateOrderly's tree.
+func probeOrderly(p *estimate.Probe, minE, maxE int, filters pennyfilter.Chain, checker *canonChecker) {
+	prune := filters.Hereditary()
+	k4 := prune.Has("k4")
+	deg := make([]int, n)
+	adj := make([]uint64, n)
+	var g Graph
+	low, edges := numEdges, 0
+	var kids []int
+	for {
+		if edges >= minE && g.isConnected() && filters.Accept(n, uint64(g)) {
+			p.Add(edges)
+			p.Add(estWritten)
+		}
+		if edges == maxE {
+			return
+		}
+		need := minE - edges
+		if low < need {
+			return
+		}
+		isolated := 0
+		for v := 0; v < n; v++ {
+			if deg[v] == 0 {
+				if v > 0 && v-1 >= low || v == 0 && low == 0 {
+					return
 				}
+				isolated++
 			}
 		}
+		if (isolated+1)/2 > maxE-edges {
+			return
+		}
+		kids = kids[:0]
+		for idx := low - 1; idx >= 0 && idx >= need-1; idx-- {
+			i, j := edgePairs[idx][0], edgePairs[idx][1]
+			if deg[i] == 6 || deg[j] == 6 || k4 && !addKeepsK4Free(adj, i, j) {
+				continue
+			}
+			child := g | 1<<idx
+			if checker.isCanonical(child) && prune.Accept(n, uint64(child)) {
+				kids = append(kids, idx)
+			}
+		}
+		if len(kids) == 0 {
+			return
+		}
+		idx := kids[p.Choose(len(kids))]
+		i, j := edgePairs[idx][0], edgePairs[idx][1]
+		deg[i]++
+		deg[j]++
+		adj[i] |= 1 << j
+		adj[j] |= 1 << i
+		g |= 1 << idx
+		low, edges = idx, edges+1
+	}
+}
+
+// probeSubsets walks one random path of main's subset recursion.
+func probeSubsets(p *estimate.Probe, minE, maxE int, filters pennyfilter.Chain) {
+	deg := make([]int, n)
+	var current Graph
+	startIdx, edges := 0, 0
+	var kids []int
+	for {
+		isolated := 0
+		for v := 0; v < n; v++ {
+			if deg[v] == 0 {
+				isolated++
+			}
+		}
+		if edges >= minE {
+			p.Add(estChecked())
+			if isolated == 0 && current.isConnected() && filters.Accept(n, uint64(current)) {
+				p.Add(edges)
+				p.Add(estWritten)
+			}
+		}
+		if edges == maxE || !canCoverIsolated(deg, startIdx, isolated, maxE-edges) {
+			return
+		}
+		need := max(minE-edges, 1)
+		kids = kids[:0]
+		for i := startIdx; i <= numEdges-need; i++ {
+			if deg[edgePairs[i][0]] < 6 && deg[edgePairs[i][1]] < 6 {
+				kids = append(kids, i)
+			}
+		}
+		if len(kids) == 0 {
+			return
+		}
+		i := kids[p.Choose(len(kids))]
+		deg[edgePairs[i][0]]++
+		deg[edgePairs[i][1]]++
+		current |= 1 << i
+		startIdx, edges = i+1, edges+1
+	}
+}
+
+// estimateRun predicts a run from random paths through its search tree
+// (pkg/estimate), probing for budget, instead of making it.
+func estimateRun(budget time.Duration, orderly bool, minE, maxE int, filters pennyfilter.Chain, outputs []string) {
+	est := estimate.New(1)
+	if orderly {
+		initOrderly()
+		checker := newCanonChecker()
+		est.Run(0, budget, func(p *estimate.Probe) { probeOrderly(p, minE, maxE, filters, checker) })
+	} else {
+		initLastEdge()
+		est.Run(0, budget, func(p *estimate.Probe) { probeSubsets(p, minE, maxE, filters) })
+	}
+
+	fmt.Printf("Estimate from %d random paths through the search tree (%d nodes):\n", est.Probes(), est.Walked())
+	fmt.Printf("  Search tree: %s nodes\n", est.Nodes())
+	if !orderly {
+		fmt.Printf("  Graphs checked: %s\n", est.Count(estChecked()))
+	}
+	total := est.Count(estWritten)
+	size := total.Mean * float64(graphio.Width(n))
+	for e := minE; e <= maxE; e++ {
+		size += float64(graphio.HeaderSize)
+		if minE < maxE {
+			fmt.Printf("  %2d edges: %s -> %s\n", e, est.Count(e), outputs[e])
+		}
+	}
+	if orderly {
+		fmt.Printf("  Non-isomorphic candidates: %s\n", total)
+	} else {
+		fmt.Printf("  Candidates: %s\n", total)
 	}
-	return false
+	fmt.Printf("  File size: %.1f MB\n", size/1024/1024)
+	fmt.Printf("  Time: %s\n", est.Time().Duration())
+}
+
+// shardPath returns the output file for graphs with e edges: the %d in
+// pattern replaced by e.
+func shardPath(pattern string, e int) string {
+	return strings.Replace(pattern, "%d", strconv.Itoa(e), 1)
 }
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: generate_edges <n> <edges> <output.bin>")
+	orderly := flag.Bool("orderly", false, "emit one graph per isomorphism class (no refine/canonicalize needed)")
+	minFlag := flag.Int("min", 0, "minimum edges of a range (output must contain %d)")
+	maxFlag := flag.Int("max", 0, "maximum edges of a range (output must contain %d)")
+	filterSpec := flag.String("filters", pennyfilter.Default, "comma-separated penny graph filters ("+pennyfilter.Names()+", or none)")
+	estimateOnly := flag.Bool("estimate", false, "predict the candidates, file size and time from random paths through the search tree, write nothing")
+	estimateTime := flag.Duration("estimate-time", 10*time.Second, "how long -estimate probes (the error shrinks with the square root)")
+	jsonOut := flag.Bool("json", false, "write events (start, progress, result) as JSON lines on stdout; text goes to stderr")
+	flag.Usage = func() {
+		fmt.Println("Usage: generate_edges [-orderly] <n> <edges> <output.bin>")
+		fmt.Printf("       generate_edges [-orderly] -min <edges> -max <edges> <n> <output_%%d.bin>\n")
 		fmt.Println("  n: number of vertices")
 		fmt.Println("  edges: exact number of edges")
 		fmt.Println("  output.bin: output file for candidate graphs")
-		fmt.Println("\nFilters: connected, no isolated vertices, max degree <= 6, no K4")
+		fmt.Println("  -min/-max: every edge count in the range in a single pass, one file")
+		fmt.Printf("             per count (%%d in the output name is replaced by it)\n")
+		fmt.Println("  -orderly: canonical augmentation; the output is already free of")
+		fmt.Println("            isomorphic duplicates and can go straight to verify_penny")
+		fmt.Println("  -estimate: predict the run (Knuth's estimator) instead of making it")
+		fmt.Println("  -json: write events (start, progress, result) as JSON lines on stdout;")
+		fmt.Println("         text goes to stderr")
+		fmt.Printf("  -filters: necessary conditions every graph must pass (default %s)\n", pennyfilter.Default)
+		for _, f := range pennyfilter.Filters {
+			fmt.Printf("      %-7s %s\n", f.Name, f.Doc)
+		}
+		fmt.Println("\nAlways: connected, no isolated vertices, max degree <= 6")
+	}
+	flag.Parse()
+	run := manifest.Start("generate_edges", nil)
+	events := jsonl.Start("generate_edges", *jsonOut)
+	args := flag.Args()
+	ranged := *minFlag != 0 || *maxFlag != 0
+	if ranged && len(args) != 2 || !ranged && len(args) != 3 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	vertices, err := strconv.Atoi(os.Args[1])
-	if err != nil || vertices < 2 {
-		fmt.Println("Error: n must be an integer >= 2")
+	vertices, err := strconv.Atoi(args[0])
+	if err != nil || vertices < 2 || vertices > graphio.MaxN {
+		fmt.Printf("Error: n must be an integer between 2 and %d\n", graphio.MaxN)
 		os.Exit(1)
 	}
 	initEdges(vertices)
-
-	targetEdges, err := strconv.Atoi(os.Args[2])
-	if err != nil || targetEdges < 1 || targetEdges > numEdges {
-		fmt.Printf("Error: edges must be between 1 and %d\n", numEdges)
+	filters, err := pennyfilter.Parse(*filterSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	outputFile := os.Args[3]
+	var minE, maxE int
+	var outputPattern string
+	if ranged {
+		minE, maxE = *minFlag, *maxFlag
+		if minE == 0 {
+			minE = 1
+		}
+		if maxE == 0 {
+			maxE = numEdges
+		}
+		if minE < 1 || maxE > numEdges || minE > maxE {
+			fmt.Printf("Error: edge range must lie within 1..%d\n", numEdges)
+			os.Exit(1)
+		}
+		outputPattern = args[1]
+		if strings.Count(outputPattern, "%d") != 1 {
+			fmt.Printf("Error: with -min/-max the output name must contain %%d once\n")
+			os.Exit(1)
+		}
+	} else {
+		minE, err = strconv.Atoi(args[1])
+		if err != nil || minE < 1 || minE > numEdges {
+			fmt.Printf("Error: edges must be between 1 and %d\n", numEdges)
+			os.Exit(1)
+		}
+		maxE = minE
+		outputPattern = args[2]
+	}
 
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	bytesPerGraph := graphio.Width(n)
+	outputs := make([]string, maxE+1)
+	for e := minE; e <= maxE; e++ {
+		outputs[e] = outputPattern
+		if ranged {
+			outputs[e] = shardPath(outputPattern, e)
+		}
 	}
 
-	fmt.Printf("=== Generating n=%d candidates with %d edges ===\n", n, targetEdges)
-	fmt.Printf("Max possible edges: %d, bytes per graph: %d\n\n", numEdges, bytesPerGraph)
+	if minE == maxE {
+		fmt.Printf("=== Generating n=%d candidates with %d edges ===\n", n, minE)
+	} else {
+		fmt.Printf("=== Generating n=%d candidates with %d to %d edges ===\n", n, minE, maxE)
+	}
+	fmt.Printf("Max possible edges: %d, bytes per graph: %d\n", numEdges, bytesPerGraph)
+	fmt.Printf("Filters: %s\n\n", filters)
+	if *estimateOnly {
+		estimateRun(*estimateTime, *orderly, minE, maxE, filters, outputs)
+		return
+	}
 
-	outFile, err := os.Create(outputFile)
-	if err != nil {
-		fmt.Printf("Error creating output file: %v\n", err)
-		os.Exit(1)
+	// One writer per edge count, all filled by the same traversal
+	writers := make([]*graphio.Writer, maxE+1)
+	for e := minE; e <= maxE; e++ {
+		params := map[string]string{"edges": strconv.Itoa(e)}
+		if *orderly {
+			params["orderly"] = "true"
+		}
+		params["filters"] = filters.String()
+		writers[e], err = graphio.Create(outputs[e], graphio.Header{
+			Kind:   graphio.Raw,
+			N:      n,
+			Stage:  "generate_edges",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Printf("Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var writeErr error
+	written := make([]int, maxE+1)
+	totalWritten := 0
+	write := func(e int, g Graph) {
+		if writeErr == nil {
+			writeErr = writers[e].Write(uint64(g))
+		}
+		written[e]++
+		totalWritten++
 	}
-	defer outFile.Close()
-	writer := bufio.NewWriter(outFile)
 
+	events.Emit("start", jsonl.Fields{"n": n, "min_edges": minE, "max_edges": maxE, "orderly": *orderly,
+		"filters": filters.String(), "outputs": outputs[minE : maxE+1]})
 	start := time.Now()
 	total := 0
-	written := 0
 
-	var generate func(start int, current Graph, remaining int)
-	generate = func(startIdx int, current Graph, remaining int) {
-		if remaining == 0 {
+	// Subsets in lexicographic order of their edge indices: every node of
+	// the recursion is a graph of its own, so all edge counts in the range
+	// come out of one traversal. Branches that can't reach minE are cut, and
+	// so are branches where an edge would push a vertex past degree 6 or an
+	// isolated vertex can no longer get an edge.
+	initLastEdge()
+	deg := make([]int, n)
+	var generate func(start int, current Graph, edges int)
+	generate = func(startIdx int, current Graph, edges int) {
+		isolated := 0
+		for v := 0; v < n; v++ {
+			if deg[v] == 0 {
+				isolated++
+			}
+		}
+		if edges >= minE {
 			total++
-			if !current.hasIsolated() && current.maxDegree() <= 6 && current.isConnected() && !current.hasK4() {
-				if bytesPerGraph == 4 {
-					b := []byte{byte(current), byte(current >> 8), byte(current >> 16), byte(current >> 24)}
-					writer.Write(b)
-				} else {
-					b := []byte{
-						byte(current), byte(current >> 8), byte(current >> 16), byte(current >> 24),
-						byte(current >> 32), byte(current >> 40), byte(current >> 48), byte(current >> 56),
-					}
-					writer.Write(b)
-				}
-				written++
+			if isolated == 0 && current.isConnected() && filters.Accept(n, uint64(current)) {
+				write(edges, current)
 			}
 			if total%10000000 == 0 {
-				fmt.Printf("  Processed %dM, written %d...\n", total/1000000, written)
+				fmt.Printf("  Processed %dM, written %d...\n", total/1000000, totalWritten)
+				events.Emit("progress", jsonl.Fields{"checked": total, "written": totalWritten})
 			}
-			return
 		}
-		if startIdx+remaining > numEdges {
+		if edges == maxE || !canCoverIsolated(deg, startIdx, isolated, maxE-edges) {
 			return
 		}
-		for i := startIdx; i <= numEdges-remaining; i++ {
-			generate(i+1, current|(1<<i), remaining-1)
+		// Leave room for the edges still needed to reach minE
+		need := minE - edges
+		if need < 1 {
+			need = 1
+		}
+		for i := startIdx; i <= numEdges-need; i++ {
+			a, b := edgePairs[i][0], edgePairs[i][1]
+			if deg[a] == 6 || deg[b] == 6 {
+				continue
+			}
+			deg[a]++
+			deg[b]++
+			generate(i+1, current|(1<<i), edges+1)
+			deg[a]--
+			deg[b]--
 		}
 	}
 
-	generate(0, 0, targetEdges)
-	writer.Flush()
+	if *orderly {
+		initOrderly()
+		total = int(generateOrderly(minE, maxE, filters, func(e int, g Graph) {
+			write(e, g)
+			if totalWritten%1000000 == 0 {
+				fmt.Printf("  Written %dM...\n", totalWritten/1000000)
+				events.Emit("progress", jsonl.Fields{"written": totalWritten})
+			}
+		}))
+	} else {
+		generate(0, 0, 0)
+	}
+	for e := minE; e <= maxE; e++ {
+		if err := writers[e].Close(); writeErr == nil {
+			writeErr = err
+		}
+	}
+	if writeErr != nil {
+		fmt.Printf("Error writing output file: %v\n", writeErr)
+		os.Exit(1)
+	}
+	if err := run.Wrote(outputs[minE : maxE+1]...); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	elapsed := time.Since(start)
 	fmt.Printf("\nDone in %v\n", elapsed)
-	fmt.Printf("Total graphs checked: %d\n", total)
-	fmt.Printf("Candidates written: %d\n", written)
+	if *orderly {
+		fmt.Printf("Canonical graphs visited: %d\n", total)
+		fmt.Printf("Non-isomorphic candidates written: %d\n", totalWritten)
+	} else {
+		fmt.Printf("Total graphs checked: %d\n", total)
+		fmt.Printf("Candidates written: %d\n", totalWritten)
+	}
 
-	info, _ := outFile.Stat()
-	fmt.Printf("File size: %.1f MB\n", float64(info.Size())/1024/1024)
+	var size int64
+	for e := minE; e <= maxE; e++ {
+		if ranged {
+			fmt.Printf("  %2d edges: %d -> %s\n", e, written[e], outputs[e])
+		}
+		if info, err := os.Stat(outputs[e]); err == nil {
+			size += info.Size()
+		}
+	}
+	fmt.Printf("File size: %.1f MB\n", float64(size)/1024/1024)
+	byEdges := map[int]int{}
+	for e := minE; e <= maxE; e++ {
+		byEdges[e] = written[e]
+	}
+	events.Emit("result", jsonl.Fields{"checked": total, "written": totalWritten, "by_edges": byEdges,
+		"outputs": outputs[minE : maxE+1], "bytes": size, "seconds": elapsed.Seconds()})
 }
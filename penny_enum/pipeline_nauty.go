@@ -1,16 +1,33 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/estimate"
+	"hexagon_clink/pkg/externaltools"
+	"hexagon_clink/pkg/extsort"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/metrics"
+	"hexagon_clink/pkg/pennyfilter"
+	"hexagon_clink/pkg/shard"
+	"hexagon_clink/pkg/wlcanon"
+	"hexagon_clink/pkg/zfile"
 )
 
 type Graph uint64
@@ -20,6 +37,9 @@ var numEdges int
 var edgeIndex [][]int
 var edgePairs [][2]int
 
+// workersBusy counts goDedup workers inside a task, for -metrics
+var workersBusy = metrics.NewGauge("pipeline_nauty_workers_busy", "Dedup workers currently fingerprinting or canonizing.")
+
 func initEdges(vertices int) {
 	n = vertices
 	numEdges = n * (n - 1) / 2
@@ -39,91 +59,47 @@ func initEdges(vertices int) {
 	}
 }
 
-func (g Graph) hasEdge(i, j int) bool {
-	return g&(1<<edgeIndex[i][j]) != 0
-}
-
-func (g Graph) degree(v int) int {
-	deg := 0
-	for u := 0; u < n; u++ {
-		if u != v && g.hasEdge(v, u) {
-			deg++
-		}
-	}
-	return deg
+// adjacency returns g as one neighbor mask per vertex, for the word-wide
+// degree, connectivity and neighborhood computations below.
+func (g Graph) adjacency() invariants.Graph {
+	return invariants.FromMask(n, uint64(g))
 }
 
 func (g Graph) isConnected() bool {
-	if g == 0 {
-		return false
-	}
-	visited := make([]bool, n)
-	queue := []int{0}
-	visited[0] = true
-	count := 1
-	for len(queue) > 0 {
-		node := queue[0]
-		queue = queue[1:]
-		for u := 0; u < n; u++ {
-			if !visited[u] && g.hasEdge(node, u) {
-				visited[u] = true
-				count++
-				queue = append(queue, u)
-			}
-		}
-	}
-	return count == n
+	return g != 0 && g.adjacency().Connected()
 }
 
-func (g Graph) hasIsolatedVertex() bool {
-	for v := 0; v < n; v++ {
-		if g.degree(v) == 0 {
-			return true
-		}
-	}
-	return false
-}
+// lastEdge[v] is the largest edge index touching v. Edges are decided in
+// increasing index order, so once that index is passed a vertex's degree is
+// final.
+var lastEdge []int
 
-func (g Graph) maxDegree() int {
-	maxDeg := 0
-	for v := 0; v < n; v++ {
-		d := g.degree(v)
-		if d > maxDeg {
-			maxDeg = d
-		}
+func initLastEdge() {
+	lastEdge = make([]int, n)
+	for v := 0; v < n-1; v++ {
+		lastEdge[v] = edgeIndex[v][n-1]
 	}
-	return maxDeg
+	lastEdge[n-1] = numEdges - 1
 }
 
-func (g Graph) hasK4() bool {
-	for a := 0; a < n; a++ {
-		for b := a + 1; b < n; b++ {
-			if !g.hasEdge(a, b) {
-				continue
-			}
-			for c := b + 1; c < n; c++ {
-				if !g.hasEdge(a, c) || !g.hasEdge(b, c) {
-					continue
-				}
-				for d := c + 1; d < n; d++ {
-					if g.hasEdge(a, d) && g.hasEdge(b, d) && g.hasEdge(c, d) {
-						return true
-					}
-				}
+// canCoverIsolated reports whether every isolated vertex (deg 0) can still
+// get an edge when only edges from index next on are undecided and at most
+// budget more edges may be added.
+func canCoverIsolated(deg []int, next, budget int) bool {
+	isolated := 0
+	for v := 0; v < n; v++ {
+		if deg[v] == 0 {
+			if lastEdge[v] < next {
+				return false
 			}
+			isolated++
 		}
 	}
-	return false
+	return (isolated+1)/2 <= budget
 }
 
 func (g Graph) edgeCount() int {
-	count := 0
-	tmp := g
-	for tmp != 0 {
-		count += int(tmp & 1)
-		tmp >>= 1
-	}
-	return count
+	return bits.OnesCount64(uint64(g))
 }
 
 func (g Graph) toGraph6() string {
@@ -148,21 +124,632 @@ func (g Graph) toGraph6() string {
 	return string(result)
 }
 
+// ---- Pure-Go isomorphism dedup, used when nauty's shortg is unavailable ----
+
+// fingerprint is the degree/triangle/neighbor-degree invariant from refine_hash
+func (g Graph) fingerprint() string {
+	type vertexInfo struct {
+		degree    int
+		triangles int
+		neighDegs []int
+	}
+
+	adj := g.adjacency()
+	infos := make([]vertexInfo, n)
+	for v := 0; v < n; v++ {
+		infos[v].degree = adj.Degree(v)
+		// Each edge among the neighbors is seen from both ends
+		for rest := adj.Adj[v]; rest != 0; rest &= rest - 1 {
+			u := bits.TrailingZeros64(rest)
+			infos[v].triangles += bits.OnesCount64(adj.Adj[u] & adj.Adj[v])
+			infos[v].neighDegs = append(infos[v].neighDegs, adj.Degree(u))
+		}
+		infos[v].triangles /= 2
+		sort.Ints(infos[v].neighDegs)
+	}
+
+	type infoKey struct {
+		degree    int
+		triangles int
+		neighDegs string
+	}
+	keys := make([]infoKey, n)
+	for v := 0; v < n; v++ {
+		keys[v] = infoKey{
+			infos[v].degree,
+			infos[v].triangles,
+			fmt.Sprint(infos[v].neighDegs),
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].degree != keys[j].degree {
+			return keys[i].degree > keys[j].degree
+		}
+		if keys[i].triangles != keys[j].triangles {
+			return keys[i].triangles > keys[j].triangles
+		}
+		return keys[i].neighDegs < keys[j].neighDegs
+	})
+
+	return fmt.Sprint(keys)
+}
+
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func sortColors(c []uint64) {
+	for i := 1; i < len(c); i++ {
+		x := c[i]
+		j := i - 1
+		for j >= 0 && c[j] > x {
+			c[j+1] = c[j]
+			j--
+		}
+		c[j+1] = x
+	}
+}
+
+// wlFingerprint is the hash-based 1-WL refinement from wl_refine
+func (g Graph) wlFingerprint(iterations int) uint64 {
+	adj := g.adjacency()
+	colors := make([]uint64, n)
+	for v := 0; v < n; v++ {
+		colors[v] = uint64(adj.Degree(v))
+	}
+
+	newColors := make([]uint64, n)
+	neighColors := make([]uint64, 0, n)
+	for iter := 0; iter < iterations; iter++ {
+		for v := 0; v < n; v++ {
+			neighColors = neighColors[:0]
+			for rest := adj.Adj[v]; rest != 0; rest &= rest - 1 {
+				neighColors = append(neighColors, colors[bits.TrailingZeros64(rest)])
+			}
+			sortColors(neighColors)
+			h := mix64(colors[v] + 0x9e3779b97f4a7c15)
+			for _, c := range neighColors {
+				h = mix64(h ^ c)
+			}
+			newColors[v] = h
+		}
+		colors, newColors = newColors, colors
+	}
+
+	sortColors(colors)
+	h := mix64(uint64(n))
+	for _, c := range colors {
+		h = mix64(h ^ c)
+	}
+	return h
+}
+
+// canonical is canonicalize's -canon-backend wl form: the minimum over the
+// relabelings that keep WL color classes together, instead of all n!
+func (g Graph) canonical() Graph {
+	return Graph(wlcanon.Canonical(n, uint64(g)))
+}
+
+type invariantKey struct {
+	fp string
+	wl uint64
+}
+
+// isoBucket holds the classes seen for one invariant key. The first graph
+// with a key is kept as-is; only when a second graph shares the key do we pay
+// for canonical forms, so graphs with unique invariants are never permuted.
+type isoBucket struct {
+	pending    Graph
+	hasPending bool
+	queued     bool
+	canons     map[Graph]bool
+}
+
+type goDedup struct {
+	buckets map[invariantKey]*isoBucket
+	workers int
+}
+
+func newGoDedup(workers int) *goDedup {
+	return &goDedup{buckets: make(map[invariantKey]*isoBucket), workers: workers}
+}
+
+// parallelMap runs f over 0..count-1 on the worker pool
+func (d *goDedup) parallelMap(count int, f func(i int)) {
+	next := atomic.Int64{}
+	var wg sync.WaitGroup
+	for w := 0; w < d.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= count {
+					return
+				}
+				workersBusy.Add(1)
+				f(i)
+				workersBusy.Add(-1)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *goDedup) addBatch(batch []Graph) {
+	keys := make([]invariantKey, len(batch))
+	d.parallelMap(len(batch), func(i int) {
+		keys[i] = invariantKey{batch[i].fingerprint(), batch[i].wlFingerprint(3)}
+	})
+
+	type job struct {
+		g      Graph
+		bucket *isoBucket
+	}
+	var jobs []job
+	for i, g := range batch {
+		b := d.buckets[keys[i]]
+		if b == nil {
+			d.buckets[keys[i]] = &isoBucket{pending: g, hasPending: true}
+			continue
+		}
+		if b.hasPending && !b.queued {
+			jobs = append(jobs, job{b.pending, b})
+			b.queued = true
+		}
+		jobs = append(jobs, job{g, b})
+	}
+
+	canons := make([]Graph, len(jobs))
+	d.parallelMap(len(jobs), func(i int) {
+		canons[i] = jobs[i].g.canonical()
+	})
+
+	for i, j := range jobs {
+		b := j.bucket
+		if b.canons == nil {
+			b.canons = make(map[Graph]bool)
+		}
+		b.canons[canons[i]] = true
+		b.hasPending = false
+		b.queued = false
+	}
+}
+
+func (d *goDedup) count() int {
+	total := 0
+	for _, b := range d.buckets {
+		if b.hasPending {
+			total++
+		}
+		total += len(b.canons)
+	}
+	return total
+}
+
+func (d *goDedup) unique() []Graph {
+	var result []Graph
+	for _, b := range d.buckets {
+		if b.hasPending {
+			result = append(result, b.pending)
+		}
+		for c := range b.canons {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// writeGraph6 writes graphs to path in graph6 format, compressed according
+// to the extension
+func writeGraph6(path string, graphs []Graph) error {
+	out, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for _, g := range graphs {
+		fmt.Fprintln(w, g.toGraph6())
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// concatGraph6 concatenates graph6 files into path and returns the number of
+// lines written. Inputs and output may each be compressed.
+func concatGraph6(path string, inputs []string) (int, error) {
+	out, err := zfile.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(out)
+	total := 0
+	for _, in := range inputs {
+		f, err := zfile.Open(in)
+		if err != nil {
+			out.Close()
+			return total, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fmt.Fprintln(w, scanner.Text())
+			total++
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			out.Close()
+			return total, fmt.Errorf("%s: %v", in, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return total, err
+	}
+	return total, out.Close()
+}
+
+// mergeUnique writes the distinct graphs of the batches' unique files to
+// path and returns how many graphs it read and wrote. shortg writes every
+// graph in its canonical labeling, the same in each batch, so a graph
+// found in several batches has the same bitmask in all of them and an
+// external sort (pkg/extsort) drops the copies: the graphs are spilled in
+// sorted runs of at most limit to dir, and the runs are merged 64 at a
+// time, level by level, into a stream without repeats. Memory stays at
+// limit plus the merge buffers however large the union is, where one
+// shortg over all batches needs its whole input sorted at once.
+func mergeUnique(path string, inputs []string, dir string, limit int) (read, written int64, runs int, err error) {
+	sorter := extsort.New(dir, limit)
+	defer sorter.Close()
+	for _, in := range inputs {
+		f, err := zfile.Open(in)
+		if err != nil {
+			return read, 0, 0, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			g, perr := invariants.ParseGraph6(scanner.Text())
+			if perr == nil {
+				err = sorter.Add(g.Mask())
+			} else {
+				err = perr
+			}
+			if err != nil {
+				break
+			}
+			read++
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+		f.Close()
+		if err != nil {
+			return read, 0, 0, fmt.Errorf("%s: %v", in, err)
+		}
+	}
+	runs = sorter.Runs()
+
+	out, err := zfile.Create(path)
+	if err != nil {
+		return read, 0, runs, err
+	}
+	w := bufio.NewWriter(out)
+	err = sorter.Each(func(g uint64) error {
+		written++
+		_, err := fmt.Fprintln(w, Graph(g).toGraph6())
+		return err
+	})
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return read, written, runs, err
+}
+
+// runShortg runs shortg on in, writing the unique graphs to out. Both are
+// piped through shortg's stdin/stdout so either may be compressed. Since in
+// can be reread, transient failures are retried.
+func runShortg(in, out string) error {
+	return externaltools.Retry(func() error {
+		src, err := zfile.Open(in)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := zfile.Create(out)
+		if err != nil {
+			return err
+		}
+		cmd, err := externaltools.Command("shortg", "-q")
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		cmd.Stdin = src
+		cmd.Stdout = dst
+		err = cmd.Run()
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", in, err)
+		}
+		return nil
+	})
+}
+
+// pipeShortg runs shortg with feed writing graph6 lines to its stdin and
+// emit called on each line of its output. The output is read while feed is
+// still writing, so neither side can stall on a full pipe, and nothing
+// touches the disk. The input is gone once fed, so a failure isn't
+// retried.
+func pipeShortg(feed func(w *bufio.Writer) error, emit func(line string)) error {
+	cmd, err := externaltools.Command("shortg", "-q")
+	if err != nil {
+		return err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	fed := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(stdin)
+		err := feed(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if cerr := stdin.Close(); err == nil {
+			err = cerr
+		}
+		fed <- err
+	}()
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		emit(scanner.Text())
+	}
+	readErr := scanner.Err()
+	if readErr != nil {
+		io.Copy(io.Discard, stdout) // let shortg finish
+	}
+	feedErr := <-fed
+	// A failing shortg also breaks the pipe under feed; its own error says
+	// why
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	if feedErr != nil {
+		return fmt.Errorf("shortg input: %v", feedErr)
+	}
+	if readErr != nil {
+		return fmt.Errorf("shortg output: %v", readErr)
+	}
+	return nil
+}
+
+// writePiped writes the unique graphs collected by -pipe to path, through
+// a final shortg when they come from several batches (the same graph in two
+// batches can carry different labelings), and returns how many it wrote.
+// batchTotal is the sum of the batches' unique counts, for the report.
+func writePiped(path string, lines []string, merge bool, batchTotal int64) (int, error) {
+	out, err := zfile.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(out)
+	count := 0
+	if merge {
+		fmt.Println("\nPhase 2: Merging batches...")
+		fmt.Printf("  %d graphs from the batches (%d repeated across batches skipped)\n",
+			len(lines), batchTotal-int64(len(lines)))
+		fmt.Println("  Running final shortg...")
+		err = pipeShortg(func(in *bufio.Writer) error {
+			for _, line := range lines {
+				if _, err := fmt.Fprintln(in, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, func(line string) {
+			count++
+			fmt.Fprintln(w, line)
+		})
+	} else {
+		for _, line := range lines {
+			count++
+			fmt.Fprintln(w, line)
+		}
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return count, err
+}
+
+// Counters of the estimate
+const (
+	estChecked = iota
+	estCandidates
+)
+
+// sample is a candidate met by a probe, with the number it stands for.
+type sample struct {
+	g      Graph
+	weight float64
+}
+
+// probeCandidates walks one random path of main's generate recursion,
+// keeping the candidate it may end on in samples (up to 10000).
+func probeCandidates(p *estimate.Probe, minE, maxE, prefixEdges int, sh shard.Shard, filters pennyfilter.Chain, samples *[]sample) {
+	deg := make([]int, n)
+	var g Graph
+	edgeIdx, edgeCount := 0, 0
+	for {
+		if edgeCount+numEdges-edgeIdx < minE || edgeCount > maxE || !canCoverIsolated(deg, edgeIdx, maxE-edgeCount) {
+			return
+		}
+		if edgeIdx == prefixEdges && !sh.Owns(uint64(g)) {
+			return
+		}
+		if edgeIdx == numEdges {
+			p.Add(estChecked)
+			if edgeCount >= minE && g.isConnected() && filters.Accept(n, uint64(g)) {
+				p.Add(estCandidates)
+				if len(*samples) < 10000 {
+					*samples = append(*samples, sample{g, p.Weight()})
+				}
+			}
+			return
+		}
+		i, j := edgePairs[edgeIdx][0], edgePairs[edgeIdx][1]
+		if deg[i] == 6 || deg[j] == 6 {
+			p.Choose(1)
+		} else if p.Choose(2) == 1 {
+			deg[i]++
+			deg[j]++
+			g |= 1 << edgeIdx
+			edgeCount++
+		}
+		edgeIdx++
+	}
+}
+
+// estimateRun predicts phase 1 from random paths through the generator's
+// tree (pkg/estimate), probing for budget, and the dedup from timing it
+// on the candidates the paths end on.
+func estimateRun(budget time.Duration, minE, maxE, prefixEdges int, sh shard.Shard, filters pennyfilter.Chain, useShortg bool, workers, batchSize int) {
+	initLastEdge()
+	est := estimate.New(1)
+	var samples []sample
+	est.Run(0, budget, func(p *estimate.Probe) {
+		probeCandidates(p, minE, maxE, prefixEdges, sh, filters, &samples)
+	})
+	candidates := est.Count(estCandidates)
+	fmt.Printf("\nEstimate from %d random paths through the search tree (%d nodes):\n", est.Probes(), est.Walked())
+	fmt.Printf("  Search tree: %s nodes\n", est.Nodes())
+	fmt.Printf("  Checked: %s\n", est.Count(estChecked))
+	fmt.Printf("  Candidates: %s in %.0f batches\n", candidates, math.Ceil(candidates.Mean/float64(batchSize)))
+	lineBytes := float64(len(Graph(0).toGraph6()) + 1)
+	fmt.Printf("  Batch files: %.1f MB uncompressed, the output at most that\n", candidates.Mean*lineBytes/1024/1024)
+	fmt.Printf("  Generation time: %s\n", est.Time().Duration())
+	if len(samples) == 0 {
+		return
+	}
+
+	// Dedup cost per candidate, timed on the sampled ones: pure Go
+	// fingerprints every candidate and canonizes nearly all (they have
+	// isomorphic copies), weighted by how many each stands for; shortg
+	// is timed on a batch of them
+	var perGraph float64
+	if useShortg {
+		start := time.Now()
+		err := pipeShortg(func(w *bufio.Writer) error {
+			for _, s := range samples {
+				if _, err := fmt.Fprintln(w, s.g.toGraph6()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, func(string) {})
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		perGraph = time.Since(start).Seconds() / float64(len(samples))
+	} else {
+		var cost, weight float64
+		for _, s := range samples {
+			start := time.Now()
+			s.g.fingerprint()
+			s.g.wlFingerprint(3)
+			s.g.canonical()
+			cost += s.weight * time.Since(start).Seconds()
+			weight += s.weight
+		}
+		perGraph = cost / weight / float64(workers)
+	}
+	dedup := estimate.Value{Mean: candidates.Mean * perGraph, StdErr: candidates.StdErr * perGraph}
+	fmt.Printf("  Dedup time: %s (timed on %d sampled candidates)\n", dedup.Duration(), len(samples))
+}
+
 func main() {
 	nFlag := flag.Int("n", 9, "number of vertices")
 	minEdges := flag.Int("min", 0, "minimum edges (default: n-1)")
 	maxEdgesFlag := flag.Int("max", 0, "maximum edges (default: 3n-6 for planar)")
 	batchSize := flag.Int("batch", 10000000, "graphs per batch")
 	outputFile := flag.String("out", "", "output file for unique graphs")
-	tmpDir := flag.String("tmp", "tmp_nauty", "temp directory for intermediate files")
+	tmpDir := flag.String("tmp", "", "temp directory for intermediate files (default: tmp_nauty, tmp_nauty_s<i>of<m> with -shard)")
+	mergeMB := flag.Int("merge-mb", 1024, "with shortg batch files: keep at most this many MB of graphs in memory while merging the batches, spilling sorted runs to -tmp (0: no limit)")
 	workers := flag.Int("workers", 0, "workers for candidate generation")
+	dedupMode := flag.String("dedup", "auto", "isomorphism dedup: shortg, go (pure Go, no nauty needed), or auto")
+	filterSpec := flag.String("filters", pennyfilter.Default, "comma-separated penny graph filters ("+pennyfilter.Names()+", or none)")
+	compress := flag.String("compress", "", "compress shortg batch files: gz or zst (-out is compressed by its own extension)")
+	pipe := flag.Bool("pipe", false, "with shortg: stream each batch through shortg's stdin and stdout instead of writing batch files to -tmp (the batches' unique graphs are kept in memory for the final shortg)")
+	shardSpec := flag.String("shard", "", "only generate shard i/m of the candidates (split by the first edges); combine the outputs with hexclink merge -dedup")
+	metricsAddr := flag.String("metrics", "", "serve progress metrics on this address (e.g. :9090) at /metrics (Prometheus) and /debug/vars (expvar)")
+	estimateOnly := flag.Bool("estimate", false, "predict the candidates, file sizes and time from random paths through the search tree, write nothing")
+	estimateTime := flag.Duration("estimate-time", 10*time.Second, "how long -estimate probes (the error shrinks with the square root)")
+	jsonOut := flag.Bool("json", false, "write events (start, batch, progress, result) as JSON lines on stdout; text goes to stderr")
 	flag.Parse()
+	run := manifest.Start("pipeline_nauty", nil)
+	events := jsonl.Start("pipeline_nauty", *jsonOut)
 
 	if *workers == 0 {
 		*workers = runtime.NumCPU()
 	}
+	batchExt := ""
+	switch *compress {
+	case "":
+	case "gz", "zst":
+		batchExt = "." + *compress
+	default:
+		fmt.Printf("Error: unknown -compress %q (use gz or zst)\n", *compress)
+		os.Exit(1)
+	}
+	if *pipe && *compress != "" {
+		fmt.Println("Error: -compress is for batch files, which -pipe doesn't write")
+		os.Exit(1)
+	}
 
 	initEdges(*nFlag)
+	filters, err := pennyfilter.Parse(*filterSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	sh, err := shard.Parse(*shardSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// A shard owns the subtrees below the prefixes (choices for the first
+	// prefixEdges edges) it is dealt; 64 prefixes per shard even out the
+	// pruning's lopsided subtrees
+	prefixEdges := 0
+	for !sh.IsAll() && 1<<prefixEdges < 64*sh.Count && prefixEdges < numEdges {
+		prefixEdges++
+	}
 
 	minE := *minEdges
 	if minE == 0 {
@@ -177,50 +764,175 @@ func main() {
 	fmt.Printf("Edge range: %d to %d\n", minE, maxE)
 	fmt.Printf("Batch size: %d graphs\n", *batchSize)
 	fmt.Printf("Workers: %d\n", *workers)
+	fmt.Printf("Filters: %s\n", filters)
+	if !sh.IsAll() {
+		fmt.Printf("Shard: %s (of %d prefixes on the first %d edges)\n", sh, 1<<prefixEdges, prefixEdges)
+	}
+
+	useShortg := false
+	switch *dedupMode {
+	case "shortg":
+		if _, err := externaltools.Require("shortg"); err != nil {
+			fmt.Printf("Error: %v, or use -dedup go\n", err)
+			os.Exit(1)
+		}
+		useShortg = true
+	case "go":
+	case "auto":
+		if externaltools.Available("shortg") {
+			useShortg = true
+		} else {
+			fmt.Println("shortg not found, falling back to pure-Go dedup")
+		}
+	default:
+		fmt.Printf("Error: unknown -dedup mode %q (use shortg, go, or auto)\n", *dedupMode)
+		os.Exit(1)
+	}
+	if useShortg && *pipe {
+		fmt.Println("Dedup: nauty shortg, batches piped")
+	} else if useShortg {
+		fmt.Println("Dedup: nauty shortg")
+	} else {
+		fmt.Println("Dedup: pure Go (fingerprint -> WL -> canonical)")
+	}
+	if *estimateOnly {
+		estimateRun(*estimateTime, minE, maxE, prefixEdges, sh, filters, useShortg, *workers, *batchSize)
+		return
+	}
+
+	finalFile := *outputFile
+	if finalFile == "" {
+		finalFile = fmt.Sprintf("n%d_unique%s.g6", n, sh.Suffix())
+	}
+	// wrote writes finalFile's manifest once it is complete
+	wrote := func() {
+		if err := run.Wrote(finalFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *tmpDir == "" {
+		*tmpDir = "tmp_nauty" + sh.Suffix()
+	}
 
 	os.MkdirAll(*tmpDir, 0755)
 
+	dedupName := "go"
+	if useShortg {
+		dedupName = "shortg"
+	}
+	events.Emit("start", jsonl.Fields{"n": n, "min_edges": minE, "max_edges": maxE, "batch": *batchSize,
+		"workers": *workers, "filters": filters.String(), "dedup": dedupName, "pipe": *pipe, "shard": sh.String(), "out": finalFile})
 	start := time.Now()
 
 	// Generate candidates and write in batches
 	var (
-		totalChecked  atomic.Int64
-		totalWritten  atomic.Int64
-		batchNum      atomic.Int32
-		currentBatch  []string
-		batchMu       sync.Mutex
-		batchFiles    []string
-		batchFilesMu  sync.Mutex
+		totalChecked atomic.Int64
+		totalWritten atomic.Int64
+		batchNum     atomic.Int32
+		currentBatch []Graph
+		batchMu      sync.Mutex
+		batchFiles   []string
+		batchFilesMu sync.Mutex
 	)
 
-	flushBatch := func(batch []string, num int) {
+	// unique: graphs left after dedup so far (pure Go dedup), or summed
+	// over the batches deduplicated so far (shortg)
+	var unique atomic.Int64
+	if *metricsAddr != "" {
+		metrics.CounterFunc("pipeline_nauty_checked_total", "Edge subsets reached by the generator.", func() float64 {
+			return float64(totalChecked.Load())
+		})
+		metrics.CounterFunc("pipeline_nauty_candidates_total", "Graphs that passed the filters.", func() float64 {
+			return float64(totalWritten.Load())
+		})
+		metrics.CounterFunc("pipeline_nauty_batches_total", "Batches handed to dedup.", func() float64 {
+			return float64(batchNum.Load())
+		})
+		metrics.GaugeFunc("pipeline_nauty_unique", "Graphs left after dedup so far.", func() float64 {
+			return float64(unique.Load())
+		})
+		metrics.GaugeFunc("pipeline_nauty_checked_per_second", "Average generation rate since start.", func() float64 {
+			return float64(totalChecked.Load()) / time.Since(start).Seconds()
+		})
+		if err := metrics.Serve(*metricsAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Metrics on http://%s/metrics\n", *metricsAddr)
+	}
+
+	goDD := newGoDedup(*workers)
+
+	// With -pipe: the batches' unique graph6 lines, without the ones
+	// repeated across batches (shortg's canonical labelings repeat verbatim)
+	var (
+		pipedLines   []string
+		pipedSeen    = make(map[string]struct{})
+		pipedBatches int
+	)
+
+	flushBatch := func(batch []Graph, num int) {
 		if len(batch) == 0 {
 			return
 		}
-		batchFile := filepath.Join(*tmpDir, fmt.Sprintf("batch_%04d.g6", num))
-		f, _ := os.Create(batchFile)
-		w := bufio.NewWriter(f)
-		for _, line := range batch {
-			fmt.Fprintln(w, line)
+		if !useShortg {
+			goDD.addBatch(batch)
+			count := goDD.count()
+			unique.Store(int64(count))
+			fmt.Printf("  Batch %d: %d graphs, %d unique so far\n", num, len(batch), count)
+			events.Emit("batch", jsonl.Fields{"batch": num, "graphs": len(batch), "unique_so_far": count})
+			return
+		}
+		if *pipe {
+			count := 0
+			err := pipeShortg(func(w *bufio.Writer) error {
+				for _, g := range batch {
+					if _, err := fmt.Fprintln(w, g.toGraph6()); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, func(line string) {
+				count++
+				batchFilesMu.Lock()
+				if _, dup := pipedSeen[line]; !dup {
+					pipedSeen[line] = struct{}{}
+					pipedLines = append(pipedLines, line)
+				}
+				batchFilesMu.Unlock()
+			})
+			if err != nil {
+				fmt.Printf("\nError: batch %d: %v\n", num, err)
+				os.Exit(1)
+			}
+			unique.Add(int64(count))
+			batchFilesMu.Lock()
+			pipedBatches++
+			batchFilesMu.Unlock()
+			fmt.Printf("  Batch %d: %d -> %d unique\n", num, len(batch), count)
+			events.Emit("batch", jsonl.Fields{"batch": num, "graphs": len(batch), "unique": count})
+			return
+		}
+		batchFile := filepath.Join(*tmpDir, fmt.Sprintf("batch_%04d.g6%s", num, batchExt))
+		if err := writeGraph6(batchFile, batch); err != nil {
+			fmt.Printf("\nError: %v\n", err)
+			os.Exit(1)
 		}
-		w.Flush()
-		f.Close()
 
 		// Run shortg on this batch
-		uniqueFile := filepath.Join(*tmpDir, fmt.Sprintf("unique_%04d.g6", num))
-		cmd := exec.Command("shortg", "-q", batchFile, uniqueFile)
-		cmd.Run()
+		uniqueFile := filepath.Join(*tmpDir, fmt.Sprintf("unique_%04d.g6%s", num, batchExt))
+		if err := runShortg(batchFile, uniqueFile); err != nil {
+			fmt.Printf("\nError: %v\n", err)
+			os.Exit(1)
+		}
 
 		// Count unique
-		uf, _ := os.Open(uniqueFile)
-		scanner := bufio.NewScanner(uf)
-		count := 0
-		for scanner.Scan() {
-			count++
-		}
-		uf.Close()
+		count, _ := zfile.CountLines(uniqueFile)
+		unique.Add(int64(count))
 
 		fmt.Printf("  Batch %d: %d -> %d unique\n", num, len(batch), count)
+		events.Emit("batch", jsonl.Fields{"batch": num, "graphs": len(batch), "unique": count})
 
 		// Remove batch file, keep unique file
 		os.Remove(batchFile)
@@ -245,6 +957,7 @@ func main() {
 				rate := float64(c) / time.Since(start).Seconds()
 				fmt.Printf("\r  Checked: %dM, candidates: %dM, rate: %.1fM/s   ",
 					c/1000000, w/1000000, rate/1000000)
+				events.Emit("progress", jsonl.Fields{"checked": c, "candidates": w, "rate": rate})
 			}
 		}
 	}()
@@ -254,6 +967,8 @@ func main() {
 
 	// We'll iterate through all possible edge combinations
 	// Use recursive generation with pruning
+	initLastEdge()
+	deg := make([]int, n)
 	var generate func(edgeIdx int, g Graph, edgeCount int)
 	generate = func(edgeIdx int, g Graph, edgeCount int) {
 		// Pruning: if we can't reach minE edges, skip
@@ -265,33 +980,38 @@ func main() {
 		if edgeCount > maxE {
 			return
 		}
+		// Pruning: every isolated vertex must still be able to get an edge
+		if !canCoverIsolated(deg, edgeIdx, maxE-edgeCount) {
+			return
+		}
+		// Every edge subset is reached once (each edge is either left out or
+		// added), so the candidate stream holds no identical bitmasks; all
+		// duplicates are isomorphic copies for the dedup stage.
+		// g holds just the prefix's edges here
+		if edgeIdx == prefixEdges && !sh.Owns(uint64(g)) {
+			return
+		}
 
 		if edgeIdx == numEdges {
 			totalChecked.Add(1)
 
-			// Check candidate filters
+			// Check candidate filters (max degree <= 6 and no isolated
+			// vertices are guaranteed by the pruning)
 			if edgeCount < minE || edgeCount > maxE {
 				return
 			}
-			if g.hasIsolatedVertex() {
-				return
-			}
-			if g.maxDegree() > 6 {
-				return
-			}
 			if !g.isConnected() {
 				return
 			}
-			if g.hasK4() {
+			if !filters.Accept(n, uint64(g)) {
 				return
 			}
 
 			// Valid candidate
-			g6 := g.toGraph6()
 			totalWritten.Add(1)
 
 			batchMu.Lock()
-			currentBatch = append(currentBatch, g6)
+			currentBatch = append(currentBatch, g)
 			if len(currentBatch) >= *batchSize {
 				batch := currentBatch
 				num := int(batchNum.Add(1))
@@ -307,8 +1027,16 @@ func main() {
 		// Don't include this edge
 		generate(edgeIdx+1, g, edgeCount)
 
-		// Include this edge
+		// Include this edge, unless it pushes a vertex past degree 6
+		i, j := edgePairs[edgeIdx][0], edgePairs[edgeIdx][1]
+		if deg[i] == 6 || deg[j] == 6 {
+			return
+		}
+		deg[i]++
+		deg[j]++
 		generate(edgeIdx+1, g|(1<<edgeIdx), edgeCount+1)
+		deg[i]--
+		deg[j]--
 	}
 
 	generate(0, 0, 0)
@@ -327,81 +1055,89 @@ func main() {
 
 	done <- true
 
-	fmt.Printf("\n\nPhase 1 complete: %d candidates in %d batches\n",
-		totalWritten.Load(), len(batchFiles))
+	// result prints the final count, after finalFile's manifest
+	result := func(count int64) {
+		wrote()
+		fmt.Printf("\n=== Result ===\n")
+		fmt.Printf("Total unique graphs: %d\n", count)
+		fmt.Printf("Output: %s\n", finalFile)
+		fmt.Printf("Time: %v\n", time.Since(start))
+		events.Emit("result", jsonl.Fields{"unique": count, "candidates": totalWritten.Load(), "checked": totalChecked.Load(),
+			"batches": batchNum.Load(), "out": finalFile, "seconds": time.Since(start).Seconds()})
+	}
 
-	// Phase 2: Merge all unique files and run shortg again
-	if len(batchFiles) > 1 {
-		fmt.Println("\nPhase 2: Merging batches...")
+	if !useShortg {
+		fmt.Printf("\n\nPhase 1 complete: %d candidates in %d batches\n",
+			totalWritten.Load(), batchNum.Load())
 
-		// Concatenate all unique files
-		mergedFile := filepath.Join(*tmpDir, "merged.g6")
-		mf, _ := os.Create(mergedFile)
-		mw := bufio.NewWriter(mf)
-		totalMerged := 0
-		for _, uf := range batchFiles {
-			f, _ := os.Open(uf)
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				fmt.Fprintln(mw, scanner.Text())
-				totalMerged++
-			}
-			f.Close()
+		unique := goDD.unique()
+		if err := writeGraph6(finalFile, unique); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		mw.Flush()
-		mf.Close()
 
-		fmt.Printf("  Merged %d graphs from %d batch files\n", totalMerged, len(batchFiles))
+		result(int64(len(unique)))
+		os.Remove(*tmpDir)
+		return
+	}
 
-		// Final shortg
-		finalFile := *outputFile
-		if finalFile == "" {
-			finalFile = fmt.Sprintf("n%d_unique.g6", n)
+	if *pipe {
+		fmt.Printf("\n\nPhase 1 complete: %d candidates in %d batches\n",
+			totalWritten.Load(), pipedBatches)
+		finalCount, err := writePiped(finalFile, pipedLines, pipedBatches > 1, unique.Load())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		fmt.Println("  Running final shortg...")
-		cmd := exec.Command("shortg", "-q", mergedFile, finalFile)
-		cmd.Run()
+		unique.Store(int64(finalCount))
 
-		// Count final
-		f, _ := os.Open(finalFile)
-		scanner := bufio.NewScanner(f)
-		finalCount := 0
-		for scanner.Scan() {
-			finalCount++
+		result(int64(finalCount))
+		os.Remove(*tmpDir)
+		return
+	}
+
+	fmt.Printf("\n\nPhase 1 complete: %d candidates in %d batches\n",
+		totalWritten.Load(), len(batchFiles))
+
+	// Phase 2: Merge the unique files, dropping the graphs repeated across
+	// batches
+	if len(batchFiles) > 1 {
+		fmt.Println("\nPhase 2: Merging batches...")
+		read, finalCount, runs, err := mergeUnique(finalFile, batchFiles, *tmpDir, extsort.LimitForMB(*mergeMB))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		f.Close()
+		if runs > 0 {
+			fmt.Printf("  Spilled to %d sorted runs\n", runs)
+		}
+		fmt.Printf("  Merged %d graphs from %d batch files (%d repeated across batches skipped)\n",
+			read, len(batchFiles), read-finalCount)
+		unique.Store(finalCount)
 
-		fmt.Printf("\n=== Result ===\n")
-		fmt.Printf("Total unique graphs: %d\n", finalCount)
-		fmt.Printf("Output: %s\n", finalFile)
-		fmt.Printf("Time: %v\n", time.Since(start))
+		result(finalCount)
 
 		// Cleanup
 		for _, uf := range batchFiles {
 			os.Remove(uf)
 		}
-		os.Remove(mergedFile)
 
 	} else if len(batchFiles) == 1 {
-		// Just one batch, rename it
-		finalFile := *outputFile
-		if finalFile == "" {
-			finalFile = fmt.Sprintf("n%d_unique.g6", n)
+		// Just one batch, rename it (recompressing if -out wants a
+		// different compression than the batch files)
+		if zfile.Ext(finalFile) == batchExt {
+			os.Rename(batchFiles[0], finalFile)
+		} else {
+			if _, err := concatGraph6(finalFile, batchFiles); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Remove(batchFiles[0])
 		}
-		os.Rename(batchFiles[0], finalFile)
 
-		f, _ := os.Open(finalFile)
-		scanner := bufio.NewScanner(f)
-		count := 0
-		for scanner.Scan() {
-			count++
-		}
-		f.Close()
+		count, _ := zfile.CountLines(finalFile)
 
-		fmt.Printf("\n=== Result ===\n")
-		fmt.Printf("Total unique graphs: %d\n", count)
-		fmt.Printf("Output: %s\n", finalFile)
-		fmt.Printf("Time: %v\n", time.Since(start))
+		result(int64(count))
 	}
 
 	os.Remove(*tmpDir)
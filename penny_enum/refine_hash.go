@@ -1,13 +1,25 @@
+//go:build ignore
+
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
+	"cmp"
+	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
 )
 
 var n int
@@ -106,98 +118,391 @@ func (g Graph) fingerprint() string {
 	return fmt.Sprint(keys)
 }
 
+// Rough in-memory cost of one graph while grouping (slice entry plus
+// amortized map and fingerprint string overhead), used to size shards
+const bytesPerGroupedGraph = 96
+
+// writeGroups appends groups to w and records their sizes in sizeDist.
+// Map iteration and the workers' merge order vary from run to run, so each
+// group is sorted and the groups are written in the order of their smallest
+// graph: the same input (and -mem) gives the same file.
+func writeGroups(w *graphio.Writer, groups map[string][]Graph, sizeDist map[int]int) error {
+	sorted := make([][]Graph, 0, len(groups))
+	for _, gs := range groups {
+		slices.Sort(gs)
+		sorted = append(sorted, gs)
+	}
+	slices.SortFunc(sorted, func(a, b []Graph) int { return cmp.Compare(a[0], b[0]) })
+	for _, gs := range sorted {
+		group := make([]uint64, len(gs))
+		for i, g := range gs {
+			group[i] = uint64(g)
+		}
+		if err := w.WriteGroup(group); err != nil {
+			return err
+		}
+		sizeDist[len(gs)]++
+	}
+	return nil
+}
+
+// maxOpenShards is the number of shard files written at once, bounding
+// open files; more shards take one pass over the input per maxOpenShards.
+const maxOpenShards = 256
+
+func shardOf(fp string, numShards int) int {
+	h := fnv.New64a()
+	h.Write([]byte(fp))
+	return int(h.Sum64() % uint64(numShards))
+}
+
+const chunkSize = 4096
+
+// readChunks streams graphs from reader in fixed-size chunks until EOF,
+// as views of the mapped input where possible, so nothing is copied.
+// chunks is always closed; a read error stops the stream and is returned.
+func readChunks(reader *graphio.Reader, chunks chan<- graphio.Graphs, progress func(total int)) error {
+	defer close(chunks)
+	total := 0
+	for {
+		chunk, err := reader.NextChunk(chunkSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		chunks <- chunk
+		if progress != nil && (total+chunk.Len())/1000000 > total/1000000 {
+			progress((total + chunk.Len()) / 1000000 * 1000000)
+		}
+		total += chunk.Len()
+	}
+}
+
+// groupGraphs fingerprints every graph in reader using a worker pool.
+// Each worker fills its own map; the maps are merged at the end.
+func groupGraphs(reader *graphio.Reader, workers int, progress func(total int)) (map[string][]Graph, int, error) {
+	chunks := make(chan graphio.Graphs, workers*2)
+	locals := make([]map[string][]Graph, workers)
+	counts := make([]int, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			local := make(map[string][]Graph)
+			for chunk := range chunks {
+				for i := 0; i < chunk.Len(); i++ {
+					g := Graph(chunk.At(i))
+					fp := g.fingerprint()
+					local[fp] = append(local[fp], g)
+				}
+				counts[w] += chunk.Len()
+			}
+			locals[w] = local
+		}(w)
+	}
+
+	err := readChunks(reader, chunks, progress)
+	wg.Wait()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groups := locals[0]
+	total := counts[0]
+	for w := 1; w < workers; w++ {
+		for fp, gs := range locals[w] {
+			groups[fp] = append(groups[fp], gs...)
+		}
+		total += counts[w]
+	}
+	return groups, total, nil
+}
+
+// partitionGraphs routes every graph in reader to the shard of its
+// fingerprint among numShards, writing shards first..first+len(shardWriters)-1
+// and skipping the graphs of the others; it returns the graphs written.
+// Workers fingerprint chunks; a single writer owns the shards.
+func partitionGraphs(reader *graphio.Reader, workers, numShards, first int, shardWriters []*graphio.Writer, progress func(total int)) (int, error) {
+	chunks := make(chan graphio.Graphs, workers*2)
+	routed := make(chan [][]Graph, workers*2)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				buckets := make([][]Graph, len(shardWriters))
+				for i := 0; i < chunk.Len(); i++ {
+					g := Graph(chunk.At(i))
+					s := shardOf(g.fingerprint(), numShards) - first
+					if s >= 0 && s < len(buckets) {
+						buckets[s] = append(buckets[s], g)
+					}
+				}
+				routed <- buckets
+			}
+		}()
+	}
+
+	total := 0
+	var writeErr error
+	written := make(chan struct{})
+	go func() {
+		for buckets := range routed {
+			for s, gs := range buckets {
+				for _, g := range gs {
+					if writeErr == nil {
+						writeErr = shardWriters[s].Write(uint64(g))
+					}
+				}
+				total += len(gs)
+			}
+		}
+		close(written)
+	}()
+
+	err := readChunks(reader, chunks, progress)
+	wg.Wait()
+	close(routed)
+	<-written
+	if err == nil {
+		err = writeErr
+	}
+	return total, err
+}
+
+// countGraphs reads path through once and returns the number of graphs.
+func countGraphs(path string, vertices int) (int64, error) {
+	reader, err := graphio.Open(path, graphio.Raw, vertices)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	var count int64
+	for {
+		if _, err := reader.Next(); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: refine_hash <n> <input.bin> <output.bin>")
-		fmt.Println("  n: number of vertices")
-		fmt.Println("  input.bin: binary file with graphs (each graph is uint32 or uint64)")
+	memMB := flag.Int("mem", 0, "memory budget in MB; if set, partition graphs into shard files on disk (0 = group everything in memory)")
+	tmpDir := flag.String("tmp", "", "directory for shard files (default: next to output)")
+	workers := flag.Int("workers", 0, "number of fingerprinting workers (default: NumCPU)")
+	jsonOut := flag.Bool("json", false, "write events (start, progress, shard, result) as JSON lines on stdout; text goes to stderr")
+	flag.Usage = func() {
+		fmt.Println("Usage: refine_hash [-workers N] [-mem MB] [-tmp dir] [n] <input.bin> <output.bin>")
+		fmt.Println("  n: number of vertices (optional, read from the input header)")
+		fmt.Println("  input.bin: raw graph file from generate_edges")
 		fmt.Println("  output.bin: output file for grouped graphs")
-		os.Exit(1)
+		flag.PrintDefaults()
 	}
+	flag.Parse()
+	events := jsonl.Start("refine_hash", *jsonOut)
 
-	vertices, err := strconv.Atoi(os.Args[1])
-	if err != nil || vertices < 2 {
-		fmt.Println("Error: n must be an integer >= 2")
+	args := flag.Args()
+	if len(args) < 2 || len(args) > 3 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	initEdges(vertices)
 
-	inputFile := os.Args[2]
-	outputFile := os.Args[3]
+	vertices := 0
+	if len(args) == 3 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 2 {
+			fmt.Println("Error: n must be an integer >= 2")
+			os.Exit(1)
+		}
+		vertices = v
+		args = args[1:]
+	}
 
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	if *workers == 0 {
+		*workers = runtime.NumCPU()
 	}
 
-	f, err := os.Open(inputFile)
+	inputFile := args[0]
+	outputFile := args[1]
+
+	reader, err := graphio.Open(inputFile, graphio.Raw, vertices)
 	if err != nil {
 		fmt.Printf("Error opening input file: %v\n", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	reader := bufio.NewReader(f)
+	defer reader.Close()
+	run := manifest.Start("refine_hash", nil)
+	if err := run.Input(inputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	initEdges(reader.Header().N)
+	bytesPerGraph := graphio.Width(n)
+	totalInput := int64(reader.Header().Count)
+	if reader.Header().Count == graphio.Streamed && *memMB > 0 {
+		// Compressed input doesn't record its count; take a counting pass
+		// so the shards can be sized
+		totalInput, err = countGraphs(inputFile, n)
+		if err != nil {
+			fmt.Printf("Error reading input file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	numShards := 1
+	if *memMB > 0 {
+		need := totalInput * bytesPerGroupedGraph
+		budget := int64(*memMB) * 1024 * 1024
+		numShards = int((need + budget - 1) / budget)
+		if numShards < 1 {
+			numShards = 1
+		}
+	}
+
+	// Group and graph counts are patched into the header on Close
+	writer, err := graphio.Create(outputFile, graphio.Derive(reader.Header(), graphio.Grouped, "refine_hash", nil))
+	if err != nil {
+		fmt.Printf("Error creating output file: %v\n", err)
+		os.Exit(1)
+	}
 
+	events.Emit("start", jsonl.Fields{"n": n, "input": inputFile, "output": outputFile, "workers": *workers, "shards": numShards})
 	start := time.Now()
-	groups := make(map[string][]Graph)
+	sizeDist := make(map[int]int)
+	numGroups := 0
 	total := 0
 
-	buf := make([]byte, bytesPerGraph)
-	for {
-		_, err := reader.Read(buf)
+	fmt.Printf("Using %d workers\n", *workers)
+
+	if numShards == 1 {
+		var groups map[string][]Graph
+		groups, total, err = groupGraphs(reader, *workers, func(total int) {
+			fmt.Printf("  Processed %dM...\n", total/1000000)
+			events.Emit("progress", jsonl.Fields{"processed": total})
+		})
 		if err != nil {
-			break
+			fmt.Printf("Error reading input file: %v\n", err)
+			os.Exit(1)
 		}
-		var g Graph
-		if bytesPerGraph == 4 {
-			g = Graph(binary.LittleEndian.Uint32(buf))
-		} else {
-			g = Graph(binary.LittleEndian.Uint64(buf))
+		fmt.Printf("\nDone fingerprinting in %v\n", time.Since(start))
+		numGroups = len(groups)
+		if err := writeGroups(writer, groups, sizeDist); err != nil {
+			fmt.Printf("Error writing output file: %v\n", err)
+			os.Exit(1)
 		}
-		fp := g.fingerprint()
-		groups[fp] = append(groups[fp], g)
-		total++
-		if total%1000000 == 0 {
-			fmt.Printf("  Processed %dM, %d groups so far...\n", total/1000000, len(groups))
+	} else {
+		// Route each graph to a shard by fingerprint hash, so that every
+		// fingerprint group lives entirely inside one shard, then group each
+		// shard in memory and append its groups. At most maxOpenShards are
+		// written at once; each further batch reads the input again.
+		dir := *tmpDir
+		if dir == "" {
+			dir = filepath.Dir(outputFile)
+		}
+		shardDir, err := os.MkdirTemp(dir, "refine_hash_shards_")
+		if err != nil {
+			fmt.Printf("Error creating shard directory: %v\n", err)
+			os.Exit(1)
+		}
+		// os.Exit skips deferred calls, so every exit below removes the
+		// shard directory itself
+		fail := func(what string, err error) {
+			os.RemoveAll(shardDir)
+			fmt.Printf("Error %s: %v\n", what, err)
+			os.Exit(1)
 		}
-	}
 
-	fmt.Printf("\nDone fingerprinting in %v\n", time.Since(start))
-	fmt.Printf("n=%d, numEdges=%d, bytesPerGraph=%d\n", n, numEdges, bytesPerGraph)
-	fmt.Printf("Total: %d\n", total)
-	fmt.Printf("Fingerprint groups: %d\n", len(groups))
+		fmt.Printf("Partitioning %d graphs into %d shards (-mem %d MB) in %s\n",
+			totalInput, numShards, *memMB, shardDir)
+		if numShards > maxOpenShards {
+			fmt.Printf("Writing %d shards at a time: %d passes over the input\n",
+				maxOpenShards, (numShards+maxOpenShards-1)/maxOpenShards)
+		}
 
-	outFile, err := os.Create(outputFile)
-	if err != nil {
-		fmt.Printf("Error creating output file: %v\n", err)
-		os.Exit(1)
-	}
-	defer outFile.Close()
-	writer := bufio.NewWriter(outFile)
+		for first := 0; first < numShards; first += maxOpenShards {
+			last := min(first+maxOpenShards, numShards)
+			in := reader
+			if first > 0 {
+				if in, err = graphio.Open(inputFile, graphio.Raw, n); err != nil {
+					fail("reopening input file", err)
+				}
+			}
+			shardWriters := make([]*graphio.Writer, last-first)
+			for i := range shardWriters {
+				shardWriters[i], err = graphio.Create(filepath.Join(shardDir, fmt.Sprintf("shard_%04d.bin", first+i)),
+					graphio.Header{Kind: graphio.Raw, N: n, Stage: "refine_hash_shard"})
+				if err != nil {
+					fail("creating shard file", err)
+				}
+			}
 
-	numGroups := uint32(len(groups))
-	binary.Write(writer, binary.LittleEndian, numGroups)
+			routed, err := partitionGraphs(in, *workers, numShards, first, shardWriters, func(total int) {
+				fmt.Printf("  Partitioned %dM...\n", total/1000000)
+				events.Emit("progress", jsonl.Fields{"partitioned": total})
+			})
+			if in != reader {
+				in.Close()
+			}
+			if err != nil {
+				fail("partitioning input file", err)
+			}
+			total += routed
+			for i := range shardWriters {
+				if err := shardWriters[i].Close(); err != nil {
+					fail("writing shard file", err)
+				}
+			}
+			fmt.Printf("Done partitioning shards %d-%d in %v\n", first+1, last, time.Since(start))
 
-	for _, gs := range groups {
-		size := uint32(len(gs))
-		binary.Write(writer, binary.LittleEndian, size)
-		for _, g := range gs {
-			if bytesPerGraph == 4 {
-				binary.Write(writer, binary.LittleEndian, uint32(g))
-			} else {
-				binary.Write(writer, binary.LittleEndian, uint64(g))
+			for i := first; i < last; i++ {
+				shardFile := filepath.Join(shardDir, fmt.Sprintf("shard_%04d.bin", i))
+				sr, err := graphio.Open(shardFile, graphio.Raw, n)
+				if err != nil {
+					fail("opening shard file", err)
+				}
+				groups, _, err := groupGraphs(sr, *workers, nil)
+				sr.Close()
+				os.Remove(shardFile)
+				if err != nil {
+					fail("reading shard file", err)
+				}
+
+				numGroups += len(groups)
+				if err := writeGroups(writer, groups, sizeDist); err != nil {
+					fail("writing output file", err)
+				}
+				fmt.Printf("  Shard %d/%d: %d groups (%d total, %.1fs)\n",
+					i+1, numShards, len(groups), numGroups, time.Since(start).Seconds())
+				events.Emit("shard", jsonl.Fields{"shard": i + 1, "shards": numShards, "groups": len(groups), "total_groups": numGroups})
 			}
 		}
+		os.RemoveAll(shardDir)
+		fmt.Printf("\nDone fingerprinting in %v\n", time.Since(start))
 	}
-	writer.Flush()
 
-	info, _ := outFile.Stat()
-	fmt.Printf("Wrote grouped data to %s (%.1f MB)\n", outputFile, float64(info.Size())/1024/1024)
-
-	sizeDist := make(map[int]int)
-	for _, gs := range groups {
-		sizeDist[len(gs)]++
+	if err := writer.Close(); err != nil {
+		fmt.Printf("Error writing output file: %v\n", err)
+		os.Exit(1)
 	}
+	if err := run.Wrote(outputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("n=%d, numEdges=%d, bytesPerGraph=%d\n", n, numEdges, bytesPerGraph)
+	fmt.Printf("Total: %d\n", total)
+	fmt.Printf("Fingerprint groups: %d\n", numGroups)
+
+	outInfo, _ := os.Stat(outputFile)
+	fmt.Printf("Wrote grouped data to %s (%.1f MB)\n", outputFile, float64(outInfo.Size())/1024/1024)
 
 	fmt.Printf("\nGroup size distribution:\n")
 	sizes := make([]int, 0)
@@ -209,4 +514,6 @@ func main() {
 	for _, size := range sizes {
 		fmt.Printf("  size %6d: %d groups\n", size, sizeDist[size])
 	}
+	events.Emit("result", jsonl.Fields{"graphs": total, "groups": numGroups, "group_sizes": sizeDist,
+		"output": outputFile, "bytes": outInfo.Size(), "seconds": time.Since(start).Seconds()})
 }
@@ -1,18 +1,31 @@
+//go:build ignore
+
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"math"
-	"math/rand"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/coordfile"
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/metrics"
+	"hexagon_clink/pkg/pennyembed"
+	"hexagon_clink/pkg/pennyfilter"
+	"hexagon_clink/pkg/prof"
+	"hexagon_clink/pkg/resultdb"
+	"hexagon_clink/pkg/shard"
+	"hexagon_clink/pkg/zfile"
 )
 
 type Graph uint64
@@ -68,222 +81,149 @@ func (g Graph) edges() [][2]int {
 	return result
 }
 
-// Check for K4 subgraph (4 nodes all pairwise connected)
-// K4 is forbidden in penny graphs
-func (g Graph) hasK4() bool {
-	for a := 0; a < n; a++ {
-		for b := a + 1; b < n; b++ {
-			if !g.hasEdge(a, b) {
-				continue
-			}
-			for c := b + 1; c < n; c++ {
-				if !g.hasEdge(a, c) || !g.hasEdge(b, c) {
-					continue
-				}
-				for d := c + 1; d < n; d++ {
-					if g.hasEdge(a, d) && g.hasEdge(b, d) && g.hasEdge(c, d) {
-						return true
-					}
-				}
-			}
+func graph6Order(path string) (int, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			return graph6.Size(line)
 		}
 	}
-	return false
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s: no graphs; pass -n", path)
 }
 
-// Numerical embedding check using gradient descent
-// Returns true if graph can be embedded with edges=1, non-edges>1
-func (g Graph) isPennyGraph() bool {
-	edges := g.edges()
-	if len(edges) == 0 {
-		return false
-	}
+// inputGraphs is the input and the range [lo, hi) of it this shard
+// verifies: views of a .bin file, read in place from the mapping rather
+// than copied, or the graphs parsed from a .g6 file.
+type inputGraphs struct {
+	views  []graphio.Graphs
+	parsed []Graph
+	lo, hi int
+}
 
-	// Non-edges
-	var nonEdges [][2]int
-	for idx := 0; idx < numEdges; idx++ {
-		if g&(1<<idx) == 0 {
-			nonEdges = append(nonEdges, edgePairs[idx])
-		}
+// total returns the number of input graphs.
+func (in *inputGraphs) total() int {
+	total := len(in.parsed)
+	for _, v := range in.views {
+		total += v.Len()
 	}
+	return total
+}
 
-	// Try multiple random starts
-	for attempt := 0; attempt < 20; attempt++ {
-		pos := make([][2]float64, n)
-		rng := rand.New(rand.NewSource(int64(42 + attempt)))
-
-		// Initialize with spread-out random positions
-		for i := 0; i < n; i++ {
-			pos[i] = [2]float64{rng.Float64() * 2, rng.Float64() * 2}
-		}
-
-		// Gradient descent
-		for iter := 0; iter < 3000; iter++ {
-			grad := make([][2]float64, n)
-			cost := 0.0
-
-			// Edge constraints: distance should be 1
-			for _, e := range edges {
-				i, j := e[0], e[1]
-				dx := pos[j][0] - pos[i][0]
-				dy := pos[j][1] - pos[i][1]
-				dist := math.Sqrt(dx*dx + dy*dy)
-				if dist < 1e-10 {
-					dist = 1e-10
-				}
-				err := dist - 1.0
-				cost += err * err
-
-				factor := 2 * err / dist
-				grad[i][0] -= factor * dx
-				grad[i][1] -= factor * dy
-				grad[j][0] += factor * dx
-				grad[j][1] += factor * dy
-			}
-
-			// Non-edge constraints: distance should be > 1
-			for _, e := range nonEdges {
-				i, j := e[0], e[1]
-				dx := pos[j][0] - pos[i][0]
-				dy := pos[j][1] - pos[i][1]
-				dist := math.Sqrt(dx*dx + dy*dy)
-				if dist < 1e-10 {
-					dist = 1e-10
-				}
-				if dist < 1.0 {
-					err := 1.0 - dist + 0.1
-					cost += err * err
-
-					factor := -2 * err / dist
-					grad[i][0] -= factor * dx
-					grad[i][1] -= factor * dy
-					grad[j][0] += factor * dx
-					grad[j][1] += factor * dy
-				}
-			}
-
-			// Update positions
-			lr := 0.1
-			if iter > 1000 {
-				lr = 0.01
-			}
-			if iter > 2000 {
-				lr = 0.001
-			}
-			for i := 0; i < n; i++ {
-				pos[i][0] -= lr * grad[i][0]
-				pos[i][1] -= lr * grad[i][1]
-			}
-
-			if cost < 1e-10 {
-				break
-			}
-		}
-
-		// Verify solution
-		valid := true
-		for _, e := range edges {
-			i, j := e[0], e[1]
-			dx := pos[j][0] - pos[i][0]
-			dy := pos[j][1] - pos[i][1]
-			dist := math.Sqrt(dx*dx + dy*dy)
-			if math.Abs(dist-1.0) > 0.001 {
-				valid = false
-				break
+// each calls f on the graphs in [lo, hi), in input order, and stops at the
+// first error.
+func (in *inputGraphs) each(f func(Graph) error) error {
+	if in.parsed != nil {
+		for _, g := range in.parsed[in.lo:in.hi] {
+			if err := f(g); err != nil {
+				return err
 			}
 		}
-		if valid {
-			for _, e := range nonEdges {
-				i, j := e[0], e[1]
-				dx := pos[j][0] - pos[i][0]
-				dy := pos[j][1] - pos[i][1]
-				dist := math.Sqrt(dx*dx + dy*dy)
-				if dist <= 1.001 {
-					valid = false
-					break
-				}
+		return nil
+	}
+	k := 0
+	for _, v := range in.views {
+		for i := max(in.lo-k, 0); i < min(in.hi-k, v.Len()); i++ {
+			if err := f(Graph(v.At(i))); err != nil {
+				return err
 			}
 		}
-		if valid {
-			return true
-		}
+		k += v.Len()
 	}
-	return false
+	return nil
 }
 
-// Parse graph6 format to Graph
-func parseGraph6(line string) Graph {
-	line = strings.TrimSpace(line)
-	if len(line) == 0 {
-		return 0
+// parseGraph6 decodes one graph6 line; blank lines and graphs of another n
+// give 0
+func parseGraph6(line string) (Graph, error) {
+	if strings.TrimSpace(line) == "" {
+		return 0, nil
 	}
-
-	// First byte encodes n (for n < 63)
-	nFromLine := int(line[0]) - 63
-	if nFromLine != n {
-		return 0 // Skip if different n
-	}
-
-	// Decode the rest as 6-bit chunks representing upper triangle
-	var bits []byte
-	for i := 1; i < len(line); i++ {
-		val := int(line[i]) - 63
-		for b := 5; b >= 0; b-- {
-			bits = append(bits, byte((val>>b)&1))
-		}
+	size, err := graph6.Size(line)
+	if err != nil || size != n {
+		return 0, err
 	}
-
-	// Build graph from upper triangle bits
 	var g Graph
-	bitIdx := 0
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			if bitIdx < len(bits) && bits[bitIdx] == 1 {
-				g |= 1 << edgeIndex[i][j]
-			}
-			bitIdx++
-		}
-	}
-	return g
+	_, err = graph6.Each(line, func(i, j int) {
+		g |= 1 << edgeIndex[i][j]
+	})
+	return g, err
 }
 
 // Convert Graph to graph6 format
 func (g Graph) toGraph6() string {
-	result := []byte{byte(n + 63)}
-
-	var bits []byte
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			if g&(1<<edgeIndex[i][j]) != 0 {
-				bits = append(bits, 1)
-			} else {
-				bits = append(bits, 0)
-			}
-		}
-	}
-
-	for len(bits)%6 != 0 {
-		bits = append(bits, 0)
-	}
-
-	for i := 0; i < len(bits); i += 6 {
-		val := bits[i]<<5 | bits[i+1]<<4 | bits[i+2]<<3 | bits[i+3]<<2 | bits[i+4]<<1 | bits[i+5]
-		result = append(result, byte(val+63))
-	}
-
-	return string(result)
+	return graph6.Encode(n, func(i, j int) bool { return g&(1<<edgeIndex[i][j]) != 0 })
 }
 
 func main() {
-	nFlag := flag.Int("n", 8, "number of vertices")
+	nFlag := flag.Int("n", 0, "number of vertices (default: from the input file)")
 	inputFile := flag.String("in", "", "input file (.g6 or .bin)")
 	outputFile := flag.String("out", "", "output file (same format as input)")
+	coordsFile := flag.String("coords", "", "also write the embedding found for each valid graph to this file, in the order of -out (for hexclink plot)")
 	workers := flag.Int("workers", 0, "number of workers (default: NumCPU)")
+	modelName := flag.String("model", "penny", "embedding to search for: penny (edges 1, non-edges > 1), matchstick (edges 1, no crossings) or unit (edges 1)")
+	filterSpec := flag.String("filters", "", "comma-separated graph filters ("+pennyfilter.Names()+", or none; default: every one that holds for -model)")
+	jsonOut := flag.Bool("json", false, "write progress and results as JSON lines on stdout (text goes to stderr)")
+	dbPath := flag.String("db", "", "record a verdict (yes, no from a filter, not_found from the search) for every input graph in this SQLite result database")
+	shardSpec := flag.String("shard", "", "only verify shard i/m of the input (the i-th of m equal line ranges); combine the outputs with hexclink merge")
+	metricsAddr := flag.String("metrics", "", "serve progress metrics on this address (e.g. :9090) at /metrics (Prometheus) and /debug/vars (expvar)")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address (e.g. localhost:6060) at /debug/pprof/")
+	tracePath := flag.String("trace", "", "write a runtime/trace of the run to this file, for go tool trace")
+	traceFor := flag.Duration("trace-for", time.Minute, "with -trace: stop tracing after this long (0: trace the whole run)")
 	flag.Parse()
+	events := jsonl.Start("verify_penny", *jsonOut)
+
+	m, ok := pennyembed.Models[*modelName]
+	if !ok {
+		fmt.Printf("Error: unknown -model %q (use penny, matchstick or unit)\n", *modelName)
+		os.Exit(1)
+	}
+	var filters pennyfilter.Chain
+	var err error
+	if *filterSpec == "" {
+		filters, err = pennyfilter.ForModel(m.Name)
+	} else if filters, err = pennyfilter.Parse(*filterSpec); err == nil {
+		err = filters.CheckModel(m.Name)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	sh, err := shard.Parse(*shardSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *pprofAddr != "" {
+		if err := prof.Serve(*pprofAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("pprof on http://%s/debug/pprof/\n", *pprofAddr)
+	}
+	if *tracePath != "" {
+		if err := prof.StartTrace(*tracePath, *traceFor); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer prof.StopTrace()
+	}
 
 	if *inputFile == "" {
-		fmt.Println("Usage: verify_penny -n <vertices> -in <input> -out <output>")
-		fmt.Println("  Supports .g6 (graph6) and .bin (binary) formats")
+		fmt.Println("Usage: verify_penny [-n <vertices>] [-model penny|matchstick|unit] -in <input> -out <output>")
+		fmt.Println("  Supports .g6 (graph6) and .bin (binary) formats, optionally compressed (.gz, .zst)")
+		os.Exit(1)
+	}
+	run := manifest.Start("verify_penny", nil)
+	if err := run.Input(*inputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -291,88 +231,151 @@ func main() {
 		*workers = runtime.NumCPU()
 	}
 
-	initEdges(*nFlag)
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
-	}
-
 	// Detect format from extension
-	isG6 := strings.HasSuffix(*inputFile, ".g6")
-
-	// Read graphs
-	var graphs []Graph
-	f, err := os.Open(*inputFile)
+	isG6 := zfile.HasExt(*inputFile, ".g6")
+
+	// n comes from the file unless given; a .bin header that disagrees
+	// with -n is an error
+	vertices := *nFlag
+	var header graphio.Header
+	var in inputGraphs
+	if !isG6 {
+		// The views point into the mapped file, which stays open until the
+		// run is done
+		var reader *graphio.Reader
+		if reader, err = graphio.Open(*inputFile, graphio.Raw, vertices); err == nil {
+			defer reader.Close()
+			header = reader.Header()
+			vertices = header.N
+			in.views, err = reader.Chunks()
+		}
+	} else if vertices == 0 {
+		vertices, err = graph6Order(*inputFile)
+	}
 	if err != nil {
-		fmt.Printf("Error opening %s: %v\n", *inputFile, err)
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	initEdges(vertices)
+	header.N = n
 
+	// Read graphs
 	if isG6 {
+		f, err := zfile.Open(*inputFile)
+		if err != nil {
+			fmt.Printf("Error opening %s: %v\n", *inputFile, err)
+			os.Exit(1)
+		}
 		scanner := bufio.NewScanner(f)
 		for scanner.Scan() {
-			g := parseGraph6(scanner.Text())
-			if g != 0 {
-				graphs = append(graphs, g)
-			}
-		}
-	} else {
-		reader := bufio.NewReader(f)
-		buf := make([]byte, bytesPerGraph)
-		for {
-			_, err := reader.Read(buf)
+			g, err := parseGraph6(scanner.Text())
 			if err != nil {
-				break
+				fmt.Printf("Error reading %s: %v\n", *inputFile, err)
+				os.Exit(1)
 			}
-			var g Graph
-			if bytesPerGraph == 4 {
-				g = Graph(binary.LittleEndian.Uint32(buf))
-			} else {
-				g = Graph(binary.LittleEndian.Uint64(buf))
+			if g != 0 {
+				in.parsed = append(in.parsed, g)
 			}
-			graphs = append(graphs, g)
 		}
+		if err := scanner.Err(); err != nil {
+			fmt.Printf("Error reading %s: %v\n", *inputFile, err)
+			os.Exit(1)
+		}
+		f.Close()
 	}
-	f.Close()
 
-	fmt.Printf("Loaded %d graphs from %s\n", len(graphs), *inputFile)
+	total := in.total()
+	fmt.Printf("Loaded %d graphs from %s\n", total, *inputFile)
+	in.lo, in.hi = sh.Range(total)
+	if !sh.IsAll() {
+		fmt.Printf("Shard %s: graphs %d..%d\n", sh, in.lo, in.hi-1)
+	}
+	numGraphs := in.hi - in.lo
 	fmt.Printf("Using %d workers\n", *workers)
+	events.Emit("start", jsonl.Fields{"n": n, "model": m.Name, "input": *inputFile, "graphs": numGraphs, "workers": *workers})
 
 	start := time.Now()
 
-	// Phase 1: K4 pruning (fast, single-threaded)
-	fmt.Println("\nPhase 1: K4 pruning...")
+	// Phase 1: structural pruning (fast, single-threaded). Every filter is a
+	// necessary condition, so a graph failing one can skip the embedding
+	// search.
+	fmt.Printf("\nPhase 1: filter pruning (%s)...\n", filters)
 	var candidates []Graph
-	for _, g := range graphs {
-		if !g.hasK4() {
+	removed := make(map[string]int)
+	rejectedBy := make(map[Graph]string)
+	in.each(func(g Graph) error {
+		if name := filters.Check(n, uint64(g)); name != "" {
+			removed[name]++
+			rejectedBy[g] = name
+		} else {
 			candidates = append(candidates, g)
 		}
+		return nil
+	})
+	left := numGraphs
+	for _, f := range filters {
+		left -= removed[f.Name]
+		fmt.Printf("After %s prune: %d graphs (removed %d)\n", f.Name, left, removed[f.Name])
 	}
-	fmt.Printf("After K4 prune: %d graphs (removed %d)\n", len(candidates), len(graphs)-len(candidates))
+	events.Emit("filtered", jsonl.Fields{"removed": removed, "candidates": len(candidates)})
 
-	// Phase 2: Parallel penny graph verification
-	fmt.Println("\nPhase 2: Penny embedding verification...")
+	// Phase 2: Parallel embedding verification
+	fmt.Printf("\nPhase 2: %s embedding verification...\n", m.Name)
 	var (
 		checked atomic.Int64
 		valid   atomic.Int64
+		busy    atomic.Int64
 		mu      sync.Mutex
 		results []Graph
+		// embedded[i] says candidates[i] embeds; the results are collected in
+		// input order once the workers are done, so runs write the same files
+		embedded  = make([]bool, len(candidates))
+		positions = make(map[Graph][][2]float64) // kept for -coords
 	)
+	if *metricsAddr != "" {
+		metrics.CounterFunc("verify_penny_checked_total", "Candidates run through the embedding search.", func() float64 {
+			return float64(checked.Load())
+		})
+		metrics.CounterFunc("verify_penny_valid_total", "Candidates found to be penny graphs.", func() float64 {
+			return float64(valid.Load())
+		})
+		metrics.GaugeFunc("verify_penny_candidates", "Candidates left after the filters.", func() float64 {
+			return float64(len(candidates))
+		})
+		metrics.GaugeFunc("verify_penny_checked_per_second", "Average verification rate since the graphs were loaded.", func() float64 {
+			return float64(checked.Load()) / time.Since(start).Seconds()
+		})
+		metrics.GaugeFunc("verify_penny_workers_busy", "Workers currently verifying a graph.", func() float64 {
+			return float64(busy.Load())
+		})
+		if err := metrics.Serve(*metricsAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Metrics on http://%s/metrics\n", *metricsAddr)
+	}
 
-	jobs := make(chan Graph, 1000)
+	jobs := make(chan int, 1000)
 	var wg sync.WaitGroup
 
 	for w := 0; w < *workers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for g := range jobs {
+			for i := range jobs {
+				g := candidates[i]
+				busy.Add(1)
+				pos, embeds := pennyembed.Embed(n, g.edges(), m)
+				busy.Add(-1)
 				checked.Add(1)
-				if g.isPennyGraph() {
+				if embeds {
 					valid.Add(1)
-					mu.Lock()
-					results = append(results, g)
-					mu.Unlock()
+					embedded[i] = true
+					if *coordsFile != "" {
+						mu.Lock()
+						positions[g] = pos
+						mu.Unlock()
+					}
 				}
 			}
 		}()
@@ -395,52 +398,161 @@ func main() {
 				eta := time.Duration(float64(len(candidates)-int(c))/rate) * time.Second
 				fmt.Printf("\r  Progress: %d/%d (%.1f%%) | Valid: %d | Rate: %.1f/s | ETA: %v   ",
 					c, len(candidates), pct, v, rate, eta)
+				events.Emit("progress", jsonl.Fields{
+					"checked": c, "candidates": len(candidates), "valid": v,
+					"rate": rate, "eta_seconds": eta.Seconds(),
+				})
 			}
 		}
 	}()
 
 	// Feed jobs
-	for _, g := range candidates {
-		jobs <- g
+	for i := range candidates {
+		jobs <- i
 	}
 	close(jobs)
 
 	wg.Wait()
 	done <- true
+	for i, g := range candidates {
+		if embedded[i] {
+			results = append(results, g)
+		}
+	}
 
 	fmt.Printf("\n\nDone in %v\n", time.Since(start))
 	fmt.Printf("Total checked: %d\n", checked.Load())
-	fmt.Printf("Valid penny graphs: %d\n", len(results))
+	fmt.Printf("Valid %s graphs: %d\n", m.Name, len(results))
+
+	if *dbPath != "" {
+		if err := recordVerdicts(*dbPath, m.Name, &in, results, rejectedBy); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Recorded %d %s verdicts in %s\n", numGraphs, m.Name, *dbPath)
+	}
 
 	// Write output
 	if *outputFile != "" {
-		if strings.HasSuffix(*outputFile, ".g6") {
-			out, err := os.Create(*outputFile)
+		if zfile.HasExt(*outputFile, ".g6") {
+			out, err := zfile.Create(*outputFile)
 			if err != nil {
 				fmt.Printf("Error creating %s: %v\n", *outputFile, err)
 				os.Exit(1)
 			}
+			w := bufio.NewWriter(out)
 			for _, g := range results {
-				fmt.Fprintln(out, g.toGraph6())
+				fmt.Fprintln(w, g.toGraph6())
+			}
+			w.Flush()
+			if err := out.Close(); err != nil {
+				fmt.Printf("Error writing %s: %v\n", *outputFile, err)
+				os.Exit(1)
 			}
-			out.Close()
 		} else {
-			out, err := os.Create(*outputFile)
+			params := make(map[string]string)
+			if !sh.IsAll() {
+				params["shard"] = sh.String()
+			}
+			if m.Name != "penny" {
+				params["model"] = m.Name
+			}
+			writer, err := graphio.Create(*outputFile, graphio.Derive(header, graphio.Raw, "verify_penny", params))
 			if err != nil {
 				fmt.Printf("Error creating %s: %v\n", *outputFile, err)
 				os.Exit(1)
 			}
-			writer := bufio.NewWriter(out)
 			for _, g := range results {
-				if bytesPerGraph == 4 {
-					binary.Write(writer, binary.LittleEndian, uint32(g))
-				} else {
-					binary.Write(writer, binary.LittleEndian, uint64(g))
+				if err := writer.Write(uint64(g)); err != nil {
+					fmt.Printf("Error writing %s: %v\n", *outputFile, err)
+					os.Exit(1)
 				}
 			}
-			writer.Flush()
-			out.Close()
+			if err := writer.Close(); err != nil {
+				fmt.Printf("Error writing %s: %v\n", *outputFile, err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("Wrote %d %s graphs to %s\n", len(results), m.Name, *outputFile)
+	}
+	if *coordsFile != "" {
+		if err := writeCoords(*coordsFile, results, positions); err != nil {
+			fmt.Printf("Error writing %s: %v\n", *coordsFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %d embeddings to %s\n", len(results), *coordsFile)
+	}
+	if err := run.Wrote(*outputFile, *coordsFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	events.Emit("result", jsonl.Fields{
+		"n": n, "graphs": numGraphs, "checked": checked.Load(), "valid": len(results),
+		"output": *outputFile, "seconds": time.Since(start).Seconds(),
+	})
+}
+
+// writeCoords writes the embeddings of graphs, numbered from 1.
+func writeCoords(path string, graphs []Graph, positions map[Graph][][2]float64) error {
+	out, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for i, g := range graphs {
+		if err == nil {
+			err = coordfile.Write(w, coordfile.Graph{Index: i + 1, Pos: positions[g], Edges: g.edges()})
 		}
-		fmt.Printf("Wrote %d penny graphs to %s\n", len(results), *outputFile)
 	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recordVerdicts stores every input graph in the result database with its
+// verdict for the model (check "penny", "matchstick" or "unit"): yes for the
+// embedded ones, no (a proof) for the ones a filter rejected, naming the
+// filter, and not_found for the ones the numerical search gave up on, which
+// may still embed.
+func recordVerdicts(path, check string, in *inputGraphs, valid []Graph, rejectedBy map[Graph]string) error {
+	db, err := resultdb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	embeds := make(map[Graph]bool, len(valid))
+	for _, g := range valid {
+		embeds[g] = true
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = in.each(func(g Graph) error {
+		g6 := g.toGraph6()
+		ig, err := invariants.ParseGraph6(g6)
+		if err != nil {
+			return err
+		}
+		id, _, err := tx.AddGraph(ig, g6, "verify_penny", "")
+		if err != nil {
+			return err
+		}
+		verdict, tool := "not_found", "verify_penny"
+		if embeds[g] {
+			verdict = "yes"
+		} else if name := rejectedBy[g]; name != "" {
+			verdict, tool = "no", tool+" filter "+name
+		}
+		return tx.AddVerdict(id, check, verdict, tool)
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
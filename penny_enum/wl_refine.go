@@ -1,13 +1,21 @@
+//go:build ignore
+
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
+	"flag"
 	"fmt"
 	"os"
+	"runtime"
 	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/graphio"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
 )
 
 var n int
@@ -50,162 +58,304 @@ func (g Graph) degree(v int) int {
 	return count
 }
 
-func (g Graph) wlFingerprint(iterations int) string {
-	colors := make([]int, n)
+// mix64 is the splitmix64 finalizer, used to combine colors into hashes
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// sortColors is an insertion sort; neighbor lists have at most a few entries
+func sortColors(c []uint64) {
+	for i := 1; i < len(c); i++ {
+		x := c[i]
+		j := i - 1
+		for j >= 0 && c[j] > x {
+			c[j+1] = c[j]
+			j--
+		}
+		c[j+1] = x
+	}
+}
+
+// wlFingerprint runs 1-WL color refinement. Each new color is a hash of the
+// vertex's color and the sorted multiset of its neighbors' colors, so colors
+// depend only on structure (never on vertex order) and no strings are built.
+func (g Graph) wlFingerprint(iterations int) uint64 {
+	var neighbors [64][]int
+	colors := make([]uint64, n)
 	for v := 0; v < n; v++ {
-		colors[v] = g.degree(v)
+		for u := 0; u < n; u++ {
+			if u != v && g.hasEdge(v, u) {
+				neighbors[v] = append(neighbors[v], u)
+			}
+		}
+		colors[v] = uint64(len(neighbors[v]))
 	}
 
+	newColors := make([]uint64, n)
+	neighColors := make([]uint64, 0, n)
 	for iter := 0; iter < iterations; iter++ {
-		newColors := make([]int, n)
-		colorMap := make(map[string]int)
-		nextColor := 0
+		for v := 0; v < n; v++ {
+			neighColors = neighColors[:0]
+			for _, u := range neighbors[v] {
+				neighColors = append(neighColors, colors[u])
+			}
+			sortColors(neighColors)
+			h := mix64(colors[v] + 0x9e3779b97f4a7c15)
+			for _, c := range neighColors {
+				h = mix64(h ^ c)
+			}
+			newColors[v] = h
+		}
+		colors, newColors = newColors, colors
+	}
+
+	sortColors(colors)
+	h := mix64(uint64(n))
+	for _, c := range colors {
+		h = mix64(h ^ c)
+	}
+	return h
+}
 
+// wl2Fingerprint runs 2-dimensional (folklore) WL refinement on vertex
+// pairs. A pair starts colored by equality/adjacency; each round it takes the
+// hash of its color and the sorted multiset of (color(u,w), color(w,v)) over
+// all w. Strictly stronger than 1-WL; O(n^3) per round, cheap for n <= 11.
+func (g Graph) wl2Fingerprint(iterations int) uint64 {
+	colors := make([]uint64, n*n)
+	for u := 0; u < n; u++ {
 		for v := 0; v < n; v++ {
-			var neighColors []int
-			for u := 0; u < n; u++ {
-				if u != v && g.hasEdge(v, u) {
-					neighColors = append(neighColors, colors[u])
-				}
+			switch {
+			case u == v:
+				colors[u*n+v] = 1
+			case g.hasEdge(u, v):
+				colors[u*n+v] = 2
+			default:
+				colors[u*n+v] = 3
 			}
-			sort.Ints(neighColors)
-			sig := fmt.Sprintf("%d:%v", colors[v], neighColors)
-
-			if c, ok := colorMap[sig]; ok {
-				newColors[v] = c
-			} else {
-				colorMap[sig] = nextColor
-				newColors[v] = nextColor
-				nextColor++
+		}
+	}
+
+	newColors := make([]uint64, n*n)
+	pairColors := make([]uint64, n)
+	for iter := 0; iter < iterations; iter++ {
+		for u := 0; u < n; u++ {
+			for v := 0; v < n; v++ {
+				for w := 0; w < n; w++ {
+					pairColors[w] = mix64(colors[u*n+w] ^ mix64(colors[w*n+v]+0x9e3779b97f4a7c15))
+				}
+				sortColors(pairColors)
+				h := mix64(colors[u*n+v])
+				for _, c := range pairColors {
+					h = mix64(h ^ c)
+				}
+				newColors[u*n+v] = h
 			}
 		}
-		colors = newColors
+		colors, newColors = newColors, colors
 	}
 
-	sorted := make([]int, n)
-	copy(sorted, colors)
-	sort.Ints(sorted)
-	return fmt.Sprint(sorted)
+	sortColors(colors)
+	h := mix64(uint64(n) + 2)
+	for _, c := range colors {
+		h = mix64(h ^ c)
+	}
+	return h
 }
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: wl_refine <n> <input_grouped.bin> <output_grouped_wl.bin>")
-		fmt.Println("  n: number of vertices")
+	workers := flag.Int("workers", 0, "number of workers (default: NumCPU)")
+	iterations := flag.Int("iter", 3, "number of WL refinement iterations")
+	wlOrder := flag.Int("order", 1, "WL dimension: 1 (vertex colors) or 2 (pair colors, slower but splits more)")
+	jsonOut := flag.Bool("json", false, "write events (start, split, progress, result) as JSON lines on stdout; text goes to stderr")
+	flag.Usage = func() {
+		fmt.Println("Usage: wl_refine [-workers N] [-iter K] [-order 1|2] [n] <input_grouped.bin> <output_grouped_wl.bin>")
+		fmt.Println("  n: number of vertices (optional, read from the input header)")
 		fmt.Println("  input_grouped.bin: grouped binary file from refine_hash")
 		fmt.Println("  output_grouped_wl.bin: output file with WL-refined groups")
-		os.Exit(1)
+		flag.PrintDefaults()
 	}
+	flag.Parse()
+	events := jsonl.Start("wl_refine", *jsonOut)
 
-	vertices, err := strconv.Atoi(os.Args[1])
-	if err != nil || vertices < 2 {
-		fmt.Println("Error: n must be an integer >= 2")
+	args := flag.Args()
+	if len(args) < 2 || len(args) > 3 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	initEdges(vertices)
 
-	inputFile := os.Args[2]
-	outputFile := os.Args[3]
+	vertices := 0
+	if len(args) == 3 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 2 {
+			fmt.Println("Error: n must be an integer >= 2")
+			os.Exit(1)
+		}
+		vertices = v
+		args = args[1:]
+	}
 
-	bytesPerGraph := 4
-	if numEdges > 32 {
-		bytesPerGraph = 8
+	if *workers == 0 {
+		*workers = runtime.NumCPU()
+	}
+	if *wlOrder != 1 && *wlOrder != 2 {
+		fmt.Println("Error: -order must be 1 or 2")
+		os.Exit(1)
 	}
+	fingerprint := Graph.wlFingerprint
+	if *wlOrder == 2 {
+		fingerprint = Graph.wl2Fingerprint
+	}
+
+	inputFile := args[0]
+	outputFile := args[1]
 
-	f, err := os.Open(inputFile)
+	raw, header, err := graphio.ReadGroups(inputFile, vertices)
 	if err != nil {
-		fmt.Printf("Error opening input file: %v\n", err)
+		fmt.Printf("Error reading input file: %v\n", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	reader := bufio.NewReader(f)
-
-	var numGroups uint32
-	binary.Read(reader, binary.LittleEndian, &numGroups)
-	fmt.Printf("Reading %d groups, refining with WL (n=%d)...\n", numGroups, n)
+	run := manifest.Start("wl_refine", nil)
+	if err := run.Input(inputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	initEdges(header.N)
+	numGroups := len(raw)
+	fmt.Printf("Read %d groups, refining with %d-WL (n=%d, %d iterations, %d workers)...\n",
+		numGroups, *wlOrder, n, *iterations, *workers)
 
-	start := time.Now()
+	groups := make([][]Graph, numGroups)
 	totalGraphs := 0
-	splitCount := 0
-
-	type groupResult struct {
-		graphs []Graph
-	}
-	var allResults []groupResult
-
-	for g := uint32(0); g < numGroups; g++ {
-		var size uint32
-		binary.Read(reader, binary.LittleEndian, &size)
-
-		graphs := make([]Graph, size)
-		for i := uint32(0); i < size; i++ {
-			if bytesPerGraph == 4 {
-				var graph uint32
-				binary.Read(reader, binary.LittleEndian, &graph)
-				graphs[i] = Graph(graph)
-			} else {
-				var graph uint64
-				binary.Read(reader, binary.LittleEndian, &graph)
-				graphs[i] = Graph(graph)
-			}
+	for g, vs := range raw {
+		groups[g] = make([]Graph, len(vs))
+		for i, v := range vs {
+			groups[g][i] = Graph(v)
 		}
-		totalGraphs += int(size)
+		totalGraphs += len(vs)
+	}
+	raw = nil
 
-		subgroups := make(map[string][]Graph)
-		for _, gr := range graphs {
-			fp := gr.wlFingerprint(3)
-			subgroups[fp] = append(subgroups[fp], gr)
-		}
+	events.Emit("start", jsonl.Fields{"n": n, "input": inputFile, "output": outputFile, "order": *wlOrder,
+		"iterations": *iterations, "workers": *workers, "groups": numGroups, "graphs": totalGraphs})
+	start := time.Now()
+	var splitCount atomic.Int64
+	var subgroupCount atomic.Int64
+	var groupsDone atomic.Int64
+	var printMu sync.Mutex
+
+	// refined[g] holds the subgroups of input group g, ordered by WL
+	// fingerprint, so the output order doesn't depend on scheduling
+	refined := make([][][]Graph, numGroups)
+	groupChan := make(chan int, *workers*2)
+
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range groupChan {
+				subgroups := make(map[uint64][]Graph)
+				for _, gr := range groups[g] {
+					fp := fingerprint(gr, *iterations)
+					subgroups[fp] = append(subgroups[fp], gr)
+				}
+
+				fps := make([]uint64, 0, len(subgroups))
+				for fp := range subgroups {
+					fps = append(fps, fp)
+				}
+				sort.Slice(fps, func(i, j int) bool { return fps[i] < fps[j] })
+				refined[g] = make([][]Graph, len(fps))
+				for i, fp := range fps {
+					refined[g][i] = subgroups[fp]
+				}
+				subgroupCount.Add(int64(len(fps)))
 
-		if len(subgroups) > 1 {
-			splitCount++
-			sizes := make([]int, 0, len(subgroups))
-			for _, sg := range subgroups {
-				sizes = append(sizes, len(sg))
+				if len(subgroups) > 1 {
+					splitCount.Add(1)
+					sizes := make([]int, 0, len(subgroups))
+					for _, sg := range subgroups {
+						sizes = append(sizes, len(sg))
+					}
+					sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+					printMu.Lock()
+					fmt.Printf("  Split! Group %d (size %d) -> %d subgroups: %v\n", g, len(groups[g]), len(subgroups), sizes)
+					printMu.Unlock()
+					events.Emit("split", jsonl.Fields{"group": g, "size": len(groups[g]), "sizes": sizes})
+				}
+
+				done := groupsDone.Add(1)
+				if done%100 == 0 {
+					printMu.Lock()
+					fmt.Printf("  Progress: %d/%d groups, %d total subgroups, %d splits (%.1fs)\n",
+						done, numGroups, subgroupCount.Load(), splitCount.Load(), time.Since(start).Seconds())
+					printMu.Unlock()
+					events.Emit("progress", jsonl.Fields{"done": done, "groups": numGroups,
+						"subgroups": subgroupCount.Load(), "splits": splitCount.Load()})
+				}
 			}
-			sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
-			fmt.Printf("  Split! Group %d (size %d) -> %d subgroups: %v\n", g, size, len(subgroups), sizes)
-		}
+		}()
+	}
 
-		for _, sg := range subgroups {
-			allResults = append(allResults, groupResult{sg})
-		}
+	// Largest groups first so a single huge group doesn't start last
+	order := make([]int, numGroups)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return len(groups[order[i]]) > len(groups[order[j]]) })
+	for _, g := range order {
+		groupChan <- g
+	}
+	close(groupChan)
+	wg.Wait()
 
-		if (g+1)%100 == 0 {
-			fmt.Printf("  Progress: %d/%d groups, %d total subgroups, %d splits (%.1fs)\n",
-				g+1, numGroups, len(allResults), splitCount, time.Since(start).Seconds())
-		}
+	var allResults [][]Graph
+	for _, subgroups := range refined {
+		allResults = append(allResults, subgroups...)
 	}
 
 	fmt.Printf("\nDone in %v\n", time.Since(start))
 	fmt.Printf("Total graphs: %d\n", totalGraphs)
 	fmt.Printf("Original groups: %d\n", numGroups)
-	fmt.Printf("Refined groups: %d (splits: %d)\n", len(allResults), splitCount)
+	fmt.Printf("Refined groups: %d (splits: %d)\n", len(allResults), splitCount.Load())
 
-	outFile, err := os.Create(outputFile)
+	writer, err := graphio.Create(outputFile, graphio.Derive(header, graphio.Grouped, "wl_refine", map[string]string{
+		"wl_order": strconv.Itoa(*wlOrder),
+		"wl_iter":  strconv.Itoa(*iterations),
+	}))
 	if err != nil {
 		fmt.Printf("Error creating output file: %v\n", err)
 		os.Exit(1)
 	}
-	writer := bufio.NewWriter(outFile)
-	binary.Write(writer, binary.LittleEndian, uint32(len(allResults)))
 	for _, gr := range allResults {
-		binary.Write(writer, binary.LittleEndian, uint32(len(gr.graphs)))
-		for _, g := range gr.graphs {
-			if bytesPerGraph == 4 {
-				binary.Write(writer, binary.LittleEndian, uint32(g))
-			} else {
-				binary.Write(writer, binary.LittleEndian, uint64(g))
-			}
+		group := make([]uint64, len(gr))
+		for i, g := range gr {
+			group[i] = uint64(g)
 		}
+		if err := writer.WriteGroup(group); err != nil {
+			fmt.Printf("Error writing output file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Printf("Error writing output file: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Wrote(outputFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
-	writer.Flush()
-	outFile.Close()
 	fmt.Printf("Wrote to %s\n", outputFile)
 
 	sizeDist := make(map[int]int)
 	for _, gr := range allResults {
-		sizeDist[len(gr.graphs)]++
+		sizeDist[len(gr)]++
 	}
 	fmt.Println("\nGroup size distribution:")
 	sizes := make([]int, 0)
@@ -216,4 +366,6 @@ func main() {
 	for _, size := range sizes {
 		fmt.Printf("  size %6d: %d groups\n", size, sizeDist[size])
 	}
+	events.Emit("result", jsonl.Fields{"graphs": totalGraphs, "groups": numGroups, "refined": len(allResults),
+		"splits": splitCount.Load(), "group_sizes": sizeDist, "output": outputFile, "seconds": time.Since(start).Seconds()})
 }
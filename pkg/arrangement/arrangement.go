@@ -0,0 +1,246 @@
+// Package arrangement reads and writes arrangement sets: the k arrangements
+// of n items that make up a solution (or a candidate prefix of one), with
+// the host graph each is laid out on. The solvers write them with -out,
+// and hexclink verify, hexclink render-arrangement (WriteSVG) and
+// find_fourth read them, so solutions pass between the tools as files
+// instead of copied console output.
+//
+// A file holds one set per line, as a JSON object:
+//
+//	{"n":15,"layout":"spiral","arrangements":[[0,1,2,...],[4,11,7,...],...],"tool":"find_fourth"}
+//	{"n":13,"layout":"graphs","shapes":["A","A","B"],"hosts":["L...","L...","L..."],"arrangements":[...]}
+//
+// arrangements[i][v] is the item at vertex v of arrangement i's host. With
+// layout "spiral" every host is the spiral of n coins (pkg/lattice.Spiral,
+// the solvers' slot order); with layout "graphs", hosts[i] is the graph6
+// line of arrangement i's host, in its own vertex numbering, and shapes
+// optionally names the hosts as the solver did.
+package arrangement
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/lattice"
+	"hexagon_clink/pkg/zfile"
+)
+
+// Layouts
+const (
+	Spiral = "spiral"
+	Graphs = "graphs"
+)
+
+// Set is one line of an arrangement file.
+type Set struct {
+	N            int      `json:"n"`
+	Layout       string   `json:"layout"`
+	Shapes       []string `json:"shapes,omitempty"`
+	Hosts        []string `json:"hosts,omitempty"`
+	Arrangements [][]int  `json:"arrangements"`
+	Tool         string   `json:"tool,omitempty"`
+}
+
+// NewSpiral returns the set of arrangements on the spiral of n coins.
+func NewSpiral(n int, arrangements [][]int, tool string) Set {
+	return Set{N: n, Layout: Spiral, Arrangements: arrangements, Tool: tool}
+}
+
+// NewGraphs returns the set of arrangements on the given hosts, each the
+// edge list of a graph on n vertices; shapes may be nil.
+func NewGraphs(n int, hosts [][][2]int, shapes []string, arrangements [][]int, tool string) Set {
+	s := Set{N: n, Layout: Graphs, Shapes: shapes, Arrangements: arrangements, Tool: tool}
+	for _, edges := range hosts {
+		s.Hosts = append(s.Hosts, graph6.EncodeEdges(n, edges))
+	}
+	return s
+}
+
+// Validate checks that the set is well formed: a known layout, a host per
+// arrangement on n vertices, and every arrangement a permutation of the
+// items 0..n-1.
+func (s Set) Validate() error {
+	if s.N < 1 {
+		return fmt.Errorf("n=%d", s.N)
+	}
+	switch s.Layout {
+	case Spiral:
+		if s.N > invariants.MaxN {
+			return fmt.Errorf("spiral of n=%d coins, at most %d supported", s.N, invariants.MaxN)
+		}
+		if len(s.Hosts) > 0 {
+			return fmt.Errorf("layout spiral has no hosts")
+		}
+	case Graphs:
+		if len(s.Hosts) != len(s.Arrangements) {
+			return fmt.Errorf("%d hosts for %d arrangements", len(s.Hosts), len(s.Arrangements))
+		}
+		for i, h := range s.Hosts {
+			n, err := graph6.Size(h)
+			if err != nil {
+				return fmt.Errorf("host %d: %v", i, err)
+			}
+			if n != s.N {
+				return fmt.Errorf("host %d has %d vertices, n=%d", i, n, s.N)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown layout %q (want %s or %s)", s.Layout, Spiral, Graphs)
+	}
+	if s.Shapes != nil && len(s.Shapes) != len(s.Arrangements) {
+		return fmt.Errorf("%d shape names for %d arrangements", len(s.Shapes), len(s.Arrangements))
+	}
+	if len(s.Arrangements) == 0 {
+		return fmt.Errorf("no arrangements")
+	}
+	for i, arr := range s.Arrangements {
+		if err := cover.CheckPermutation(arr, s.N); err != nil {
+			return fmt.Errorf("arrangement %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Edges returns the host edges of arrangement i, as vertex pairs (a, b)
+// with a < b. The set must be valid.
+func (s Set) Edges(i int) [][2]int {
+	if s.Layout == Spiral {
+		return SpiralEdges(s.N)
+	}
+	_, edges, err := graph6.Decode(s.Hosts[i])
+	if err != nil {
+		panic(fmt.Sprintf("arrangement: host %d: %v", i, err)) // Validate checks the hosts
+	}
+	return edges
+}
+
+// SpiralEdges returns the edges of the spiral of n coins, the solvers'
+// buildSpiral.
+func SpiralEdges(n int) [][2]int {
+	g := lattice.Contact(lattice.Spiral(n))
+	var edges [][2]int
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			if g.HasEdge(a, b) {
+				edges = append(edges, [2]int{a, b})
+			}
+		}
+	}
+	return edges
+}
+
+// Positions places arrangement i's host on the triangular lattice: the
+// spiral's own coins, or for layout graphs an embedding by lattice.Embed,
+// as the contact graph of its coins if possible. It returns false if the
+// host doesn't embed (penny graphs need not). The set must be valid.
+func (s Set) Positions(i int) ([]lattice.Point, bool) {
+	if s.Layout == Spiral {
+		return lattice.Spiral(s.N), true
+	}
+	g := invariants.New(s.N)
+	for _, e := range s.Edges(i) {
+		g.AddEdge(e[0], e[1])
+	}
+	if pos, ok := lattice.Embed(g, true); ok {
+		return pos, true
+	}
+	return lattice.Embed(g, false)
+}
+
+// Uncovered returns the pairs of items (a, b), a < b, that no arrangement
+// puts on adjacent vertices. The set must be valid.
+func (s Set) Uncovered() [][2]int {
+	hosts := make([][][2]int, len(s.Arrangements))
+	for i := range hosts {
+		hosts[i] = s.Edges(i)
+	}
+	pairs := cover.NewTable(s.N)
+	return pairs.Uncovered(pairs.Coverage(s.Arrangements, hosts))
+}
+
+// Name is arrangement i's label, "arr<i>" with the shape name if any.
+func (s Set) Name(i int) string {
+	if s.Shapes != nil {
+		return fmt.Sprintf("arr%d (%s)", i, s.Shapes[i])
+	}
+	return fmt.Sprintf("arr%d", i)
+}
+
+// Write writes s as one line.
+func Write(w io.Writer, s Set) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", line)
+	return err
+}
+
+// WriteFile writes the sets to the file at path (optionally .gz/.zst).
+func WriteFile(path string, sets ...Set) error {
+	f, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, s := range sets {
+		if err = Write(w, s); err != nil {
+			break
+		}
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReadFile reads every set of the file at path (optionally .gz/.zst).
+func ReadFile(path string) ([]Set, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sets, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return sets, nil
+}
+
+// Read reads every set from r and validates it. Errors start with the
+// line number.
+func Read(r io.Reader) ([]Set, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<26)
+	var sets []Set
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+		var s Set
+		if err := dec.Decode(&s); err != nil {
+			return nil, fmt.Errorf("%d: %v", line, err)
+		}
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("%d: %v", line, err)
+		}
+		sets = append(sets, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
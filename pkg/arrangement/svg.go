@@ -0,0 +1,166 @@
+package arrangement
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"hexagon_clink/pkg/coverage"
+)
+
+// SVG layout, in pixels
+const (
+	svgPad   = 16  // around the picture and between panels
+	svgTitle = 24  // set title above each row
+	svgLabel = 20  // caption under each panel
+	svgMinW  = 150 // panel width, so the caption fits
+)
+
+// panel is one arrangement laid out for drawing.
+type panel struct {
+	xy     [][2]float64 // coin centers, in coin diameters
+	minX   float64
+	minY   float64
+	width  float64 // in coin diameters, including a coin's width
+	height float64
+}
+
+func newPanel(s Set, i int) (panel, error) {
+	pos, ok := s.Positions(i)
+	if !ok {
+		return panel{}, fmt.Errorf("%s: host doesn't embed in the triangular lattice", s.Name(i))
+	}
+	p := panel{xy: make([][2]float64, len(pos)), minX: math.Inf(1), minY: math.Inf(1)}
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for v, pt := range pos {
+		x, y := pt.XY()
+		y = -y // R goes up
+		p.xy[v] = [2]float64{x, y}
+		p.minX, maxX = min(p.minX, x), max(maxX, x)
+		p.minY, maxY = min(p.minY, y), max(maxY, y)
+	}
+	p.width, p.height = maxX-p.minX+1, maxY-p.minY+1
+	return p, nil
+}
+
+// pixels returns the panel's width in pixels for coins d pixels across.
+func (p panel) pixels(d float64) float64 {
+	return max(p.width*d, svgMinW)
+}
+
+// WriteSVG draws each set as a row of its arrangements side by side: the
+// coins of each host (coin pixels across) labeled with the item they hold,
+// and their contacts, thick in the arrangement's color (as in pkg/coverage)
+// where the arrangement covers a pair no earlier one does, thin and gray
+// where it repeats one. Each panel's caption counts the new pairs; a row
+// whose set leaves pairs uncovered lists them under it. Layout graphs
+// hosts must embed in the triangular lattice (Positions).
+func WriteSVG(w io.Writer, sets []Set, coin int) error {
+	d := float64(coin)
+	rows := make([][]panel, len(sets))
+	width, height := 0.0, float64(svgPad)
+	for r, s := range sets {
+		rowW, rowH := float64(svgPad), 0.0
+		for i := range s.Arrangements {
+			p, err := newPanel(s, i)
+			if err != nil {
+				return fmt.Errorf("set %d: %v", r+1, err)
+			}
+			rows[r] = append(rows[r], p)
+			rowW += p.pixels(d) + svgPad
+			rowH = max(rowH, p.height*d)
+		}
+		width = max(width, rowW)
+		height += svgTitle + rowH + svgLabel + svgPad
+		if len(s.Uncovered()) > 0 {
+			height += svgLabel
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" font-family="sans-serif" font-size="12">`+"\n", num(width), num(height))
+	fmt.Fprintf(&sb, `<rect width="%s" height="%s" fill="white"/>`+"\n", num(width), num(height))
+	y := float64(svgPad)
+	for r, s := range sets {
+		title := fmt.Sprintf("n=%d, k=%d, %s", s.N, len(s.Arrangements), s.Layout)
+		if s.Tool != "" {
+			title += " (" + s.Tool + ")"
+		}
+		fmt.Fprintf(&sb, `<text x="%d" y="%s" font-weight="bold">%s</text>`+"\n", svgPad, num(y+14), escape(title))
+		y += svgTitle
+
+		covered := make(map[[2]int]bool)
+		x, rowH := float64(svgPad), 0.0
+		for i, p := range rows[r] {
+			color := coverage.Palette[i%len(coverage.Palette)]
+			arr := s.Arrangements[i]
+			at := func(v int) (float64, float64) {
+				return x + (p.xy[v][0]-p.minX+0.5)*d, y + (p.xy[v][1]-p.minY+0.5)*d
+			}
+			edges := s.Edges(i)
+			// old contacts first, so the new ones are drawn over them
+			var newEdges [][2]int
+			for _, e := range edges {
+				pr := pair(arr[e[0]], arr[e[1]])
+				if covered[pr] {
+					x1, y1 := at(e[0])
+					x2, y2 := at(e[1])
+					fmt.Fprintf(&sb, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="#bbb" stroke-width="%s"/>`+"\n",
+						num(x1), num(y1), num(x2), num(y2), num(d/20))
+					continue
+				}
+				newEdges = append(newEdges, e)
+			}
+			for _, e := range newEdges {
+				a, b := arr[e[0]], arr[e[1]]
+				covered[pair(a, b)] = true
+				x1, y1 := at(e[0])
+				x2, y2 := at(e[1])
+				fmt.Fprintf(&sb, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-width="%s" stroke-linecap="round"><title>%d-%d</title></line>`+"\n",
+					num(x1), num(y1), num(x2), num(y2), color, num(d/7), min(a, b), max(a, b))
+			}
+			for v, item := range arr {
+				cx, cy := at(v)
+				fmt.Fprintf(&sb, `<circle cx="%s" cy="%s" r="%s" fill="#f3e2c0" stroke="#b08d57"><title>vertex %d: item %d</title></circle>`+"\n",
+					num(cx), num(cy), num(d*0.34), v, item)
+				fmt.Fprintf(&sb, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="central" font-size="%s">%d</text>`+"\n",
+					num(cx), num(cy), num(d*0.36), item)
+			}
+			caption := fmt.Sprintf("%s: %d new of %d", s.Name(i), len(newEdges), len(edges))
+			fmt.Fprintf(&sb, `<text x="%s" y="%s" fill="%s">%s</text>`+"\n", num(x), num(y+p.height*d+14), color, escape(caption))
+			x += p.pixels(d) + svgPad
+			rowH = max(rowH, p.height*d)
+		}
+		y += rowH + svgLabel
+		if uncovered := s.Uncovered(); len(uncovered) > 0 {
+			pairs := make([]string, len(uncovered))
+			for j, pr := range uncovered {
+				pairs[j] = fmt.Sprintf("%d-%d", pr[0], pr[1])
+			}
+			fmt.Fprintf(&sb, `<text x="%d" y="%s" fill="red">uncovered: %s</text>`+"\n", svgPad, num(y+14), strings.Join(pairs, " "))
+			y += svgLabel
+		}
+		y += svgPad
+	}
+	sb.WriteString("</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func pair(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+func num(f float64) string {
+	return fmt.Sprintf("%.1f", f)
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,80 @@
+//go:build bliss
+
+package bliss
+
+/*
+#cgo CFLAGS: -I/opt/homebrew/include
+#cgo LDFLAGS: -L/opt/homebrew/lib -lbliss -lstdc++
+#cgo linux CFLAGS: -I/usr/include/bliss
+
+#include <stdlib.h>
+#include <bliss_C.h>
+
+// canonical_rows builds the n-vertex graph whose row i has bit j set for
+// each neighbor j, finds its canonical labeling and writes the relabeled
+// graph to out in the same layout. Each call has its own BlissGraph, so
+// calls may run concurrently.
+static int canonical_rows(const unsigned long long *in, unsigned long long *out, int n) {
+	BlissGraph *g = bliss_new(n);
+	BlissStats stats;
+	const unsigned int *lab;
+	int i, j;
+
+	if (g == NULL) {
+		return -1;
+	}
+	for (i = 0; i < n; i++) {
+		for (j = i + 1; j < n; j++) {
+			if ((in[i] >> j) & 1) {
+				bliss_add_edge(g, i, j);
+			}
+		}
+	}
+	lab = bliss_find_canonical_labeling(g, NULL, NULL, &stats);
+	for (i = 0; i < n; i++) {
+		out[i] = 0;
+	}
+	for (i = 0; i < n; i++) {
+		for (j = i + 1; j < n; j++) {
+			if ((in[i] >> j) & 1) {
+				out[lab[i]] |= 1ULL << lab[j];
+				out[lab[j]] |= 1ULL << lab[i];
+			}
+		}
+	}
+	bliss_release(g);
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// Available reports whether this binary was built with the bliss binding.
+const Available = true
+
+// CanonicalGraph returns bliss's canonical form of g: g relabeled by the
+// canonical labeling, so two graphs are isomorphic exactly when their forms
+// are equal. Safe for concurrent use.
+func CanonicalGraph(g invariants.Graph) (invariants.Graph, error) {
+	if g.N == 0 {
+		return g, nil
+	}
+	in := make([]C.ulonglong, g.N)
+	for i, row := range g.Adj {
+		in[i] = C.ulonglong(row)
+	}
+	out := make([]C.ulonglong, g.N)
+	if C.canonical_rows(&in[0], &out[0], C.int(g.N)) != 0 {
+		return invariants.Graph{}, errors.New("bliss: out of memory")
+	}
+	cg := invariants.New(g.N)
+	for i := range out {
+		cg.Adj[i] = uint64(out[i])
+	}
+	return cg, nil
+}
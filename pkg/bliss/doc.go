@@ -0,0 +1,10 @@
+// Package bliss canonicalizes graphs with Junttila and Kaski's bliss
+// library via its C API, so a benchmark can run millions of graphs in one
+// process instead of forking the bliss command per graph. The cgo code is
+// only built with the bliss build tag (go build -tags bliss), since it needs
+// libbliss and its headers; without the tag Available is false.
+//
+// Like pkg/nauty, the result is the full relabeled graph, so equal forms
+// mean isomorphic graphs and nothing can collide; the labeling differs from
+// nauty's.
+package bliss
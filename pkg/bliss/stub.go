@@ -0,0 +1,17 @@
+//go:build !bliss
+
+package bliss
+
+import (
+	"errors"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// Available reports whether this binary was built with the bliss binding.
+const Available = false
+
+// CanonicalGraph is only implemented with the bliss build tag.
+func CanonicalGraph(g invariants.Graph) (invariants.Graph, error) {
+	return invariants.Graph{}, errors.New("bliss: built without the bliss tag")
+}
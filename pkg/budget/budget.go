@@ -0,0 +1,100 @@
+// Package budget bounds a search by wall-clock time and node count, so a
+// run given -timeout or -max-nodes ends on its own with its summary
+// instead of being killed. A Budget wraps a context that is cancelled,
+// with the reason as its cause, when either limit is reached; hot loops
+// poll Stopped, which is a single atomic load.
+//
+// A nil *Budget is valid and never stops, so tools call it
+// unconditionally.
+package budget
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Why a run was stopped.
+var (
+	ErrTimeout   = errors.New("timeout")
+	ErrNodeLimit = errors.New("node limit reached")
+)
+
+// ExitStopped is the exit status of a tool whose budget ran out before it
+// could answer.
+const ExitStopped = 2
+
+// Budget tracks one run's limits.
+type Budget struct {
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	maxNodes int64
+	nodes    atomic.Int64
+	stopped  atomic.Bool
+}
+
+// New returns a Budget ending after timeout and after maxNodes nodes
+// reported through Add (0 means no limit for either), or nil if neither
+// is set.
+func New(timeout time.Duration, maxNodes int64) *Budget {
+	if timeout <= 0 && maxNodes <= 0 {
+		return nil
+	}
+	b := &Budget{maxNodes: maxNodes}
+	b.ctx, b.cancel = context.WithCancelCause(context.Background())
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() { b.Stop(ErrTimeout) })
+	}
+	return b
+}
+
+// Context returns a context cancelled when the budget runs out.
+func (b *Budget) Context() context.Context {
+	if b == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
+// Add counts n more nodes and stops the run once the limit is reached.
+// Callers batch their counts; the limit is checked at each call.
+func (b *Budget) Add(n int64) {
+	if b == nil {
+		return
+	}
+	if total := b.nodes.Add(n); b.maxNodes > 0 && total >= b.maxNodes {
+		b.Stop(ErrNodeLimit)
+	}
+}
+
+// Nodes returns the nodes counted so far.
+func (b *Budget) Nodes() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.nodes.Load()
+}
+
+// Stop ends the run with the given reason; only the first reason is kept.
+func (b *Budget) Stop(reason error) {
+	if b == nil {
+		return
+	}
+	b.cancel(reason)
+	b.stopped.Store(true)
+}
+
+// Stopped reports whether the run should stop.
+func (b *Budget) Stopped() bool {
+	return b != nil && b.stopped.Load()
+}
+
+// Err returns why the run was stopped (ErrTimeout or ErrNodeLimit), or
+// nil if it wasn't.
+func (b *Budget) Err() error {
+	if !b.Stopped() {
+		return nil
+	}
+	return context.Cause(b.ctx)
+}
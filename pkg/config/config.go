@@ -0,0 +1,280 @@
+// Package config lets a tool take its flags from a file, so an experiment
+// is a file to keep and share instead of a command line in shell history.
+// The file is a small subset of TOML: one "flag = value" per line, with
+// values in the same syntax as on the command line, and [name] sections for
+// named profiles:
+//
+//	# n=20 on the spiral, overlap pinned
+//	n = 20
+//	k = 5
+//	engine = "search"
+//	max-overlap = [5, 5, 5, 5]
+//	timeout = "6h"
+//
+//	[quick]
+//	timeout = "10m"
+//	workers = 4
+//
+// Keys are flag names; underscores may stand for dashes. Strings are quoted
+// ("..." with backslash escapes, or '...' taken literally), numbers and
+// true/false are bare, and an array is passed on as its elements joined by
+// commas, the way list flags are written. The lines outside any section
+// apply first, then those of the chosen profile. Flags given on the
+// command line win over the file.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Setting is one flag value taken from the file.
+type Setting struct {
+	Name, Value string
+}
+
+func (s Setting) String() string {
+	return s.Name + "=" + s.Value
+}
+
+// Apply sets fs's flags from the file at path, the top-level lines and then
+// those of section profile (none if ""), skipping flags set on the command
+// line. Call it after fs.Parse. It returns the settings applied, in file
+// order; an unknown flag, a missing profile or a value the flag rejects is
+// an error naming the line.
+func Apply(fs *flag.FlagSet, path, profile string) ([]Setting, error) {
+	sections, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := sections[""]
+	if profile != "" {
+		extra, ok := sections[profile]
+		if !ok {
+			var names []string
+			for name := range sections {
+				if name != "" {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("%s: no profile %q (has: %s)", path, profile, strings.Join(names, ", "))
+		}
+		// The profile's lines replace top-level ones for the same key
+		merged := make([]line, 0, len(lines)+len(extra))
+		override := make(map[string]bool)
+		for _, l := range extra {
+			override[l.key] = true
+		}
+		for _, l := range lines {
+			if !override[l.key] {
+				merged = append(merged, l)
+			}
+		}
+		lines = append(merged, extra...)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var applied []Setting
+	for _, l := range lines {
+		name := l.key
+		if fs.Lookup(name) == nil {
+			name = strings.ReplaceAll(name, "_", "-")
+		}
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s:%d: no flag -%s", path, l.num, l.key)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, l.value); err != nil {
+			return nil, fmt.Errorf("%s:%d: -%s: %v", path, l.num, name, err)
+		}
+		applied = append(applied, Setting{name, l.value})
+	}
+	return applied, nil
+}
+
+// line is one "key = value" of the file, with the value already in flag
+// syntax.
+type line struct {
+	num        int
+	key, value string
+}
+
+// load reads path into its sections; top-level lines are section "".
+func load(path string) (map[string][]line, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := map[string][]line{"": nil}
+	seen := make(map[string]int) // section + "\x00" + key: line number
+	section := ""
+	sc := bufio.NewScanner(f)
+	for num := 1; sc.Scan(); num++ {
+		text := strings.TrimSpace(stripComment(sc.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("%s:%d: bad section header %q", path, num, text)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: empty section name", path, num)
+			}
+			if _, dup := sections[section]; dup {
+				return nil, fmt.Errorf("%s:%d: profile [%s] defined twice", path, num, section)
+			}
+			sections[section] = nil
+			continue
+		}
+		key, raw, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, num)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, num, key, err)
+		}
+		if prev, dup := seen[section+"\x00"+key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s already set on line %d", path, num, key, prev)
+		}
+		seen[section+"\x00"+key] = num
+		sections[section] = append(sections[section], line{num, key, value})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sections, nil
+}
+
+// stripComment cuts s at a # outside quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parseValue turns a TOML value into flag syntax.
+func parseValue(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	if raw[0] != '[' {
+		return parseScalar(raw)
+	}
+	if !strings.HasSuffix(raw, "]") {
+		return "", fmt.Errorf("unterminated array %s", raw)
+	}
+	var elems []string
+	for _, e := range splitArray(raw[1 : len(raw)-1]) {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue // trailing comma
+		}
+		v, err := parseScalar(e)
+		if err != nil {
+			return "", err
+		}
+		elems = append(elems, v)
+	}
+	return strings.Join(elems, ","), nil
+}
+
+// splitArray splits the inside of an array at commas outside quotes.
+func splitArray(s string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseScalar(raw string) (string, error) {
+	switch raw[0] {
+	case '"':
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", raw)
+		}
+		return s, nil
+	case '\'':
+		if len(raw) < 2 || raw[len(raw)-1] != '\'' || strings.ContainsRune(raw[1:len(raw)-1], '\'') {
+			return "", fmt.Errorf("bad string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+	if strings.ContainsAny(raw, " \t[]\"'") {
+		return "", fmt.Errorf("bad value %s (quote strings)", raw)
+	}
+	return raw, nil
+}
+
+// Load applies the file at path, and its section profile, to the program's
+// command-line flags, for the tools' -config and -profile flags: without a
+// path there is nothing to do, and a profile needs a path.
+func Load(path, profile string) ([]Setting, error) {
+	if path == "" {
+		if profile != "" {
+			return nil, fmt.Errorf("-profile %s needs -config", profile)
+		}
+		return nil, nil
+	}
+	return Apply(flag.CommandLine, path, profile)
+}
+
+// Describe says where settings came from, for the tools' output, e.g.
+// "Config runs.toml [quick]: n=20 k=5 timeout=10m".
+func Describe(path, profile string, settings []Setting) string {
+	where := path
+	if profile != "" {
+		where += " [" + profile + "]"
+	}
+	parts := make([]string, len(settings))
+	for i, s := range settings {
+		parts[i] = s.String()
+	}
+	if len(parts) == 0 {
+		return "Config " + where + ": nothing applied"
+	}
+	return "Config " + where + ": " + strings.Join(parts, " ")
+}
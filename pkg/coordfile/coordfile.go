@@ -0,0 +1,205 @@
+// Package coordfile reads and writes the vertex-position files the tools
+// write next to their graphs, for plotting (hexclink plot,
+// mathematica/decode_g6.go, polyiamond_enum/plot_polyiamonds.py):
+//
+//	GRAPH <index>
+//	VERTICES <n>
+//	<q> <r>      one line per vertex
+//	EDGES <m>
+//	<i> <j>      one line per edge
+//
+// VERTICES blocks hold axial triangular-lattice coordinates, written by
+// polyiamond_enum -coords and hexclink lattice -coords. Embeddings found
+// numerically (verify_penny -coords) have POINTS instead, with x y in the
+// plane. Both have unit edges.
+package coordfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/zfile"
+)
+
+// Graph is one block of a coordinate file.
+type Graph struct {
+	Index int
+	// Lattice says Pos holds axial lattice coordinates (q, r), which are
+	// integers; otherwise Pos is in the plane.
+	Lattice bool
+	Pos     [][2]float64
+	Edges   [][2]int
+}
+
+// XY returns the positions in the plane: lattice point (q, r) is at
+// (q + r/2, r·√3/2), so lattice neighbors are 1 apart.
+func (g Graph) XY() [][2]float64 {
+	if !g.Lattice {
+		return g.Pos
+	}
+	xy := make([][2]float64, len(g.Pos))
+	for i, p := range g.Pos {
+		xy[i] = [2]float64{p[0] + p[1]/2, p[1] * math.Sqrt(3) / 2}
+	}
+	return xy
+}
+
+// Write writes g as one block.
+func Write(w io.Writer, g Graph) error {
+	keyword := "POINTS"
+	if g.Lattice {
+		keyword = "VERTICES"
+	}
+	fmt.Fprintf(w, "GRAPH %d\n%s %d\n", g.Index, keyword, len(g.Pos))
+	for _, p := range g.Pos {
+		if g.Lattice {
+			fmt.Fprintf(w, "%d %d\n", int(p[0]), int(p[1]))
+		} else {
+			fmt.Fprintf(w, "%.9g %.9g\n", p[0], p[1])
+		}
+	}
+	fmt.Fprintf(w, "EDGES %d\n", len(g.Edges))
+	var err error
+	for _, e := range g.Edges {
+		if _, err = fmt.Fprintf(w, "%d %d\n", e[0], e[1]); err != nil {
+			break
+		}
+	}
+	return err
+}
+
+// ReadFile reads every block of the file at path (optionally .gz/.zst).
+func ReadFile(path string) ([]Graph, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	graphs, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return graphs, nil
+}
+
+// Read reads every block from r. Errors start with the line number.
+func Read(r io.Reader) ([]Graph, error) {
+	p := parser{scanner: bufio.NewScanner(r)}
+	var graphs []Graph
+	for {
+		fields, err := p.next()
+		if err == io.EOF {
+			return graphs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != 2 || fields[0] != "GRAPH" {
+			return nil, p.errorf("expected GRAPH <index>")
+		}
+		g := Graph{}
+		if g.Index, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, p.errorf("bad index %q", fields[1])
+		}
+		if fields, err = p.header(); err != nil {
+			return nil, err
+		}
+		switch fields[0] {
+		case "VERTICES":
+			g.Lattice = true
+		case "POINTS":
+		default:
+			return nil, p.errorf("expected VERTICES or POINTS <count>")
+		}
+		if g.Pos, err = p.pairs(fields[1]); err != nil {
+			return nil, err
+		}
+		if fields, err = p.header(); err != nil {
+			return nil, err
+		}
+		if fields[0] != "EDGES" {
+			return nil, p.errorf("expected EDGES <count>")
+		}
+		edges, err := p.pairs(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			i, j := int(e[0]), int(e[1])
+			if float64(i) != e[0] || float64(j) != e[1] || i < 0 || j < 0 || i >= len(g.Pos) || j >= len(g.Pos) || i == j {
+				return nil, p.errorf("bad edge %v-%v for %d vertices", e[0], e[1], len(g.Pos))
+			}
+			g.Edges = append(g.Edges, [2]int{i, j})
+		}
+		graphs = append(graphs, g)
+	}
+}
+
+type parser struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// next returns the fields of the next non-blank line.
+func (p *parser) next() ([]string, error) {
+	for p.scanner.Scan() {
+		p.line++
+		if fields := strings.Fields(p.scanner.Text()); len(fields) > 0 {
+			return fields, nil
+		}
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// header returns a "<keyword> <count>" line inside a block.
+func (p *parser) header() ([]string, error) {
+	fields, err := p.next()
+	if err == io.EOF {
+		return nil, p.errorf("unexpected end of file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 2 {
+		return nil, p.errorf("expected <keyword> <count>")
+	}
+	return fields, nil
+}
+
+// pairs reads count lines of two numbers.
+func (p *parser) pairs(count string) ([][2]float64, error) {
+	k, err := strconv.Atoi(count)
+	if err != nil || k < 0 {
+		return nil, p.errorf("bad count %q", count)
+	}
+	result := make([][2]float64, k)
+	for i := range result {
+		fields, err := p.next()
+		if err == io.EOF {
+			return nil, p.errorf("unexpected end of file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != 2 {
+			return nil, p.errorf("expected two numbers")
+		}
+		for c := range fields {
+			if result[i][c], err = strconv.ParseFloat(fields[c], 64); err != nil {
+				return nil, p.errorf("bad number %q", fields[c])
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+// Package cover tracks which pairs of n items a set of arrangements covers.
+// An arrangement puts the items 0..n-1 on the slots of a host graph (arr[v]
+// is the item at vertex v) and covers every pair of items on adjacent
+// vertices; the solvers look for k arrangements covering all n(n-1)/2.
+//
+// Pairs are numbered as in pkg/pairs. A Table looks the index up in both
+// directions without the arithmetic, and a pairs.Set holds covered pairs as
+// a bitmap, which the search loops update and undo one pair at a time.
+package cover
+
+import (
+	"fmt"
+
+	"hexagon_clink/pkg/pairs"
+)
+
+// Table maps pairs of n items to their indices and back, precomputed from
+// pairs.PairIndex.
+type Table struct {
+	n     int
+	index []int    // index[a*n+b] (and b*n+a) for a != b
+	pairs [][2]int // pairs[i]: the pair (a, b), a < b, with index i
+}
+
+// NewTable returns the pair table of n items.
+func NewTable(n int) *Table {
+	t := &Table{n: n, index: make([]int, n*n), pairs: make([][2]int, pairs.Count(n))}
+	pairs.Each(n, func(i, a, b int) {
+		t.index[a*n+b], t.index[b*n+a] = i, i
+		t.pairs[i] = [2]int{a, b}
+	})
+	return t
+}
+
+// N returns the number of items.
+func (t *Table) N() int {
+	return t.n
+}
+
+// Len returns the number of pairs.
+func (t *Table) Len() int {
+	return len(t.pairs)
+}
+
+// Index returns the index of the pair {a, b}, a != b, in either order.
+func (t *Table) Index(a, b int) int {
+	return t.index[a*t.n+b]
+}
+
+// Pair returns the pair (a, b), a < b, with index i.
+func (t *Table) Pair(i int) [2]int {
+	return t.pairs[i]
+}
+
+// Cover adds the pairs arr covers on a host with the given edges to
+// covered and returns how many were not in it yet.
+func (t *Table) Cover(covered pairs.Set, arr []int, edges [][2]int) int {
+	added := 0
+	for _, e := range edges {
+		if pi := t.Index(arr[e[0]], arr[e[1]]); !covered.Has(pi) {
+			covered.Add(pi)
+			added++
+		}
+	}
+	return added
+}
+
+// Coverage returns the pairs the arrangements cover, arrs[i] laid out on
+// the host with edges hosts[i].
+func (t *Table) Coverage(arrs [][]int, hosts [][][2]int) pairs.Set {
+	covered := pairs.NewSet(t.Len())
+	for i, arr := range arrs {
+		t.Cover(covered, arr, hosts[i])
+	}
+	return covered
+}
+
+// Uncovered lists the pairs (a, b), a < b, not in covered, in index order.
+func (t *Table) Uncovered(covered pairs.Set) [][2]int {
+	var missing [][2]int
+	for i, p := range t.pairs {
+		if !covered.Has(i) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// CheckPermutation returns an error unless arr holds each of the items
+// 0..n-1 exactly once.
+func CheckPermutation(arr []int, n int) error {
+	if len(arr) != n {
+		return fmt.Errorf("%d items, want %d", len(arr), n)
+	}
+	seen := make([]bool, n)
+	for v, item := range arr {
+		if item < 0 || item >= n {
+			return fmt.Errorf("item %d at vertex %d is not in 0..%d", item, v, n-1)
+		}
+		if seen[item] {
+			return fmt.Errorf("item %d appears twice", item)
+		}
+		seen[item] = true
+	}
+	return nil
+}
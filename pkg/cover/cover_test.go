@@ -0,0 +1,234 @@
+package cover
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"hexagon_clink/pkg/lattice"
+	"hexagon_clink/pkg/pairs"
+)
+
+// eachPermutation calls f with every permutation of 0..n-1 (in a buffer f
+// must not keep).
+func eachPermutation(n int, f func([]int)) {
+	p := make([]int, n)
+	used := make([]bool, n)
+	var place func(i int)
+	place = func(i int) {
+		if i == n {
+			f(p)
+			return
+		}
+		for item := 0; item < n; item++ {
+			if !used[item] {
+				used[item], p[i] = true, item
+				place(i + 1)
+				used[item] = false
+			}
+		}
+	}
+	place(0)
+}
+
+// spiralEdges returns the edges of the spiral of n coins.
+func spiralEdges(n int) [][2]int {
+	g := lattice.Contact(lattice.Spiral(n))
+	var edges [][2]int
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			if g.HasEdge(a, b) {
+				edges = append(edges, [2]int{a, b})
+			}
+		}
+	}
+	return edges
+}
+
+func TestTable(t *testing.T) {
+	for n := 2; n <= 64; n++ {
+		tab := NewTable(n)
+		if tab.N() != n || tab.Len() != pairs.Count(n) {
+			t.Fatalf("n=%d: N %d, Len %d", n, tab.N(), tab.Len())
+		}
+		pairs.Each(n, func(i, a, b int) {
+			if tab.Index(a, b) != i || tab.Index(b, a) != i || tab.Pair(i) != [2]int{a, b} {
+				t.Fatalf("n=%d: pair %d (%d,%d): Index %d/%d, Pair %v", n, i, a, b, tab.Index(a, b), tab.Index(b, a), tab.Pair(i))
+			}
+		})
+	}
+}
+
+// TestCheckPermutation runs every sequence of n items from 0..n, which
+// includes duplicates and the out-of-range item n.
+func TestCheckPermutation(t *testing.T) {
+	for n := 1; n <= 6; n++ {
+		accepted, factorial := 0, 1
+		for i := 2; i <= n; i++ {
+			factorial *= i
+		}
+		seq := make([]int, n)
+		for {
+			distinct := true
+			seen := make([]bool, n+1)
+			for _, item := range seq {
+				distinct = distinct && item < n && !seen[item]
+				seen[item] = true
+			}
+			err := CheckPermutation(seq, n)
+			if (err == nil) != distinct {
+				t.Fatalf("n=%d: CheckPermutation(%v) = %v", n, seq, err)
+			}
+			if err == nil {
+				accepted++
+			}
+			// Next sequence, counting in base n+1
+			k := 0
+			for k < n && seq[k] == n {
+				seq[k] = 0
+				k++
+			}
+			if k == n {
+				break
+			}
+			seq[k]++
+		}
+		if accepted != factorial {
+			t.Errorf("n=%d: %d sequences accepted, want %d", n, accepted, factorial)
+		}
+	}
+}
+
+func TestCheckPermutationMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		arr []int
+		n   int
+	}{
+		{[]int{0, 1, 1}, 3},    // duplicate
+		{[]int{2, 0, 2, 1}, 4}, // duplicate, one item missing
+		{[]int{0, 1, 3}, 3},    // out of range
+		{[]int{0, -1, 1}, 3},   // negative
+		{[]int{0, 1}, 3},       // too short
+		{[]int{0, 1, 2, 3}, 3}, // too long
+		{nil, 2},
+	} {
+		if err := CheckPermutation(tc.arr, tc.n); err == nil {
+			t.Errorf("CheckPermutation(%v, %d) accepted", tc.arr, tc.n)
+		}
+	}
+	if err := CheckPermutation(nil, 0); err != nil {
+		t.Errorf("empty permutation: %v", err)
+	}
+}
+
+// TestCoverage lays every pair of permutations out on the spiral and
+// compares the covered, uncovered and short pairs with an adjacency count.
+func TestCoverage(t *testing.T) {
+	for n := 2; n <= 6; n++ {
+		tab := NewTable(n)
+		edges := spiralEdges(n)
+		var perms [][]int
+		eachPermutation(n, func(p []int) { perms = append(perms, slices.Clone(p)) })
+		hosts := [][][2]int{edges, edges}
+		adj := make([]int, n*n)
+		for _, p := range perms {
+			for _, q := range perms {
+				clear(adj)
+				for _, arr := range [][]int{p, q} {
+					for _, e := range edges {
+						adj[arr[e[0]]*n+arr[e[1]]]++
+						adj[arr[e[1]]*n+arr[e[0]]]++
+					}
+				}
+				var want, wantTwice [][2]int
+				for a := 0; a < n; a++ {
+					for b := a + 1; b < n; b++ {
+						if adj[a*n+b] == 0 {
+							want = append(want, [2]int{a, b})
+						}
+						if adj[a*n+b] < 2 {
+							wantTwice = append(wantTwice, [2]int{a, b})
+						}
+					}
+				}
+
+				arrs := [][]int{p, q}
+				covered := tab.Coverage(arrs, hosts)
+				got := tab.Uncovered(covered)
+				if !slices.Equal(got, want) || covered.Count() != tab.Len()-len(want) {
+					t.Fatalf("n=%d %v %v: uncovered %v (%d covered), want %v", n, p, q, got, covered.Count(), want)
+				}
+				counts := tab.Counts(arrs, hosts)
+				if got := tab.Short(counts, Uniform(n, 1)); !slices.Equal(got, want) {
+					t.Fatalf("n=%d %v %v: short of 1 %v, want %v", n, p, q, got, want)
+				}
+				if got := tab.Short(counts, Uniform(n, 2)); !slices.Equal(got, wantTwice) {
+					t.Fatalf("n=%d %v %v: short of 2 %v, want %v", n, p, q, got, wantTwice)
+				}
+			}
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	const n = 6
+	tab := NewTable(n)
+	edges := spiralEdges(n)
+	covered := pairs.NewSet(tab.Len())
+	arr := []int{0, 1, 2, 3, 4, 5}
+	if added := tab.Cover(covered, arr, edges); added != len(edges) {
+		t.Errorf("first cover added %d, want %d", added, len(edges))
+	}
+	if added := tab.Cover(covered, arr, edges); added != 0 {
+		t.Errorf("second cover added %d, want 0", added)
+	}
+	if covered.Count() != len(edges) {
+		t.Errorf("%d pairs covered, want %d", covered.Count(), len(edges))
+	}
+}
+
+func TestReadMultiplicity(t *testing.T) {
+	const n = 5
+	dir := t.TempDir()
+	write := func(text string) string {
+		path := filepath.Join(dir, "m.txt")
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	m, err := ReadMultiplicity(write("# every pair twice\ndefault 2\n\n1 0 2 # listed at the default\n"), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(m, Uniform(n, 2)) || !m.IsUniform() || m.Total() != 2*pairs.Count(n) {
+		t.Errorf("uniform file read as %v", m)
+	}
+
+	m, err = ReadMultiplicity(write("0 1 3\n2 4 0\n"), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.IsUniform() || m[pairs.PairIndex(n, 0, 1)] != 3 || m[pairs.PairIndex(n, 2, 4)] != 0 || m.Max() != 3 {
+		t.Errorf("mixed file read as %v", m)
+	}
+	if m.Total() != pairs.Count(n)-2+3 {
+		t.Errorf("mixed file: total %d", m.Total())
+	}
+
+	for _, bad := range []string{
+		"0 1\n",
+		"0 0 2\n",
+		"0 5 1\n",
+		"0 1 -1\n",
+		"0 1 2\n1 0 2\n",
+		"default 1\ndefault 2\n",
+		"default\n",
+	} {
+		if _, err := ReadMultiplicity(write(bad), n); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package coverage shows how a solution's arrangements cover the pairs of
+// items: the n×n matrix of which arrangements cover each pair, rendered as
+// text or SVG with one color per arrangement, and per-arrangement overlap
+// statistics. A solution covers every pair; the matrix shows where the
+// arrangements overlap and which of them carries which part of the pairs.
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Arrangement is one arrangement of a solution: the item at each slot of
+// its host graph and the graph's edges between slots.
+type Arrangement struct {
+	Name  string // label, e.g. "arr1" or "arr1 (B)"
+	Items []int  // Items[slot]
+	Edges [][2]int
+}
+
+// Stats counts one arrangement's pairs. Overlap is Edges - New: edges on
+// pairs an earlier arrangement already covers (or on the same pair twice).
+type Stats struct {
+	Name    string `json:"name"`
+	Edges   int    `json:"edges"`
+	New     int    `json:"new"`     // pairs no earlier arrangement covers
+	Overlap int    `json:"overlap"` // edges on already covered pairs
+	Only    int    `json:"only"`    // pairs no other arrangement covers
+}
+
+// Matrix records which arrangements cover each pair of n items.
+type Matrix struct {
+	n    int
+	arrs []Arrangement
+	by   [][][]int // by[a][b] (and by[b][a]): arrangements covering {a, b}, in order
+}
+
+// New builds the coverage matrix of arrs on n items.
+func New(n int, arrs []Arrangement) *Matrix {
+	m := &Matrix{n: n, arrs: arrs, by: make([][][]int, n)}
+	for a := range m.by {
+		m.by[a] = make([][]int, n)
+	}
+	for i, arr := range arrs {
+		for _, e := range arr.Edges {
+			a, b := arr.Items[e[0]], arr.Items[e[1]]
+			if l := m.by[a][b]; len(l) == 0 || l[len(l)-1] != i {
+				m.by[a][b] = append(m.by[a][b], i)
+				m.by[b][a] = m.by[a][b]
+			}
+		}
+	}
+	return m
+}
+
+// Stats returns the statistics of each arrangement, in order.
+func (m *Matrix) Stats() []Stats {
+	stats := make([]Stats, len(m.arrs))
+	seen := make(map[[2]int]bool)
+	for i, arr := range m.arrs {
+		st := &stats[i]
+		st.Name, st.Edges = arr.Name, len(arr.Edges)
+		for _, e := range arr.Edges {
+			p := pair(arr.Items[e[0]], arr.Items[e[1]])
+			if !seen[p] {
+				seen[p] = true
+				st.New++
+			}
+		}
+		st.Overlap = st.Edges - st.New
+	}
+	for a := 0; a < m.n; a++ {
+		for b := a + 1; b < m.n; b++ {
+			if l := m.by[a][b]; len(l) == 1 {
+				stats[l[0]].Only++
+			}
+		}
+	}
+	return stats
+}
+
+// Totals returns the number of pairs, those covered, those covered by more
+// than one arrangement and those not covered.
+func (m *Matrix) Totals() (pairs, covered, multiple, uncovered int) {
+	for a := 0; a < m.n; a++ {
+		for b := a + 1; b < m.n; b++ {
+			pairs++
+			switch len(m.by[a][b]) {
+			case 0:
+				uncovered++
+			case 1:
+				covered++
+			default:
+				covered++
+				multiple++
+			}
+		}
+	}
+	return pairs, covered, multiple, uncovered
+}
+
+func pair(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// symbol is arrangement i's mark in the text matrix.
+func symbol(i int) byte {
+	const symbols = "0123456789abcdefghijklmnopqrstuvwxyz"
+	if i < len(symbols) {
+		return symbols[i]
+	}
+	return '?'
+}
+
+// WriteText writes the matrix, one character per pair (the covering
+// arrangement's index, * for more than one, . for none), followed by the
+// statistics table.
+func (m *Matrix) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	width := len(fmt.Sprint(m.n-1)) + 1
+	fmt.Fprintf(&sb, "%*s", width, "")
+	for b := 0; b < m.n; b++ {
+		fmt.Fprintf(&sb, "%*d", width, b)
+	}
+	sb.WriteByte('\n')
+	for a := 0; a < m.n; a++ {
+		fmt.Fprintf(&sb, "%*d", width, a)
+		for b := 0; b < m.n; b++ {
+			c := byte('.')
+			switch l := m.by[a][b]; {
+			case a == b:
+				c = '\\'
+			case len(l) == 1:
+				c = symbol(l[0])
+			case len(l) > 1:
+				c = '*'
+			}
+			fmt.Fprintf(&sb, "%*c", width, c)
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("(digit: the arrangement covering the pair, *: more than one, .: none)\n\n")
+
+	nameWidth := len("arrangement")
+	for _, arr := range m.arrs {
+		nameWidth = max(nameWidth, len(arr.Name)+4)
+	}
+	fmt.Fprintf(&sb, "%-*s %6s %6s %8s %6s\n", nameWidth, "arrangement", "edges", "new", "overlap", "only")
+	for i, st := range m.Stats() {
+		fmt.Fprintf(&sb, "%-*s %6d %6d %8d %6d\n", nameWidth, fmt.Sprintf("%c: %s", symbol(i), st.Name), st.Edges, st.New, st.Overlap, st.Only)
+	}
+	pairs, covered, multiple, uncovered := m.Totals()
+	fmt.Fprintf(&sb, "%d pairs: %d covered (%d more than once), %d uncovered\n", pairs, covered, multiple, uncovered)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Palette colors the arrangements in the SVG (and in hexclink
+// render-arrangement); more than ten reuse colors.
+var Palette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// WriteSVG draws the matrix: each cell in the color of the arrangement
+// covering its pair, split into stripes when several do, white with a red
+// border when none does. Hovering a cell names the pair and its
+// arrangements. A legend with the statistics sits on the right.
+func (m *Matrix) WriteSVG(w io.Writer) error {
+	const cell, margin = 16, 28
+	size := margin + m.n*cell
+	legendX := size + 20
+	width := legendX + 320
+	height := max(size, margin+(len(m.arrs)+2)*20) + 10
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="10">`+"\n", width, height)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	for i := 0; i < m.n; i++ {
+		p := margin + i*cell + cell/2
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="middle">%d</text>`+"\n", p, margin-6, i)
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="end" dominant-baseline="middle">%d</text>`+"\n", margin-6, p, i)
+	}
+	for a := 0; a < m.n; a++ {
+		for b := 0; b < m.n; b++ {
+			x, y := margin+b*cell, margin+a*cell
+			l := m.by[a][b]
+			switch {
+			case a == b:
+				fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="#333"/>`+"\n", x, y, cell, cell)
+				continue
+			case len(l) == 0:
+				fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="white" stroke="red"/>`+"\n", x+1, y+1, cell-2, cell-2)
+			default:
+				for j, i := range l {
+					x0, x1 := x+j*cell/len(l), x+(j+1)*cell/len(l)
+					fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", x0, y, x1-x0, cell, Palette[i%len(Palette)])
+				}
+			}
+			names := make([]string, len(l))
+			for j, i := range l {
+				names[j] = m.arrs[i].Name
+			}
+			if len(names) == 0 {
+				names = []string{"uncovered"}
+			}
+			fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="white" stroke-width="0.5"><title>%d-%d: %s</title></rect>`+"\n",
+				x, y, cell, cell, min(a, b), max(a, b), escape(strings.Join(names, ", ")))
+		}
+	}
+
+	y := margin
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" font-weight="bold">edges / new / overlap / only</text>`+"\n", legendX, y)
+	for i, st := range m.Stats() {
+		y += 20
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`+"\n", legendX, y-10, Palette[i%len(Palette)])
+		fmt.Fprintf(&sb, `<text x="%d" y="%d">%s: %d / %d / %d / %d</text>`+"\n", legendX+18, y, escape(st.Name), st.Edges, st.New, st.Overlap, st.Only)
+	}
+	pairs, covered, multiple, uncovered := m.Totals()
+	fmt.Fprintf(&sb, `<text x="%d" y="%d">%d pairs: %d covered (%d more than once), %d uncovered</text>`+"\n", legendX, y+30, pairs, covered, multiple, uncovered)
+	sb.WriteString("</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// SaveSVG writes the SVG rendering to path.
+func (m *Matrix) SaveSVG(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.WriteSVG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,296 @@
+// Package encoding builds the CNF formulas of the SAT side of the search:
+// arrangements as permutation matrices of variables (x(item, slot) is true
+// if item sits at slot), at-most-one constraints in several encodings, and
+// the auxiliary variables that make a pair of items adjacent in a host
+// graph. find_fourth (arr3 for a given arr1, arr2) and solver_general
+// -engine sat (all arrangements at once) both build their formulas here.
+//
+// Variables are numbered from 1 as in DIMACS, and literals are variables
+// or their negations; a model as gophersat returns it has the value of
+// variable v at index v-1.
+package encoding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CNF collects clauses over 1-indexed variables.
+type CNF struct {
+	NumVars int
+	Clauses [][]int
+}
+
+// NewVar returns a fresh variable.
+func (c *CNF) NewVar() int {
+	c.NumVars++
+	return c.NumVars
+}
+
+// Add adds the clause of lits. The slice is kept, not copied.
+func (c *CNF) Add(lits ...int) {
+	c.Clauses = append(c.Clauses, lits)
+}
+
+// WriteDIMACS writes the formula in DIMACS form.
+func (c *CNF) WriteDIMACS(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "p cnf %d %d\n", c.NumVars, len(c.Clauses))
+	for _, clause := range c.Clauses {
+		for _, lit := range clause {
+			fmt.Fprintf(bw, "%d ", lit)
+		}
+		fmt.Fprintln(bw, "0")
+	}
+	return bw.Flush()
+}
+
+// At-most-one encodings. For m variables: pairwise needs m(m-1)/2 clauses
+// and no new variables; sequential (Sinz 2005) 3m-4 clauses and m-1
+// variables; commander (Klieber & Kwon 2007) groups of three under a
+// commander variable, about 3m clauses and m/2 variables; product (Chen
+// 2010) places the variables on a p x q grid, about 2m + 4sqrt(m) clauses
+// and 2sqrt(m) variables.
+const (
+	Pairwise   = "pairwise"
+	Sequential = "sequential"
+	Commander  = "commander"
+	Product    = "product"
+)
+
+// Encodings lists the at-most-one encodings.
+var Encodings = []string{Pairwise, Sequential, Commander, Product}
+
+// CheckAMO reports an unknown at-most-one encoding.
+func CheckAMO(enc string) error {
+	for _, e := range Encodings {
+		if e == enc {
+			return nil
+		}
+	}
+	return fmt.Errorf("at-most-one encoding %q: want one of %v", enc, Encodings)
+}
+
+// AtMostOne adds clauses allowing at most one of vars to be true, in the
+// encoding enc.
+func (c *CNF) AtMostOne(enc string, vars []int) {
+	m := len(vars)
+	if m < 2 {
+		return
+	}
+	if m <= 4 || enc == Pairwise {
+		// the others need more clauses than this for so few variables
+		for i := 0; i < m; i++ {
+			for j := i + 1; j < m; j++ {
+				c.Add(-vars[i], -vars[j])
+			}
+		}
+		return
+	}
+	switch enc {
+	case Sequential:
+		// s[i] is true once one of vars[0..i] is true
+		s := make([]int, m-1)
+		for i := range s {
+			s[i] = c.NewVar()
+		}
+		c.Add(-vars[0], s[0])
+		for i := 1; i < m-1; i++ {
+			c.Add(-vars[i], s[i])
+			c.Add(-s[i-1], s[i])
+			c.Add(-vars[i], -s[i-1])
+		}
+		c.Add(-vars[m-1], -s[m-2])
+
+	case Commander:
+		// each group of three has a commander that is true if one of the
+		// group is; at most one per group, then at most one commander
+		var commanders []int
+		for g := 0; g < m; g += 3 {
+			group := vars[g:min(g+3, m)]
+			c.AtMostOne(Pairwise, group)
+			cmd := c.NewVar()
+			for _, v := range group {
+				c.Add(-v, cmd)
+			}
+			commanders = append(commanders, cmd)
+		}
+		c.AtMostOne(enc, commanders)
+
+	case Product:
+		// vars[k] sits at row k/q, column k%q and implies both; at most
+		// one row and one column
+		p := 1
+		for p*p < m {
+			p++
+		}
+		q := (m + p - 1) / p
+		rows, cols := make([]int, p), make([]int, q)
+		for i := range rows {
+			rows[i] = c.NewVar()
+		}
+		for j := range cols {
+			cols[j] = c.NewVar()
+		}
+		for k, v := range vars {
+			c.Add(-v, rows[k/q])
+			c.Add(-v, cols[k%q])
+		}
+		c.AtMostOne(enc, rows)
+		c.AtMostOne(enc, cols)
+
+	default:
+		panic("encoding: unknown at-most-one encoding " + enc)
+	}
+}
+
+// ExactlyOne adds one clause for at least one of vars and the at-most-one
+// clauses in encoding enc.
+func (c *CNF) ExactlyOne(enc string, vars []int) {
+	c.Add(append([]int(nil), vars...)...)
+	c.AtMostOne(enc, vars)
+}
+
+// AtLeast adds clauses requiring at least r of vars to be true: every
+// len(vars)-r+1 of them include a true one. That is one clause per subset,
+// C(m, r-1) of them for m variables, few enough for the handful of
+// arrangements solver_general counts over.
+func (c *CNF) AtLeast(r int, vars []int) {
+	m := len(vars)
+	if r <= 0 {
+		return
+	}
+	if r > m {
+		v := c.NewVar() // unsatisfiable, without an empty clause
+		c.Add(v)
+		c.Add(-v)
+		return
+	}
+	pick := make([]int, m-r+1)
+	var rec func(pos, from int)
+	rec = func(pos, from int) {
+		if pos == len(pick) {
+			c.Add(append([]int(nil), pick...)...)
+			return
+		}
+		for i := from; i <= m-(len(pick)-pos); i++ {
+			pick[pos] = vars[i]
+			rec(pos+1, i+1)
+		}
+	}
+	rec(0, 0)
+}
+
+// And returns a new variable equivalent to the conjunction of lits (the
+// Tseitin encoding: it implies each literal, and all of them imply it).
+func (c *CNF) And(lits ...int) int {
+	v := c.NewVar()
+	back := []int{v}
+	for _, l := range lits {
+		c.Add(-v, l)
+		back = append(back, -l)
+	}
+	c.Add(back...)
+	return v
+}
+
+// Permutation is an arrangement of n items at n slots as n*n variables.
+type Permutation struct {
+	n, first int
+}
+
+// NewPermutation allocates the n*n variables of an arrangement of n items.
+// They are numbered consecutively, so allocating all arrangements before
+// their constraints (whose encodings may add variables) keeps each
+// arrangement's variables in one block after the other.
+func (c *CNF) NewPermutation(n int) Permutation {
+	p := Permutation{n: n, first: c.NumVars + 1}
+	c.NumVars += n * n
+	return p
+}
+
+// PermutationConstraints requires each item of p at exactly one slot and
+// each slot to hold exactly one item, with at-most-one encoding enc.
+func (c *CNF) PermutationConstraints(p Permutation, enc string) {
+	vars := make([]int, p.n)
+	for item := 0; item < p.n; item++ {
+		for slot := 0; slot < p.n; slot++ {
+			vars[slot] = p.Var(item, slot)
+		}
+		c.ExactlyOne(enc, vars)
+	}
+	for slot := 0; slot < p.n; slot++ {
+		for item := 0; item < p.n; item++ {
+			vars[item] = p.Var(item, slot)
+		}
+		c.ExactlyOne(enc, vars)
+	}
+}
+
+// Var returns the variable that is true if item sits at slot.
+func (p Permutation) Var(item, slot int) int {
+	return p.first + item*p.n + slot
+}
+
+// Decode returns the arrangement (the item at each slot) in model.
+func (p Permutation) Decode(model []bool) []int {
+	arr := make([]int, p.n)
+	for item := 0; item < p.n; item++ {
+		for slot := 0; slot < p.n; slot++ {
+			if v := p.Var(item, slot); v <= len(model) && model[v-1] {
+				arr[slot] = item
+			}
+		}
+	}
+	return arr
+}
+
+// Arcs returns both directions of each edge between slots, sorted, for
+// Adjacent.
+func Arcs(edges [][2]int) [][2]int {
+	arcs := make([][2]int, 0, 2*len(edges))
+	for _, e := range edges {
+		arcs = append(arcs, e, [2]int{e[1], e[0]})
+	}
+	sort.Slice(arcs, func(i, j int) bool {
+		if arcs[i][0] != arcs[j][0] {
+			return arcs[i][0] < arcs[j][0]
+		}
+		return arcs[i][1] < arcs[j][1]
+	})
+	return arcs
+}
+
+// Adjacent returns one variable per arc (s, t), equivalent to a at s and b
+// at t in p (And). A clause over them requires a and b to be neighbors.
+func (c *CNF) Adjacent(p Permutation, a, b int, arcs [][2]int) []int {
+	ways := make([]int, len(arcs))
+	for i, arc := range arcs {
+		ways[i] = c.And(p.Var(a, arc[0]), p.Var(b, arc[1]))
+	}
+	return ways
+}
+
+// NextTo returns one variable per slot with neighbors, implying a at that
+// slot and b at one of its neighbors in p. That is all a clause over them
+// needs, with a variable per slot instead of per arc and two clauses
+// each; the variables can't be used negated.
+func (c *CNF) NextTo(p Permutation, a, b int, neighbors [][]int) []int {
+	var ways []int
+	for slot, nbrs := range neighbors {
+		if len(nbrs) == 0 {
+			continue
+		}
+		at := c.NewVar()
+		ways = append(ways, at)
+		c.Add(-at, p.Var(a, slot))
+		next := []int{-at}
+		for _, t := range nbrs {
+			next = append(next, p.Var(b, t))
+		}
+		c.Add(next...)
+	}
+	return ways
+}
@@ -0,0 +1,237 @@
+package encoding
+
+import (
+	"fmt"
+	"math/bits"
+	"testing"
+
+	"hexagon_clink/pkg/cover"
+)
+
+// satisfies reports whether the assignment with variable v true iff bit
+// v-1 of set satisfies every clause of c.
+func satisfies(c *CNF, set uint64) bool {
+	for _, clause := range c.Clauses {
+		sat := false
+		for _, lit := range clause {
+			v := lit
+			if v < 0 {
+				v = -v
+			}
+			if (set>>(v-1)&1 == 1) == (lit > 0) {
+				sat = true
+				break
+			}
+		}
+		if !sat {
+			return false
+		}
+	}
+	return true
+}
+
+// models returns the assignments of variables 1..m (bit v-1 for v) that
+// extend to a model of c, trying every assignment of all its variables.
+func models(t *testing.T, c *CNF, m int) map[uint64]bool {
+	t.Helper()
+	if c.NumVars > 24 {
+		t.Fatalf("%d variables, too many to enumerate", c.NumVars)
+	}
+	out := make(map[uint64]bool)
+	for set := uint64(0); set < 1<<c.NumVars; set++ {
+		if satisfies(c, set) {
+			out[set&(1<<m-1)] = true
+		}
+	}
+	return out
+}
+
+// checkModels compares the models of c over variables 1..m with the
+// assignments want accepts.
+func checkModels(t *testing.T, name string, c *CNF, m int, want func(set uint64) bool) {
+	t.Helper()
+	got := models(t, c, m)
+	for set := uint64(0); set < 1<<m; set++ {
+		if got[set] != want(set) {
+			t.Errorf("%s: assignment %0*b is a model: %v, want %v", name, m, set, got[set], want(set))
+			return
+		}
+	}
+}
+
+func vars(m int) []int {
+	v := make([]int, m)
+	for i := range v {
+		v[i] = i + 1
+	}
+	return v
+}
+
+func TestAtMostOne(t *testing.T) {
+	for _, enc := range Encodings {
+		for m := 0; m <= 10; m++ {
+			c := &CNF{NumVars: m}
+			c.AtMostOne(enc, vars(m))
+			checkModels(t, fmt.Sprintf("%s m=%d", enc, m), c, m, func(set uint64) bool {
+				return bits.OnesCount64(set) <= 1
+			})
+		}
+	}
+}
+
+func TestExactlyOne(t *testing.T) {
+	for _, enc := range Encodings {
+		for m := 1; m <= 10; m++ {
+			c := &CNF{NumVars: m}
+			c.ExactlyOne(enc, vars(m))
+			checkModels(t, fmt.Sprintf("%s m=%d", enc, m), c, m, func(set uint64) bool {
+				return bits.OnesCount64(set) == 1
+			})
+		}
+	}
+}
+
+func TestCheckAMO(t *testing.T) {
+	for _, enc := range Encodings {
+		if err := CheckAMO(enc); err != nil {
+			t.Error(err)
+		}
+	}
+	if CheckAMO("bimander") == nil {
+		t.Error("unknown encoding accepted")
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	for m := 0; m <= 7; m++ {
+		for r := 0; r <= m+1; r++ {
+			c := &CNF{NumVars: m}
+			c.AtLeast(r, vars(m))
+			checkModels(t, fmt.Sprintf("m=%d r=%d", m, r), c, m, func(set uint64) bool {
+				return bits.OnesCount64(set) >= r
+			})
+		}
+	}
+}
+
+func TestAnd(t *testing.T) {
+	// Every sign pattern of up to four literals; the And variable is m+1
+	for m := 0; m <= 4; m++ {
+		for signs := 0; signs < 1<<m; signs++ {
+			lits := vars(m)
+			for i := range lits {
+				if signs>>i&1 == 1 {
+					lits[i] = -lits[i]
+				}
+			}
+			c := &CNF{NumVars: m}
+			v := c.And(lits...)
+			if v != m+1 {
+				t.Fatalf("And returned variable %d, want %d", v, m+1)
+			}
+			checkModels(t, fmt.Sprintf("And%v", lits), c, m+1, func(set uint64) bool {
+				all := true
+				for i := range lits {
+					all = all && (set>>i&1 == 1) == (lits[i] > 0)
+				}
+				return (set>>m&1 == 1) == all
+			})
+		}
+	}
+}
+
+// TestPermutation checks that the models of the permutation constraints
+// are exactly the permutation matrices, and Decode reads them back.
+func TestPermutation(t *testing.T) {
+	for n := 1; n <= 4; n++ {
+		c := &CNF{}
+		p := c.NewPermutation(n)
+		c.PermutationConstraints(p, Pairwise)
+		got := models(t, c, n*n)
+		perms := 0
+		for set := range got {
+			model := make([]bool, n*n)
+			for v := range model {
+				model[v] = set>>v&1 == 1
+			}
+			arr := p.Decode(model)
+			if err := cover.CheckPermutation(arr, n); err != nil {
+				t.Fatalf("n=%d: model %0*b decodes to %v: %v", n, n*n, set, arr, err)
+			}
+			// The model is the matrix of arr and nothing else
+			var want uint64
+			for slot, item := range arr {
+				want |= 1 << (p.Var(item, slot) - 1)
+			}
+			if set != want {
+				t.Fatalf("n=%d: model %0*b is not the matrix of %v", n, n*n, set, arr)
+			}
+			perms++
+		}
+		factorial := 1
+		for i := 2; i <= n; i++ {
+			factorial *= i
+		}
+		if perms != factorial {
+			t.Errorf("n=%d: %d models, want %d", n, perms, factorial)
+		}
+	}
+}
+
+// TestAdjacent lays every permutation of 4 items on a path and a star and
+// checks that a clause over Adjacent's and NextTo's variables is
+// satisfiable exactly when the two items are neighbors.
+func TestAdjacent(t *testing.T) {
+	const n = 4
+	tab := cover.NewTable(n)
+	for _, edges := range [][][2]int{
+		{{0, 1}, {1, 2}, {2, 3}},
+		{{0, 1}, {0, 2}, {0, 3}},
+	} {
+		neighbors := make([][]int, n)
+		for _, e := range edges {
+			neighbors[e[0]] = append(neighbors[e[0]], e[1])
+			neighbors[e[1]] = append(neighbors[e[1]], e[0])
+		}
+		for a := 0; a < n; a++ {
+			for b := 0; b < n; b++ {
+				if a == b {
+					continue
+				}
+				adj := &CNF{}
+				p := adj.NewPermutation(n)
+				adj.PermutationConstraints(p, Pairwise)
+				adj.Add(adj.Adjacent(p, a, b, Arcs(edges))...)
+				next := &CNF{}
+				q := next.NewPermutation(n) // the same variables as p
+				next.PermutationConstraints(q, Pairwise)
+				next.Add(next.NextTo(q, a, b, neighbors)...)
+
+				for _, c := range []*CNF{adj, next} {
+					got := models(t, c, n*n)
+					count := 0
+					for set := range got {
+						model := make([]bool, n*n)
+						for v := range model {
+							model[v] = set>>v&1 == 1
+						}
+						arr := p.Decode(model)
+						if err := cover.CheckPermutation(arr, n); err != nil {
+							t.Fatalf("model decodes to %v: %v", arr, err)
+						}
+						if !tab.Coverage([][]int{arr}, [][][2]int{edges}).Has(tab.Index(a, b)) {
+							t.Fatalf("edges %v: %v is a model but %d, %d aren't neighbors", edges, arr, a, b)
+						}
+						count++
+					}
+					// a and b on the ends of an edge either way round, the
+					// other two items on the other slots either way
+					want := len(edges) * 2 * 2
+					if count != want {
+						t.Errorf("edges %v, items %d, %d: %d models, want %d", edges, a, b, count, want)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package estimate predicts the size of a backtracking search before it
+// runs, with Knuth's estimator (Knuth 1975, "Estimating the efficiency of
+// backtrack programs"): a probe walks from the root to a leaf, picking one
+// child uniformly at random at each node, and each node on its path stands
+// for the product of the branching factors above it. Averaged over the
+// probes this is an unbiased estimate of the number of nodes, and the same
+// weights summed over the nodes of some kind (the candidates a generator
+// writes, say) estimate how many there are. Lopsided trees make the
+// estimates spread widely, so each comes with its standard error.
+//
+// The probes do the work of the search at every node they visit, so the
+// time spent at each node, weighted the same way, predicts the search's
+// running time; leaves that run expensive filters count for what they
+// cost.
+package estimate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Estimator collects the probes of one tree. Counters are numbered from 0
+// by the caller.
+type Estimator struct {
+	rng    *rand.Rand
+	probes int
+	nodes  stat
+	time   stat // seconds
+	counts []stat
+	walked int64 // nodes the probes visited
+}
+
+// stat sums one quantity and its square over the probes.
+type stat struct {
+	sum, sumSq float64
+}
+
+func (s *stat) add(x float64) {
+	s.sum += x
+	s.sumSq += x * x
+}
+
+// Probe is one walk from the root.
+type Probe struct {
+	weight float64
+	nodes  float64
+	time   float64
+	last   time.Time // when the walk reached the current node
+	counts []float64
+	e      *Estimator
+}
+
+// New returns an estimator whose probes choose with the given seed.
+func New(seed int64) *Estimator {
+	return &Estimator{rng: rand.New(rand.NewSource(seed))}
+}
+
+// Run makes walks, each a call of walk starting at the root, until it has
+// made probes of them or budget has passed (0: no limit).
+func (e *Estimator) Run(probes int, budget time.Duration, walk func(p *Probe)) {
+	start := time.Now()
+	for i := 0; probes <= 0 || i < probes; i++ {
+		if budget > 0 && i%64 == 0 && time.Since(start) > budget {
+			break
+		}
+		p := &Probe{weight: 1, nodes: 1, last: time.Now(), counts: make([]float64, len(e.counts)), e: e}
+		e.walked++
+		walk(p)
+		p.clock()
+		e.probes++
+		e.nodes.add(p.nodes)
+		e.time.add(p.time)
+		for len(e.counts) < len(p.counts) {
+			// A counter first seen in this probe was 0 in the earlier ones
+			e.counts = append(e.counts, stat{})
+		}
+		for k, c := range p.counts {
+			e.counts[k].add(c)
+		}
+	}
+}
+
+// clock charges the time since the walk reached the current node to it.
+func (p *Probe) clock() {
+	now := time.Now()
+	p.time += p.weight * now.Sub(p.last).Seconds()
+	p.last = now
+}
+
+// Choose descends to one of the current node's m >= 1 children, chosen
+// uniformly, and returns its index. A node without children ends the walk
+// without a call.
+func (p *Probe) Choose(m int) int {
+	p.clock()
+	p.weight *= float64(m)
+	p.nodes += p.weight
+	p.e.walked++
+	if m == 1 {
+		return 0
+	}
+	return p.e.rng.Intn(m)
+}
+
+// Add counts the current node under counter k.
+func (p *Probe) Add(k int) {
+	for len(p.counts) <= k {
+		p.counts = append(p.counts, 0)
+	}
+	p.counts[k] += p.weight
+}
+
+// Weight returns the number of nodes the current one stands for.
+func (p *Probe) Weight() float64 {
+	return p.weight
+}
+
+// Probes returns the number of probes made.
+func (e *Estimator) Probes() int {
+	return e.probes
+}
+
+// Nodes estimates the nodes of the tree.
+func (e *Estimator) Nodes() Value {
+	return e.value(e.nodes)
+}
+
+// Count estimates the nodes counted under counter k.
+func (e *Estimator) Count(k int) Value {
+	if k >= len(e.counts) {
+		return Value{}
+	}
+	return e.value(e.counts[k])
+}
+
+func (e *Estimator) value(s stat) Value {
+	if e.probes == 0 {
+		return Value{}
+	}
+	n := float64(e.probes)
+	mean := s.sum / n
+	v := Value{Mean: mean}
+	if e.probes > 1 {
+		variance := (s.sumSq - n*mean*mean) / (n - 1)
+		v.StdErr = math.Sqrt(max(variance, 0) / n)
+	}
+	return v
+}
+
+// Time estimates the seconds the search takes on one core.
+func (e *Estimator) Time() Value {
+	return e.value(e.time)
+}
+
+// Walked returns the number of nodes the probes visited.
+func (e *Estimator) Walked() int64 {
+	return e.walked
+}
+
+// Value is an estimate with its standard error.
+type Value struct {
+	Mean, StdErr float64
+}
+
+// String formats the value as e.g. "1.23e+06 ± 4%".
+func (v Value) String() string {
+	if v.Mean == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.3g ± %.0f%%", v.Mean, 100*v.StdErr/v.Mean)
+}
+
+// Duration formats a value in seconds as a rounded duration, e.g.
+// "26h14m0s ± 12%".
+func (v Value) Duration() string {
+	d := time.Duration(v.Mean * float64(time.Second))
+	switch {
+	case d > time.Hour:
+		d = d.Round(time.Minute)
+	case d > time.Minute:
+		d = d.Round(time.Second)
+	default:
+		d = d.Round(time.Millisecond)
+	}
+	if v.Mean == 0 {
+		return d.String()
+	}
+	return fmt.Sprintf("%v ± %.0f%%", d, 100*v.StdErr/v.Mean)
+}
@@ -0,0 +1,213 @@
+// Package externaltools runs the external programs the pipeline shells out
+// to (nauty's shortg and labelg, bliss) so that a failure can't pass for an
+// empty result: a missing binary is reported up front with how to install
+// it, stderr is captured and quoted in the error, and failures that are
+// likely to go away on their own (the process couldn't be started for lack
+// of resources, or was killed by SIGKILL, usually the OOM killer under a
+// passing memory peak) are retried.
+//
+// Output retries, since its inputs are files named in the arguments.
+// Command is for streaming through stdin and stdout, which can't be
+// replayed, so it runs once; callers that can reopen their inputs wrap it
+// in Retry.
+package externaltools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Attempts is how often a transient failure is tried in all; the wait
+// before attempt i+1 is i*RetryDelay.
+var (
+	Attempts   = 3
+	RetryDelay = time.Second
+)
+
+// install says where each known tool comes from, for the not-found error.
+var install = map[string]string{
+	"shortg": "nauty (brew install nauty, apt install nauty)",
+	"labelg": "nauty (brew install nauty, apt install nauty)",
+	"bliss":  "bliss (brew install bliss, apt install bliss)",
+}
+
+// Require returns the path of tool, or an error saying it is missing and
+// how to install it.
+func Require(tool string) (string, error) {
+	path, err := exec.LookPath(tool)
+	if err == nil {
+		return path, nil
+	}
+	if from, ok := install[tool]; ok {
+		return "", fmt.Errorf("%s not found on PATH; it comes with %s", tool, from)
+	}
+	return "", fmt.Errorf("%s not found on PATH", tool)
+}
+
+// Available reports whether tool is on PATH.
+func Available(tool string) bool {
+	_, err := exec.LookPath(tool)
+	return err == nil
+}
+
+// Error is a failed run of a tool.
+type Error struct {
+	Tool     string
+	Args     []string
+	Err      error
+	Stderr   string // the end of what the tool wrote to stderr
+	Attempts int
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s", strings.Join(append([]string{e.Tool}, e.Args...), " "))
+	if e.Attempts > 1 {
+		fmt.Fprintf(&b, " (%d attempts)", e.Attempts)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	if e.Stderr != "" {
+		fmt.Fprintf(&b, ": %s", e.Stderr)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether err is a failure worth retrying: the process
+// couldn't be started for lack of resources or because the binary was
+// being replaced, or it was killed by SIGKILL.
+func Transient(err error) bool {
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOMEM) || errors.Is(err, syscall.ETXTBSY) {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return status.Signal() == syscall.SIGKILL
+		}
+	}
+	return false
+}
+
+// Retry calls attempt until it succeeds, fails for good, or Attempts are
+// used up. The error of the last attempt is returned, as an *Error with the
+// attempt count if it is one.
+func Retry(attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		var e *Error
+		if errors.As(err, &e) {
+			e.Attempts = i
+		}
+		if !Transient(err) || i >= Attempts {
+			return err
+		}
+		time.Sleep(time.Duration(i) * RetryDelay)
+	}
+}
+
+// Output runs tool with args and returns its stdout, retrying transient
+// failures.
+func Output(tool string, args ...string) ([]byte, error) {
+	var out []byte
+	err := Retry(func() error {
+		cmd, err := Command(tool, args...)
+		if err != nil {
+			return err
+		}
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		out = stdout.Bytes()
+		return nil
+	})
+	return out, err
+}
+
+// Cmd is an exec.Cmd whose stderr is captured for its errors. Set Stdin and
+// Stdout (or take the pipes) as usual; don't set Stderr.
+type Cmd struct {
+	*exec.Cmd
+	tool   string
+	args   []string
+	stderr tail
+}
+
+// Command prepares tool with args, failing if it isn't on PATH.
+func Command(tool string, args ...string) (*Cmd, error) {
+	path, err := Require(tool)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cmd{Cmd: exec.Command(path, args...), tool: tool, args: args}
+	c.Cmd.Stderr = &c.stderr
+	return c, nil
+}
+
+// Start starts the command.
+func (c *Cmd) Start() error {
+	if err := c.Cmd.Start(); err != nil {
+		return c.wrap(err)
+	}
+	return nil
+}
+
+// Wait waits for the command to exit; a non-zero exit is an *Error quoting
+// the tool's stderr.
+func (c *Cmd) Wait() error {
+	if err := c.Cmd.Wait(); err != nil {
+		return c.wrap(err)
+	}
+	return nil
+}
+
+// Run starts the command and waits for it.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *Cmd) wrap(err error) error {
+	return &Error{Tool: c.tool, Args: c.args, Err: err, Stderr: c.stderr.String(), Attempts: 1}
+}
+
+// tailSize is how much of a tool's stderr is kept.
+const tailSize = 4096
+
+// tail keeps the last tailSize bytes written to it.
+type tail struct {
+	buf []byte
+	cut bool
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - tailSize; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.cut = true
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	s := strings.TrimSpace(string(t.buf))
+	if t.cut && s != "" {
+		return "..." + s
+	}
+	return s
+}
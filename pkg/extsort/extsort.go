@@ -0,0 +1,233 @@
+// Package extsort sorts and deduplicates more uint64 values (graph
+// bitmasks) than fit in memory: values are buffered up to a limit, then
+// the buffer is sorted, deduplicated and written to a run file, and the
+// runs are merged at the end. Memory stays at the limit plus one read
+// buffer per open run; at most fanIn runs are open at once, more are first
+// merged into longer runs.
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+)
+
+// fanIn is the number of runs merged at once, bounding open files.
+const fanIn = 64
+
+// Sorter collects values; create it with New, feed it with Add, read the
+// result with Each and remove the run files with Close.
+type Sorter struct {
+	dir   string
+	limit int
+	buf   []uint64
+	runs  []string
+
+	// Spilled counts the values written to run files, duplicates within a
+	// run already removed.
+	Spilled int64
+}
+
+// New returns a Sorter keeping at most limit values in memory before it
+// spills to run files in dir ("" is the system temp directory). A limit of
+// 0 never spills.
+func New(dir string, limit int) *Sorter {
+	return &Sorter{dir: dir, limit: limit}
+}
+
+// LimitForMB converts a memory budget in MB to a value limit.
+func LimitForMB(mb int) int {
+	return mb << 20 / 8
+}
+
+// Add adds v.
+func (s *Sorter) Add(v uint64) error {
+	s.buf = append(s.buf, v)
+	if s.limit > 0 && len(s.buf) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// Runs returns the number of run files written so far.
+func (s *Sorter) Runs() int {
+	return len(s.runs)
+}
+
+func sortUnique(buf []uint64) []uint64 {
+	slices.Sort(buf)
+	return slices.Compact(buf)
+}
+
+func (s *Sorter) spill() error {
+	vals := sortUnique(s.buf)
+	s.buf = s.buf[:0]
+	err := s.writeRun(func(emit func(uint64) error) error {
+		for _, v := range vals {
+			if err := emit(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.Spilled += int64(len(vals))
+	if len(s.runs) >= fanIn {
+		return s.compact()
+	}
+	return nil
+}
+
+// writeRun creates a run file and fills it with the values fill emits.
+func (s *Sorter) writeRun(fill func(emit func(uint64) error) error) error {
+	f, err := os.CreateTemp(s.dir, "extsort-run-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriterSize(f, 1<<20)
+	var b [8]byte
+	err = fill(func(v uint64) error {
+		binary.LittleEndian.PutUint64(b[:], v)
+		_, err := w.Write(b[:])
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// compact merges all runs into one.
+func (s *Sorter) compact() error {
+	old := s.runs
+	s.runs = nil
+	err := s.writeRun(func(emit func(uint64) error) error {
+		return merge(old, emit)
+	})
+	for _, path := range old {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Each calls f on every distinct value added, in increasing order,
+// stopping at the first error.
+func (s *Sorter) Each(f func(v uint64) error) error {
+	if len(s.runs) == 0 {
+		for _, v := range sortUnique(s.buf) {
+			if err := f(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.buf) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	return merge(s.runs, f)
+}
+
+// merge calls f on the distinct values of the run files in increasing
+// order.
+func merge(paths []string, f func(v uint64) error) error {
+	h := make(mergeHeap, 0, len(paths))
+	defer func() {
+		for _, r := range h {
+			r.f.Close()
+		}
+	}()
+	for _, path := range paths {
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		r := &run{f: fh, r: bufio.NewReaderSize(fh, 1<<16)}
+		if ok, err := r.next(); err != nil {
+			fh.Close()
+			return err
+		} else if ok {
+			h = append(h, r)
+		} else {
+			fh.Close()
+		}
+	}
+	heap.Init(&h)
+	first, last := true, uint64(0)
+	for len(h) > 0 {
+		r := h[0]
+		if first || r.v != last {
+			if err := f(r.v); err != nil {
+				return err
+			}
+			first, last = false, r.v
+		}
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			r.f.Close()
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// Close removes the run files.
+func (s *Sorter) Close() error {
+	var err error
+	for _, path := range s.runs {
+		if rerr := os.Remove(path); err == nil {
+			err = rerr
+		}
+	}
+	s.runs = nil
+	return err
+}
+
+type run struct {
+	f *os.File
+	r *bufio.Reader
+	v uint64
+	b [8]byte
+}
+
+// next reads the run's next value into v and reports whether there was one.
+func (r *run) next() (bool, error) {
+	if _, err := io.ReadFull(r.r, r.b[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	r.v = binary.LittleEndian.Uint64(r.b[:])
+	return true, nil
+}
+
+type mergeHeap []*run
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].v < h[j].v }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(*run)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
@@ -0,0 +1,252 @@
+// Package fabexport writes coin layouts as files for fabrication: DXF
+// outlines for laser cutting (the pieces on layer CUT, their numbers and
+// captions as text on layer LABELS, in millimeters) and binary STL solids
+// for 3D printing (each piece a disc or hexagonal prism with its number
+// raised on top in seven-segment digits).
+//
+// Pieces are discs or hexagons of the same size across, centered where
+// the coins of a hexagonal packing sit: neighboring coins touch, and
+// hexagons, which are the cells of the packing, meet flat side to flat
+// side. Clearance shrinks every piece so neighbors don't share a cut.
+package fabexport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Shapes
+const (
+	Disc = "disc"
+	Hex  = "hex"
+)
+
+// Piece is one coin: its center in millimeters and its number.
+type Piece struct {
+	X, Y  float64
+	Label string
+}
+
+// Note is a caption, e.g. an arrangement's name, centered at X, Y.
+type Note struct {
+	X, Y float64
+	Text string
+}
+
+// Options describes the pieces. Size is the distance between neighboring
+// centers in millimeters (the coin diameter, or the hexagon's width across
+// flats).
+type Options struct {
+	Shape     string
+	Size      float64
+	Clearance float64 // subtracted from Size for the outline
+	Height    float64 // STL: thickness of the pieces
+	Relief    float64 // STL: height of the raised numbers
+	Segments  int     // sides of the polygon approximating a disc in STL
+}
+
+// outline returns the corners of p's outline, counterclockwise. Discs
+// have o.Segments corners (DXF draws them as circles instead).
+func (o Options) outline(p Piece) [][2]float64 {
+	r := (o.Size - o.Clearance) / 2
+	sides, start := o.Segments, 0.0
+	if o.Shape == Hex {
+		// flats face the six neighbors, which lie at multiples of 60°
+		sides, start, r = 6, math.Pi/6, r/math.Cos(math.Pi/6)
+	}
+	pts := make([][2]float64, sides)
+	for i := range pts {
+		a := start + 2*math.Pi*float64(i)/float64(sides)
+		pts[i] = [2]float64{p.X + r*math.Cos(a), p.Y + r*math.Sin(a)}
+	}
+	return pts
+}
+
+// Check reports options that can't make pieces.
+func (o Options) Check() error {
+	if o.Shape != Disc && o.Shape != Hex {
+		return fmt.Errorf("shape %q: want %s or %s", o.Shape, Disc, Hex)
+	}
+	if o.Size <= 0 || o.Clearance < 0 || o.Clearance >= o.Size {
+		return fmt.Errorf("size %g, clearance %g: need 0 <= clearance < size", o.Size, o.Clearance)
+	}
+	if o.Height < 0 || o.Relief < 0 {
+		return fmt.Errorf("height %g, relief %g: need >= 0", o.Height, o.Relief)
+	}
+	if o.Segments < 6 {
+		return fmt.Errorf("%d segments: need at least 6", o.Segments)
+	}
+	return nil
+}
+
+// textHeight is the height of a piece's number: small enough that two
+// digits fit inside the outline.
+func (o Options) textHeight() float64 {
+	return 0.4 * (o.Size - o.Clearance)
+}
+
+// DXF writes the pieces and notes as an R12 ASCII DXF drawing.
+func DXF(w io.Writer, pieces []Piece, notes []Note, o Options) error {
+	bw := bufio.NewWriter(w)
+	group := func(code int, value any) {
+		fmt.Fprintf(bw, "%d\n%v\n", code, value)
+	}
+	num := func(f float64) string {
+		return fmt.Sprintf("%.4f", f)
+	}
+	text := func(x, y, height float64, s string) {
+		group(0, "TEXT")
+		group(8, "LABELS")
+		group(10, num(x))
+		group(20, num(y))
+		group(40, num(height))
+		group(1, s)
+		group(72, 1) // centered
+		group(73, 2) // middle
+		group(11, num(x))
+		group(21, num(y))
+	}
+
+	group(0, "SECTION")
+	group(2, "HEADER")
+	group(9, "$INSUNITS")
+	group(70, 4) // millimeters
+	group(0, "ENDSEC")
+	group(0, "SECTION")
+	group(2, "ENTITIES")
+	for _, p := range pieces {
+		if o.Shape == Disc {
+			group(0, "CIRCLE")
+			group(8, "CUT")
+			group(10, num(p.X))
+			group(20, num(p.Y))
+			group(40, num((o.Size-o.Clearance)/2))
+		} else {
+			group(0, "POLYLINE")
+			group(8, "CUT")
+			group(66, 1)
+			group(10, num(0))
+			group(20, num(0))
+			group(30, num(0))
+			group(70, 1) // closed
+			for _, c := range o.outline(p) {
+				group(0, "VERTEX")
+				group(8, "CUT")
+				group(10, num(c[0]))
+				group(20, num(c[1]))
+			}
+			group(0, "SEQEND")
+			group(8, "CUT")
+		}
+		text(p.X, p.Y, o.textHeight(), p.Label)
+	}
+	for _, n := range notes {
+		text(n.X, n.Y, o.textHeight(), n.Text)
+	}
+	group(0, "ENDSEC")
+	group(0, "EOF")
+	return bw.Flush()
+}
+
+// STL writes the pieces as a binary STL solid: a prism of o.Height per
+// piece with its number raised o.Relief above the top face. Characters
+// other than the digits 0-9 in a label are left out.
+func STL(w io.Writer, pieces []Piece, o Options) error {
+	var tris [][3][3]float64
+	for _, p := range pieces {
+		tris = appendPrism(tris, o.outline(p), 0, o.Height)
+		if o.Relief == 0 {
+			continue
+		}
+		h := o.textHeight()
+		dw, gap := h/2, h/5
+		x := p.X - (float64(len(p.Label))*(dw+gap)-gap)/2
+		for _, ch := range p.Label {
+			if ch >= '0' && ch <= '9' {
+				for _, r := range digitBars(int(ch-'0'), x, p.Y-h/2, dw, h) {
+					box := [][2]float64{{r[0], r[1]}, {r[2], r[1]}, {r[2], r[3]}, {r[0], r[3]}}
+					tris = appendPrism(tris, box, o.Height, o.Height+o.Relief)
+				}
+			}
+			x += dw + gap
+		}
+	}
+
+	header := make([]byte, 80)
+	copy(header, "hexagon_clink fabexport")
+	buf := make([]byte, 0, 84+50*len(tris))
+	buf = append(buf, header...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(tris)))
+	for _, t := range tris {
+		n := normal(t)
+		for _, v := range append([][3]float64{n}, t[:]...) {
+			for _, c := range v {
+				buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(c)))
+			}
+		}
+		buf = append(buf, 0, 0) // attribute byte count
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendPrism adds the triangles of the prism over the convex polygon poly
+// (counterclockwise) from z0 to z1, facing outward.
+func appendPrism(tris [][3][3]float64, poly [][2]float64, z0, z1 float64) [][3][3]float64 {
+	at := func(i int, z float64) [3]float64 {
+		c := poly[i%len(poly)]
+		return [3]float64{c[0], c[1], z}
+	}
+	for i := 1; i+1 < len(poly); i++ {
+		tris = append(tris,
+			[3][3]float64{at(0, z1), at(i, z1), at(i+1, z1)}, // top, counterclockwise from above
+			[3][3]float64{at(0, z0), at(i+1, z0), at(i, z0)}) // bottom, clockwise from above
+	}
+	for i := range poly {
+		tris = append(tris,
+			[3][3]float64{at(i, z0), at(i+1, z0), at(i+1, z1)},
+			[3][3]float64{at(i, z0), at(i+1, z1), at(i, z1)})
+	}
+	return tris
+}
+
+func normal(t [3][3]float64) [3]float64 {
+	u := [3]float64{t[1][0] - t[0][0], t[1][1] - t[0][1], t[1][2] - t[0][2]}
+	v := [3]float64{t[2][0] - t[0][0], t[2][1] - t[0][1], t[2][2] - t[0][2]}
+	n := [3]float64{u[1]*v[2] - u[2]*v[1], u[2]*v[0] - u[0]*v[2], u[0]*v[1] - u[1]*v[0]}
+	l := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+	if l == 0 {
+		return n
+	}
+	return [3]float64{n[0] / l, n[1] / l, n[2] / l}
+}
+
+// segments lists the seven-segment bars lit for each digit, as bits
+// a (top), b (upper right), c (lower right), d (bottom), e (lower left),
+// f (upper left), g (middle).
+var segments = [10]uint8{0x3f, 0x06, 0x5b, 0x4f, 0x66, 0x6d, 0x7d, 0x07, 0x7f, 0x6f}
+
+// digitBars returns the bars of digit as rectangles {x0, y0, x1, y1} in the
+// cell of width w and height h whose lower left corner is (x, y).
+func digitBars(digit int, x, y, w, h float64) [][4]float64 {
+	t := h / 7
+	bars := [7][4]float64{
+		{0, h - t, w, h},             // a
+		{w - t, h / 2, w, h},         // b
+		{w - t, 0, w, h / 2},         // c
+		{0, 0, w, t},                 // d
+		{0, 0, t, h / 2},             // e
+		{0, h / 2, t, h},             // f
+		{0, h/2 - t/2, w, h/2 + t/2}, // g
+	}
+	var out [][4]float64
+	for i, b := range bars {
+		if segments[digit]&(1<<i) != 0 {
+			out = append(out, [4]float64{x + b[0], y + b[1], x + b[2], y + b[3]})
+		}
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+// Package graph6 is the graph6 codec shared by the tools, after the format
+// description that comes with nauty (formats.txt):
+//
+//   - A line may start with the optional header ">>graph6<<", which nauty's
+//     tools write with -h.
+//   - The vertex count n is one byte n+63 for n <= 62; for n <= 258047 it is
+//     '~' and 18 bits in three bytes; up to 2^36-1 it is "~~" and 36 bits in
+//     six bytes. Each byte holds 6 bits plus 63, most significant first.
+//   - Then come the bits of the upper triangle of the adjacency matrix
+//     column by column, (0,1), (0,2), (1,2), (0,3), ..., padded with zeros
+//     to a multiple of 6.
+package graph6
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Header is the optional prefix of a graph6 line.
+const Header = ">>graph6<<"
+
+// MaxN is the largest vertex count graph6 can encode.
+const MaxN = 1<<36 - 1
+
+// Decode parses one graph6 line (surrounding whitespace and the header are
+// allowed) and returns the vertex count and the edges (i, j), i < j, in
+// graph6 bit order.
+func Decode(line string) (int, [][2]int, error) {
+	n, data, err := decodeN(line)
+	if err != nil {
+		return 0, nil, err
+	}
+	var edges [][2]int
+	err = eachBit(line, n, data, func(i, j int) {
+		edges = append(edges, [2]int{i, j})
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, edges, nil
+}
+
+// Size returns the vertex count of a graph6 line without decoding its
+// edges.
+func Size(line string) (int, error) {
+	n, _, err := decodeN(line)
+	return n, err
+}
+
+// Each calls edge for every edge (i, j), i < j, of the graph6 line in
+// graph6 bit order, after checking the whole line, and returns the vertex
+// count. It avoids building an edge list for callers with their own graph
+// type.
+func Each(line string, edge func(i, j int)) (int, error) {
+	n, data, err := decodeN(line)
+	if err != nil {
+		return 0, err
+	}
+	if err := eachBit(line, n, data, func(int, int) {}); err != nil {
+		return 0, err
+	}
+	eachBit(line, n, data, edge)
+	return n, nil
+}
+
+// decodeN strips whitespace and the header and splits the line into n and
+// the edge data.
+func decodeN(line string) (int, string, error) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), Header)
+	if line == "" {
+		return 0, "", fmt.Errorf("empty graph6 line")
+	}
+	// the count is in line[start:end]
+	start, end := 0, 1
+	switch {
+	case strings.HasPrefix(line, "~~"):
+		start, end = 2, 8
+	case line[0] == '~':
+		start, end = 1, 4
+	}
+	if len(line) < end {
+		return 0, "", fmt.Errorf("graph6 %q: truncated vertex count", abbrev(line))
+	}
+	n := 0
+	for k := start; k < end; k++ {
+		c := int(line[k]) - 63
+		if c < 0 || c > 63 {
+			return 0, "", fmt.Errorf("graph6 %q: invalid byte %q in the vertex count", abbrev(line), line[k])
+		}
+		n = n<<6 | c
+	}
+	data := line[end:]
+	if n > 1<<31 { // n(n-1)/2 bits would overflow; no line is that long anyway
+		return 0, "", fmt.Errorf("graph6 %q: n=%d too large to decode", abbrev(line), n)
+	}
+	if want := (n*(n-1)/2 + 5) / 6; len(data) != want {
+		return 0, "", fmt.Errorf("graph6 %q: %d data bytes, want %d for n=%d", abbrev(line), len(data), want, n)
+	}
+	return n, data, nil
+}
+
+// eachBit calls edge for every set bit of data, checking each byte.
+func eachBit(line string, n int, data string, edge func(i, j int)) error {
+	k := 0
+	for j := 1; j < n; j++ {
+		for i := 0; i < j; i++ {
+			c := int(data[k/6]) - 63
+			if c < 0 || c > 63 {
+				return fmt.Errorf("graph6 %q: invalid byte %q", abbrev(line), data[k/6])
+			}
+			if c&(1<<(5-k%6)) != 0 {
+				edge(i, j)
+			}
+			k++
+		}
+	}
+	return nil
+}
+
+// abbrev shortens long lines in error messages.
+func abbrev(line string) string {
+	if len(line) > 40 {
+		return line[:37] + "..."
+	}
+	return line
+}
+
+// Encode returns the graph6 line, without header or newline, of the graph
+// on n vertices whose edges are (i, j) with hasEdge(i, j) for i < j.
+func Encode(n int, hasEdge func(i, j int) bool) string {
+	out := AppendN(nil, n)
+	var cur, k int
+	for j := 1; j < n; j++ {
+		for i := 0; i < j; i++ {
+			cur <<= 1
+			if hasEdge(i, j) {
+				cur |= 1
+			}
+			k++
+			if k%6 == 0 {
+				out = append(out, byte(cur+63))
+				cur = 0
+			}
+		}
+	}
+	if k%6 != 0 {
+		out = append(out, byte(cur<<(6-k%6)+63))
+	}
+	return string(out)
+}
+
+// EncodeEdges is Encode for an edge list; each edge may be given in either
+// order.
+func EncodeEdges(n int, edges [][2]int) string {
+	set := make(map[[2]int]bool, len(edges))
+	for _, e := range edges {
+		i, j := e[0], e[1]
+		if i > j {
+			i, j = j, i
+		}
+		set[[2]int{i, j}] = true
+	}
+	return Encode(n, func(i, j int) bool { return set[[2]int{i, j}] })
+}
+
+// AppendN appends the graph6 encoding of the vertex count n.
+func AppendN(out []byte, n int) []byte {
+	if n < 0 || n > MaxN {
+		panic(fmt.Sprintf("graph6: n=%d out of range 0..%d", n, MaxN))
+	}
+	switch {
+	case n <= 62:
+		return append(out, byte(n+63))
+	case n <= 258047:
+		return append(out, '~', byte(n>>12+63), byte(n>>6&63+63), byte(n&63+63))
+	}
+	out = append(out, '~', '~')
+	for shift := 30; shift >= 0; shift -= 6 {
+		out = append(out, byte(n>>shift&63+63))
+	}
+	return out
+}
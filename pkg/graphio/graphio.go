@@ -0,0 +1,304 @@
+// Package graphio reads and writes the binary graph files passed between the
+// penny_enum pipeline stages.
+//
+// A file starts with a fixed 24-byte header:
+//
+//	magic   [4]byte  "HXCG"
+//	version uint8    2
+//	kind    uint8    1 = raw, 2 = grouped
+//	width   uint8    bytes per graph (4 or 8)
+//	n       uint8    number of vertices
+//	count   uint64   number of graphs
+//	groups  uint64   number of groups (0 for raw files)
+//
+// followed by a uint32 length and that many bytes of metadata, one
+// "key=value" line each: "stage" names the tool that wrote the file, the
+// other keys are its creation parameters. Then come the graphs as
+// little-endian edge bitmasks; in grouped files every group is prefixed with
+// its uint32 size.
+//
+// Version 1 files have no n (byte 7 is 0) and no metadata. Files written
+// before the header existed (raw graphs, or a uint32 group count followed by
+// groups) are still accepted when the caller says which kind it expects and
+// supplies n.
+//
+// Paths ending in .gz or .zst are compressed and decompressed transparently
+// (see package zfile). A compressed file can't be rewritten in place, so its
+// header stores Streamed for the counts and readers count the graphs instead.
+package graphio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"hexagon_clink/pkg/zfile"
+)
+
+// Magic identifies a graph file with a header.
+const Magic = "HXCG"
+
+// Version is the header version written by this package.
+const Version = 2
+
+// HeaderSize is the size of the fixed part of the header in bytes.
+const HeaderSize = 24
+
+// MaxN is the largest vertex count whose edge bitmask fits in a uint64.
+const MaxN = 11
+
+// Streamed is stored in place of the graph and group counts of compressed
+// files, whose writer can't seek back to fill them in. Readers count to EOF.
+const Streamed = ^uint64(0)
+
+// maxMetaSize bounds the metadata block so a corrupt length can't make a
+// reader allocate gigabytes.
+const maxMetaSize = 1 << 20
+
+// Kind says how the graphs in a file are laid out.
+type Kind uint8
+
+const (
+	Raw     Kind = 1
+	Grouped Kind = 2
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Raw:
+		return "raw"
+	case Grouped:
+		return "grouped"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// ParseKind parses "raw" or "grouped".
+func ParseKind(s string) (Kind, error) {
+	switch s {
+	case "raw":
+		return Raw, nil
+	case "grouped":
+		return Grouped, nil
+	}
+	return 0, fmt.Errorf("unknown file kind %q (want raw or grouped)", s)
+}
+
+// Header describes a graph file.
+type Header struct {
+	Version uint8
+	Kind    Kind
+	N       int               // number of vertices, 0 if unknown
+	Width   int               // bytes per graph
+	Count   uint64            // number of graphs
+	Groups  uint64            // number of groups, 0 for raw files
+	Stage   string            // tool that wrote the file
+	Params  map[string]string // creation parameters of that tool
+	Legacy  bool              // file has no header
+}
+
+// Width returns the bytes per graph for graphs on n vertices.
+func Width(n int) int {
+	if n*(n-1)/2 <= 32 {
+		return 4
+	}
+	return 8
+}
+
+// ParamKeys returns the parameter names in sorted order.
+func (h Header) ParamKeys() []string {
+	keys := make([]string, 0, len(h.Params))
+	for k := range h.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check reports whether a file with header h can be read as kind on n
+// vertices. A zero kind or n accepts anything.
+func (h Header) Check(kind Kind, n int) error {
+	if kind != 0 && h.Kind != kind {
+		return fmt.Errorf("expected a %s file, found %s", kind, h.Kind)
+	}
+	if n == 0 {
+		return nil
+	}
+	if h.N != 0 && h.N != n {
+		return fmt.Errorf("file has graphs on n=%d vertices, not n=%d", h.N, n)
+	}
+	if h.Width != Width(n) {
+		return fmt.Errorf("expected %d-byte graphs for n=%d, file has %d-byte graphs", Width(n), n, h.Width)
+	}
+	return nil
+}
+
+func (h Header) meta() []byte {
+	var b strings.Builder
+	if h.Stage != "" {
+		fmt.Fprintf(&b, "stage=%s\n", h.Stage)
+	}
+	for _, k := range h.ParamKeys() {
+		fmt.Fprintf(&b, "%s=%s\n", k, h.Params[k])
+	}
+	return []byte(b.String())
+}
+
+func (h Header) validateMeta() error {
+	if strings.Contains(h.Stage, "\n") {
+		return fmt.Errorf("stage %q contains a newline", h.Stage)
+	}
+	for k, v := range h.Params {
+		if k == "" || k == "stage" || strings.ContainsAny(k, "=\n") {
+			return fmt.Errorf("invalid parameter name %q", k)
+		}
+		if strings.Contains(v, "\n") {
+			return fmt.Errorf("parameter %s=%q contains a newline", k, v)
+		}
+	}
+	return nil
+}
+
+// fixed encodes the fixed part of the header.
+func (h Header) fixed() []byte {
+	buf := make([]byte, HeaderSize)
+	copy(buf, Magic)
+	buf[4] = h.Version
+	buf[5] = uint8(h.Kind)
+	buf[6] = uint8(h.Width)
+	buf[7] = uint8(h.N)
+	binary.LittleEndian.PutUint64(buf[8:], h.Count)
+	binary.LittleEndian.PutUint64(buf[16:], h.Groups)
+	return buf
+}
+
+func (h Header) encode() []byte {
+	meta := h.meta()
+	buf := binary.LittleEndian.AppendUint32(h.fixed(), uint32(len(meta)))
+	return append(buf, meta...)
+}
+
+func decodeFixed(buf []byte) (Header, error) {
+	h := Header{
+		Version: buf[4],
+		Kind:    Kind(buf[5]),
+		Width:   int(buf[6]),
+		N:       int(buf[7]),
+		Count:   binary.LittleEndian.Uint64(buf[8:]),
+		Groups:  binary.LittleEndian.Uint64(buf[16:]),
+	}
+	if h.Version != 1 && h.Version != Version {
+		return h, fmt.Errorf("unsupported header version %d (this build reads versions 1-%d)", h.Version, Version)
+	}
+	if h.Kind != Raw && h.Kind != Grouped {
+		return h, fmt.Errorf("unknown file kind %d in header", buf[5])
+	}
+	if h.Width != 4 && h.Width != 8 {
+		return h, fmt.Errorf("invalid graph width %d in header", h.Width)
+	}
+	if h.Version == 1 && h.N != 0 {
+		return h, fmt.Errorf("version 1 header with reserved byte %d", h.N)
+	}
+	if h.Version >= 2 && (h.N < 2 || h.N > MaxN) {
+		return h, fmt.Errorf("invalid vertex count %d in header", h.N)
+	}
+	if h.N != 0 && Width(h.N) != h.Width {
+		return h, fmt.Errorf("header says n=%d but %d bytes per graph", h.N, h.Width)
+	}
+	if h.Kind == Raw && h.Groups != 0 {
+		return h, fmt.Errorf("raw file header has %d groups", h.Groups)
+	}
+	return h, nil
+}
+
+func decodeMeta(h *Header, meta []byte) error {
+	for _, line := range strings.Split(string(meta), "\n") {
+		if line == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("malformed metadata line %q", line)
+		}
+		if k == "stage" {
+			h.Stage = v
+			continue
+		}
+		if h.Params == nil {
+			h.Params = make(map[string]string)
+		}
+		h.Params[k] = v
+	}
+	return nil
+}
+
+// readHeader reads a header, magic included, from r and returns it with
+// the number of bytes it occupies.
+func readHeader(r io.Reader) (Header, int64, error) {
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Header{}, 0, fmt.Errorf("truncated header")
+	}
+	h, err := decodeFixed(buf)
+	if err != nil {
+		return h, 0, err
+	}
+	if h.Version == 1 {
+		return h, HeaderSize, nil
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return h, 0, fmt.Errorf("truncated header")
+	}
+	metaLen := binary.LittleEndian.Uint32(buf)
+	if metaLen > maxMetaSize {
+		return h, 0, fmt.Errorf("metadata length %d is too large", metaLen)
+	}
+	meta := make([]byte, metaLen)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return h, 0, fmt.Errorf("truncated metadata")
+	}
+	if err := decodeMeta(&h, meta); err != nil {
+		return h, 0, err
+	}
+	return h, HeaderSize + 4 + int64(metaLen), nil
+}
+
+// ReadHeader returns the header of path. Files without a header are
+// reported with Legacy set and everything else zero.
+func ReadHeader(path string) (Header, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(Magic))
+	if err != nil && err != io.EOF {
+		return Header{}, err
+	}
+	if string(magic) != Magic {
+		return Header{Legacy: true}, nil
+	}
+	h, _, err := readHeader(br)
+	if err != nil {
+		return h, fmt.Errorf("%s: %v", path, err)
+	}
+	return h, nil
+}
+
+// Derive returns the header for a file produced from a file with header in:
+// the input's n and parameters are carried over, and params are added on
+// top under stage.
+func Derive(in Header, kind Kind, stage string, params map[string]string) Header {
+	h := Header{Kind: kind, N: in.N, Stage: stage, Params: make(map[string]string)}
+	for k, v := range in.Params {
+		h.Params[k] = v
+	}
+	for k, v := range params {
+		h.Params[k] = v
+	}
+	return h
+}
@@ -0,0 +1,35 @@
+package graphio
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hexagon_clink/pkg/zfile"
+)
+
+// TestReadGroupsOversizedGroup reads a compressed file whose one group
+// claims 0xFFFFFFF0 graphs but holds none, which used to allocate the whole
+// group up front and run out of memory instead of failing.
+func TestReadGroupsOversizedGroup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.bin.gz")
+	w, err := zfile.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A header-less grouped file: the group count, then the group's size
+	data := binary.LittleEndian.AppendUint32(nil, 1)
+	data = binary.LittleEndian.AppendUint32(data, 0xFFFFFFF0)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = ReadGroups(path, 9)
+	if err == nil || !strings.Contains(err.Error(), "truncated group") {
+		t.Fatalf("ReadGroups: got error %v, want a truncated group", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package graphio
+
+import (
+	"errors"
+	"os"
+)
+
+// Without mmap, Open falls back to reading through a buffer.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("mmap not supported")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package graphio
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps size bytes of f read-only.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,506 @@
+package graphio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"hexagon_clink/pkg/zfile"
+)
+
+// Reader reads a graph file written by Writer, or a legacy headerless file.
+// Uncompressed files are mapped into memory, so reading costs no system
+// calls or copies, and NextChunk and NextGroupView hand out views of the
+// mapping itself.
+type Reader struct {
+	f        io.Closer
+	r        *bufio.Reader
+	data     []byte // the whole file if mapped; reads past the header index it instead of r
+	h        Header
+	path     string
+	buf      []byte
+	offset   int64
+	size     int64  // file size, -1 if unknown
+	graphs   uint64 // graphs read so far
+	groups   uint64 // groups read so far
+	groupLen uint64 // graphs left in the current group
+	inGroup  bool
+}
+
+// Open opens path for reading as a file of the given kind on n vertices.
+// A file with a header must match kind, and must match n unless n is 0, in
+// which case n is taken from the header. Files without a header need both.
+// Names ending in .gz or .zst are decompressed on the fly.
+func Open(path string, kind Kind, n int) (*Reader, error) {
+	if !zfile.IsCompressed(path) {
+		if r, err := openMapped(path, kind, n); r != nil || err != nil {
+			return r, err
+		}
+	}
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The size of a compressed file says nothing about its contents; the
+	// reader counts to EOF instead
+	size := int64(-1)
+	if pf, ok := f.(*os.File); ok {
+		info, err := pf.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		size = info.Size()
+	}
+	r, err := newReader(f, f, nil, size, path, kind, n)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// mapping closes a mapped file.
+type mapping struct {
+	f    *os.File
+	data []byte
+}
+
+func (m *mapping) Close() error {
+	err := munmap(m.data)
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openMapped opens path by mapping it into memory. It returns nil, nil if
+// the file can't be mapped (empty, too large for an int, or no mmap on
+// this system), for Open to read it through a buffer instead.
+func openMapped(path string, kind Kind, n int) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 || int64(int(size)) != size {
+		f.Close()
+		return nil, nil
+	}
+	data, err := mmap(f, size)
+	if err != nil {
+		f.Close()
+		return nil, nil
+	}
+	m := &mapping{f, data}
+	r, err := newReader(bytes.NewReader(data), m, data, size, path, kind, n)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenAny opens a file with a header regardless of its kind and n.
+func OpenAny(path string) (*Reader, error) {
+	return Open(path, 0, 0)
+}
+
+// newReader reads the header from src, which holds size bytes (-1 if
+// unknown). With data set, src reads data and the rest of the file is read
+// from data directly.
+func newReader(src io.Reader, f io.Closer, data []byte, size int64, path string, kind Kind, n int) (*Reader, error) {
+	r := &Reader{f: f, r: bufio.NewReader(src), data: data, path: path, buf: make([]byte, 8), size: size}
+
+	magic, err := r.r.Peek(len(Magic))
+	if err == nil && string(magic) == Magic {
+		h, hsize, err := readHeader(r.r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := h.Check(kind, n); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if h.N == 0 {
+			if n == 0 {
+				return nil, fmt.Errorf("%s: version %d header does not record n; pass it explicitly", path, h.Version)
+			}
+			h.N = n
+		}
+		r.h = h
+		r.offset = hsize
+		if h.Kind == Raw && h.Count != Streamed && size >= 0 {
+			want := hsize + int64(h.Count)*int64(h.Width)
+			if size != want {
+				return nil, fmt.Errorf("%s: header says %d graphs (%d bytes), file is %d bytes", path, h.Count, want, size)
+			}
+		}
+		return r, nil
+	}
+
+	// Legacy file without a header
+	if kind == 0 || n == 0 {
+		return nil, fmt.Errorf("%s: no %q header; files without a header need an explicit kind and n", path, Magic)
+	}
+	if n < 2 || n > MaxN {
+		return nil, fmt.Errorf("invalid vertex count %d", n)
+	}
+	width := Width(n)
+	r.h = Header{Kind: kind, N: n, Width: width, Legacy: true}
+	switch kind {
+	case Raw:
+		if size < 0 {
+			r.h.Count = Streamed
+			break
+		}
+		if size%int64(width) != 0 {
+			return nil, fmt.Errorf("%s: size %d is not a multiple of %d bytes per graph (wrong n, or not a raw file?)", path, size, width)
+		}
+		r.h.Count = uint64(size / int64(width))
+	case Grouped:
+		if end, err := r.atEnd(); err != nil {
+			return nil, err
+		} else if end {
+			return r, nil
+		}
+		if _, err := io.ReadFull(r.r, r.buf[:4]); err != nil {
+			return nil, fmt.Errorf("%s: truncated group count", path)
+		}
+		r.h.Groups = uint64(binary.LittleEndian.Uint32(r.buf))
+		r.offset = 4
+	default:
+		return nil, fmt.Errorf("unknown file kind %d", kind)
+	}
+	return r, nil
+}
+
+// Header returns the file header. For legacy grouped files and compressed
+// files the counts are only known once the whole file has been read; until
+// then they are Streamed (or 0 for the graph count of legacy grouped files).
+func (r *Reader) Header() Header {
+	return r.h
+}
+
+// Mapped reports whether the file is mapped into memory, so that views
+// from NextChunk and NextGroupView point into it.
+func (r *Reader) Mapped() bool {
+	return r.data != nil
+}
+
+// Path returns the file name.
+func (r *Reader) Path() string {
+	return r.path
+}
+
+func (r *Reader) readFull(n int, what string) error {
+	if r.data != nil {
+		if int64(len(r.data))-r.offset < int64(n) {
+			return fmt.Errorf("%s: truncated %s at offset %d", r.path, what, r.offset)
+		}
+		copy(r.buf, r.data[r.offset:r.offset+int64(n)])
+		r.offset += int64(n)
+		return nil
+	}
+	_, err := io.ReadFull(r.r, r.buf[:n])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%s: truncated %s at offset %d", r.path, what, r.offset)
+	}
+	if err != nil {
+		return err
+	}
+	r.offset += int64(n)
+	return nil
+}
+
+func (r *Reader) graph() (uint64, error) {
+	if err := r.readFull(r.h.Width, "graph"); err != nil {
+		return 0, err
+	}
+	r.graphs++
+	if r.h.Width == 4 {
+		return uint64(binary.LittleEndian.Uint32(r.buf)), nil
+	}
+	return binary.LittleEndian.Uint64(r.buf), nil
+}
+
+// atEnd reports whether the underlying file has no more bytes.
+func (r *Reader) atEnd() (bool, error) {
+	if r.data != nil {
+		return r.offset == int64(len(r.data)), nil
+	}
+	_, err := r.r.Peek(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+func (r *Reader) finish() error {
+	end, err := r.atEnd()
+	if err != nil {
+		return err
+	}
+	if !end {
+		return fmt.Errorf("%s: trailing data at offset %d", r.path, r.offset)
+	}
+	if r.h.Count == Streamed || (r.h.Legacy && r.h.Kind == Grouped) {
+		r.h.Count = r.graphs
+	}
+	if r.h.Groups == Streamed {
+		r.h.Groups = r.groups
+	}
+	if r.graphs != r.h.Count {
+		return fmt.Errorf("%s: header says %d graphs, found %d", r.path, r.h.Count, r.graphs)
+	}
+	return io.EOF
+}
+
+// Next returns the next graph. In grouped files it walks through all groups
+// in order. It returns io.EOF after the last graph.
+func (r *Reader) Next() (uint64, error) {
+	if r.h.Kind == Raw {
+		if r.h.Count == Streamed {
+			if end, err := r.atEnd(); err != nil {
+				return 0, err
+			} else if end {
+				return 0, r.finish()
+			}
+		} else if r.graphs == r.h.Count {
+			return 0, r.finish()
+		}
+		return r.graph()
+	}
+	for !r.inGroup || r.groupLen == 0 {
+		size, err := r.nextGroupSize()
+		if err != nil {
+			return 0, err
+		}
+		r.groupLen = size
+	}
+	r.groupLen--
+	return r.graph()
+}
+
+func (r *Reader) nextGroupSize() (uint64, error) {
+	if r.inGroup && r.groupLen != 0 {
+		return 0, fmt.Errorf("%s: %d graphs left unread in group %d", r.path, r.groupLen, r.groups)
+	}
+	r.inGroup = false
+	if r.h.Groups == Streamed {
+		if end, err := r.atEnd(); err != nil {
+			return 0, err
+		} else if end {
+			return 0, r.finish()
+		}
+	} else if r.groups == r.h.Groups {
+		return 0, r.finish()
+	}
+	if err := r.readFull(4, "group size"); err != nil {
+		return 0, err
+	}
+	r.groups++
+	r.inGroup = true
+	return uint64(binary.LittleEndian.Uint32(r.buf)), nil
+}
+
+// NextGroup returns the next group of a grouped file, or io.EOF after the
+// last one.
+func (r *Reader) NextGroup() ([]uint64, error) {
+	if r.h.Kind != Grouped {
+		return nil, fmt.Errorf("%s: NextGroup on a %s file", r.path, r.h.Kind)
+	}
+	view, err := r.NextGroupView()
+	if err != nil {
+		return nil, err
+	}
+	return view.AppendTo(make([]uint64, 0, view.Len())), nil
+}
+
+// NextGroupView is NextGroup returning a view: of the mapping for a mapped
+// file, without copying, else of a new buffer.
+func (r *Reader) NextGroupView() (Graphs, error) {
+	if r.h.Kind != Grouped {
+		return Graphs{}, fmt.Errorf("%s: NextGroupView on a %s file", r.path, r.h.Kind)
+	}
+	size, err := r.nextGroupSize()
+	if err != nil {
+		return Graphs{}, err
+	}
+	view, err := r.view(size, "group")
+	if err != nil {
+		return Graphs{}, err
+	}
+	r.groupLen = 0
+	return view, nil
+}
+
+// NextChunk returns the next graphs of a raw file, at most limit of them, as
+// a view: of the mapping for a mapped file, without copying, else of a new
+// buffer. It returns io.EOF after the last graph.
+func (r *Reader) NextChunk(limit int) (Graphs, error) {
+	if r.h.Kind != Raw {
+		return Graphs{}, fmt.Errorf("%s: NextChunk on a %s file", r.path, r.h.Kind)
+	}
+	count := uint64(limit)
+	if r.h.Count != Streamed {
+		if r.graphs == r.h.Count {
+			return Graphs{}, r.finish()
+		}
+		count = min(count, r.h.Count-r.graphs)
+	} else {
+		if end, err := r.atEnd(); err != nil {
+			return Graphs{}, err
+		} else if end {
+			return Graphs{}, r.finish()
+		}
+		if r.data != nil {
+			count = min(count, max(1, uint64(int64(len(r.data))-r.offset)/uint64(r.h.Width)))
+		} else {
+			// Only as many as are buffered, so a short file isn't
+			// mistaken for a truncated one
+			if _, err := r.r.Peek(r.h.Width); err != nil && err != io.EOF {
+				return Graphs{}, err
+			}
+			count = min(count, max(1, uint64(r.r.Buffered()/r.h.Width)))
+		}
+	}
+	return r.view(count, "graph")
+}
+
+// Chunks returns the rest of a raw file as views of at most 1<<20 graphs,
+// in order: of the mapping for a mapped file, without copying, else of the
+// buffers NextChunk reads. The views of a mapped file are only valid until
+// the Reader is closed.
+func (r *Reader) Chunks() ([]Graphs, error) {
+	var chunks []Graphs
+	for {
+		chunk, err := r.NextChunk(1 << 20)
+		if errors.Is(err, io.EOF) {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// view reads the next count graphs.
+func (r *Reader) view(count uint64, what string) (Graphs, error) {
+	w := int64(r.h.Width)
+	if r.data != nil {
+		if uint64(int64(len(r.data))-r.offset)/uint64(w) < count {
+			return Graphs{}, fmt.Errorf("%s: truncated %s at offset %d", r.path, what, r.offset)
+		}
+		end := r.offset + int64(count)*w
+		view := Graphs{r.data[r.offset:end], r.h.Width}
+		r.offset = end
+		r.graphs += count
+		return view, nil
+	}
+	// Read maxPrealloc bytes at a time, so a group size that overstates the
+	// data (a corrupt compressed file) ends in the truncation error instead
+	// of one huge allocation
+	total := int64(count) * w
+	buf := make([]byte, 0, min(total, maxPrealloc))
+	for int64(len(buf)) < total {
+		part := int(min(total-int64(len(buf)), maxPrealloc))
+		buf = slices.Grow(buf, part)
+		if _, err := io.ReadFull(r.r, buf[len(buf):len(buf)+part]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Graphs{}, fmt.Errorf("%s: truncated %s at offset %d", r.path, what, r.offset)
+		} else if err != nil {
+			return Graphs{}, err
+		}
+		buf = buf[:len(buf)+part]
+	}
+	r.offset += int64(len(buf))
+	r.graphs += count
+	return Graphs{buf, r.h.Width}, nil
+}
+
+// Close closes the file.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// maxPrealloc bounds the room ReadAll and ReadGroups make up front when
+// the file size is unknown; past it the slices grow as they are read.
+const maxPrealloc = 1 << 20
+
+// preallocate returns how many items of at least width bytes each to make
+// room for: count, as the header says, but no more than the rest of the
+// file can hold, so a corrupt header or a file read as the wrong kind
+// can't make the caller allocate more than the file's size.
+func (r *Reader) preallocate(count uint64, width int) int {
+	if count == Streamed {
+		return 0
+	}
+	limit := uint64(maxPrealloc)
+	if r.size >= 0 {
+		limit = uint64(max(r.size-r.offset, 0)) / uint64(width)
+	}
+	return int(min(count, limit))
+}
+
+// ReadAll reads every graph in path, flattening groups, and returns them
+// with the file header.
+func ReadAll(path string, kind Kind, n int) ([]uint64, Header, error) {
+	r, err := Open(path, kind, n)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	defer r.Close()
+	graphs := make([]uint64, 0, r.preallocate(r.h.Count, r.h.Width))
+	for {
+		var err error
+		if r.h.Kind == Raw {
+			var chunk Graphs
+			if chunk, err = r.NextChunk(1 << 16); err == nil {
+				graphs = chunk.AppendTo(graphs)
+			}
+		} else {
+			var g uint64
+			if g, err = r.Next(); err == nil {
+				graphs = append(graphs, g)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return graphs, r.h, nil
+		}
+		if err != nil {
+			return nil, Header{}, err
+		}
+	}
+}
+
+// ReadGroups reads every group in a grouped file and returns them with the
+// file header.
+func ReadGroups(path string, n int) ([][]uint64, Header, error) {
+	r, err := Open(path, Grouped, n)
+	if err != nil {
+		return nil, Header{}, err
+	}
+	defer r.Close()
+	// Every group takes at least its 4-byte size
+	groups := make([][]uint64, 0, r.preallocate(r.h.Groups, 4))
+	for {
+		group, err := r.NextGroup()
+		if errors.Is(err, io.EOF) {
+			return groups, r.h, nil
+		}
+		if err != nil {
+			return nil, Header{}, err
+		}
+		groups = append(groups, group)
+	}
+}
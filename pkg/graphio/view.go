@@ -0,0 +1,41 @@
+package graphio
+
+import "encoding/binary"
+
+// Graphs is a read-only view of consecutive graphs as stored in a file,
+// little-endian bitmasks of a fixed width. Views of a mapped file point
+// into the mapping, so they are only valid until the Reader is closed.
+type Graphs struct {
+	data  []byte
+	width int
+}
+
+// Len returns the number of graphs in the view.
+func (g Graphs) Len() int {
+	if g.width == 0 {
+		return 0
+	}
+	return len(g.data) / g.width
+}
+
+// At returns graph i.
+func (g Graphs) At(i int) uint64 {
+	b := g.data[i*g.width : (i+1)*g.width]
+	if g.width == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Slice returns graphs i to j-1.
+func (g Graphs) Slice(i, j int) Graphs {
+	return Graphs{g.data[i*g.width : j*g.width], g.width}
+}
+
+// AppendTo appends the graphs to dst and returns it.
+func (g Graphs) AppendTo(dst []uint64) []uint64 {
+	for i, n := 0, g.Len(); i < n; i++ {
+		dst = append(dst, g.At(i))
+	}
+	return dst
+}
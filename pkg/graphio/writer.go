@@ -0,0 +1,136 @@
+package graphio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"hexagon_clink/pkg/zfile"
+)
+
+// Writer writes a graph file. The counts in the header are filled in by
+// Close, except in compressed files, which keep Streamed.
+type Writer struct {
+	f      io.WriteCloser
+	path   string
+	w      *bufio.Writer
+	h      Header
+	buf    []byte
+	closed bool
+}
+
+// Create creates path and writes a header for a file described by h.
+// Kind and N are required; Stage and Params are optional. The version,
+// width and counts are filled in by the Writer. Names ending in .gz or .zst
+// are compressed on the fly.
+func Create(path string, h Header) (*Writer, error) {
+	if h.Kind != Raw && h.Kind != Grouped {
+		return nil, fmt.Errorf("unknown file kind %d", h.Kind)
+	}
+	if h.N < 2 || h.N > MaxN {
+		return nil, fmt.Errorf("invalid vertex count %d", h.N)
+	}
+	if err := h.validateMeta(); err != nil {
+		return nil, err
+	}
+	f, err := zfile.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		f:    f,
+		path: path,
+		w:    bufio.NewWriter(f),
+		h: Header{
+			Version: Version,
+			Kind:    h.Kind,
+			N:       h.N,
+			Width:   Width(h.N),
+			Stage:   h.Stage,
+			Params:  h.Params,
+		},
+		buf: make([]byte, 8),
+	}
+	initial := w.h
+	if zfile.IsCompressed(path) {
+		initial.Count = Streamed
+		if initial.Kind == Grouped {
+			initial.Groups = Streamed
+		}
+	}
+	if _, err := w.w.Write(initial.encode()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) put(g uint64) error {
+	if w.h.Width == 4 {
+		if g>>32 != 0 {
+			return fmt.Errorf("%s: graph %#x does not fit in 4 bytes", w.path, g)
+		}
+		binary.LittleEndian.PutUint32(w.buf, uint32(g))
+	} else {
+		binary.LittleEndian.PutUint64(w.buf, g)
+	}
+	_, err := w.w.Write(w.buf[:w.h.Width])
+	return err
+}
+
+// Write appends one graph to a raw file.
+func (w *Writer) Write(g uint64) error {
+	if w.h.Kind != Raw {
+		return fmt.Errorf("%s: Write on a %s file", w.path, w.h.Kind)
+	}
+	if err := w.put(g); err != nil {
+		return err
+	}
+	w.h.Count++
+	return nil
+}
+
+// WriteGroup appends one group to a grouped file.
+func (w *Writer) WriteGroup(group []uint64) error {
+	if w.h.Kind != Grouped {
+		return fmt.Errorf("%s: WriteGroup on a %s file", w.path, w.h.Kind)
+	}
+	if uint64(len(group)) > 0xFFFFFFFF {
+		return fmt.Errorf("%s: group of %d graphs is too large", w.path, len(group))
+	}
+	binary.LittleEndian.PutUint32(w.buf, uint32(len(group)))
+	if _, err := w.w.Write(w.buf[:4]); err != nil {
+		return err
+	}
+	for _, g := range group {
+		if err := w.put(g); err != nil {
+			return err
+		}
+	}
+	w.h.Count += uint64(len(group))
+	w.h.Groups++
+	return nil
+}
+
+// Header returns the header as it will be written by Close.
+func (w *Writer) Header() Header {
+	return w.h
+}
+
+// Close flushes the file and patches the counts into the header.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.w.Flush()
+	if pf, ok := w.f.(*os.File); ok && err == nil {
+		_, err = pf.WriteAt(w.h.fixed(), 0)
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
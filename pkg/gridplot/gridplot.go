@@ -0,0 +1,272 @@
+// Package gridplot draws graphs with known vertex positions (see
+// pkg/coordfile) side by side in a grid, as SVG or PNG, for a quick look
+// without plotting software. All cells share one scale, so a unit edge has
+// the same length everywhere and sizes can be compared across the grid.
+package gridplot
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+
+	"hexagon_clink/pkg/coordfile"
+)
+
+// Options controls the layout.
+type Options struct {
+	Cols   int  // cells per row
+	Cell   int  // cell width and height in pixels
+	Coins  bool // draw each vertex as a disk of diameter 1, as pennies
+	Labels bool // write each graph's index in its cell
+}
+
+// cellPad is the margin inside each cell, in pixels.
+const cellPad = 12
+
+// layout is the shared placement: graph k's point p is drawn at
+// origin[k] + scale*p, with y growing upwards.
+type layout struct {
+	cols   int
+	scale  float64
+	xy     [][][2]float64
+	origin [][2]float64
+	width  int
+	height int
+}
+
+func newLayout(graphs []coordfile.Graph, o Options) (*layout, error) {
+	if len(graphs) == 0 {
+		return nil, fmt.Errorf("no graphs to plot")
+	}
+	if o.Cols < 1 || o.Cell < 4*cellPad {
+		return nil, fmt.Errorf("need at least 1 column and cells of %d pixels", 4*cellPad)
+	}
+	l := &layout{cols: min(o.Cols, len(graphs)), xy: make([][][2]float64, len(graphs)), origin: make([][2]float64, len(graphs))}
+	// Bounding boxes, padded by the coin radius when coins are drawn
+	pad := 0.0
+	if o.Coins {
+		pad = 0.5
+	}
+	lo := make([][2]float64, len(graphs))
+	hi := make([][2]float64, len(graphs))
+	extent := 0.0
+	for k, g := range graphs {
+		l.xy[k] = g.XY()
+		lo[k] = [2]float64{math.Inf(1), math.Inf(1)}
+		hi[k] = [2]float64{math.Inf(-1), math.Inf(-1)}
+		for _, p := range l.xy[k] {
+			for c := 0; c < 2; c++ {
+				lo[k][c] = math.Min(lo[k][c], p[c]-pad)
+				hi[k][c] = math.Max(hi[k][c], p[c]+pad)
+			}
+		}
+		if len(l.xy[k]) == 0 {
+			lo[k], hi[k] = [2]float64{}, [2]float64{}
+		}
+		extent = math.Max(extent, math.Max(hi[k][0]-lo[k][0], hi[k][1]-lo[k][1]))
+	}
+	if extent == 0 {
+		extent = 1
+	}
+	inner := float64(o.Cell - 2*cellPad)
+	if o.Labels {
+		inner -= labelHeight
+	}
+	l.scale = inner / extent
+
+	cols := l.cols
+	rows := (len(graphs) + cols - 1) / cols
+	l.width, l.height = cols*o.Cell, rows*o.Cell
+	for k := range graphs {
+		// Center the graph in the area below the label
+		cx := float64(k%cols*o.Cell) + float64(o.Cell)/2
+		top := float64(k/cols*o.Cell + cellPad)
+		if o.Labels {
+			top += labelHeight
+		}
+		cy := top + inner/2
+		mid := [2]float64{(lo[k][0] + hi[k][0]) / 2, (lo[k][1] + hi[k][1]) / 2}
+		l.origin[k] = [2]float64{cx - l.scale*mid[0], cy + l.scale*mid[1]}
+	}
+	return l, nil
+}
+
+// at returns the pixel position of graph k's vertex v.
+func (l *layout) at(k, v int) (float64, float64) {
+	p := l.xy[k][v]
+	return l.origin[k][0] + l.scale*p[0], l.origin[k][1] - l.scale*p[1]
+}
+
+// dotRadius is the radius of a vertex dot in pixels.
+func (l *layout) dotRadius() float64 {
+	return math.Max(2, math.Min(6, l.scale*0.08))
+}
+
+const labelHeight = 14
+
+// SVG writes the grid as an SVG document.
+func SVG(w io.Writer, graphs []coordfile.Graph, o Options) error {
+	l, err := newLayout(graphs, o)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", l.width, l.height, l.width, l.height)
+	fmt.Fprintf(b, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+	for k, g := range graphs {
+		fmt.Fprintf(b, "<g id=\"graph%d\">\n", g.Index)
+		if o.Labels {
+			x := k % l.cols * o.Cell
+			y := k / l.cols * o.Cell
+			fmt.Fprintf(b, "<text x=\"%d\" y=\"%d\" font-family=\"sans-serif\" font-size=\"12\">%d</text>\n", x+cellPad, y+cellPad+10, g.Index)
+		}
+		if o.Coins {
+			for v := range g.Pos {
+				x, y := l.at(k, v)
+				fmt.Fprintf(b, "<circle cx=\"%s\" cy=\"%s\" r=\"%s\" fill=\"#f3e2c0\" stroke=\"#b08d57\"/>\n", num(x), num(y), num(l.scale/2))
+			}
+		}
+		for _, e := range g.Edges {
+			x1, y1 := l.at(k, e[0])
+			x2, y2 := l.at(k, e[1])
+			fmt.Fprintf(b, "<line x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\" stroke=\"#333\" stroke-width=\"1.5\"/>\n", num(x1), num(y1), num(x2), num(y2))
+		}
+		for v := range g.Pos {
+			x, y := l.at(k, v)
+			fmt.Fprintf(b, "<circle cx=\"%s\" cy=\"%s\" r=\"%s\" fill=\"#1f5fa8\"/>\n", num(x), num(y), num(l.dotRadius()))
+		}
+		fmt.Fprintf(b, "</g>\n")
+	}
+	fmt.Fprintf(b, "</svg>\n")
+	return b.Flush()
+}
+
+func num(x float64) string {
+	return strconv.FormatFloat(x, 'f', 2, 64)
+}
+
+var (
+	white    = color.RGBA{255, 255, 255, 255}
+	edgeInk  = color.RGBA{0x33, 0x33, 0x33, 255}
+	dotInk   = color.RGBA{0x1f, 0x5f, 0xa8, 255}
+	coinFill = color.RGBA{0xf3, 0xe2, 0xc0, 255}
+	coinRim  = color.RGBA{0xb0, 0x8d, 0x57, 255}
+	gridInk  = color.RGBA{0xdd, 0xdd, 0xdd, 255}
+)
+
+// PNG writes the grid as a PNG image.
+func PNG(w io.Writer, graphs []coordfile.Graph, o Options) error {
+	l, err := newLayout(graphs, o)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, l.width, l.height))
+	fillRect(img, img.Bounds(), white)
+	cols := l.cols
+	for k, g := range graphs {
+		cell := image.Rect(k%cols*o.Cell, k/cols*o.Cell, (k%cols+1)*o.Cell, (k/cols+1)*o.Cell)
+		// Cell borders, so neighboring graphs don't run together
+		fillRect(img, image.Rect(cell.Max.X-1, cell.Min.Y, cell.Max.X, cell.Max.Y), gridInk)
+		fillRect(img, image.Rect(cell.Min.X, cell.Max.Y-1, cell.Max.X, cell.Max.Y), gridInk)
+		if o.Labels {
+			drawNumber(img, cell.Min.X+cellPad, cell.Min.Y+cellPad, g.Index, edgeInk)
+		}
+		if o.Coins {
+			for v := range g.Pos {
+				x, y := l.at(k, v)
+				disk(img, x, y, l.scale/2, coinRim)
+				disk(img, x, y, l.scale/2-1.5, coinFill)
+			}
+		}
+		for _, e := range g.Edges {
+			x1, y1 := l.at(k, e[0])
+			x2, y2 := l.at(k, e[1])
+			segment(img, x1, y1, x2, y2, 0.9, edgeInk)
+		}
+		for v := range g.Pos {
+			x, y := l.at(k, v)
+			disk(img, x, y, l.dotRadius(), dotInk)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// disk fills the pixels whose centers lie within radius of (cx, cy).
+func disk(img *image.RGBA, cx, cy, radius float64, c color.RGBA) {
+	r := image.Rect(int(cx-radius)-1, int(cy-radius)-1, int(cx+radius)+2, int(cy+radius)+2).Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy) <= radius {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// segment fills the pixels whose centers lie within halfWidth of the
+// segment from (x1, y1) to (x2, y2).
+func segment(img *image.RGBA, x1, y1, x2, y2, halfWidth float64, c color.RGBA) {
+	r := image.Rect(int(math.Min(x1, x2)-halfWidth)-1, int(math.Min(y1, y2)-halfWidth)-1,
+		int(math.Max(x1, x2)+halfWidth)+2, int(math.Max(y1, y2)+halfWidth)+2).Intersect(img.Bounds())
+	dx, dy := x2-x1, y2-y1
+	length2 := dx*dx + dy*dy
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			t := 0.0
+			if length2 > 0 {
+				t = math.Max(0, math.Min(1, ((px-x1)*dx+(py-y1)*dy)/length2))
+			}
+			if math.Hypot(px-x1-t*dx, py-y1-t*dy) <= halfWidth {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// digits is a 3x5 pixel font for the cell labels, one row per string.
+var digits = [10][5]string{
+	{"###", "#.#", "#.#", "#.#", "###"},
+	{".#.", "##.", ".#.", ".#.", "###"},
+	{"###", "..#", "###", "#..", "###"},
+	{"###", "..#", "###", "..#", "###"},
+	{"#.#", "#.#", "###", "..#", "..#"},
+	{"###", "#..", "###", "..#", "###"},
+	{"###", "#..", "###", "#.#", "###"},
+	{"###", "..#", "..#", "..#", "..#"},
+	{"###", "#.#", "###", "#.#", "###"},
+	{"###", "#.#", "###", "..#", "###"},
+}
+
+// drawNumber writes the decimal digits of k with their top left at (x, y),
+// each font pixel 2x2.
+func drawNumber(img *image.RGBA, x, y, k int, c color.RGBA) {
+	const px = 2
+	for _, ch := range strconv.Itoa(k) {
+		if ch < '0' || ch > '9' {
+			continue
+		}
+		for row, bits := range digits[ch-'0'] {
+			for col, bit := range bits {
+				if bit == '#' {
+					fillRect(img, image.Rect(x+col*px, y+row*px, x+(col+1)*px, y+(row+1)*px), c)
+				}
+			}
+		}
+		x += 4 * px
+	}
+}
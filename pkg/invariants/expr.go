@@ -0,0 +1,387 @@
+package invariants
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled predicate over Invariants, such as
+//
+//	edges==26 && maxdeg<=6 && girth>=3 && connected
+//
+// Operands are integer literals, true, false and the variables listed in
+// Variables. Operators, loosest first: ||, &&, comparisons (== != < <= > >=),
+// + -, * / %, unary ! and -. Parentheses group.
+type Expr struct {
+	src  string
+	eval func(inv *Invariants) (value, error)
+}
+
+type value struct {
+	n int
+	b bool
+}
+
+type exprType int
+
+const (
+	intType exprType = iota
+	boolType
+)
+
+func (t exprType) String() string {
+	if t == boolType {
+		return "bool"
+	}
+	return "int"
+}
+
+type variable struct {
+	typ exprType
+	doc string
+	get func(inv *Invariants) (value, error)
+}
+
+func intVar(doc string, get func(inv *Invariants) int) variable {
+	return variable{intType, doc, func(inv *Invariants) (value, error) { return value{n: get(inv)}, nil }}
+}
+
+// exactVar is a variable that is only computed up to ExactLimit.
+func exactVar(name, doc string, get func(inv *Invariants) int) variable {
+	return variable{intType, doc, func(inv *Invariants) (value, error) {
+		if inv.N > ExactLimit {
+			return value{}, fmt.Errorf("%s is not computed above n=%d", name, ExactLimit)
+		}
+		return value{n: get(inv)}, nil
+	}}
+}
+
+var variables = map[string]variable{
+	"n":            intVar("vertices", func(inv *Invariants) int { return inv.N }),
+	"edges":        intVar("edges", func(inv *Invariants) int { return inv.Edges }),
+	"mindeg":       intVar("minimum degree", func(inv *Invariants) int { return inv.MinDegree }),
+	"maxdeg":       intVar("maximum degree", func(inv *Invariants) int { return inv.MaxDegree }),
+	"triangles":    intVar("number of triangles", func(inv *Invariants) int { return inv.Triangles }),
+	"girth":        intVar("shortest cycle, 0 for a forest", func(inv *Invariants) int { return inv.Girth }),
+	"diameter":     intVar("diameter, -1 if disconnected", func(inv *Invariants) int { return inv.Diameter }),
+	"components":   intVar("connected components", func(inv *Invariants) int { return inv.Components }),
+	"independence": exactVar("independence", "independence number (n <= 13)", func(inv *Invariants) int { return inv.Independence }),
+	"chromatic":    exactVar("chromatic", "chromatic number (n <= 13)", func(inv *Invariants) int { return inv.Chromatic }),
+	"connected": {boolType, "true if connected", func(inv *Invariants) (value, error) {
+		return value{b: inv.Connected}, nil
+	}},
+}
+
+// Variables returns "name: description" for every variable, sorted by name.
+func Variables() []string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s: %s", name, variables[name].doc)
+	}
+	return names
+}
+
+// Compile parses a predicate expression.
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	t, eval, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, p.errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if t != boolType {
+		return nil, fmt.Errorf("expression %q is an int, not a condition", src)
+	}
+	return &Expr{src, eval}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match evaluates the expression for inv.
+func (e *Expr) Match(inv *Invariants) (bool, error) {
+	v, err := e.eval(inv)
+	return v.b, err
+}
+
+type token struct {
+	text string
+	pos  int
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	at := len(p.src)
+	if p.pos < len(p.toks) {
+		at = p.toks[p.pos].pos
+	}
+	return fmt.Errorf("expression %q at offset %d: %s", p.src, at, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || unicode.IsLetter(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			p.toks = append(p.toks, token{s[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, cand := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")"} {
+				if strings.HasPrefix(s[i:], cand) {
+					op = cand
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("expression %q at offset %d: unexpected character %q", s, i, s[i])
+			}
+			p.toks = append(p.toks, token{op, i})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].text
+	}
+	return ""
+}
+
+type evalFunc = func(inv *Invariants) (value, error)
+
+// binary parses a left-associative chain of next separated by ops.
+func (p *parser) binary(next func() (exprType, evalFunc, error), ops ...string) (exprType, evalFunc, error) {
+	t, left, err := next()
+	if err != nil {
+		return 0, nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range ops {
+			if op == o {
+				found = true
+			}
+		}
+		if !found {
+			return t, left, nil
+		}
+		p.pos++
+		rt, right, err := next()
+		if err != nil {
+			return 0, nil, err
+		}
+		t, left, err = p.combine(op, t, left, rt, right)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func (p *parser) combine(op string, lt exprType, left evalFunc, rt exprType, right evalFunc) (exprType, evalFunc, error) {
+	switch op {
+	case "&&", "||":
+		if lt != boolType || rt != boolType {
+			return 0, nil, p.errorf("%s needs conditions on both sides", op)
+		}
+		and := op == "&&"
+		return boolType, func(inv *Invariants) (value, error) {
+			l, err := left(inv)
+			if err != nil || l.b != and {
+				// Short-circuit: false && x, true || x
+				return l, err
+			}
+			return right(inv)
+		}, nil
+	case "==", "!=":
+		if lt != rt {
+			return 0, nil, p.errorf("%s compares %s with %s", op, lt, rt)
+		}
+		eq := op == "=="
+		return boolType, func(inv *Invariants) (value, error) {
+			l, err := left(inv)
+			if err != nil {
+				return value{}, err
+			}
+			r, err := right(inv)
+			if err != nil {
+				return value{}, err
+			}
+			return value{b: (l == r) == eq}, nil
+		}, nil
+	}
+	if lt != intType || rt != intType {
+		return 0, nil, p.errorf("%s needs numbers on both sides", op)
+	}
+	var f func(a, b int) (value, error)
+	switch op {
+	case "<":
+		f = func(a, b int) (value, error) { return value{b: a < b}, nil }
+	case "<=":
+		f = func(a, b int) (value, error) { return value{b: a <= b}, nil }
+	case ">":
+		f = func(a, b int) (value, error) { return value{b: a > b}, nil }
+	case ">=":
+		f = func(a, b int) (value, error) { return value{b: a >= b}, nil }
+	case "+":
+		f = func(a, b int) (value, error) { return value{n: a + b}, nil }
+	case "-":
+		f = func(a, b int) (value, error) { return value{n: a - b}, nil }
+	case "*":
+		f = func(a, b int) (value, error) { return value{n: a * b}, nil }
+	case "/", "%":
+		mod := op == "%"
+		f = func(a, b int) (value, error) {
+			if b == 0 {
+				return value{}, errors.New("division by zero")
+			}
+			if mod {
+				return value{n: a % b}, nil
+			}
+			return value{n: a / b}, nil
+		}
+	}
+	t := intType
+	switch op {
+	case "<", "<=", ">", ">=":
+		t = boolType
+	}
+	return t, func(inv *Invariants) (value, error) {
+		l, err := left(inv)
+		if err != nil {
+			return value{}, err
+		}
+		r, err := right(inv)
+		if err != nil {
+			return value{}, err
+		}
+		return f(l.n, r.n)
+	}, nil
+}
+
+func (p *parser) or() (exprType, evalFunc, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *parser) and() (exprType, evalFunc, error) {
+	return p.binary(p.compare, "&&")
+}
+
+func (p *parser) compare() (exprType, evalFunc, error) {
+	t, left, err := p.sum()
+	if err != nil {
+		return 0, nil, err
+	}
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		rt, right, err := p.sum()
+		if err != nil {
+			return 0, nil, err
+		}
+		return p.combine(op, t, left, rt, right)
+	}
+	return t, left, nil
+}
+
+func (p *parser) sum() (exprType, evalFunc, error) {
+	return p.binary(p.product, "+", "-")
+}
+
+func (p *parser) product() (exprType, evalFunc, error) {
+	return p.binary(p.unary, "*", "/", "%")
+}
+
+func (p *parser) unary() (exprType, evalFunc, error) {
+	switch op := p.peek(); op {
+	case "!", "-":
+		p.pos++
+		t, operand, err := p.unary()
+		if err != nil {
+			return 0, nil, err
+		}
+		if op == "!" {
+			if t != boolType {
+				return 0, nil, p.errorf("! needs a condition")
+			}
+			return boolType, func(inv *Invariants) (value, error) {
+				v, err := operand(inv)
+				return value{b: !v.b}, err
+			}, nil
+		}
+		if t != intType {
+			return 0, nil, p.errorf("unary - needs a number")
+		}
+		return intType, func(inv *Invariants) (value, error) {
+			v, err := operand(inv)
+			return value{n: -v.n}, err
+		}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (exprType, evalFunc, error) {
+	if p.pos >= len(p.toks) {
+		return 0, nil, p.errorf("unexpected end")
+	}
+	tok := p.toks[p.pos].text
+	p.pos++
+	switch {
+	case tok == "(":
+		t, inner, err := p.or()
+		if err != nil {
+			return 0, nil, err
+		}
+		if p.peek() != ")" {
+			return 0, nil, p.errorf("missing )")
+		}
+		p.pos++
+		return t, inner, nil
+	case tok == "true" || tok == "false":
+		b := tok == "true"
+		return boolType, func(*Invariants) (value, error) { return value{b: b}, nil }, nil
+	case unicode.IsDigit(rune(tok[0])):
+		n, err := strconv.Atoi(tok)
+		if err != nil {
+			p.pos--
+			return 0, nil, p.errorf("bad number %q", tok)
+		}
+		return intType, func(*Invariants) (value, error) { return value{n: n}, nil }, nil
+	}
+	v, ok := variables[tok]
+	if !ok {
+		p.pos--
+		return 0, nil, p.errorf("unknown name %q", tok)
+	}
+	return v.typ, v.get, nil
+}
@@ -0,0 +1,358 @@
+// Package invariants computes graph invariants (degree sequence, triangles,
+// girth, diameter, connectivity, independence and chromatic number) for the
+// annotate and filter commands. Its Graph, one neighbor bitmask per vertex,
+// is also what the penny_enum tools convert their edge bitmasks to (with
+// FromMask) for degrees and connectivity in word operations.
+package invariants
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"hexagon_clink/pkg/graph6"
+)
+
+// MaxN is the largest vertex count a Graph can hold.
+const MaxN = 64
+
+// ExactLimit is the largest n for which the independence and chromatic
+// numbers are computed; both are exponential in the worst case.
+const ExactLimit = 13
+
+// Graph is an undirected simple graph as one neighbor bitmask per vertex.
+type Graph struct {
+	N   int
+	Adj []uint64
+}
+
+// New returns the empty graph on n vertices.
+func New(n int) Graph {
+	if n < 0 || n > MaxN {
+		panic(fmt.Sprintf("invariants: n=%d out of range", n))
+	}
+	return Graph{N: n, Adj: make([]uint64, n)}
+}
+
+// AddEdge adds the edge (i, j).
+func (g Graph) AddEdge(i, j int) {
+	g.Adj[i] |= 1 << j
+	g.Adj[j] |= 1 << i
+}
+
+// HasEdge reports whether (i, j) is an edge.
+func (g Graph) HasEdge(i, j int) bool {
+	return g.Adj[i]&(1<<j) != 0
+}
+
+// maskPairs[n][k] is the k-th pair of the FromMask layout on n vertices.
+var maskPairs [12][][2]uint8
+
+func init() {
+	for n := range maskPairs {
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				maskPairs[n] = append(maskPairs[n], [2]uint8{uint8(i), uint8(j)})
+			}
+		}
+	}
+}
+
+// FromMask builds a graph from an edge bitmask in the penny_enum layout:
+// bit k is the k-th pair (i, j), i < j, in the order (0,1), (0,2), ...,
+// (0,n-1), (1,2), ... Bits past the last pair are ignored. It visits only
+// the set bits, so it costs one step per edge.
+func FromMask(n int, mask uint64) Graph {
+	if n >= len(maskPairs) {
+		panic(fmt.Sprintf("invariants: n=%d has too many edges for a bitmask", n))
+	}
+	g := New(n)
+	pairs := maskPairs[n]
+	for rest := mask & (1<<len(pairs) - 1); rest != 0; rest &= rest - 1 {
+		p := pairs[bits.TrailingZeros64(rest)]
+		g.AddEdge(int(p[0]), int(p[1]))
+	}
+	return g
+}
+
+// Mask returns the edge bitmask of g in the FromMask layout. It panics if
+// the edges don't fit in 64 bits (n > 11).
+func (g Graph) Mask() uint64 {
+	if g.N*(g.N-1)/2 > 64 {
+		panic(fmt.Sprintf("invariants: n=%d has too many edges for a bitmask", g.N))
+	}
+	var mask uint64
+	idx := 0
+	for i := 0; i < g.N; i++ {
+		for j := i + 1; j < g.N; j++ {
+			if g.HasEdge(i, j) {
+				mask |= 1 << idx
+			}
+			idx++
+		}
+	}
+	return mask
+}
+
+// Degree returns the degree of v.
+func (g Graph) Degree(v int) int {
+	return bits.OnesCount64(g.Adj[v])
+}
+
+// MaxDegree returns the largest degree of g (0 for no vertices).
+func (g Graph) MaxDegree() int {
+	top := 0
+	for _, a := range g.Adj {
+		top = max(top, bits.OnesCount64(a))
+	}
+	return top
+}
+
+// Reach returns the vertices reachable from v, v included, as a bitmask.
+// The search advances a whole frontier per step: the next one is the union
+// of the frontier's neighbor masks.
+func (g Graph) Reach(v int) uint64 {
+	seen := uint64(1) << v
+	for frontier := seen; frontier != 0; {
+		var next uint64
+		for f := frontier; f != 0; f &= f - 1 {
+			next |= g.Adj[bits.TrailingZeros64(f)]
+		}
+		frontier = next &^ seen
+		seen |= frontier
+	}
+	return seen
+}
+
+// Connected reports whether g has exactly one component; the empty graph
+// on no vertices is not connected.
+func (g Graph) Connected() bool {
+	if g.N == 0 {
+		return false
+	}
+	return g.Reach(0) == 1<<g.N-1
+}
+
+// ParseGraph6 decodes one graph6 line (n <= MaxN).
+func ParseGraph6(line string) (Graph, error) {
+	n, err := graph6.Size(line)
+	if err != nil {
+		return Graph{}, err
+	}
+	if n > MaxN {
+		return Graph{}, fmt.Errorf("graph6: n=%d, at most %d supported", n, MaxN)
+	}
+	g := New(n)
+	if _, err := graph6.Each(line, g.AddEdge); err != nil {
+		return Graph{}, err
+	}
+	return g, nil
+}
+
+// Graph6 encodes g as a graph6 line without the newline.
+func (g Graph) Graph6() string {
+	return graph6.Encode(g.N, g.HasEdge)
+}
+
+// Invariants holds the invariants of one graph. Girth is 0 for a forest,
+// Diameter is -1 for a disconnected graph, and Independence and Chromatic
+// are -1 when n exceeds ExactLimit.
+type Invariants struct {
+	N            int   `json:"n"`
+	Edges        int   `json:"edges"`
+	Degrees      []int `json:"degrees"` // non-increasing
+	MinDegree    int   `json:"min_degree"`
+	MaxDegree    int   `json:"max_degree"`
+	Triangles    int   `json:"triangles"`
+	Girth        int   `json:"girth"`
+	Diameter     int   `json:"diameter"`
+	Connected    bool  `json:"connected"`
+	Components   int   `json:"components"`
+	Independence int   `json:"independence"`
+	Chromatic    int   `json:"chromatic"`
+}
+
+// Compute returns the invariants of g.
+func Compute(g Graph) Invariants {
+	inv := Invariants{N: g.N, Degrees: make([]int, g.N)}
+	for v := 0; v < g.N; v++ {
+		inv.Degrees[v] = bits.OnesCount64(g.Adj[v])
+		inv.Edges += inv.Degrees[v]
+	}
+	inv.Edges /= 2
+	sort.Sort(sort.Reverse(sort.IntSlice(inv.Degrees)))
+	if g.N > 0 {
+		inv.MaxDegree = inv.Degrees[0]
+		inv.MinDegree = inv.Degrees[g.N-1]
+	}
+	inv.Triangles = Triangles(g)
+	inv.Components = Components(g)
+	inv.Connected = inv.Components == 1
+	inv.Girth, inv.Diameter = girthDiameter(g)
+	if !inv.Connected {
+		inv.Diameter = -1
+	}
+	inv.Independence, inv.Chromatic = -1, -1
+	if g.N <= ExactLimit {
+		inv.Independence = Independence(g)
+		inv.Chromatic = Chromatic(g)
+	}
+	return inv
+}
+
+// Triangles counts the triangles of g.
+func Triangles(g Graph) int {
+	count := 0
+	for u := 0; u < g.N; u++ {
+		// Count each triangle once, from its smallest vertex
+		higher := g.Adj[u] &^ (1<<(u+1) - 1)
+		for rest := higher; rest != 0; rest &= rest - 1 {
+			v := bits.TrailingZeros64(rest)
+			count += bits.OnesCount64(g.Adj[v] & higher &^ (1<<(v+1) - 1))
+		}
+	}
+	return count
+}
+
+// Components counts the connected components of g.
+func Components(g Graph) int {
+	var seen uint64
+	count := 0
+	for v := 0; v < g.N; v++ {
+		if seen&(1<<v) != 0 {
+			continue
+		}
+		count++
+		seen |= g.Reach(v)
+	}
+	return count
+}
+
+// girthDiameter runs a BFS from every vertex. The girth is the shortest
+// cycle closed by a non-tree edge over all roots; the diameter is the
+// largest eccentricity (only meaningful for connected graphs).
+func girthDiameter(g Graph) (girth, diameter int) {
+	dist := make([]int, g.N)
+	parent := make([]int, g.N)
+	queue := make([]int, 0, g.N)
+	for s := 0; s < g.N; s++ {
+		for v := range dist {
+			dist[v] = -1
+		}
+		dist[s], parent[s] = 0, -1
+		queue = append(queue[:0], s)
+		for head := 0; head < len(queue); head++ {
+			u := queue[head]
+			if dist[u] > diameter {
+				diameter = dist[u]
+			}
+			for rest := g.Adj[u]; rest != 0; rest &= rest - 1 {
+				w := bits.TrailingZeros64(rest)
+				if dist[w] < 0 {
+					dist[w], parent[w] = dist[u]+1, u
+					queue = append(queue, w)
+				} else if parent[u] != w {
+					if c := dist[u] + dist[w] + 1; girth == 0 || c < girth {
+						girth = c
+					}
+				}
+			}
+		}
+	}
+	return girth, diameter
+}
+
+// Independence returns the size of a largest independent set of g.
+func Independence(g Graph) int {
+	var best func(p uint64) int
+	best = func(p uint64) int {
+		if p == 0 {
+			return 0
+		}
+		// Branch on a vertex of maximum degree within p; one of degree
+		// <= 1 can always be taken
+		v, vdeg := -1, -1
+		for rest := p; rest != 0; rest &= rest - 1 {
+			u := bits.TrailingZeros64(rest)
+			if d := bits.OnesCount64(g.Adj[u] & p); d > vdeg {
+				v, vdeg = u, d
+			}
+		}
+		if vdeg <= 1 {
+			// p is a matching plus isolated vertices
+			return bits.OnesCount64(p) - countEdges(g, p)
+		}
+		with := 1 + best(p&^(g.Adj[v]|1<<v))
+		if without := best(p &^ (1 << v)); without > with {
+			return without
+		}
+		return with
+	}
+	return best(1<<g.N - 1)
+}
+
+func countEdges(g Graph, p uint64) int {
+	total := 0
+	for rest := p; rest != 0; rest &= rest - 1 {
+		total += bits.OnesCount64(g.Adj[bits.TrailingZeros64(rest)] & p)
+	}
+	return total / 2
+}
+
+// Chromatic returns the chromatic number of g.
+func Chromatic(g Graph) int {
+	if g.N == 0 {
+		return 0
+	}
+	// Color vertices in order of decreasing degree
+	order := make([]int, g.N)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return bits.OnesCount64(g.Adj[order[a]]) > bits.OnesCount64(g.Adj[order[b]])
+	})
+	color := make([]int, g.N)
+	var colorable func(i, k, used int) bool
+	colorable = func(i, k, used int) bool {
+		if i == g.N {
+			return true
+		}
+		v := order[i]
+		// A fresh color is interchangeable with any other fresh one
+		limit := used + 1
+		if limit > k {
+			limit = k
+		}
+		for c := 1; c <= limit; c++ {
+			ok := true
+			for rest := g.Adj[v]; rest != 0; rest &= rest - 1 {
+				if color[bits.TrailingZeros64(rest)] == c {
+					ok = false
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+			color[v] = c
+			next := used
+			if c > used {
+				next = c
+			}
+			if colorable(i+1, k, next) {
+				return true
+			}
+		}
+		color[v] = 0
+		return false
+	}
+	for k := 1; ; k++ {
+		for v := range color {
+			color[v] = 0
+		}
+		if colorable(0, k, 0) {
+			return k
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package jsonl gives the command-line tools a machine-readable output
+// mode: events such as progress, solutions and final results, one JSON
+// object per line on stdout, for scripts and dashboards to follow.
+//
+// While it is on, the tools' usual text output moves to stderr (Start
+// redirects os.Stdout), so stdout carries nothing but events and the text
+// stays visible on the terminal.
+package jsonl
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Emitter writes events. A nil *Emitter is valid and discards them, so
+// tools call Emit unconditionally.
+type Emitter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	tool  string
+	start time.Time
+}
+
+// Fields are the payload of one event.
+type Fields map[string]any
+
+// Start returns an Emitter for tool if enabled, else nil. Enabling it
+// points os.Stdout at stderr and keeps the real stdout for events.
+func Start(tool string, enabled bool) *Emitter {
+	if !enabled {
+		return nil
+	}
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	return New(tool, out)
+}
+
+// New returns an Emitter writing to w, without touching os.Stdout.
+func New(tool string, w io.Writer) *Emitter {
+	return &Emitter{enc: json.NewEncoder(w), tool: tool, start: time.Now()}
+}
+
+// Emit writes one event: {"event": event, "tool": ..., "elapsed": seconds
+// since Start, plus fields}. Emit is safe for concurrent use.
+func (e *Emitter) Emit(event string, fields Fields) {
+	if e == nil {
+		return
+	}
+	rec := Fields{
+		"event":   event,
+		"tool":    e.tool,
+		"elapsed": time.Since(e.start).Seconds(),
+	}
+	for k, v := range fields {
+		rec[k] = v
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(rec)
+}
+
+// Enabled reports whether events are written.
+func (e *Emitter) Enabled() bool {
+	return e != nil
+}
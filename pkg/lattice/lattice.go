@@ -0,0 +1,227 @@
+// Package lattice decides whether a graph embeds in the triangular lattice
+// with unit edges: the centers of a hexagonal packing of equal coins, which
+// is what the clink solvers' spiral is cut from. Vertices are placed on
+// distinct lattice points in axial coordinates by backtracking so that
+// every edge joins neighboring points. As a subgraph, non-adjacent vertices
+// may still land on neighboring points; as an induced subgraph (the contact
+// graph of a set of coins in the packing, like the spiral) they may not.
+package lattice
+
+import (
+	"math"
+	"math/bits"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// Point is a lattice point in axial coordinates: Q along one lattice
+// direction, R along the direction 60 degrees from it.
+type Point struct{ Q, R int }
+
+// Dirs are the six unit steps, counterclockwise from +Q.
+var Dirs = [6]Point{{1, 0}, {0, 1}, {-1, 1}, {-1, 0}, {0, -1}, {1, -1}}
+
+// Add returns p + d.
+func (p Point) Add(d Point) Point {
+	return Point{p.Q + d.Q, p.R + d.R}
+}
+
+// XY returns p's position in the plane, (Q + R/2, R·√3/2), so lattice
+// neighbors are 1 apart.
+func (p Point) XY() (x, y float64) {
+	return float64(p.Q) + float64(p.R)/2, float64(p.R) * math.Sqrt(3) / 2
+}
+
+// Dist returns the number of unit steps between p and o.
+func Dist(p, o Point) int {
+	dq, dr := p.Q-o.Q, p.R-o.R
+	return (abs(dq) + abs(dr) + abs(dq+dr)) / 2
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Embed places g's vertices on the lattice so that adjacent vertices are on
+// neighboring points, and, if induced, non-adjacent ones are not. It returns
+// the position of each vertex, or false if there is no such placement.
+// Components are placed apart from each other; the first vertex of each
+// sits at a multiple of (2n, 0).
+func Embed(g invariants.Graph, induced bool) ([]Point, bool) {
+	n := g.N
+	for v := 0; v < n; v++ {
+		if degree(g, v) > 6 {
+			return nil, false
+		}
+	}
+	e := &embedder{
+		g:       g,
+		induced: induced,
+		dist:    distances(g),
+		pos:     make([]Point, n),
+		placed:  make([]bool, n),
+		at:      make(map[Point]int, n),
+	}
+	for start, comp := 0, 0; start < n; start++ {
+		if e.placed[start] {
+			continue
+		}
+		order := placementOrder(g, start)
+		if !e.place(order, 0, Point{2 * n * comp, 0}) {
+			return nil, false
+		}
+		comp++
+	}
+	return e.pos, true
+}
+
+type embedder struct {
+	g       invariants.Graph
+	induced bool
+	dist    [][]int // graph distances, n between components
+	pos     []Point
+	placed  []bool
+	at      map[Point]int
+}
+
+// place assigns positions to order[i:], given order[:i] placed. The first
+// vertex goes to origin and the second to origin+Dirs[0], which removes the
+// rotations; candidates off the first line are kept on one side of it,
+// which removes the reflection.
+func (e *embedder) place(order []int, i int, origin Point) bool {
+	if i == len(order) {
+		return true
+	}
+	v := order[i]
+	var cands []Point
+	switch {
+	case i == 0:
+		cands = []Point{origin}
+	case i == 1:
+		cands = []Point{origin.Add(Dirs[0])}
+	default:
+		anchor := -1
+		onLine := true
+		for _, u := range order[:i] {
+			if anchor < 0 && e.g.HasEdge(u, v) {
+				anchor = u
+			}
+			if e.pos[u].R != origin.R {
+				onLine = false
+			}
+		}
+		for _, d := range Dirs {
+			p := e.pos[anchor].Add(d)
+			if onLine && p.R < origin.R {
+				continue
+			}
+			cands = append(cands, p)
+		}
+	}
+	for _, p := range cands {
+		if !e.fits(order[:i], v, p) {
+			continue
+		}
+		e.pos[v], e.placed[v] = p, true
+		e.at[p] = v
+		if e.place(order, i+1, origin) {
+			return true
+		}
+		e.placed[v] = false
+		delete(e.at, p)
+	}
+	return false
+}
+
+// fits reports whether v can go to p with the vertices in done placed.
+// Besides the edge conditions, no two vertices may be further apart on the
+// lattice than in the graph, which cuts off branches that can't close a
+// cycle.
+func (e *embedder) fits(done []int, v int, p Point) bool {
+	if _, taken := e.at[p]; taken {
+		return false
+	}
+	for _, u := range done {
+		d := Dist(p, e.pos[u])
+		if e.g.HasEdge(u, v) && d != 1 {
+			return false
+		}
+		if du := e.dist[u][v]; du < e.g.N && d > du {
+			return false
+		}
+	}
+	if e.induced {
+		for _, d := range Dirs {
+			if u, ok := e.at[p.Add(d)]; ok && !e.g.HasEdge(u, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func degree(g invariants.Graph, v int) int {
+	return bits.OnesCount64(g.Adj[v])
+}
+
+// placementOrder lists the component of start starting there, always
+// taking next the vertex with the most neighbors already listed (ties to
+// the higher degree), so constraints bite as early as possible. Every
+// vertex after the first has a listed neighbor.
+func placementOrder(g invariants.Graph, start int) []int {
+	in := make([]bool, g.N)
+	order := []int{start}
+	in[start] = true
+	for {
+		best, bestLinks := -1, 0
+		for v := 0; v < g.N; v++ {
+			if in[v] {
+				continue
+			}
+			links := 0
+			for _, u := range order {
+				if g.HasEdge(u, v) {
+					links++
+				}
+			}
+			if links > bestLinks || links > 0 && links == bestLinks && degree(g, v) > degree(g, best) {
+				best, bestLinks = v, links
+			}
+		}
+		if best < 0 {
+			return order
+		}
+		in[best] = true
+		order = append(order, best)
+	}
+}
+
+// distances returns all-pairs shortest path lengths by BFS; unreachable
+// pairs get n.
+func distances(g invariants.Graph) [][]int {
+	n := g.N
+	dist := make([][]int, n)
+	for s := 0; s < n; s++ {
+		d := make([]int, n)
+		for i := range d {
+			d[i] = n
+		}
+		d[s] = 0
+		queue := []int{s}
+		for len(queue) > 0 {
+			u := queue[0]
+			queue = queue[1:]
+			for v := 0; v < n; v++ {
+				if g.HasEdge(u, v) && d[v] == n {
+					d[v] = d[u] + 1
+					queue = append(queue, v)
+				}
+			}
+		}
+		dist[s] = d
+	}
+	return dist
+}
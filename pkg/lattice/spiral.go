@@ -0,0 +1,61 @@
+package lattice
+
+import "hexagon_clink/pkg/invariants"
+
+// Spiral returns the positions of the first n coins of the solvers' spiral:
+// each coin goes next to the previous one, on the free point touching the
+// most coins already placed, ties going to the point nearest the first coin
+// and then to the first direction in Dirs. This is solver_general's
+// buildSpiral on integer coordinates, which lays Q along (1.5, 0) and R
+// along (0.75, 1.3); the rounded 1.3 makes distances slightly favor small
+// |R|, and spiralDist keeps that so both give the same slot order.
+func Spiral(n int) []Point {
+	if n <= 0 {
+		return nil
+	}
+	pts := []Point{{0, 0}}
+	placed := map[Point]bool{{0, 0}: true}
+	for len(pts) < n {
+		prev := pts[len(pts)-1]
+		var best Point
+		bestContacts, bestDist := -1, 0
+		for _, d := range Dirs {
+			cand := prev.Add(d)
+			if placed[cand] {
+				continue
+			}
+			contacts := 0
+			for _, dd := range Dirs {
+				if placed[cand.Add(dd)] {
+					contacts++
+				}
+			}
+			if dist := spiralDist(cand); contacts > bestContacts || contacts == bestContacts && dist < bestDist {
+				best, bestContacts, bestDist = cand, contacts, dist
+			}
+		}
+		pts = append(pts, best)
+		placed[best] = true
+	}
+	return pts
+}
+
+// spiralDist is 400 times the squared distance of p from the origin in
+// buildSpiral's coordinates: (1.5Q + 0.75R)^2 + (1.3R)^2.
+func spiralDist(p Point) int {
+	return 900*p.Q*p.Q + 900*p.Q*p.R + 901*p.R*p.R
+}
+
+// Contact returns the contact graph of coins at pts: vertex i is pts[i],
+// and coins on neighboring points touch.
+func Contact(pts []Point) invariants.Graph {
+	g := invariants.New(len(pts))
+	for i := range pts {
+		for j := i + 1; j < len(pts); j++ {
+			if Dist(pts[i], pts[j]) == 1 {
+				g.AddEdge(i, j)
+			}
+		}
+	}
+	return g
+}
@@ -0,0 +1,166 @@
+// Package logging gives the search tools one format for their progress
+// lines: each line is tagged with the time since the run started and, for
+// lines from a worker, the worker's ID, and it is printed only if the run's
+// level asks for it:
+//
+//	[   12.345s w3] First valid arr2: [...]
+//
+// The levels are quiet (no progress lines, only the results the tools print
+// themselves and errors), normal, verbose and debug. Tools wire them to
+// -log-level and -log-file; a log file gets the same lines, each after the
+// wall-clock time, appended so that several runs can share it.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how much a run logs.
+type Level int
+
+const (
+	Quiet Level = iota
+	Normal
+	Verbose
+	Debug
+)
+
+var levelNames = []string{"quiet", "normal", "verbose", "debug"}
+
+func (l Level) String() string {
+	if l < Quiet || l > Debug {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return Quiet, fmt.Errorf("log level %q: want one of %v", s, levelNames)
+}
+
+// sink is what the loggers of one run share.
+type sink struct {
+	mu    sync.Mutex
+	level Level
+	start time.Time
+	file  io.WriteCloser
+}
+
+// Logger writes tagged lines. A nil *Logger is valid and discards them, so
+// code that runs with and without one logs unconditionally. Loggers are
+// safe for concurrent use, and lines from different workers don't
+// interleave.
+type Logger struct {
+	sink *sink
+	tag  string
+}
+
+// New returns a Logger at level writing to stdout and, unless logFile is
+// empty, appending to logFile. Stdout is looked up at each line, so the
+// lines follow it to stderr when jsonl.Start takes stdout for events.
+func New(level Level, logFile string) (*Logger, error) {
+	s := &sink{level: level, start: time.Now()}
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+		s.file = f
+		fmt.Fprintf(f, "# %s %s\n", time.Now().Format(time.RFC3339), strings.Join(os.Args, " "))
+	}
+	return &Logger{sink: s}, nil
+}
+
+// Start is New from the values of the -log-level and -log-file flags.
+func Start(level, logFile string) (*Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return New(l, logFile)
+}
+
+// Close closes the log file, if any.
+func (l *Logger) Close() error {
+	if l == nil || l.sink.file == nil {
+		return nil
+	}
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	return l.sink.file.Close()
+}
+
+// Worker returns a Logger whose lines are tagged as worker id's.
+func (l *Logger) Worker(id int) *Logger {
+	return l.Tag(fmt.Sprintf("w%d", id))
+}
+
+// Tag returns a Logger whose lines are tagged with tag, e.g. the engine of
+// a portfolio run.
+func (l *Logger) Tag(tag string) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{sink: l.sink, tag: tag}
+}
+
+// Enabled reports whether lines at level are printed, for callers that
+// would do work just to log.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level <= l.sink.level
+}
+
+// Printf logs a line at Normal level.
+func (l *Logger) Printf(format string, args ...any) {
+	l.log(Normal, format, args)
+}
+
+// Verbosef logs a line at Verbose level.
+func (l *Logger) Verbosef(format string, args ...any) {
+	l.log(Verbose, format, args)
+}
+
+// Debugf logs a line at Debug level.
+func (l *Logger) Debugf(format string, args ...any) {
+	l.log(Debug, format, args)
+}
+
+// log writes the message, each of its lines tagged (a message may span
+// several lines, e.g. a table of counters).
+func (l *Logger) log(level Level, format string, args []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now()
+	prefix := fmt.Sprintf("[%9.3fs", now.Sub(l.sink.start).Seconds())
+	if l.tag != "" {
+		prefix += " " + l.tag
+	}
+	prefix += "] "
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(fmt.Sprintf(format, args...), "\n"), "\n") {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	text := b.String()
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	io.WriteString(os.Stdout, text)
+	if l.sink.file != nil {
+		stamp := now.Format("2006-01-02 15:04:05.000 ")
+		io.WriteString(l.sink.file, stamp+strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\n"+stamp)+"\n")
+	}
+}
@@ -0,0 +1,213 @@
+// Package lowerbound proves lower bounds on the number k of arrangements
+// needed to cover every pair of n items when each arrangement lays the
+// items out on the same host graph, before a search is spent on a k that
+// can't work.
+//
+// Trivial is ceil(pairs/edges). MaxDegree uses that an item meets at most
+// Δ partners per arrangement. Config is the configuration LP: every item
+// sits on k slots, one per arrangement, whose degrees must add up to at
+// least its n-1 partners; such a multiset of k slot degrees is a pattern,
+// and every arrangement fills each slot once, so the number of items z_p
+// using each pattern p must satisfy
+//
+//	sum_p z_p = n,  sum_p z_p * (slots of degree d in p) = k * (slots of degree d in the host)
+//
+// for every degree d. If this has no fractional solution z >= 0, no k
+// arrangements exist. It implies the other two bounds, and is stronger when
+// the host's degrees can't be dealt out so that every item gets enough.
+package lowerbound
+
+import (
+	"math/big"
+	"math/bits"
+	"sort"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// Bounds are the lower bounds on k for one host graph.
+type Bounds struct {
+	N, Edges  int
+	Trivial   int // ceil(pairs/edges)
+	MaxDegree int // ceil((n-1)/Δ)
+	Config    int // smallest k whose configuration LP is feasible; 0 if none up to MaxK
+}
+
+// MaxK caps the k tried by Compute: beyond it the patterns get too many.
+const MaxK = 16
+
+// Compute returns the bounds for host graph g; a graph without edges gets
+// zeros.
+func Compute(g invariants.Graph) Bounds {
+	degrees := make([]int, g.N)
+	edges := 0
+	for v := range degrees {
+		degrees[v] = bits.OnesCount64(g.Adj[v])
+		edges += degrees[v]
+	}
+	edges /= 2
+	b := Bounds{N: g.N, Edges: edges}
+	if edges == 0 {
+		return b
+	}
+	b.Trivial = Trivial(g.N, edges)
+	b.MaxDegree = MaxDegree(g.N, degrees)
+	for k := max(b.Trivial, b.MaxDegree); k <= MaxK; k++ {
+		if ConfigFeasible(g.N, k, degrees) {
+			b.Config = k
+			break
+		}
+	}
+	return b
+}
+
+// Trivial returns ceil(pairs/edges) for n items and a host with edges
+// edges.
+func Trivial(n, edges int) int {
+	pairs := n * (n - 1) / 2
+	return (pairs + edges - 1) / edges
+}
+
+// MaxDegree returns ceil((n-1)/Δ) for a host with the given degrees.
+func MaxDegree(n int, degrees []int) int {
+	top := 0
+	for _, d := range degrees {
+		top = max(top, d)
+	}
+	if top == 0 {
+		return 0
+	}
+	return (n - 1 + top - 1) / top
+}
+
+// ConfigFeasible reports whether the configuration LP for k arrangements on
+// a host with the given slot degrees has a solution.
+func ConfigFeasible(n, k int, degrees []int) bool {
+	count := make(map[int]int)
+	for _, d := range degrees {
+		count[d]++
+	}
+	classes := make([]int, 0, len(count))
+	for d := range count {
+		classes = append(classes, d)
+	}
+	sort.Ints(classes)
+
+	// Columns: patterns as counts per class. Rows: one per class, then the
+	// number of items.
+	rows := len(classes) + 1
+	var columns [][]int
+	pattern := make([]int, len(classes))
+	var gen func(class, left, sum int)
+	gen = func(class, left, sum int) {
+		if class == len(classes)-1 {
+			pattern[class] = left
+			if sum+left*classes[class] >= n-1 {
+				col := make([]int, rows)
+				copy(col, pattern)
+				col[rows-1] = 1
+				columns = append(columns, col)
+			}
+			return
+		}
+		for c := 0; c <= left; c++ {
+			pattern[class] = c
+			gen(class+1, left-c, sum+c*classes[class])
+		}
+	}
+	gen(0, k, 0)
+	if len(columns) == 0 {
+		return false
+	}
+
+	rhs := make([]int, rows)
+	for i, d := range classes {
+		rhs[i] = k * count[d]
+	}
+	rhs[rows-1] = n
+	return feasible(columns, rhs)
+}
+
+// feasible reports whether sum_j x_j * columns[j] = rhs has a solution
+// x >= 0 (rhs >= 0), by phase one of the simplex method: minimize the sum
+// of one artificial variable per row, in exact arithmetic with Bland's rule
+// so it can't cycle.
+func feasible(columns [][]int, rhs []int) bool {
+	m, p := len(rhs), len(columns)
+	width := p + m // structural columns, then the artificials
+	t := make([][]*big.Rat, m)
+	for i := range t {
+		t[i] = make([]*big.Rat, width+1)
+		for j, col := range columns {
+			t[i][j] = big.NewRat(int64(col[i]), 1)
+		}
+		for j := 0; j < m; j++ {
+			t[i][p+j] = new(big.Rat)
+		}
+		t[i][p+i].SetInt64(1)
+		t[i][width] = big.NewRat(int64(rhs[i]), 1)
+	}
+	basis := make([]int, m)
+	for i := range basis {
+		basis[i] = p + i
+	}
+	// Reduced costs, and minus the objective in the last entry
+	cost := make([]*big.Rat, width+1)
+	for j := range cost {
+		cost[j] = new(big.Rat)
+		if j < p || j == width {
+			for i := range t {
+				cost[j].Sub(cost[j], t[i][j])
+			}
+		}
+	}
+
+	ratio, best := new(big.Rat), new(big.Rat)
+	tmp := new(big.Rat)
+	for {
+		enter := -1
+		for j := 0; j < width; j++ {
+			if cost[j].Sign() < 0 {
+				enter = j
+				break
+			}
+		}
+		if enter < 0 {
+			break
+		}
+		leave := -1
+		for i := range t {
+			if t[i][enter].Sign() <= 0 {
+				continue
+			}
+			ratio.Quo(t[i][width], t[i][enter])
+			if c := ratio.Cmp(best); leave < 0 || c < 0 || c == 0 && basis[i] < basis[leave] {
+				leave = i
+				best.Set(ratio)
+			}
+		}
+		if leave < 0 {
+			break // unbounded; can't happen in phase one
+		}
+
+		pivot := new(big.Rat).Set(t[leave][enter])
+		for j := range t[leave] {
+			t[leave][j].Quo(t[leave][j], pivot)
+		}
+		for i := range t {
+			if i == leave || t[i][enter].Sign() == 0 {
+				continue
+			}
+			f := new(big.Rat).Set(t[i][enter])
+			for j := range t[i] {
+				t[i][j].Sub(t[i][j], tmp.Mul(f, t[leave][j]))
+			}
+		}
+		f := new(big.Rat).Set(cost[enter])
+		for j := range cost {
+			cost[j].Sub(cost[j], tmp.Mul(f, t[leave][j]))
+		}
+		basis[leave] = enter
+	}
+	return cost[width].Sign() == 0
+}
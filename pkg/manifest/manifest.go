@@ -0,0 +1,249 @@
+// Package manifest records how an output file was produced, so a .g6 or
+// solution file found months later can be traced back to its run: next to
+// each output path it writes path.manifest.json with the tool, its build
+// (git commit, whether the tree was modified, Go version), the command
+// line and the value of every flag, the inputs with their sizes and
+// SHA-256 hashes, the host, and the start and wall time of the run up to
+// the moment the file was written.
+//
+// A tool calls Start after parsing its flags, Input for each file it
+// reads and Wrote after writing each output (again after rewriting it, so
+// the manifest follows the file). A directory input is recorded file by
+// file; a directory output gets its manifest next to it as
+// dir.manifest.json.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Suffix is appended to an output path to name its manifest.
+const Suffix = ".manifest.json"
+
+// Build identifies the binary that ran.
+type Build struct {
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified"` // the tree had uncommitted changes
+	Time      string `json:"commit_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// File is an input file.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"` // empty for stdin
+}
+
+// Manifest is what one sidecar file holds.
+type Manifest struct {
+	Tool        string            `json:"tool"`
+	Output      string            `json:"output"`
+	Build       Build             `json:"build"`
+	Args        []string          `json:"args"`
+	Flags       map[string]string `json:"flags"`
+	Inputs      []File            `json:"inputs"`
+	Outputs     []string          `json:"outputs"` // every output of the run so far
+	Host        string            `json:"host"`
+	CPUs        int               `json:"cpus"`
+	Dir         string            `json:"dir"`
+	Start       time.Time         `json:"start"`
+	Written     time.Time         `json:"written"`
+	WallSeconds float64           `json:"wall_seconds"`
+}
+
+// Run collects one run's provenance. A nil *Run is valid and records
+// nothing, and a Run is safe for concurrent use.
+type Run struct {
+	mu      sync.Mutex
+	m       Manifest
+	hashed  map[string]bool
+	outputs map[string]bool
+}
+
+// Start begins recording the run of tool, whose flags are fs (nil for the
+// command line's, which must be parsed).
+func Start(tool string, fs *flag.FlagSet) *Run {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	flags := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	host, _ := os.Hostname()
+	dir, _ := os.Getwd()
+	return &Run{
+		m: Manifest{
+			Tool:    tool,
+			Build:   build(),
+			Args:    os.Args,
+			Flags:   flags,
+			Inputs:  []File{},
+			Outputs: []string{},
+			Host:    host,
+			CPUs:    runtime.NumCPU(),
+			Dir:     dir,
+			Start:   time.Now(),
+		},
+		hashed:  map[string]bool{},
+		outputs: map[string]bool{},
+	}
+}
+
+// build reads the commit from the binary's build info, which "go build"
+// of a package stamps, or else asks git in the working directory (for
+// "go run" of single files).
+func build() Build {
+	b := Build{GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Commit = s.Value
+			case "vcs.time":
+				b.Time = s.Value
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if b.Commit == "" {
+		if out, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+			b.Commit = strings.TrimSpace(string(out))
+			status, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output()
+			b.Modified = err == nil && len(status) > 0
+		}
+	}
+	return b
+}
+
+// Input records the files at paths: "-" as stdin, a directory as the
+// regular files in it (not their manifests). Each file is hashed once;
+// empty paths, from flags that weren't given, are skipped.
+func (r *Run) Input(paths ...string) error {
+	if r == nil {
+		return nil
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if path == "-" {
+			r.add(File{Path: "-"})
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+		if !info.IsDir() {
+			if err := r.hash(path); err != nil {
+				return err
+			}
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && !strings.HasSuffix(e.Name(), Suffix) {
+				if err := r.hash(filepath.Join(path, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Run) hash(path string) error {
+	r.mu.Lock()
+	done := r.hashed[path]
+	r.hashed[path] = true
+	r.mu.Unlock()
+	if done {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("manifest: %s: %w", path, err)
+	}
+	r.add(File{Path: path, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+func (r *Run) add(f File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m.Inputs = append(r.m.Inputs, f)
+	sort.Slice(r.m.Inputs, func(i, j int) bool { return r.m.Inputs[i].Path < r.m.Inputs[j].Path })
+}
+
+// Wrote writes the manifest of each output in paths, which the run has
+// just written (a file or a directory); empty paths are skipped.
+func (r *Run) Wrote(paths ...string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var written []string
+	for _, path := range paths {
+		if path != "" {
+			written = append(written, path)
+		}
+	}
+	for _, path := range written {
+		if !r.outputs[path] {
+			r.outputs[path] = true
+			r.m.Outputs = append(r.m.Outputs, path)
+		}
+	}
+	for _, path := range written {
+		m := r.m
+		m.Output = path
+		m.Written = time.Now()
+		m.WallSeconds = m.Written.Sub(m.Start).Seconds()
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+		if err := os.WriteFile(filepath.Clean(path)+Suffix, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// Read reads the manifest written for the output at path.
+func Read(path string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(filepath.Clean(path) + Suffix)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
@@ -0,0 +1,91 @@
+// Package metrics lets a long-running search be watched remotely: tools
+// register counters and gauges, and Serve publishes them over HTTP, as
+// JSON at /debug/vars (expvar) and in the Prometheus text format at
+// /metrics.
+//
+// Registering is cheap and independent of Serve, so tools update their
+// metrics unconditionally and only serve them when asked (-metrics :9090).
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type metric struct {
+	name, help, kind string
+	value            func() float64
+}
+
+var (
+	mu       sync.Mutex
+	registry []metric
+	started  = time.Now()
+)
+
+func register(name, help, kind string, value func() float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry = append(registry, metric{name, help, kind, value})
+}
+
+// NewCounter registers a counter that only goes up, such as nodes explored.
+func NewCounter(name, help string) *expvar.Int {
+	v := expvar.NewInt(name)
+	register(name, help, "counter", func() float64 { return float64(v.Value()) })
+	return v
+}
+
+// NewGauge registers a value that goes up and down, such as workers busy.
+func NewGauge(name, help string) *expvar.Int {
+	v := expvar.NewInt(name)
+	register(name, help, "gauge", func() float64 { return float64(v.Value()) })
+	return v
+}
+
+// CounterFunc registers a counter read from f, for counts a tool already
+// keeps elsewhere.
+func CounterFunc(name, help string, f func() float64) {
+	expvar.Publish(name, expvar.Func(func() any { return f() }))
+	register(name, help, "counter", f)
+}
+
+// GaugeFunc registers a gauge read from f, e.g. a rate computed on demand.
+func GaugeFunc(name, help string, f func() float64) {
+	expvar.Publish(name, expvar.Func(func() any { return f() }))
+	register(name, help, "gauge", f)
+}
+
+// Since returns the seconds since the program started, for rates.
+func Since() float64 {
+	return time.Since(started).Seconds()
+}
+
+// Serve listens on addr (e.g. ":9090") and serves the metrics in the
+// background. It returns once the port is bound, so a bad address is
+// reported to the caller instead of lost in a goroutine.
+func Serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", writePrometheus)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func writePrometheus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mu.Lock()
+	defer mu.Unlock()
+	for _, m := range registry {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.kind, m.name, m.value())
+	}
+	fmt.Fprintf(w, "# HELP uptime_seconds Seconds since the program started.\n# TYPE uptime_seconds gauge\nuptime_seconds %g\n", Since())
+}
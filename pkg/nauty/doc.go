@@ -0,0 +1,33 @@
+// Package nauty canonicalizes graphs with Brendan McKay's nauty library via
+// cgo. The cgo code is only built with the nauty build tag (go build -tags
+// nauty), since it needs libnauty and its headers; without the tag
+// Available is false and the tools fall back to their own canonical forms.
+//
+// nauty's canonical form is a different labeling than the minimum bitmask
+// over all relabelings that penny_enum's brute-force canonicalize computes,
+// but it classifies graphs the same way: equal forms exactly for isomorphic
+// graphs. It is the full relabeled graph, not a hash, so distinct classes
+// never collide.
+package nauty
+
+import "fmt"
+
+// MaxN is the largest vertex count whose edges fit in a 64-bit mask.
+const MaxN = 11
+
+// CheckBackend reports an error unless backend names a canonicalization
+// backend usable in this binary: "brute" (relabeling by every permutation),
+// "wl" (only the relabelings that keep color-refinement classes together,
+// pkg/wlcanon) or "nauty" (needs -tags nauty).
+func CheckBackend(backend string) error {
+	switch backend {
+	case "brute", "wl":
+		return nil
+	case "nauty":
+		if !Available {
+			return fmt.Errorf("canon backend nauty needs a binary built with -tags nauty (and libnauty installed)")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown canon backend %q (want brute, wl or nauty)", backend)
+}
@@ -0,0 +1,110 @@
+//go:build nauty
+
+package nauty
+
+/*
+#cgo CFLAGS: -I/opt/homebrew/include
+#cgo LDFLAGS: -L/opt/homebrew/lib -lnauty
+#cgo linux CFLAGS: -I/usr/include/nauty
+
+#include <nauty.h>
+#include <naututil.h>
+
+#define MAXN_ROWS 64
+
+// canonical_rows runs nauty on the n-vertex graph whose row i has bit j set
+// for each neighbor j and writes its canonical form to out in the same
+// layout. One setword per row (m = 1), so n must fit in a word; returns -1
+// otherwise.
+static int canonical_rows(const unsigned long long *in, unsigned long long *out, int n) {
+	graph g[MAXN_ROWS], cg[MAXN_ROWS];
+	int lab[MAXN_ROWS], ptn[MAXN_ROWS], orbits[MAXN_ROWS];
+	DEFAULTOPTIONS_GRAPH(options);
+	statsblk stats;
+	int i, j;
+
+	if (n > WORDSIZE || n > MAXN_ROWS) {
+		return -1;
+	}
+	options.getcanon = TRUE;
+	EMPTYGRAPH(g, 1, n);
+	for (i = 0; i < n; i++) {
+		for (j = 0; j < n; j++) {
+			if ((in[i] >> j) & 1) {
+				ADDELEMENT(GRAPHROW(g, i, 1), j);
+			}
+		}
+	}
+	densenauty(g, lab, ptn, orbits, &options, &stats, 1, n, cg);
+	for (i = 0; i < n; i++) {
+		out[i] = 0;
+		for (j = 0; j < n; j++) {
+			if (ISELEMENT(GRAPHROW(cg, i, 1), j)) {
+				out[i] |= 1ULL << j;
+			}
+		}
+	}
+	return 0;
+}
+
+static void check_version(void) {
+	nauty_check(WORDSIZE, 1, 1, NAUTYVERSIONID);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// Available reports whether this binary was built with the nauty backend.
+const Available = true
+
+// mu serializes calls: a stock libnauty keeps its workspace in static
+// variables, so concurrent densenauty calls would corrupt each other.
+var (
+	mu   sync.Mutex
+	once sync.Once
+)
+
+// Canonical returns nauty's canonical form of the graph on n vertices
+// with edge bitmask mask, as a bitmask in the same layout. Two graphs are
+// isomorphic exactly when their canonical forms are equal.
+func Canonical(n int, mask uint64) uint64 {
+	if n < 1 || n > MaxN {
+		panic(fmt.Sprintf("nauty: n=%d out of range 1..%d", n, MaxN))
+	}
+	cg, err := CanonicalGraph(invariants.FromMask(n, mask))
+	if err != nil {
+		panic(err)
+	}
+	return cg.Mask()
+}
+
+// CanonicalGraph returns nauty's canonical form of g, for any n up to the
+// library's word size (64 on most builds, 32 on some).
+func CanonicalGraph(g invariants.Graph) (invariants.Graph, error) {
+	if g.N == 0 {
+		return g, nil
+	}
+	in := make([]C.ulonglong, g.N)
+	for i, row := range g.Adj {
+		in[i] = C.ulonglong(row)
+	}
+	out := make([]C.ulonglong, g.N)
+	mu.Lock()
+	once.Do(func() { C.check_version() })
+	rc := C.canonical_rows(&in[0], &out[0], C.int(g.N))
+	mu.Unlock()
+	if rc != 0 {
+		return invariants.Graph{}, fmt.Errorf("nauty: n=%d exceeds the library's word size", g.N)
+	}
+	cg := invariants.New(g.N)
+	for i := range out {
+		cg.Adj[i] = uint64(out[i])
+	}
+	return cg, nil
+}
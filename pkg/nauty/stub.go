@@ -0,0 +1,23 @@
+//go:build !nauty
+
+package nauty
+
+import (
+	"errors"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// Available reports whether this binary was built with the nauty backend.
+const Available = false
+
+// Canonical is only implemented with the nauty build tag; check Available
+// (or CheckBackend) first.
+func Canonical(n int, mask uint64) uint64 {
+	panic("nauty: built without the nauty tag")
+}
+
+// CanonicalGraph is only implemented with the nauty build tag.
+func CanonicalGraph(g invariants.Graph) (invariants.Graph, error) {
+	return invariants.Graph{}, errors.New("nauty: built without the nauty tag")
+}
@@ -0,0 +1,123 @@
+// Package oeis formats per-size counts as comma-separated sequences and
+// compares them with a small bundled table of known OEIS sequences, so an
+// enumeration run can flag a count that disagrees with the literature.
+// Only sequences whose terms are established are bundled; a count outside
+// a sequence's bundled terms is reported as unchecked, not as a match.
+package oeis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sequence is a bundled OEIS entry; Terms[i] is a(Offset+i).
+type Sequence struct {
+	ID     string
+	Name   string
+	Offset int
+	Terms  []int64
+}
+
+// Term returns a(k) and whether the table has it.
+func (s Sequence) Term(k int) (int64, bool) {
+	i := k - s.Offset
+	if i < 0 || i >= len(s.Terms) {
+		return 0, false
+	}
+	return s.Terms[i], true
+}
+
+var table = map[string]Sequence{
+	"A000577": {
+		ID:     "A000577",
+		Name:   "free polyiamonds with n cells",
+		Offset: 1,
+		Terms: []int64{1, 1, 1, 3, 4, 12, 24, 66, 160, 448, 1186, 3334, 9235, 26166,
+			73983, 211297, 604107, 1736328, 5000593, 14448984},
+	},
+	"A047932": harborth(),
+}
+
+// harborth lists the maximum number of edges of a penny graph on n
+// vertices, floor(3n - sqrt(12n - 3)) (Harborth 1974), for n = 1..200.
+func harborth() Sequence {
+	s := Sequence{ID: "A047932", Name: "maximum edges of a penny graph with n vertices", Offset: 1}
+	for n := int64(1); n <= 200; n++ {
+		// floor(3n - sqrt(x)) = 3n - ceil(sqrt(x)), with an exact ceil
+		x := 12*n - 3
+		r := int64(math.Sqrt(float64(x)))
+		for r*r > x {
+			r--
+		}
+		for r*r < x {
+			r++
+		}
+		s.Terms = append(s.Terms, 3*n-r)
+	}
+	return s
+}
+
+// Lookup returns the bundled sequence with the given A-number.
+func Lookup(id string) (Sequence, bool) {
+	s, ok := table[strings.ToUpper(id)]
+	return s, ok
+}
+
+// IDs returns the A-numbers in the table.
+func IDs() []string {
+	ids := make([]string, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Format returns the values as "v0, v1, ...".
+func Format(values []int64) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.FormatInt(v, 10)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Report compares values, where values[i] is the count for size start+i,
+// with sequence id and returns one line per mismatch plus a summary line.
+// ok is false if any term disagrees or id isn't bundled.
+func Report(id string, start int, values []int64) (lines []string, ok bool) {
+	s, found := Lookup(id)
+	if !found {
+		return []string{fmt.Sprintf("%s: not in the bundled table (have %s)", id, strings.Join(IDs(), ", "))}, false
+	}
+	checked, unchecked := 0, 0
+	ok = true
+	for i, v := range values {
+		want, have := s.Term(start + i)
+		if !have {
+			unchecked++
+			continue
+		}
+		checked++
+		if v != want {
+			ok = false
+			lines = append(lines, fmt.Sprintf("MISMATCH %s(%d): got %d, expected %d", s.ID, start+i, v, want))
+		}
+	}
+	status := "all match"
+	if !ok {
+		status = fmt.Sprintf("%d mismatches", len(lines))
+	}
+	terms := "terms"
+	if checked == 1 {
+		terms = "term"
+	}
+	summary := fmt.Sprintf("%s (%s): %d %s checked, %s", s.ID, s.Name, checked, terms, status)
+	if unchecked > 0 {
+		summary += fmt.Sprintf(", %d beyond the bundled terms", unchecked)
+	}
+	return append(lines, summary), ok
+}
@@ -0,0 +1,94 @@
+// Package pairs numbers the unordered pairs of n items and keeps sets of
+// them as bitmaps.
+//
+// Pairs are numbered in lexicographic order: {a, b} with a < b has index
+// a*n - a*(a+1)/2 + b-a-1, so the n-1 pairs of item 0 come first, then the
+// n-2 pairs of item 1 with a larger item, and so on up to Count(n)-1.
+// Searches that look an index up for every edge they place keep a
+// precomputed table (cover.Table) instead of the arithmetic.
+package pairs
+
+import "math/bits"
+
+// Count returns the number of pairs of n items, n(n-1)/2.
+func Count(n int) int {
+	return n * (n - 1) / 2
+}
+
+// PairIndex returns the index of the pair {a, b} of n items, a != b, given
+// in either order.
+func PairIndex(n, a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	return a*n - a*(a+1)/2 + b - a - 1
+}
+
+// PairFromIndex returns the pair (a, b), a < b, of n items with index i.
+func PairFromIndex(n, i int) (int, int) {
+	a := 0
+	for row := n - 1; i >= row; row-- {
+		i -= row
+		a++
+	}
+	return a, a + 1 + i
+}
+
+// Each calls f with every pair (a, b), a < b, of n items and its index, in
+// index order.
+func Each(n int, f func(i, a, b int)) {
+	i := 0
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			f(i, a, b)
+			i++
+		}
+	}
+}
+
+// Set is a set of pair indices as a bitmap, one bit per pair in 64-bit
+// words.
+type Set []uint64
+
+// NewSet returns an empty set for count pairs.
+func NewSet(count int) Set {
+	return make(Set, (count+63)/64)
+}
+
+// Has reports whether pair i is in the set.
+func (s Set) Has(i int) bool {
+	return s[i>>6]&(1<<(i&63)) != 0
+}
+
+// Add puts pair i in the set.
+func (s Set) Add(i int) {
+	s[i>>6] |= 1 << (i & 63)
+}
+
+// Remove takes pair i out of the set.
+func (s Set) Remove(i int) {
+	s[i>>6] &^= 1 << (i & 63)
+}
+
+// Count returns the number of pairs in the set.
+func (s Set) Count() int {
+	n := 0
+	for _, w := range s {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Clone returns a copy of the set.
+func (s Set) Clone() Set {
+	return append(Set(nil), s...)
+}
+
+// Each calls f with every index in the set, in increasing order.
+func (s Set) Each(f func(i int)) {
+	for wi, w := range s {
+		for ; w != 0; w &= w - 1 {
+			f(wi*64 + bits.TrailingZeros64(w))
+		}
+	}
+}
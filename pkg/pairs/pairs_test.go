@@ -0,0 +1,115 @@
+package pairs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPairIndex(t *testing.T) {
+	for n := 2; n <= 30; n++ {
+		i := 0
+		for a := 0; a < n; a++ {
+			for b := a + 1; b < n; b++ {
+				if got := PairIndex(n, a, b); got != i {
+					t.Fatalf("n=%d: PairIndex(%d, %d) = %d, want %d", n, a, b, got, i)
+				}
+				if got := PairIndex(n, b, a); got != i {
+					t.Fatalf("n=%d: PairIndex(%d, %d) = %d, want %d", n, b, a, got, i)
+				}
+				if ga, gb := PairFromIndex(n, i); ga != a || gb != b {
+					t.Fatalf("n=%d: PairFromIndex(%d) = (%d, %d), want (%d, %d)", n, i, ga, gb, a, b)
+				}
+				i++
+			}
+		}
+		if i != Count(n) {
+			t.Fatalf("n=%d: %d pairs, Count %d", n, i, Count(n))
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	for n := 0; n <= 30; n++ {
+		next := 0
+		prev := [2]int{-1, -1}
+		Each(n, func(i, a, b int) {
+			// Index order is lexicographic order of (a, b)
+			if i != next || a >= b || b >= n || [2]int{a, b} == prev ||
+				a < prev[0] || a == prev[0] && b <= prev[1] || PairIndex(n, a, b) != i {
+				t.Fatalf("n=%d: Each gave %d (%d, %d) after %v, want index %d", n, i, a, b, prev, next)
+			}
+			prev = [2]int{a, b}
+			next++
+		})
+		if next != Count(n) {
+			t.Errorf("n=%d: Each visited %d pairs, want %d", n, next, Count(n))
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	// Count(n) for these is 66 (a word and two bits), 120 (eight short of
+	// two words) and 190 (a partial third word)
+	for _, n := range []int{12, 16, 20} {
+		count := Count(n)
+		s := NewSet(count)
+		if len(s) != (count+63)/64 {
+			t.Fatalf("n=%d: %d words for %d pairs", n, len(s), count)
+		}
+		// Every third pair, plus both sides of each word boundary
+		want := make(map[int]bool)
+		for i := 0; i < count; i += 3 {
+			want[i] = true
+		}
+		for w := 64; w < count; w += 64 {
+			want[w-1], want[w] = true, true
+		}
+		want[count-1] = true
+		for i := range want {
+			s.Add(i)
+			s.Add(i) // adding twice changes nothing
+		}
+		check := func(s Set, want map[int]bool) {
+			t.Helper()
+			var got []int
+			s.Each(func(i int) { got = append(got, i) })
+			var wantList []int
+			for i := 0; i < count; i++ {
+				if s.Has(i) != want[i] {
+					t.Fatalf("n=%d: Has(%d) = %v", n, i, s.Has(i))
+				}
+				if want[i] {
+					wantList = append(wantList, i)
+				}
+			}
+			if !slices.Equal(got, wantList) {
+				t.Fatalf("n=%d: Each gave %v, want %v", n, got, wantList)
+			}
+			if s.Count() != len(wantList) {
+				t.Fatalf("n=%d: Count %d, want %d", n, s.Count(), len(wantList))
+			}
+		}
+		check(s, want)
+
+		c := s.Clone()
+		for i := range want {
+			if i%2 == 0 {
+				c.Remove(i)
+				c.Remove(i)
+			}
+		}
+		check(s, want) // the original is untouched
+		odd := make(map[int]bool)
+		for i := range want {
+			if i%2 == 1 {
+				odd[i] = true
+			}
+		}
+		check(c, odd)
+
+		for i := range odd {
+			c.Remove(i)
+		}
+		check(c, nil)
+	}
+}
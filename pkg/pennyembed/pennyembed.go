@@ -0,0 +1,265 @@
+// Package pennyembed looks for a drawing of a graph with every edge of
+// length 1, by gradient descent from random starts, in one of the models
+// verify_penny checks: penny graphs (non-adjacent vertices farther than 1
+// apart), matchstick graphs (no crossings) and unit-distance graphs. A
+// drawing found is a proof up to its tolerance (edges within 0.001 of 1),
+// so a graph whose drawings force two non-adjacent coins to touch can pass;
+// a graph none of the starts converges for is taken not to embed. hexclink
+// selftest lists the known errors both ways. The starts are seeded, so the
+// answer for a graph never changes between runs.
+package pennyembed
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Model is an embedding model: every edge has length 1, and the models
+// differ in what non-adjacent pairs and the drawing must satisfy.
+type Model struct {
+	Name string
+	// MinDist is the distance every non-adjacent pair must exceed: 1 for
+	// penny graphs, otherwise just enough to keep the vertices distinct.
+	MinDist float64
+	// NoCrossings requires the unit segments to form a plane drawing.
+	NoCrossings bool
+}
+
+// Models are the models by name.
+var Models = map[string]Model{
+	"penny":      {Name: "penny", MinDist: 1.0},
+	"matchstick": {Name: "matchstick", MinDist: 0.01, NoCrossings: true},
+	"unit":       {Name: "unit", MinDist: 0.01},
+}
+
+// orient is twice the signed area of the triangle p, q, r.
+func orient(p, q, r [2]float64) float64 {
+	return (q[0]-p[0])*(r[1]-p[1]) - (q[1]-p[1])*(r[0]-p[0])
+}
+
+// segmentDist returns the distance from p to the segment a-b.
+func segmentDist(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy + 1e-20)
+	t = math.Max(0, math.Min(1, t))
+	ex, ey := p[0]-a[0]-t*dx, p[1]-a[1]-t*dy
+	return math.Sqrt(ex*ex + ey*ey)
+}
+
+// Embed reports whether the search finds an embedding in model m of the
+// graph on n vertices with the given edges (i < j), and returns its vertex
+// positions. A penny embedding is also a matchstick and unit-distance
+// embedding (two crossing unit segments, or a vertex on one, would put a
+// non-adjacent pair closer than 1), so the weaker models also try the
+// penny search, whose strong repulsion often converges where theirs gets
+// stuck.
+func Embed(n int, edges [][2]int, m Model) ([][2]float64, bool) {
+	if pos, ok := search(n, edges, m, m); ok || m.Name == "penny" {
+		return pos, ok
+	}
+	return search(n, edges, Models["penny"], m)
+}
+
+// Numerical embedding check using gradient descent
+// Returns the positions if graph can be embedded with edges=1 and the
+// constraints of model c (penny: non-edges>1), checking the result against
+// model check
+func search(n int, edges [][2]int, c, check Model) ([][2]float64, bool) {
+	if len(edges) == 0 {
+		return nil, false
+	}
+
+	// Non-edges
+	adjacent := make([][]bool, n)
+	for i := range adjacent {
+		adjacent[i] = make([]bool, n)
+	}
+	for _, e := range edges {
+		adjacent[e[0]][e[1]], adjacent[e[1]][e[0]] = true, true
+	}
+	var nonEdges [][2]int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if !adjacent[i][j] {
+				nonEdges = append(nonEdges, [2]int{i, j})
+			}
+		}
+	}
+
+	// Edge pairs that must not cross: those without a common endpoint
+	var edgePairsToSeparate [][2][2]int
+	if c.NoCrossings || check.NoCrossings {
+		for a := 0; a < len(edges); a++ {
+			for b := a + 1; b < len(edges); b++ {
+				e, f := edges[a], edges[b]
+				if e[0] != f[0] && e[0] != f[1] && e[1] != f[0] && e[1] != f[1] {
+					edgePairsToSeparate = append(edgePairsToSeparate, [2][2]int{e, f})
+				}
+			}
+		}
+	}
+
+	// Try multiple random starts
+	for attempt := 0; attempt < 20; attempt++ {
+		pos := make([][2]float64, n)
+		rng := rand.New(rand.NewSource(int64(42 + attempt)))
+
+		// Initialize with spread-out random positions
+		for i := 0; i < n; i++ {
+			pos[i] = [2]float64{rng.Float64() * 2, rng.Float64() * 2}
+		}
+
+		// Gradient descent
+		for iter := 0; iter < 3000; iter++ {
+			grad := make([][2]float64, n)
+			cost := 0.0
+
+			// Edge constraints: distance should be 1
+			for _, e := range edges {
+				i, j := e[0], e[1]
+				dx := pos[j][0] - pos[i][0]
+				dy := pos[j][1] - pos[i][1]
+				dist := math.Sqrt(dx*dx + dy*dy)
+				if dist < 1e-10 {
+					dist = 1e-10
+				}
+				err := dist - 1.0
+				cost += err * err
+
+				factor := 2 * err / dist
+				grad[i][0] -= factor * dx
+				grad[i][1] -= factor * dy
+				grad[j][0] += factor * dx
+				grad[j][1] += factor * dy
+			}
+
+			// Non-edge constraints: distance should be > MinDist
+			for _, e := range nonEdges {
+				i, j := e[0], e[1]
+				dx := pos[j][0] - pos[i][0]
+				dy := pos[j][1] - pos[i][1]
+				dist := math.Sqrt(dx*dx + dy*dy)
+				if dist < 1e-10 {
+					dist = 1e-10
+				}
+				if dist < c.MinDist {
+					err := c.MinDist - dist + 0.1
+					cost += err * err
+
+					factor := -2 * err / dist
+					grad[i][0] -= factor * dx
+					grad[i][1] -= factor * dy
+					grad[j][0] += factor * dx
+					grad[j][1] += factor * dy
+				}
+			}
+
+			// Crossing constraints: of two crossing segments, push the
+			// endpoint nearest the other segment's line across it
+			if c.NoCrossings {
+				for _, pair := range edgePairsToSeparate {
+					e, f := pair[0], pair[1]
+					a, b, p, q := pos[e[0]], pos[e[1]], pos[f[0]], pos[f[1]]
+					if orient(a, b, p)*orient(a, b, q) >= 0 || orient(p, q, a)*orient(p, q, b) >= 0 {
+						continue
+					}
+					best, bestDist := -1, math.Inf(1)
+					var line [2]int
+					for k, cand := range [4]struct {
+						v    int
+						line [2]int
+					}{{e[0], f}, {e[1], f}, {f[0], e}, {f[1], e}} {
+						p, q, r := pos[cand.line[0]], pos[cand.line[1]], pos[cand.v]
+						length := math.Hypot(q[0]-p[0], q[1]-p[1]) + 1e-10
+						if dist := math.Abs(orient(p, q, r)) / length; dist < bestDist {
+							best, bestDist, line = k, dist, cand.line
+						}
+					}
+					v := [4]int{e[0], e[1], f[0], f[1]}[best]
+					p, q, r := pos[line[0]], pos[line[1]], pos[v]
+					length := math.Hypot(q[0]-p[0], q[1]-p[1]) + 1e-10
+					// Unit normal pointing to r's side; r has to go the other way
+					nx, ny := -(q[1]-p[1])/length, (q[0]-p[0])/length
+					if orient(p, q, r) < 0 {
+						nx, ny = -nx, -ny
+					}
+					err := bestDist + 0.05
+					cost += err * err
+					grad[v][0] += 2 * err * nx
+					grad[v][1] += 2 * err * ny
+				}
+			}
+
+			// Update positions
+			lr := 0.1
+			if iter > 1000 {
+				lr = 0.01
+			}
+			if iter > 2000 {
+				lr = 0.001
+			}
+			for i := 0; i < n; i++ {
+				pos[i][0] -= lr * grad[i][0]
+				pos[i][1] -= lr * grad[i][1]
+			}
+
+			if cost < 1e-10 {
+				break
+			}
+		}
+
+		// Verify solution
+		valid := true
+		for _, e := range edges {
+			i, j := e[0], e[1]
+			dx := pos[j][0] - pos[i][0]
+			dy := pos[j][1] - pos[i][1]
+			dist := math.Sqrt(dx*dx + dy*dy)
+			if math.Abs(dist-1.0) > 0.001 {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			for _, e := range nonEdges {
+				i, j := e[0], e[1]
+				dx := pos[j][0] - pos[i][0]
+				dy := pos[j][1] - pos[i][1]
+				dist := math.Sqrt(dx*dx + dy*dy)
+				if dist <= check.MinDist+0.001 {
+					valid = false
+					break
+				}
+			}
+		}
+		if valid && check.NoCrossings {
+			valid = planeDrawing(pos, edges, edgePairsToSeparate)
+		}
+		if valid {
+			return pos, true
+		}
+	}
+	return nil, false
+}
+
+// planeDrawing reports whether the straight-line drawing is plane: no two
+// segments without a common endpoint cross or touch, and no vertex lies on
+// a segment it isn't an endpoint of.
+func planeDrawing(pos [][2]float64, edges [][2]int, separate [][2][2]int) bool {
+	const eps = 0.001
+	for _, pair := range separate {
+		e, f := pair[0], pair[1]
+		a, b, c, d := pos[e[0]], pos[e[1]], pos[f[0]], pos[f[1]]
+		if orient(a, b, c)*orient(a, b, d) < 0 && orient(c, d, a)*orient(c, d, b) < 0 {
+			return false
+		}
+	}
+	for _, e := range edges {
+		for v := range pos {
+			if v != e[0] && v != e[1] && segmentDist(pos[v], pos[e[0]], pos[e[1]]) <= eps {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,298 @@
+// Package pennyfilter holds cheap structural necessary conditions for a
+// graph to be a penny graph (the contact graph of non-overlapping unit
+// disks). Candidate generators and verify_penny run a configurable chain of
+// them before the expensive embedding search. Some of the conditions also
+// hold for the weaker matchstick and unit-distance models (see Models).
+//
+// Graphs are edge bitmasks in the penny_enum layout: bit k is the k-th pair
+// (i, j), i < j, in the order (0,1), (0,2), ..., (0,n-1), (1,2), ...
+package pennyfilter
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+	"strings"
+
+	"hexagon_clink/pkg/planar"
+)
+
+// maxN bounds the vertex count; edge bitmasks are uint64 so n <= 11 in
+// practice.
+const maxN = 16
+
+// adjacency holds one neighbor bitmask per vertex.
+type adjacency [maxN]uint16
+
+// Filter is one necessary condition; a graph failing it is not a penny graph.
+type Filter struct {
+	Name string
+	Doc  string
+	// Hereditary filters hold for every subgraph of a graph that passes
+	// them, so generators that build graphs edge by edge can prune on them.
+	Hereditary bool
+	// Models lists the embedding models (see Models) for which the filter
+	// is a necessary condition; all of them hold for penny graphs.
+	Models []string
+	reject func(n int, g uint64, adj *adjacency) bool
+}
+
+// Models are the embedding models verify_penny checks: penny graphs (edges
+// of length 1, non-edges longer), matchstick graphs (edges of length 1
+// drawn without crossings) and unit-distance graphs (edges of length 1).
+// Each is a special case of the next.
+var Models = []string{"penny", "matchstick", "unit"}
+
+// Filters lists every available filter in the order Default applies them.
+var Filters = []Filter{
+	{
+		Name:       "k4",
+		Doc:        "no K4: at most three disks touch pairwise",
+		Hereditary: true,
+		Models:     []string{"penny", "matchstick", "unit"},
+		reject:     hasK4,
+	},
+	{
+		Name:       "degree",
+		Doc:        "max degree <= 6: a disk touches at most six others",
+		Hereditary: true,
+		Models:     []string{"penny"},
+		reject:     degreeOver6,
+	},
+	{
+		Name:       "planar",
+		Doc:        "planar: the centers and contact segments form a plane drawing",
+		Hereditary: true,
+		Models:     []string{"penny", "matchstick"},
+		reject: func(n int, g uint64, _ *adjacency) bool {
+			return !planar.IsPlanarMask(n, g)
+		},
+	},
+	{
+		Name:       "k23",
+		Doc:        "no K2,3: two unit circles meet in at most two points, so two vertices share at most two neighbors",
+		Hereditary: true,
+		Models:     []string{"penny", "matchstick", "unit"},
+		reject:     hasK23,
+	},
+	{
+		Name: "wheel",
+		Doc: "neighborhoods: the neighbors of a vertex sit on a unit circle at least 60 degrees apart, " +
+			"adjacent exactly at 60, so every neighborhood is a disjoint union of paths, or a 6-cycle at degree 6",
+		Models: []string{"penny"},
+		reject: badNeighborhood,
+	},
+}
+
+// Default is the chain used when a tool's -filters flag is not given.
+const Default = "k4,degree,planar,k23,wheel"
+
+// Chain is a list of filters applied in order.
+type Chain []Filter
+
+// Parse parses a comma-separated list of filter names. "none" or an empty
+// string gives an empty chain.
+func Parse(spec string) (Chain, error) {
+	var c Chain
+	if spec == "" || spec == "none" {
+		return c, nil
+	}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, f := range Filters {
+			if f.Name == name {
+				c = append(c, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown filter %q (available: %s)", name, Names())
+		}
+	}
+	return c, nil
+}
+
+// ForModel returns every filter that is a necessary condition for model,
+// in the order Default applies them.
+func ForModel(model string) (Chain, error) {
+	if !slices.Contains(Models, model) {
+		return nil, fmt.Errorf("unknown model %q (available: %s)", model, strings.Join(Models, ","))
+	}
+	var c Chain
+	for _, f := range Filters {
+		if slices.Contains(f.Models, model) {
+			c = append(c, f)
+		}
+	}
+	return c, nil
+}
+
+// CheckModel reports an error if a filter of c could reject a graph that
+// has an embedding in model.
+func (c Chain) CheckModel(model string) error {
+	for _, f := range c {
+		if !slices.Contains(f.Models, model) {
+			return fmt.Errorf("filter %s is not a necessary condition for %s graphs", f.Name, model)
+		}
+	}
+	return nil
+}
+
+// Names returns the names of all filters, comma-separated.
+func Names() string {
+	names := make([]string, len(Filters))
+	for i, f := range Filters {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// String returns the chain in the form Parse accepts.
+func (c Chain) String() string {
+	if len(c) == 0 {
+		return "none"
+	}
+	names := make([]string, len(c))
+	for i, f := range c {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// Hereditary returns the filters of c that generators may prune on.
+func (c Chain) Hereditary() Chain {
+	var h Chain
+	for _, f := range c {
+		if f.Hereditary {
+			h = append(h, f)
+		}
+	}
+	return h
+}
+
+// Has reports whether c contains the named filter.
+func (c Chain) Has(name string) bool {
+	for _, f := range c {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns the name of the first filter that rejects the graph on n
+// vertices with edge bitmask g, or "" if all accept it.
+func (c Chain) Check(n int, g uint64) string {
+	if len(c) == 0 {
+		return ""
+	}
+	if n > maxN {
+		panic(fmt.Sprintf("pennyfilter: n=%d exceeds %d", n, maxN))
+	}
+	var adj adjacency
+	idx := 0
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if g&(1<<idx) != 0 {
+				adj[i] |= 1 << j
+				adj[j] |= 1 << i
+			}
+			idx++
+		}
+	}
+	for _, f := range c {
+		if f.reject(n, g, &adj) {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// Accept reports whether every filter in c accepts the graph.
+func (c Chain) Accept(n int, g uint64) bool {
+	return c.Check(n, g) == ""
+}
+
+func hasK4(n int, _ uint64, adj *adjacency) bool {
+	// Per edge ab, a K4 through it is an edge among the common neighbors
+	for a := 0; a < n; a++ {
+		for above := adj[a] &^ (1<<(a+1) - 1); above != 0; above &= above - 1 {
+			b := bits.TrailingZeros16(above)
+			common := adj[a] & adj[b]
+			for c := common; c != 0; c &= c - 1 {
+				if adj[bits.TrailingZeros16(c)]&common != 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func degreeOver6(n int, _ uint64, adj *adjacency) bool {
+	for v := 0; v < n; v++ {
+		if bits.OnesCount16(adj[v]) > 6 {
+			return true
+		}
+	}
+	return false
+}
+
+func hasK23(n int, _ uint64, adj *adjacency) bool {
+	for u := 0; u < n; u++ {
+		for v := u + 1; v < n; v++ {
+			if bits.OnesCount16(adj[u]&adj[v]) > 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func badNeighborhood(n int, _ uint64, adj *adjacency) bool {
+	for v := 0; v < n; v++ {
+		nb := adj[v]
+		deg := bits.OnesCount16(nb)
+		if deg > 6 {
+			return true
+		}
+		// A neighbor touches at most the two neighbors 60 degrees away
+		inner := 0
+		for u := nb; u != 0; u &= u - 1 {
+			k := bits.OnesCount16(adj[bits.TrailingZeros16(u)] & nb)
+			if k > 2 {
+				return true
+			}
+			inner += k
+		}
+		inner /= 2
+		components := 0
+		for rest := nb; rest != 0; {
+			components++
+			comp := rest & -rest
+			for {
+				grown := comp
+				for u := comp; u != 0; u &= u - 1 {
+					grown |= adj[bits.TrailingZeros16(u)] & nb
+				}
+				if grown == comp {
+					break
+				}
+				comp = grown
+			}
+			rest &^= comp
+		}
+		if deg == 6 {
+			// Six neighbors fill the circle: a single 6-cycle
+			if inner != 6 || components != 1 {
+				return true
+			}
+		} else if inner != deg-components {
+			// Fewer than six can't close a cycle: a forest of paths
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package pennyfilter
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// edgeIndices returns the bit of each pair (i, j) in the edge bitmask of a
+// graph on n vertices, in the order (0,1), (0,2), ..., (1,2), ...
+func edgeIndices(n int) [][]int {
+	idx := make([][]int, n)
+	for i := range idx {
+		idx[i] = make([]int, n)
+	}
+	k := 0
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			idx[i][j], idx[j][i] = k, k
+			k++
+		}
+	}
+	return idx
+}
+
+// hasK4Loops is the check hasK4 replaced: four nested loops over the
+// vertices, testing each pair's bit in the edge bitmask.
+func hasK4Loops(n int, g uint64, edgeIndex [][]int) bool {
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			if g&(1<<edgeIndex[a][b]) == 0 {
+				continue
+			}
+			for c := b + 1; c < n; c++ {
+				if g&(1<<edgeIndex[a][c]) == 0 || g&(1<<edgeIndex[b][c]) == 0 {
+					continue
+				}
+				for d := c + 1; d < n; d++ {
+					if g&(1<<edgeIndex[a][d]) != 0 && g&(1<<edgeIndex[b][d]) != 0 && g&(1<<edgeIndex[c][d]) != 0 {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// adjacencyOf builds the neighbor masks as Check does.
+func adjacencyOf(n int, g uint64) *adjacency {
+	var adj adjacency
+	idx := 0
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if g&(1<<idx) != 0 {
+				adj[i] |= 1 << j
+				adj[j] |= 1 << i
+			}
+			idx++
+		}
+	}
+	return &adj
+}
+
+// randomGraphs returns count random graphs on n vertices with about
+// 2n edges and no vertex above degree 6, like the generators' candidates.
+func randomGraphs(n, count int, seed int64) []uint64 {
+	rng := rand.New(rand.NewSource(seed))
+	edgeIndex := edgeIndices(n)
+	graphs := make([]uint64, count)
+	for k := range graphs {
+		var g uint64
+		deg := make([]int, n)
+		for e := 0; e < 2*n; e++ {
+			i, j := rng.Intn(n), rng.Intn(n)
+			if i == j || deg[i] == 6 || deg[j] == 6 || g&(1<<edgeIndex[i][j]) != 0 {
+				continue
+			}
+			g |= 1 << edgeIndex[i][j]
+			deg[i]++
+			deg[j]++
+		}
+		graphs[k] = g
+	}
+	return graphs
+}
+
+func TestHasK4MatchesLoops(t *testing.T) {
+	for n := 4; n <= 11; n++ {
+		edgeIndex := edgeIndices(n)
+		// K4 on the last four vertices, and random graphs with and without
+		k4 := uint64(0)
+		for a := n - 4; a < n; a++ {
+			for b := a + 1; b < n; b++ {
+				k4 |= 1 << edgeIndex[a][b]
+			}
+		}
+		found := 0
+		for _, g := range append(randomGraphs(n, 2000, int64(n)), k4) {
+			want := hasK4Loops(n, g, edgeIndex)
+			if got := hasK4(n, g, adjacencyOf(n, g)); got != want {
+				t.Fatalf("n=%d graph %#x: hasK4 = %v, the four loops say %v", n, g, got, want)
+			}
+			if want {
+				found++
+			}
+		}
+		if found < 2 {
+			t.Errorf("n=%d: only %d graphs with a K4, the comparison hardly covers them", n, found)
+		}
+	}
+}
+
+// BenchmarkHasK4 times the bitset check against the four loops it replaced,
+// on random candidates: on neighbor masks built beforehand, as Check builds
+// them once for the whole chain, and with building them included.
+func BenchmarkHasK4(b *testing.B) {
+	for _, n := range []int{9, 11} {
+		graphs := randomGraphs(n, 1<<12, 1)
+		edgeIndex := edgeIndices(n)
+		adjs := make([]*adjacency, len(graphs))
+		for i, g := range graphs {
+			adjs[i] = adjacencyOf(n, g)
+		}
+		b.Run(fmt.Sprintf("n%d/loops", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				hasK4Loops(n, graphs[i&(len(graphs)-1)], edgeIndex)
+			}
+		})
+		b.Run(fmt.Sprintf("n%d/bitset", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				k := i & (len(graphs) - 1)
+				hasK4(n, graphs[k], adjs[k])
+			}
+		})
+		b.Run(fmt.Sprintf("n%d/bitset_with_masks", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				g := graphs[i&(len(graphs)-1)]
+				hasK4(n, g, adjacencyOf(n, g))
+			}
+		})
+	}
+}
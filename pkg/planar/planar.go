@@ -0,0 +1,342 @@
+// Package planar tests graphs for planarity with the left-right planarity
+// test of de Fraysseix and Rosenstiehl, following the formulation in
+// U. Brandes, "The Left-Right Planarity Test" (2009). It runs in time linear
+// in the size of the graph and only answers yes or no; no embedding is
+// built.
+package planar
+
+// IsPlanar reports whether the graph on n vertices with the given edges is
+// planar. Self-loops and repeated edges are not allowed.
+func IsPlanar(n int, edges [][2]int) bool {
+	if n > 2 && len(edges) > 3*n-6 {
+		return false
+	}
+	s := newState(n, edges)
+	for v := 0; v < n; v++ {
+		if s.height[v] < 0 {
+			s.height[v] = 0
+			s.roots = append(s.roots, v)
+			s.orient(v)
+		}
+	}
+	s.sortByNesting()
+	for _, v := range s.roots {
+		if !s.test(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPlanarMask is IsPlanar for a graph given as an edge bitmask in the
+// penny_enum layout: bit k is the k-th pair (i, j), i < j, in the order
+// (0,1), (0,2), ..., (0,n-1), (1,2), ...
+func IsPlanarMask(n int, g uint64) bool {
+	var edges [][2]int
+	idx := 0
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if g&(1<<idx) != 0 {
+				edges = append(edges, [2]int{i, j})
+			}
+			idx++
+		}
+	}
+	return IsPlanar(n, edges)
+}
+
+const none = -1
+
+// interval is a range of return edges on one side, from its lowest to its
+// highest edge, linked through state.ref.
+type interval struct {
+	low, high int
+}
+
+func (i interval) empty() bool {
+	return i.low == none && i.high == none
+}
+
+type conflictPair struct {
+	left, right interval
+}
+
+func (p *conflictPair) swap() {
+	p.left, p.right = p.right, p.left
+}
+
+// half is one end of an undirected edge as seen from a vertex.
+type half struct {
+	to, edge int
+}
+
+type state struct {
+	adj    [][]half // undirected edges per vertex
+	height []int
+	roots  []int
+
+	// Oriented edges, numbered in the order the DFS orients them
+	src, dst   []int
+	out        [][]int // oriented out-edges per vertex
+	parentEdge []int   // tree edge into each vertex
+	oriented   []bool  // per undirected edge
+
+	lowpt, lowpt2, nesting []int
+	ref, lowptEdge         []int
+	stackBottom            []*conflictPair
+
+	stack []*conflictPair
+}
+
+func newState(n int, edges [][2]int) *state {
+	s := &state{
+		adj:        make([][]half, n),
+		height:     make([]int, n),
+		out:        make([][]int, n),
+		parentEdge: make([]int, n),
+		oriented:   make([]bool, len(edges)),
+	}
+	for k, e := range edges {
+		s.adj[e[0]] = append(s.adj[e[0]], half{e[1], k})
+		s.adj[e[1]] = append(s.adj[e[1]], half{e[0], k})
+	}
+	for v := range s.height {
+		s.height[v] = none
+		s.parentEdge[v] = none
+	}
+	return s
+}
+
+func (s *state) newEdge(v, w int) int {
+	e := len(s.src)
+	s.src = append(s.src, v)
+	s.dst = append(s.dst, w)
+	s.lowpt = append(s.lowpt, 0)
+	s.lowpt2 = append(s.lowpt2, 0)
+	s.nesting = append(s.nesting, 0)
+	s.ref = append(s.ref, none)
+	s.lowptEdge = append(s.lowptEdge, none)
+	s.stackBottom = append(s.stackBottom, nil)
+	s.out[v] = append(s.out[v], e)
+	return e
+}
+
+// orient runs the first DFS: it orients every edge away from the root along
+// tree edges and towards ancestors along back edges, and computes lowpoints
+// and nesting depths.
+func (s *state) orient(v int) {
+	e := s.parentEdge[v]
+	for _, h := range s.adj[v] {
+		if s.oriented[h.edge] {
+			continue
+		}
+		s.oriented[h.edge] = true
+		w := h.to
+		vw := s.newEdge(v, w)
+		s.lowpt[vw] = s.height[v]
+		s.lowpt2[vw] = s.height[v]
+		if s.height[w] < 0 { // tree edge
+			s.parentEdge[w] = vw
+			s.height[w] = s.height[v] + 1
+			s.orient(w)
+		} else { // back edge
+			s.lowpt[vw] = s.height[w]
+		}
+
+		s.nesting[vw] = 2 * s.lowpt[vw]
+		if s.lowpt2[vw] < s.height[v] { // chordal
+			s.nesting[vw]++
+		}
+
+		if e != none {
+			switch {
+			case s.lowpt[vw] < s.lowpt[e]:
+				s.lowpt2[e] = min(s.lowpt[e], s.lowpt2[vw])
+				s.lowpt[e] = s.lowpt[vw]
+			case s.lowpt[vw] > s.lowpt[e]:
+				s.lowpt2[e] = min(s.lowpt2[e], s.lowpt[vw])
+			default:
+				s.lowpt2[e] = min(s.lowpt2[e], s.lowpt2[vw])
+			}
+		}
+	}
+}
+
+// sortByNesting orders every vertex's out-edges by nesting depth, which is
+// the order the second DFS visits them in.
+func (s *state) sortByNesting() {
+	for _, es := range s.out {
+		for i := 1; i < len(es); i++ {
+			for j := i; j > 0 && s.nesting[es[j]] < s.nesting[es[j-1]]; j-- {
+				es[j], es[j-1] = es[j-1], es[j]
+			}
+		}
+	}
+}
+
+func (s *state) top() *conflictPair {
+	if len(s.stack) == 0 {
+		return nil
+	}
+	return s.stack[len(s.stack)-1]
+}
+
+func (s *state) pop() *conflictPair {
+	p := s.top()
+	s.stack = s.stack[:len(s.stack)-1]
+	return p
+}
+
+// setRef links return edge e to the next one in its interval; links from
+// an empty interval end are dropped.
+func (s *state) setRef(e, to int) {
+	if e != none {
+		s.ref[e] = to
+	}
+}
+
+func (s *state) conflicting(i interval, b int) bool {
+	return !i.empty() && s.lowpt[i.high] > s.lowpt[b]
+}
+
+func (s *state) lowest(p *conflictPair) int {
+	if p.left.empty() {
+		return s.lowpt[p.right.low]
+	}
+	if p.right.empty() {
+		return s.lowpt[p.left.low]
+	}
+	return min(s.lowpt[p.left.low], s.lowpt[p.right.low])
+}
+
+// test runs the second DFS, which tries to put the return edges of every
+// tree edge on the left or right so that none of them cross. It returns
+// false as soon as that is impossible.
+func (s *state) test(v int) bool {
+	e := s.parentEdge[v]
+	for k, ei := range s.out[v] {
+		w := s.dst[ei]
+		s.stackBottom[ei] = s.top()
+		if ei == s.parentEdge[w] { // tree edge
+			if !s.test(w) {
+				return false
+			}
+		} else { // back edge
+			s.lowptEdge[ei] = ei
+			s.stack = append(s.stack, &conflictPair{
+				left:  interval{none, none},
+				right: interval{ei, ei},
+			})
+		}
+
+		// Integrate new return edges
+		if s.lowpt[ei] < s.height[v] {
+			if k == 0 {
+				s.lowptEdge[e] = s.lowptEdge[ei]
+			} else if !s.addConstraints(ei, e) {
+				return false
+			}
+		}
+	}
+	if e != none {
+		s.removeBackEdges(e)
+	}
+	return true
+}
+
+func (s *state) addConstraints(ei, e int) bool {
+	p := &conflictPair{left: interval{none, none}, right: interval{none, none}}
+
+	// Merge the return edges of ei into p.right
+	for {
+		q := s.pop()
+		if !q.left.empty() {
+			q.swap()
+		}
+		if !q.left.empty() {
+			return false
+		}
+		if s.lowpt[q.right.low] > s.lowpt[e] {
+			if p.right.empty() {
+				p.right = q.right
+			} else {
+				s.ref[p.right.low] = q.right.high
+			}
+			p.right.low = q.right.low
+		} else {
+			s.ref[q.right.low] = s.lowptEdge[e]
+		}
+		if s.top() == s.stackBottom[ei] {
+			break
+		}
+	}
+
+	// Merge the conflicting return edges of the earlier siblings into p.left
+	for {
+		t := s.top()
+		if t == nil || !s.conflicting(t.left, ei) && !s.conflicting(t.right, ei) {
+			break
+		}
+		q := s.pop()
+		if s.conflicting(q.right, ei) {
+			q.swap()
+		}
+		if s.conflicting(q.right, ei) {
+			return false
+		}
+		s.setRef(p.right.low, q.right.high)
+		if q.right.low != none {
+			p.right.low = q.right.low
+		}
+		if p.left.empty() {
+			p.left = q.left
+		} else {
+			s.setRef(p.left.low, q.left.high)
+		}
+		p.left.low = q.left.low
+	}
+
+	if !p.left.empty() || !p.right.empty() {
+		s.stack = append(s.stack, p)
+	}
+	return true
+}
+
+func (s *state) removeBackEdges(e int) {
+	u := s.src[e]
+
+	// Drop conflict pairs whose lowest return edge ends at u
+	for len(s.stack) > 0 && s.lowest(s.top()) == s.height[u] {
+		s.pop()
+	}
+
+	if len(s.stack) > 0 {
+		p := s.top()
+		// Trim the left interval
+		for p.left.high != none && s.dst[p.left.high] == u {
+			p.left.high = s.ref[p.left.high]
+		}
+		if p.left.high == none && p.left.low != none {
+			s.ref[p.left.low] = p.right.low
+			p.left.low = none
+		}
+		// Trim the right interval
+		for p.right.high != none && s.dst[p.right.high] == u {
+			p.right.high = s.ref[p.right.high]
+		}
+		if p.right.high == none && p.right.low != none {
+			s.ref[p.right.low] = p.left.low
+			p.right.low = none
+		}
+	}
+
+	// The side of e is the side of a highest return edge
+	if s.lowpt[e] < s.height[u] {
+		hl, hr := s.top().left.high, s.top().right.high
+		if hl != none && (hr == none || s.lowpt[hl] > s.lowpt[hr]) {
+			s.ref[e] = hl
+		} else {
+			s.ref[e] = hr
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package polyiamond
+
+import (
+	"hash/maphash"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Enumerate grows the polyiamonds one triangle at a time up to n
+// triangles and calls level with the shapes of each size. Shapes that keep
+// (if not nil) rejects are dropped and not grown further, so keep must only
+// reject shapes none of whose extensions are wanted.
+func Enumerate(n int, workers int, keep func(size int, p Polyiamond) bool, cache *Cache, level func(size int, shapes []Polyiamond)) {
+	if n < 1 {
+		return
+	}
+
+	// Initial triangle
+	initial := Canonicalize(Polyiamond{
+		Triangles: []Triangle{
+			MakeTriangle(Vertex{0, 0}, Vertex{1, 0}, Vertex{0, 1}),
+		},
+	})
+	current := []Polyiamond{}
+	if keep == nil || keep(1, initial) {
+		current = append(current, initial)
+	}
+	Resume(current, 1, n, workers, keep, cache, level)
+}
+
+// Resume is Enumerate starting from current, the shapes of size
+// triangles, e.g. saved by an earlier run.
+func Resume(current []Polyiamond, size, n int, workers int, keep func(size int, p Polyiamond) bool, cache *Cache, level func(size int, shapes []Polyiamond)) {
+	level(size, current)
+	for size++; size <= n && len(current) > 0; size++ {
+		current = grow(current, size, workers, keep, cache)
+		level(size, current)
+	}
+}
+
+// growBatch is how many shapes a worker takes at a time. The work per
+// shape varies with its boundary, so workers fetch small batches as they
+// go instead of getting one fixed chunk each, and none sits idle while
+// another finishes a chunk of large shapes.
+const growBatch = 64
+
+// shapeShards is the number of independently locked parts of the set of
+// grown shapes, so workers adding shapes rarely wait for each other.
+const shapeShards = 256
+
+type shapeSet struct {
+	seed   maphash.Seed
+	shards [shapeShards]struct {
+		mu     sync.Mutex
+		shapes map[string]Polyiamond
+	}
+}
+
+func newShapeSet() *shapeSet {
+	s := &shapeSet{seed: maphash.MakeSeed()}
+	for i := range s.shards {
+		s.shards[i].shapes = make(map[string]Polyiamond)
+	}
+	return s
+}
+
+// add stores p under key unless the key is already present; keep (if not
+// nil) is only asked about shapes not seen before.
+func (s *shapeSet) add(key string, p Polyiamond, keep func(Polyiamond) bool) {
+	shard := &s.shards[maphash.String(s.seed, key)%shapeShards]
+	shard.mu.Lock()
+	_, dup := shard.shapes[key]
+	shard.mu.Unlock()
+	if dup || (keep != nil && !keep(p)) {
+		return
+	}
+	shard.mu.Lock()
+	shard.shapes[key] = p
+	shard.mu.Unlock()
+}
+
+// Cache remembers recently grown shapes, after translation to the
+// origin, so that one reached again from another parent skips Canonicalize
+// (12 transformed, sorted copies) and the shape set. It is direct-mapped:
+// a shape hashes to one slot and replaces what was there, so it holds a
+// fixed number of shapes however large the level. Shapes of different
+// sizes never match, so it needs no clearing between levels.
+type Cache struct {
+	seed         maphash.Seed
+	slots        []string // Key of the normalized shape
+	locks        [256]sync.Mutex
+	hits, misses atomic.Int64
+}
+
+// NewCache returns a cache of size slots (rounded up to a power of
+// two), or nil for size 0, which caches nothing.
+func NewCache(size int) *Cache {
+	if size <= 0 {
+		return nil
+	}
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &Cache{seed: maphash.MakeSeed(), slots: make([]string, n)}
+}
+
+// Stats returns the cache hits and lookups so far.
+func (c *Cache) Stats() (hits, lookups int64) {
+	if c == nil {
+		return 0, 0
+	}
+	hits = c.hits.Load()
+	return hits, hits + c.misses.Load()
+}
+
+// seen reports whether the normalized shape with key normKey was looked up
+// before and is still cached, and caches it otherwise.
+func (c *Cache) seen(normKey string) bool {
+	if c == nil {
+		return false
+	}
+	slot := maphash.String(c.seed, normKey) & uint64(len(c.slots)-1)
+	lock := &c.locks[slot%uint64(len(c.locks))]
+	lock.Lock()
+	hit := c.slots[slot] == normKey
+	c.slots[slot] = normKey
+	lock.Unlock()
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return hit
+}
+
+// grow returns the polyiamonds with one more triangle (size in all) than
+// shapes, up to symmetry, that keep accepts.
+func grow(shapes []Polyiamond, size, workers int, keep func(size int, p Polyiamond) bool, cache *Cache) []Polyiamond {
+	next := newShapeSet()
+	var keepAt func(Polyiamond) bool
+	if keep != nil {
+		keepAt = func(p Polyiamond) bool { return keep(size, p) }
+	}
+
+	var taken atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(taken.Add(growBatch)) - growBatch
+				if start >= len(shapes) {
+					return
+				}
+				for _, shape := range shapes[start:min(start+growBatch, len(shapes))] {
+					for _, newTri := range Boundary(shape) {
+						// A cached shape has been canonicalized and offered to next already
+						norm := Normalize(Add(shape, newTri))
+						if cache.seen(Key(norm)) {
+							continue
+						}
+						canon := Canonicalize(norm)
+						next.add(Key(canon), canon, keepAt)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Sorted, so runs (and resumed runs) report shapes in the same order
+	var result []Polyiamond
+	for i := range next.shards {
+		for _, p := range next.shards[i].shapes {
+			result = append(result, p)
+		}
+	}
+	slices.SortFunc(result, Compare)
+	return result
+}
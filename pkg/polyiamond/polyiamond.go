@@ -0,0 +1,285 @@
+// Package polyiamond enumerates polyiamonds, shapes made of edge-connected
+// equilateral triangles of the triangular lattice, up to rotation and
+// reflection, and gives their contact graphs: the lattice points the
+// triangles cover, adjacent at distance 1, form a penny graph.
+//
+// Lattice points are (a, b) coordinates in the basis of two unit vectors
+// 60 degrees apart. A shape is kept in canonical form: translated to the
+// origin, its triangles sorted, and the smallest of its 12 images under the
+// symmetries of the lattice.
+package polyiamond
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Vertex in triangular lattice (a, b) coordinates
+type Vertex struct {
+	A, B int
+}
+
+// Triangle represented by 3 vertices (sorted for canonical form)
+type Triangle [3]Vertex
+
+// Polyiamond is a set of triangles
+type Polyiamond struct {
+	Triangles []Triangle
+}
+
+// MakeTriangle returns the triangle with the given corners.
+func MakeTriangle(v1, v2, v3 Vertex) Triangle {
+	verts := []Vertex{v1, v2, v3}
+	sort.Slice(verts, func(i, j int) bool {
+		if verts[i].A != verts[j].A {
+			return verts[i].A < verts[j].A
+		}
+		return verts[i].B < verts[j].B
+	})
+	return Triangle{verts[0], verts[1], verts[2]}
+}
+
+func rotateVertex60(v Vertex) Vertex {
+	return Vertex{-v.B, v.A + v.B}
+}
+
+func reflectVertex(v Vertex) Vertex {
+	return Vertex{v.A + v.B, -v.B}
+}
+
+func transformTriangle(t Triangle, rotate int, reflect bool) Triangle {
+	verts := []Vertex{t[0], t[1], t[2]}
+
+	if reflect {
+		for i := range verts {
+			verts[i] = reflectVertex(verts[i])
+		}
+	}
+
+	for r := 0; r < rotate%6; r++ {
+		for i := range verts {
+			verts[i] = rotateVertex60(verts[i])
+		}
+	}
+
+	return MakeTriangle(verts[0], verts[1], verts[2])
+}
+
+// Normalize translates p to the origin and sorts its triangles.
+func Normalize(p Polyiamond) Polyiamond {
+	if len(p.Triangles) == 0 {
+		return p
+	}
+
+	// Find min a, b across all vertices
+	minA, minB := 1000000, 1000000
+	for _, t := range p.Triangles {
+		for _, v := range t {
+			if v.A < minA {
+				minA = v.A
+			}
+			if v.B < minB {
+				minB = v.B
+			}
+		}
+	}
+
+	// Translate
+	result := Polyiamond{Triangles: make([]Triangle, len(p.Triangles))}
+	for i, t := range p.Triangles {
+		result.Triangles[i] = MakeTriangle(
+			Vertex{t[0].A - minA, t[0].B - minB},
+			Vertex{t[1].A - minA, t[1].B - minB},
+			Vertex{t[2].A - minA, t[2].B - minB},
+		)
+	}
+
+	// Sort triangles for canonical ordering
+	sort.Slice(result.Triangles, func(i, j int) bool {
+		for k := 0; k < 3; k++ {
+			if result.Triangles[i][k].A != result.Triangles[j][k].A {
+				return result.Triangles[i][k].A < result.Triangles[j][k].A
+			}
+			if result.Triangles[i][k].B != result.Triangles[j][k].B {
+				return result.Triangles[i][k].B < result.Triangles[j][k].B
+			}
+		}
+		return false
+	})
+
+	return result
+}
+
+func transformPolyiamond(p Polyiamond, rotate int, reflect bool) Polyiamond {
+	result := Polyiamond{Triangles: make([]Triangle, len(p.Triangles))}
+	for i, t := range p.Triangles {
+		result.Triangles[i] = transformTriangle(t, rotate, reflect)
+	}
+	return Normalize(result)
+}
+
+// Key returns a string identifying the normalized shape p.
+func Key(p Polyiamond) string {
+	var key string
+	for _, t := range p.Triangles {
+		key += fmt.Sprintf("%d,%d,%d,%d,%d,%d;", t[0].A, t[0].B, t[1].A, t[1].B, t[2].A, t[2].B)
+	}
+	return key
+}
+
+// Compare orders normalized shapes by their triangles, then by size.
+func Compare(a, b Polyiamond) int {
+	for i := 0; i < len(a.Triangles) && i < len(b.Triangles); i++ {
+		for k := 0; k < 3; k++ {
+			if a.Triangles[i][k].A != b.Triangles[i][k].A {
+				return a.Triangles[i][k].A - b.Triangles[i][k].A
+			}
+			if a.Triangles[i][k].B != b.Triangles[i][k].B {
+				return a.Triangles[i][k].B - b.Triangles[i][k].B
+			}
+		}
+	}
+	return len(a.Triangles) - len(b.Triangles)
+}
+
+// Canonicalize returns the smallest normalized image of p under rotation
+// and reflection.
+func Canonicalize(p Polyiamond) Polyiamond {
+	best := Normalize(p)
+
+	for rot := 0; rot < 6; rot++ {
+		for _, refl := range []bool{false, true} {
+			candidate := transformPolyiamond(p, rot, refl)
+			if Compare(candidate, best) < 0 {
+				best = candidate
+			}
+		}
+	}
+
+	return best
+}
+
+func getAdjacentTriangles(t Triangle) []Triangle {
+	verts := []Vertex{t[0], t[1], t[2]}
+	neighbors := make([]Triangle, 0, 3)
+
+	for i := 0; i < 3; i++ {
+		v1, v2 := verts[i], verts[(i+1)%3]
+		v3 := verts[(i+2)%3]
+
+		// Fourth vertex completing parallelogram
+		v4 := Vertex{v1.A + v2.A - v3.A, v1.B + v2.B - v3.B}
+		neighbors = append(neighbors, MakeTriangle(v1, v2, v4))
+	}
+
+	return neighbors
+}
+
+func polyiamondContains(p Polyiamond, t Triangle) bool {
+	for _, tri := range p.Triangles {
+		if tri == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Boundary returns the triangles outside p that share an edge with it.
+func Boundary(p Polyiamond) []Triangle {
+	seen := make(map[Triangle]bool)
+	for _, t := range p.Triangles {
+		for _, neighbor := range getAdjacentTriangles(t) {
+			if !polyiamondContains(p, neighbor) {
+				seen[neighbor] = true
+			}
+		}
+	}
+
+	result := make([]Triangle, 0, len(seen))
+	for t := range seen {
+		result = append(result, t)
+	}
+	return result
+}
+
+// Add returns p with triangle t added.
+func Add(p Polyiamond, t Triangle) Polyiamond {
+	newTris := make([]Triangle, len(p.Triangles)+1)
+	copy(newTris, p.Triangles)
+	newTris[len(p.Triangles)] = t
+	return Polyiamond{Triangles: newTris}
+}
+
+// Size returns the vertex and edge counts of p's contact graph.
+func Size(p Polyiamond) (int, int) {
+	vertices := make(map[Vertex]bool)
+	edges := make(map[[2]Vertex]bool)
+
+	for _, t := range p.Triangles {
+		for _, v := range t {
+			vertices[v] = true
+		}
+		for i := 0; i < 3; i++ {
+			v1, v2 := t[i], t[(i+1)%3]
+			if v1.A > v2.A || (v1.A == v2.A && v1.B > v2.B) {
+				v1, v2 = v2, v1
+			}
+			edges[[2]Vertex{v1, v2}] = true
+		}
+	}
+
+	return len(vertices), len(edges)
+}
+
+// Graph returns p's contact graph: its vertices in increasing (a, b) order
+// and its edges as index pairs, sorted.
+func Graph(p Polyiamond) ([]Vertex, [][2]int) {
+	// Collect vertices and edges
+	vertexSet := make(map[Vertex]bool)
+	edgeSet := make(map[[2]Vertex]bool)
+
+	for _, t := range p.Triangles {
+		for _, v := range t {
+			vertexSet[v] = true
+		}
+		for i := 0; i < 3; i++ {
+			v1, v2 := t[i], t[(i+1)%3]
+			if v1.A > v2.A || (v1.A == v2.A && v1.B > v2.B) {
+				v1, v2 = v2, v1
+			}
+			edgeSet[[2]Vertex{v1, v2}] = true
+		}
+	}
+
+	// Create sorted vertex list
+	vertices := make([]Vertex, 0, len(vertexSet))
+	for v := range vertexSet {
+		vertices = append(vertices, v)
+	}
+	sort.Slice(vertices, func(i, j int) bool {
+		if vertices[i].A != vertices[j].A {
+			return vertices[i].A < vertices[j].A
+		}
+		return vertices[i].B < vertices[j].B
+	})
+
+	// Map vertices to indices
+	vertexIdx := make(map[Vertex]int)
+	for i, v := range vertices {
+		vertexIdx[v] = i
+	}
+
+	// Build edge list with indices
+	edges := make([][2]int, 0, len(edgeSet))
+	for e := range edgeSet {
+		edges = append(edges, [2]int{vertexIdx[e[0]], vertexIdx[e[1]]})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+
+	return vertices, edges
+}
@@ -0,0 +1,85 @@
+// Package prof lets a long run be profiled without a rebuild: Serve
+// publishes the net/http/pprof handlers (CPU and heap profiles, goroutine
+// dumps, and execution traces on demand under /debug/pprof/), and
+// StartTrace records a runtime/trace of the run to a file.
+//
+// Tools wire these to -pprof :6060 and -trace file (with -trace-for to
+// limit the window, since a trace of a multi-hour run is far too large).
+package prof
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// Serve listens on addr (e.g. "localhost:6060") and serves the pprof
+// handlers in the background, e.g.
+//
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//
+// It returns once the port is bound, so a bad address is reported to the
+// caller.
+func Serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("pprof: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+var (
+	traceMu   sync.Mutex
+	traceFile *os.File
+)
+
+// StartTrace records an execution trace to path, for "go tool trace",
+// for the first window of the run (0: until StopTrace). Only one trace
+// runs at a time.
+func StartTrace(path string, window time.Duration) error {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	if traceFile != nil {
+		return fmt.Errorf("trace: already tracing to %s", traceFile.Name())
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("trace: %w", err)
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return fmt.Errorf("trace: %w", err)
+	}
+	traceFile = f
+	if window > 0 {
+		time.AfterFunc(window, func() { StopTrace() })
+	}
+	return nil
+}
+
+// StopTrace ends the trace and closes its file. It does nothing when no
+// trace is running, so tools call it on every way out; a trace cut off by
+// os.Exit without it is unreadable.
+func StopTrace() error {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	if traceFile == nil {
+		return nil
+	}
+	trace.Stop()
+	err := traceFile.Close()
+	traceFile = nil
+	return err
+}
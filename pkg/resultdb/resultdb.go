@@ -0,0 +1,326 @@
+// Package resultdb keeps the pipeline's results in one SQLite database:
+// graphs with their invariants, verdicts of the checks run on them (such
+// as verify_penny's embedding search) and solver solutions. Unlike the
+// .g6/.bin/.txt files passed between stages, the database can be queried
+// incrementally, e.g. for the n=12 graphs with 24+ edges that no run has
+// verified yet:
+//
+//	SELECT graph6 FROM graph_view WHERE n = 12 AND edges >= 24 AND penny IS NULL
+//
+// Graphs are keyed by the graph6 string of their canonical form
+// (wlcanon.CanonicalGraph), so importing the same file twice, or another
+// labeling of a graph already there, adds nothing; the graph6 column keeps
+// the labeling the graph was first added with.
+//
+// A verdict of "no" is a proof (verify_penny's filters); a search that
+// gives up without finding an embedding records "not_found", which says
+// nothing about the graph. The view's penny column prefers the latest yes
+// or no over any not_found.
+package resultdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/wlcanon"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS graphs (
+	id       INTEGER PRIMARY KEY,
+	graph6   TEXT NOT NULL,
+	canon    TEXT NOT NULL,
+	n        INTEGER NOT NULL,
+	edges    INTEGER NOT NULL,
+	stage    TEXT NOT NULL DEFAULT '',
+	source   TEXT NOT NULL DEFAULT '',
+	added_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS graphs_n_edges ON graphs (n, edges);
+
+CREATE TABLE IF NOT EXISTS invariants (
+	graph_id     INTEGER PRIMARY KEY REFERENCES graphs (id),
+	degrees      TEXT NOT NULL,
+	min_degree   INTEGER NOT NULL,
+	max_degree   INTEGER NOT NULL,
+	triangles    INTEGER NOT NULL,
+	girth        INTEGER NOT NULL,
+	diameter     INTEGER NOT NULL,
+	connected    INTEGER NOT NULL,
+	components   INTEGER NOT NULL,
+	independence INTEGER NOT NULL,
+	chromatic    INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS verdicts (
+	id         INTEGER PRIMARY KEY,
+	graph_id   INTEGER NOT NULL REFERENCES graphs (id),
+	check_name TEXT NOT NULL,
+	verdict    TEXT NOT NULL,
+	tool       TEXT NOT NULL DEFAULT '',
+	checked_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS verdicts_graph_check ON verdicts (graph_id, check_name);
+
+CREATE TABLE IF NOT EXISTS solutions (
+	id           INTEGER PRIMARY KEY,
+	n            INTEGER NOT NULL,
+	k            INTEGER NOT NULL,
+	shapes       TEXT NOT NULL DEFAULT '',
+	arrangements TEXT NOT NULL,
+	tool         TEXT NOT NULL DEFAULT '',
+	found_at     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS solutions_n_k ON solutions (n, k);
+`
+
+// viewSchema follows schema once migrate has brought the tables up to
+// date.
+const viewSchema = `
+CREATE UNIQUE INDEX IF NOT EXISTS graphs_canon ON graphs (canon);
+
+-- graph_view: every graph with its invariants and latest penny verdict,
+-- a yes or no before any not_found
+CREATE VIEW IF NOT EXISTS graph_view AS
+SELECT g.id, g.graph6, g.canon, g.n, g.edges, g.stage, g.source,
+	i.degrees, i.min_degree, i.max_degree, i.triangles, i.girth, i.diameter,
+	i.connected, i.components, i.independence, i.chromatic,
+	(SELECT v.verdict FROM verdicts v WHERE v.graph_id = g.id AND v.check_name = 'penny'
+		ORDER BY v.verdict = 'not_found', v.id DESC LIMIT 1) AS penny
+FROM graphs g LEFT JOIN invariants i ON i.graph_id = g.id;
+`
+
+// DB is an open result database.
+type DB struct {
+	*sql.DB
+}
+
+// Open opens or creates the database at path and makes sure the schema
+// exists.
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=10000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range []func() error{
+		func() error { _, err := db.Exec(schema); return err },
+		func() error { return migrate(db) },
+		func() error { _, err := db.Exec(viewSchema); return err },
+	} {
+		if err := step(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return &DB{db}, nil
+}
+
+// schemaVersion is the database's user_version once migrate is done.
+// Version 1 keys graphs by canonical form; version 2 stores failed
+// embedding searches as not_found rather than no.
+const schemaVersion = 2
+
+// migrate brings a database written by an older version up to date. The
+// view is dropped and recreated, as it may select older columns.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version >= schemaVersion {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DROP VIEW IF EXISTS graph_view`); err != nil {
+		return err
+	}
+	var hasCanon int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('graphs') WHERE name = 'canon'`).Scan(&hasCanon); err != nil {
+		return err
+	}
+	if hasCanon == 0 {
+		if err := mergeIsomorphic(tx); err != nil {
+			return err
+		}
+	}
+	// verify_penny used to record no for every graph it failed to embed;
+	// the filters' rejections name the filter in the tool
+	for _, stmt := range []string{
+		`UPDATE verdicts SET verdict = 'not_found' WHERE verdict = 'no' AND tool = 'verify_penny'`,
+		fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion),
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// mergeIsomorphic adds and fills the canon column of a database from
+// before graphs were keyed by canonical form, and merges each set of
+// isomorphic graphs into its oldest row, which takes over their verdicts.
+func mergeIsomorphic(tx *sql.Tx) error {
+	if _, err := tx.Exec(`ALTER TABLE graphs ADD COLUMN canon TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	type row struct {
+		id int64
+		g6 string
+	}
+	var all []row
+	rows, err := tx.Query(`SELECT id, graph6 FROM graphs ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.g6); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	kept := make(map[string]int64)
+	for _, r := range all {
+		g, err := invariants.ParseGraph6(r.g6)
+		if err != nil {
+			return fmt.Errorf("graph %d: %v", r.id, err)
+		}
+		canon := Canonical(g)
+		if keep, ok := kept[canon]; ok {
+			for _, stmt := range []string{
+				`UPDATE verdicts SET graph_id = ?2 WHERE graph_id = ?1`,
+				`DELETE FROM invariants WHERE graph_id = ?1`,
+				`DELETE FROM graphs WHERE id = ?1`,
+			} {
+				if _, err := tx.Exec(stmt, r.id, keep); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		kept[canon] = r.id
+		if _, err := tx.Exec(`UPDATE graphs SET canon = ? WHERE id = ?`, canon, r.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Canonical returns the graph6 string of g's canonical form, the key of
+// the graphs table: equal for two graphs exactly when they are isomorphic.
+func Canonical(g invariants.Graph) string {
+	c := wlcanon.CanonicalGraph(g)
+	return graph6.Encode(c.N, c.HasEdge)
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// Tx batches inserts; commit it with Commit. Batching matters: SQLite
+// syncs the disk once per transaction.
+type Tx struct {
+	tx                          *sql.Tx
+	findGraph, addGraph, addInv *sql.Stmt
+	addVerdict                  *sql.Stmt
+}
+
+// Begin starts a batch of inserts.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	t := &Tx{tx: tx}
+	for _, p := range []struct {
+		stmt **sql.Stmt
+		sql  string
+	}{
+		{&t.findGraph, `SELECT id FROM graphs WHERE canon = ?`},
+		{&t.addGraph, `INSERT INTO graphs (graph6, canon, n, edges, stage, source, added_at) VALUES (?, ?, ?, ?, ?, ?, ?)`},
+		{&t.addInv, `INSERT OR REPLACE INTO invariants VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&t.addVerdict, `INSERT INTO verdicts (graph_id, check_name, verdict, tool, checked_at) VALUES (?, ?, ?, ?, ?)`},
+	} {
+		if *p.stmt, err = tx.Prepare(p.sql); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AddGraph stores g (graph6 encoding g6) unless it or a graph isomorphic
+// to it is already there, and returns the id of its row and whether it was
+// new. New graphs get their invariants computed and stored too.
+func (t *Tx) AddGraph(g invariants.Graph, g6, stage, source string) (int64, bool, error) {
+	canon := Canonical(g)
+	var id int64
+	err := t.findGraph.QueryRow(canon).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	inv := invariants.Compute(g)
+	res, err := t.addGraph.Exec(g6, canon, g.N, inv.Edges, stage, source, now())
+	if err != nil {
+		return 0, false, err
+	}
+	if id, err = res.LastInsertId(); err != nil {
+		return 0, false, err
+	}
+	degrees := make([]string, len(inv.Degrees))
+	for i, d := range inv.Degrees {
+		degrees[i] = strconv.Itoa(d)
+	}
+	_, err = t.addInv.Exec(id, strings.Join(degrees, ","), inv.MinDegree, inv.MaxDegree, inv.Triangles,
+		inv.Girth, inv.Diameter, inv.Connected, inv.Components, inv.Independence, inv.Chromatic)
+	return id, true, err
+}
+
+// AddVerdict records the outcome of check (e.g. "penny" with "yes", "no"
+// or "not_found") for graph id. Later verdicts don't delete earlier ones;
+// the view shows the latest, a yes or no before any not_found.
+func (t *Tx) AddVerdict(id int64, check, verdict, tool string) error {
+	_, err := t.addVerdict.Exec(id, check, verdict, tool, now())
+	return err
+}
+
+// Commit ends the batch.
+func (t *Tx) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback abandons the batch.
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// AddSolution records k arrangements of n items found by tool; shapes
+// names the host graph of each arrangement ("" for the spiral).
+func (db *DB) AddSolution(n int, shapes []string, arrangements [][]int, tool string) error {
+	arrs, err := json.Marshal(arrangements)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO solutions (n, k, shapes, arrangements, tool, found_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		n, len(arrangements), strings.Join(shapes, ","), string(arrs), tool, now())
+	return err
+}
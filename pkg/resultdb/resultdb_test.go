@@ -0,0 +1,189 @@
+package resultdb
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/invariants"
+)
+
+// labelings returns two labelings of the path on 3 vertices, centre 1 and
+// centre 2, and the triangle.
+func labelings(t *testing.T) (a, b, c string) {
+	t.Helper()
+	enc := func(edges ...[2]int) string {
+		return graph6.Encode(3, func(u, v int) bool {
+			for _, e := range edges {
+				if e == [2]int{u, v} || e == [2]int{v, u} {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return enc([2]int{0, 1}, [2]int{1, 2}), enc([2]int{0, 2}, [2]int{1, 2}), enc([2]int{0, 1}, [2]int{0, 2}, [2]int{1, 2})
+}
+
+func parse(t *testing.T, g6 string) invariants.Graph {
+	t.Helper()
+	g, err := invariants.ParseGraph6(g6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// TestAddGraphIsomorphic adds two labelings of one graph and another graph:
+// the second labeling finds the first one's row, which keeps its graph6.
+func TestAddGraphIsomorphic(t *testing.T) {
+	a, b, c := labelings(t)
+	db, err := Open(filepath.Join(t.TempDir(), "r.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for i, g6 := range []string{a, b, c, a} {
+		id, isNew, err := tx.AddGraph(parse(t, g6), g6, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i == 0 || i == 2; isNew != want {
+			t.Errorf("graph %d (%s): new %v, want %v", i, g6, isNew, want)
+		}
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ids[1] != ids[0] || ids[3] != ids[0] || ids[2] == ids[0] {
+		t.Errorf("ids %v, want the first, second and fourth equal", ids)
+	}
+	var stored string
+	if err := db.QueryRow(`SELECT graph6 FROM graph_view WHERE id = ?`, ids[0]).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != a {
+		t.Errorf("stored graph6 %s, want the first labeling %s", stored, a)
+	}
+}
+
+// TestMigrate opens a database with the old schema, keyed by graph6, that
+// holds two labelings of one graph with a verdict each: they become one row,
+// the older, with both verdicts. The other graph's failed search, recorded
+// as no back then, becomes not_found.
+func TestMigrate(t *testing.T) {
+	a, b, c := labelings(t)
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE graphs (id INTEGER PRIMARY KEY, graph6 TEXT NOT NULL UNIQUE, n INTEGER NOT NULL,
+			edges INTEGER NOT NULL, stage TEXT NOT NULL DEFAULT '', source TEXT NOT NULL DEFAULT '', added_at TEXT NOT NULL)`,
+		`CREATE VIEW graph_view AS SELECT g.id, g.graph6 FROM graphs g`,
+		`CREATE TABLE verdicts (id INTEGER PRIMARY KEY, graph_id INTEGER NOT NULL REFERENCES graphs (id),
+			check_name TEXT NOT NULL, verdict TEXT NOT NULL, tool TEXT NOT NULL DEFAULT '', checked_at TEXT NOT NULL)`,
+		`INSERT INTO graphs (id, graph6, n, edges, added_at) VALUES (1, '` + a + `', 3, 2, ''), (2, '` + c + `', 3, 3, ''), (3, '` + b + `', 3, 2, '')`,
+		`INSERT INTO verdicts (graph_id, check_name, verdict, tool, checked_at) VALUES
+			(1, 'penny', 'yes', 'verify_penny', ''), (3, 'penny', 'yes', 'verify_penny', ''), (2, 'penny', 'no', 'verify_penny', '')`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var ids []int64
+	rows, err := db.Query(`SELECT id FROM graph_view ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("graphs %v after migrating, want [1 2]", ids)
+	}
+	var verdicts int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM verdicts WHERE graph_id = 1`).Scan(&verdicts); err != nil {
+		t.Fatal(err)
+	}
+	if verdicts != 2 {
+		t.Errorf("%d verdicts on the kept row, want 2", verdicts)
+	}
+	var penny string
+	if err := db.QueryRow(`SELECT penny FROM graph_view WHERE id = 2`).Scan(&penny); err != nil {
+		t.Fatal(err)
+	}
+	if penny != "not_found" {
+		t.Errorf("old search failure reads %q, want not_found", penny)
+	}
+
+	// A labeling added after the migration finds the kept row
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if id, isNew, err := tx.AddGraph(parse(t, b), b, "", ""); err != nil || isNew || id != 1 {
+		t.Errorf("AddGraph(%s) = %d, %v, %v, want 1, false, nil", b, id, isNew, err)
+	}
+}
+
+// TestViewPrefersProof records not_found after yes and after no: the view
+// keeps showing the proof.
+func TestViewPrefersProof(t *testing.T) {
+	a, _, c := labelings(t)
+	db, err := Open(filepath.Join(t.TempDir(), "r.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{}
+	for _, v := range []struct{ g6, first string }{{a, "yes"}, {c, "no"}} {
+		id, _, err := tx.AddGraph(parse(t, v.g6), v.g6, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, verdict := range []string{v.first, "not_found"} {
+			if err := tx.AddVerdict(id, "penny", verdict, "test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want[id] = v.first
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for id, w := range want {
+		var penny string
+		if err := db.QueryRow(`SELECT penny FROM graph_view WHERE id = ?`, id).Scan(&penny); err != nil {
+			t.Fatal(err)
+		}
+		if penny != w {
+			t.Errorf("graph %d: view says %q, want %q", id, penny, w)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package shard splits a tool's work deterministically across machines:
+// with -shard i/m, shard i of m (0 <= i < m) takes its share of the work
+// items, the m shards together take each item exactly once, and the same
+// input always gives the same split, so a failed shard can be rerun alone.
+// "hexclink merge" combines the shard outputs afterwards.
+package shard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shard is one part of an m-way split. The zero value, like All, is the
+// whole of the work.
+type Shard struct {
+	Index, Count int
+}
+
+// All is the trivial split: one shard doing everything.
+var All = Shard{0, 1}
+
+// Parse reads "i/m"; the empty string means All.
+func Parse(s string) (Shard, error) {
+	if s == "" {
+		return All, nil
+	}
+	is, ms, ok := strings.Cut(s, "/")
+	i, err1 := strconv.Atoi(is)
+	m, err2 := strconv.Atoi(ms)
+	if !ok || err1 != nil || err2 != nil {
+		return Shard{}, fmt.Errorf("shard %q: want i/m, e.g. 0/4", s)
+	}
+	if m < 1 || i < 0 || i >= m {
+		return Shard{}, fmt.Errorf("shard %q: need 0 <= i < m", s)
+	}
+	return Shard{i, m}, nil
+}
+
+// IsAll reports whether the shard does all of the work.
+func (s Shard) IsAll() bool {
+	return s.Count <= 1
+}
+
+// Owns reports whether work item k >= 0 belongs to this shard. Items are
+// dealt round-robin, which spreads runs of similar items over all shards.
+func (s Shard) Owns(k uint64) bool {
+	return s.IsAll() || k%uint64(s.Count) == uint64(s.Index)
+}
+
+// Range returns this shard's contiguous part [lo, hi) of total items; the
+// parts differ in size by at most one.
+func (s Shard) Range(total int) (lo, hi int) {
+	if s.IsAll() {
+		return 0, total
+	}
+	return total * s.Index / s.Count, total * (s.Index + 1) / s.Count
+}
+
+// String returns "i/m".
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Suffix returns "_s<i>of<m>" for naming a shard's default output files,
+// or "" for All.
+func (s Shard) Suffix() string {
+	if s.IsAll() {
+		return ""
+	}
+	return fmt.Sprintf("_s%dof%d", s.Index, s.Count)
+}
@@ -0,0 +1,229 @@
+// Package subiso decides whether a pattern graph is isomorphic to a
+// (not necessarily induced) subgraph of a host graph, with a VF2-style
+// backtracking matcher: pattern vertices are mapped one at a time in a
+// connectivity-first order, candidates are restricted to host vertices
+// adjacent to the images of already-mapped neighbors, and degree and
+// neighbor-degree pruning cut hopeless candidates up front. The same
+// matcher, run from a graph to itself, lists its automorphisms.
+//
+// Graphs are given as one neighbor bitmask per vertex, so n <= 64.
+package subiso
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Signature holds invariants that can only grow when passing to a
+// supergraph on at least as many vertices; a pattern whose signature
+// doesn't fit a host's can't be its subgraph, whatever the labeling.
+type Signature struct {
+	N         int
+	Edges     int
+	Triangles int
+	Degrees   []int // non-increasing
+}
+
+// Sign computes the signature of adj.
+func Sign(adj []uint64) Signature {
+	s := Signature{N: len(adj), Degrees: degrees(adj)}
+	for u := range adj {
+		s.Edges += s.Degrees[u]
+		higher := adj[u] &^ (1<<(u+1) - 1)
+		for rest := higher; rest != 0; rest &= rest - 1 {
+			v := bits.TrailingZeros64(rest)
+			s.Triangles += bits.OnesCount64(adj[v] & higher &^ (1<<(v+1) - 1))
+		}
+	}
+	s.Edges /= 2
+	sort.Sort(sort.Reverse(sort.IntSlice(s.Degrees)))
+	return s
+}
+
+// FitsIn reports whether a graph with signature p could be a subgraph of
+// one with signature h. Sorted degree sequences must dominate pointwise,
+// since a subgraph embedding maps vertices injectively without losing
+// degree.
+func (p Signature) FitsIn(h Signature) bool {
+	if p.N > h.N || p.Edges > h.Edges || p.Triangles > h.Triangles {
+		return false
+	}
+	for i, d := range p.Degrees {
+		if d > h.Degrees[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func degrees(adj []uint64) []int {
+	deg := make([]int, len(adj))
+	for v, a := range adj {
+		deg[v] = bits.OnesCount64(a)
+	}
+	return deg
+}
+
+// neighborDegrees returns the degrees of v's neighbors, largest first.
+func neighborDegrees(adj []uint64, deg []int, v int) []int {
+	var nd []int
+	for rest := adj[v]; rest != 0; rest &= rest - 1 {
+		nd = append(nd, deg[bits.TrailingZeros64(rest)])
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(nd)))
+	return nd
+}
+
+// Contains reports whether pattern is isomorphic to a subgraph of host.
+func Contains(host, pattern []uint64) bool {
+	return contains(host, pattern, false)
+}
+
+// ContainsInduced reports whether pattern is isomorphic to an induced
+// subgraph of host: non-adjacent pattern vertices must also map to
+// non-adjacent host vertices.
+func ContainsInduced(host, pattern []uint64) bool {
+	return contains(host, pattern, true)
+}
+
+// Automorphisms returns the automorphisms of the graph, identity
+// included, as vertex maps: perm[v] is the image of v. If there are more
+// than limit of them it stops and returns nil and false, since a partial
+// list is not a group.
+func Automorphisms(adj []uint64, limit int) ([][]int, bool) {
+	var perms [][]int
+	complete := true
+	embed(adj, adj, true, func(image []int) bool {
+		if len(perms) == limit {
+			complete = false
+			return true
+		}
+		perms = append(perms, append([]int(nil), image...))
+		return false
+	})
+	if !complete {
+		return nil, false
+	}
+	return perms, true
+}
+
+func contains(host, pattern []uint64, induced bool) bool {
+	return embed(host, pattern, induced, nil)
+}
+
+// embed runs the matcher, calling visit on every embedding found until it
+// returns true; with a nil visit it stops at the first. It reports whether
+// the search was stopped.
+func embed(host, pattern []uint64, induced bool, visit func(image []int) bool) bool {
+	np, nh := len(pattern), len(host)
+	if np > nh {
+		return false
+	}
+	if np == 0 {
+		return visit == nil || visit(nil)
+	}
+	pdeg, hdeg := degrees(pattern), degrees(host)
+
+	// compat[u] is the set of host vertices u may map to: at least its
+	// degree, and neighbors at least as heavy as u's
+	hnd := make([][]int, nh)
+	for v := range host {
+		hnd[v] = neighborDegrees(host, hdeg, v)
+	}
+	compat := make([]uint64, np)
+	for u := range pattern {
+		pnd := neighborDegrees(pattern, pdeg, u)
+	next:
+		for v := range host {
+			if hdeg[v] < pdeg[u] {
+				continue
+			}
+			for i, d := range pnd {
+				if d > hnd[v][i] {
+					continue next
+				}
+			}
+			compat[u] |= 1 << v
+		}
+		if compat[u] == 0 {
+			return false
+		}
+	}
+
+	// Mapping order: always the vertex with the most already-ordered
+	// neighbors, then the fewest candidates, then the highest degree
+	order := make([]int, 0, np)
+	var ordered uint64
+	for len(order) < np {
+		best, bestLinks, bestCands := -1, -1, 0
+		for u := 0; u < np; u++ {
+			if ordered&(1<<u) != 0 {
+				continue
+			}
+			links := bits.OnesCount64(pattern[u] & ordered)
+			cands := bits.OnesCount64(compat[u])
+			if best < 0 || links > bestLinks ||
+				links == bestLinks && (cands < bestCands || cands == bestCands && pdeg[u] > pdeg[best]) {
+				best, bestLinks, bestCands = u, links, cands
+			}
+		}
+		order = append(order, best)
+		ordered |= 1 << best
+	}
+
+	m := matcher{
+		host:    host,
+		pattern: pattern,
+		compat:  compat,
+		order:   order,
+		image:   make([]int, np),
+		induced: induced,
+		visit:   visit,
+	}
+	return m.search(0)
+}
+
+type matcher struct {
+	host, pattern []uint64
+	compat        []uint64
+	order         []int
+	image         []int  // host vertex of each mapped pattern vertex
+	mappedP       uint64 // mapped pattern vertices
+	used          uint64 // host vertices in the image
+	induced       bool
+	visit         func(image []int) bool
+}
+
+func (m *matcher) search(i int) bool {
+	if i == len(m.order) {
+		return m.visit == nil || m.visit(m.image)
+	}
+	u := m.order[i]
+	cands := m.compat[u] &^ m.used
+	for rest := m.pattern[u] & m.mappedP; rest != 0; rest &= rest - 1 {
+		cands &= m.host[m.image[bits.TrailingZeros64(rest)]]
+	}
+	if m.induced {
+		// Mapped non-neighbors of u must not be adjacent to its image
+		for rest := m.mappedP &^ m.pattern[u]; rest != 0; rest &= rest - 1 {
+			cands &^= m.host[m.image[bits.TrailingZeros64(rest)]]
+		}
+	}
+	// Unmapped neighbors of u need distinct unused neighbors of its image
+	need := bits.OnesCount64(m.pattern[u] &^ m.mappedP)
+	for ; cands != 0; cands &= cands - 1 {
+		v := bits.TrailingZeros64(cands)
+		if bits.OnesCount64(m.host[v]&^m.used) < need {
+			continue
+		}
+		m.image[u] = v
+		m.mappedP |= 1 << u
+		m.used |= 1 << v
+		if m.search(i + 1) {
+			return true
+		}
+		m.mappedP &^= 1 << u
+		m.used &^= 1 << v
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+// Package wlcanon computes canonical forms of small graphs, given as edge
+// bitmasks in the penny_enum layout, without trying all n! relabelings.
+//
+// Color refinement (1-WL) first splits the vertices into classes that every
+// isomorphism must preserve: start from the degrees and recolor each vertex
+// by its color and the multiset of its neighbors' colors until the number of
+// classes stops growing. The classes, ordered by color, get consecutive
+// blocks of labels, and only the relabelings that put each class on its own
+// block are tried: the product of the class sizes' factorials instead of
+// n!, which for an asymmetric graph is often 1. Within those, a relabeling
+// is abandoned as soon as the labels placed so far already fix higher bits
+// than the best form found.
+//
+// The colors depend only on the graph's structure, so isomorphic graphs try
+// the same set of relabeled graphs and get equal forms; equal forms are
+// relabelings of each other, so they are isomorphic. The form is the
+// smallest bitmask among the tried relabelings, which in general is not the
+// smallest over all n!.
+package wlcanon
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+	"sort"
+
+	"hexagon_clink/pkg/invariants"
+)
+
+// MaxN is the largest vertex count whose edges fit in a 64-bit mask.
+const MaxN = 11
+
+// Canonical returns the canonical form of the graph on n vertices with edge
+// bitmask mask, in the same layout.
+func Canonical(n int, mask uint64) uint64 {
+	if n < 1 || n > MaxN {
+		panic(fmt.Sprintf("wlcanon: n=%d out of range 1..%d", n, MaxN))
+	}
+	g := invariants.FromMask(n, mask)
+	classes := Classes(g)
+
+	// block[p] is the vertices allowed at label p
+	block := make([]uint64, n)
+	p := 0
+	for _, class := range classes {
+		var set uint64
+		for _, v := range class {
+			set |= 1 << v
+		}
+		for range class {
+			block[p] = set
+			p++
+		}
+	}
+
+	s := search{n: n, adj: g.Adj, block: block, at: make([]int, n), best: ^uint64(0)}
+	s.place(n-1, 0, 0)
+	return s.best
+}
+
+// Minimum returns the smallest bitmask over all n! relabelings of the graph
+// on n vertices with edge bitmask mask: the form penny_enum's canonicalize
+// writes with -canon-backend brute. It runs the search of Canonical with
+// every vertex allowed at every label, so it is slow on very symmetric
+// graphs, but the pruning makes it fast enough for a single lookup.
+func Minimum(n int, mask uint64) uint64 {
+	if n < 1 || n > MaxN {
+		panic(fmt.Sprintf("wlcanon: n=%d out of range 1..%d", n, MaxN))
+	}
+	g := invariants.FromMask(n, mask)
+	block := make([]uint64, n)
+	for p := range block {
+		block[p] = 1<<n - 1
+	}
+	s := search{n: n, adj: g.Adj, block: block, at: make([]int, n), best: ^uint64(0)}
+	s.place(n-1, 0, 0)
+	return s.best
+}
+
+// CanonicalGraph returns the canonical form of g for any n up to
+// invariants.MaxN, where the edges no longer fit in one mask. Trying every
+// relabeling within the classes explodes on symmetric graphs such as
+// lattice patches, so this searches by individualization and refinement
+// instead: pick the first class with more than one vertex, give each of
+// its vertices a class of its own in turn, and refine again, until every
+// class is a single vertex. Class order, and so the labeling at each leaf,
+// depends only on the structure; the form is the smallest over the leaves,
+// compared row by row from the highest label down. A leaf with the best
+// form gives an automorphism, which maps the best leaf's branch to the
+// current one where the two paths split, so the rest of the current branch
+// is skipped; and a vertex that the automorphisms fixing the vertices
+// individualized so far map to an already tried one is skipped, as its
+// subtree has the same leaves. Without that, the edgeless graph on 11
+// vertices takes 11! leaves; with it, 11.
+func CanonicalGraph(g invariants.Graph) invariants.Graph {
+	s := rowSearch{adj: g.Adj, rows: make([]uint64, g.N), at: make([]int, g.N), back: -1}
+	s.place(refine(g.Adj, Classes(g)))
+	cg := invariants.New(g.N)
+	for p, row := range s.best {
+		for rest := row; rest != 0; rest &= rest - 1 {
+			cg.AddEdge(p, bits.TrailingZeros64(rest))
+		}
+	}
+	return cg
+}
+
+// Classes returns g's color classes after refinement, each sorted, in an
+// order that depends only on the graph's structure.
+func Classes(g invariants.Graph) [][]int {
+	colors := make([]uint64, g.N)
+	for v := range colors {
+		colors[v] = uint64(g.Degree(v))
+	}
+	next := make([]uint64, g.N)
+	neighbors := make([]uint64, 0, g.N)
+	for classes := countDistinct(colors); ; {
+		for v := range colors {
+			neighbors = neighbors[:0]
+			for rest := g.Adj[v]; rest != 0; rest &= rest - 1 {
+				neighbors = append(neighbors, colors[bits.TrailingZeros64(rest)])
+			}
+			sort.Slice(neighbors, func(a, b int) bool { return neighbors[a] < neighbors[b] })
+			h := mix64(colors[v] + 0x9e3779b97f4a7c15)
+			for _, c := range neighbors {
+				h = mix64(h ^ c)
+			}
+			next[v] = h
+		}
+		colors, next = next, colors
+		refined := countDistinct(colors)
+		if refined <= classes {
+			break
+		}
+		classes = refined
+	}
+
+	byColor := make(map[uint64][]int)
+	for v, c := range colors {
+		byColor[c] = append(byColor[c], v)
+	}
+	keys := make([]uint64, 0, len(byColor))
+	for c := range byColor {
+		keys = append(keys, c)
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a] < keys[b] })
+	result := make([][]int, len(keys))
+	for i, c := range keys {
+		result[i] = byColor[c]
+	}
+	return result
+}
+
+func countDistinct(colors []uint64) int {
+	seen := make(map[uint64]bool, len(colors))
+	for _, c := range colors {
+		seen[c] = true
+	}
+	return len(seen)
+}
+
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// search assigns labels from the top down: the pairs among labels p..n-1
+// are the highest bits of the mask, so once they are placed they can be
+// compared with the best form.
+type search struct {
+	n     int
+	adj   []uint64
+	block []uint64
+	at    []int // at[p] is the vertex with label p
+	best  uint64
+}
+
+// pairIndex is the bit of pair (i, j), i < j.
+func (s *search) pairIndex(i, j int) int {
+	return i*s.n - i*(i+1)/2 + j - i - 1
+}
+
+// place labels a vertex p, with used the vertices labeled above it and cur
+// the bits of their pairs.
+func (s *search) place(p int, used, cur uint64) {
+	if p < 0 {
+		if cur < s.best {
+			s.best = cur
+		}
+		return
+	}
+	shift := s.pairIndex(p, p+1) // first bit among labels >= p
+	for rest := s.block[p] &^ used; rest != 0; rest &= rest - 1 {
+		v := bits.TrailingZeros64(rest)
+		next := cur
+		for q := p + 1; q < s.n; q++ {
+			if s.adj[v]&(1<<s.at[q]) != 0 {
+				next |= 1 << s.pairIndex(p, q)
+			}
+		}
+		if next>>shift > s.best>>shift {
+			continue
+		}
+		s.at[p] = v
+		s.place(p-1, used|1<<v, next)
+	}
+}
+
+// refine splits classes until every vertex of a class has the same number
+// of neighbors in each class. The pieces of a class stay in its place,
+// ordered by their neighbor counts, so the order depends only on the
+// structure and the order of the classes passed in.
+func refine(adj []uint64, classes [][]int) [][]int {
+	type piece struct {
+		counts []int
+		vs     []int
+	}
+	n := len(adj)
+	for {
+		masks := make([]uint64, len(classes))
+		for c, class := range classes {
+			for _, v := range class {
+				masks[c] |= 1 << v
+			}
+		}
+		next := make([][]int, 0, n)
+		for _, class := range classes {
+			var pieces []piece
+			for _, v := range class {
+				counts := make([]int, len(classes))
+				for c, m := range masks {
+					counts[c] = bits.OnesCount64(adj[v] & m)
+				}
+				i := slices.IndexFunc(pieces, func(p piece) bool { return slices.Equal(p.counts, counts) })
+				if i < 0 {
+					pieces = append(pieces, piece{counts: counts})
+					i = len(pieces) - 1
+				}
+				pieces[i].vs = append(pieces[i].vs, v)
+			}
+			slices.SortFunc(pieces, func(a, b piece) int { return slices.Compare(a.counts, b.counts) })
+			for _, p := range pieces {
+				next = append(next, p.vs)
+			}
+		}
+		if len(next) == len(classes) {
+			return next
+		}
+		classes = next
+	}
+}
+
+// rowSearch walks the individualization tree. At a leaf every class is one
+// vertex and the class index is its label; rows[p] has bit q set for each
+// label q > p adjacent to label p.
+type rowSearch struct {
+	adj      []uint64
+	rows     []uint64
+	at       []int // at[p] is the vertex with label p at the current leaf
+	best     []uint64
+	bestAt   []int
+	bestPath []int
+	path     []int   // vertices individualized on the way to the current node
+	autos    [][]int // automorphisms found, as vertex permutations
+	back     int     // depth to return to, -1 if none
+}
+
+func (s *rowSearch) place(classes [][]int) {
+	target := slices.IndexFunc(classes, func(class []int) bool { return len(class) > 1 })
+	if target < 0 {
+		s.leaf(classes)
+		return
+	}
+	var tried []int
+	for _, v := range classes[target] {
+		if s.inOrbit(v, tried) {
+			continue
+		}
+		tried = append(tried, v)
+		s.path = append(s.path, v)
+		split := make([][]int, 0, len(classes)+1)
+		split = append(split, classes[:target]...)
+		rest := slices.DeleteFunc(slices.Clone(classes[target]), func(u int) bool { return u == v })
+		split = append(split, []int{v}, rest)
+		split = append(split, classes[target+1:]...)
+		s.place(refine(s.adj, split))
+		s.path = s.path[:len(s.path)-1]
+		if s.back >= 0 {
+			if s.back < len(s.path) {
+				return
+			}
+			s.back = -1
+		}
+	}
+}
+
+// leaf compares the labeling at a leaf with the best form: a smaller form
+// replaces it, an equal one maps the best labeling onto this one, which is
+// an automorphism fixing the vertices both paths individualized before they
+// split, and sends the search back to that depth.
+func (s *rowSearch) leaf(classes [][]int) {
+	n := len(s.adj)
+	for p := range s.rows {
+		s.at[p] = classes[p][0]
+		s.rows[p] = 0
+		for q := p + 1; q < n; q++ {
+			if s.adj[classes[p][0]]&(1<<classes[q][0]) != 0 {
+				s.rows[p] |= 1 << q
+			}
+		}
+	}
+	switch {
+	case s.best == nil || less(s.rows, s.best):
+		s.best = slices.Clone(s.rows)
+		s.bestAt = slices.Clone(s.at)
+		s.bestPath = slices.Clone(s.path)
+	case slices.Equal(s.rows, s.best):
+		auto := make([]int, n)
+		for p, v := range s.bestAt {
+			auto[v] = s.at[p]
+		}
+		s.autos = append(s.autos, auto)
+		s.back = 0
+		for s.back < min(len(s.path), len(s.bestPath)) && s.path[s.back] == s.bestPath[s.back] {
+			s.back++
+		}
+	}
+}
+
+// inOrbit reports whether v is in the orbit of one of vs under the group
+// generated by the automorphisms found so far that fix every vertex on the
+// path.
+func (s *rowSearch) inOrbit(v int, vs []int) bool {
+	if len(vs) == 0 {
+		return false
+	}
+	var gens [][]int
+	for _, auto := range s.autos {
+		if !slices.ContainsFunc(s.path, func(u int) bool { return auto[u] != u }) {
+			gens = append(gens, auto)
+		}
+	}
+	orbit := uint64(1) << v
+	for grown := true; grown; {
+		grown = false
+		for _, auto := range gens {
+			for rest := orbit; rest != 0; rest &= rest - 1 {
+				if u := auto[bits.TrailingZeros64(rest)]; orbit&(1<<u) == 0 {
+					orbit |= 1 << u
+					grown = true
+				}
+			}
+		}
+	}
+	return slices.ContainsFunc(vs, func(u int) bool { return orbit&(1<<u) != 0 })
+}
+
+// less compares two forms from the row of the highest label down.
+func less(a, b []uint64) bool {
+	for p := len(a) - 1; p >= 0; p-- {
+		if a[p] != b[p] {
+			return a[p] < b[p]
+		}
+	}
+	return false
+}
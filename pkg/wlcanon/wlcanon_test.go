@@ -0,0 +1,104 @@
+package wlcanon
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/lattice"
+)
+
+// relabel returns g with vertex v renamed perm[v].
+func relabel(g invariants.Graph, perm []int) invariants.Graph {
+	h := invariants.New(g.N)
+	for u := 0; u < g.N; u++ {
+		for v := u + 1; v < g.N; v++ {
+			if g.HasEdge(u, v) {
+				h.AddEdge(perm[u], perm[v])
+			}
+		}
+	}
+	return h
+}
+
+// TestCanonicalGraphSymmetric runs graphs with huge automorphism groups,
+// which took factorial time before the search pruned by automorphisms, and
+// checks that relabelings get the same form.
+func TestCanonicalGraphSymmetric(t *testing.T) {
+	star := func(n int) invariants.Graph {
+		g := invariants.New(n)
+		for v := 1; v < n; v++ {
+			g.AddEdge(0, v)
+		}
+		return g
+	}
+	complete := func(n int) invariants.Graph {
+		g := invariants.New(n)
+		for u := 0; u < n; u++ {
+			for v := u + 1; v < n; v++ {
+				g.AddEdge(u, v)
+			}
+		}
+		return g
+	}
+	bipartite := func(a, b int) invariants.Graph {
+		g := invariants.New(a + b)
+		for u := 0; u < a; u++ {
+			for v := a; v < a+b; v++ {
+				g.AddEdge(u, v)
+			}
+		}
+		return g
+	}
+	cycles := invariants.New(24) // four hexagons
+	for v := 0; v < 24; v++ {
+		cycles.AddEdge(v, v/6*6+(v+1)%6)
+	}
+	cases := map[string]invariants.Graph{
+		"edgeless 11": invariants.New(11),
+		"edgeless 64": invariants.New(invariants.MaxN),
+		"star 11":     star(11),
+		"star 40":     star(40),
+		"K12":         complete(12),
+		"K6,6":        bipartite(6, 6),
+		"4 hexagons":  cycles,
+		"spiral 37":   lattice.Contact(lattice.Spiral(37)),
+	}
+	rng := rand.New(rand.NewSource(1))
+	for name, g := range cases {
+		start := time.Now()
+		want := CanonicalGraph(g)
+		for try := 0; try < 3; try++ {
+			if got := CanonicalGraph(relabel(g, rng.Perm(g.N))); !slices.Equal(got.Adj, want.Adj) {
+				t.Errorf("%s: a relabeling has another form", name)
+			}
+		}
+		if took := time.Since(start); took > 5*time.Second {
+			t.Errorf("%s: %v for four forms", name, took)
+		}
+	}
+}
+
+// TestCanonicalGraphClasses checks every graph on 6 vertices: there are 156
+// up to isomorphism, and two graphs get the same form exactly when they
+// have the same minimum bitmask.
+func TestCanonicalGraphClasses(t *testing.T) {
+	const n = 6
+	byMin := make(map[uint64]string)
+	forms := make(map[string]bool)
+	for mask := uint64(0); mask < 1<<(n*(n-1)/2); mask++ {
+		cg := CanonicalGraph(invariants.FromMask(n, mask))
+		form := cg.Graph6()
+		min := Minimum(n, mask)
+		if prev, ok := byMin[min]; ok && prev != form {
+			t.Fatalf("mask %#x: form %s, an isomorphic graph has %s", mask, form, prev)
+		}
+		byMin[min] = form
+		forms[form] = true
+	}
+	if len(byMin) != 156 || len(forms) != 156 {
+		t.Errorf("%d minimum bitmasks and %d forms, want 156", len(byMin), len(forms))
+	}
+}
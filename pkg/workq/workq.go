@@ -0,0 +1,498 @@
+// Package workq hands work units to worker processes over HTTP. A
+// Coordinator owns a fixed list of units; workers lease one at a time,
+// renew the lease while they work and upload the unit's result file. A
+// lease that is neither renewed nor completed expires and the unit goes
+// back in the queue, so a worker that dies or loses its network costs
+// only the time until expiry. A worker is not handed a unit it has failed
+// before while another live worker could take it, so one broken machine
+// can't use up a unit's attempts; once every live worker has failed a
+// unit it goes back to them, and a unit that fails MaxAttempts times is
+// given up on.
+//
+// The units are the shards of pkg/shard: unit i of m is "-shard i/m" for
+// the tool the workers run, so every tool with -shard can be driven
+// elastically, and a coordinator run gives the same results as running
+// all m shards by hand.
+//
+// Protocol (JSON bodies):
+//
+//	POST /lease   {"worker"}        200 Lease, 204 all done, 503 nothing for this worker yet (retry)
+//	POST /renew   {"worker", "id"}  200, or 410 if the lease was lost
+//	POST /result?worker=..&id=..    body: result file; 200, or 410 if the unit was given up on
+//	POST /fail    {"worker", "id", "error"}
+//	GET  /status  Status
+package workq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"hexagon_clink/pkg/shard"
+)
+
+// MaxAttempts is how often a unit is handed out before it counts as failed.
+const MaxAttempts = 3
+
+// retryAfter is how long a worker told to wait sleeps before asking again.
+const retryAfter = 10 * time.Second
+
+// Lease is one unit handed to a worker.
+type Lease struct {
+	ID           int    `json:"id"`
+	Shard        string `json:"shard"` // "i/m"
+	Ext          string `json:"ext"`   // extension the result file should have
+	Attempt      int    `json:"attempt"`
+	LeaseSeconds int    `json:"lease_seconds"`
+}
+
+// Status summarizes the queue.
+type Status struct {
+	Units   int `json:"units"`
+	Pending int `json:"pending"`
+	Leased  int `json:"leased"`
+	Done    int `json:"done"`
+	Failed  int `json:"failed"`
+}
+
+type state int
+
+const (
+	pending state = iota
+	leased
+	done
+	failed
+)
+
+type unit struct {
+	state    state
+	worker   string
+	expires  time.Time
+	attempts int
+	lastErr  string
+	failedBy map[string]bool // workers that reported failing this unit
+}
+
+// Coordinator serves the units and stores their results in dir as
+// unit_<i><ext>.
+type Coordinator struct {
+	mu    sync.Mutex
+	units []unit
+	dir   string
+	ext   string
+	lease time.Duration
+	log   func(format string, args ...any)
+	done  chan struct{}
+	seen  map[string]time.Time // last request of each worker
+}
+
+// NewCoordinator creates a coordinator for count units whose results are
+// files with extension ext, stored in dir. Units already in dir from an
+// earlier run count as done. log gets one line per event.
+func NewCoordinator(count int, dir, ext string, lease time.Duration, log func(string, ...any)) (*Coordinator, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Coordinator{units: make([]unit, count), dir: dir, ext: ext, lease: lease, log: log,
+		done: make(chan struct{}), seen: make(map[string]time.Time)}
+	for i := range c.units {
+		if _, err := os.Stat(c.ResultPath(i)); err == nil {
+			c.units[i].state = done
+		}
+	}
+	c.checkFinished()
+	return c, nil
+}
+
+// ResultPath is where unit i's result is stored.
+func (c *Coordinator) ResultPath(i int) string {
+	return filepath.Join(c.dir, fmt.Sprintf("unit_%d%s", i, c.ext))
+}
+
+// Done is closed once every unit is done or failed.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+// Status returns the current counts.
+func (c *Coordinator) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	s := Status{Units: len(c.units)}
+	for _, u := range c.units {
+		switch u.state {
+		case pending:
+			s.Pending++
+		case leased:
+			s.Leased++
+		case done:
+			s.Done++
+		case failed:
+			s.Failed++
+		}
+	}
+	return s
+}
+
+// Failed lists the units given up on, with their last error.
+func (c *Coordinator) Failed() map[int]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[int]string)
+	for i, u := range c.units {
+		if u.state == failed {
+			out[i] = u.lastErr
+		}
+	}
+	return out
+}
+
+// expire requeues units whose lease ran out; c.mu must be held.
+func (c *Coordinator) expire() {
+	now := time.Now()
+	for i := range c.units {
+		u := &c.units[i]
+		if u.state == leased && now.After(u.expires) {
+			c.log("unit %d: lease of %s expired", i, u.worker)
+			c.retry(i, "lease expired")
+		}
+	}
+}
+
+// touch records a request from worker; c.mu must be held.
+func (c *Coordinator) touch(worker string) {
+	c.seen[worker] = time.Now()
+}
+
+// live reports whether worker asked for anything recently enough to still
+// be around: a working worker renews every third of a lease, a waiting one
+// polls every retryAfter. c.mu must be held.
+func (c *Coordinator) live(worker string, now time.Time) bool {
+	return now.Sub(c.seen[worker]) <= max(c.lease, 3*retryAfter)
+}
+
+// avoids reports whether unit i should not go to worker: it failed the
+// unit before and some other live worker hasn't. c.mu must be held.
+func (c *Coordinator) avoids(i int, worker string) bool {
+	u := &c.units[i]
+	if !u.failedBy[worker] {
+		return false
+	}
+	now := time.Now()
+	for w := range c.seen {
+		if !u.failedBy[w] && c.live(w, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// retry puts unit i back in the queue unless it is out of attempts;
+// c.mu must be held.
+func (c *Coordinator) retry(i int, reason string) {
+	u := &c.units[i]
+	u.lastErr = reason
+	u.worker = ""
+	if u.attempts >= MaxAttempts {
+		u.state = failed
+		c.log("unit %d: failed %d times, giving up (%s)", i, u.attempts, reason)
+		c.checkFinished()
+		return
+	}
+	u.state = pending
+}
+
+// checkFinished closes done once no unit is pending or leased; c.mu must
+// be held (or c not yet shared).
+func (c *Coordinator) checkFinished() {
+	for _, u := range c.units {
+		if u.state == pending || u.state == leased {
+			return
+		}
+	}
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+type request struct {
+	Worker string `json:"worker"`
+	ID     int    `json:"id"`
+	Error  string `json:"error"`
+}
+
+// Handler returns the HTTP handler implementing the protocol.
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lease", c.handleLease)
+	mux.HandleFunc("/renew", c.handleRenew)
+	mux.HandleFunc("/result", c.handleResult)
+	mux.HandleFunc("/fail", c.handleFail)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(c.Status())
+	})
+	return mux
+}
+
+func decode(w http.ResponseWriter, r *http.Request) (request, bool) {
+	var req request
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	req, ok := decode(w, r)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch(req.Worker)
+	c.expire()
+	busy := false
+	for i := range c.units {
+		u := &c.units[i]
+		if u.state == leased || u.state == pending && c.avoids(i, req.Worker) {
+			busy = true
+		}
+		if u.state != pending || c.avoids(i, req.Worker) {
+			continue
+		}
+		u.state = leased
+		u.worker = req.Worker
+		u.expires = time.Now().Add(c.lease)
+		u.attempts++
+		sh := shard.Shard{Index: i, Count: len(c.units)}
+		c.log("unit %d (shard %s) -> %s, attempt %d", i, sh, req.Worker, u.attempts)
+		json.NewEncoder(w).Encode(Lease{ID: i, Shard: sh.String(), Ext: c.ext, Attempt: u.attempts,
+			LeaseSeconds: int(c.lease / time.Second)})
+		return
+	}
+	if busy {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// holds reports whether worker holds the lease on unit id; c.mu must be
+// held.
+func (c *Coordinator) holds(id int, worker string) bool {
+	return id >= 0 && id < len(c.units) && c.units[id].state == leased && c.units[id].worker == worker
+}
+
+func (c *Coordinator) handleRenew(w http.ResponseWriter, r *http.Request) {
+	req, ok := decode(w, r)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch(req.Worker)
+	c.expire()
+	if !c.holds(req.ID, req.Worker) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	c.units[req.ID].expires = time.Now().Add(c.lease)
+}
+
+func (c *Coordinator) handleFail(w http.ResponseWriter, r *http.Request) {
+	req, ok := decode(w, r)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch(req.Worker)
+	if !c.holds(req.ID, req.Worker) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	c.log("unit %d: %s failed: %s", req.ID, req.Worker, req.Error)
+	u := &c.units[req.ID]
+	if u.failedBy == nil {
+		u.failedBy = make(map[string]bool)
+	}
+	u.failedBy[req.Worker] = true
+	c.retry(req.ID, req.Error)
+}
+
+// handleResult stores an uploaded result. A result for a unit whose lease
+// moved on is still accepted if the unit isn't done yet: shards are
+// deterministic, so any complete run of the unit will do. A unit that ran
+// out of attempts stays failed: Done may already be closed and the results
+// collected, so a late result for it is dropped with 410.
+func (c *Coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	worker := r.URL.Query().Get("worker")
+	if err != nil || id < 0 || id >= len(c.units) {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.touch(worker)
+	state := c.units[id].state
+	if state == failed {
+		c.log("unit %d: dropped a result from %s, the unit was given up on", id, worker)
+	}
+	c.mu.Unlock()
+	if state == failed {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	if state == done {
+		return
+	}
+
+	// Write to a temporary name first, so a half-received upload never
+	// looks like a result
+	tmp := fmt.Sprintf("%s.part.%d", c.ResultPath(id), time.Now().UnixNano())
+	f, err := os.Create(tmp)
+	if err == nil {
+		_, err = io.Copy(f, r.Body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.units[id].state == failed {
+		os.Remove(tmp)
+		c.log("unit %d: dropped a result from %s, the unit was given up on", id, worker)
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	if err == nil && c.units[id].state != done {
+		err = os.Rename(tmp, c.ResultPath(id))
+	}
+	os.Remove(tmp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c.units[id].state != done {
+		c.units[id].state = done
+		c.units[id].worker = worker
+		c.log("unit %d: done by %s", id, worker)
+		c.checkFinished()
+	}
+}
+
+// Client is a worker's connection to a coordinator.
+type Client struct {
+	URL    string       // e.g. http://host:8700
+	Worker string       // name shown in the coordinator's log
+	HTTP   *http.Client // nil means http.DefaultClient
+}
+
+func (cl *Client) client() *http.Client {
+	if cl.HTTP == nil {
+		return http.DefaultClient
+	}
+	return cl.HTTP
+}
+
+func (cl *Client) post(path string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return cl.client().Post(cl.URL+path, "application/json", bytes.NewReader(data))
+}
+
+// Lease asks for a unit. It returns nil and no error once all units are
+// done, and waits while every remaining unit is leased to someone else or
+// was failed by this worker and can go to another.
+func (cl *Client) Lease() (*Lease, error) {
+	for {
+		resp, err := cl.post("/lease", request{Worker: cl.Worker})
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			var l Lease
+			err := json.NewDecoder(resp.Body).Decode(&l)
+			resp.Body.Close()
+			return &l, err
+		case http.StatusNoContent:
+			resp.Body.Close()
+			return nil, nil
+		case http.StatusServiceUnavailable:
+			resp.Body.Close()
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			time.Sleep(time.Duration(max(wait, 1)) * time.Second)
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("lease: %s", resp.Status)
+		}
+	}
+}
+
+// Renew extends the lease on unit id; it reports false if the lease was
+// lost (expired and handed to someone else).
+func (cl *Client) Renew(id int) (bool, error) {
+	resp, err := cl.post("/renew", request{Worker: cl.Worker, ID: id})
+	if err != nil {
+		return true, err // a network blip doesn't mean the lease is gone
+	}
+	resp.Body.Close()
+	return resp.StatusCode != http.StatusGone, nil
+}
+
+// Fail gives unit id back to be retried.
+func (cl *Client) Fail(id int, reason string) error {
+	resp, err := cl.post("/fail", request{Worker: cl.Worker, ID: id, Error: reason})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Upload sends the result file of unit id.
+func (cl *Client) Upload(id int, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	url := fmt.Sprintf("%s/result?id=%d&worker=%s", cl.URL, id, url.QueryEscape(cl.Worker))
+	resp, err := cl.client().Post(url, "application/octet-stream", f)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("upload unit %d: the coordinator gave up on the unit and dropped the result", id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload unit %d: %s %s", id, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package workq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCoordinator(t *testing.T, count int, lease time.Duration) (*Coordinator, *httptest.Server) {
+	t.Helper()
+	c, err := NewCoordinator(count, t.TempDir(), ".txt", lease, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(c.Handler())
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+// lease asks for a unit and fails the test unless one comes back promptly.
+func lease(t *testing.T, cl *Client) *Lease {
+	t.Helper()
+	type result struct {
+		l   *Lease
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		l, err := cl.Lease()
+		ch <- result{l, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("%s: lease: %v", cl.Worker, r.err)
+		}
+		return r.l
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: lease hangs", cl.Worker)
+		return nil
+	}
+}
+
+// leaseStatus posts one /lease request and returns the HTTP status.
+func leaseStatus(t *testing.T, cl *Client) int {
+	t.Helper()
+	resp, err := cl.post("/lease", request{Worker: cl.Worker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func upload(t *testing.T, cl *Client, id int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "result.txt")
+	if err := os.WriteFile(path, []byte("ok\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Upload(id, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func isDone(c *Coordinator) bool {
+	select {
+	case <-c.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFailOnlyWorker(t *testing.T) {
+	c, srv := newTestCoordinator(t, 1, time.Minute)
+	cl := &Client{URL: srv.URL, Worker: "w1"}
+
+	// The only worker keeps getting the unit back until it runs out of
+	// attempts, instead of waiting forever for a worker that hasn't failed it
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		l := lease(t, cl)
+		if l == nil || l.ID != 0 || l.Attempt != attempt {
+			t.Fatalf("attempt %d: got lease %+v", attempt, l)
+		}
+		if err := cl.Fail(l.ID, "broken"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := lease(t, cl); l != nil {
+		t.Fatalf("got lease %+v after %d failures", l, MaxAttempts)
+	}
+	if s := c.Status(); s != (Status{Units: 1, Failed: 1}) {
+		t.Errorf("status %+v", s)
+	}
+	if got := c.Failed(); got[0] != "broken" {
+		t.Errorf("failed units %v", got)
+	}
+	if !isDone(c) {
+		t.Error("Done not closed")
+	}
+}
+
+func TestFailGoesToOtherWorker(t *testing.T) {
+	c, srv := newTestCoordinator(t, 2, time.Minute)
+	w1 := &Client{URL: srv.URL, Worker: "w1"}
+	w2 := &Client{URL: srv.URL, Worker: "w2"}
+
+	l1 := lease(t, w1)
+	l2 := lease(t, w2)
+	if l1.ID != 0 || l2.ID != 1 {
+		t.Fatalf("leases %+v %+v", l1, l2)
+	}
+	if err := w1.Fail(0, "broken"); err != nil {
+		t.Fatal(err)
+	}
+	// w2 is alive and hasn't failed unit 0, so w1 has to wait for it
+	if got := leaseStatus(t, w1); got != http.StatusServiceUnavailable {
+		t.Fatalf("w1 lease after failing: status %d, want 503", got)
+	}
+	upload(t, w2, 1)
+	l := lease(t, w2)
+	if l == nil || l.ID != 0 || l.Attempt != 2 {
+		t.Fatalf("w2 got %+v, want unit 0 attempt 2", l)
+	}
+	upload(t, w2, 0)
+	if got := leaseStatus(t, w1); got != http.StatusNoContent {
+		t.Errorf("lease when all done: status %d, want 204", got)
+	}
+	if s := c.Status(); s != (Status{Units: 2, Done: 2}) {
+		t.Errorf("status %+v", s)
+	}
+	if !isDone(c) {
+		t.Error("Done not closed")
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	c, srv := newTestCoordinator(t, 1, 50*time.Millisecond)
+	w1 := &Client{URL: srv.URL, Worker: "w1"}
+	w2 := &Client{URL: srv.URL, Worker: "w2"}
+
+	if l := lease(t, w1); l == nil || l.ID != 0 {
+		t.Fatalf("w1 got %+v", l)
+	}
+	if ok, err := w1.Renew(0); !ok || err != nil {
+		t.Fatalf("renew of a live lease: %v %v", ok, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if s := c.Status(); s != (Status{Units: 1, Pending: 1}) {
+		t.Errorf("status after expiry %+v", s)
+	}
+	if ok, err := w1.Renew(0); ok || err != nil {
+		t.Errorf("renew of an expired lease: %v %v", ok, err)
+	}
+	l := lease(t, w2)
+	if l == nil || l.ID != 0 || l.Attempt != 2 {
+		t.Fatalf("w2 got %+v, want unit 0 attempt 2", l)
+	}
+
+	// A late result from the worker that lost the lease still counts
+	upload(t, w1, 0)
+	if s := c.Status(); s != (Status{Units: 1, Done: 1}) {
+		t.Errorf("status %+v", s)
+	}
+	if _, err := os.Stat(c.ResultPath(0)); err != nil {
+		t.Error(err)
+	}
+	if !isDone(c) {
+		t.Error("Done not closed")
+	}
+}
+
+func TestExpiryUsesUpAttempts(t *testing.T) {
+	c, srv := newTestCoordinator(t, 1, 20*time.Millisecond)
+	cl := &Client{URL: srv.URL, Worker: "w1"}
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		if l := lease(t, cl); l == nil || l.Attempt != attempt {
+			t.Fatalf("attempt %d: got %+v", attempt, l)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+	if l := lease(t, cl); l != nil {
+		t.Fatalf("got lease %+v after %d expiries", l, MaxAttempts)
+	}
+	if got := c.Failed(); got[0] != "lease expired" {
+		t.Errorf("failed units %v", got)
+	}
+}
+
+func TestResultAfterUnitFailed(t *testing.T) {
+	c, srv := newTestCoordinator(t, 1, 20*time.Millisecond)
+	cl := &Client{URL: srv.URL, Worker: "w1"}
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		if l := lease(t, cl); l == nil || l.Attempt != attempt {
+			t.Fatalf("attempt %d: got %+v", attempt, l)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+	// The last lease only expires when the coordinator looks again
+	if l := lease(t, cl); l != nil {
+		t.Fatalf("got lease %+v after %d expiries", l, MaxAttempts)
+	}
+	if !isDone(c) {
+		t.Fatal("Done not closed after the last attempt expired")
+	}
+
+	// The slow run's result arrives after the unit was given up on
+	path := filepath.Join(t.TempDir(), "result.txt")
+	if err := os.WriteFile(path, []byte("ok\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Upload(0, path); err == nil {
+		t.Error("upload for a failed unit succeeded")
+	}
+	if s := c.Status(); s != (Status{Units: 1, Failed: 1}) {
+		t.Errorf("status %+v, want the unit still failed", s)
+	}
+	if _, err := os.Stat(c.ResultPath(0)); !os.IsNotExist(err) {
+		t.Errorf("result file of a failed unit: %v", err)
+	}
+}
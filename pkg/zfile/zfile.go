@@ -0,0 +1,241 @@
+// Package zfile opens and creates files that are compressed according to
+// their extension: ".gz" is gzip (compress/gzip), ".zst" is zstd, piped
+// through the zstd command since the standard library has no zstd codec.
+// Any other name is a plain file.
+package zfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Compression extensions recognized by Open and Create.
+const (
+	Gzip = ".gz"
+	Zstd = ".zst"
+)
+
+// Ext returns the compression extension of path, or "" for a plain file.
+func Ext(path string) string {
+	switch {
+	case strings.HasSuffix(path, Gzip):
+		return Gzip
+	case strings.HasSuffix(path, Zstd):
+		return Zstd
+	}
+	return ""
+}
+
+// IsCompressed reports whether path names a compressed file.
+func IsCompressed(path string) bool {
+	return Ext(path) != ""
+}
+
+// Base strips the compression extension: "a.g6.zst" becomes "a.g6".
+func Base(path string) string {
+	return strings.TrimSuffix(path, Ext(path))
+}
+
+// HasExt reports whether path, ignoring compression, ends in ext, so that
+// HasExt("a.g6.zst", ".g6") is true.
+func HasExt(path, ext string) bool {
+	return strings.HasSuffix(Base(path), ext)
+}
+
+// Open opens path for reading, decompressing it if needed.
+func Open(path string) (io.ReadCloser, error) {
+	switch Ext(path) {
+	case Gzip:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return &gzipReader{zr, f}, nil
+	case Zstd:
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		cmd := exec.Command("zstd", "-d", "-c", "-q", path)
+		return startReader(cmd, path)
+	}
+	return os.Open(path)
+}
+
+// Create creates path for writing, compressing it if needed.
+func Create(path string) (io.WriteCloser, error) {
+	switch Ext(path) {
+	case Gzip:
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipWriter{gzip.NewWriter(f), f}, nil
+	case Zstd:
+		// Create the file here so permission errors surface immediately
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		cmd := exec.Command("zstd", "-q", "-f", "-T0", "-o", path)
+		return startWriter(cmd, path)
+	}
+	return os.Create(path)
+}
+
+type gzipReader struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (r *gzipReader) Close() error {
+	r.Reader.Close()
+	return r.f.Close()
+}
+
+type gzipWriter struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (w *gzipWriter) Close() error {
+	err := w.Writer.Close()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// cmdReader reads the stdout of a decompressor process.
+type cmdReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	path   string
+	err    error // sticky result once the stream has ended
+}
+
+func startReader(cmd *exec.Cmd, path string) (*cmdReader, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: starting %s: %v", path, cmd.Path, err)
+	}
+	return &cmdReader{ReadCloser: stdout, cmd: cmd, stderr: stderr, path: path}, nil
+}
+
+func (r *cmdReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		// Wait has closed the pipe; keep reporting how the stream ended
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		// A decompressor that fails mid-stream still closes stdout; only
+		// report EOF once it has exited cleanly
+		if werr := r.wait(); werr != nil {
+			err = werr
+		}
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *cmdReader) wait() error {
+	if r.cmd.ProcessState != nil {
+		if !r.cmd.ProcessState.Success() {
+			return r.failure(nil)
+		}
+		return nil
+	}
+	if err := r.cmd.Wait(); err != nil {
+		return r.failure(err)
+	}
+	return nil
+}
+
+func (r *cmdReader) failure(err error) error {
+	msg := strings.TrimSpace(r.stderr.String())
+	if msg == "" && err != nil {
+		msg = err.Error()
+	}
+	return fmt.Errorf("%s: %s failed: %s", r.path, r.cmd.Args[0], msg)
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	if r.cmd.ProcessState == nil {
+		// Closing early makes the decompressor die of SIGPIPE; that's fine
+		r.cmd.Wait()
+	}
+	return nil
+}
+
+// cmdWriter feeds the stdin of a compressor process.
+type cmdWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	path   string
+}
+
+func startWriter(cmd *exec.Cmd, path string) (*cmdWriter, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: starting %s: %v", path, cmd.Path, err)
+	}
+	return &cmdWriter{stdin, cmd, stderr, path}, nil
+}
+
+func (w *cmdWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if werr := w.cmd.Wait(); werr != nil {
+		msg := strings.TrimSpace(w.stderr.String())
+		if msg == "" {
+			msg = werr.Error()
+		}
+		return fmt.Errorf("%s: %s failed: %s", w.path, w.cmd.Args[0], msg)
+	}
+	return err
+}
+
+// CountLines returns the number of lines in path, decompressing it if
+// needed.
+func CountLines(path string) (int, error) {
+	r, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	buf := make([]byte, 64*1024)
+	count := 0
+	for {
+		n, err := r.Read(buf)
+		count += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
@@ -1,438 +1,134 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"slices"
 	"sort"
-	"sync"
+	"strings"
+
+	"hexagon_clink/pkg/coordfile"
+	"hexagon_clink/pkg/graph6"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/oeis"
+	"hexagon_clink/pkg/polyiamond"
+	"hexagon_clink/pkg/wlcanon"
+	"hexagon_clink/pkg/zfile"
 )
 
-// Vertex in triangular lattice (a, b) coordinates
-type Vertex struct {
-	A, B int
+// shapeFile is the header of a file of polyiamonds of one size, written by
+// -save and read by -resume. Pruned sets only hold the shapes that can
+// still reach V vertices and E edges within Limit triangles.
+type shapeFile struct {
+	Size, Count int
+	Pruned      bool
+	V, E, Limit int
 }
 
-// Triangle represented by 3 vertices (sorted for canonical form)
-type Triangle [3]Vertex
-
-// Polyiamond is a set of triangles
-type Polyiamond struct {
-	Triangles []Triangle
-}
-
-func makeTriangle(v1, v2, v3 Vertex) Triangle {
-	verts := []Vertex{v1, v2, v3}
-	sort.Slice(verts, func(i, j int) bool {
-		if verts[i].A != verts[j].A {
-			return verts[i].A < verts[j].A
-		}
-		return verts[i].B < verts[j].B
-	})
-	return Triangle{verts[0], verts[1], verts[2]}
-}
-
-func rotateVertex60(v Vertex) Vertex {
-	return Vertex{-v.B, v.A + v.B}
-}
-
-func reflectVertex(v Vertex) Vertex {
-	return Vertex{v.A + v.B, -v.B}
-}
-
-func transformTriangle(t Triangle, rotate int, reflect bool) Triangle {
-	verts := []Vertex{t[0], t[1], t[2]}
-
-	if reflect {
-		for i := range verts {
-			verts[i] = reflectVertex(verts[i])
-		}
-	}
-
-	for r := 0; r < rotate%6; r++ {
-		for i := range verts {
-			verts[i] = rotateVertex60(verts[i])
-		}
-	}
-
-	return makeTriangle(verts[0], verts[1], verts[2])
-}
-
-func normalizePolyiamond(p Polyiamond) Polyiamond {
-	if len(p.Triangles) == 0 {
-		return p
-	}
-
-	// Find min a, b across all vertices
-	minA, minB := 1000000, 1000000
-	for _, t := range p.Triangles {
-		for _, v := range t {
-			if v.A < minA {
-				minA = v.A
-			}
-			if v.B < minB {
-				minB = v.B
-			}
-		}
-	}
-
-	// Translate
-	result := Polyiamond{Triangles: make([]Triangle, len(p.Triangles))}
-	for i, t := range p.Triangles {
-		result.Triangles[i] = makeTriangle(
-			Vertex{t[0].A - minA, t[0].B - minB},
-			Vertex{t[1].A - minA, t[1].B - minB},
-			Vertex{t[2].A - minA, t[2].B - minB},
-		)
-	}
-
-	// Sort triangles for canonical ordering
-	sort.Slice(result.Triangles, func(i, j int) bool {
-		for k := 0; k < 3; k++ {
-			if result.Triangles[i][k].A != result.Triangles[j][k].A {
-				return result.Triangles[i][k].A < result.Triangles[j][k].A
-			}
-			if result.Triangles[i][k].B != result.Triangles[j][k].B {
-				return result.Triangles[i][k].B < result.Triangles[j][k].B
-			}
-		}
-		return false
-	})
-
-	return result
-}
-
-func transformPolyiamond(p Polyiamond, rotate int, reflect bool) Polyiamond {
-	result := Polyiamond{Triangles: make([]Triangle, len(p.Triangles))}
-	for i, t := range p.Triangles {
-		result.Triangles[i] = transformTriangle(t, rotate, reflect)
-	}
-	return normalizePolyiamond(result)
-}
-
-func polyiamondKey(p Polyiamond) string {
-	var key string
-	for _, t := range p.Triangles {
-		key += fmt.Sprintf("%d,%d,%d,%d,%d,%d;", t[0].A, t[0].B, t[1].A, t[1].B, t[2].A, t[2].B)
-	}
-	return key
-}
-
-func comparePolyiamonds(a, b Polyiamond) int {
-	for i := 0; i < len(a.Triangles) && i < len(b.Triangles); i++ {
-		for k := 0; k < 3; k++ {
-			if a.Triangles[i][k].A != b.Triangles[i][k].A {
-				return a.Triangles[i][k].A - b.Triangles[i][k].A
-			}
-			if a.Triangles[i][k].B != b.Triangles[i][k].B {
-				return a.Triangles[i][k].B - b.Triangles[i][k].B
-			}
-		}
-	}
-	return len(a.Triangles) - len(b.Triangles)
-}
-
-func canonicalize(p Polyiamond) Polyiamond {
-	best := normalizePolyiamond(p)
-
-	for rot := 0; rot < 6; rot++ {
-		for _, refl := range []bool{false, true} {
-			candidate := transformPolyiamond(p, rot, refl)
-			if comparePolyiamonds(candidate, best) < 0 {
-				best = candidate
+// writeShapes writes the shapes after a header line, one shape per
+// line as its triangles' vertex coordinates:
+//
+//	# polyiamonds size 12 count 3334 [pruned v 13 e 26 limit 14]
+//	0,0,0,1,1,0 0,1,1,0,1,1 ...
+func writeShapes(path string, h shapeFile, shapes []polyiamond.Polyiamond) error {
+	f, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# polyiamonds size %d count %d", h.Size, len(shapes))
+	if h.Pruned {
+		fmt.Fprintf(w, " pruned v %d e %d limit %d", h.V, h.E, h.Limit)
+	}
+	fmt.Fprintln(w)
+	for _, p := range shapes {
+		for i, t := range p.Triangles {
+			if i > 0 {
+				w.WriteByte(' ')
 			}
+			fmt.Fprintf(w, "%d,%d,%d,%d,%d,%d", t[0].A, t[0].B, t[1].A, t[1].B, t[2].A, t[2].B)
 		}
+		w.WriteByte('\n')
 	}
-
-	return best
-}
-
-func getAdjacentTriangles(t Triangle) []Triangle {
-	verts := []Vertex{t[0], t[1], t[2]}
-	neighbors := make([]Triangle, 0, 3)
-
-	for i := 0; i < 3; i++ {
-		v1, v2 := verts[i], verts[(i+1)%3]
-		v3 := verts[(i+2)%3]
-
-		// Fourth vertex completing parallelogram
-		v4 := Vertex{v1.A + v2.A - v3.A, v1.B + v2.B - v3.B}
-		neighbors = append(neighbors, makeTriangle(v1, v2, v4))
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-
-	return neighbors
+	return err
 }
 
-func polyiamondContains(p Polyiamond, t Triangle) bool {
-	for _, tri := range p.Triangles {
-		if tri == t {
-			return true
-		}
-	}
-	return false
-}
-
-func getBoundary(p Polyiamond) []Triangle {
-	seen := make(map[Triangle]bool)
-	for _, t := range p.Triangles {
-		for _, neighbor := range getAdjacentTriangles(t) {
-			if !polyiamondContains(p, neighbor) {
-				seen[neighbor] = true
+// readShapes reads a file written by writeShapes.
+func readShapes(path string) (shapeFile, []polyiamond.Polyiamond, error) {
+	var h shapeFile
+	f, err := zfile.Open(path)
+	if err != nil {
+		return h, nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<24)
+	if !scanner.Scan() {
+		return h, nil, fmt.Errorf("%s: empty file", path)
+	}
+	header := scanner.Text()
+	if _, err := fmt.Sscanf(header, "# polyiamonds size %d count %d", &h.Size, &h.Count); err != nil {
+		return h, nil, fmt.Errorf("%s: not a polyiamond file (%q)", path, header)
+	}
+	if i := strings.Index(header, " pruned "); i >= 0 {
+		h.Pruned = true
+		if _, err := fmt.Sscanf(header[i:], " pruned v %d e %d limit %d", &h.V, &h.E, &h.Limit); err != nil {
+			return h, nil, fmt.Errorf("%s: bad header %q", path, header)
+		}
+	}
+	shapes := make([]polyiamond.Polyiamond, 0, h.Count)
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != h.Size {
+			return h, nil, fmt.Errorf("%s:%d: %d triangles, want %d", path, line, len(fields), h.Size)
+		}
+		p := polyiamond.Polyiamond{Triangles: make([]polyiamond.Triangle, len(fields))}
+		for i, field := range fields {
+			var c [6]int
+			if _, err := fmt.Sscanf(field, "%d,%d,%d,%d,%d,%d", &c[0], &c[1], &c[2], &c[3], &c[4], &c[5]); err != nil {
+				return h, nil, fmt.Errorf("%s:%d: bad triangle %q", path, line, field)
 			}
+			p.Triangles[i] = polyiamond.MakeTriangle(polyiamond.Vertex{A: c[0], B: c[1]}, polyiamond.Vertex{A: c[2], B: c[3]}, polyiamond.Vertex{A: c[4], B: c[5]})
 		}
+		shapes = append(shapes, p)
 	}
-
-	result := make([]Triangle, 0, len(seen))
-	for t := range seen {
-		result = append(result, t)
-	}
-	return result
-}
-
-func addTriangle(p Polyiamond, t Triangle) Polyiamond {
-	newTris := make([]Triangle, len(p.Triangles)+1)
-	copy(newTris, p.Triangles)
-	newTris[len(p.Triangles)] = t
-	return Polyiamond{Triangles: newTris}
-}
-
-func enumeratePolyiamonds(n int, workers int) []Polyiamond {
-	if n < 1 {
-		return nil
-	}
-
-	// Initial triangle
-	initial := Polyiamond{
-		Triangles: []Triangle{
-			makeTriangle(Vertex{0, 0}, Vertex{1, 0}, Vertex{0, 1}),
-		},
+	if err := scanner.Err(); err != nil {
+		return h, nil, fmt.Errorf("%s: %v", path, err)
 	}
-
-	if n == 1 {
-		return []Polyiamond{canonicalize(initial)}
-	}
-
-	current := map[string]Polyiamond{
-		polyiamondKey(canonicalize(initial)): canonicalize(initial),
-	}
-
-	for size := 2; size <= n; size++ {
-		fmt.Printf("  Size %d: processing %d shapes...\n", size, len(current))
-
-		shapes := make([]Polyiamond, 0, len(current))
-		for _, p := range current {
-			shapes = append(shapes, p)
-		}
-
-		// Parallel processing
-		var mu sync.Mutex
-		next := make(map[string]Polyiamond)
-
-		var wg sync.WaitGroup
-		chunkSize := (len(shapes) + workers - 1) / workers
-
-		for w := 0; w < workers; w++ {
-			start := w * chunkSize
-			end := start + chunkSize
-			if end > len(shapes) {
-				end = len(shapes)
-			}
-			if start >= len(shapes) {
-				break
-			}
-
-			wg.Add(1)
-			go func(chunk []Polyiamond) {
-				defer wg.Done()
-				localNext := make(map[string]Polyiamond)
-
-				for _, shape := range chunk {
-					for _, newTri := range getBoundary(shape) {
-						newShape := addTriangle(shape, newTri)
-						canon := canonicalize(newShape)
-						key := polyiamondKey(canon)
-						localNext[key] = canon
-					}
-				}
-
-				mu.Lock()
-				for k, v := range localNext {
-					next[k] = v
-				}
-				mu.Unlock()
-			}(shapes[start:end])
-		}
-
-		wg.Wait()
-		current = next
-	}
-
-	result := make([]Polyiamond, 0, len(current))
-	for _, p := range current {
-		result = append(result, p)
-	}
-	return result
-}
-
-func polyiamondToGraph(p Polyiamond) (int, int) {
-	vertices := make(map[Vertex]bool)
-	edges := make(map[[2]Vertex]bool)
-
-	for _, t := range p.Triangles {
-		for _, v := range t {
-			vertices[v] = true
-		}
-		for i := 0; i < 3; i++ {
-			v1, v2 := t[i], t[(i+1)%3]
-			if v1.A > v2.A || (v1.A == v2.A && v1.B > v2.B) {
-				v1, v2 = v2, v1
-			}
-			edges[[2]Vertex{v1, v2}] = true
-		}
+	if len(shapes) != h.Count {
+		return h, nil, fmt.Errorf("%s: %d shapes, header says %d", path, len(shapes), h.Count)
 	}
-
-	return len(vertices), len(edges)
+	return h, shapes, nil
 }
 
-func polyiamondToCoords(p Polyiamond) ([]Vertex, [][2]int) {
-	// Collect vertices and edges
-	vertexSet := make(map[Vertex]bool)
-	edgeSet := make(map[[2]Vertex]bool)
-
-	for _, t := range p.Triangles {
-		for _, v := range t {
-			vertexSet[v] = true
-		}
-		for i := 0; i < 3; i++ {
-			v1, v2 := t[i], t[(i+1)%3]
-			if v1.A > v2.A || (v1.A == v2.A && v1.B > v2.B) {
-				v1, v2 = v2, v1
-			}
-			edgeSet[[2]Vertex{v1, v2}] = true
-		}
-	}
-
-	// Create sorted vertex list
-	vertices := make([]Vertex, 0, len(vertexSet))
-	for v := range vertexSet {
-		vertices = append(vertices, v)
-	}
-	sort.Slice(vertices, func(i, j int) bool {
-		if vertices[i].A != vertices[j].A {
-			return vertices[i].A < vertices[j].A
-		}
-		return vertices[i].B < vertices[j].B
-	})
-
-	// Map vertices to indices
-	vertexIdx := make(map[Vertex]int)
-	for i, v := range vertices {
-		vertexIdx[v] = i
-	}
-
-	// Build edge list with indices
-	edges := make([][2]int, 0, len(edgeSet))
-	for e := range edgeSet {
-		edges = append(edges, [2]int{vertexIdx[e[0]], vertexIdx[e[1]]})
-	}
-
-	return vertices, edges
+func polyiamondToGraph6(p polyiamond.Polyiamond) string {
+	vertices, edges := polyiamond.Graph(p)
+	return graph6.EncodeEdges(len(vertices), edges)
 }
 
-func polyiamondToGraph6(p Polyiamond) string {
-	// Collect vertices and edges
-	vertexSet := make(map[Vertex]bool)
-	edgeSet := make(map[[2]Vertex]bool)
-
-	for _, t := range p.Triangles {
-		for _, v := range t {
-			vertexSet[v] = true
-		}
-		for i := 0; i < 3; i++ {
-			v1, v2 := t[i], t[(i+1)%3]
-			if v1.A > v2.A || (v1.A == v2.A && v1.B > v2.B) {
-				v1, v2 = v2, v1
-			}
-			edgeSet[[2]Vertex{v1, v2}] = true
-		}
-	}
-
-	// Create sorted vertex list for consistent indexing
-	vertices := make([]Vertex, 0, len(vertexSet))
-	for v := range vertexSet {
-		vertices = append(vertices, v)
-	}
-	sort.Slice(vertices, func(i, j int) bool {
-		if vertices[i].A != vertices[j].A {
-			return vertices[i].A < vertices[j].A
-		}
-		return vertices[i].B < vertices[j].B
-	})
-
-	// Map vertices to indices
-	vertexIdx := make(map[Vertex]int)
-	for i, v := range vertices {
-		vertexIdx[v] = i
-	}
-
-	n := len(vertices)
-
-	// Build adjacency matrix (upper triangle)
-	adj := make([][]bool, n)
-	for i := range adj {
-		adj[i] = make([]bool, n)
-	}
-	for e := range edgeSet {
-		i, j := vertexIdx[e[0]], vertexIdx[e[1]]
-		if i > j {
-			i, j = j, i
-		}
-		adj[i][j] = true
-	}
-
-	// Encode in graph6 format
-	var result []byte
-
-	// Encode n (assuming n <= 62)
-	if n <= 62 {
-		result = append(result, byte(n+63))
-	} else {
-		// For larger n, use extended encoding
-		result = append(result, 126)
-		result = append(result, byte((n>>12)+63))
-		result = append(result, byte(((n>>6)&63)+63))
-		result = append(result, byte((n&63)+63))
-	}
-
-	// Encode upper triangle bits
-	var bits []bool
-	for j := 1; j < n; j++ {
-		for i := 0; i < j; i++ {
-			bits = append(bits, adj[i][j])
-		}
-	}
-
-	// Pad to multiple of 6
-	for len(bits)%6 != 0 {
-		bits = append(bits, false)
-	}
-
-	// Convert to characters
-	for i := 0; i < len(bits); i += 6 {
-		var val byte = 0
-		for j := 0; j < 6; j++ {
-			if bits[i+j] {
-				val |= 1 << (5 - j)
-			}
-		}
-		result = append(result, val+63)
-	}
-
-	return string(result)
+// canonicalKey is the graph6 form of the canonically labeled contact
+// graph, equal for two polyiamonds exactly when their graphs are
+// isomorphic, which different shapes often are.
+func canonicalKey(p polyiamond.Polyiamond) string {
+	vertices, edges := polyiamond.Graph(p)
+	g := invariants.New(len(vertices))
+	for _, e := range edges {
+		g.AddEdge(e[0], e[1])
+	}
+	cg := wlcanon.CanonicalGraph(g)
+	return graph6.Encode(cg.N, cg.HasEdge)
 }
 
-func printPolyiamond(p Polyiamond, idx int, nTri int) {
+func printPolyiamond(p polyiamond.Polyiamond, idx int, nTri int) {
 	fmt.Printf("--- Polyiamond %d (%d triangles) ---\n", idx, nTri)
 
 	// Find bounds
@@ -456,8 +152,8 @@ func printPolyiamond(p Polyiamond, idx int, nTri int) {
 
 	// Determine triangle orientation and position
 	type TriPos struct {
-		q, r   int
-		isUp   bool
+		q, r int
+		isUp bool
 	}
 
 	triPositions := make([]TriPos, 0, len(p.Triangles))
@@ -549,34 +245,117 @@ func main() {
 	showShapes := flag.Bool("show", false, "Show matching shapes")
 	g6Output := flag.String("g6", "", "Output matching graphs to this .g6 file")
 	coordOutput := flag.String("coords", "", "Output vertex coordinates to this file (for plotting)")
+	sequence := flag.Bool("sequence", false, "Print the counts per triangle count as comma-separated sequences")
+	checkOEIS := flag.Bool("oeis", false, "With -sequence: compare the polyiamond counts with OEIS A000577 and exit 1 on a mismatch")
+	prune := flag.Bool("prune", true, "Drop shapes during growth that can no longer reach -v/-e (off with -sequence, which needs every polyiamond)")
+	saveFile := flag.String("save", "", "Write the shapes of the largest size grown to this file (optionally .gz/.zst), for -resume")
+	resumeFile := flag.String("resume", "", "Grow from the shapes in this -save file instead of from a single triangle")
+	cacheSize := flag.Int("cache", 1<<20, "Grown shapes remembered to skip canonicalizing them again (0 = no cache)")
+	jsonOut := flag.Bool("json", false, "Write events (start, one per triangle count, result) as JSON lines on stdout; text goes to stderr")
 	flag.Parse()
+	events := jsonl.Start("polyiamond_enum", *jsonOut)
+
+	if (*g6Output != "" || *coordOutput != "") && *targetV > invariants.MaxN {
+		fmt.Fprintf(os.Stderr, "Error: -g6 and -coords deduplicate graphs of at most %d vertices, -v is %d\n", invariants.MaxN, *targetV)
+		os.Exit(1)
+	}
 
 	if *workers == 0 {
 		*workers = runtime.NumCPU()
 	}
+	run := manifest.Start("polyiamond_enum", nil)
+	wrote := func(path string) {
+		if err := run.Wrote(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Printf("Searching for polyiamonds with %d vertices and %d edges\n", *targetV, *targetE)
 	fmt.Printf("Triangle range: %d to %d, workers: %d\n\n", *minTri, *maxTri, *workers)
+	events.Emit("start", jsonl.Fields{"v": *targetV, "e": *targetE, "min": *minTri, "max": *maxTri, "workers": *workers,
+		"prune": *prune && !*sequence, "resume": *resumeFile})
 
 	total := 0
+	var shapeCounts, matchCounts []int64
 	var allMatches []struct {
-		p    Polyiamond
+		p    polyiamond.Polyiamond
 		nTri int
 	}
 
-	for nTri := *minTri; nTri <= *maxTri; nTri++ {
+	// Vertices and edges only grow as triangles are added, and every
+	// polyiamond can be built through smaller ones, so a shape that has
+	// passed the targets, or can't reach them with the triangles left (each
+	// adds at most 1 vertex and 2 edges), has no matching extension. By
+	// Euler's formula a polyiamond with T triangles and h holes has
+	// T = E - V + 1 - h, so none above E - V + 1 triangles matches. The
+	// counts of -sequence need every polyiamond, so it doesn't prune.
+	limit := *maxTri
+	var keep func(size int, p polyiamond.Polyiamond) bool
+	if *prune && !*sequence {
+		limit = min(limit, *targetE-*targetV+1)
+		keep = func(size int, p polyiamond.Polyiamond) bool {
+			v, e := polyiamond.Size(p)
+			left := limit - size
+			return v <= *targetV && e <= *targetE && v+left >= *targetV && e+2*left >= *targetE
+		}
+		fmt.Printf("Pruning shapes that can't reach the targets (at most %d triangles)\n\n", limit)
+	}
+
+	// A set saved with pruning lacks the shapes those targets didn't need,
+	// so it only continues a run with the same targets
+	first := *minTri
+	var resumed []polyiamond.Polyiamond
+	resumeSize := 0
+	if *resumeFile != "" {
+		h, shapes, err := readShapes(*resumeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if h.Pruned && (keep == nil || h.V != *targetV || h.E != *targetE || h.Limit != limit) {
+			fmt.Fprintf(os.Stderr, "Error: %s was pruned for v=%d e=%d and at most %d triangles; resume it with the same targets and pruning on\n", *resumeFile, h.V, h.E, h.Limit)
+			os.Exit(1)
+		}
+		if h.Size > limit {
+			fmt.Fprintf(os.Stderr, "Error: %s holds shapes with %d triangles, more than the %d to grow to\n", *resumeFile, h.Size, limit)
+			os.Exit(1)
+		}
+		if keep != nil && !h.Pruned {
+			shapes = slices.DeleteFunc(shapes, func(p polyiamond.Polyiamond) bool { return !keep(h.Size, p) })
+		}
+		fmt.Printf("Resuming from %d polyiamonds with %d triangles (%s)\n\n", len(shapes), h.Size, *resumeFile)
+		if err := run.Input(*resumeFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		resumed, resumeSize = shapes, h.Size
+		first = max(first, h.Size)
+	}
+
+	cache := polyiamond.NewCache(*cacheSize)
+	lastSize := 0
+	var last []polyiamond.Polyiamond
+	level := func(nTri int, shapes []polyiamond.Polyiamond) {
+		lastSize, last = nTri, shapes
+		if nTri < first {
+			return
+		}
 		fmt.Printf("n=%d triangles:\n", nTri)
-		shapes := enumeratePolyiamonds(nTri, *workers)
-		fmt.Printf("  Found %d polyiamonds\n", len(shapes))
+		if keep != nil {
+			fmt.Printf("  Kept %d polyiamonds\n", len(shapes))
+		} else {
+			fmt.Printf("  Found %d polyiamonds\n", len(shapes))
+		}
 
 		count := 0
 		for _, p := range shapes {
-			v, e := polyiamondToGraph(p)
+			v, e := polyiamond.Size(p)
 			if v == *targetV && e == *targetE {
 				count++
 				if *showShapes || *g6Output != "" || *coordOutput != "" {
 					allMatches = append(allMatches, struct {
-						p    Polyiamond
+						p    polyiamond.Polyiamond
 						nTri int
 					}{p, nTri})
 				}
@@ -584,10 +363,52 @@ func main() {
 		}
 
 		fmt.Printf("  Matches (%d vertices, %d edges): %d\n\n", *targetV, *targetE, count)
+		events.Emit("level", jsonl.Fields{"triangles": nTri, "polyiamonds": len(shapes), "pruned": keep != nil, "matches": count})
 		total += count
+		shapeCounts = append(shapeCounts, int64(len(shapes)))
+		matchCounts = append(matchCounts, int64(count))
+	}
+	if *resumeFile != "" {
+		polyiamond.Resume(resumed, resumeSize, limit, *workers, keep, cache, level)
+	} else {
+		polyiamond.Enumerate(limit, *workers, keep, cache, level)
+	}
+	if from := max(limit+1, first); from < *maxTri {
+		fmt.Printf("n=%d..%d triangles: skipped, a match has at most e-v+1 = %d\n\n", from, *maxTri, limit)
+	} else if from == *maxTri {
+		fmt.Printf("n=%d triangles: skipped, a match has at most e-v+1 = %d\n\n", from, limit)
 	}
 
 	fmt.Printf("Total: %d\n", total)
+	if cache != nil {
+		hits, lookups := cache.Stats()
+		fmt.Printf("Canonical form cache: %d hits of %d lookups (%.1f%%)\n", hits, lookups, 100*float64(hits)/float64(max(lookups, 1)))
+	}
+
+	if *saveFile != "" && lastSize > 0 {
+		h := shapeFile{Size: lastSize, Pruned: keep != nil, V: *targetV, E: *targetE, Limit: limit}
+		if err := writeShapes(*saveFile, h, last); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *saveFile, err)
+			os.Exit(1)
+		}
+		wrote(*saveFile)
+		fmt.Printf("Saved %d polyiamonds with %d triangles to %s\n", len(last), lastSize, *saveFile)
+	}
+
+	oeisOK := true
+	if *sequence {
+		fmt.Printf("\nPolyiamonds by triangles (%d..%d): %s\n", first, *maxTri, oeis.Format(shapeCounts))
+		fmt.Printf("Matches (%d vertices, %d edges) by triangles (%d..%d): %s\n", *targetV, *targetE, first, *maxTri, oeis.Format(matchCounts))
+		if *checkOEIS {
+			lines, ok := oeis.Report("A000577", first, shapeCounts)
+			for _, l := range lines {
+				fmt.Println("  " + l)
+			}
+			if oeisOK = ok; !ok {
+				defer os.Exit(1) // after the output files below are closed
+			}
+		}
+	}
 
 	if *showShapes && len(allMatches) > 0 {
 		fmt.Printf("\n=== Matching shapes ===\n\n")
@@ -596,7 +417,20 @@ func main() {
 		}
 	}
 
-	if *g6Output != "" && len(allMatches) > 0 {
+	// The graph outputs hold one graph per isomorphism class
+	var unique []polyiamond.Polyiamond
+	if *g6Output != "" || *coordOutput != "" {
+		seen := make(map[string]bool)
+		for _, m := range allMatches {
+			key := canonicalKey(m.p)
+			if !seen[key] {
+				seen[key] = true
+				unique = append(unique, m.p)
+			}
+		}
+	}
+
+	if *g6Output != "" && len(unique) > 0 {
 		f, err := os.Create(*g6Output)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
@@ -604,14 +438,15 @@ func main() {
 		}
 		defer f.Close()
 
-		for _, m := range allMatches {
-			g6 := polyiamondToGraph6(m.p)
+		for _, p := range unique {
+			g6 := polyiamondToGraph6(p)
 			fmt.Fprintln(f, g6)
 		}
-		fmt.Printf("\nWrote %d graphs to %s\n", len(allMatches), *g6Output)
+		wrote(*g6Output)
+		fmt.Printf("\nWrote %d unique graphs (of %d matches) to %s\n", len(unique), len(allMatches), *g6Output)
 	}
 
-	if *coordOutput != "" && len(allMatches) > 0 {
+	if *coordOutput != "" && len(unique) > 0 {
 		f, err := os.Create(*coordOutput)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
@@ -619,31 +454,30 @@ func main() {
 		}
 		defer f.Close()
 
-		// Deduplicate by edge signature
-		seen := make(map[string]bool)
-		graphIdx := 0
-
-		for _, m := range allMatches {
-			verts, edges := polyiamondToCoords(m.p)
-
-			// Create signature for dedup
-			sig := fmt.Sprintf("%v", edges)
-			if seen[sig] {
-				continue
-			}
-			seen[sig] = true
-
-			graphIdx++
-			fmt.Fprintf(f, "GRAPH %d\n", graphIdx)
-			fmt.Fprintf(f, "VERTICES %d\n", len(verts))
+		for i, p := range unique {
+			verts, edges := polyiamond.Graph(p)
+			g := coordfile.Graph{Index: i + 1, Lattice: true, Edges: edges}
 			for _, v := range verts {
-				fmt.Fprintf(f, "%d %d\n", v.A, v.B)
+				g.Pos = append(g.Pos, [2]float64{float64(v.A), float64(v.B)})
 			}
-			fmt.Fprintf(f, "EDGES %d\n", len(edges))
-			for _, e := range edges {
-				fmt.Fprintf(f, "%d %d\n", e[0], e[1])
+			if err := coordfile.Write(f, g); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *coordOutput, err)
+				os.Exit(1)
 			}
 		}
-		fmt.Printf("Wrote %d unique graphs to %s\n", graphIdx, *coordOutput)
+		wrote(*coordOutput)
+		fmt.Printf("Wrote %d unique graphs to %s\n", len(unique), *coordOutput)
+	}
+	result := jsonl.Fields{"matches": total, "by_triangles": matchCounts, "first": first,
+		"g6": *g6Output, "coords": *coordOutput, "save": *saveFile}
+	if unique != nil {
+		result["unique"] = len(unique)
+	}
+	if *sequence {
+		result["polyiamonds_by_triangles"] = shapeCounts
+		if *checkOEIS {
+			result["oeis_ok"] = oeisOK
+		}
 	}
+	events.Emit("result", result)
 }
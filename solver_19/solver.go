@@ -5,11 +5,19 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/pairs"
 )
 
 var hexDirs = [6][2]float64{
@@ -86,13 +94,14 @@ type Solver struct {
 	edges         []Edge
 	slotAdj       [][]int
 	remEdges      []int
-	pairTable     [][]int
+	pairs         *cover.Table
 	maxOverlapArr []int // per-level overlap limits, nil means use dynamic calculation
+	log           *logging.Logger
 
-	solution      [][]int
-	found         int32
-	printedLevel  []int32 // track if we've printed first solution at each level
-	mu            sync.Mutex
+	solution     [][]int
+	found        int32
+	printedLevel []int32 // track if we've printed first solution at each level
+	mu           sync.Mutex
 }
 
 func NewSolver(n, k int) *Solver {
@@ -118,41 +127,29 @@ func NewSolver(n, k int) *Solver {
 		}
 	}
 
-	pairTable := make([][]int, n)
-	for a := 0; a < n; a++ {
-		pairTable[a] = make([]int, n)
-		for b := 0; b < n; b++ {
-			if a < b {
-				pairTable[a][b] = a*n - a*(a+1)/2 + (b - a - 1)
-			} else if b < a {
-				pairTable[a][b] = b*n - b*(b+1)/2 + (a - b - 1)
-			}
-		}
-	}
-
 	return &Solver{
 		n:            n,
 		k:            k,
-		numPairs:     n * (n - 1) / 2,
+		numPairs:     pairs.Count(n),
 		numEdges:     len(edges),
 		edges:        edges,
 		slotAdj:      slotAdj,
 		remEdges:     remEdges,
-		pairTable:    pairTable,
+		pairs:        cover.NewTable(n),
 		solution:     make([][]int, k),
 		printedLevel: make([]int32, k),
 	}
 }
 
 func (s *Solver) pairIndex(a, b int) int {
-	return s.pairTable[a][b]
+	return s.pairs.Index(a, b)
 }
 
 func (s *Solver) SetMaxOverlap(limits []int) {
 	s.maxOverlapArr = limits
 }
 
-func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [][]int, rng *rand.Rand) {
+func (s *Solver) solve(level int, covered pairs.Set, coveredCount int, parentArrs [][]int, rng *rand.Rand, log *logging.Logger) {
 	if atomic.LoadInt32(&s.found) != 0 {
 		return
 	}
@@ -176,8 +173,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 	arr := make([]int, s.n)
 	used := make([]bool, s.n)
 	usedItems := make([]int, 0, s.n)
-	coveredSet := make([]bool, s.numPairs)
-	copy(coveredSet, covered)
+	coveredSet := covered.Clone()
 
 	order := make([]int, s.n)
 	for i := 0; i < s.n; i++ {
@@ -200,16 +196,19 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 		if slot == s.n {
 			arrCopy := make([]int, s.n)
 			copy(arrCopy, arr)
-			coveredCopy := make([]bool, s.numPairs)
-			copy(coveredCopy, coveredSet)
+			coveredCopy := coveredSet.Clone()
 
 			newParentArrs := append(parentArrs, arrCopy)
 
 			// Print first valid arrangement at this level
 			if atomic.CompareAndSwapInt32(&s.printedLevel[level], 0, 1) {
 				newEdges := localCovered - coveredCount
-				fmt.Printf("First valid arr%d: %v (overlap=%d, new=%d, covered=%d/%d)\n",
+				log.Printf("First valid arr%d: %v (overlap=%d, new=%d, covered=%d/%d)",
 					level+1, arrCopy, s.numEdges-newEdges, newEdges, localCovered, s.numPairs)
+				events.Emit("level", jsonl.Fields{
+					"level": level + 1, "arrangement": arrCopy, "overlap": s.numEdges - newEdges,
+					"new": newEdges, "covered": localCovered, "pairs": s.numPairs,
+				})
 			}
 
 			if level == s.k-2 {
@@ -224,7 +223,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 					s.mu.Unlock()
 				}
 			} else {
-				s.solve(level+1, coveredCopy, localCovered, newParentArrs, rng)
+				s.solve(level+1, coveredCopy, localCovered, newParentArrs, rng, log)
 			}
 			return
 		}
@@ -247,7 +246,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			for _, adjSlot := range s.slotAdj[slot] {
 				adjItem := arr[adjSlot]
 				pi := s.pairIndex(item, adjItem)
-				if coveredSet[pi] {
+				if coveredSet.Has(pi) {
 					newOverlap++
 				} else {
 					newPairs = append(newPairs, pi)
@@ -262,7 +261,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 				doomed := false
 				for _, other := range usedItems {
 					pi := s.pairIndex(item, other)
-					if coveredSet[pi] {
+					if coveredSet.Has(pi) {
 						continue
 					}
 					found := false
@@ -286,7 +285,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			used[item] = true
 			usedItems = append(usedItems, item)
 			for _, pi := range newPairs {
-				coveredSet[pi] = true
+				coveredSet.Add(pi)
 			}
 
 			enumerate(slot+1, overlap+newOverlap, localCovered+len(newPairs))
@@ -294,7 +293,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			used[item] = false
 			usedItems = usedItems[:len(usedItems)-1]
 			for _, pi := range newPairs {
-				coveredSet[pi] = false
+				coveredSet.Remove(pi)
 			}
 		}
 	}
@@ -309,12 +308,11 @@ func (s *Solver) Solve(numWorkers int) bool {
 	}
 	s.solution[0] = arr0
 
-	covered := make([]bool, s.numPairs)
+	covered := pairs.NewSet(s.numPairs)
 	coveredCount := 0
 	for _, e := range s.edges {
-		pi := s.pairIndex(e.a, e.b)
-		if !covered[pi] {
-			covered[pi] = true
+		if pi := s.pairIndex(e.a, e.b); !covered.Has(pi) {
+			covered.Add(pi)
 			coveredCount++
 		}
 	}
@@ -326,11 +324,14 @@ func (s *Solver) Solve(numWorkers int) bool {
 	var wg sync.WaitGroup
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
-		go func(seed int64) {
+		go func(w int, seed int64) {
 			defer wg.Done()
 			rng := rand.New(rand.NewSource(seed))
-			s.solve(0, covered, coveredCount, nil, rng)
-		}(time.Now().UnixNano() + int64(w)*12345)
+			log := s.log.Worker(w)
+			log.Verbosef("started, seed %d", seed)
+			s.solve(0, covered, coveredCount, nil, rng, log)
+			log.Verbosef("finished")
+		}(w, time.Now().UnixNano()+int64(w)*12345)
 	}
 	wg.Wait()
 
@@ -363,14 +364,30 @@ var item0AllowedSlots = map[int]bool{0: true, 1: true, 7: true, 8: true}
 const n = 19
 const k = 5
 
+// events receives the -json events (nil: off).
+var events *jsonl.Emitter
+
 func main() {
 	workers := flag.Int("workers", 8, "Number of parallel workers")
 	maxOverlap := flag.String("max-overlap", "0,0,12", "Comma-separated max overlap per level")
+	outPath := flag.String("out", "", "write the solution to this arrangement file (pkg/arrangement)")
+	logLevel := flag.String("log-level", "normal", "progress lines to print: quiet, normal, verbose or debug (see pkg/logging)")
+	logFile := flag.String("log-file", "", "also append the progress lines to this file")
+	jsonOut := flag.Bool("json", false, "write events (start, levels, solution, result) as JSON lines on stdout; text goes to stderr")
 	flag.Parse()
+	events = jsonl.Start("solver_19", *jsonOut)
+	logger, err := logging.Start(*logLevel, *logFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+	run := manifest.Start("solver_19", nil)
 
 	fmt.Printf("Searching for %d arrangements of %d items (hexagonal symmetry)\n", k, n)
 
 	solver := NewSolver(n, k)
+	solver.log = logger
 
 	overlapLimits, err := parseOverlapLimits(*maxOverlap)
 	if err != nil {
@@ -387,6 +404,10 @@ func main() {
 		solver.numPairs, solver.numEdges, (solver.numPairs+solver.numEdges-1)/solver.numEdges)
 	fmt.Printf("Item 0 restricted to slots: 0, 1, 7, 8\n")
 	fmt.Printf("Workers: %d\n\n", *workers)
+	events.Emit("start", jsonl.Fields{
+		"n": n, "k": k, "shape": "spiral", "edges": solver.numEdges, "pairs": solver.numPairs,
+		"workers": *workers, "max_overlap": overlapLimits,
+	})
 
 	start := time.Now()
 	found := solver.Solve(*workers)
@@ -397,9 +418,22 @@ func main() {
 		for i, arr := range solver.solution {
 			fmt.Printf("  Arr%d: %v\n", i, arr)
 		}
+		events.Emit("solution", jsonl.Fields{"arrangements": solver.solution})
+		if *outPath != "" {
+			if err := arrangement.WriteFile(*outPath, arrangement.NewSpiral(n, solver.solution, "solver_19")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if err := run.Wrote(*outPath); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Solution written to %s\n", *outPath)
+		}
 	} else {
 		fmt.Println("\nNo solution found.")
 	}
+	events.Emit("result", jsonl.Fields{"found": found, "seconds": elapsed.Seconds()})
 
 	fmt.Printf("\nTime: %v\n", elapsed.Round(time.Millisecond))
 }
@@ -5,11 +5,22 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/budget"
+	"hexagon_clink/pkg/config"
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/coverage"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/pairs"
 )
 
 const (
@@ -86,8 +97,10 @@ type Solver struct {
 	edges         []Edge
 	slotAdj       [][]int // full adjacency for each slot
 	slotDeg       []int   // degree of each slot
-	pairTable     [][]int
+	pairs         *cover.Table
 	maxOverlapArr []int // per-level overlap limits
+	budget        *budget.Budget
+	log           *logging.Logger
 
 	solution     [][]int
 	found        int32
@@ -111,32 +124,20 @@ func NewSolver() *Solver {
 		slotDeg[i] = len(slotAdj[i])
 	}
 
-	pairTable := make([][]int, N)
-	for a := 0; a < N; a++ {
-		pairTable[a] = make([]int, N)
-		for b := 0; b < N; b++ {
-			if a < b {
-				pairTable[a][b] = a*N - a*(a+1)/2 + (b - a - 1)
-			} else if b < a {
-				pairTable[a][b] = b*N - b*(b+1)/2 + (a - b - 1)
-			}
-		}
-	}
-
 	return &Solver{
-		numPairs:     N * (N - 1) / 2,
+		numPairs:     pairs.Count(N),
 		numEdges:     len(edges),
 		edges:        edges,
 		slotAdj:      slotAdj,
 		slotDeg:      slotDeg,
-		pairTable:    pairTable,
+		pairs:        cover.NewTable(N),
 		solution:     make([][]int, K),
 		printedLevel: make([]int32, K),
 	}
 }
 
 func (s *Solver) pairIndex(a, b int) int {
-	return s.pairTable[a][b]
+	return s.pairs.Index(a, b)
 }
 
 func (s *Solver) SetMaxOverlap(limits []int) {
@@ -144,26 +145,34 @@ func (s *Solver) SetMaxOverlap(limits []int) {
 }
 
 // countNeededPartners returns how many uncovered pairs item has with other items
-func (s *Solver) countNeededPartners(item int, coveredSet []bool) int {
+func (s *Solver) countNeededPartners(item int, coveredSet pairs.Set) int {
 	count := 0
 	for other := 0; other < N; other++ {
 		if other == item {
 			continue
 		}
-		pi := s.pairIndex(item, other)
-		if !coveredSet[pi] {
+		if !coveredSet.Has(s.pairIndex(item, other)) {
 			count++
 		}
 	}
 	return count
 }
 
+// Nodes a worker counts before adding them to the budget
+const nodeFlush = 1 << 14
+
+// done reports whether the search should stop: a solution was found or
+// the budget ran out.
+func (s *Solver) done() bool {
+	return atomic.LoadInt32(&s.found) != 0 || s.budget.Stopped()
+}
+
 // Special slot with minimum degree (slot 19 has degree 2)
 const specialSlot = 19
 const specialSlotDegree = 2
 
-func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [][]int, rng *rand.Rand) {
-	if atomic.LoadInt32(&s.found) != 0 {
+func (s *Solver) solve(level int, covered pairs.Set, coveredCount int, parentArrs [][]int, rng *rand.Rand, log *logging.Logger) {
+	if s.done() {
 		return
 	}
 
@@ -188,8 +197,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 	}
 	used := make([]bool, N)
 	filledSlots := make([]int, 0, N)
-	coveredSet := make([]bool, s.numPairs)
-	copy(coveredSet, covered)
+	coveredSet := covered.Clone()
 
 	order := make([]int, N)
 	for i := 0; i < N; i++ {
@@ -217,25 +225,35 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 		}
 	}
 
+	var nodes int64
+	defer func() { s.budget.Add(nodes) }()
+
 	var enumerate func(depth, overlap, localCovered int)
 	enumerate = func(depth, overlap, localCovered int) {
-		if atomic.LoadInt32(&s.found) != 0 {
+		if s.done() {
 			return
 		}
+		if nodes++; nodes == nodeFlush {
+			s.budget.Add(nodes)
+			nodes = 0
+		}
 
 		if depth == N {
 			arrCopy := make([]int, N)
 			copy(arrCopy, arr)
-			coveredCopy := make([]bool, s.numPairs)
-			copy(coveredCopy, coveredSet)
+			coveredCopy := coveredSet.Clone()
 
 			newParentArrs := append(parentArrs, arrCopy)
 
 			count := atomic.AddInt32(&s.printedLevel[level], 1)
 			if count <= 10 {
 				newEdges := localCovered - coveredCount
-				fmt.Printf("[%s] Valid arr%d #%d: %v (overlap=%d, new=%d, covered=%d/%d)\n",
-					time.Now().Format("15:04:05.000"), level+1, count, arrCopy, s.numEdges-newEdges, newEdges, localCovered, s.numPairs)
+				log.Printf("Valid arr%d #%d: %v (overlap=%d, new=%d, covered=%d/%d)",
+					level+1, count, arrCopy, s.numEdges-newEdges, newEdges, localCovered, s.numPairs)
+				events.Emit("level", jsonl.Fields{
+					"level": level + 1, "count": count, "arrangement": arrCopy, "overlap": s.numEdges - newEdges,
+					"new": newEdges, "covered": localCovered, "pairs": s.numPairs,
+				})
 			}
 
 			if level == K-2 {
@@ -250,7 +268,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 					s.mu.Unlock()
 				}
 			} else {
-				s.solve(level+1, coveredCopy, localCovered, newParentArrs, rng)
+				s.solve(level+1, coveredCopy, localCovered, newParentArrs, rng, log)
 			}
 			return
 		}
@@ -279,7 +297,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 		}
 
 		for _, item := range candidates {
-			if atomic.LoadInt32(&s.found) != 0 {
+			if s.done() {
 				return
 			}
 
@@ -292,7 +310,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 				}
 				adjItem := arr[adjSlot]
 				pi := s.pairIndex(item, adjItem)
-				if coveredSet[pi] {
+				if coveredSet.Has(pi) {
 					newOverlap++
 				} else {
 					newPairs = append(newPairs, pi)
@@ -309,7 +327,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 				for _, filledSlot := range filledSlots {
 					other := arr[filledSlot]
 					pi := s.pairIndex(item, other)
-					if coveredSet[pi] {
+					if coveredSet.Has(pi) {
 						continue
 					}
 					// Check if this pair can still be covered
@@ -334,7 +352,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			used[item] = true
 			filledSlots = append(filledSlots, slot)
 			for _, pi := range newPairs {
-				coveredSet[pi] = true
+				coveredSet.Add(pi)
 			}
 
 			enumerate(depth+1, overlap+newOverlap, localCovered+len(newPairs))
@@ -343,7 +361,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			used[item] = false
 			filledSlots = filledSlots[:len(filledSlots)-1]
 			for _, pi := range newPairs {
-				coveredSet[pi] = false
+				coveredSet.Remove(pi)
 			}
 		}
 	}
@@ -358,12 +376,11 @@ func (s *Solver) Solve(numWorkers int) bool {
 	}
 	s.solution[0] = arr0
 
-	covered := make([]bool, s.numPairs)
+	covered := pairs.NewSet(s.numPairs)
 	coveredCount := 0
 	for _, e := range s.edges {
-		pi := s.pairIndex(e.a, e.b)
-		if !covered[pi] {
-			covered[pi] = true
+		if pi := s.pairIndex(e.a, e.b); !covered.Has(pi) {
+			covered.Add(pi)
 			coveredCount++
 		}
 	}
@@ -371,11 +388,14 @@ func (s *Solver) Solve(numWorkers int) bool {
 	var wg sync.WaitGroup
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
-		go func(seed int64) {
+		go func(w int, seed int64) {
 			defer wg.Done()
 			rng := rand.New(rand.NewSource(seed))
-			s.solve(0, covered, coveredCount, nil, rng)
-		}(time.Now().UnixNano() + int64(w)*12345)
+			log := s.log.Worker(w)
+			log.Verbosef("started, seed %d", seed)
+			s.solve(0, covered, coveredCount, nil, rng, log)
+			log.Verbosef("finished")
+		}(w, time.Now().UnixNano()+int64(w)*12345)
 	}
 	wg.Wait()
 
@@ -398,14 +418,73 @@ func parseOverlapLimits(s string) ([]int, error) {
 	return limits, nil
 }
 
+// showCoverage prints which arrangements cover each pair, with overlap
+// statistics per arrangement, and draws the matrix to the SVG file at path
+func showCoverage(path string, s *Solver, run *manifest.Run) {
+	edges := make([][2]int, len(s.edges))
+	for i, e := range s.edges {
+		edges[i] = [2]int{e.a, e.b}
+	}
+	arrs := make([]coverage.Arrangement, len(s.solution))
+	for i, arr := range s.solution {
+		arrs[i] = coverage.Arrangement{Name: fmt.Sprintf("arr%d", i), Items: arr, Edges: edges}
+	}
+	m := coverage.New(N, arrs)
+	fmt.Println("\nPair coverage:")
+	m.WriteText(os.Stdout)
+	if err := m.SaveSVG(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Wrote(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Coverage matrix drawn to %s\n", path)
+}
+
+// events receives the -json events (nil: off).
+var events *jsonl.Emitter
+
 func main() {
 	workers := flag.Int("workers", 8, "Number of parallel workers")
 	maxOverlap := flag.String("max-overlap", "", "Comma-separated max overlap per level (e.g., '0,0,10,10')")
+	timeout := flag.Duration("timeout", 0, "give up after this long and print the summary so far (0: no limit)")
+	maxNodes := flag.Int64("max-nodes", 0, "give up after about this many search nodes (0: no limit)")
+	outPath := flag.String("out", "", "write the solution to this arrangement file (pkg/arrangement)")
+	coveragePath := flag.String("coverage", "", "with a solution, print the pair-coverage matrix and overlap per arrangement, and draw it to this SVG file")
+	configPath := flag.String("config", "", "take flags from this TOML file (flags on the command line win); see pkg/config")
+	profile := flag.String("profile", "", "with -config: also apply this [profile] section of the file")
+	logLevel := flag.String("log-level", "normal", "progress lines to print: quiet, normal, verbose or debug (see pkg/logging)")
+	logFile := flag.String("log-file", "", "also append the progress lines to this file")
+	jsonOut := flag.Bool("json", false, "write events (start, levels, solution, result) as JSON lines on stdout; text goes to stderr")
 	flag.Parse()
+	settings, err := config.Load(*configPath, *profile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	logger, err := logging.Start(*logLevel, *logFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+	events = jsonl.Start("solver_20", *jsonOut)
+	run := manifest.Start("solver_20", nil)
+	if err := run.Input(*configPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *configPath != "" {
+		fmt.Println(config.Describe(*configPath, *profile, settings))
+	}
 
 	fmt.Printf("Searching for %d arrangements of %d items\n", K, N)
 
 	solver := NewSolver()
+	solver.budget = budget.New(*timeout, *maxNodes)
+	solver.log = logger
 
 	overlapLimits, err := parseOverlapLimits(*maxOverlap)
 	if err != nil {
@@ -422,6 +501,10 @@ func main() {
 		solver.numPairs, solver.numEdges, (solver.numPairs+solver.numEdges-1)/solver.numEdges)
 	fmt.Printf("Special slot %d has degree %d (filled first at last level)\n", specialSlot, specialSlotDegree)
 	fmt.Printf("Workers: %d\n\n", *workers)
+	events.Emit("start", jsonl.Fields{
+		"n": N, "k": K, "shape": "spiral", "edges": solver.numEdges, "pairs": solver.numPairs,
+		"workers": *workers, "max_overlap": overlapLimits,
+	})
 
 	start := time.Now()
 	found := solver.Solve(*workers)
@@ -432,9 +515,35 @@ func main() {
 		for i, arr := range solver.solution {
 			fmt.Printf("  Arr%d: %v\n", i, arr)
 		}
+		events.Emit("solution", jsonl.Fields{"arrangements": solver.solution})
+		if *outPath != "" {
+			if err := arrangement.WriteFile(*outPath, arrangement.NewSpiral(N, solver.solution, "solver_20")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if err := run.Wrote(*outPath); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Solution written to %s\n", *outPath)
+		}
+		if *coveragePath != "" {
+			showCoverage(*coveragePath, solver, run)
+		}
+	} else if b := solver.budget; b.Stopped() {
+		fmt.Printf("\nStopped (%v) after %d nodes: no solution found so far.\n", b.Err(), b.Nodes())
+		fmt.Print("Valid arrangements by level:")
+		for level := 0; level < K-1; level++ {
+			fmt.Printf(" arr%d %d", level+1, atomic.LoadInt32(&solver.printedLevel[level]))
+		}
+		fmt.Println()
+		fmt.Printf("\nTime: %v\n", elapsed.Round(time.Millisecond))
+		events.Emit("result", jsonl.Fields{"found": false, "stopped": b.Err().Error(), "nodes": b.Nodes(), "seconds": elapsed.Seconds()})
+		os.Exit(budget.ExitStopped)
 	} else {
 		fmt.Println("\nNo solution found.")
 	}
+	events.Emit("result", jsonl.Fields{"found": found, "stopped": nil, "seconds": elapsed.Seconds()})
 
 	fmt.Printf("\nTime: %v\n", elapsed.Round(time.Millisecond))
 }
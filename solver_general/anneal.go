@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+)
+
+// Temperature range of one annealing run, in uncovered pairs: early on a
+// move that uncovers one more pair is taken about a third of the time, at
+// the end almost never.
+const (
+	annealTempStart = 1.0
+	annealTempEnd   = 0.02
+)
+
+// annealState is one worker's current arrangements and how often each
+// pair is covered by them.
+type annealState struct {
+	s         *Solver
+	adj       [][][]int // adj[i][slot]: all neighbors of slot in shapes[i]
+	need      []int     // need[pair]: coverings required (SetMultiplicity)
+	arrs      [][]int
+	count     []int // count[pair]: edges covering it
+	uncovered int   // coverings still missing: pairs uncovered, unless a pair needs several
+}
+
+func (s *Solver) newAnnealState(adj [][][]int, need []int, rng *rand.Rand) *annealState {
+	st := &annealState{s: s, adj: adj, need: need, arrs: make([][]int, s.k), count: make([]int, s.numPairs)}
+	for i := range st.arrs {
+		if i == 0 {
+			st.arrs[0] = make([]int, s.n)
+			for slot := range st.arrs[0] {
+				st.arrs[0][slot] = slot
+			}
+		} else {
+			st.arrs[i] = rng.Perm(s.n)
+		}
+	}
+	st.uncovered = cover.Multiplicity(need).Total()
+	for i, arr := range st.arrs {
+		for _, e := range s.shapes[i].edges {
+			st.add(s.pairIndex(arr[e.a], arr[e.b]))
+		}
+	}
+	return st
+}
+
+func (st *annealState) add(pi int) {
+	if st.count[pi]++; st.count[pi] <= st.need[pi] {
+		st.uncovered--
+	}
+}
+
+func (st *annealState) remove(pi int) {
+	if st.count[pi]--; st.count[pi] < st.need[pi] {
+		st.uncovered++
+	}
+}
+
+// swap exchanges the items at slots a and b of arrangement i; swapping
+// again undoes it.
+func (st *annealState) swap(i, a, b int) {
+	arr := st.arrs[i]
+	ia, ib := arr[a], arr[b]
+	for _, c := range st.adj[i][a] {
+		if c != b {
+			st.remove(st.s.pairIndex(ia, arr[c]))
+			st.add(st.s.pairIndex(ib, arr[c]))
+		}
+	}
+	for _, c := range st.adj[i][b] {
+		if c != a {
+			st.remove(st.s.pairIndex(ib, arr[c]))
+			st.add(st.s.pairIndex(ia, arr[c]))
+		}
+	}
+	arr[a], arr[b] = ib, ia
+}
+
+// SetAnneal sets the length of one annealing run and how many runs each
+// worker makes before giving up (0: until a solution is found).
+func (s *Solver) SetAnneal(steps int64, runs int) {
+	s.annealSteps, s.annealRuns = steps, runs
+}
+
+// SolveAnneal looks for a solution by simulated annealing: arr0 stays the
+// identity, arr1..arr(k-1) start as random permutations, and a move swaps
+// two items within one of them, accepted if it doesn't uncover more pairs
+// or else with probability exp(-delta/T) while T cools geometrically over
+// the run. Every worker anneals on its own until one reaches zero
+// uncovered pairs (with SetMultiplicity, zero missing coverings). It can find solutions far beyond the reach of the
+// complete search, but never proves that there is none.
+func (s *Solver) SolveAnneal(numWorkers int) bool {
+	adj := make([][][]int, s.k)
+	for i, sh := range s.shapes {
+		adj[i] = make([][]int, s.n)
+		for _, e := range sh.edges {
+			adj[i][e.a] = append(adj[i][e.a], e.b)
+			adj[i][e.b] = append(adj[i][e.b], e.a)
+		}
+	}
+	need := s.multiplicity()
+	if s.k == 1 {
+		return s.newAnnealState(adj, need, nil).uncovered == 0
+	}
+
+	required := need.Total()
+	var best atomic.Int64
+	best.Store(int64(required))
+	var runsDone atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(log *logging.Logger, seed int64) {
+			defer wg.Done()
+			workersBusy.Add(1)
+			defer workersBusy.Add(-1)
+			rng := rand.New(rand.NewSource(seed))
+			for run := 0; s.annealRuns == 0 || run < s.annealRuns; run++ {
+				st := s.newAnnealState(adj, need, rng)
+				found := st.anneal(rng, s.annealSteps)
+				runsDone.Add(1)
+				log.Debugf("anneal run %d: %d pairs uncovered", run+1, st.uncovered)
+				for b := best.Load(); int64(st.uncovered) < b; b = best.Load() {
+					if best.CompareAndSwap(b, int64(st.uncovered)) {
+						break
+					}
+				}
+				raiseBestCovered(required - st.uncovered)
+				if found {
+					s.mu.Lock()
+					if atomic.LoadInt32(&s.found) == 0 {
+						copy(s.solution, st.arrs)
+						atomic.StoreInt32(&s.found, 1)
+					}
+					s.mu.Unlock()
+				}
+				if s.done() {
+					return
+				}
+			}
+		}(logger.Worker(w), time.Now().UnixNano()+int64(w)*12345)
+	}
+	wg.Wait()
+
+	missing := "uncovered pairs"
+	if s.need != nil {
+		missing = "missing coverings"
+	}
+	fmt.Printf("Anneal: %d runs of %d steps, fewest %s %d\n", runsDone.Load(), s.annealSteps, missing, best.Load())
+	events.Emit("anneal", jsonl.Fields{"runs": runsDone.Load(), "steps": s.annealSteps, "best_uncovered": best.Load()})
+	return atomic.LoadInt32(&s.found) != 0
+}
+
+// anneal runs one annealing schedule of the given number of steps and
+// reports whether it reached zero uncovered pairs. It checks for another
+// worker's solution, and the budget, every few thousand steps.
+func (st *annealState) anneal(rng *rand.Rand, steps int64) bool {
+	s := st.s
+	cool := math.Pow(annealTempEnd/annealTempStart, 1/float64(steps))
+	temp := annealTempStart
+	var nodes int64
+	defer func() { s.countNodes(nodes) }()
+	for step := int64(0); step < steps; step++ {
+		if st.uncovered == 0 {
+			return true
+		}
+		if nodes++; nodes == nodeFlush {
+			s.countNodes(nodes)
+			nodes = 0
+			if s.done() {
+				return false
+			}
+		}
+		i := 1 + rng.Intn(s.k-1)
+		a, b := rng.Intn(s.n), rng.Intn(s.n-1)
+		if b >= a {
+			b++
+		}
+		before := st.uncovered
+		st.swap(i, a, b)
+		if delta := st.uncovered - before; delta > 0 && rng.Float64() >= math.Exp(-float64(delta)/temp) {
+			st.swap(i, a, b)
+		}
+		temp *= cool
+	}
+	return st.uncovered == 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"hexagon_clink/pkg/budget"
+	"hexagon_clink/pkg/jsonl"
+)
+
+// benchCase is a fixed search workload for -bench on the spiral: an
+// exhaustive search that finds no solution, which visits the same nodes in
+// any order, or a -find-all enumeration cut off after maxNodes, whose item
+// orders are seeded by the task and so repeat from run to run.
+type benchCase struct {
+	name      string
+	n, k      int
+	limits    []int
+	findAll   bool
+	maxNodes  int64
+	heuristic heuristic
+}
+
+var benchCases = []benchCase{
+	{name: "n12 k3 exhaustive", n: 12, k: 3, limits: []int{2, 2}},
+	{name: "n10 k4 exhaustive", n: 10, k: 4, limits: []int{3, 3, 3}},
+	{name: "n20 k5 find-all", n: 20, k: 5, findAll: true, maxNodes: 10_000_000},
+}
+
+// solver returns a solver for the case with the options runBench uses.
+func (bc benchCase) solver() *Solver {
+	shapes := make([]*Shape, bc.k)
+	shape := spiralShape(bc.n)
+	for i := range shapes {
+		shapes[i] = shape
+	}
+	s := NewSolver(bc.n, shapes)
+	s.SetMaxOverlap(bc.limits)
+	s.BreakSymmetry()
+	s.SpecialSlot()
+	s.SetHeuristic(bc.heuristic)
+	if bc.maxNodes > 0 {
+		s.SetBudget(budget.New(0, bc.maxNodes))
+	}
+	for i := range s.printedLevel {
+		s.printedLevel[i] = 1 // no "First valid" lines in the table
+	}
+	return s
+}
+
+// run searches on one worker.
+func (bc benchCase) run(s *Solver) {
+	if bc.findAll {
+		s.FindAll(1, func(arrs [][]int, index int) {})
+	} else {
+		s.Solve(1)
+	}
+}
+
+// runBench runs the benchCases on one worker with the default options
+// (symmetry breaking, special slot) but without the no-good table, whose
+// hits depend on the order, and prints nodes per second and heap
+// allocations per node.
+func runBench() {
+	fmt.Printf("%-20s %12s %10s %12s %12s %10s\n", "case", "nodes", "time", "nodes/s", "allocs/node", "B/node")
+	for _, bc := range benchCases {
+		s := bc.solver()
+
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		bc.run(s)
+		took := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		nodes := s.nodes()
+		perNode := func(v uint64) float64 { return float64(v) / float64(max(nodes, 1)) }
+		allocs, bytes := perNode(after.Mallocs-before.Mallocs), perNode(after.TotalAlloc-before.TotalAlloc)
+		rate := float64(nodes) / took.Seconds()
+		fmt.Printf("%-20s %12d %10v %12.0f %12.3f %10.1f\n", bc.name, nodes, took.Round(time.Millisecond), rate, allocs, bytes)
+		events.Emit("bench", jsonl.Fields{
+			"case": bc.name, "nodes": nodes, "seconds": took.Seconds(), "nodes_per_second": rate,
+			"allocs_per_node": allocs, "bytes_per_node": bytes,
+		})
+	}
+}
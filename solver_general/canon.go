@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/pairs"
+)
+
+// canonicalSolution returns a key that two solutions share exactly when one
+// turns into the other by relabeling the items, reordering the
+// arrangements and moving arrangements along automorphisms of their host
+// graphs; autos[i] is shapes[i]'s automorphism group (nil: identity only,
+// so some equivalent solutions may keep different keys).
+//
+// What a solution is, up to automorphisms of the hosts, is one edge set
+// of item pairs per arrangement. For every arrangement j and automorphism
+// σ of its host, relabel each item by its slot in arr_j∘σ, which turns
+// arrangement j into its host's own edges; the others become edge sets on
+// slots, which are sorted. The smallest of these readings is the key.
+//
+// A relabeling only turns a solution into another if it keeps the
+// requirements, so a non-uniform multiplicity need (nil: none) is read
+// under each relabeling too and becomes part of the key.
+func canonicalSolution(shapes []*Shape, autos [][][]int, arrs [][]int, need cover.Multiplicity) string {
+	n := len(arrs[0])
+	label := make([]int, n)
+	others := make([]string, 0, len(arrs)-1)
+	var edgeKeys []int
+	var relabeled cover.Multiplicity
+	if need != nil && !need.IsUniform() {
+		relabeled = make(cover.Multiplicity, len(need))
+	}
+	best := ""
+	for j, arr := range arrs {
+		group := autos[j]
+		if group == nil {
+			group = [][]int{nil}
+		}
+		for _, perm := range group {
+			for slot := range arr {
+				if perm == nil {
+					label[arr[slot]] = slot
+				} else {
+					label[arr[perm[slot]]] = slot
+				}
+			}
+			others = others[:0]
+			for i, other := range arrs {
+				if i == j {
+					continue
+				}
+				edgeKeys = edgeKeys[:0]
+				for _, e := range shapes[i].edges {
+					a, b := label[other[e.a]], label[other[e.b]]
+					edgeKeys = append(edgeKeys, min(a, b)*n+max(a, b))
+				}
+				slices.Sort(edgeKeys)
+				others = append(others, fmt.Sprint(shapes[i].name, edgeKeys))
+			}
+			sort.Strings(others)
+			key := shapes[j].name + "|" + strings.Join(others, "|")
+			if relabeled != nil {
+				pairs.Each(n, func(pi, a, b int) {
+					relabeled[pairs.PairIndex(n, label[a], label[b])] = need[pi]
+				})
+				key += fmt.Sprint("|", relabeled)
+			}
+			if best == "" || key < best {
+				best = key
+			}
+		}
+	}
+	return best
+}
+
+// FindAll enumerates every solution the search accepts (same overlap
+// limits and bounds as Solve) instead of stopping at the first, splitting
+// the work into the subtrees of rootPrefixes as Solve does. Solutions equal up
+// to relabeling, arrangement order and host automorphisms are counted
+// once: report gets each new one, with its number, in the order found. It
+// returns the number of solutions reached and of distinct ones.
+func (s *Solver) FindAll(numWorkers int, report func(arrs [][]int, index int)) (total, distinct int) {
+	autos := make([][][]int, s.k)
+	for i, sh := range s.shapes {
+		autos[i] = sh.automorphisms(s.n)
+	}
+	arr0 := make([]int, s.n)
+	for i := range arr0 {
+		arr0[i] = i
+	}
+	covered := s.coveredByArr0()
+	coveredCount := covered.Covered()
+	if s.k == 1 {
+		if coveredCount < s.required {
+			return 0, 0
+		}
+		report([][]int{arr0}, 1)
+		return 1, 1
+	}
+	if !s.coverable(covered) {
+		return 0, 0
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	collect := func(arrs [][]int) {
+		arrs = append([][]int{arr0}, arrs...)
+		if s.last != nil {
+			arrs[s.k-1] = s.fromLast(arrs[s.k-1])
+		}
+		key := canonicalSolution(s.shapes, autos, arrs, s.need)
+		mu.Lock()
+		defer mu.Unlock()
+		total++
+		if !seen[key] {
+			seen[key] = true
+			report(arrs, len(seen))
+		}
+	}
+
+	tasks := s.rootTasks(numWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(log *logging.Logger) {
+			defer wg.Done()
+			workersBusy.Add(1)
+			defer workersBusy.Add(-1)
+			covered := covered.Clone() // solve changes it as it goes
+			for task := range tasks {
+				task.solution = collect
+				s.solve(0, covered, coveredCount, nil, &worker{rng: rand.New(rand.NewSource(int64(task.prefix[0]))), budget: -1, log: log}, task)
+			}
+		}(logger.Worker(w))
+	}
+	wg.Wait()
+	return total, len(seen)
+}
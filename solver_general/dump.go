@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+)
+
+// DumpPartials enumerates every arr1..arr(k-2) that the search would accept
+// (same overlap limits and bounds) and that covers, together with arr0, at
+// least minCovered pairs (coverings, with SetMultiplicity), and writes them
+// in find_fourth's candidate format: one line per candidate, arrangements
+// separated by ";" and items by ",".
+// Work is split by arr1's first item, one file dir/item_<item>.txt each;
+// with BreakSymmetry only orbit representatives start arr1, which loses no
+// solutions. It returns the number of candidates written.
+func (s *Solver) DumpPartials(dir string, minCovered, numWorkers int) (int64, error) {
+	if s.k < 3 {
+		return 0, fmt.Errorf("-dump-partials needs k >= 3 (it writes arr1..arr%d)", s.k-2)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	covered := s.coveredByArr0()
+	coveredCount := covered.Covered()
+
+	items := make(chan int, s.n)
+	for item := 0; item < s.n; item++ {
+		if s.autos == nil || orbitMin(s.autos, item) {
+			items <- item
+		}
+	}
+	close(items)
+
+	var total int64
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(log *logging.Logger) {
+			defer wg.Done()
+			workersBusy.Add(1)
+			defer workersBusy.Add(-1)
+			covered := covered.Clone() // solve changes it as it goes
+			for item := range items {
+				count, err := s.dumpItem(dir, item, minCovered, covered, coveredCount)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+				atomic.AddInt64(&total, count)
+				log.Printf("item_%d.txt: %d candidates", item, count)
+				events.Emit("dump_file", jsonl.Fields{"item": item, "candidates": count})
+			}
+		}(logger.Worker(w))
+	}
+	wg.Wait()
+	return total, firstErr
+}
+
+func (s *Solver) dumpItem(dir string, item, minCovered int, covered tally, coveredCount int) (int64, error) {
+	path := filepath.Join(dir, fmt.Sprintf("item_%d.txt", item))
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	var count int64
+	task := &searchTask{
+		prefix:     []int{item},
+		minCovered: minCovered,
+		emit: func(arrs [][]int) {
+			parts := make([]string, len(arrs))
+			for i, arr := range arrs {
+				items := make([]string, len(arr))
+				for j, v := range arr {
+					items[j] = strconv.Itoa(v)
+				}
+				parts[i] = strings.Join(items, ",")
+			}
+			fmt.Fprintln(w, strings.Join(parts, ";"))
+			count++
+			dumped.Add(1)
+		},
+	}
+	// The enumeration is exhaustive, so the order doesn't matter; a fixed
+	// seed keeps the files reproducible
+	s.solve(0, covered, coveredCount, nil, &worker{rng: rand.New(rand.NewSource(int64(item))), budget: -1}, task)
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return count, err
+	}
+	return count, f.Close()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"hexagon_clink/pkg/jsonl"
+)
+
+// shortfall is a set of items that can't meet all their partners: with
+// arr0 fixed to the identity, they still need needed pairs (coverings,
+// with SetMultiplicity) after it, and arr1..arr(k-1) give them at most
+// available. With arr0 free (arr0Free), needed counts all their coverings
+// and available what all k arrangements give.
+type shortfall struct {
+	items     []int
+	needed    int
+	available int
+}
+
+// degrees returns the degree of each of the shape's slots.
+func (sh *Shape) degrees(n int) []int {
+	degree := make([]int, n)
+	for _, e := range sh.edges {
+		degree[e.a]++
+		degree[e.b]++
+	}
+	return degree
+}
+
+// Precheck applies the degree-sum argument to each item before any
+// search: item i sits on slot i of arr0 and meets its neighbors there, so
+// it needs n-1-deg0(i) more partners (with SetMultiplicity, the coverings
+// its pairs still lack after arr0), and each later arrangement gives it at
+// most its shape's largest degree. Every item that can't get enough is
+// returned on its own. If each can, the t neediest items together still
+// need more than the t largest degrees of every later shape add up to for
+// some t (t = n is the pairs/edges count); the smallest such group is
+// returned. nil means the check passes, which proves nothing. With arr0
+// free, fixing it would make the check unsound, so arr0 counts as a later
+// arrangement and the items need all their coverings.
+func (s *Solver) Precheck() []shortfall {
+	first, covered := 1, s.coveredByArr0()
+	if s.arr0Free() {
+		first, covered = 0, s.newTally()
+	}
+	need := s.uncoveredPairs(covered, nil)
+	items := make([]int, s.n)
+	for i := range items {
+		items[i] = i
+	}
+	sort.SliceStable(items, func(a, b int) bool { return need[items[a]] > need[items[b]] })
+
+	later := make([][]int, s.k-first)
+	best := 0
+	for i := range later {
+		later[i] = s.shapes[first+i].degrees(s.n)
+		sort.Sort(sort.Reverse(sort.IntSlice(later[i])))
+		best += later[i][0]
+	}
+	var short []shortfall
+	for _, item := range items {
+		if need[item] > best {
+			short = append(short, shortfall{items: []int{item}, needed: need[item], available: best})
+		}
+	}
+	if short != nil {
+		sort.Slice(short, func(a, b int) bool { return short[a].items[0] < short[b].items[0] })
+		return short
+	}
+
+	needed, available := 0, 0
+	for t, item := range items {
+		needed += need[item]
+		for _, d := range later {
+			available += d[t]
+		}
+		if needed > available {
+			group := append([]int(nil), items[:t+1]...)
+			sort.Ints(group)
+			return []shortfall{{items: group, needed: needed, available: available}}
+		}
+	}
+	return nil
+}
+
+// ruledOut runs Precheck and, if it rules k arrangements out, prints and
+// emits why.
+func (s *Solver) ruledOut() bool {
+	short := s.Precheck()
+	if short == nil {
+		return false
+	}
+	what := "partners"
+	if s.need != nil {
+		what = "coverings"
+	}
+	more, later := " more", fmt.Sprintf(" after arr0, arr1..arr%d give", s.k-1)
+	switch {
+	case s.arr0Free():
+		more, later = "", fmt.Sprintf(", arr0..arr%d give", s.k-1)
+		if s.k == 1 {
+			later = ", arr0 gives"
+		}
+	case s.k == 1:
+		later = " after arr0, no other arrangement gives"
+	case s.k == 2:
+		later = " after arr0, arr1 gives"
+	}
+	for _, sh := range short {
+		if len(sh.items) == 1 {
+			fmt.Printf("Item %d needs %d%s %s%s it at most %d\n", sh.items[0], sh.needed, more, what, later, sh.available)
+		} else {
+			fmt.Printf("Items %v need %d%s %s%s them at most %d\n", sh.items, sh.needed, more, what, later, sh.available)
+		}
+		events.Emit("shortfall", jsonl.Fields{"items": sh.items, "needed": sh.needed, "available": sh.available})
+	}
+	fmt.Printf("%d arrangements are impossible: ruled out before searching.\n", s.k)
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// heuristic is the order in which the search tries items for a slot.
+// Every order starts from a per-level shuffle, which breaks the ties, so
+// workers still explore differently.
+type heuristic int
+
+const (
+	heurRandom            heuristic = iota // the shuffle itself, the same for every slot
+	heurUncovered                          // items with the most uncovered pairs first
+	heurLeastConstraining                  // per slot, items overlapping the fewest placed neighbors first
+	heurDegreeMatched                      // items ranked by uncovered pairs to slots of matching degree rank
+)
+
+var heuristics = map[string]heuristic{
+	"random":             heurRandom,
+	"uncovered":          heurUncovered,
+	"least-constraining": heurLeastConstraining,
+	"degree-matched":     heurDegreeMatched,
+}
+
+func parseHeuristic(name string) (heuristic, error) {
+	if h, ok := heuristics[name]; ok {
+		return h, nil
+	}
+	names := make([]string, 0, len(heuristics))
+	for name := range heuristics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unknown -heuristic %q (use %s)", name, strings.Join(names, ", "))
+}
+
+func (s *Solver) SetHeuristic(h heuristic) {
+	s.heuristic = h
+}
+
+// uncoveredPairs returns, for every item, how many coverings its pairs
+// still lack: the pairs not covered yet, unless SetMultiplicity asks for
+// more. It fills and returns need, or a new slice if need is nil.
+func (s *Solver) uncoveredPairs(covered tally, need []int) []int {
+	if need == nil {
+		need = make([]int, s.n)
+	}
+	clear(need)
+	for a := 0; a < s.n; a++ {
+		for b := a + 1; b < s.n; b++ {
+			short := covered.short(s.pairIndex(a, b))
+			need[a] += short
+			need[b] += short
+		}
+	}
+	return need
+}
+
+// itemOrder lists the items to try at each slot of one level's search. It
+// lives in the worker's levelScratch, so every solve call at that level
+// reuses its buffers and ordering allocates nothing after the first call.
+type itemOrder struct {
+	s       *Solver
+	h       heuristic
+	shape   *Shape
+	base    []int   // shuffled, sorted by need for heurUncovered
+	perSlot [][]int // heurDegreeMatched: fixed order per slot; heurLeastConstraining: one buffer per slot
+	keys    []int   // heurLeastConstraining: overlap per item; heurDegreeMatched: rank per item
+	need    []int   // uncovered pairs per item
+	bySlot  []int   // heurDegreeMatched: slots by degree
+	degree  []int   // heurDegreeMatched: degree per slot
+}
+
+// reset prepares the order for one solve call on shape, given the pairs
+// covered so far.
+func (o *itemOrder) reset(s *Solver, shape *Shape, covered tally, rng *rand.Rand) {
+	o.s, o.h, o.shape = s, s.heuristic, shape
+	if o.base == nil {
+		o.base = make([]int, s.n)
+		o.keys = make([]int, s.n)
+		o.need = make([]int, s.n)
+		o.bySlot = make([]int, s.n)
+		o.degree = make([]int, s.n)
+		o.perSlot = make([][]int, s.n)
+		for slot := range o.perSlot {
+			o.perSlot[slot] = make([]int, s.n)
+		}
+	}
+	for i := range o.base {
+		o.base[i] = i
+	}
+	rng.Shuffle(len(o.base), func(i, j int) { o.base[i], o.base[j] = o.base[j], o.base[i] })
+
+	switch o.h {
+	case heurUncovered:
+		need := s.uncoveredPairs(covered, o.need)
+		slices.SortStableFunc(o.base, func(a, b int) int { return need[b] - need[a] })
+
+	case heurDegreeMatched:
+		// Rank items by need and slots by degree, both descending, and try
+		// the items whose rank is closest to the slot's first
+		need := s.uncoveredPairs(covered, o.need)
+		byNeed := o.bySlot // sorted by need here, by degree below
+		copy(byNeed, o.base)
+		slices.SortStableFunc(byNeed, func(a, b int) int { return need[b] - need[a] })
+		itemRank := o.keys
+		for r, item := range byNeed {
+			itemRank[item] = r
+		}
+		degree := o.degree
+		clear(degree)
+		for _, e := range shape.edges {
+			degree[e.a]++
+			degree[e.b]++
+		}
+		bySlot := o.bySlot
+		for i := range bySlot {
+			bySlot[i] = i
+		}
+		slices.SortStableFunc(bySlot, func(a, b int) int { return degree[b] - degree[a] })
+		for r, slot := range bySlot {
+			items := o.perSlot[slot]
+			copy(items, o.base)
+			slices.SortStableFunc(items, func(a, b int) int { return abs(itemRank[a]-r) - abs(itemRank[b]-r) })
+		}
+	}
+}
+
+// at returns the items in the order to try them at slot, given the items
+// placed in arr[:slot] and the pairs covered so far. Used items are
+// included; the caller skips them.
+func (o *itemOrder) at(slot int, arr []int, covered tally) []int {
+	switch o.h {
+	case heurDegreeMatched:
+		return o.perSlot[slot]
+	case heurLeastConstraining:
+		for _, item := range o.base {
+			overlap := 0
+			for _, adj := range o.shape.slotAdj[slot] {
+				if covered.Has(o.s.pairIndex(item, arr[adj])) {
+					overlap++
+				}
+			}
+			o.keys[item] = overlap
+		}
+		items := o.perSlot[slot]
+		copy(items, o.base)
+		slices.SortStableFunc(items, func(a, b int) int { return o.keys[a] - o.keys[b] })
+		return items
+	}
+	return o.base
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/zfile"
+)
+
+// ilpModel is the covering problem as a 0-1 feasibility program, for
+// external MIP solvers. Binary x_<i>_<item>_<v> places item at vertex v of
+// arrangement i's shape (arr0 stays fixed, so i >= 1). For every pair arr0
+// leaves uncovered, z_<i>_<a>_<b>_<v> in [0,1] may only be positive if a
+// sits at v and b at a neighbor of v, and the z of each pair must sum to at
+// least 1, or to its multiplicity (SetMultiplicity) less one if arr0 covers
+// it: an item sits at one vertex, so its z sum to at most 1 per
+// arrangement. Vertices are numbered as in the shape's .g6 line (the spiral's
+// are its slots).
+type ilpModel struct {
+	name   string
+	vars   []string
+	binary []bool
+	rows   []ilpRow
+}
+
+type ilpRow struct {
+	name  string
+	vars  []int
+	coefs []int
+	sense byte // 'E', 'G' or 'L'
+	rhs   int
+}
+
+func (m *ilpModel) addVar(name string, binary bool) int {
+	m.vars = append(m.vars, name)
+	m.binary = append(m.binary, binary)
+	return len(m.vars) - 1
+}
+
+func (m *ilpModel) addRow(name string, sense byte, rhs int, vars []int, coefs []int) {
+	m.rows = append(m.rows, ilpRow{name, vars, coefs, sense, rhs})
+}
+
+func ones(count int) []int {
+	c := make([]int, count)
+	for i := range c {
+		c[i] = 1
+	}
+	return c
+}
+
+// xName names the placement variable of item at slot of arrangement i
+func (s *Solver) xName(i, item, slot int) string {
+	return fmt.Sprintf("x_%d_%d_%d", i, item, s.shapes[i].vertex[slot])
+}
+
+// coveredByArr0 counts the pairs arr0 (the identity on shapes[0]) covers
+func (s *Solver) coveredByArr0() tally {
+	covered := s.newTally()
+	for _, e := range s.shapes[0].edges {
+		if pi := s.pairIndex(e.a, e.b); !covered.Has(pi) {
+			covered.Add(pi)
+		}
+	}
+	return covered
+}
+
+// BuildILP writes the model for the solver's shapes, with the same arr1
+// restriction as the search if BreakSymmetry was called.
+func (s *Solver) BuildILP(name string) (*ilpModel, error) {
+	n := s.n
+	m := &ilpModel{name: name}
+	x := make([][][]int, s.k)
+	for i := 1; i < s.k; i++ {
+		x[i] = make([][]int, n)
+		for item := 0; item < n; item++ {
+			x[i][item] = make([]int, n)
+			for slot := 0; slot < n; slot++ {
+				x[i][item][slot] = m.addVar(s.xName(i, item, slot), true)
+			}
+		}
+	}
+
+	// Each arrangement is a permutation
+	for i := 1; i < s.k; i++ {
+		vars := make([]int, n)
+		for item := 0; item < n; item++ {
+			for slot := 0; slot < n; slot++ {
+				vars[slot] = x[i][item][slot]
+			}
+			m.addRow(fmt.Sprintf("item_%d_%d", i, item), 'E', 1, append([]int(nil), vars...), ones(n))
+		}
+		for slot := 0; slot < n; slot++ {
+			for item := 0; item < n; item++ {
+				vars[item] = x[i][item][slot]
+			}
+			m.addRow(fmt.Sprintf("vertex_%d_%d", i, s.shapes[i].vertex[slot]), 'E', 1, append([]int(nil), vars...), ones(n))
+		}
+	}
+
+	neighbors := make([][][]int, s.k)
+	for i, shape := range s.shapes {
+		neighbors[i] = make([][]int, n)
+		for _, e := range shape.edges {
+			neighbors[i][e.a] = append(neighbors[i][e.a], e.b)
+			neighbors[i][e.b] = append(neighbors[i][e.b], e.a)
+		}
+	}
+
+	covered := s.coveredByArr0()
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			pi := s.pairIndex(a, b)
+			r, err := s.stillNeeded(pi, covered)
+			if err != nil {
+				return nil, err
+			}
+			if r == 0 {
+				continue
+			}
+			var ways []int
+			for i := 1; i < s.k; i++ {
+				for slot := 0; slot < n; slot++ {
+					if len(neighbors[i][slot]) == 0 {
+						continue
+					}
+					suffix := fmt.Sprintf("%d_%d_%d_%d", i, a, b, s.shapes[i].vertex[slot])
+					z := m.addVar("z_"+suffix, false)
+					ways = append(ways, z)
+					m.addRow("za_"+suffix, 'L', 0, []int{z, x[i][a][slot]}, []int{1, -1})
+					vars := []int{z}
+					for _, t := range neighbors[i][slot] {
+						vars = append(vars, x[i][b][t])
+					}
+					coefs := ones(len(vars))
+					for j := 1; j < len(coefs); j++ {
+						coefs[j] = -1
+					}
+					m.addRow("zb_"+suffix, 'L', 0, vars, coefs)
+				}
+			}
+			if len(ways) == 0 {
+				return nil, fmt.Errorf("pair (%d,%d) can't be covered: no arrangement has edges", a, b)
+			}
+			m.addRow(fmt.Sprintf("pair_%d_%d", a, b), 'G', r, ways, ones(len(ways)))
+		}
+	}
+
+	if s.k > 1 && s.autos != nil {
+		for item := 0; item < n; item++ {
+			if !orbitMin(s.autos, item) {
+				m.addRow(fmt.Sprintf("sym_%d", item), 'L', 0, []int{x[1][item][0]}, []int{1})
+			}
+		}
+	}
+	return m, nil
+}
+
+// Write writes the model in CPLEX LP format, or free MPS if path ends in
+// .mps (either may be compressed).
+func (m *ilpModel) Write(path string) error {
+	f, err := zfile.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if zfile.HasExt(path, ".mps") {
+		m.writeMPS(w)
+	} else {
+		m.writeLP(w)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (m *ilpModel) writeLP(w io.Writer) {
+	fmt.Fprintf(w, "\\ %s: %d variables, %d constraints\n", m.name, len(m.vars), len(m.rows))
+	fmt.Fprintln(w, "Minimize")
+	fmt.Fprintf(w, " obj: 0 %s\n", m.vars[0])
+	fmt.Fprintln(w, "Subject To")
+	senses := map[byte]string{'E': "=", 'G': ">=", 'L': "<="}
+	for _, r := range m.rows {
+		fmt.Fprintf(w, " %s:", r.name)
+		for j, v := range r.vars {
+			// LP readers limit line length
+			if j > 0 && j%8 == 0 {
+				fmt.Fprint(w, "\n  ")
+			}
+			switch {
+			case r.coefs[j] == 1:
+				fmt.Fprintf(w, " + %s", m.vars[v])
+			case r.coefs[j] == -1:
+				fmt.Fprintf(w, " - %s", m.vars[v])
+			default:
+				fmt.Fprintf(w, " %+d %s", r.coefs[j], m.vars[v])
+			}
+		}
+		fmt.Fprintf(w, " %s %d\n", senses[r.sense], r.rhs)
+	}
+	fmt.Fprintln(w, "Bounds")
+	for v, name := range m.vars {
+		if !m.binary[v] {
+			fmt.Fprintf(w, " 0 <= %s <= 1\n", name)
+		}
+	}
+	fmt.Fprintln(w, "Binaries")
+	for v, name := range m.vars {
+		if m.binary[v] {
+			fmt.Fprintf(w, " %s\n", name)
+		}
+	}
+	fmt.Fprintln(w, "End")
+}
+
+func (m *ilpModel) writeMPS(w io.Writer) {
+	type entry struct{ row, coef int }
+	cols := make([][]entry, len(m.vars))
+	for ri, r := range m.rows {
+		for j, v := range r.vars {
+			cols[v] = append(cols[v], entry{ri, r.coefs[j]})
+		}
+	}
+
+	fmt.Fprintf(w, "NAME %s\n", m.name)
+	fmt.Fprintln(w, "ROWS")
+	fmt.Fprintln(w, " N obj")
+	for _, r := range m.rows {
+		fmt.Fprintf(w, " %c %s\n", r.sense, r.name)
+	}
+	fmt.Fprintln(w, "COLUMNS")
+	inInt := false
+	for v, name := range m.vars {
+		if m.binary[v] != inInt {
+			if inInt {
+				fmt.Fprintln(w, "    MARKER MARKER INTEND")
+			} else {
+				fmt.Fprintln(w, "    MARKER MARKER INTORG")
+			}
+			inInt = m.binary[v]
+		}
+		if len(cols[v]) == 0 {
+			fmt.Fprintf(w, "    %s obj 0\n", name)
+		}
+		for _, e := range cols[v] {
+			fmt.Fprintf(w, "    %s %s %d\n", name, m.rows[e.row].name, e.coef)
+		}
+	}
+	if inInt {
+		fmt.Fprintln(w, "    MARKER MARKER INTEND")
+	}
+	fmt.Fprintln(w, "RHS")
+	for _, r := range m.rows {
+		if r.rhs != 0 {
+			fmt.Fprintf(w, "    RHS %s %d\n", r.name, r.rhs)
+		}
+	}
+	fmt.Fprintln(w, "BOUNDS")
+	for v, name := range m.vars {
+		if m.binary[v] {
+			fmt.Fprintf(w, " BV BND %s\n", name)
+		} else {
+			fmt.Fprintf(w, " UP BND %s 1\n", name)
+		}
+	}
+	fmt.Fprintln(w, "ENDATA")
+}
+
+// ReadILPSolution reads the x variables of a MIP solver's solution file and
+// turns them into arrangements (arr0 included). It only looks for variable
+// names followed by a value somewhere later on the line, which covers the
+// usual formats: Gurobi/HiGHS "name value" and CBC "index name value
+// reduced-cost".
+func (s *Solver) ReadILPSolution(path string) ([][]int, error) {
+	type place struct{ i, item, slot int }
+	byName := make(map[string]place)
+	for i := 1; i < s.k; i++ {
+		for item := 0; item < s.n; item++ {
+			for slot := 0; slot < s.n; slot++ {
+				byName[s.xName(i, item, slot)] = place{i, item, slot}
+			}
+		}
+	}
+
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	solution := make([][]int, s.k)
+	solution[0] = make([]int, s.n)
+	for slot := range solution[0] {
+		solution[0][slot] = slot
+	}
+	for i := 1; i < s.k; i++ {
+		solution[i] = make([]int, s.n)
+		for slot := range solution[i] {
+			solution[i][slot] = -1
+		}
+	}
+
+	seen := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		for j, field := range fields {
+			p, ok := byName[field]
+			if !ok {
+				continue
+			}
+			seen++
+			for _, next := range fields[j+1:] {
+				value, err := strconv.ParseFloat(next, 64)
+				if err != nil {
+					continue
+				}
+				if value > 0.5 {
+					if prev := solution[p.i][p.slot]; prev >= 0 && prev != p.item {
+						return nil, fmt.Errorf("%s:%d: arrangement %d has items %d and %d at vertex %d",
+							path, line, p.i, prev, p.item, s.shapes[p.i].vertex[p.slot])
+					}
+					solution[p.i][p.slot] = p.item
+				}
+				break
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if seen == 0 && s.k > 1 {
+		return nil, fmt.Errorf("%s: no x_ variables found", path)
+	}
+
+	for i := 1; i < s.k; i++ {
+		for slot, item := range solution[i] {
+			if item < 0 {
+				return nil, fmt.Errorf("%s: arrangement %d has no item at vertex %d", path, i, s.shapes[i].vertex[slot])
+			}
+		}
+		if err := cover.CheckPermutation(solution[i], s.n); err != nil {
+			return nil, fmt.Errorf("%s: arrangement %d: %v", path, i, err)
+		}
+	}
+	return solution, nil
+}
+
+// Uncovered lists the pairs fewer arrangements of solution make adjacent
+// than required: none, or fewer than their multiplicity if one was set
+func (s *Solver) Uncovered(solution [][]int) [][2]int {
+	hosts := make([][][2]int, len(solution))
+	for i := range solution {
+		hosts[i] = make([][2]int, len(s.shapes[i].edges))
+		for j, e := range s.shapes[i].edges {
+			hosts[i][j] = [2]int{e.a, e.b}
+		}
+	}
+	return s.pairs.Short(s.pairs.Counts(solution, hosts), s.multiplicity())
+}
+
+// shapePath inserts a shape multiset's names before the extension(s) of
+// path, so each multiset gets its own model file
+func shapePath(path string, names []string) string {
+	dir, base := filepath.Split(path)
+	dot := strings.Index(base, ".")
+	if dot < 0 {
+		dot = len(base)
+	}
+	return dir + base[:dot] + "_" + strings.Join(names, "") + base[dot:]
+}
+
+// checkSolution verifies an external solver's solution and reports it
+func checkSolution(s *Solver, path string) {
+	solution, err := s.ReadILPSolution(path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	arrs := make([][]int, len(solution))
+	for i, arr := range solution {
+		arrs[i] = s.shapes[i].byVertex(arr)
+		fmt.Printf("  Arr%d (%s): %v\n", i, s.shapes[i].name, arrs[i])
+	}
+	missing := s.Uncovered(solution)
+	events.Emit("check", jsonl.Fields{"path": path, "arrangements": arrs, "valid": len(missing) == 0,
+		"uncovered": missing, "pairs": s.numPairs})
+	if len(missing) > 0 && s.need != nil {
+		fmt.Printf("\nINVALID: %d of %d pairs covered fewer times than -multiplicity asks: %v\n", len(missing), s.numPairs, missing)
+		os.Exit(1)
+	}
+	if len(missing) > 0 {
+		fmt.Printf("\nINVALID: %d of %d pairs uncovered: %v\n", len(missing), s.numPairs, missing)
+		os.Exit(1)
+	}
+	if s.need != nil {
+		fmt.Printf("\nVerified: all %d pairs covered as often as -multiplicity asks\n", s.numPairs)
+		return
+	}
+	fmt.Printf("\nVerified: all %d pairs covered\n", s.numPairs)
+}
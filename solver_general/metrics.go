@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"hexagon_clink/pkg/metrics"
+)
+
+// Search metrics, served over HTTP with -metrics and kept up to date
+// either way. Nodes are counted per solve call and flushed in batches of
+// nodeFlush so the workers don't contend on one counter.
+var (
+	nodesExplored = metrics.NewCounter("solver_general_nodes_total", "Slots filled by the backtracking search.")
+	workersBusy   = metrics.NewGauge("solver_general_workers_busy", "Workers currently searching.")
+	multisetsDone = metrics.NewCounter("solver_general_multisets_total", "Shape multisets finished with -graphs.")
+	dumped        = metrics.NewCounter("solver_general_dumped_total", "Candidates written by -dump-partials.")
+	pairsTotal    = metrics.NewGauge("solver_general_pairs", "Pairs to cover, n(n-1)/2, or coverings required with -multiplicity.")
+	bestCovered   atomic.Int64
+)
+
+const nodeFlush = 1 << 14
+
+func init() {
+	metrics.GaugeFunc("solver_general_nodes_per_second", "Average search rate since start.", func() float64 {
+		return float64(nodesExplored.Value()) / metrics.Since()
+	})
+	metrics.GaugeFunc("solver_general_best_covered_pairs", "Most pairs covered (coverings with -multiplicity) by any arrangement prefix reached so far.", func() float64 {
+		return float64(bestCovered.Load())
+	})
+}
+
+// raiseBestCovered records covered if it beats the best so far
+func raiseBestCovered(covered int) {
+	for best := bestCovered.Load(); int64(covered) > best; best = bestCovered.Load() {
+		if bestCovered.CompareAndSwap(best, int64(covered)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/pairs"
+)
+
+// SetMultiplicity requires pair i to be covered by at least m[i] of the
+// arrangements instead of once (nil: once each). A pair is covered at most
+// once per arrangement, so it counts arrangements. Every engine honors it:
+// the search counts coverings per pair (tally) and its bounds count the
+// coverings still missing instead of the uncovered pairs.
+//
+// The search keeps arr0 fixed to the identity, which relabels the items.
+// Unless m is uniform that is a restriction, not a symmetry (arr0Free): a
+// "no" from the search then only covers the solutions with arr0 the
+// identity, and BreakSymmetry drops the orbits of arr0's automorphisms,
+// which would move the requirements. SolveSAT and Precheck leave arr0
+// free then, so their "no" is still a proof.
+func (s *Solver) SetMultiplicity(m cover.Multiplicity) {
+	if len(m) > 0 && m.IsUniform() && m[0] == 1 {
+		m = nil // once each: the search's plain covered bits do
+	}
+	s.need = m
+	s.required = s.numPairs
+	if m != nil {
+		s.required = m.Total()
+	}
+}
+
+// arr0Free reports whether fixing arr0 to the identity loses solutions:
+// with a multiplicity that isn't uniform, relabeling the items moves the
+// requirements.
+func (s *Solver) arr0Free() bool {
+	return s.need != nil && !s.need.IsUniform()
+}
+
+// noSolution is how to report that the engine found nothing: with arr0
+// free, only a "no" from SolveSAT covers every arr0.
+func (s *Solver) noSolution() string {
+	if s.arr0Free() && !s.proved {
+		return "No solution with arr0 = identity (not a proof)."
+	}
+	return "No solution found."
+}
+
+// multiplicity returns how often each pair must be covered.
+func (s *Solver) multiplicity() cover.Multiplicity {
+	if s.need == nil {
+		return cover.Uniform(s.n, 1)
+	}
+	return s.need
+}
+
+// stillNeeded returns how many of arr1..arr(k-1) must cover the pair with
+// index pi, given what arr0 covers; an error if that is more than there are.
+func (s *Solver) stillNeeded(pi int, byArr0 tally) (int, error) {
+	t := byArr0.short(pi)
+	if t > s.k-1 {
+		p := s.pairs.Pair(pi)
+		return 0, fmt.Errorf("pair (%d,%d) must be covered %d more times after arr0, by %d arrangements", p[0], p[1], t, s.k-1)
+	}
+	return t, nil
+}
+
+// coverable reports whether arr1..arr(k-1) can still give every pair the
+// coverings it lacks after arr0, and logs the first that they can't.
+func (s *Solver) coverable(byArr0 tally) bool {
+	for pi := 0; pi < s.numPairs; pi++ {
+		if _, err := s.stillNeeded(pi, byArr0); err != nil {
+			logger.Printf("%v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// tally is the search's record of the coverings: met holds the pairs
+// covered as often as required, and with SetMultiplicity count holds how
+// often each is covered so far, up to its multiplicity. Covering a pair
+// that is met is overlap. Without a multiplicity count is nil and met is
+// all there is, so the search loop pays one nil check per pair.
+type tally struct {
+	met   pairs.Set
+	count []int
+	need  cover.Multiplicity
+}
+
+// newTally returns the state with nothing covered: only the pairs of
+// multiplicity 0 are met.
+func (s *Solver) newTally() tally {
+	c := tally{met: pairs.NewSet(s.numPairs)}
+	if s.need != nil {
+		c.count, c.need = make([]int, s.numPairs), s.need
+		for pi, t := range s.need {
+			if t == 0 {
+				c.met.Add(pi)
+			}
+		}
+	}
+	return c
+}
+
+// Has reports whether pair pi is covered as often as required.
+func (c tally) Has(pi int) bool {
+	return c.met.Has(pi)
+}
+
+// Add records one more covering of pair pi, which is not met yet.
+func (c tally) Add(pi int) {
+	if c.count != nil {
+		if c.count[pi]++; c.count[pi] < c.need[pi] {
+			return
+		}
+	}
+	c.met.Add(pi)
+}
+
+// Remove undoes Add(pi).
+func (c tally) Remove(pi int) {
+	if c.count != nil {
+		if c.count[pi]--; c.count[pi]+1 < c.need[pi] {
+			return
+		}
+	}
+	c.met.Remove(pi)
+}
+
+// times returns how often pair pi is covered, up to its multiplicity.
+func (c tally) times(pi int) int {
+	if c.count != nil {
+		return c.count[pi]
+	}
+	if c.met.Has(pi) {
+		return 1
+	}
+	return 0
+}
+
+// short returns how many coverings pair pi still lacks.
+func (c tally) short(pi int) int {
+	if c.count != nil {
+		return c.need[pi] - c.count[pi]
+	}
+	if c.met.Has(pi) {
+		return 0
+	}
+	return 1
+}
+
+// Covered returns the coverings made so far that count toward
+// Solver.required: the covered pairs without a multiplicity.
+func (c tally) Covered() int {
+	if c.count == nil {
+		return c.met.Count()
+	}
+	total := 0
+	for _, t := range c.count {
+		total += t
+	}
+	return total
+}
+
+// Clone returns a copy for another worker.
+func (c tally) Clone() tally {
+	c.met = c.met.Clone()
+	if c.count != nil {
+		c.count = append([]int(nil), c.count...)
+	}
+	return c
+}
+
+// readMultiplicity reads -multiplicity for n items and prints what it asks
+// for; nil if path is empty.
+func readMultiplicity(path string, n int) cover.Multiplicity {
+	if path == "" {
+		return nil
+	}
+	m, err := cover.ReadMultiplicity(path, n)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Input(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	byT := make(map[int]int)
+	for _, t := range m {
+		byT[t]++
+	}
+	var parts []string
+	for t := 0; t <= m.Max(); t++ {
+		if byT[t] > 0 {
+			parts = append(parts, fmt.Sprintf("%d pairs %d times", byT[t], t))
+		}
+	}
+	fmt.Printf("Multiplicity from %s: %s, %d coverings\n", path, strings.Join(parts, ", "), m.Total())
+	return m
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sync/atomic"
+
+	"hexagon_clink/pkg/pairs"
+)
+
+// noGoodTable remembers search states whose subtree was searched to the end
+// without a solution, so that the search skips them when it reaches them
+// again by placing the same items in another order, in another worker or
+// after a restart. A state is the level and slot, the coverings so far, the
+// items used so far, the items at the slots still adjacent to empty ones
+// (the frontier) and the overlap the arrangement may still add: nothing
+// else decides how it can be completed. States are stored as 64-bit
+// Zobrist hashes in a direct-mapped table: each hashes to one entry and
+// replaces what was there, so the table never grows, and a false hit needs
+// two states with the same 64 bits.
+type noGoodTable struct {
+	entries []atomic.Uint64
+	zPair   []uint64 // per pair index, XORed in while the pair is covered
+	zItem   []uint64 // per item, while it is used
+	zSlot   []uint64 // per slot*n+item, while item sits at a frontier slot
+
+	lookups, hits, stores atomic.Int64
+}
+
+// noGoodMinNodes is the least subtree, in nodes, worth storing: smaller
+// ones are cheaper to search again than to crowd bigger ones out of the
+// table.
+const noGoodMinNodes = 32
+
+// newNoGoodTable returns a table of size entries (rounded up to a power of
+// two) for n items, or nil for size 0, which turns it off.
+func newNoGoodTable(size, n int) *noGoodTable {
+	if size <= 0 {
+		return nil
+	}
+	entries := 1
+	for entries < size {
+		entries <<= 1
+	}
+	// A fixed seed keeps the hashes, and so the search, the same every run
+	rng := rand.New(rand.NewSource(1))
+	random := func(count int) []uint64 {
+		z := make([]uint64, count)
+		for i := range z {
+			z[i] = rng.Uint64()
+		}
+		return z
+	}
+	return &noGoodTable{
+		entries: make([]atomic.Uint64, entries),
+		zPair:   random(pairs.Count(n)),
+		zItem:   random(n),
+		zSlot:   random(n * n),
+	}
+}
+
+// zCover returns the hash of the j-th covering of pair pi: zPair[pi] for
+// the first, rotated for each further one a multiplicity asks for.
+func (t *noGoodTable) zCover(pi, j int) uint64 {
+	return bits.RotateLeft64(t.zPair[pi], j-1)
+}
+
+// key returns the hash of a state from the running hashes of the covered
+// pairs and used items and the items at the frontier slots of arr.
+func (t *noGoodTable) key(level, slot, slack int, covered, used uint64, frontier, arr []int) uint64 {
+	n := len(t.zItem)
+	h := covered ^ used
+	for _, f := range frontier {
+		h ^= t.zSlot[f*n+arr[f]]
+	}
+	return mix64(h ^ mix64(uint64(level)<<48|uint64(slot)<<32|uint64(slack)))
+}
+
+// has reports whether the state with key is known to fail.
+func (t *noGoodTable) has(key uint64) bool {
+	t.lookups.Add(1)
+	if t.entries[key&uint64(len(t.entries)-1)].Load() != key|1 {
+		return false
+	}
+	t.hits.Add(1)
+	return true
+}
+
+// add records that the state with key fails. The low bit is set so no
+// key is mistaken for an empty entry.
+func (t *noGoodTable) add(key uint64) {
+	t.entries[key&uint64(len(t.entries)-1)].Store(key | 1)
+	t.stores.Add(1)
+}
+
+// String summarizes the hits, for the end of a search.
+func (t *noGoodTable) String() string {
+	lookups, hits := t.lookups.Load(), t.hits.Load()
+	return fmt.Sprintf("No-good table: %d hits of %d lookups (%.1f%%), %d failed states stored in %d entries",
+		hits, lookups, 100*float64(hits)/float64(max(lookups, 1)), t.stores.Load(), len(t.entries))
+}
+
+// mix64 is the splitmix64 finalizer, which spreads every input bit over
+// the whole output.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// SetNoGoods gives the search a no-good table of size entries (0: none).
+// Only the search for a first solution uses it; -find-all and
+// -dump-partials enumerate every state anyway.
+func (s *Solver) SetNoGoods(size int) {
+	s.noGoods = newNoGoodTable(size, s.n)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"hexagon_clink/pkg/jsonl"
+)
+
+// clone returns a solver for the same instance with the same options and
+// its own search state.
+func (s *Solver) clone() *Solver {
+	c := NewSolver(s.n, s.shapes)
+	c.maxOverlapArr = s.maxOverlapArr
+	c.need, c.required = s.need, s.required
+	c.autos, c.autos1, c.lexOrder = s.autos, s.autos1, s.lexOrder
+	c.heuristic = s.heuristic
+	c.restart = s.restart
+	c.annealSteps, c.annealRuns = s.annealSteps, s.annealRuns
+	c.last, c.lastSlot, c.lastDegree = s.last, s.lastSlot, s.lastDegree
+	c.budget = s.budget
+	c.noGoods = s.noGoods // only the search uses it
+	return c
+}
+
+type engineResult struct {
+	engine string
+	solver *Solver
+	found  bool
+	took   time.Duration
+}
+
+// SolvePortfolio runs the search, the SAT encoding and annealing on the
+// instance at once, each on its own copy of the solver, with the workers
+// split between search and annealing. The first solution wins and stops
+// the others; a "no" from SAT is a proof and stops them too. A "no" from
+// the others only counts once all three have given up. gophersat can't be
+// interrupted, so a SAT run still going at the end keeps running in the
+// background until it finishes or the process exits. The engines share the
+// solver's budget and all give up when it runs out.
+func (s *Solver) SolvePortfolio(numWorkers int) bool {
+	searchWorkers := max(1, numWorkers/2)
+	annealWorkers := max(1, numWorkers-searchWorkers)
+	engines := map[string]func(c *Solver) bool{
+		"search": func(c *Solver) bool { return c.Solve(searchWorkers) },
+		"sat":    (*Solver).SolveSAT,
+		"anneal": func(c *Solver) bool { return c.SolveAnneal(annealWorkers) },
+	}
+	logger.Printf("Portfolio: search (%d workers), sat, anneal (%d workers)", searchWorkers, annealWorkers)
+
+	results := make(chan engineResult, len(engines))
+	clones := make(map[string]*Solver, len(engines))
+	start := time.Now()
+	for name, solve := range engines {
+		c := s.clone()
+		clones[name] = c
+		go func(name string, c *Solver, solve func(c *Solver) bool) {
+			found := solve(c)
+			results <- engineResult{name, c, found, time.Since(start)}
+		}(name, c, solve)
+	}
+	stopAll := func() {
+		for _, c := range clones {
+			atomic.StoreInt32(&c.found, 1)
+		}
+	}
+
+	for range engines {
+		r := <-results
+		logger.Printf("Portfolio: %s finished after %v: found=%v", r.engine, r.took.Round(time.Millisecond), r.found)
+		switch {
+		case r.found:
+			stopAll()
+			copy(s.solution, r.solver.solution)
+			logger.Printf("Portfolio: %s won", r.engine)
+			events.Emit("portfolio", jsonl.Fields{"winner": r.engine, "found": true, "seconds": r.took.Seconds()})
+			return true
+		case r.engine == "sat" && !s.budget.Stopped():
+			stopAll()
+			s.proved = r.solver.proved
+			logger.Printf("Portfolio: sat proved there is no solution")
+			events.Emit("portfolio", jsonl.Fields{"winner": "sat", "found": false, "seconds": r.took.Seconds()})
+			return false
+		}
+	}
+	events.Emit("portfolio", jsonl.Fields{"winner": nil, "found": false, "seconds": time.Since(start).Seconds()})
+	return false
+}
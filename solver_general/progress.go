@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"hexagon_clink/pkg/jsonl"
+)
+
+// Why a branch of the search was cut
+const (
+	pruneBound    = iota // too few edges left to cover the missing pairs
+	pruneOverlap         // the arrangement would exceed its overlap limit
+	pruneDoomed          // last arrangement: a pair with a placed item can't be covered anymore
+	pruneSymmetry        // arr1: item not the smallest in its orbit
+	prunePartners        // last arrangement: item has more pairs left than the slot has neighbors
+	pruneNoGood          // state already searched without a solution (noGoodTable)
+	numPrunes
+)
+
+var pruneNames = [numPrunes]string{"bound", "overlap", "doomed", "symmetry", "partners", "nogood"}
+
+// levelStats counts the search for one arrangement (arr1..arr(k-1)), summed
+// over all workers.
+type levelStats struct {
+	nodes    atomic.Int64 // slots filled
+	complete atomic.Int64 // arrangements completed within the limits
+	pruned   [numPrunes]atomic.Int64
+}
+
+// levelCount is a worker's unflushed share of a levelStats, so the workers
+// don't contend on the shared counters.
+type levelCount struct {
+	nodes, complete int64
+	pruned          [numPrunes]int64
+}
+
+func (c *levelCount) flushTo(st *levelStats) {
+	st.nodes.Add(c.nodes)
+	st.complete.Add(c.complete)
+	for r, v := range c.pruned {
+		st.pruned[r].Add(v)
+	}
+	*c = levelCount{}
+}
+
+// reached returns the number of levels the search has filled slots in.
+func (s *Solver) reached() int {
+	n := 0
+	for i := range s.stats {
+		if s.stats[i].nodes.Load() > 0 {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// levelFields returns the counters of every level that was reached, for
+// progress events.
+func (s *Solver) levelFields() []jsonl.Fields {
+	var levels []jsonl.Fields
+	for i := 0; i < s.reached(); i++ {
+		st := &s.stats[i]
+		pruned := jsonl.Fields{}
+		for r, name := range pruneNames {
+			pruned[name] = st.pruned[r].Load()
+		}
+		levels = append(levels, jsonl.Fields{
+			"level": i + 1, "nodes": st.nodes.Load(), "complete": st.complete.Load(), "pruned": pruned,
+		})
+	}
+	return levels
+}
+
+// printLevels prints one line of counters per level reached.
+func (s *Solver) printLevels(prefix string) {
+	fmt.Print(s.levelLines(prefix))
+}
+
+// levelLines returns the lines printLevels prints.
+func (s *Solver) levelLines(prefix string) string {
+	var b strings.Builder
+	for i := 0; i < s.reached(); i++ {
+		st := &s.stats[i]
+		fmt.Fprintf(&b, "%sarr%d: nodes %d, complete %d, pruned", prefix, i+1, st.nodes.Load(), st.complete.Load())
+		for r, name := range pruneNames {
+			fmt.Fprintf(&b, " %s %d", name, st.pruned[r].Load())
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// reportProgress prints the per-level counters every interval (and emits
+// them as progress events) until the returned stop is called. An interval
+// of 0 reports nothing.
+func (s *Solver) reportProgress(every time.Duration) (stop func()) {
+	if every <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(start)
+				logger.Printf("Progress: %.0f nodes/s\n%s", float64(s.nodes())/elapsed.Seconds(), s.levelLines("  "))
+				events.Emit("progress", jsonl.Fields{
+					"levels": s.levelFields(), "nodes_per_second": float64(s.nodes()) / elapsed.Seconds(),
+				})
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// nodes returns the slots filled at all levels so far.
+func (s *Solver) nodes() int64 {
+	var total int64
+	for i := range s.stats {
+		total += s.stats[i].nodes.Load()
+	}
+	return total
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/metrics"
+)
+
+var restartsDone = metrics.NewCounter("solver_general_restarts_total", "Search runs abandoned at their node cutoff and restarted.")
+
+// worker is the state of one search goroutine: its random source, the
+// nodes its current run may still visit (negative: unlimited), the nodes
+// it visited in all and its buffers. When the budget runs out, cut is set
+// and the run unwinds.
+type worker struct {
+	rng    *rand.Rand
+	budget int64
+	cut    bool
+	nodes  int64
+	log    *logging.Logger // tagged with the worker's ID
+
+	levels  []levelScratch // per level, allocated on first use
+	parents [][]int        // parents[i]: the arr(i+1) being extended, in levels[i].arr
+}
+
+// levelScratch holds the buffers of one level's search, which every solve
+// call of the worker at that level reuses: the search at a level ends
+// before the worker starts another one at the same level.
+type levelScratch struct {
+	arr       []int
+	used      []bool
+	usedItems []int
+	newPairs  [][]int // per slot: the pairs the item placed there covers
+	needed    []int   // per item: coverings its pairs lack, at the last level
+	order     itemOrder
+}
+
+// scratch returns the worker's buffers for level of s's search.
+func (w *worker) scratch(s *Solver, level int) *levelScratch {
+	if w.levels == nil {
+		w.levels = make([]levelScratch, s.k)
+		w.parents = make([][]int, s.k)
+	}
+	sc := &w.levels[level]
+	if sc.arr == nil {
+		sc.arr = make([]int, s.n)
+		sc.used = make([]bool, s.n)
+		sc.usedItems = make([]int, 0, s.n)
+		sc.newPairs = make([][]int, s.n)
+		for slot := range sc.newPairs {
+			sc.newPairs[slot] = make([]int, 0, s.n) // a slot has fewer than n neighbors
+		}
+		sc.needed = make([]int, s.n)
+	}
+	return sc
+}
+
+// restartPolicy gives the node cutoff of each run of a worker: a fixed
+// number, or unit times the Luby sequence 1, 1, 2, 1, 1, 2, 4, 1, ...
+// The zero policy never restarts.
+type restartPolicy struct {
+	luby bool
+	unit int64
+}
+
+// parseRestart reads -restart: "" (off), "fixed:N" or "luby:N", N in nodes.
+func parseRestart(s string) (restartPolicy, error) {
+	if s == "" {
+		return restartPolicy{}, nil
+	}
+	kind, num, ok := strings.Cut(s, ":")
+	unit, err := strconv.ParseInt(num, 10, 64)
+	if !ok || err != nil || unit <= 0 || kind != "fixed" && kind != "luby" {
+		return restartPolicy{}, fmt.Errorf("invalid -restart %q (use fixed:N or luby:N, N nodes > 0)", s)
+	}
+	return restartPolicy{luby: kind == "luby", unit: unit}, nil
+}
+
+func (p restartPolicy) String() string {
+	switch {
+	case p.unit == 0:
+		return "off"
+	case p.luby:
+		return fmt.Sprintf("luby:%d", p.unit)
+	}
+	return fmt.Sprintf("fixed:%d", p.unit)
+}
+
+// cutoff returns the node budget of run i (1-based), or -1 for no limit.
+func (p restartPolicy) cutoff(i int) int64 {
+	if p.unit == 0 {
+		return -1
+	}
+	if !p.luby {
+		return p.unit
+	}
+	return p.unit * luby(i)
+}
+
+// luby returns the i-th term (1-based) of the Luby sequence: if i = 2^k-1
+// it is 2^(k-1), otherwise the sequence repeats from the start.
+func luby(i int) int64 {
+	for {
+		k := 1
+		for 1<<k-1 < i {
+			k++
+		}
+		if i == 1<<k-1 {
+			return 1 << (k - 1)
+		}
+		i -= 1<<(k-1) - 1
+	}
+}
+
+func (s *Solver) SetRestart(p restartPolicy) {
+	s.restart = p
+}
+
+// run searches task's subtree from arr0's coverage until a solution is
+// found, or a run finishes within its cutoff, which means the whole
+// subtree was searched, or the budget runs out.
+// Every restart reshuffles, since the next run draws new item orders.
+func (s *Solver) run(w *worker, covered tally, coveredCount int, task *searchTask) {
+	for i := 1; ; i++ {
+		w.budget, w.cut = s.restart.cutoff(i), false
+		s.solve(0, covered, coveredCount, nil, w, task)
+		if !w.cut || s.done() {
+			return
+		}
+		s.restarts.Add(1)
+		restartsDone.Add(1)
+		w.log.Debugf("restart %d after %d nodes", i, s.restart.cutoff(i))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/crillab/gophersat/solver"
+
+	"hexagon_clink/pkg/encoding"
+)
+
+// SolveSAT decides the whole covering problem with a SAT solver instead of
+// the randomized search, so a "no" is a proof. arr0 is fixed to the identity
+// as in Solve, unless the multiplicity isn't uniform (arr0Free): then it is
+// placed like the others, as fixing it would lose solutions. x(i, item,
+// slot) places item at slot of arrangement i, and every pair the fixed
+// arr0 leaves uncovered needs, in some arrangement, one item at a slot and
+// the other at an adjacent slot (in as many arrangements as
+// SetMultiplicity asks). Overlap limits don't apply, and of the budget only
+// the timeout does.
+func (s *Solver) SolveSAT() bool {
+	n := s.n
+	free := s.arr0Free()
+	first := 1 // first arrangement the solver places
+	covered := s.newTally()
+	if free {
+		first = 0
+	} else {
+		arr0 := make([]int, n)
+		for i := range arr0 {
+			arr0[i] = i
+		}
+		s.solution[0] = arr0
+		covered = s.coveredByArr0()
+		if s.k == 1 {
+			return len(s.Uncovered(s.solution[:1])) == 0
+		}
+	}
+
+	// Each arrangement is a permutation; a sequential counter for at most
+	// one needs 3n clauses per item and slot instead of the n(n-1)/2 of the
+	// pairwise encoding
+	c := &encoding.CNF{}
+	perms := make([]encoding.Permutation, s.k)
+	for i := first; i < s.k; i++ {
+		perms[i] = c.NewPermutation(n)
+	}
+	for i := first; i < s.k; i++ {
+		c.PermutationConstraints(perms[i], encoding.Sequential)
+	}
+
+	// neighbors[i][slot]: slots adjacent to slot in arrangement i's shape
+	neighbors := make([][][]int, s.k)
+	for i, shape := range s.shapes {
+		neighbors[i] = make([][]int, n)
+		for _, e := range shape.edges {
+			neighbors[i][e.a] = append(neighbors[i][e.a], e.b)
+			neighbors[i][e.b] = append(neighbors[i][e.b], e.a)
+		}
+	}
+
+	// Coverage: at(i, a, b, slot) means a sits at slot in arrangement i
+	// with b next to it. A pair needed by r > 1 arrangements gets one
+	// variable per arrangement, implying one of its at(i, ...), and at
+	// least r of those.
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			pi := s.pairIndex(a, b)
+			r := covered.short(pi)
+			if !free {
+				var err error
+				if r, err = s.stillNeeded(pi, covered); err != nil {
+					logger.Printf("%v", err)
+					s.proved = true
+					return false
+				}
+			}
+			if r == 0 {
+				continue
+			}
+			var ways, by []int
+			for i := first; i < s.k; i++ {
+				at := c.NextTo(perms[i], a, b, neighbors[i])
+				ways = append(ways, at...)
+				if r > 1 && len(at) > 0 {
+					v := c.NewVar()
+					c.Add(append([]int{-v}, at...)...)
+					by = append(by, v)
+				}
+			}
+			if len(ways) == 0 {
+				s.proved = true
+				return false
+			}
+			if r == 1 {
+				c.Add(ways...)
+			} else {
+				c.AtLeast(r, by)
+			}
+		}
+	}
+
+	// Same symmetry breaking as the search: arr1's first slot holds the
+	// smallest item of its orbit under arr0's automorphisms (BreakSymmetry
+	// leaves autos nil when arr0 is free)
+	if s.autos != nil && !free {
+		for item := 0; item < n; item++ {
+			if !orbitMin(s.autos, item) {
+				c.Add(-perms[1].Var(item, 0))
+			}
+		}
+	}
+
+	logger.Printf("CNF: %d variables, %d clauses", c.NumVars, len(c.Clauses))
+	workersBusy.Add(1)
+	defer workersBusy.Add(-1)
+
+	// gophersat can't be interrupted: when the budget runs out first, the
+	// solver is left to finish in the background
+	sat := solver.New(solver.ParseSlice(c.Clauses))
+	status := make(chan solver.Status, 1)
+	go func() { status <- sat.Solve() }()
+	select {
+	case st := <-status:
+		if st != solver.Sat {
+			s.proved = true
+			return false
+		}
+	case <-s.budget.Context().Done():
+		return false
+	}
+	model := sat.Model()
+	for i := first; i < s.k; i++ {
+		s.solution[i] = perms[i].Decode(model)
+	}
+	return true
+}
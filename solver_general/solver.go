@@ -1,15 +1,35 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/budget"
+	"hexagon_clink/pkg/config"
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/coverage"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/metrics"
+	"hexagon_clink/pkg/pairs"
+	"hexagon_clink/pkg/prof"
+	"hexagon_clink/pkg/resultdb"
+	"hexagon_clink/pkg/subiso"
+	"hexagon_clink/pkg/zfile"
 )
 
 var hexDirs = [6][2]float64{
@@ -79,25 +99,19 @@ func buildSpiral(n int) []Edge {
 	return edges
 }
 
-type Solver struct {
-	n, k          int
-	numPairs      int
-	numEdges      int
-	edges         []Edge
-	slotAdj       [][]int
-	remEdges      []int
-	pairTable     [][]int
-	maxOverlapArr []int // per-level overlap limits, nil means use dynamic calculation
-
-	solution      [][]int
-	found         int32
-	printedLevel  []int32 // track if we've printed first solution at each level
-	mu            sync.Mutex
+// Shape is one host graph an arrangement is laid out on. Slots are filled
+// in order, and slotAdj[s] lists the earlier slots adjacent to slot s.
+type Shape struct {
+	name     string
+	edges    []Edge
+	numEdges int
+	slotAdj  [][]int
+	remEdges []int   // remEdges[s]: edges with an endpoint at slot s or later
+	frontier [][]int // frontier[s]: slots before s adjacent to slot s or later
+	vertex   []int   // original vertex of each slot
 }
 
-func NewSolver(n, k int) *Solver {
-	edges := buildSpiral(n)
-
+func newShape(name string, n int, edges []Edge, vertex []int) *Shape {
 	slotAdj := make([][]int, n)
 	for s := 0; s < n; s++ {
 		for _, e := range edges {
@@ -118,131 +132,729 @@ func NewSolver(n, k int) *Solver {
 		}
 	}
 
-	pairTable := make([][]int, n)
+	frontier := make([][]int, n+1)
+	for slot := 0; slot <= n; slot++ {
+		for f := 0; f < slot; f++ {
+			for _, e := range edges {
+				if e.a == f && e.b >= slot || e.b == f && e.a >= slot {
+					frontier[slot] = append(frontier[slot], f)
+					break
+				}
+			}
+		}
+	}
+
+	if vertex == nil {
+		vertex = make([]int, n)
+		for i := range vertex {
+			vertex[i] = i
+		}
+	}
+	return &Shape{
+		name:     name,
+		edges:    edges,
+		numEdges: len(edges),
+		slotAdj:  slotAdj,
+		remEdges: remEdges,
+		frontier: frontier,
+		vertex:   vertex,
+	}
+}
+
+// byVertex turns an arrangement indexed by slot into one indexed by the
+// shape's original vertex numbers, as given in its .g6 line.
+func (sh *Shape) byVertex(arr []int) []int {
+	out := make([]int, len(arr))
+	for slot, item := range arr {
+		out[sh.vertex[slot]] = item
+	}
+	return out
+}
+
+// hostEdges returns the shape's edges between its original vertices.
+func (sh *Shape) hostEdges() [][2]int {
+	edges := make([][2]int, len(sh.edges))
+	for i, e := range sh.edges {
+		edges[i] = [2]int{sh.vertex[e.a], sh.vertex[e.b]}
+	}
+	return edges
+}
+
+// automorphisms returns the shape's automorphism group as slot maps, or
+// nil if it is too large to list (or n > 64).
+func (sh *Shape) automorphisms(n int) [][]int {
+	if n > 64 {
+		return nil
+	}
+	adj := make([]uint64, n)
+	for _, e := range sh.edges {
+		adj[e.a] |= 1 << e.b
+		adj[e.b] |= 1 << e.a
+	}
+	perms, ok := subiso.Automorphisms(adj, maxAutomorphisms)
+	if !ok {
+		return nil
+	}
+	return perms
+}
+
+// specialFirst returns a copy of sh with a minimum-degree slot (the last
+// such) moved to the front and the others in their order, the slot map
+// from the copy's slots to sh's, and every slot's degree in the copy.
+func (sh *Shape) specialFirst(n int) (*Shape, []int, []int) {
+	degree := make([]int, n)
+	for _, e := range sh.edges {
+		degree[e.a]++
+		degree[e.b]++
+	}
+	special := 0
+	for slot := range degree {
+		if degree[slot] <= degree[special] {
+			special = slot
+		}
+	}
+	from := []int{special}
+	for slot := 0; slot < n; slot++ {
+		if slot != special {
+			from = append(from, slot)
+		}
+	}
+	to := make([]int, n)
+	vertex := make([]int, n)
+	newDegree := make([]int, n)
+	for slot, old := range from {
+		to[old] = slot
+		vertex[slot] = sh.vertex[old]
+		newDegree[slot] = degree[old]
+	}
+	edges := make([]Edge, len(sh.edges))
+	for i, e := range sh.edges {
+		a, b := to[e.a], to[e.b]
+		if a > b {
+			a, b = b, a
+		}
+		edges[i] = Edge{a, b}
+	}
+	return newShape(sh.name, n, edges, vertex), from, newDegree
+}
+
+// spiralShape is the hexagon spiral, whose construction order already
+// places every node next to earlier ones.
+func spiralShape(n int) *Shape {
+	return newShape("spiral", n, buildSpiral(n), nil)
+}
+
+// graphShape turns a graph read from a .g6 file into a shape. Slots are
+// assigned in connectivity order (highest degree first, then always the
+// vertex with the most already-placed neighbors), like the spiral, so the
+// overlap of a partial arrangement is known as early as possible.
+func graphShape(name string, g invariants.Graph) *Shape {
+	n := g.N
+	degree := func(v int) int {
+		d := 0
+		for u := 0; u < n; u++ {
+			if g.HasEdge(u, v) {
+				d++
+			}
+		}
+		return d
+	}
+	slotOf := make([]int, n)
+	for i := range slotOf {
+		slotOf[i] = -1
+	}
+	vertex := make([]int, 0, n)
+	for len(vertex) < n {
+		best, bestLinks := -1, -1
+		for v := 0; v < n; v++ {
+			if slotOf[v] >= 0 {
+				continue
+			}
+			links := 0
+			for _, u := range vertex {
+				if g.HasEdge(u, v) {
+					links++
+				}
+			}
+			if best < 0 || links > bestLinks || links == bestLinks && degree(v) > degree(best) {
+				best, bestLinks = v, links
+			}
+		}
+		slotOf[best] = len(vertex)
+		vertex = append(vertex, best)
+	}
+
+	var edges []Edge
 	for a := 0; a < n; a++ {
-		pairTable[a] = make([]int, n)
-		for b := 0; b < n; b++ {
-			if a < b {
-				pairTable[a][b] = a*n - a*(a+1)/2 + (b - a - 1)
-			} else if b < a {
-				pairTable[a][b] = b*n - b*(b+1)/2 + (a - b - 1)
+		for b := a + 1; b < n; b++ {
+			if g.HasEdge(vertex[a], vertex[b]) {
+				edges = append(edges, Edge{a, b})
 			}
 		}
 	}
+	return newShape(name, n, edges, vertex)
+}
+
+// loadShapes reads host graphs from a .g6 file; all must have n vertices
+func loadShapes(path string) ([]*Shape, int, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var shapes []*Shape
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		g, err := invariants.ParseGraph6(scanner.Text())
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if n == 0 {
+			n = g.N
+		} else if g.N != n {
+			return nil, 0, fmt.Errorf("%s:%d: graph on %d vertices, expected %d", path, line, g.N, n)
+		}
+		shapes = append(shapes, graphShape(shapeName(len(shapes)), g))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(shapes) == 0 {
+		return nil, 0, fmt.Errorf("%s: no graphs", path)
+	}
+	return shapes, n, nil
+}
+
+// shapeName labels loaded graphs A, B, C, ... in file order
+func shapeName(i int) string {
+	if i < 26 {
+		return string(rune('A' + i))
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// shapeMultisets returns every way to pick k shapes out of count with
+// repetition, as non-decreasing index lists. The arrangements are
+// interchangeable, so a multiset covers all of its orderings.
+func shapeMultisets(count, k int) [][]int {
+	var result [][]int
+	pick := make([]int, k)
+	var rec func(pos, from int)
+	rec = func(pos, from int) {
+		if pos == k {
+			result = append(result, append([]int(nil), pick...))
+			return
+		}
+		for i := from; i < count; i++ {
+			pick[pos] = i
+			rec(pos+1, i)
+		}
+	}
+	rec(0, 0)
+	return result
+}
+
+// events receives -json output; nil (discarding) otherwise
+var events *jsonl.Emitter
+
+// logger prints the progress lines at -log-level
+var logger *logging.Logger
+
+// run records the inputs of the run for the manifests of its outputs
+var run *manifest.Run
+
+// wrote writes the manifest of an output file or directory just written.
+func wrote(path string) {
+	if err := run.Wrote(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// maxAutomorphisms caps the group listed for symmetry breaking; the
+// stabilizer filter is linear in its size
+const maxAutomorphisms = 1 << 12
+
+type Solver struct {
+	n, k          int
+	numPairs      int
+	required      int      // coverings required: numPairs, or the multiplicity's total
+	shapes        []*Shape // shapes[i] hosts arrangement i
+	edgesFrom     []int    // edgesFrom[i]: total edges of shapes[i:]
+	sameFrom      []bool   // sameFrom[i]: shapes[i:] are all one shape
+	pairs         *cover.Table
+	maxOverlapArr []int   // per-level overlap limits, nil means use dynamic calculation
+	autos         [][]int // automorphisms of shapes[0], for symmetry breaking on arr1
+	autos1        [][]int // automorphisms of arr1's shape but the identity, for the lex-leader check
+	lexOrder      bool    // arrangements on the same shape come in lexicographic order
+	heuristic     heuristic
+	restart       restartPolicy
+	annealSteps   int64
+	annealRuns    int
+	restarts      atomic.Int64
+	budget        *budget.Budget
+	noGoods       *noGoodTable
+	need          cover.Multiplicity // coverings per pair, nil: one each (SetMultiplicity)
+	proved        bool               // SolveSAT proved there is no solution, for any arr0
+
+	// Set by SpecialSlot: the last arrangement is searched on last, which is
+	// shapes[k-1] with a minimum-degree slot first; lastSlot maps its slots
+	// back and lastDegree holds their degrees.
+	last       *Shape
+	lastSlot   []int
+	lastDegree []int
+
+	stats        []levelStats // stats[i]: search for arr(i+1)
+	solution     [][]int
+	found        int32
+	printedLevel []int32 // track if we've printed first solution at each level
+	mu           sync.Mutex
+}
+
+// NewSolver searches for len(shapes) arrangements of n items, arrangement i
+// laid out on shapes[i].
+func NewSolver(n int, shapes []*Shape) *Solver {
+	k := len(shapes)
+	edgesFrom := make([]int, k+1)
+	sameFrom := make([]bool, k+1)
+	sameFrom[k] = true
+	for i := k - 1; i >= 0; i-- {
+		edgesFrom[i] = edgesFrom[i+1] + shapes[i].numEdges
+		sameFrom[i] = sameFrom[i+1] && (i == k-1 || shapes[i] == shapes[i+1])
+	}
 
 	return &Solver{
 		n:            n,
 		k:            k,
-		numPairs:     n * (n - 1) / 2,
-		numEdges:     len(edges),
-		edges:        edges,
-		slotAdj:      slotAdj,
-		remEdges:     remEdges,
-		pairTable:    pairTable,
+		numPairs:     pairs.Count(n),
+		required:     pairs.Count(n),
+		shapes:       shapes,
+		edgesFrom:    edgesFrom,
+		sameFrom:     sameFrom,
+		pairs:        cover.NewTable(n),
+		stats:        make([]levelStats, k),
 		solution:     make([][]int, k),
 		printedLevel: make([]int32, k),
 	}
 }
 
 func (s *Solver) pairIndex(a, b int) int {
-	return s.pairTable[a][b]
+	return s.pairs.Index(a, b)
 }
 
+// SetMaxOverlap fixes the overlap allowed at each level: limits[i] for
+// arr(i+1), where a negative entry or a level past the end of limits keeps
+// the dynamic limit.
 func (s *Solver) SetMaxOverlap(limits []int) {
 	s.maxOverlapArr = limits
 }
 
-func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [][]int, rng *rand.Rand) {
-	if atomic.LoadInt32(&s.found) != 0 {
+// SpecialSlot makes the search fill a minimum-degree slot of the last
+// arrangement's shape first, and only with items that have at most that
+// many pairs left to cover, as solver_20 does with slot 19 (degree 2) of the
+// n=20 spiral. At the last level every placed item must also have no more
+// uncovered pairs than its slot has neighbors. Returns the slot, as a vertex
+// of the shape, and its degree.
+func (s *Solver) SpecialSlot() (vertex, degree int) {
+	if s.k < 2 {
+		return -1, 0
+	}
+	s.last, s.lastSlot, s.lastDegree = s.shapes[s.k-1].specialFirst(s.n)
+	if s.k == 2 && s.lexOrder {
+		s.autos1 = s.hostAutos(s.last) // arr1 is searched on last's slots
+	}
+	return s.last.vertex[0], s.lastDegree[0]
+}
+
+// fromLast turns an arrangement on s.last back into one on shapes[k-1].
+func (s *Solver) fromLast(arr []int) []int {
+	out := make([]int, len(arr))
+	for slot, item := range arr {
+		out[s.lastSlot[slot]] = item
+	}
+	return out
+}
+
+// BreakSymmetry lists the automorphisms of arr0's shape and returns the
+// group order (0 if too large to use). With arr0 fixed to the identity, an
+// automorphism σ relabels the items of a solution into another solution, so
+// arr1 only needs items that are the smallest in their orbit under the
+// automorphisms fixing the items already placed in arr1.
+//
+// Beyond that, solutions turn into each other by moving an arrangement
+// along an automorphism τ of its own shape and by swapping two
+// arrangements on the same shape, and the search keeps only the smallest,
+// read as arr1, arr2, ... slot by slot: arr1 must be no larger than
+// σ∘arr1∘τ (autos1), which changes no level's overlap; and an arrangement
+// smaller than the one before it on the same shape is dropped if the two
+// would also pass the overlap limits in the other order (lexOrder), which
+// the search then finds. Of the solutions the limits accept, the smallest
+// of each class is kept, so none is lost.
+//
+// A multiplicity that isn't uniform (SetMultiplicity) doesn't survive
+// relabeling the items, so then only τ and the order of the arrangements
+// are used, and the group order returned is 1.
+func (s *Solver) BreakSymmetry() int {
+	s.lexOrder = true
+	level0 := s.shapes[1%s.k]
+	if s.k == 2 && s.last != nil {
+		level0 = s.last
+	}
+	s.autos1 = s.hostAutos(level0)
+	s.autos = nil
+	if s.need == nil || s.need.IsUniform() {
+		s.autos = s.shapes[0].automorphisms(s.n)
+	}
+	if len(s.autos) <= 1 {
+		s.autos = nil
+		return 1
+	}
+	return len(s.autos)
+}
+
+// hostAutos returns the automorphisms of arr1's shape sh other than the
+// identity, or nil if there are none or too many to pair with arr0's.
+func (s *Solver) hostAutos(sh *Shape) [][]int {
+	var autos [][]int
+	for _, perm := range sh.automorphisms(s.n) {
+		for slot, to := range perm {
+			if slot != to {
+				autos = append(autos, perm)
+				break
+			}
+		}
+	}
+	if len(autos) == 0 || (len(s.autos)+1)*len(autos) > maxAutomorphisms {
+		return nil
+	}
+	return autos
+}
+
+// lexLeader reports whether arr1, filled up to slot, may still be no larger
+// than σ∘arr1∘τ for every σ in autos and τ in autos1: the two are compared
+// slot by slot as far as both are known.
+func (s *Solver) lexLeader(arr []int, slot int) bool {
+	sigmas := s.autos
+	if sigmas == nil {
+		sigmas = [][]int{nil}
+	}
+	for _, tau := range s.autos1 {
+		for _, sigma := range sigmas {
+			for j := 0; j <= slot && tau[j] <= slot; j++ {
+				other := arr[tau[j]]
+				if sigma != nil {
+					other = sigma[other]
+				}
+				if other != arr[j] {
+					if other < arr[j] {
+						return false
+					}
+					break
+				}
+			}
+		}
+	}
+	return true
+}
+
+// searchTask restricts solve to the arr1s whose first slots hold prefix,
+// so tasks with different prefixes search disjoint subtrees. With emit set
+// solve enumerates exhaustively instead of looking for a solution: every
+// arr1..arr(k-2) covering at least minCovered pairs goes to emit instead of
+// being extended (in the worker's buffers, valid only during the call).
+// With solution set instead, every solution arr1..arr(k-1) goes to it (a
+// copy, the last arrangement on s.last's slots) and the search goes on.
+type searchTask struct {
+	prefix     []int
+	minCovered int
+	emit       func(arrs [][]int)
+	solution   func(arrs [][]int)
+}
+
+// overlapLimit returns the overlap allowed to the arrangement at level
+// (arr(level+1)) on shape with missing pairs left: the explicit limit if
+// provided, otherwise dynamic. If this and the later arrangements are all
+// on one shape, they can go in any order, and one of them covers at least
+// its share of the missing pairs, in proportion to its edges, so this one
+// must. With other shapes after it, each position keeps its shape and no
+// such order exists; this one must only cover what the later shapes' edges
+// can't.
+func (s *Solver) overlapLimit(level, missing int, shape *Shape) int {
+	if level < len(s.maxOverlapArr) && s.maxOverlapArr[level] >= 0 {
+		return s.maxOverlapArr[level]
+	}
+	if !s.sameFrom[level+1] {
+		return shape.numEdges - max(missing-s.edgesFrom[level+2], 0)
+	}
+	share := s.edgesFrom[level+1]
+	minNewEdges := (missing*shape.numEdges + share - 1) / share
+	return shape.numEdges - minNewEdges
+}
+
+// swapAccepted reports whether the search would also accept arr, complete
+// at level, and the arrangement before it on the same shape in the other
+// order: arr within the overlap limit of the level before, against the
+// pairs covered before that, and then the other one, and arr passing
+// arr1's symmetry checks if it moves there. The limits depend on the order,
+// so only then may the lexicographic order of the two be enforced.
+func (s *Solver) swapAccepted(level int, parentArrs [][]int, arr []int) bool {
+	covered := s.coveredByArr0()
+	for i, other := range parentArrs[:level-1] {
+		for _, e := range s.shapes[i+1].edges {
+			if pi := s.pairIndex(other[e.a], other[e.b]); !covered.Has(pi) {
+				covered.Add(pi)
+			}
+		}
+	}
+	count := covered.Covered()
+	shape := s.shapes[level]
+	for i, a := range [][]int{arr, parentArrs[level-1]} {
+		limit := s.overlapLimit(level-1+i, s.required-count, shape)
+		overlap := 0
+		for _, e := range shape.edges {
+			pi := s.pairIndex(a[e.a], a[e.b])
+			if covered.Has(pi) {
+				overlap++
+			} else {
+				covered.Add(pi)
+				count++
+			}
+		}
+		if overlap > limit {
+			return false
+		}
+	}
+	return level > 1 || s.leadsArr1(arr)
+}
+
+// leadsArr1 reports whether the complete arr passes arr1's symmetry checks:
+// each item the smallest of its orbit under the automorphisms fixing the
+// ones before, and arr no larger than σ∘arr∘τ.
+func (s *Solver) leadsArr1(arr []int) bool {
+	stab := s.autos
+	for _, item := range arr {
+		if stab == nil {
+			break
+		}
+		if !orbitMin(stab, item) {
+			return false
+		}
+		var next [][]int
+		for _, perm := range stab {
+			if perm[item] == item {
+				next = append(next, perm)
+			}
+		}
+		stab = next
+	}
+	return s.autos1 == nil || s.lexLeader(arr, len(arr)-1)
+}
+
+// solve searches arr(level+1) and, through recursion, the arrangements
+// after it, given the coverings made by arr0..arr(level) (coveredCount of
+// them count toward s.required). It adds the pairs of each arrangement it
+// places to covered and removes them again on the way back, so covered is
+// as it was when solve returns.
+func (s *Solver) solve(level int, covered tally, coveredCount int, parentArrs [][]int, w *worker, task *searchTask) {
+	if s.done() {
 		return
 	}
 
+	shape := s.shapes[level+1]
 	remaining := s.k - level - 1
-	missing := s.numPairs - coveredCount
+	missing := s.required - coveredCount
+
+	lastSlots := remaining == 1 && s.last != nil
+	if lastSlots {
+		shape = s.last
+	}
 
-	if missing > remaining*s.numEdges {
+	if missing > s.edgesFrom[level+1] {
+		s.stats[level].pruned[pruneBound].Add(1)
 		return
 	}
 
-	// Calculate max overlap: use explicit limit if provided, otherwise dynamic
-	var maxOverlap int
-	if s.maxOverlapArr != nil && level < len(s.maxOverlapArr) {
-		maxOverlap = s.maxOverlapArr[level]
-	} else {
-		minNewEdges := (missing + remaining - 1) / remaining
-		maxOverlap = s.numEdges - minNewEdges
+	maxOverlap := s.overlapLimit(level, missing, shape)
+
+	sc := w.scratch(s, level)
+	arr, used, usedItems := sc.arr, sc.used, sc.usedItems[:0]
+	clear(used)
+
+	// needed[item]: coverings item's pairs still lack; at the last level an
+	// item can only go to a slot with at least that many neighbors
+	var needed []int
+	if lastSlots {
+		needed = s.uncoveredPairs(covered, sc.needed)
 	}
 
-	arr := make([]int, s.n)
-	used := make([]bool, s.n)
-	usedItems := make([]int, 0, s.n)
-	coveredSet := make([]bool, s.numPairs)
-	copy(coveredSet, covered)
+	order := &sc.order
+	order.reset(s, shape, covered, w.rng)
 
-	order := make([]int, s.n)
-	for i := 0; i < s.n; i++ {
-		order[i] = i
+	// stab[slot]: automorphisms fixing every item in arr[:slot] (arr1 only)
+	var stab [][][]int
+	if level == 0 && s.autos != nil {
+		stab = make([][][]int, s.n+1)
+		stab[0] = s.autos
+	}
+	// prev: the arrangement before this one, if on the same shape
+	var prev []int
+	if s.lexOrder && level > 0 && shape == s.shapes[level] {
+		prev = parentArrs[level-1]
+	}
+
+	// The no-good table only holds states whose subtree depends on nothing
+	// else: not under arr1's symmetry checks, which read all of arr1, nor
+	// when the next arrangement is compared with this one (lexOrder). In a
+	// state under prev, arr must already be larger, so it can't be swapped.
+	noGoods := s.noGoods
+	if task.emit != nil || task.solution != nil || level == 0 && (s.autos != nil || s.autos1 != nil) {
+		noGoods = nil
+	}
+	if s.lexOrder && remaining > 1 {
+		next := s.shapes[level+2]
+		if remaining == 2 && s.last != nil {
+			next = s.last
+		}
+		if next == shape {
+			noGoods = nil
+		}
+	}
+	// zCovered and zUsed: running Zobrist hashes of covered and used
+	var zCovered, zUsed uint64
+	if noGoods != nil {
+		for pi := 0; pi < s.numPairs; pi++ {
+			for j := 1; j <= covered.times(pi); j++ {
+				zCovered ^= noGoods.zCover(pi, j)
+			}
+		}
 	}
-	rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+
+	var count levelCount
+	defer func() {
+		s.countNodes(count.nodes)
+		count.flushTo(&s.stats[level])
+	}()
 
 	var enumerate func(slot, overlap, localCovered int)
 	enumerate = func(slot, overlap, localCovered int) {
-		if atomic.LoadInt32(&s.found) != 0 {
+		if s.done() || w.cut {
 			return
 		}
+		if w.budget >= 0 {
+			if w.budget == 0 {
+				w.cut = true
+				return
+			}
+			w.budget--
+		}
+		w.nodes++
+		if count.nodes++; count.nodes == nodeFlush {
+			s.countNodes(count.nodes)
+			count.flushTo(&s.stats[level])
+		}
 
-		missingNow := s.numPairs - localCovered
-		maxPossible := s.remEdges[slot] + (remaining-1)*s.numEdges
+		missingNow := s.required - localCovered
+		maxPossible := shape.remEdges[slot] + s.edgesFrom[level+2]
 		if missingNow > maxPossible {
+			count.pruned[pruneBound]++
 			return
 		}
 
+		var key uint64
+		start := w.nodes
+		noGood := noGoods != nil && slot < s.n && !(level == 0 && slot < len(task.prefix)) &&
+			(prev == nil || slices.Compare(arr[:slot], prev[:slot]) > 0)
+		if noGood {
+			key = noGoods.key(level, slot, maxOverlap-overlap, zCovered, zUsed, shape.frontier[slot], arr)
+			if noGoods.has(key) {
+				count.pruned[pruneNoGood]++
+				return
+			}
+		}
+
 		if slot == s.n {
-			arrCopy := make([]int, s.n)
-			copy(arrCopy, arr)
-			coveredCopy := make([]bool, s.numPairs)
-			copy(coveredCopy, coveredSet)
+			if prev != nil && slices.Compare(arr, prev) < 0 && s.swapAccepted(level, parentArrs, arr) {
+				count.pruned[pruneSymmetry]++
+				return
+			}
+			count.complete++
+			raiseBestCovered(localCovered)
 
-			newParentArrs := append(parentArrs, arrCopy)
+			// arr stays in the worker's buffer: whoever keeps it copies it
+			w.parents[level] = arr
+			newParentArrs := w.parents[:level+1]
+			if task.emit != nil && level == s.k-3 {
+				if localCovered >= task.minCovered {
+					task.emit(newParentArrs)
+				}
+				return
+			}
 
 			// Print first valid arrangement at this level
 			if atomic.CompareAndSwapInt32(&s.printedLevel[level], 0, 1) {
 				newEdges := localCovered - coveredCount
-				fmt.Printf("First valid arr%d: %v (overlap=%d, new=%d, covered=%d/%d)\n",
-					level+1, arrCopy, s.numEdges-newEdges, newEdges, localCovered, s.numPairs)
+				w.log.Printf("First valid arr%d: %v (overlap=%d, new=%d, covered=%d/%d)",
+					level+1, shape.byVertex(arr), shape.numEdges-newEdges, newEdges, localCovered, s.required)
+				events.Emit("level", jsonl.Fields{
+					"level": level + 1, "arrangement": shape.byVertex(arr), "overlap": shape.numEdges - newEdges,
+					"new": newEdges, "covered": localCovered, "pairs": s.required,
+				})
 			}
 
 			if level == s.k-2 {
-				if localCovered == s.numPairs {
+				if localCovered == s.required && task.solution != nil {
+					task.solution(cloneArrs(newParentArrs))
+				} else if localCovered == s.required {
 					s.mu.Lock()
 					if atomic.LoadInt32(&s.found) == 0 {
 						for i, perm := range newParentArrs {
-							s.solution[i+1] = perm
+							s.solution[i+1] = slices.Clone(perm)
+						}
+						if s.last != nil {
+							s.solution[s.k-1] = s.fromLast(s.solution[s.k-1])
 						}
 						atomic.StoreInt32(&s.found, 1)
 					}
 					s.mu.Unlock()
 				}
 			} else {
-				s.solve(level+1, coveredCopy, localCovered, newParentArrs, rng)
+				// Flush first: the subtree below may run for a long time
+				s.countNodes(count.nodes)
+				count.flushTo(&s.stats[level])
+				s.solve(level+1, covered, localCovered, newParentArrs, w, task)
 			}
 			return
 		}
 
-		for _, item := range order {
-			if atomic.LoadInt32(&s.found) != 0 {
+		for _, item := range order.at(slot, arr, covered) {
+			if s.done() || w.cut {
 				return
 			}
 			if used[item] {
 				continue
 			}
+			if stab != nil && !orbitMin(stab[slot], item) {
+				count.pruned[pruneSymmetry]++
+				continue
+			}
+			if level == 0 && slot < len(task.prefix) && item != task.prefix[slot] {
+				continue
+			}
+			if needed != nil && needed[item] > s.lastDegree[slot] {
+				count.pruned[prunePartners]++
+				continue
+			}
 
 			newOverlap := 0
-			var newPairs []int
-			for _, adjSlot := range s.slotAdj[slot] {
+			newPairs := sc.newPairs[slot][:0]
+			for _, adjSlot := range shape.slotAdj[slot] {
 				adjItem := arr[adjSlot]
 				pi := s.pairIndex(item, adjItem)
-				if coveredSet[pi] {
+				if covered.Has(pi) {
 					newOverlap++
 				} else {
 					newPairs = append(newPairs, pi)
@@ -250,6 +862,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			}
 
 			if overlap+newOverlap > maxOverlap {
+				count.pruned[pruneOverlap]++
 				continue
 			}
 
@@ -257,7 +870,7 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 				doomed := false
 				for _, other := range usedItems {
 					pi := s.pairIndex(item, other)
-					if coveredSet[pi] {
+					if covered.Has(pi) {
 						continue
 					}
 					found := false
@@ -267,21 +880,41 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 							break
 						}
 					}
-					if !found {
+					// This is the last arrangement to cover the pair, once
+					if !found || covered.short(pi) > 1 {
 						doomed = true
 						break
 					}
 				}
 				if doomed {
+					count.pruned[pruneDoomed]++
 					continue
 				}
 			}
 
 			arr[slot] = item
+			if level == 0 && s.autos1 != nil && !s.lexLeader(arr, slot) {
+				count.pruned[pruneSymmetry]++
+				continue
+			}
 			used[item] = true
 			usedItems = append(usedItems, item)
+			if noGoods != nil {
+				zUsed ^= noGoods.zItem[item]
+				for _, pi := range newPairs {
+					zCovered ^= noGoods.zCover(pi, covered.times(pi)+1)
+				}
+			}
+			if stab != nil {
+				stab[slot+1] = stab[slot+1][:0]
+				for _, perm := range stab[slot] {
+					if perm[item] == item {
+						stab[slot+1] = append(stab[slot+1], perm)
+					}
+				}
+			}
 			for _, pi := range newPairs {
-				coveredSet[pi] = true
+				covered.Add(pi)
 			}
 
 			enumerate(slot+1, overlap+newOverlap, localCovered+len(newPairs))
@@ -289,14 +922,101 @@ func (s *Solver) solve(level int, covered []bool, coveredCount int, parentArrs [
 			used[item] = false
 			usedItems = usedItems[:len(usedItems)-1]
 			for _, pi := range newPairs {
-				coveredSet[pi] = false
+				covered.Remove(pi)
+			}
+			if noGoods != nil {
+				zUsed ^= noGoods.zItem[item]
+				for _, pi := range newPairs {
+					zCovered ^= noGoods.zCover(pi, covered.times(pi)+1)
+				}
 			}
 		}
+		if noGood && w.nodes-start >= noGoodMinNodes && !s.done() && !w.cut {
+			noGoods.add(key)
+		}
 	}
 
 	enumerate(0, 0, coveredCount)
 }
 
+// SetBudget stops the search, and every other engine, when b runs out.
+func (s *Solver) SetBudget(b *budget.Budget) {
+	s.budget = b
+}
+
+// done reports whether the search should stop: a solution was found or
+// the budget ran out.
+func (s *Solver) done() bool {
+	return atomic.LoadInt32(&s.found) != 0 || s.budget.Stopped()
+}
+
+// countNodes adds a worker's batch of nodes to the metrics and the budget.
+func (s *Solver) countNodes(nodes int64) {
+	nodesExplored.Add(nodes)
+	s.budget.Add(nodes)
+}
+
+// cloneArrs returns a copy of arrs that shares no memory with it.
+func cloneArrs(arrs [][]int) [][]int {
+	out := make([][]int, len(arrs))
+	for i, arr := range arrs {
+		out[i] = slices.Clone(arr)
+	}
+	return out
+}
+
+// orbitMin reports whether item is the smallest of its orbit under group
+func orbitMin(group [][]int, item int) bool {
+	for _, perm := range group {
+		if perm[item] < item {
+			return false
+		}
+	}
+	return true
+}
+
+// rootPrefixes splits the search at its root into the assignments of
+// arr1's first slots: all sequences of distinct items, the first an orbit
+// representative under arr0's automorphisms, one slot longer until there
+// are at least 4 per worker (or arr1 is full). Every arr1 the search
+// accepts starts with exactly one of them, so the subtrees are disjoint
+// and together cover the whole search; the list is the same on every run.
+func (s *Solver) rootPrefixes(numWorkers int) [][]int {
+	var prefixes [][]int
+	for item := 0; item < s.n; item++ {
+		if s.autos == nil || orbitMin(s.autos, item) {
+			prefixes = append(prefixes, []int{item})
+		}
+	}
+	for depth := 1; depth < s.n && len(prefixes) < 4*numWorkers; depth++ {
+		var longer [][]int
+		for _, p := range prefixes {
+			for item := 0; item < s.n; item++ {
+				if !slices.Contains(p, item) {
+					longer = append(longer, append(slices.Clip(p), item))
+				}
+			}
+		}
+		prefixes = longer
+	}
+	return prefixes
+}
+
+// rootTasks returns a closed channel holding a task for each of
+// rootPrefixes, for the workers to take in turn.
+func (s *Solver) rootTasks(numWorkers int) <-chan *searchTask {
+	prefixes := s.rootPrefixes(numWorkers)
+	tasks := make(chan *searchTask, len(prefixes))
+	for _, p := range prefixes {
+		tasks <- &searchTask{prefix: p}
+	}
+	close(tasks)
+	return tasks
+}
+
+// Solve searches for a solution with numWorkers workers, which take the
+// subtrees of rootPrefixes in turn, so no two search the same part of the
+// tree.
 func (s *Solver) Solve(numWorkers int) bool {
 	arr0 := make([]int, s.n)
 	for i := 0; i < s.n; i++ {
@@ -304,88 +1024,764 @@ func (s *Solver) Solve(numWorkers int) bool {
 	}
 	s.solution[0] = arr0
 
-	covered := make([]bool, s.numPairs)
-	coveredCount := 0
-	for _, e := range s.edges {
-		pi := s.pairIndex(e.a, e.b)
-		if !covered[pi] {
-			covered[pi] = true
-			coveredCount++
-		}
-	}
+	covered := s.coveredByArr0()
+	coveredCount := covered.Covered()
 
 	if s.k == 1 {
-		return coveredCount == s.numPairs
+		return coveredCount == s.required
+	}
+	if !s.coverable(covered) {
+		return false
 	}
 
+	tasks := s.rootTasks(numWorkers)
 	var wg sync.WaitGroup
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
-		go func(seed int64) {
+		go func(w int, seed int64) {
 			defer wg.Done()
-			rng := rand.New(rand.NewSource(seed))
-			s.solve(0, covered, coveredCount, nil, rng)
-		}(time.Now().UnixNano() + int64(w)*12345)
+			workersBusy.Add(1)
+			defer workersBusy.Add(-1)
+			wk := &worker{rng: rand.New(rand.NewSource(seed)), log: logger.Worker(w)}
+			wk.log.Verbosef("started, seed %d", seed)
+			defer func() { wk.log.Verbosef("finished after %d nodes", wk.nodes) }()
+			covered := covered.Clone() // solve changes it as it goes
+			for task := range tasks {
+				if s.done() {
+					return
+				}
+				wk.log.Debugf("task %v", task.prefix)
+				s.run(wk, covered, coveredCount, task)
+			}
+		}(w, time.Now().UnixNano()+int64(w)*12345)
 	}
 	wg.Wait()
 
 	return atomic.LoadInt32(&s.found) != 0
 }
 
-func parseOverlapLimits(s string) ([]int, error) {
+// parseOverlapLimits reads -max-overlap: one limit per level arr1..arr(k-1),
+// where an empty entry or "auto" keeps that level's dynamic limit (-1), so
+// "auto,auto,10" only caps arr3. Fewer entries than levels leave the rest
+// dynamic.
+func parseOverlapLimits(s string, k int) ([]int, error) {
 	if s == "" {
 		return nil, nil
 	}
 	parts := strings.Split(s, ",")
+	if len(parts) > k-1 {
+		return nil, fmt.Errorf("%d limits given, but k=%d has only %d levels (arr1..arr%d)", len(parts), k, k-1, k-1)
+	}
 	limits := make([]int, len(parts))
 	for i, p := range parts {
-		v, err := strconv.Atoi(strings.TrimSpace(p))
+		p = strings.TrimSpace(p)
+		if p == "" || p == "auto" {
+			limits[i] = -1
+			continue
+		}
+		v, err := strconv.Atoi(p)
 		if err != nil {
 			return nil, fmt.Errorf("invalid overlap limit %q: %v", p, err)
 		}
+		if v < 0 {
+			return nil, fmt.Errorf("negative overlap limit %d", v)
+		}
 		limits[i] = v
 	}
 	return limits, nil
 }
 
+func printSymmetry(order int) {
+	if order == 0 {
+		fmt.Printf("Automorphisms of arr0's shape: more than %d, symmetry breaking off\n", maxAutomorphisms)
+	} else {
+		fmt.Printf("Automorphisms of arr0's shape: %d\n", order)
+	}
+}
+
+// exportOrCheck writes the solver's integer program to export and/or
+// verifies a solution for it read from check
+func exportOrCheck(s *Solver, export, check string) {
+	if export != "" {
+		names := make([]string, s.k)
+		for i, sh := range s.shapes {
+			names[i] = sh.name
+		}
+		model, err := s.BuildILP(fmt.Sprintf("clink_n%d_k%d_%s", s.n, s.k, strings.Join(names, "_")))
+		if err == nil {
+			err = model.Write(export)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		wrote(export)
+		fmt.Printf("Wrote %s: %d variables, %d constraints\n", export, len(model.vars), len(model.rows))
+		if s.arr0Free() {
+			fmt.Println("Note: arr0 is fixed to the identity in the model, so with this multiplicity an infeasible model is not a proof")
+		}
+		events.Emit("export", jsonl.Fields{"path": export, "variables": len(model.vars), "constraints": len(model.rows)})
+	}
+	if check != "" {
+		checkSolution(s, check)
+	}
+}
+
+// solutionsOut holds the solutions of the run for the -out file, which is
+// rewritten with all of them after each one, so a run stopped later keeps
+// what it found.
+var solutionsOut struct {
+	sync.Mutex
+	path string
+	sets []arrangement.Set
+}
+
+// saveSolution adds a solution to the -out arrangement file, if any. With
+// names nil the arrangements are on the spiral, else on picked, numbered
+// by vertex as in the .g6 file.
+func saveSolution(n int, picked []*Shape, names []string, arrs [][]int) {
+	if solutionsOut.path == "" {
+		return
+	}
+	set := arrangement.NewSpiral(n, arrs, "solver_general")
+	if names != nil {
+		hosts := make([][][2]int, len(picked))
+		for i, sh := range picked {
+			hosts[i] = sh.hostEdges()
+		}
+		set = arrangement.NewGraphs(n, hosts, names, arrs, "solver_general")
+	}
+	solutionsOut.Lock()
+	defer solutionsOut.Unlock()
+	solutionsOut.sets = append(solutionsOut.sets, set)
+	if err := arrangement.WriteFile(solutionsOut.path, solutionsOut.sets...); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	wrote(solutionsOut.path)
+	fmt.Printf("Solution written to %s\n", solutionsOut.path)
+}
+
+// recordSolution stores a solution in the result database at path, if any
+func recordSolution(path string, n int, shapes []string, arrs [][]int) {
+	if path == "" {
+		return
+	}
+	db, err := resultdb.Open(path)
+	if err == nil {
+		err = db.AddSolution(n, shapes, arrs, "solver_general")
+		db.Close()
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Solution recorded in %s\n", path)
+}
+
+// showCoverage prints which arrangements of a solution cover each pair,
+// with overlap statistics per arrangement, and draws the matrix to the SVG
+// file at path; nothing if path is empty. names are the shapes' names with
+// -graphs, else nil.
+func showCoverage(path string, n int, shapes []*Shape, names []string, solution [][]int) {
+	if path == "" {
+		return
+	}
+	arrs := make([]coverage.Arrangement, len(solution))
+	for i, arr := range solution {
+		edges := make([][2]int, len(shapes[i].edges))
+		for j, e := range shapes[i].edges {
+			edges[j] = [2]int{e.a, e.b}
+		}
+		name := fmt.Sprintf("arr%d", i)
+		if names != nil {
+			name += " (" + names[i] + ")"
+		}
+		arrs[i] = coverage.Arrangement{Name: name, Items: arr, Edges: edges}
+	}
+	m := coverage.New(n, arrs)
+	fmt.Println("\nPair coverage:")
+	m.WriteText(os.Stdout)
+	if err := m.SaveSVG(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	wrote(path)
+	fmt.Printf("Coverage matrix drawn to %s\n", path)
+	_, _, multiple, uncovered := m.Totals()
+	events.Emit("coverage", jsonl.Fields{"svg": path, "arrangements": m.Stats(), "multiple": multiple, "uncovered": uncovered})
+}
+
+// parseShapeList reads a multiset like "A,A,B" of k loaded shape names
+func parseShapeList(list string, shapes []*Shape, k int) ([]int, error) {
+	parts := strings.Split(list, ",")
+	if len(parts) != k {
+		return nil, fmt.Errorf("-shapes %q names %d shapes, need k=%d", list, len(parts), k)
+	}
+	m := make([]int, k)
+	for i, p := range parts {
+		m[i] = -1
+		for si, sh := range shapes {
+			if sh.name == strings.TrimSpace(p) {
+				m[i] = si
+			}
+		}
+		if m[i] < 0 {
+			return nil, fmt.Errorf("-shapes: unknown shape %q", p)
+		}
+	}
+	sort.Ints(m)
+	return m, nil
+}
+
 func main() {
 	n := flag.Int("n", 17, "Number of items")
 	k := flag.Int("k", 4, "Number of arrangements")
 	workers := flag.Int("workers", 8, "Number of parallel workers")
-	maxOverlap := flag.String("max-overlap", "", "Comma-separated max overlap per level (e.g., '5,5,5' for k=4)")
+	maxOverlap := flag.String("max-overlap", "", "Comma-separated max overlap per level arr1..arr(k-1) (e.g., '5,5,5' for k=4; 'auto' or empty keeps a level dynamic)")
+	engine := flag.String("engine", "search", "search (randomized backtracking), sat (complete, via gophersat), anneal (simulated annealing, finds but never rules out solutions) or portfolio (all three at once, first answer wins)")
+	annealSteps := flag.Int64("anneal-steps", 2000000, "with -engine anneal: swap moves per annealing run")
+	annealRuns := flag.Int("anneal-runs", 20, "with -engine anneal: runs per worker before giving up (0: until a solution is found)")
+	symmetry := flag.Bool("symmetry", true, "restrict arr1 to orbit representatives under arr0's automorphisms and search lex-leaders only")
+	noGoodSize := flag.Int("nogood", 1<<16, "entries in the table of search states known to fail, skipped when reached again (0: off)")
+	restartFlag := flag.String("restart", "", "abandon a worker's search after a node cutoff and start over reshuffled: fixed:N or luby:N (N nodes times the Luby sequence)")
+	heuristicName := flag.String("heuristic", "random", "item order at each slot: random, uncovered (most uncovered pairs first), least-constraining (least overlap first) or degree-matched (needy items to high-degree slots)")
+	precheck := flag.Bool("precheck", true, "before searching, check that every item can still meet its n-1 partners given the slot degrees of the k arrangements, and skip the search if not")
+	specialSlot := flag.Bool("special-slot", true, "fill a minimum-degree slot first at the last level, and only with items that have few enough pairs left")
+	graphsFile := flag.String("graphs", "", "host graphs (.g6, .gz/.zst ok); each arrangement may use any of them (default: spiral)")
+	export := flag.String("export", "", "write the problem as an integer program (.lp, or .mps) instead of solving")
+	checkFile := flag.String("check-solution", "", "verify a MIP solver's solution file for the exported model")
+	dumpDir := flag.String("dump-partials", "", "write every arr1..arr(k-2) prefix to item_<x>.txt files in this directory (find_fourth input) instead of solving")
+	dumpMin := flag.Int("dump-min-covered", 0, "with -dump-partials: pairs the prefix must cover, coverings with -multiplicity (default: enough for the last arrangement to finish)")
+	jsonOut := flag.Bool("json", false, "write events (start, levels, solutions, result) as JSON lines on stdout; text goes to stderr")
+	shapeList := flag.String("shapes", "", "with -graphs: only this shape multiset, e.g. A,A,B")
+	dbPath := flag.String("db", "", "record solutions in this SQLite result database")
+	outPath := flag.String("out", "", "write the solutions to this arrangement file (pkg/arrangement; hexclink verify, find_fourth -in)")
+	findAll := flag.Bool("find-all", false, "with -engine search: enumerate every solution within the overlap limits instead of stopping at the first, each printed once up to relabeling, arrangement order and host automorphisms")
+	surveyFlag := flag.Bool("survey", false, "run all k arrangements on each host graph in turn and report which admit k (default -graphs: ../penny_enum/n<n>_maximal.g6)")
+	multiplicityPath := flag.String("multiplicity", "", "require some pairs to be covered more than once (or not at all): a file of \"a b t\" lines, pair {a,b} at least t times, and \"default t\"")
+	coveragePath := flag.String("coverage", "", "with a solution, print the pair-coverage matrix and overlap per arrangement, and draw it to this SVG file (with -survey one file per graph, e.g. cov_A.svg)")
+	progressEvery := flag.Duration("progress", 10*time.Second, "print per-level node and prune counts this often during the search (0: only at the end)")
+	timeout := flag.Duration("timeout", 0, "give up after this long and print the summary so far (0: no limit)")
+	maxNodes := flag.Int64("max-nodes", 0, "give up after about this many search nodes, or annealing moves (0: no limit)")
+	metricsAddr := flag.String("metrics", "", "serve search metrics on this address (e.g. :9090) at /metrics (Prometheus) and /debug/vars (expvar)")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address (e.g. localhost:6060) at /debug/pprof/")
+	tracePath := flag.String("trace", "", "write a runtime/trace of the run to this file, for go tool trace")
+	traceFor := flag.Duration("trace-for", time.Minute, "with -trace: stop tracing after this long (0: trace the whole run)")
+	bench := flag.Bool("bench", false, "run fixed search workloads on one worker and print nodes/s and allocations per node, instead of solving")
+	configPath := flag.String("config", "", "take flags from this TOML file (flags on the command line win); see pkg/config")
+	profile := flag.String("profile", "", "with -config: also apply this [profile] section of the file")
+	logLevel := flag.String("log-level", "normal", "progress lines to print: quiet, normal, verbose or debug (see pkg/logging)")
+	logFile := flag.String("log-file", "", "also append the progress lines to this file")
 	flag.Parse()
+	settings, err := config.Load(*configPath, *profile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	events = jsonl.Start("solver_general", *jsonOut)
+	logger, err = logging.Start(*logLevel, *logFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+	run = manifest.Start("solver_general", nil)
+	if err := run.Input(*configPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	solutionsOut.path = *outPath
+	if *configPath != "" {
+		fmt.Println(config.Describe(*configPath, *profile, settings))
+	}
+	if *metricsAddr != "" {
+		if err := metrics.Serve(*metricsAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Metrics on http://%s/metrics\n", *metricsAddr)
+	}
+	if *pprofAddr != "" {
+		if err := prof.Serve(*pprofAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("pprof on http://%s/debug/pprof/\n", *pprofAddr)
+	}
+	if *tracePath != "" {
+		if err := prof.StartTrace(*tracePath, *traceFor); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer prof.StopTrace()
+	}
+	if *bench {
+		runBench()
+		return
+	}
 
-	fmt.Printf("Searching for %d arrangements of %d items\n", *k, *n)
-
-	solver := NewSolver(*n, *k)
-
-	overlapLimits, err := parseOverlapLimits(*maxOverlap)
+	overlapLimits, err := parseOverlapLimits(*maxOverlap, *k)
 	if err != nil {
 		fmt.Printf("Error parsing max-overlap: %v\n", err)
+		os.Exit(1)
+	}
+
+	var solve func(s *Solver) bool
+	switch *engine {
+	case "search":
+		solve = func(s *Solver) bool { return s.Solve(*workers) }
+	case "sat":
+		solve = (*Solver).SolveSAT
+		if overlapLimits != nil {
+			fmt.Println("Note: -max-overlap is ignored by -engine sat")
+			overlapLimits = nil
+		}
+	case "anneal":
+		solve = func(s *Solver) bool { return s.SolveAnneal(*workers) }
+		if overlapLimits != nil {
+			fmt.Println("Note: -max-overlap is ignored by -engine anneal")
+			overlapLimits = nil
+		}
+	case "portfolio":
+		solve = func(s *Solver) bool { return s.SolvePortfolio(*workers) }
+	default:
+		fmt.Printf("Error: unknown -engine %q (use search, sat, anneal or portfolio)\n", *engine)
+		os.Exit(1)
+	}
+	heur, err := parseHeuristic(*heuristicName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	restart, err := parseRestart(*restartFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// need is read once n is known
+	var need cover.Multiplicity
+
+	b := budget.New(*timeout, *maxNodes)
+
+	// prepare applies the search options to a new solver
+	prepare := func(s *Solver) {
+		s.SetBudget(b)
+		s.SetMultiplicity(need)
+		s.SetMaxOverlap(overlapLimits)
+		s.SetHeuristic(heur)
+		s.SetRestart(restart)
+		s.SetNoGoods(*noGoodSize)
+		s.SetAnneal(*annealSteps, *annealRuns)
+		if *symmetry {
+			printSymmetry(s.BreakSymmetry())
+		}
+		if *specialSlot && (*engine == "search" || *engine == "portfolio") {
+			vertex, degree := s.SpecialSlot()
+			fmt.Printf("Special slot %d has degree %d (filled first at last level)\n", vertex, degree)
+		}
+	}
+	search := solve
+	solve = func(s *Solver) bool {
+		stop := s.reportProgress(*progressEvery)
+		found := search(s)
+		stop()
+		if s.nodes() > 0 {
+			if restart.unit > 0 {
+				fmt.Printf("Restarts: %d (%v)\n", s.restarts.Load(), restart)
+			}
+			fmt.Println("Search by level:")
+			s.printLevels("  ")
+			stats := jsonl.Fields{"levels": s.levelFields(), "restarts": s.restarts.Load()}
+			if s.noGoods != nil {
+				fmt.Println(s.noGoods)
+				stats["nogood"] = jsonl.Fields{"lookups": s.noGoods.lookups.Load(), "hits": s.noGoods.hits.Load(), "stored": s.noGoods.stores.Load()}
+			}
+			events.Emit("level_stats", stats)
+		}
+		return found
+	}
+
+	if *findAll && (*engine != "search" || *surveyFlag || *dumpDir != "" || *export != "" || *checkFile != "") {
+		fmt.Println("Error: -find-all needs -engine search and can't be combined with -survey, -dump-partials, -export or -check-solution")
+		os.Exit(1)
+	}
+	// findAllOn enumerates the solutions on picked (names nil: spiral)
+	findAllOn := func(s *Solver, picked []*Shape, names []string) (total, distinct int) {
+		stop := s.reportProgress(*progressEvery)
+		total, distinct = s.FindAll(*workers, func(arrs [][]int, index int) {
+			fmt.Printf("\n*** SOLUTION %d ***\n", index)
+			out := arrs
+			if names != nil {
+				out = make([][]int, len(arrs))
+				for i, arr := range arrs {
+					out[i] = picked[i].byVertex(arr)
+				}
+			}
+			for i, arr := range out {
+				fmt.Printf("  Arr%d: %v\n", i, arr)
+			}
+			events.Emit("solution", jsonl.Fields{"index": index, "shapes": names, "arrangements": out})
+			recordSolution(*dbPath, *n, names, out)
+			saveSolution(*n, picked, names, out)
+			if *coveragePath != "" {
+				showCoverage(shapePath(*coveragePath, append(slices.Clone(names), fmt.Sprintf("_%d", index))), *n, picked, names, arrs)
+			}
+		})
+		stop()
+		fmt.Println("Search by level:")
+		s.printLevels("  ")
+		events.Emit("level_stats", jsonl.Fields{"levels": s.levelFields()})
+		return total, distinct
+	}
+
+	if *surveyFlag {
+		if *export != "" || *checkFile != "" || *dumpDir != "" || *shapeList != "" {
+			fmt.Println("Error: -survey can't be combined with -export, -check-solution, -dump-partials or -shapes")
+			os.Exit(1)
+		}
+		if *graphsFile == "" {
+			*graphsFile = fmt.Sprintf("../penny_enum/n%d_maximal.g6", *n)
+		}
+	}
+
+	if *graphsFile == "" {
+		shapes := make([]*Shape, *k)
+		shape := spiralShape(*n)
+		for i := range shapes {
+			shapes[i] = shape
+		}
+
+		fmt.Printf("Searching for %d arrangements of %d items\n", *k, *n)
+		need = readMultiplicity(*multiplicityPath, *n)
+		solver := NewSolver(*n, shapes)
+		if overlapLimits != nil {
+			fmt.Printf("Max overlap limits: %s\n", *maxOverlap)
+		}
+		prepare(solver)
+		pairsTotal.Set(int64(solver.required))
+
+		events.Emit("start", jsonl.Fields{
+			"n": *n, "k": *k, "shape": "spiral", "edges": shape.numEdges, "pairs": solver.numPairs,
+			"engine": *engine, "heuristic": *heuristicName, "restart": restart.String(), "workers": *workers, "max_overlap": overlapLimits,
+		})
+		fmt.Printf("Edges per arrangement: %d, Total pairs: %d\n", shape.numEdges, solver.numPairs)
+		required := solver.multiplicity().Total()
+		fmt.Printf("Lower bound: ceil(%d/%d) = %d arrangements\n",
+			required, shape.numEdges, (required+shape.numEdges-1)/shape.numEdges)
+		if *export != "" || *checkFile != "" {
+			exportOrCheck(solver, *export, *checkFile)
+			return
+		}
+		if *dumpDir != "" {
+			minCovered := *dumpMin
+			if minCovered == 0 {
+				minCovered = solver.required - shape.numEdges
+			}
+			fmt.Printf("Dumping arr1..arr%d prefixes covering >= %d pairs to %s (workers: %d)\n",
+				*k-2, minCovered, *dumpDir, *workers)
+			if need != nil {
+				fmt.Printf("Counting coverings: check the prefixes with find_fourth -multiplicity %s\n", *multiplicityPath)
+			}
+			start := time.Now()
+			count, err := solver.DumpPartials(*dumpDir, minCovered, *workers)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			wrote(*dumpDir)
+			fmt.Printf("\nWrote %d candidates\nTime: %v\n", count, time.Since(start).Round(time.Millisecond))
+			events.Emit("result", jsonl.Fields{"dump_dir": *dumpDir, "min_covered": minCovered, "candidates": count,
+				"stopped": stopReason(b), "seconds": time.Since(start).Seconds()})
+			if b.Stopped() {
+				fmt.Printf("Stopped (%v) after %d nodes: the files are incomplete\n", b.Err(), b.Nodes())
+				exitStopped()
+			}
+			return
+		}
+		fmt.Printf("Engine: %s, Heuristic: %s, Workers: %d\n\n", *engine, *heuristicName, *workers)
+
+		if *precheck && solver.ruledOut() {
+			fmt.Println("\nNo solution found.")
+			events.Emit("result", jsonl.Fields{"found": false, "ruled_out": true, "seconds": 0})
+			return
+		}
+		if *findAll {
+			start := time.Now()
+			total, distinct := findAllOn(solver, shapes, nil)
+			printFindAll(total, distinct, need, b)
+			fmt.Printf("\nTime: %v\n", time.Since(start).Round(time.Millisecond))
+			events.Emit("result", jsonl.Fields{"found": distinct > 0, "solutions": total, "distinct": distinct,
+				"stopped": stopReason(b), "seconds": time.Since(start).Seconds()})
+			if b.Stopped() {
+				exitStopped()
+			}
+			return
+		}
+
+		start := time.Now()
+		found := solve(solver)
+		elapsed := time.Since(start)
+
+		if found {
+			fmt.Println("\n*** SOLUTION FOUND ***")
+			for i, arr := range solver.solution {
+				fmt.Printf("  Arr%d: %v\n", i, arr)
+			}
+			events.Emit("solution", jsonl.Fields{"arrangements": solver.solution})
+			recordSolution(*dbPath, *n, nil, solver.solution)
+			saveSolution(*n, nil, nil, solver.solution)
+			showCoverage(*coveragePath, *n, shapes, nil, solver.solution)
+		} else if b.Stopped() {
+			fmt.Printf("\nStopped (%v) after %d nodes: no solution found so far.\n", b.Err(), b.Nodes())
+		} else {
+			fmt.Println("\n" + solver.noSolution())
+		}
+		events.Emit("result", jsonl.Fields{"found": found, "stopped": stopReason(b), "seconds": elapsed.Seconds(),
+			"arr0_identity_only": !found && solver.arr0Free() && !solver.proved})
+
+		fmt.Printf("\nTime: %v\n", elapsed.Round(time.Millisecond))
+		if !found && b.Stopped() {
+			exitStopped()
+		}
 		return
 	}
+
+	// Mixed shapes: every arrangement picks its own host graph
+	if *dumpDir != "" {
+		fmt.Println("Error: -dump-partials writes find_fourth input, which is spiral only; drop -graphs")
+		os.Exit(1)
+	}
+	shapes, graphN, err := loadShapes(*graphsFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Input(*graphsFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	nSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "n" {
+			nSet = true
+		}
+	})
+	if nSet && *n != graphN {
+		fmt.Printf("Error: -n %d, but %s has graphs on %d vertices\n", *n, *graphsFile, graphN)
+		os.Exit(1)
+	}
+	*n = graphN
+	numPairs := pairs.Count(*n)
+	need = readMultiplicity(*multiplicityPath, *n)
+	required := numPairs
+	if need != nil {
+		required = need.Total()
+	}
+	pairsTotal.Set(int64(required))
+
+	if *surveyFlag {
+		fmt.Printf("Surveying %d arrangements of %d items on each host graph\n", *k, *n)
+	} else {
+		fmt.Printf("Searching for %d arrangements of %d items on mixed shapes\n", *k, *n)
+	}
+	fmt.Printf("Loaded %d shapes from %s:\n", len(shapes), *graphsFile)
+	for _, sh := range shapes {
+		fmt.Printf("  %s: %d edges\n", sh.name, sh.numEdges)
+	}
 	if overlapLimits != nil {
-		solver.SetMaxOverlap(overlapLimits)
-		fmt.Printf("Max overlap limits: %v\n", overlapLimits)
+		fmt.Printf("Max overlap limits: %s\n", *maxOverlap)
+	}
+	fmt.Printf("Total pairs: %d\n", numPairs)
+	fmt.Printf("Engine: %s, Heuristic: %s, Workers: %d\n\n", *engine, *heuristicName, *workers)
+	shapeEdges := make(map[string]int)
+	for _, sh := range shapes {
+		shapeEdges[sh.name] = sh.numEdges
+	}
+	events.Emit("start", jsonl.Fields{
+		"n": *n, "k": *k, "graphs": *graphsFile, "shapes": shapeEdges, "pairs": numPairs,
+		"engine": *engine, "heuristic": *heuristicName, "restart": restart.String(), "workers": *workers, "max_overlap": overlapLimits, "survey": *surveyFlag,
+	})
+
+	if *surveyFlag {
+		start := time.Now()
+		results := survey(*n, *k, shapes, need, prepare, solve, *precheck, *dbPath, *coveragePath, b)
+		admit := printSurvey(*k, results)
+		if b.Stopped() {
+			fmt.Printf("Stopped (%v) after %d nodes: surveyed %d of %d graphs\n", b.Err(), b.Nodes(), len(results), len(shapes))
+		}
+		fmt.Printf("Time: %v\n", time.Since(start).Round(time.Millisecond))
+		if admit == nil {
+			admit = []string{}
+		}
+		events.Emit("result", jsonl.Fields{"found": len(admit) > 0, "admit": admit, "graphs": len(shapes),
+			"surveyed": len(results), "stopped": stopReason(b), "seconds": time.Since(start).Seconds()})
+		if b.Stopped() {
+			exitStopped()
+		}
+		return
 	}
 
-	fmt.Printf("Edges per arrangement: %d, Total pairs: %d\n", solver.numEdges, solver.numPairs)
-	fmt.Printf("Lower bound: ceil(%d/%d) = %d arrangements\n",
-		solver.numPairs, solver.numEdges, (solver.numPairs+solver.numEdges-1)/solver.numEdges)
-	fmt.Printf("Workers: %d\n\n", *workers)
+	// Arrangements are interchangeable, so only shape multisets are tried,
+	// most edges first; ones with fewer edges than required coverings are
+	// skipped.
+	multisets := shapeMultisets(len(shapes), *k)
+	total := func(m []int) int {
+		t := 0
+		for _, i := range m {
+			t += shapes[i].numEdges
+		}
+		return t
+	}
+	sort.SliceStable(multisets, func(i, j int) bool {
+		return total(multisets[i]) > total(multisets[j])
+	})
+	if *shapeList != "" {
+		m, err := parseShapeList(*shapeList, shapes, *k)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		multisets = [][]int{m}
+	} else if *checkFile != "" {
+		fmt.Println("Error: -check-solution with -graphs needs -shapes")
+		os.Exit(1)
+	}
 
 	start := time.Now()
-	found := solver.Solve(*workers)
-	elapsed := time.Since(start)
+	tried, skipped, ruledOut := 0, 0, 0
+	notProved := false // some multiset's "no" only covers arr0 the identity (arr0Free)
+	allTotal, allDistinct := 0, 0
+	for _, m := range multisets {
+		if b.Stopped() {
+			break
+		}
+		if total(m) < required {
+			skipped++
+			continue
+		}
+		picked := make([]*Shape, *k)
+		names := make([]string, *k)
+		for i, si := range m {
+			picked[i] = shapes[si]
+			names[i] = shapes[si].name
+		}
+		tried++
+		fmt.Printf("=== Shapes %s (%d edges) ===\n", strings.Join(names, " "), total(m))
+
+		solver := NewSolver(*n, picked)
+		solver.SetMultiplicity(need)
+		if *precheck && *export == "" && *checkFile == "" && solver.ruledOut() {
+			ruledOut++
+			fmt.Println()
+			events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": total(m), "found": false, "ruled_out": true})
+			continue
+		}
+		prepare(solver)
+		if *export != "" || *checkFile != "" {
+			path := *export
+			if path != "" && *shapeList == "" {
+				path = shapePath(path, names)
+			}
+			exportOrCheck(solver, path, *checkFile)
+			continue
+		}
+		if *findAll {
+			solutions, distinct := findAllOn(solver, picked, names)
+			allTotal += solutions
+			allDistinct += distinct
+			multisetsDone.Add(1)
+			fmt.Printf("%d solutions, %d distinct\n\n", solutions, distinct)
+			events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": total(m), "found": distinct > 0, "solutions": solutions, "distinct": distinct})
+			continue
+		}
+		found := solve(solver)
+		multisetsDone.Add(1)
+		if !found && b.Stopped() {
+			fmt.Print("Stopped.\n\n")
+			events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": total(m), "found": false, "stopped": stopReason(b)})
+			break
+		}
+		if !found {
+			identityOnly := solver.arr0Free() && !solver.proved
+			notProved = notProved || identityOnly
+			fmt.Print(solver.noSolution() + "\n\n")
+			events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": total(m), "found": false, "arr0_identity_only": identityOnly})
+			continue
+		}
+		events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": total(m), "found": true})
 
-	if found {
 		fmt.Println("\n*** SOLUTION FOUND ***")
+		arrs := make([][]int, *k)
 		for i, arr := range solver.solution {
-			fmt.Printf("  Arr%d: %v\n", i, arr)
+			arrs[i] = picked[i].byVertex(arr)
+			fmt.Printf("  Arr%d (%s): %v\n", i, picked[i].name, arrs[i])
+		}
+		events.Emit("solution", jsonl.Fields{"shapes": names, "arrangements": arrs})
+		recordSolution(*dbPath, *n, names, arrs)
+		saveSolution(*n, picked, names, arrs)
+		showCoverage(*coveragePath, *n, picked, names, solver.solution)
+		fmt.Printf("\nTried %d shape multisets (%d skipped, too few edges; %d ruled out by -precheck)\n", tried, skipped, ruledOut)
+		fmt.Printf("Time: %v\n", time.Since(start).Round(time.Millisecond))
+		events.Emit("result", jsonl.Fields{"found": true, "tried": tried, "skipped": skipped, "ruled_out": ruledOut,
+			"seconds": time.Since(start).Seconds()})
+		return
+	}
+
+	if *export != "" || *checkFile != "" {
+		return
+	}
+	if *findAll {
+		fmt.Printf("\nTried %d shape multisets (%d skipped, too few edges; %d ruled out by -precheck)\n", tried, skipped, ruledOut)
+		printFindAll(allTotal, allDistinct, need, b)
+		fmt.Printf("Time: %v\n", time.Since(start).Round(time.Millisecond))
+		events.Emit("result", jsonl.Fields{"found": allDistinct > 0, "solutions": allTotal, "distinct": allDistinct,
+			"tried": tried, "skipped": skipped, "ruled_out": ruledOut, "stopped": stopReason(b), "seconds": time.Since(start).Seconds()})
+		if b.Stopped() {
+			exitStopped()
 		}
+		return
+	}
+	if b.Stopped() {
+		fmt.Printf("\nStopped (%v) after %d nodes: no solution in %d shape multisets tried (%d skipped, too few edges; %d ruled out by -precheck)\n",
+			b.Err(), b.Nodes(), tried, skipped, ruledOut)
+	} else if notProved {
+		fmt.Printf("\nNo solution with arr0 = identity (not a proof): tried %d shape multisets (%d skipped, too few edges; %d ruled out by -precheck)\n", tried, skipped, ruledOut)
 	} else {
-		fmt.Println("\nNo solution found.")
+		fmt.Printf("\nNo solution found: tried %d shape multisets (%d skipped, too few edges; %d ruled out by -precheck)\n", tried, skipped, ruledOut)
+	}
+	fmt.Printf("Time: %v\n", time.Since(start).Round(time.Millisecond))
+	events.Emit("result", jsonl.Fields{"found": false, "tried": tried, "skipped": skipped, "ruled_out": ruledOut,
+		"stopped": stopReason(b), "seconds": time.Since(start).Seconds(), "arr0_identity_only": notProved})
+	if b.Stopped() {
+		exitStopped()
+	}
+}
+
+// exitStopped ends a run its budget stopped with budget.ExitStopped,
+// closing the -trace file first.
+func exitStopped() {
+	prof.StopTrace()
+	os.Exit(budget.ExitStopped)
+}
+
+// printFindAll sums up a -find-all run with the multiplicity need.
+func printFindAll(total, distinct int, need cover.Multiplicity, b *budget.Budget) {
+	if b.Stopped() {
+		fmt.Printf("Stopped (%v) after %d nodes, so there may be more.\n", b.Err(), b.Nodes())
 	}
+	if need != nil && !need.IsUniform() {
+		fmt.Println("Only solutions with arr0 = identity were searched: the multiplicity isn't uniform, so there may be more.")
+	}
+	fmt.Printf("Found %d solutions, %d distinct up to relabeling, arrangement order and host automorphisms\n", total, distinct)
+}
 
-	fmt.Printf("\nTime: %v\n", elapsed.Round(time.Millisecond))
+// stopReason is why b ended the run, for result events, or nil if it
+// didn't.
+func stopReason(b *budget.Budget) any {
+	if err := b.Err(); err != nil {
+		return err.Error()
+	}
+	return nil
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// benchSmall are quick exhaustive searches (no solution, so the same nodes
+// in any order) for timing the search loop in isolation, the last ones
+// with each -heuristic, whose buffers the workers reuse too; benchCases
+// are the larger -bench workloads.
+var benchSmall = []benchCase{
+	{name: "n9 k3 exhaustive", n: 9, k: 3, limits: []int{1, 1}},
+	{name: "n9 k4 exhaustive", n: 9, k: 4, limits: []int{3, 3, 3}},
+	{name: "n11 k3 exhaustive", n: 11, k: 3, limits: []int{1, 1}},
+	{name: "n9 k4 exhaustive uncovered", n: 9, k: 4, limits: []int{3, 3, 3}, heuristic: heurUncovered},
+	{name: "n9 k4 exhaustive least-constraining", n: 9, k: 4, limits: []int{3, 3, 3}, heuristic: heurLeastConstraining},
+	{name: "n9 k4 exhaustive degree-matched", n: 9, k: 4, limits: []int{3, 3, 3}, heuristic: heurDegreeMatched},
+}
+
+// BenchmarkEnumerate runs the search on one worker, as -bench does, and
+// reports the nodes visited per run alongside the allocations; the inner
+// loop should not allocate, so allocs/op stays at the solver's setup.
+func BenchmarkEnumerate(b *testing.B) {
+	for _, bc := range append(benchSmall, benchCases...) {
+		b.Run(strings.ReplaceAll(bc.name, " ", "_"), func(b *testing.B) {
+			b.ReportAllocs()
+			var nodes int64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s := bc.solver()
+				b.StartTimer()
+				bc.run(s)
+				nodes = s.nodes()
+			}
+			b.ReportMetric(float64(nodes), "nodes/op")
+			b.ReportMetric(float64(nodes)*float64(b.N)/b.Elapsed().Seconds(), "nodes/s")
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/pairs"
+)
+
+// TestSearchAgreesWithSAT solves random instances with hosts of 6 and 7
+// vertices and mixed shapes, with the default options of -graphs, and
+// checks that the search finds a solution exactly when -engine sat does.
+// The overlap limit once assumed the arrangements could be reordered,
+// which mixed shapes don't allow, and missed solutions.
+func TestSearchAgreesWithSAT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// A third of the pairs and up to three more, so that three hosts are
+	// close to the bound and the overlap limits matter
+	host := func(n int) *Shape {
+		g := invariants.New(n)
+		pairs := rng.Perm(n * (n - 1) / 2)
+		edges := n*(n-1)/6 + rng.Intn(4)
+		for u, p := 0, 0; u < n; u++ {
+			for v := u + 1; v < n; v, p = v+1, p+1 {
+				if pairs[p] < edges {
+					g.AddEdge(u, v)
+				}
+			}
+		}
+		return graphShape(shapeName(0), g)
+	}
+	parse := func(g6 string) *Shape {
+		g, err := invariants.ParseGraph6(g6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return graphShape(g6, g)
+	}
+	instances := [][2]*Shape{{parse("EPH_"), parse("EqhO")}} // A,A,B once said no
+	for i := 0; i < 120; i++ {
+		n := 6 + i%2
+		instances = append(instances, [2]*Shape{host(n), host(n)})
+	}
+	solvable := 0
+	for i, hosts := range instances {
+		a, b := hosts[0], hosts[1]
+		n := len(a.slotAdj)
+		for _, shapes := range [][]*Shape{{a, a, b}, {a, b, b}, {b, a, a}} {
+			s := NewSolver(n, shapes)
+			s.BreakSymmetry()
+			s.SpecialSlot()
+			for i := range s.printedLevel {
+				s.printedLevel[i] = 1
+			}
+			found := s.Solve(1)
+			if found {
+				if uncovered := s.Uncovered(s.solution); len(uncovered) > 0 {
+					t.Fatalf("instance %d: search solution leaves %d pairs uncovered", i, len(uncovered))
+				}
+				solvable++
+			}
+			if sat := NewSolver(n, shapes).SolveSAT(); sat != found {
+				t.Errorf("instance %d (%d, %d, %d edges): search %v, sat %v",
+					i, shapes[0].numEdges, shapes[1].numEdges, shapes[2].numEdges, found, sat)
+			}
+		}
+	}
+	t.Logf("%d of %d solvable", solvable, 3*len(instances))
+}
+
+// TestArr0FreeWithMultiplicity has two arrangements of 4 items on a single
+// edge, and asks for pair (2,3) twice and nothing else. arr0 the identity
+// covers (0,1) instead, so the search says no, but that isn't a proof:
+// SolveSAT, with arr0 free, puts 2 and 3 on the edge both times, and
+// Precheck must not rule the instance out.
+func TestArr0FreeWithMultiplicity(t *testing.T) {
+	g := invariants.New(4)
+	g.AddEdge(0, 1)
+	edge := graphShape("edge", g)
+	shapes := []*Shape{edge, edge}
+	solver := func() *Solver {
+		s := NewSolver(4, shapes)
+		need := make(cover.Multiplicity, pairs.Count(4))
+		need[s.pairIndex(2, 3)] = 2
+		s.SetMultiplicity(need)
+		for i := range s.printedLevel {
+			s.printedLevel[i] = 1
+		}
+		return s
+	}
+
+	if short := solver().Precheck(); short != nil {
+		t.Errorf("Precheck ruled out a solvable instance: %+v", short)
+	}
+	s := solver()
+	if s.Solve(1) {
+		t.Fatalf("search found %v with arr0 the identity", s.solution)
+	}
+	if got := s.noSolution(); got != "No solution with arr0 = identity (not a proof)." {
+		t.Errorf("search's no reads %q", got)
+	}
+	s = solver()
+	if !s.SolveSAT() {
+		t.Fatal("SAT found no solution with arr0 free")
+	}
+	for i, arr := range s.solution {
+		if a, b := min(arr[0], arr[1]), max(arr[0], arr[1]); a != 2 || b != 3 {
+			t.Errorf("arr%d = %v doesn't put 2 and 3 on the edge", i, arr)
+		}
+	}
+}
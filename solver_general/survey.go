@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"hexagon_clink/pkg/budget"
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/pairs"
+)
+
+// surveyResult is the outcome of the search on one host graph
+type surveyResult struct {
+	shape        *Shape
+	skipped      bool // k copies have fewer edges than there are pairs (coverings required)
+	ruledOut     bool // Precheck rules k out
+	found        bool
+	stopped      bool // the budget ran out during its search
+	identityOnly bool // the "no" only covers arr0 the identity (arr0Free): not a proof
+	elapsed      time.Duration
+}
+
+// survey runs the covering search with all k arrangements on the same host
+// graph, for every graph in shapes, and prints which of them admit k
+// arrangements. Unlike the mixed search it doesn't stop at the first
+// success. Each solver gets the search options from prepare before solve
+// runs it; solutions go to the result database at dbPath, and their
+// coverage matrices to coveragePath with the graph's name added, if set.
+// With precheck, graphs that Precheck rules out aren't searched. need is
+// the multiplicity (nil: once each).
+// When b runs out, the survey ends with the graph it was on.
+func survey(n, k int, shapes []*Shape, need cover.Multiplicity, prepare func(s *Solver), solve func(s *Solver) bool, precheck bool, dbPath, coveragePath string, b *budget.Budget) []surveyResult {
+	required := pairs.Count(n)
+	if need != nil {
+		required = need.Total()
+	}
+	results := make([]surveyResult, len(shapes))
+	for i, sh := range shapes {
+		if b.Stopped() {
+			return results[:i]
+		}
+		results[i].shape = sh
+		names := make([]string, k)
+		picked := make([]*Shape, k)
+		for j := range picked {
+			picked[j] = sh
+			names[j] = sh.name
+		}
+		if k*sh.numEdges < required {
+			results[i].skipped = true
+			fmt.Printf("=== Shape %s (%d edges): %d×%d < %d pairs, skipped ===\n\n", sh.name, sh.numEdges, k, sh.numEdges, required)
+			continue
+		}
+		fmt.Printf("=== Shape %s (%d edges) ===\n", sh.name, sh.numEdges)
+
+		solver := NewSolver(n, picked)
+		solver.SetMultiplicity(need)
+		if precheck && solver.ruledOut() {
+			results[i].ruledOut = true
+			fmt.Println()
+			events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": k * sh.numEdges, "found": false, "ruled_out": true})
+			continue
+		}
+		prepare(solver)
+		start := time.Now()
+		found := solve(solver)
+		results[i].found = found
+		results[i].stopped = !found && b.Stopped()
+		results[i].elapsed = time.Since(start)
+		multisetsDone.Add(1)
+		results[i].identityOnly = !found && !results[i].stopped && solver.arr0Free() && !solver.proved
+		events.Emit("multiset", jsonl.Fields{"shapes": names, "edges": k * sh.numEdges, "found": found,
+			"stopped": results[i].stopped, "seconds": results[i].elapsed.Seconds(), "arr0_identity_only": results[i].identityOnly})
+		if results[i].stopped {
+			fmt.Print("Stopped.\n\n")
+			continue
+		}
+		if !found {
+			fmt.Print(solver.noSolution() + "\n\n")
+			continue
+		}
+		fmt.Println("*** SOLUTION FOUND ***")
+		arrs := make([][]int, k)
+		for j, arr := range solver.solution {
+			arrs[j] = sh.byVertex(arr)
+			fmt.Printf("  Arr%d: %v\n", j, arrs[j])
+		}
+		fmt.Println()
+		events.Emit("solution", jsonl.Fields{"shapes": names, "arrangements": arrs})
+		recordSolution(dbPath, n, names, arrs)
+		saveSolution(n, picked, names, arrs)
+		if coveragePath != "" {
+			showCoverage(shapePath(coveragePath, []string{sh.name}), n, picked, nil, solver.solution)
+		}
+	}
+	return results
+}
+
+// printSurvey prints the table of survey's results and the graphs that
+// admit k arrangements.
+func printSurvey(k int, results []surveyResult) []string {
+	fmt.Printf("%-6s %6s  %-10s %s\n", "shape", "edges", "result", "time")
+	var admit []string
+	identityOnly := false
+	for _, r := range results {
+		result, took := "none", r.elapsed.Round(time.Millisecond).String()
+		switch {
+		case r.skipped:
+			result, took = "too few", "-"
+		case r.ruledOut:
+			result, took = "ruled out", "-"
+		case r.found:
+			result = "found"
+			admit = append(admit, r.shape.name)
+		case r.stopped:
+			result = "stopped"
+		case r.identityOnly:
+			result, identityOnly = "none*", true
+		}
+		fmt.Printf("%-6s %6d  %-10s %s\n", r.shape.name, r.shape.numEdges, result, took)
+	}
+	if identityOnly {
+		fmt.Println("* only arr0 = identity searched, the multiplicity isn't uniform: not a proof")
+	}
+	if len(admit) == 0 {
+		fmt.Printf("\nNo host graph admits %d arrangements on its own\n", k)
+	} else {
+		fmt.Printf("\nAdmit %d arrangements: %s (%d of %d)\n", k, strings.Join(admit, " "), len(admit), len(results))
+	}
+	return admit
+}
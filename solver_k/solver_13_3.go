@@ -1,14 +1,29 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"hexagon_clink/pkg/arrangement"
+	"hexagon_clink/pkg/budget"
+	"hexagon_clink/pkg/config"
+	"hexagon_clink/pkg/cover"
+	"hexagon_clink/pkg/coverage"
+	"hexagon_clink/pkg/invariants"
+	"hexagon_clink/pkg/jsonl"
+	"hexagon_clink/pkg/logging"
+	"hexagon_clink/pkg/manifest"
+	"hexagon_clink/pkg/pairs"
+	"hexagon_clink/pkg/zfile"
 )
 
-// All 4 maximal penny graphs on 13 vertices (26 edges each)
+// All 4 maximal penny graphs on 13 vertices (26 edges each), used unless
+// -graphs names a .g6 file
 var allGraphs = [][][2]int{
 	// Graph A
 	{
@@ -44,14 +59,78 @@ var allGraphs = [][][2]int{
 	},
 }
 
-const numItems = 13
-const numEdges = 26
+// maxItems bounds n so that the search state fits in fixed-size arrays
+const maxItems = 32
+
+var numItems int
+var allNeighbors [][][]int
+var pairTable *cover.Table
+var edgeCounts []int
+
+// limits ends the search early when -timeout or -max-nodes run out; nodes
+// are counted in batches of nodeFlush
+var limits *budget.Budget
+
+const nodeFlush = 1 << 14
 
-var allNeighbors [4][][]int
-var allPairs [][2]int
+// slack is how many pairs may be covered twice: the total edges of the three
+// shapes minus the pairs to cover. With n=13 and 26-edge graphs it is 0,
+// so every arrangement must be disjoint from the others.
+func slack(shape0, shape1, shape2 int) int {
+	return edgeCounts[shape0] + edgeCounts[shape1] + edgeCounts[shape2] - pairTable.Len()
+}
 
-func init() {
+// loadGraphs reads the shapes from a .g6 file (as written by filter_maximal);
+// all graphs must have the same number of vertices
+func loadGraphs(path string) ([][][2]int, error) {
+	f, err := zfile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var graphs [][][2]int
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		g, err := invariants.ParseGraph6(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if n == 0 {
+			n = g.N
+		} else if g.N != n {
+			return nil, fmt.Errorf("%s:%d: graph on %d vertices, expected %d", path, line, g.N, n)
+		}
+		var edges [][2]int
+		for j := 1; j < g.N; j++ {
+			for i := 0; i < j; i++ {
+				if g.HasEdge(i, j) {
+					edges = append(edges, [2]int{i, j})
+				}
+			}
+		}
+		graphs = append(graphs, edges)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(graphs) == 0 {
+		return nil, fmt.Errorf("%s: no graphs", path)
+	}
+	if n > maxItems {
+		return nil, fmt.Errorf("%s: n=%d exceeds the limit of %d", path, n, maxItems)
+	}
+	numItems = n
+	return graphs, nil
+}
+
+func setup() {
 	// Precompute neighbor lists
+	allNeighbors = make([][][]int, len(allGraphs))
+	edgeCounts = make([]int, len(allGraphs))
 	for i, g := range allGraphs {
 		neighbors := make([][]int, numItems)
 		for j := range neighbors {
@@ -62,46 +141,54 @@ func init() {
 			neighbors[e[1]] = append(neighbors[e[1]], e[0])
 		}
 		allNeighbors[i] = neighbors
+		edgeCounts[i] = len(g)
 	}
 
-	// All pairs
-	for i := 0; i < numItems-1; i++ {
-		for j := i + 1; j < numItems; j++ {
-			allPairs = append(allPairs, [2]int{i, j})
-		}
-	}
+	pairTable = cover.NewTable(numItems)
 }
 
-func buildPairsTable(shapeIdx int, arr []int) [numItems][numItems]bool {
-	var table [numItems][numItems]bool
-	for _, e := range allGraphs[shapeIdx] {
-		item1 := arr[e[0]]
-		item2 := arr[e[1]]
-		table[item1][item2] = true
-		table[item2][item1] = true
+// shapeName labels shapes A, B, C, ... as in the n=13 write-up
+func shapeName(shape int) string {
+	if shape < 26 {
+		return string(rune('A' + shape))
 	}
-	return table
+	return fmt.Sprintf("#%d", shape)
+}
+
+// coveredBy returns the pairs arr covers on shape shapeIdx
+func coveredBy(shapeIdx int, arr []int) pairs.Set {
+	covered := pairs.NewSet(pairTable.Len())
+	pairTable.Cover(covered, arr, allGraphs[shapeIdx])
+	return covered
 }
 
 type Solution struct {
 	shape0, shape1, shape2 int
-	arr1, arr2             [numItems]int
+	arr1, arr2             [maxItems]int
 }
 
-// Search for arr2 that covers exactly the needed pairs
-func searchArr2(shape2 int, neededTable *[numItems][numItems]bool, neededCount int, found *atomic.Bool) (bool, [numItems]int) {
+// Search for arr2 that covers the neededCount pairs not in covered, with at
+// most waste of its edges landing on pairs that are already covered
+func searchArr2(shape2 int, covered pairs.Set, neededCount, waste int, found *atomic.Bool) (bool, [maxItems]int) {
 	neighbors2 := allNeighbors[shape2]
-	var arr2 [numItems]int
-	var used2 [numItems]bool
+	var arr2 [maxItems]int
+	var used2 [maxItems]bool
 	pairsCovered := 0
-	var result [numItems]int
+	wasted := 0
+	var result [maxItems]int
 	success := false
+	var nodes int64
+	defer func() { limits.Add(nodes) }()
 
 	var search func(pos int)
 	search = func(pos int) {
-		if success || found.Load() {
+		if success || found.Load() || limits.Stopped() {
 			return
 		}
+		if nodes++; nodes == nodeFlush {
+			limits.Add(nodes)
+			nodes = 0
+		}
 
 		if pos == numItems {
 			if pairsCovered == neededCount {
@@ -119,25 +206,27 @@ func searchArr2(shape2 int, neededTable *[numItems][numItems]bool, neededCount i
 			arr2[pos] = item
 			used2[item] = true
 
-			isWaste := false
-			newPairs := 0
+			tooWasteful := false
+			newPairs, newWaste := 0, 0
 
 			for _, nPos := range neighbors2[pos] {
 				if nPos < pos {
 					nItem := arr2[nPos]
-					if neededTable[item][nItem] {
+					if !covered.Has(pairTable.Index(item, nItem)) {
 						newPairs++
-					} else {
-						isWaste = true
+					} else if newWaste++; wasted+newWaste > waste {
+						tooWasteful = true
 						break
 					}
 				}
 			}
 
-			if !isWaste {
+			if !tooWasteful {
 				pairsCovered += newPairs
+				wasted += newWaste
 				search(pos + 1)
 				pairsCovered -= newPairs
+				wasted -= newWaste
 			}
 
 			arr2[pos] = 0
@@ -149,42 +238,46 @@ func searchArr2(shape2 int, neededTable *[numItems][numItems]bool, neededCount i
 	return success, result
 }
 
-// Search for arr1 starting with firstItem at position 0
-func searchArr1Worker(shape0, shape1, firstItem int, pairs0Table *[numItems][numItems]bool,
-	found *atomic.Bool, resultChan chan<- Solution, countChan chan<- int64) {
+// Search for arr1 starting with firstItem at position 0. arr1 may overlap
+// arr0 on at most maxOverlap pairs.
+func searchArr1Worker(shape0, shape1, firstItem int, covered0 pairs.Set, maxOverlap int,
+	found *atomic.Bool, resultChan chan<- Solution, countChan chan<- int64, log *logging.Logger) {
 
 	neighbors1 := allNeighbors[shape1]
-	var arr1 [numItems]int
-	var used1 [numItems]bool
-	var localCount int64
+	var arr1 [maxItems]int
+	var used1 [maxItems]bool
+	overlap := 0
+	var localCount, nodes int64
+	defer func() { limits.Add(nodes) }()
 
 	arr1[0] = firstItem
 	used1[firstItem] = true
 
 	var search func(pos int)
 	search = func(pos int) {
-		if found.Load() {
+		if found.Load() || limits.Stopped() {
 			return
 		}
+		if nodes++; nodes == nodeFlush {
+			limits.Add(nodes)
+			nodes = 0
+		}
 
 		if pos == numItems {
 			localCount++
 			// Complete arr1 found, compute needed pairs and search arr2
-			pairs1Table := buildPairsTable(shape1, arr1[:])
-
-			var neededTable [numItems][numItems]bool
-			neededCount := 0
-			for _, p := range allPairs {
-				if !pairs0Table[p[0]][p[1]] && !pairs1Table[p[0]][p[1]] {
-					neededTable[p[0]][p[1]] = true
-					neededTable[p[1]][p[0]] = true
-					neededCount++
+			covered := covered0.Clone()
+			pairTable.Cover(covered, arr1[:], allGraphs[shape1])
+			neededCount := pairTable.Len() - covered.Count()
+
+			// Try each shape2 >= shape1; whatever slack arr1 didn't use
+			// is left for arr2
+			for shape2 := shape1; shape2 < len(allGraphs) && !found.Load(); shape2++ {
+				waste := slack(shape0, shape1, shape2) - overlap
+				if waste < 0 {
+					continue
 				}
-			}
-
-			// Try each shape2 >= shape1
-			for shape2 := shape1; shape2 < 4 && !found.Load(); shape2++ {
-				success, arr2 := searchArr2(shape2, &neededTable, neededCount, found)
+				success, arr2 := searchArr2(shape2, covered, neededCount, waste, found)
 				if success && found.CompareAndSwap(false, true) {
 					resultChan <- Solution{shape0, shape1, shape2, arr1, arr2}
 					return
@@ -201,19 +294,24 @@ func searchArr1Worker(shape0, shape1, firstItem int, pairs0Table *[numItems][num
 			arr1[pos] = item
 			used1[item] = true
 
-			hasOverlap := false
+			tooMuchOverlap := false
+			newOverlap := 0
 			for _, nPos := range neighbors1[pos] {
 				if nPos < pos {
 					nItem := arr1[nPos]
-					if pairs0Table[item][nItem] {
-						hasOverlap = true
-						break
+					if covered0.Has(pairTable.Index(item, nItem)) {
+						if newOverlap++; overlap+newOverlap > maxOverlap {
+							tooMuchOverlap = true
+							break
+						}
 					}
 				}
 			}
 
-			if !hasOverlap {
+			if !tooMuchOverlap {
+				overlap += newOverlap
 				search(pos + 1)
+				overlap -= newOverlap
 			}
 
 			arr1[pos] = 0
@@ -222,21 +320,124 @@ func searchArr1Worker(shape0, shape1, firstItem int, pairs0Table *[numItems][num
 	}
 
 	search(1) // Start from position 1 since position 0 is fixed
+	log.Debugf("arr1 starting with item %d: %d checked", firstItem, localCount)
 	countChan <- localCount
 }
 
+// showCoverage prints which arrangements of sol cover each pair, with
+// overlap statistics per arrangement, and draws the matrix to the SVG file
+// at path
+// saveSolution writes sol to the arrangement file at path.
+func saveSolution(path string, sol Solution, arr0 [maxItems]int, run *manifest.Run) {
+	shapes := []int{sol.shape0, sol.shape1, sol.shape2}
+	items := [3][maxItems]int{arr0, sol.arr1, sol.arr2}
+	hosts := make([][][2]int, 3)
+	names := make([]string, 3)
+	arrs := make([][]int, 3)
+	for i, shape := range shapes {
+		hosts[i], names[i], arrs[i] = allGraphs[shape], shapeName(shape), items[i][:numItems]
+	}
+	set := arrangement.NewGraphs(numItems, hosts, names, arrs, "solver_k")
+	if err := arrangement.WriteFile(path, set); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Wrote(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Solution written to %s\n", path)
+}
+
+func showCoverage(path string, sol Solution, arr0 [maxItems]int, run *manifest.Run) {
+	shapes := [3]int{sol.shape0, sol.shape1, sol.shape2}
+	items := [3][maxItems]int{arr0, sol.arr1, sol.arr2}
+	arrs := make([]coverage.Arrangement, 3)
+	for i, shape := range shapes {
+		arrs[i] = coverage.Arrangement{
+			Name:  fmt.Sprintf("arr%d (%s)", i, shapeName(shape)),
+			Items: items[i][:numItems],
+			Edges: allGraphs[shape],
+		}
+	}
+	m := coverage.New(numItems, arrs)
+	fmt.Println("\nPair coverage:")
+	m.WriteText(os.Stdout)
+	if err := m.SaveSVG(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run.Wrote(path); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Coverage matrix drawn to %s\n", path)
+}
+
 func main() {
 	workers := flag.Int("w", 13, "number of workers per shape pair")
+	graphsFile := flag.String("graphs", "", "read the shapes from this .g6 file (e.g. from filter_maximal) instead of the built-in n=13 graphs")
+	jsonOut := flag.Bool("json", false, "write progress and results as JSON lines on stdout (text goes to stderr)")
+	timeout := flag.Duration("timeout", 0, "give up after this long and print the summary so far (0: no limit)")
+	maxNodes := flag.Int64("max-nodes", 0, "give up after about this many search nodes (0: no limit)")
+	outPath := flag.String("out", "", "write the solution to this arrangement file (pkg/arrangement)")
+	coveragePath := flag.String("coverage", "", "with a solution, print the pair-coverage matrix and overlap per arrangement, and draw it to this SVG file")
+	configPath := flag.String("config", "", "take flags from this TOML file (flags on the command line win); see pkg/config")
+	profile := flag.String("profile", "", "with -config: also apply this [profile] section of the file")
+	logLevel := flag.String("log-level", "normal", "progress lines to print: quiet, normal, verbose or debug (see pkg/logging)")
+	logFile := flag.String("log-file", "", "also append the progress lines to this file")
 	flag.Parse()
+	settings, err := config.Load(*configPath, *profile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	events := jsonl.Start("solver_k", *jsonOut)
+	logger, err := logging.Start(*logLevel, *logFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+	run := manifest.Start("solver_k", nil)
+	if err := run.Input(*configPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *configPath != "" {
+		fmt.Println(config.Describe(*configPath, *profile, settings))
+	}
+	limits = budget.New(*timeout, *maxNodes)
+
+	if *graphsFile != "" {
+		graphs, err := loadGraphs(*graphsFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := run.Input(*graphsFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		allGraphs = graphs
+	} else {
+		numItems = 13
+	}
+	setup()
+	numShapes := len(allGraphs)
 
 	start := time.Now()
 
 	fmt.Println("============================================")
-	fmt.Println("SOLVER: n=13, testing if 3 arrangements suffice")
+	fmt.Printf("SOLVER: n=%d, testing if 3 arrangements suffice\n", numItems)
 	fmt.Println("============================================")
+	if *graphsFile != "" {
+		fmt.Printf("Shapes: %d graphs from %s\n", numShapes, *graphsFile)
+	}
 	fmt.Printf("Workers: %d\n\n", *workers)
+	events.Emit("start", jsonl.Fields{"n": numItems, "k": 3, "shapes": numShapes, "workers": *workers})
 
-	var identity [numItems]int
+	var identity [maxItems]int
 	for i := 0; i < numItems; i++ {
 		identity[i] = i
 	}
@@ -245,12 +446,21 @@ func main() {
 	resultChan := make(chan Solution, 1)
 
 	// shape0 <= shape1 <= shape2 (symmetry breaking)
-	for shape0 := 0; shape0 < 4 && !found.Load(); shape0++ {
-		pairs0Table := buildPairsTable(shape0, identity[:])
-
-		for shape1 := shape0; shape1 < 4 && !found.Load(); shape1++ {
-			label := string(rune('A'+shape0)) + string(rune('A'+shape1)) + "*"
-			fmt.Printf("Testing %s: ", label)
+	for shape0 := 0; shape0 < numShapes && !found.Load() && !limits.Stopped(); shape0++ {
+		covered0 := coveredBy(shape0, identity[:])
+
+		for shape1 := shape0; shape1 < numShapes && !found.Load() && !limits.Stopped(); shape1++ {
+			label := shapeName(shape0) + shapeName(shape1) + "*"
+			// arr1 may use whatever overlap the roomiest shape2 allows
+			maxOverlap := -1
+			for shape2 := shape1; shape2 < numShapes; shape2++ {
+				maxOverlap = max(maxOverlap, slack(shape0, shape1, shape2))
+			}
+			if maxOverlap < 0 {
+				logger.Printf("Testing %s: too few edges to cover all %d pairs", label, pairTable.Len())
+				events.Emit("shape_pair", jsonl.Fields{"shapes": label, "skipped": true})
+				continue
+			}
 
 			var wg sync.WaitGroup
 			countChan := make(chan int64, numItems)
@@ -265,7 +475,7 @@ func main() {
 				wg.Add(1)
 				go func(fi int) {
 					defer wg.Done()
-					searchArr1Worker(shape0, shape1, fi, &pairs0Table, found, resultChan, countChan)
+					searchArr1Worker(shape0, shape1, fi, covered0, maxOverlap, found, resultChan, countChan, logger.Worker(fi))
 				}(firstItem)
 			}
 
@@ -278,7 +488,13 @@ func main() {
 				totalArr1 += c
 			}
 
-			fmt.Printf("%d arr1 checked (elapsed: %v)\n", totalArr1, time.Since(start))
+			if limits.Stopped() && !found.Load() {
+				logger.Printf("Testing %s: %d arr1 checked, stopped", label, totalArr1)
+				events.Emit("shape_pair", jsonl.Fields{"shapes": label, "max_overlap": maxOverlap, "arr1_checked": totalArr1, "stopped": true})
+				break
+			}
+			logger.Printf("Testing %s: %d arr1 checked", label, totalArr1)
+			events.Emit("shape_pair", jsonl.Fields{"shapes": label, "max_overlap": maxOverlap, "arr1_checked": totalArr1})
 
 			if found.Load() {
 				break
@@ -295,15 +511,37 @@ func main() {
 	if found.Load() {
 		sol := <-resultChan
 		fmt.Println("*** FOUND A SOLUTION! ***")
-		fmt.Printf("Shapes: %c%c%c\n", 'A'+sol.shape0, 'A'+sol.shape1, 'A'+sol.shape2)
-		fmt.Printf("arr0 = %v\n", identity)
-		fmt.Printf("arr1 = %v\n", sol.arr1)
-		fmt.Printf("arr2 = %v\n", sol.arr2)
+		fmt.Printf("Shapes: %s%s%s\n", shapeName(sol.shape0), shapeName(sol.shape1), shapeName(sol.shape2))
+		fmt.Printf("arr0 = %v\n", identity[:numItems])
+		fmt.Printf("arr1 = %v\n", sol.arr1[:numItems])
+		fmt.Printf("arr2 = %v\n", sol.arr2[:numItems])
+		events.Emit("solution", jsonl.Fields{
+			"n":            numItems,
+			"shapes":       shapeName(sol.shape0) + shapeName(sol.shape1) + shapeName(sol.shape2),
+			"arrangements": [][]int{identity[:numItems], sol.arr1[:numItems], sol.arr2[:numItems]},
+		})
+		if *outPath != "" {
+			saveSolution(*outPath, sol, identity, run)
+		}
+		if *coveragePath != "" {
+			showCoverage(*coveragePath, sol, identity, run)
+		}
+	} else if limits.Stopped() {
+		fmt.Printf("Stopped (%v) after %d nodes: no solution found so far.\n", limits.Err(), limits.Nodes())
+		fmt.Println("The search is incomplete, so this proves nothing.")
 	} else {
 		fmt.Println("No solution found.")
-		fmt.Println("3 arrangements are NOT sufficient for n=13.")
-		fmt.Println("CONCLUSION: n=13 requires at least 4 arrangements.")
+		fmt.Printf("3 arrangements are NOT sufficient for n=%d.\n", numItems)
+		fmt.Printf("CONCLUSION: n=%d requires at least 4 arrangements.\n", numItems)
 	}
 
 	fmt.Printf("\nTotal time: %v\n", time.Since(start))
+	var stopped any
+	if err := limits.Err(); err != nil && !found.Load() {
+		stopped = err.Error()
+	}
+	events.Emit("result", jsonl.Fields{"n": numItems, "k": 3, "found": found.Load(), "stopped": stopped, "seconds": time.Since(start).Seconds()})
+	if stopped != nil {
+		os.Exit(budget.ExitStopped)
+	}
 }